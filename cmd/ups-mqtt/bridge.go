@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/alerts"
+	"github.com/sweeney/ups-mqtt/internal/clock"
+	"github.com/sweeney/ups-mqtt/internal/config"
+	"github.com/sweeney/ups-mqtt/internal/nut"
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+	"github.com/sweeney/ups-mqtt/internal/update"
+)
+
+// publishBridge publishes the bridge stats topic when anything populates it:
+// clock skew checking, polls skipped since startup, byte counts, poll
+// timings or telemetry.  varMap is nil after a failed poll.
+func publishBridge(varMap map[string]string, sent, received time.Time, pub publisher.Publisher, cfg *config.Config, st *pollState) error {
+	threshold := cfg.NUT.ClockSkewThreshold.Duration
+	if threshold <= 0 && st.skipped == 0 && st.cycleBytes == nil && st.pollTimings == nil && !cfg.Diagnostics.Telemetry {
+		return nil
+	}
+	stats := publisher.BridgeStats{
+		Timestamp:      received.UTC().Format(time.RFC3339),
+		SkippedPolls:   st.skipped,
+		CycleBytes:     st.cycleBytes,
+		OverByteBudget: st.overBudget,
+		PollTimings:    st.pollTimings,
+	}
+	if cfg.Diagnostics.Telemetry {
+		stats.Telemetry = &publisher.Telemetry{
+			UptimeSecs:          int64(received.Sub(st.started) / time.Second),
+			Polls:               st.pollsMade,
+			FailedPolls:         st.failedPolls,
+			ConsecutiveFailures: st.failures,
+			LastPollMs:          math.Round(st.lastPollTook.Seconds()*1e4) / 10,
+			MQTTReconnects:      st.brokers.reconnectCount(),
+			Version:             buildVersion(),
+		}
+	}
+	if threshold > 0 && varMap != nil {
+		checkClockSkew(&stats, varMap, sent, received, threshold, st)
+	}
+	if err := publisher.PublishBridgeStats(stats, publishConfig(cfg), pub); err != nil {
+		return fmt.Errorf("publishing bridge stats: %w", err)
+	}
+	return nil
+}
+
+// checkClockSkew compares the driver's ups.date/ups.time against the bridge
+// clock and records the result in stats.  The UPS clock is read at some point
+// during the poll round trip, so the comparison is made against its midpoint
+// and only skew beyond the threshold plus half the round trip (and the
+// one-second resolution of ups.time) is flagged.
+func checkClockSkew(stats *publisher.BridgeStats, varMap map[string]string, sent, received time.Time, threshold time.Duration, st *pollState) {
+	upsClock, ok := nut.UPSClock(varMap, time.Local)
+	if !ok {
+		return
+	}
+	rtt := received.Sub(sent)
+	skew := upsClock.Sub(sent.Add(rtt / 2))
+	secs := math.Round(skew.Seconds()*10) / 10
+	stats.ClockSkewSecs = &secs
+	stats.ClockSkewed = skew.Abs() > threshold+rtt/2+time.Second
+	if stats.ClockSkewed != st.clockSkewed {
+		if stats.ClockSkewed {
+			log.Printf("clock skew: UPS clock is %s off the bridge clock (threshold %s)", skew.Round(time.Second), threshold)
+		} else {
+			log.Printf("clock skew back within %s", threshold)
+		}
+	}
+	st.clockSkewed = stats.ClockSkewed
+}
+
+// announceOnline publishes the last poll's state message again once the
+// connection to the broker is back, replacing the offline LWT the broker
+// may have published while the bridge was away, without waiting for the
+// next poll.  The message keeps the poll's timestamp.  With
+// mqtt.republish_on_connect the variables, computed metrics and discovery
+// are published again too.  Nothing is published before the first poll,
+// or while polls are failing, when offline is right.
+func announceOnline(pub publisher.Publisher, cfg *config.Config, st *pollState) error {
+	if st.lastVars == nil || st.reading.CommsLost {
+		return nil
+	}
+	pubCfg := publishConfig(cfg)
+	if cfg.MQTT.RepublishOnConnect {
+		st.discovered = false
+		if err := announceDiscovery(st.lastVars, pub, cfg, st); err != nil {
+			return err
+		}
+		if err := publisher.PublishVariables(st.lastVars, pubCfg, pub); err != nil {
+			return fmt.Errorf("publishing: %w", err)
+		}
+		if err := publisher.PublishMetrics(st.lastMetrics, pubCfg, pub); err != nil {
+			return fmt.Errorf("publishing: %w", err)
+		}
+	}
+	if err := publisher.PublishStateAt(st.lastVars, st.lastMetrics, st.lastPolled, pubCfg, pub); err != nil {
+		return fmt.Errorf("publishing: %w", err)
+	}
+	return nil
+}
+
+// countPoll counts the poll just made, which took took, for the telemetry.
+func countPoll(took time.Duration, pollErr error, st *pollState) {
+	st.pollsMade++
+	st.lastPollTook = took
+	if pollErr != nil {
+		st.failedPolls++
+		st.failures++
+	} else {
+		st.failures = 0
+	}
+}
+
+// publishPollComplete ends a poll cycle, failed or not, with the
+// mqtt.poll_complete marker: its ID, unique to this run of the bridge and
+// the poll, and the messages published since countCycle started the
+// cycle, as st.published counted them after on_change left repeats out.
+func publishPollComplete(pub publisher.Publisher, cfg *config.Config, st *pollState) {
+	var topics int64
+	if st.published != nil {
+		topics = st.published.Messages()
+	}
+	id := fmt.Sprintf("%d-%d", st.started.Unix(), st.pollsMade)
+	if err := publisher.PublishPollComplete(id, topics, st.clock.Now(), publishConfig(cfg), pub); err != nil {
+		log.Printf("publishing poll_complete: %v", err)
+	}
+}
+
+// buildVersion is the version the bridge was built as: the one set with
+// -ldflags "-X main.version=…", else the module version go install
+// recorded, else "devel".
+func buildVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "devel"
+}
+
+// updateChecker looks for a newer release every update_check.interval and
+// hands the latest to every pipeline's poll loop, so a bridge polling
+// several UPSes asks the feed once.  A nil *updateChecker, the check being
+// off, hands out nothing.
+type updateChecker struct {
+	mu     sync.Mutex
+	latest string // "" until the feed has answered
+	subs   []chan string
+}
+
+// subscribe returns a channel receiving the latest release, straight away
+// when it is already known and again after every check.
+func (u *updateChecker) subscribe() <-chan string {
+	if u == nil {
+		return nil
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	ch := make(chan string, 1)
+	if u.latest != "" {
+		ch <- u.latest
+	}
+	u.subs = append(u.subs, ch)
+	return ch
+}
+
+// run checks the feed in cfg now and then every interval on clk until ctx
+// is cancelled.  A failed check is logged and the last answer kept.
+func (u *updateChecker) run(ctx context.Context, client *http.Client, clk clock.Clock, cfg config.UpdateCheckConfig) {
+	ticker := clk.NewTicker(cfg.Interval.Duration)
+	defer ticker.Stop()
+	for {
+		latest, err := update.Latest(ctx, client, cfg.URL, "ups-mqtt/"+buildVersion())
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			log.Printf("update check: %v", err)
+		default:
+			u.set(latest)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+// set records latest and hands it to every subscriber, replacing what one
+// hasn't taken yet.
+func (u *updateChecker) set(latest string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if latest != u.latest && update.Newer(latest, buildVersion()) {
+		log.Printf("update check: %s is out (running %s)", latest, buildVersion())
+	}
+	u.latest = latest
+	for _, ch := range u.subs {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- latest
+	}
+}
+
+// publishUpdate publishes latest on the update topic when it is newer than
+// the running bridge, and clears the topic otherwise.
+func publishUpdate(latest string, pub publisher.Publisher, cfg *config.Config) error {
+	if !update.Newer(latest, buildVersion()) {
+		latest = ""
+	}
+	return publisher.PublishUpdateAvailable(latest, publishConfig(cfg), pub)
+}
+
+// recordTimings logs how long each upsd request of the poll just made
+// took, failed or not, and keeps the breakdown for the bridge stats topic,
+// when diagnostics.poll_timings is on and poller keeps timings.
+func recordTimings(poller nut.Poller, pollErr error, cfg *config.Config, st *pollState) {
+	st.pollTimings = nil
+	timer, ok := poller.(nut.Timer)
+	if !cfg.Diagnostics.PollTimings || !ok {
+		return
+	}
+	t := timer.LastTimings()
+	ms := func(d time.Duration) float64 {
+		return math.Round(d.Seconds()*1e4) / 10
+	}
+	st.pollTimings = &publisher.PollTimings{
+		Connect: ms(t.Connect),
+		Auth:    ms(t.Auth),
+		Status:  ms(t.Status),
+		ListUPS: ms(t.ListUPS),
+		GetVars: ms(t.GetVars),
+		Total:   ms(t.Total),
+	}
+	what := "poll"
+	if pollErr != nil {
+		what = "failed poll"
+	}
+	r := func(d time.Duration) time.Duration { return d.Round(100 * time.Microsecond) }
+	log.Printf("%s timings: connect %s, auth %s, status %s, list ups %s, get vars %s, total %s",
+		what, r(t.Connect), r(t.Auth), r(t.Status), r(t.ListUPS), r(t.GetVars), r(t.Total))
+}
+
+// countCycle takes what was published since the previous poll as the last
+// cycle's, for the bridge topic and the Prometheus textfile, and logs when
+// a cycle goes over mqtt.byte_budget and when one is back within it.
+// Nothing is reported before the first successful poll, whose cycle
+// started part-way through startup.
+func countCycle(cfg *config.Config, st *pollState) {
+	if st.published == nil {
+		return
+	}
+	counts := st.published.Take()
+	st.cycleBytes = nil
+	budget := int64(cfg.MQTT.ByteBudget)
+	if !cfg.MQTT.ByteStats && budget == 0 || st.lastVars == nil {
+		st.overBudget = false
+		return
+	}
+	st.cycleBytes = &counts
+	over := budget > 0 && counts.Bytes > budget
+	if over != st.overBudget {
+		if over {
+			var classes []string
+			for _, class := range publisher.TopicClassNames {
+				classes = append(classes, fmt.Sprintf("%s %d", class, counts.ByClass[class]))
+			}
+			log.Printf("byte budget: a poll cycle published %d bytes, over mqtt.byte_budget of %d (%s)",
+				counts.Bytes, budget, strings.Join(classes, ", "))
+		} else {
+			log.Printf("byte budget: poll cycles back within %d bytes", budget)
+		}
+	}
+	st.overBudget = over
+}
+
+// topicClasses returns how the byte counts sort the topics cfg publishes.
+func topicClasses(cfg *config.Config) publisher.TopicClasses {
+	c := publisher.TopicClasses{Roots: []string{cfg.MQTT.TopicPrefix + "/" + topicLabel(cfg)}}
+	if root := cfg.MirrorRoot(); root != "" {
+		c.Roots = append(c.Roots, root)
+	}
+	for _, ns := range sortedKeys(cfg.MQTT.NamespacePrefixes) {
+		c.VariableRoots = append(c.VariableRoots, cfg.MQTT.NamespacePrefixes[ns])
+	}
+	if cfg.HomeAssistant.Discovery {
+		c.DiscoveryPrefix = cfg.HomeAssistant.DiscoveryPrefix
+	}
+	return c
+}
+
+// doClients publishes the hosts currently attached to the UPS in upsd and
+// evaluates login-count alerts.
+func doClients(lister nut.ClientLister, pub publisher.Publisher, cfg *config.Config, st *pollState) error {
+	clients, err := lister.Clients()
+	if err != nil {
+		return fmt.Errorf("querying NUT clients: %w", err)
+	}
+	now := st.clock.Now()
+	pubCfg := publishConfig(cfg)
+	if err := publisher.PublishClients(clients.Hosts, clients.NumLogins, cfg.NUT.ExpectedClients, now, pubCfg, pub); err != nil {
+		return fmt.Errorf("publishing clients: %w", err)
+	}
+
+	// Older upsd versions don't answer GET NUMLOGINS; every LIST CLIENT
+	// entry is a login, so fall back to counting them.
+	logins := clients.NumLogins
+	if logins < 0 {
+		logins = len(clients.Hosts)
+	}
+	return evaluateAlerts(alerts.Observation{Time: now, NumLogins: &logins}, pub, cfg, st)
+}
+
+// brokerStatus tracks the brokers the bridge is connected to, for the
+// bridge/mqtt_broker topic: with failover the one broker in use, with
+// fanout each connected one.  Connections are numbered by their place in
+// mqtt.brokers.
+type brokerStatus struct {
+	mu        sync.Mutex
+	connected []string
+
+	// seen marks the connections that have been up before, and
+	// reconnects counts the times one came up again.
+	seen       []bool
+	reconnects int64
+}
+
+func newBrokerStatus(brokers []string) *brokerStatus {
+	return &brokerStatus{connected: make([]string, len(brokers)), seen: make([]bool, len(brokers))}
+}
+
+// reconnectCount returns how often a broker connection was re-established
+// after dropping; zero without a broker.
+func (b *brokerStatus) reconnectCount() int64 {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.reconnects
+}
+
+// set records connection i as up, to addr, or down, and returns the
+// connected brokers in mqtt.brokers order, separated by ", ".
+func (b *brokerStatus) set(i int, up bool, addr string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.connected[i] = ""
+	if up {
+		b.connected[i] = addr
+		if b.seen[i] {
+			b.reconnects++
+		}
+		b.seen[i] = true
+	}
+	var list []string
+	for _, a := range b.connected {
+		if a != "" {
+			list = append(list, a)
+		}
+	}
+	return strings.Join(list, ", ")
+}
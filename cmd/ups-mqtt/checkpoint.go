@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/config"
+	"github.com/sweeney/ups-mqtt/internal/metrics"
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+)
+
+// checkpoint is the last successful poll, kept in checkpoint.dir so that a
+// restarted bridge has something to publish before its first poll.
+type checkpoint struct {
+	Timestamp time.Time         `json:"timestamp"`
+	Variables map[string]string `json:"variables"`
+	Computed  metrics.Metrics   `json:"computed"`
+}
+
+// checkpointPath is this UPS's checkpoint file in checkpoint.dir.
+func checkpointPath(cfg *config.Config) string {
+	return filepath.Join(cfg.Checkpoint.Dir, "checkpoint_"+topicLabel(cfg)+".json")
+}
+
+// writeCheckpoint replaces the checkpoint, when checkpoint.dir is set, with
+// the latest successful poll.
+func writeCheckpoint(cfg *config.Config, st *pollState) error {
+	if cfg.Checkpoint.Dir == "" || st.lastVars == nil {
+		return nil
+	}
+	data, err := json.Marshal(checkpoint{
+		Timestamp: st.lastPolled.UTC(),
+		Variables: st.lastVars,
+		Computed:  st.lastMetrics,
+	})
+	if err != nil {
+		return fmt.Errorf("marshalling checkpoint: %w", err)
+	}
+	return writeFileAtomic(checkpointPath(cfg), append(data, '\n'))
+}
+
+// publishCheckpoint publishes the checkpoint a previous run left, when
+// checkpoint.dir is set, as a stale state message with the poll's own
+// timestamp.  Having none is not an error.
+func publishCheckpoint(pub publisher.Publisher, cfg *config.Config) error {
+	if cfg.Checkpoint.Dir == "" {
+		return nil
+	}
+	path := checkpointPath(cfg)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var c checkpoint
+	if err := json.Unmarshal(data, &c); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if c.Variables == nil {
+		return nil
+	}
+	if err := publisher.PublishStaleState(c.Variables, c.Computed, c.Timestamp, publishConfig(cfg), pub); err != nil {
+		return fmt.Errorf("publishing: %w", err)
+	}
+	return nil
+}
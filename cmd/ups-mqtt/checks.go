@@ -0,0 +1,106 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/sweeney/ups-mqtt/internal/config"
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+)
+
+// runSelfTest performs one MQTT loopback check over ps, logs the outcome and
+// publishes it via pub.
+func runSelfTest(ps publisher.PubSub, pub publisher.Publisher, cfg *config.Config) publisher.SelfTestResult {
+	pubCfg := publishConfig(cfg)
+	res := publisher.SelfTest(ps, pubCfg, cfg.MQTT.SelfTestTimeout.Duration)
+	if res.OK {
+		log.Printf("MQTT self-test passed (%.1f ms round trip)", res.LatencyMS)
+	} else {
+		log.Printf("MQTT self-test FAILED: %s", res.Error)
+	}
+	if err := publisher.PublishSelfTestResult(res, pubCfg, pub); err != nil {
+		log.Printf("publishing self-test result: %v", err)
+	}
+	return res
+}
+
+// watchSelfTest subscribes to the self-test command topic so operators can
+// re-run the loopback check on demand.  Each run happens on its own
+// goroutine: the probe is delivered on the same client, so running it inside
+// the message handler would block its own delivery.
+func watchSelfTest(ps publisher.PubSub, pub publisher.Publisher, cfg *config.Config) error {
+	topic := publisher.SelfTestRunTopic(cfg.MQTT.TopicPrefix, topicLabel(cfg))
+	return ps.Subscribe(topic, func(publisher.Message) {
+		go runSelfTest(ps, pub, cfg)
+	})
+}
+
+// runACLCheck probes every broker permission the configuration needs, logs
+// each one that is missing and publishes the report.
+func runACLCheck(ps publisher.PubSub, pub publisher.Publisher, cfg *config.Config) publisher.ACLReport {
+	rep := publisher.CheckACL(ps, aclRoots(cfg), aclSubscribeTopics(cfg), cfg.MQTT.SelfTestTimeout.Duration)
+	for _, c := range rep.Checks {
+		if !c.OK {
+			log.Printf("MQTT ACL: missing %s permission on %s: %s", c.Access, c.Topic, c.Error)
+		}
+	}
+	if rep.OK {
+		log.Printf("MQTT ACL check passed (%d permissions)", len(rep.Checks))
+	}
+	if err := publisher.PublishACLReport(rep, publishConfig(cfg), pub); err != nil {
+		log.Printf("publishing ACL report: %v", err)
+	}
+	return rep
+}
+
+// aclRoots lists the topic roots the bridge publishes under with cfg.
+func aclRoots(cfg *config.Config) []string {
+	roots := []string{cfg.MQTT.TopicPrefix + "/" + topicLabel(cfg)}
+	for _, ns := range sortedKeys(cfg.MQTT.NamespacePrefixes) {
+		roots = append(roots, strings.TrimSuffix(cfg.MQTT.NamespacePrefixes[ns], "/"))
+	}
+	if root := cfg.MirrorRoot(); root != "" {
+		roots = append(roots, root)
+	}
+	if cfg.MQTT.Aggregate {
+		roots = append(roots, publisher.AggregateTopic(cfg.MQTT.TopicPrefix))
+	}
+	if cfg.HomeAssistant.Discovery {
+		roots = append(roots,
+			cfg.HomeAssistant.DiscoveryPrefix+"/sensor",
+			cfg.HomeAssistant.DiscoveryPrefix+"/binary_sensor")
+	}
+	return roots
+}
+
+// aclSubscribeTopics lists the topics the bridge subscribes to with cfg.
+func aclSubscribeTopics(cfg *config.Config) []string {
+	prefix, label := cfg.MQTT.TopicPrefix, topicLabel(cfg)
+	var topics []string
+	if cfg.MQTT.SelfTest {
+		topics = append(topics, publisher.SelfTestProbeTopic(prefix, label), publisher.SelfTestRunTopic(prefix, label))
+	}
+	if cfg.Diagnostics.RawNUT {
+		topics = append(topics, publisher.RawCommandTopic(prefix, label))
+	}
+	if cfg.Commands.Enabled {
+		topics = append(topics, publisher.CommandTopic(prefix, label))
+	}
+	if cfg.Drill.Enabled {
+		topics = append(topics, publisher.DrillTopic(prefix, label))
+	}
+	if cfg.HomeAssistant.Discovery {
+		topics = append(topics, publisher.HAStatusTopic(cfg.HomeAssistant.DiscoveryPrefix))
+	}
+	return topics
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
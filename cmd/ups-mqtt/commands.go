@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/sweeney/ups-mqtt/internal/clock"
+	"github.com/sweeney/ups-mqtt/internal/config"
+	"github.com/sweeney/ups-mqtt/internal/nut"
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+)
+
+// watchRawNUT subscribes to the diagnostics command topic and answers each
+// raw NUT protocol line with upsd's reply.  Commands are handled one at a
+// time on the subscription goroutine and every one is logged.
+func watchRawNUT(ps publisher.PubSub, rc nut.RawCommander, pub publisher.Publisher, cfg *config.Config) error {
+	pubCfg := publishConfig(cfg)
+	topic := publisher.RawCommandTopic(pubCfg.Prefix, pubCfg.UPSName)
+	log.Printf("raw NUT passthrough enabled on %s", topic)
+	return ps.Subscribe(topic, func(msg publisher.Message) {
+		line := strings.TrimSpace(msg.Payload)
+		resp, err := rc.Raw(line)
+		if err != nil {
+			log.Printf("raw NUT command %q: %v", line, err)
+		} else {
+			log.Printf("raw NUT command %q", line)
+		}
+		if err := publisher.PublishRawResponse(resp, err, pubCfg, pub); err != nil {
+			log.Printf("publishing raw NUT response: %v", err)
+		}
+	})
+}
+
+// watchCommands subscribes to the command topic and runs each payload as a
+// NUT instant command, publishing the outcome to cmd/result.  Retained
+// commands are ignored: the broker replays them on every (re)subscribe, so
+// running them would repeat the command after each restart or reconnect.
+// Results are stamped with clk.
+func watchCommands(ps publisher.PubSub, ic nut.InstCommander, pub publisher.Publisher, cfg *config.Config, clk clock.Clock) error {
+	pubCfg := publishConfig(cfg)
+	topic := publisher.CommandTopic(pubCfg.Prefix, pubCfg.UPSName)
+	log.Printf("instant commands enabled on %s", topic)
+	return ps.Subscribe(topic, func(msg publisher.Message) {
+		cmd := strings.TrimSpace(msg.Payload)
+		if msg.Retained {
+			log.Printf("instant command %q: ignored, it was published retained", cmd)
+			return
+		}
+		err := runCommand(ic, cmd, cfg.Commands.Allow)
+		if err != nil {
+			log.Printf("instant command %q: %v", cmd, err)
+		} else {
+			log.Printf("instant command %q", cmd)
+		}
+		if err := publisher.PublishCommandResult(cmd, err, clk.Now(), pubCfg, pub); err != nil {
+			log.Printf("publishing command result: %v", err)
+		}
+	})
+}
+
+// watchHABirth subscribes to Home Assistant's status topic and signals
+// online each time HA announces it has (re)started, so that discovery can
+// be sent again: HA may have lost its entities if the broker lost the
+// retained discovery messages.  The poll loop does the announcing, since
+// it owns the poll state.
+func watchHABirth(ps publisher.PubSub, cfg *config.Config, online chan<- struct{}) error {
+	return ps.Subscribe(publisher.HAStatusTopic(cfg.HomeAssistant.DiscoveryPrefix), func(msg publisher.Message) {
+		if strings.TrimSpace(msg.Payload) != "online" {
+			return
+		}
+		select {
+		case online <- struct{}{}:
+		default: // one is already pending
+		}
+	})
+}
+
+// watchDrill subscribes to the drill topic and passes the poll loop true
+// for "start" and false for "stop", which owns the poll state the drill
+// runs through.  Like commands, retained payloads are ignored.
+func watchDrill(ps publisher.PubSub, cfg *config.Config, drills chan<- bool) error {
+	pubCfg := publishConfig(cfg)
+	topic := publisher.DrillTopic(pubCfg.Prefix, pubCfg.UPSName)
+	log.Printf("outage drills enabled on %s", topic)
+	return ps.Subscribe(topic, func(msg publisher.Message) {
+		cmd := strings.TrimSpace(msg.Payload)
+		if msg.Retained {
+			log.Printf("drill %q: ignored, it was published retained", cmd)
+			return
+		}
+		var start bool
+		switch cmd {
+		case "start":
+			start = true
+		case "stop":
+		default:
+			log.Printf("drill %q: want \"start\" or \"stop\"", cmd)
+			return
+		}
+		select {
+		case drills <- start:
+		default:
+			log.Printf("drill %q: ignored, the last one is still pending", cmd)
+		}
+	})
+}
+
+// runCommand runs cmd if it is a well-formed command name matching one of
+// the allow globs ("*" matches any).  An empty allow runs nothing.
+func runCommand(ic nut.InstCommander, cmd string, allow []string) error {
+	if !nut.ValidCommandName(cmd) {
+		return fmt.Errorf("%w: %q", nut.ErrInvalidCommand, cmd)
+	}
+	if !slices.ContainsFunc(allow, func(glob string) bool {
+		ok, err := path.Match(glob, cmd)
+		return err == nil && ok
+	}) {
+		return fmt.Errorf("%s is not in commands.allow", cmd)
+	}
+	return ic.InstCmd(cmd)
+}
+
+// setLowThresholds writes low_battery.charge and low_battery.runtime, where
+// set, to the UPS's battery.charge.low and battery.runtime.low.  Failures
+// are logged and the bridge carries on with whatever the UPS has.
+func setLowThresholds(vs nut.VarSetter, cfg *config.Config) {
+	lb := cfg.LowBattery
+	var vars [][2]string
+	if lb.Charge > 0 {
+		vars = append(vars, [2]string{"battery.charge.low", strconv.FormatFloat(lb.Charge, 'f', -1, 64)})
+	}
+	if lb.Runtime.Duration > 0 {
+		vars = append(vars, [2]string{"battery.runtime.low", strconv.Itoa(int(lb.Runtime.Seconds()))})
+	}
+	for _, v := range vars {
+		if err := vs.SetVar(v[0], v[1]); err != nil {
+			log.Printf("low_battery: setting %s to %s: %v", v[0], v[1], err)
+			continue
+		}
+		log.Printf("low_battery: set %s to %s", v[0], v[1])
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/alerts"
+	"github.com/sweeney/ups-mqtt/internal/config"
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+)
+
+// drillStatuses is the scripted outage a drill runs: each step after the
+// first moves the pretend ups.status on, giving power_lost, low_battery and
+// power_restored on the events topic and on_battery, low_battery and
+// power_restored to the notifiers — what a real outage that ran the
+// battery down would send.
+var drillStatuses = []string{"OL", "OB DISCHRG", "OB DISCHRG LB", "OL CHRG"}
+
+// drill runs a scripted outage, one step every drill.step, so that
+// automations and notification chains can be rehearsed without pulling
+// the plug.  Only the events topic and the notifiers hear of it, every
+// message marked as a drill; the state and variable topics, the alert
+// engine and the outage record keep following the UPS.
+type drill struct {
+	next int              // index into drillStatuses of the next step
+	due  <-chan time.Time // from the poll state's clock
+}
+
+// C fires when the next step is due; it is nil while no drill runs.
+func (d *drill) C() <-chan time.Time {
+	return d.due
+}
+
+// command starts a drill, or with start unset ends the running one at
+// once with the power_restored step, so nothing is left thinking the
+// power is out.
+func (d *drill) command(start bool, now time.Time, pub publisher.Publisher, cfg *config.Config, st *pollState) error {
+	running := d.due != nil
+	switch {
+	case start && running:
+		log.Printf("drill: already running")
+		return nil
+	case !start && !running:
+		return nil
+	case start:
+		log.Printf("drill: starting a scripted outage, one step every %s", cfg.Drill.Step)
+		d.next = 1
+	default:
+		log.Printf("drill: stopped early")
+		d.next = len(drillStatuses) - 1
+	}
+	return d.step(now, pub, cfg, st)
+}
+
+// step runs the next step of the drill and schedules the one after it on
+// st's clock.  A step cut short by a stop is simply never received.
+func (d *drill) step(now time.Time, pub publisher.Publisher, cfg *config.Config, st *pollState) error {
+	d.due = nil
+	prev, cur := drillStatuses[d.next-1], drillStatuses[d.next]
+	d.next++
+	if d.next < len(drillStatuses) {
+		d.due = st.clock.After(cfg.Drill.Step.Duration)
+	} else {
+		log.Printf("drill: finished")
+	}
+	return publishDrillStep(prev, cur, now, pub, cfg, st)
+}
+
+// publishDrillStep sends what the bridge would for ups.status going from
+// prev to cur, marked as a drill: events, when mqtt.events is on, and
+// notifications, their messages prefixed "DRILL:".
+func publishDrillStep(prev, cur string, now time.Time, pub publisher.Publisher, cfg *config.Config, st *pollState) error {
+	log.Printf("drill: ups.status %q → %q", prev, cur)
+	if cfg.MQTT.Events {
+		for _, ev := range alerts.Transitions(alerts.Reading{Status: prev}, alerts.Reading{Status: cur}) {
+			if err := publisher.PublishDrillEvent(ev, prev, cur, now, publishConfig(cfg), pub); err != nil {
+				return fmt.Errorf("publishing drill event %s: %w", ev, err)
+			}
+		}
+	}
+	for _, ev := range alerts.StatusEvents(prev, cur) {
+		ev.Drill = true
+		if err := sendNotification(ev, now, pub, cfg, st); err != nil {
+			return err
+		}
+	}
+	return nil
+}
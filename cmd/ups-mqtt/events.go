@@ -0,0 +1,305 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/alerts"
+	"github.com/sweeney/ups-mqtt/internal/config"
+	"github.com/sweeney/ups-mqtt/internal/hook"
+	"github.com/sweeney/ups-mqtt/internal/metrics"
+	"github.com/sweeney/ups-mqtt/internal/notify"
+	"github.com/sweeney/ups-mqtt/internal/nut"
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+	"github.com/sweeney/ups-mqtt/internal/wol"
+)
+
+// publishTransitions publishes an events topic entry for each transition
+// from the last reading to cur, when mqtt.events is on, and makes cur the
+// last reading.  The UPS dropping out of upsd's list or returning is also
+// announced; see announceMissing.
+func publishTransitions(cur alerts.Reading, now time.Time, pub publisher.Publisher, cfg *config.Config, st *pollState) error {
+	prev := st.reading
+	st.reading = cur
+	if cur.Missing != prev.Missing {
+		if err := announceMissing(cur.Missing, now, pub, cfg, st); err != nil {
+			return err
+		}
+	}
+	if !cfg.MQTT.Events {
+		return nil
+	}
+	for _, ev := range alerts.Transitions(prev, cur) {
+		if err := publisher.PublishEvent(ev, prev.Status, cur.Status, now, publishConfig(cfg), pub); err != nil {
+			return fmt.Errorf("publishing event %s: %w", ev, err)
+		}
+	}
+	return nil
+}
+
+// announceMissing logs and notifies that upsd has stopped listing the UPS,
+// as ups_missing, or lists it again, as ups_found.  A missing UPS is also
+// marked offline on the state topic, as the LWT would, so its retained
+// data isn't taken as current; the next good poll marks it online again.
+func announceMissing(missing bool, now time.Time, pub publisher.Publisher, cfg *config.Config, st *pollState) error {
+	ev := alerts.Event{Name: alerts.TransitionUPSFound, Severity: alerts.SeverityInfo,
+		Message: fmt.Sprintf("UPS %s is listed by upsd again", cfg.NUT.UPSName)}
+	if missing {
+		ev = alerts.Event{Name: alerts.TransitionUPSMissing, Severity: alerts.SeverityWarning,
+			Message: fmt.Sprintf("UPS %s is no longer listed by upsd (renamed, or its driver stopped)", cfg.NUT.UPSName)}
+		log.Printf("%s — marking it offline and looking for it every poll", ev.Message)
+		offline := publisher.Message{
+			Topic:    publisher.StateTopic(cfg.MQTT.TopicPrefix, topicLabel(cfg)),
+			Payload:  publisher.FormatOffline(),
+			Retained: true,
+		}
+		if err := pub.Publish(offline); err != nil {
+			return fmt.Errorf("marking UPS offline: %w", err)
+		}
+	} else {
+		log.Print(ev.Message)
+	}
+	return sendNotification(ev, now, pub, cfg, st)
+}
+
+// evaluateAlerts feeds obs to the alert engine, logs and publishes every
+// alert that changed state, and notifies about each transition.
+func evaluateAlerts(obs alerts.Observation, pub publisher.Publisher, cfg *config.Config, st *pollState) error {
+	if st.alerts == nil {
+		return nil
+	}
+	changed := st.alerts.Evaluate(obs)
+	for _, a := range changed {
+		if a.Active {
+			log.Printf("alert %s (%s): %s", a.Name, a.Severity, a.Message)
+		} else {
+			log.Printf("alert %s cleared: %s", a.Name, a.Message)
+		}
+	}
+	if err := publisher.PublishAlerts(changed, publishConfig(cfg), pub); err != nil {
+		return fmt.Errorf("publishing alerts: %w", err)
+	}
+	for _, a := range changed {
+		if err := sendNotification(publisher.AlertEvent(a), obs.Time, pub, cfg, st); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newAlertEngine builds the alert engine from cfg.Alerts, or returns nil
+// when no alerts are configured.
+func newAlertEngine(cfg *config.Config) (*alerts.Engine, error) {
+	if len(cfg.Alerts) == 0 {
+		return nil, nil
+	}
+	rules := make([]alerts.Rule, len(cfg.Alerts))
+	for i, a := range cfg.Alerts {
+		sev := alerts.Severity(a.Severity)
+		if sev == "" {
+			sev = alerts.SeverityWarning
+		}
+		rules[i] = alerts.Rule{Name: a.Name, Type: a.Type, Severity: sev, Min: a.Min, Max: a.Max, When: a.When}
+	}
+	e, err := alerts.NewEngine(rules)
+	if err != nil {
+		return nil, err
+	}
+	if e.Uses(alerts.TypeMinLogins) && cfg.NUT.ClientsInterval.Duration <= 0 {
+		log.Printf("warning: %s alerts need nut.clients_interval > 0 and will never fire", alerts.TypeMinLogins)
+	}
+	return e, nil
+}
+
+// sendNotification delivers ev when notifications are enabled: to the
+// notify topic and the configured notifiers, as the routes direct, each in
+// its language.  A drill's messages are prefixed "DRILL:".  During quiet
+// hours only critical events get through.  A failing notifier is logged;
+// only a failed publish to the notify topic is returned.
+func sendNotification(ev alerts.Event, now time.Time, pub publisher.Publisher, cfg *config.Config, st *pollState) error {
+	if !cfg.Notifications.Enabled {
+		return nil
+	}
+	if st.quiet && ev.Severity != alerts.SeverityCritical {
+		log.Printf("notification %s suppressed during quiet hours", ev.Name)
+		return nil
+	}
+	all := []string{notify.MQTT}
+	for name := range st.notifiers {
+		all = append(all, name)
+	}
+	n := notify.Notification{UPS: cfg.NUT.EffectiveLabel(), Event: ev.Name, Severity: ev.Severity, Message: ev.Message, Time: now, Drill: ev.Drill}
+	message := func(lang string) string {
+		msg, err := st.templates.Message(lang, n, st.notifyVars)
+		if err != nil {
+			log.Printf("notification template %s.%s: %v — sending the English message", lang, ev.Name, err)
+		}
+		if ev.Drill {
+			msg = "DRILL: " + msg
+		}
+		return msg
+	}
+	var pubErr error
+	for _, name := range notify.Targets(st.notifyRoutes, all, ev) {
+		if name == notify.MQTT {
+			topicEv := ev
+			topicEv.Message = message(cfg.Notifications.Language)
+			if err := publisher.PublishNotification(topicEv, now, publishConfig(cfg), pub); err != nil {
+				pubErr = fmt.Errorf("publishing notification: %w", err)
+			}
+			continue
+		}
+		out := n
+		out.Message = message(notifierLanguage(cfg, name))
+		if err := st.notifiers[name].Notify(out); err != nil {
+			log.Printf("notifier %s: %s: %v", name, ev.Name, err)
+		}
+	}
+	return pubErr
+}
+
+// withRuntime adds the battery runtime left to an on_battery message, as
+// in "UPS is running on battery, 68 min runtime remaining", when the UPS
+// reports it.
+func withRuntime(ev alerts.Event, m metrics.Metrics) alerts.Event {
+	if ev.Name == "on_battery" && m.Computed("battery_runtime_mins") {
+		ev.Message = fmt.Sprintf("%s, %.0f min runtime remaining", ev.Message, m.BatteryRuntimeMins)
+	}
+	return ev
+}
+
+// runEventHook starts the [hooks] command for event, if one is configured,
+// in the background, with vars and extra in its environment along with
+// UPS_MQTT_EVENT and UPS_MQTT_UPS.  A failing command is logged.
+func runEventHook(event string, vars map[string]string, extra []string, cfg *config.Config, st *pollState) {
+	command := cfg.Hooks.Command(event)
+	if command == "" {
+		return
+	}
+	env := append(hook.Env(vars), "UPS_MQTT_EVENT="+event, "UPS_MQTT_UPS="+cfg.NUT.EffectiveLabel())
+	h := hook.Event{Command: command, Timeout: cfg.Hooks.Timeout.Duration}
+	st.eventHooks.Add(1)
+	go func() {
+		defer st.eventHooks.Done()
+		if err := h.Run(append(env, extra...)); err != nil {
+			log.Printf("hooks.%s: %v", event, err)
+		}
+	}()
+}
+
+// notifierLanguage is the language of the notifier called name.
+func notifierLanguage(cfg *config.Config, name string) string {
+	for _, nc := range cfg.Notifications.Notifiers {
+		if nc.Name == name && nc.Language != "" {
+			return nc.Language
+		}
+	}
+	return cfg.Notifications.Language
+}
+
+// newNotifiers builds the notifiers and routes configured under
+// [notifications].
+func newNotifiers(cfg *config.Config) (map[string]notify.Notifier, []notify.Route) {
+	notifiers := make(map[string]notify.Notifier)
+	for _, nc := range cfg.Notifications.Notifiers {
+		switch nc.Type {
+		case "webhook":
+			notifiers[nc.Name] = &notify.Webhook{URL: nc.URL, Timeout: nc.Timeout.Duration}
+		case "email":
+			notifiers[nc.Name] = &notify.Email{
+				Addr:     nc.SMTPHost,
+				Username: nc.Username,
+				Password: nc.Password,
+				From:     nc.From,
+				To:       nc.To,
+			}
+		case "telegram":
+			notifiers[nc.Name] = &notify.Telegram{Token: nc.Token, ChatID: nc.ChatID, Timeout: nc.Timeout.Duration}
+		case "pushover":
+			notifiers[nc.Name] = &notify.Pushover{Token: nc.Token, UserKey: nc.UserKey, Timeout: nc.Timeout.Duration}
+		case "slack":
+			notifiers[nc.Name] = &notify.Slack{URL: nc.URL, Timeout: nc.Timeout.Duration}
+		}
+	}
+	routes := make([]notify.Route, len(cfg.Notifications.Routes))
+	for i, r := range cfg.Notifications.Routes {
+		routes[i] = notify.Route{Events: r.Events, MinSeverity: alerts.Severity(r.MinSeverity), Notifiers: r.Notifiers}
+	}
+	return notifiers, routes
+}
+
+// updateQuietHours tracks entering and leaving quiet hours and, when
+// mute_beeper is set, disables the UPS beeper for their duration.  A UPS
+// that rejects the command only costs a log line.
+func updateQuietHours(poller nut.Poller, now time.Time, cfg *config.Config, st *pollState) {
+	// Quiet hours removed by a reload end like any other.
+	quiet := st.quietHours != nil && st.quietHours.Contains(now)
+	if quiet == st.quiet {
+		return
+	}
+	st.quiet = quiet
+	cmd := "beeper.enable"
+	if quiet {
+		cmd = "beeper.disable"
+		log.Printf("quiet hours started: holding back non-critical notifications")
+	} else {
+		log.Printf("quiet hours ended")
+	}
+	if !cfg.Notifications.MuteBeeper {
+		return
+	}
+	ic, ok := poller.(nut.InstCommander)
+	if !ok {
+		return
+	}
+	if err := ic.InstCmd(cmd); err != nil {
+		log.Printf("%s: %v", cmd, err)
+	}
+}
+
+// wakeOnLAN sends the configured Wake-on-LAN packets once mains have stayed
+// up for wake_on_lan.settle after an outage that reached low battery or FSD
+// — the point at which upsmon shuts hosts down — or after any outage when
+// wake_on_lan.always is set.  onBattery is whether the outage is still on
+// (see outageOngoing); going back on battery restarts the wait.
+func wakeOnLAN(status string, onBattery bool, now time.Time, cfg *config.Config, st *pollState) {
+	w := cfg.WakeOnLAN
+	if len(w.Targets) == 0 {
+		return
+	}
+	flags := strings.Fields(status)
+	if slices.Contains(flags, "LB") || slices.Contains(flags, "FSD") || (w.Always && onBattery) {
+		st.wakePending = true
+	}
+	if onBattery {
+		st.wakeAt = nil
+		return
+	}
+	if !st.wakePending {
+		return
+	}
+	if st.wakeAt == nil {
+		at := now.Add(w.Settle.Duration)
+		st.wakeAt = &at
+		log.Printf("wake-on-lan: mains restored; waking %d host(s) if they stay up for %s", len(w.Targets), w.Settle)
+	}
+	if now.Before(*st.wakeAt) {
+		return
+	}
+	st.wakePending, st.wakeAt = false, nil
+
+	send := st.wake
+	if send == nil {
+		send = wol.Send
+	}
+	for _, mac := range w.Targets {
+		if err := send(w.Broadcast, mac); err != nil {
+			log.Printf("wake-on-lan: %s: %v", mac, err)
+		} else {
+			log.Printf("wake-on-lan: sent magic packet to %s via %s", mac, w.Broadcast)
+		}
+	}
+}
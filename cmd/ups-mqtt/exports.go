@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/config"
+	"github.com/sweeney/ups-mqtt/internal/grafana"
+	"github.com/sweeney/ups-mqtt/internal/plugin"
+	"github.com/sweeney/ups-mqtt/internal/prom"
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+)
+
+// pushGrafana pushes the latest poll to Grafana Live when it is configured.
+func pushGrafana(ctx context.Context, client *http.Client, cfg *config.Config, st *pollState) error {
+	if cfg.Grafana.URL == "" || st.lastVars == nil {
+		return nil
+	}
+	body := grafana.Format(cfg.NUT.EffectiveLabel(), cfg.Labels, st.lastVars, st.lastMetrics, st.clock.Now())
+	return grafana.Push(ctx, client, cfg.Grafana.URL, cfg.Grafana.Token, cfg.Grafana.StreamID, body)
+}
+
+// pollSnapshot is the JSON written to diagnostics.snapshot_file.
+type pollSnapshot struct {
+	Timestamp string            `json:"timestamp"`
+	UPSName   string            `json:"ups_name"`
+	Variables map[string]string `json:"variables"`
+}
+
+// writeSnapshot replaces diagnostics.snapshot_file, when configured, with
+// the variables of the latest successful poll.
+func writeSnapshot(cfg *config.Config, st *pollState) error {
+	if cfg.Diagnostics.SnapshotFile == "" || st.lastVars == nil {
+		return nil
+	}
+	data, err := json.MarshalIndent(pollSnapshot{
+		Timestamp: st.clock.Now().UTC().Format(time.RFC3339),
+		UPSName:   cfg.NUT.EffectiveLabel(),
+		Variables: st.lastVars,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshalling snapshot: %w", err)
+	}
+	return writeFileAtomic(cfg.Diagnostics.SnapshotFile, append(data, '\n'))
+}
+
+// writeTextfile replaces this bridge's .prom file in
+// prometheus.textfile_dir, when configured, with the latest poll in the
+// format of node_exporter's textfile collector.
+func writeTextfile(cfg *config.Config, st *pollState) error {
+	if cfg.Prometheus.TextfileDir == "" || st.lastVars == nil {
+		return nil
+	}
+	label := cfg.NUT.EffectiveLabel()
+	body := prom.Format(label, cfg.Labels, st.lastVars, st.lastMetrics)
+	if c := st.cycleBytes; c != nil {
+		body += prom.FormatPublished(label, cfg.Labels, c.ByClass, c.Messages)
+	}
+	return writeFileAtomic(filepath.Join(cfg.Prometheus.TextfileDir, prom.TextfileName(label)), []byte(body))
+}
+
+// writeFileAtomic replaces path with data via a temporary file in the same
+// directory and a rename, so readers — and a crash or SIGQUIT part-way
+// through — only ever leave the old or the new content, never a mix.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp) //nolint:errcheck // fails harmlessly once renamed
+
+	if _, err := f.Write(data); err != nil {
+		f.Close() //nolint:errcheck
+		return err
+	}
+	if err := f.Chmod(0o644); err != nil {
+		f.Close() //nolint:errcheck
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// newSinks wraps mqtt in a Router over the [[sinks]] in cfg, or returns it
+// unchanged when none are configured.  Without an mqtt entry the broker
+// still receives every message, so adding a file or HTTP sink never takes
+// anything away from MQTT subscribers.  The sinks other than mqtt drop
+// their messages while paused is set; see low_power.
+func newSinks(cfg *config.Config, mqtt publisher.Publisher, paused *atomic.Bool) (publisher.Publisher, error) {
+	if len(cfg.Sinks) == 0 {
+		return mqtt, nil
+	}
+	var routes []publisher.Route
+	hasMQTT := false
+	for _, sc := range cfg.Sinks {
+		name := sc.Name
+		if name == "" {
+			name = sc.Type
+		}
+		if sc.Type == "mqtt" {
+			hasMQTT = true
+		}
+		if sc.Disabled {
+			continue
+		}
+		var sink publisher.Sink
+		switch sc.Type {
+		case "mqtt":
+			sink = mqtt
+		case "file":
+			fs, err := publisher.NewFileSink(sc.Path)
+			if err != nil {
+				return nil, fmt.Errorf("sink %q: %w", name, err)
+			}
+			sink = fs
+		case "http":
+			sink = &publisher.HTTPSink{URL: sc.URL, Timeout: sc.Timeout.Duration}
+		case "plugin":
+			sink = &publisher.PluginSink{Process: &plugin.Process{
+				Command: sc.Command,
+				Args:    sc.Args,
+				Timeout: sc.Timeout.Duration,
+			}}
+		case "kafka":
+			ks, err := publisher.NewKafkaSink(publisher.KafkaOptions{
+				Brokers:       sc.Brokers,
+				Topic:         sc.KafkaTopic,
+				ClientID:      cfg.MQTT.ClientID,
+				Timeout:       sc.Timeout.Duration,
+				TLS:           sc.TLS,
+				TLSCACert:     sc.TLSCACert,
+				TLSInsecure:   sc.TLSInsecure,
+				TLSServerName: sc.TLSServerName,
+				SASLMechanism: sc.SASLMechanism,
+				Username:      sc.Username,
+				Password:      sc.Password,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("sink %q: %w", name, err)
+			}
+			sink = ks
+		}
+		if sc.Type != "mqtt" {
+			sink = &publisher.PausableSink{Sink: sink, Paused: paused}
+		}
+		routes = append(routes, publisher.Route{Name: name, Sink: sink, Topics: sc.Topics, Exclude: sc.Exclude})
+	}
+	if !hasMQTT {
+		routes = append([]publisher.Route{{Name: "mqtt", Sink: mqtt}}, routes...)
+	}
+	return publisher.NewRouter(routes...), nil
+}
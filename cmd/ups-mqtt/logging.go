@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// logStampLen is the length of the "2006/01/02 15:04:05 " prefix the log
+// package's standard flags put before every message.
+const logStampLen = len("2006/01/02 15:04:05 ")
+
+// repeatWriter collapses runs of identical log messages, for
+// logging.suppress_repeats: the first of a run is written, and when a
+// different message ends it, how many times it was repeated.  The
+// timestamp is left out of the comparison.  The log package serialises
+// writes, so repeatWriter needs no lock of its own.
+type repeatWriter struct {
+	w       io.Writer
+	last    string // the previous message, without its timestamp
+	stamp   string // the timestamp of its latest repeat
+	repeats int
+}
+
+func newRepeatWriter(w io.Writer) *repeatWriter {
+	return &repeatWriter{w: w}
+}
+
+func (r *repeatWriter) Write(p []byte) (int, error) {
+	stamp, msg := "", string(p)
+	if len(msg) > logStampLen {
+		stamp, msg = msg[:logStampLen], msg[logStampLen:]
+	}
+	if msg == r.last {
+		r.stamp = stamp
+		r.repeats++
+		return len(p), nil
+	}
+	if r.repeats > 0 {
+		if _, err := fmt.Fprintf(r.w, "%slast message repeated %d times\n", r.stamp, r.repeats); err != nil {
+			return 0, err
+		}
+		r.repeats = 0
+	}
+	r.last = msg
+	if _, err := r.w.Write(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
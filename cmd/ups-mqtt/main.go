@@ -2,67 +2,526 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
+	"os"
 	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/sweeney/ups-mqtt/internal/clock"
 	"github.com/sweeney/ups-mqtt/internal/config"
 	"github.com/sweeney/ups-mqtt/internal/metrics"
 	"github.com/sweeney/ups-mqtt/internal/nut"
+	"github.com/sweeney/ups-mqtt/internal/prom"
 	"github.com/sweeney/ups-mqtt/internal/publisher"
+	"github.com/sweeney/ups-mqtt/internal/schedule"
 )
 
+// version is the bridge's version, set at build time with
+// -ldflags "-X main.version=…"; see buildVersion.
+var version string
+
 func main() {
 	configPath := flag.String("config", "/etc/ups-mqtt/config.toml", "path to config file")
+	once := flag.Bool("once", false, "poll once, publish, push to the Pushgateway if configured, and exit")
+	dryRun := flag.Bool("dry-run", false, "print topics and payloads to stdout instead of connecting to the MQTT broker")
+	dryRunJSON := flag.Bool("dry-run-json", false, "like -dry-run, printing JSON lines in the file sink format")
+	preset := flag.String("preset", "", "tune the defaults for the host, e.g. \"rpi\"; overrides the preset in the config")
+	record := flag.String("record", "", "append every poll's variables to this file as JSON lines, or CSV if it ends in .csv; overrides diagnostics.record_file")
+	replayPath := flag.String("replay", "", "publish the polls recorded by -record in this file instead of polling NUT, then exit")
+	replaySpeed := flag.Float64("replay-speed", 1, "with -replay, how many times faster than recorded to play the polls; 0 = without waiting")
 	flag.Parse()
+	// Passed on through the environment, the preset and record file also
+	// apply to the config reloaded on SIGHUP.
+	if *preset != "" {
+		os.Setenv("UPS_MQTT_PRESET", *preset) //nolint:errcheck
+	}
+	if *record != "" {
+		os.Setenv("UPS_MQTT_DIAGNOSTICS_RECORD_FILE", *record) //nolint:errcheck
+	}
+
+	// setup and init-config write the config, so they must not need one
+	// that loads.
+	if args := flag.Args(); len(args) > 0 && args[0] == "setup" {
+		if err := setupMain(*configPath, args[1:]); err != nil {
+			log.Fatalf("setup: %v", err)
+		}
+		return
+	}
+	if args := flag.Args(); len(args) > 0 && args[0] == "init-config" {
+		if err := initConfigMain(args[1:]); err != nil {
+			log.Fatalf("init-config: %v", err)
+		}
+		return
+	}
 
 	cfg, err := config.Load(*configPath, "./config.toml")
 	if err != nil {
 		log.Fatalf("loading config: %v", err)
 	}
+	if cfg.Logging.SuppressRepeats {
+		log.SetOutput(newRepeatWriter(os.Stderr))
+	}
+	for _, host := range cfg.NUT.Servers() {
+		if _, err := nut.ParseEndpoints(host, cfg.NUT.Port); err != nil {
+			log.Fatalf("nut.hosts: %v", err)
+		}
+	}
 
-	log.Printf("ups-mqtt starting (NUT: %s:%d, UPS: %s, label: %s, MQTT: %s)",
-		cfg.NUT.Host, cfg.NUT.Port, cfg.NUT.UPSName, cfg.NUT.EffectiveLabel(), cfg.MQTT.Broker)
+	if args := flag.Args(); len(args) > 0 && args[0] == "history" {
+		if err := historyMain(cfg, args[1:]); err != nil {
+			log.Fatalf("history: %v", err)
+		}
+		return
+	}
+	if args := flag.Args(); len(args) > 0 {
+		if err := transferMain(cfg, args); err != nil {
+			log.Fatalf("%s: %v", args[0], err)
+		}
+		return
+	}
 
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
 	defer cancel()
+	reloadPaths := []string{*configPath, "./config.toml"}
+	// Catch SIGHUP before anything starts: its default action kills the
+	// process, so one arriving while MQTT and NUT connect must not be lost
+	// to a handler that isn't registered yet.  It is acted on once the
+	// pipelines are running.
+	var hup chan os.Signal
+	if !*once {
+		hup = make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+	}
+
+	// Each UPS gets its own pipeline: MQTT connection (and so LWT) and poll
+	// loop.  They share a pool of at most nut.max_connections upsd
+	// connections, so they poll in parallel without a socket each.  With
+	// nut.ups_name = "*" the UPSes are those upsd serves.
+	var dry publisher.Publisher
+	if *dryRun || *dryRunJSON {
+		dry = publisher.NewPrintPublisher(os.Stdout, *dryRunJSON)
+	}
+	if *replayPath != "" {
+		if *replaySpeed < 0 {
+			log.Fatalf("-replay-speed must not be negative, got %v", *replaySpeed)
+		}
+		if err := replayMain(ctx, cfg, *replayPath, *replaySpeed, dry); err != nil {
+			log.Fatalf("replay: %v", err)
+		}
+		return
+	}
+	cfgs := cfg.PerUPS()
+	var pool *nut.Pool
+	if len(cfgs) > 1 || cfg.NUT.Discover() {
+		pool = nut.NewPool(cfg.NUT.Servers(), cfg.NUT.Port, cfg.NUT.Username, cfg.NUT.Password, cfg.NUT.MaxConnections)
+		defer pool.Close() //nolint:errcheck
+	}
+	if cfg.NUT.Discover() {
+		if cfgs, err = discoverUPS(ctx, cfg, pool, *once); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Fatalf("listing the UPSes on upsd: %v", err)
+		}
+	}
+	// Under systemd, readiness and the watchdog are reported for all the
+	// pipelines together.
+	var sd *systemd
+	if !*once {
+		sd = newSystemd(len(cfgs))
+		sd.checkWatchdog(cfg)
+	}
+	p := &pipelines{ctx: ctx, cancel: cancel, pool: pool, once: *once, dry: dry, sd: sd, clock: clock.Real}
+	// The pool is there when more than one UPS may be polled.
+	if pool != nil && !*once {
+		p.agg = newAggregator()
+	}
+	if cfg.UpdateCheck.Enabled && !*once {
+		p.update = &updateChecker{}
+		go p.update.run(ctx, http.DefaultClient, p.clock, cfg.UpdateCheck)
+	}
+	for _, c := range cfgs {
+		p.start(c)
+	}
+	if !*once {
+		go reloadOnSIGHUP(ctx, cfg, reloadPaths, hup, p.reload)
+		if cfg.NUT.Discover() {
+			go p.watch(cfg)
+		}
+	}
+	if err := p.wait(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run connects to MQTT and NUT for the UPS in cfg and polls it until ctx is
+// cancelled, or polls it once when once is set.  pool, when not nil,
+// supplies the NUT connection.  Configs received on reload replace the
+// settings that can change while running; see reloadConfig.  dry, when not
+// nil, takes the place of the MQTT connection for a dry run: the broker is
+// never contacted, so nothing that subscribes to it is set up.  sd, when
+// not nil, is told when the connections are up and about each successful
+// poll, as pipeline number pipeline.  The poll loop ticks on, and stamps
+// what it publishes with, clk.
+func run(ctx context.Context, cfg *config.Config, pool *nut.Pool, reload <-chan *config.Config, once bool, dry publisher.Publisher, sd *systemd, agg *aggregator, updates <-chan string, clk clock.Clock, pipeline int) error {
+	// With broker_mode "fanout" the first broker is the main connection,
+	// used for subscriptions, and the rest are connected alongside it.
+	brokers, primary := cfg.MQTT.BrokerList(), cfg.MQTT
+	sep := " then "
+	if cfg.MQTT.BrokerMode == "fanout" {
+		primary.Brokers, sep = brokers[:1], " and "
+	}
+	log.Printf("ups-mqtt %s starting (NUT: %s, default port %d, UPS: %s, label: %s, MQTT: %s)",
+		buildVersion(), strings.Join(cfg.NUT.Servers(), " then "), cfg.NUT.Port, cfg.NUT.UPSName, cfg.NUT.EffectiveLabel(), strings.Join(brokers, sep))
+	if cfg.MQTT.TLSInsecure {
+		log.Printf("warning: mqtt.tls_insecure is set — the broker's TLS certificate is not verified")
+	}
 
 	// Connect to MQTT broker first so LWT is registered before we talk to NUT.
-	lwtTopic := publisher.StateTopic(cfg.MQTT.TopicPrefix, cfg.NUT.EffectiveLabel())
+	lwtTopic := publisher.StateTopic(cfg.MQTT.TopicPrefix, topicLabel(cfg))
 	lwtPayload := publisher.FormatOffline()
 
-	pub, err := publisher.NewMQTTPublisher(cfg.MQTT, lwtTopic, lwtPayload)
-	if err != nil {
-		log.Fatalf("connecting to MQTT broker: %v", err)
+	// Connection events go to the audit log, when enabled; those before
+	// the broker is reachable are published along with the connection.
+	var audit *publisher.AuditLog
+	if n := cfg.Diagnostics.AuditLog; n > 0 {
+		audit = publisher.NewAuditLog(n)
+	}
+	var mqttPub *publisher.MQTTPublisher
+	var err error
+	pub := dry
+	if dry != nil {
+		log.Printf("dry run: printing messages instead of publishing them to the broker")
+	} else {
+		mqttPub, err = connectMQTT(ctx, primary, lwtTopic, lwtPayload, once, func(event string, err error) {
+			if audit != nil {
+				audit.Record("mqtt", event, strings.Join(primary.BrokerList(), ", "), err, clk.Now())
+			}
+		})
+		if errors.Is(err, context.Canceled) {
+			log.Printf("MQTT connection interrupted: %v", err)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("connecting to MQTT broker: %w", err)
+		}
+		pub = mqttPub
+	}
+	var fanout *publisher.FanoutPublisher
+	if mqttPub != nil && len(primary.BrokerList()) < len(brokers) {
+		fanout = publisher.NewFanoutPublisher(mqttPub)
+		pub = fanout
+	}
+	// While no broker is reachable, messages wait in the buffer; it sits
+	// below on_change and the byte counter, which treat them as published.
+	var buffer *publisher.BufferPublisher
+	if mqttPub != nil && !once && cfg.MQTT.BufferSize > 0 {
+		if buffer, err = publisher.NewBufferPublisher(pub, cfg.MQTT.BufferSize, cfg.MQTT.BufferFile); err != nil {
+			return fmt.Errorf("mqtt buffer: %w", err)
+		}
+		pub = buffer
+	}
+	// Counted here, the bytes are those the broker receives: after
+	// on_change has left out repeats, and with the migration mirror's copies.
+	published := publisher.NewByteCounter(pub)
+	pub = published
+	var onChange *publisher.OnChangePublisher
+	if cfg.MQTT.PublishMode == "on_change" {
+		onChange = publisher.NewOnChangePublisher(pub)
+		pub = onChange
+	}
+	if root := cfg.MirrorRoot(); root != "" {
+		from := cfg.MQTT.TopicPrefix + "/" + topicLabel(cfg)
+		log.Printf("migration: mirroring %s/… to %s/…", from, root)
+		pub = publisher.NewMirrorPublisher(pub, from, root)
+	}
+	var sinksPaused atomic.Bool
+	if pub, err = newSinks(cfg, pub, &sinksPaused); err != nil {
+		return fmt.Errorf("configuring sinks: %w", err)
+	}
+
+	// A reconnect sends what was buffered while no broker was reachable,
+	// and republishes everything in on_change mode, since the broker may
+	// have lost its retained messages in the meantime.  Every connection,
+	// including a failover, publishes the brokers now in use.
+	active := newBrokerStatus(brokers)
+	// The poll loop announces the bridge online again on every reconnect.
+	reconnected := make(chan struct{}, 1)
+	onMQTTConn := func(i int, p *publisher.MQTTPublisher, event string, err error) {
+		addr := brokers[i]
+		if p != nil {
+			addr = p.Broker()
+		}
+		if onChange != nil && event == publisher.ConnConnected {
+			onChange.Reset()
+		}
+		if event == publisher.ConnConnected || event == publisher.ConnLost {
+			connected := active.set(i, event == publisher.ConnConnected, addr)
+			if buffer != nil {
+				if err := buffer.SetOnline(connected != ""); err != nil {
+					log.Printf("mqtt buffer: %v", err)
+				}
+			}
+			if event == publisher.ConnConnected || fanout != nil {
+				if err := publisher.PublishMQTTBroker(connected, publishConfig(cfg), pub); err != nil {
+					log.Printf("publishing MQTT broker: %v", err)
+				}
+			}
+		}
+		if audit != nil {
+			recordConn(audit, "mqtt", event, addr, err, clk.Now(), pub, cfg)
+		}
+		if event == publisher.ConnConnected {
+			select {
+			case reconnected <- struct{}{}:
+			default:
+			}
+		}
+	}
+	if mqttPub != nil {
+		onMQTTConn(0, mqttPub, publisher.ConnConnected, nil)
+		mqttPub.OnConnChange(func(event string, err error) { onMQTTConn(0, mqttPub, event, err) })
+	}
+	if fanout != nil {
+		connectFanout(ctx, cfg.MQTT, lwtTopic, lwtPayload, once, fanout, onMQTTConn)
+	}
+
+	if once {
+		err := onceMain(ctx, pub, cfg, pool)
+		pub.Close() //nolint:errcheck
+		if err != nil {
+			return fmt.Errorf("--once: %w", err)
+		}
+		return nil
 	}
 	defer pub.Close() //nolint:errcheck
 
+	if cfg.MQTT.ACLCheck && mqttPub != nil {
+		runACLCheck(mqttPub, pub, cfg)
+	}
+	if cfg.MQTT.SelfTest && mqttPub != nil {
+		runSelfTest(mqttPub, pub, cfg)
+		if err := watchSelfTest(mqttPub, pub, cfg); err != nil {
+			log.Printf("subscribing to self-test command topic: %v", err)
+		}
+	}
+
+	// Reaching upsd can take a while during an outage; until then the last
+	// run's reading is better than the offline LWT.
+	if err := publishCheckpoint(pub, cfg); err != nil {
+		log.Printf("checkpoint: %v", err)
+	}
+
 	// Connect to NUT with exponential backoff, interruptible by signal.
-	nutClient, err := connectNUT(ctx, cfg.NUT)
+	// Every connection, including a failover or failback, publishes the
+	// server now in use.
+	onNUTConn := func(event, addr string, err error) {
+		if event == nut.ConnConnected {
+			if err := publisher.PublishNUTServer(addr, publishConfig(cfg), pub); err != nil {
+				log.Printf("publishing NUT server: %v", err)
+			}
+		}
+		if audit != nil {
+			recordConn(audit, "nut", event, addr, err, clk.Now(), pub, cfg)
+		}
+	}
+	nutClient, err := connectNUT(ctx, cfg.NUT, pool, onNUTConn)
 	if err != nil {
 		log.Printf("NUT connection interrupted: %v", err)
-		return
+		return nil
 	}
 	defer nutClient.Close() //nolint:errcheck
-	log.Printf("connected to NUT at %s:%d", cfg.NUT.Host, cfg.NUT.Port)
+	log.Printf("connected to NUT at %s", nutClient.Addr())
+	nutClient.SetVariables(cfg.NUT.PollVars)
+	sd.connected()
+	setLowThresholds(nutClient, cfg)
+
+	if cfg.Diagnostics.RawNUT && mqttPub != nil {
+		if err := watchRawNUT(mqttPub, nutClient, pub, cfg); err != nil {
+			log.Printf("subscribing to raw NUT command topic: %v", err)
+		}
+	}
+	if cfg.Commands.Enabled && mqttPub != nil {
+		if err := watchCommands(mqttPub, nutClient, pub, cfg, clk); err != nil {
+			log.Printf("subscribing to command topic: %v", err)
+		}
+	}
+	// A nil channel never fires, which keeps re-announcing disabled.
+	var haOnline chan struct{}
+	if cfg.HomeAssistant.Discovery && mqttPub != nil {
+		haOnline = make(chan struct{}, 1)
+		if err := watchHABirth(mqttPub, cfg, haOnline); err != nil {
+			log.Printf("subscribing to Home Assistant status topic: %v", err)
+		}
+	}
+	var drills chan bool
+	if cfg.Drill.Enabled && mqttPub != nil {
+		drills = make(chan bool, 1)
+		if err := watchDrill(mqttPub, cfg, drills); err != nil {
+			log.Printf("subscribing to drill topic: %v", err)
+		}
+	}
+	var running drill
 
-	// Main poll loop.
-	ticker := time.NewTicker(cfg.NUT.PollInterval.Duration)
-	defer ticker.Stop()
+	// Main poll loop.  Low-power mode polls on its own interval, so the
+	// ticker is replaced whenever the mode changes.
+	tickC, stopTicker := newPollTicker(clk, cfg.NUT)
+	defer func() { stopTicker() }()
 
-	log.Printf("polling every %s", cfg.NUT.PollInterval)
+	if cfg.NUT.AlignPolls {
+		log.Printf("polling every %s, aligned to the clock", cfg.NUT.PollInterval)
+	} else {
+		log.Printf("polling every %s", cfg.NUT.PollInterval)
+	}
 
-	var outageStart *time.Time
+	st := newPollStateAt(clk)
+	st.sinksPaused = &sinksPaused
+	st.published = published
+	st.brokers = active
+	defer st.close()
+	if err := st.configure(nil, cfg); err != nil {
+		return err
+	}
+	ticking := false
 
+	// Attached-client reporting runs on its own, usually slower, schedule.
+	// A nil channel never fires, which keeps it disabled.
+	var clientsC <-chan time.Time
+	if cfg.NUT.ClientsInterval.Duration > 0 {
+		clientsTicker := clk.NewTicker(cfg.NUT.ClientsInterval.Duration)
+		defer clientsTicker.Stop()
+		clientsC = clientsTicker.C()
+		if err := doClients(nutClient, pub, cfg, st); err != nil {
+			log.Printf("clients error: %v", err)
+		}
+	}
+
+	// Polling uses live, which reloads replace; callbacks registered above
+	// keep cfg.
+	live := cfg
 loop:
 	for {
 		select {
-		case <-ticker.C:
-			if err := doPoll(nutClient, pub, cfg, &outageStart); err != nil {
-				log.Printf("poll error: %v", err)
+		case t := <-tickC:
+			skipped := st.skipped
+			interval := pollSchedule(live, st.lowPower).PollInterval
+			due := st.takeTick(t, clk.Now(), interval.Duration)
+			if st.skipped > skipped {
+				log.Printf("poll overran the %s interval; %d cycle(s) skipped since startup", interval, st.skipped)
+			}
+			if !due {
+				continue loop
+			}
+			err := doPoll(nutClient, pub, live, st)
+			if st.lowPower != ticking {
+				ticking = st.lowPower
+				stopTicker()
+				tickC, stopTicker = newPollTicker(clk, pollSchedule(live, ticking))
+			}
+			var polled *metrics.Metrics
+			if err == nil {
+				polled = &st.lastMetrics
+			}
+			if err := agg.report(polled, clk.Now(), pub, live); err != nil {
+				log.Printf("publishing aggregate: %v", err)
+			}
+			if err != nil {
+				// A missing UPS is logged when it goes and when it returns.
+				if !errors.Is(err, nut.ErrUPSNotFound) {
+					log.Printf("poll error: %v", err)
+				}
+				continue loop
+			}
+			sd.polledOK(pipeline)
+			// The checkpoint matters most during an outage, so it is kept
+			// in low-power mode too.
+			if err := writeCheckpoint(live, st); err != nil {
+				log.Printf("checkpoint: %v", err)
+			}
+			// In low-power mode the exports wait for mains to return.
+			if st.lowPower {
+				continue loop
+			}
+			if err := pushGrafana(ctx, http.DefaultClient, live, st); err != nil {
+				log.Printf("grafana live: %v", err)
+			}
+			if err := writeSnapshot(live, st); err != nil {
+				log.Printf("snapshot file: %v", err)
+			}
+			if err := writeTextfile(live, st); err != nil {
+				log.Printf("prometheus textfile: %v", err)
+			}
+		case <-clientsC:
+			if st.lowPower {
+				continue loop
+			}
+			if err := doClients(nutClient, pub, live, st); err != nil {
+				log.Printf("clients error: %v", err)
+			}
+		case <-haOnline:
+			// Home Assistant restarted.  on_change mode would hold back
+			// discovery messages the broker already has, so it forgets
+			// everything; the state follows with the next poll.
+			st.discovered = false
+			if onChange != nil {
+				onChange.Reset()
+			}
+			if st.lastVars == nil {
+				continue loop
+			}
+			log.Printf("Home Assistant came online; announcing discovery again")
+			if err := announceDiscovery(st.lastVars, pub, live, st); err != nil {
+				log.Printf("Home Assistant: %v", err)
+			}
+		case <-reconnected:
+			if err := announceOnline(pub, live, st); err != nil {
+				log.Printf("announcing online: %v", err)
+			}
+		case latest := <-updates:
+			if err := publishUpdate(latest, pub, live); err != nil {
+				log.Printf("publishing update check: %v", err)
+			}
+		case start := <-drills:
+			if err := running.command(start, clk.Now(), pub, live, st); err != nil {
+				log.Printf("drill: %v", err)
+			}
+		case <-running.C():
+			if err := running.step(clk.Now(), pub, live, st); err != nil {
+				log.Printf("drill: %v", err)
+			}
+		case next := <-reload:
+			prev := live
+			var restart []string
+			live, restart = reloadConfig(live, next)
+			if err := st.configure(prev, live); err != nil {
+				log.Printf("reload: %v — keeping the previous config", err)
+				live = prev
+				continue loop
+			}
+			nutClient.SetVariables(live.NUT.PollVars)
+			if sched, old := pollSchedule(live, ticking), pollSchedule(prev, ticking); sched.PollInterval != old.PollInterval || sched.AlignPolls != old.AlignPolls {
+				stopTicker()
+				tickC, stopTicker = newPollTicker(clk, sched)
+				log.Printf("now polling every %s", sched.PollInterval)
+			}
+			// Topics may have moved: announce discovery again and let
+			// on_change mode republish everything.
+			st.discovered = false
+			if onChange != nil {
+				onChange.Reset()
+			}
+			log.Printf("configuration reloaded")
+			if len(restart) > 0 {
+				log.Printf("reload: changes to [%s] take effect after a restart", strings.Join(restart, "], ["))
 			}
 		case <-ctx.Done():
 			break loop
@@ -70,12 +529,19 @@ loop:
 	}
 
 	log.Println("shutting down…")
-	ticker.Stop()
+	sd.stopping()
+	stopTicker()
 
 	// Attempt a final poll so subscribers see fresh state on exit.
-	if err := doPoll(nutClient, pub, cfg, &outageStart); err != nil {
+	if err := doPoll(nutClient, pub, live, st); err != nil {
 		log.Printf("final poll failed (%v); skipping final state snapshot", err)
 	}
+	// Don't leave the beeper disabled if we stop during quiet hours.
+	if st.quiet && live.Notifications.MuteBeeper {
+		if err := nutClient.InstCmd("beeper.enable"); err != nil {
+			log.Printf("re-enabling beeper: %v", err)
+		}
+	}
 
 	// Always publish the offline announcement.
 	offMsg := publisher.Message{
@@ -88,20 +554,104 @@ loop:
 	}
 
 	log.Println("offline announcement sent, exiting")
+	return nil
+}
+
+// onceMain connects to NUT without retrying and performs a single runOnce.
+// Cron-style callers get a prompt failure instead of an indefinite backoff.
+func onceMain(ctx context.Context, pub publisher.Publisher, cfg *config.Config, pool *nut.Pool) error {
+	c, err := newNUTClient(cfg.NUT, pool)
+	if err != nil {
+		return fmt.Errorf("connecting to NUT: %w", err)
+	}
+	defer c.Close() //nolint:errcheck
+	c.SetVariables(cfg.NUT.PollVars)
+	return runOnce(ctx, c, pub, cfg, http.DefaultClient)
+}
+
+// runOnce polls and publishes once, then pushes the snapshot to the
+// Prometheus Pushgateway when one is configured.  The offline announcement
+// is deliberately not sent: the data just published stays current until the
+// next scheduled run.
+func runOnce(ctx context.Context, poller nut.Poller, pub publisher.Publisher, cfg *config.Config, client *http.Client) error {
+	st := newPollState()
+	defer st.close()
+	if err := doPoll(poller, pub, cfg, st); err != nil {
+		return err
+	}
+	if err := writeSnapshot(cfg, st); err != nil {
+		return fmt.Errorf("snapshot file: %w", err)
+	}
+	if err := writeTextfile(cfg, st); err != nil {
+		return fmt.Errorf("prometheus textfile: %w", err)
+	}
+	if cfg.Pushgateway.URL == "" {
+		return nil
+	}
+	label := cfg.NUT.EffectiveLabel()
+	body := prom.Format(label, cfg.Labels, st.lastVars, st.lastMetrics)
+	if err := prom.Push(ctx, client, cfg.Pushgateway.URL, cfg.Pushgateway.Job, label, body); err != nil {
+		return fmt.Errorf("pushgateway: %w", err)
+	}
+	return nil
 }
 
-// connectNUT dials upsd with exponential backoff (1 s → 60 s cap).
-// Each sleep is interruptible via ctx cancellation.
-func connectNUT(ctx context.Context, cfg config.NUTConfig) (*nut.Client, error) {
+// pollSchedule returns the settings the poll ticker runs on: cfg.NUT, with
+// low_power.poll_interval in place of the poll interval in low-power mode.
+func pollSchedule(cfg *config.Config, lowPower bool) config.NUTConfig {
+	n := cfg.NUT
+	if lowPower {
+		n.PollInterval = cfg.LowPower.PollInterval
+	}
+	return n
+}
+
+// newPollTicker returns the poll ticker's channel and stop function: a
+// plain ticker on clk, or one aligned to its wall clock with
+// nut.align_polls.
+func newPollTicker(clk clock.Clock, cfg config.NUTConfig) (<-chan time.Time, func()) {
+	if cfg.AlignPolls {
+		t := schedule.NewAlignedTicker(clk, cfg.PollInterval.Duration)
+		return t.C, t.Stop
+	}
+	t := clk.NewTicker(cfg.PollInterval.Duration)
+	return t.C(), t.Stop
+}
+
+// newNUTClient returns a client for the UPS in cfg: one from pool when
+// that is not nil, otherwise one with its own connection.
+func newNUTClient(cfg config.NUTConfig, pool *nut.Pool) (*nut.Client, error) {
+	if pool != nil {
+		return pool.Client(cfg.UPSName)
+	}
+	return nut.NewClient(cfg.Servers(), cfg.Port, cfg.Username, cfg.Password, cfg.UPSName)
+}
+
+// connectNUT dials upsd with exponential backoff (1 s → nut.max_backoff).
+// Each sleep is interruptible via ctx cancellation.  onConn, when not nil,
+// is told about the first failure, the connection, and later connection
+// changes (see nut.Client.OnConnChange).
+func connectNUT(ctx context.Context, cfg config.NUTConfig, pool *nut.Pool, onConn func(event, addr string, err error)) (*nut.Client, error) {
 	backoff := time.Second
-	const maxBackoff = 60 * time.Second
+	maxBackoff := cfg.MaxBackoff.Duration
 
-	for {
-		c, err := nut.NewClient(cfg.Host, cfg.Port, cfg.Username, cfg.Password, cfg.UPSName)
+	for attempt := 1; ; attempt++ {
+		c, err := newNUTClient(cfg, pool)
 		if err == nil {
+			if onConn != nil {
+				onConn(nut.ConnConnected, c.Addr(), nil)
+				c.OnConnChange(onConn)
+			}
 			return c, nil
 		}
 		log.Printf("NUT connection failed: %v — retrying in %s", err, backoff)
+		if onConn != nil && attempt == 1 {
+			event := nut.ConnFailed
+			if errors.Is(err, nut.ErrAuthFailed) {
+				event = nut.ConnAuthFailed
+			}
+			onConn(event, "", err)
+		}
 
 		select {
 		case <-ctx.Done():
@@ -116,44 +666,76 @@ func connectNUT(ctx context.Context, cfg config.NUTConfig) (*nut.Client, error)
 	}
 }
 
-// doPoll fetches NUT variables, computes metrics, and publishes everything.
-// outageStart tracks when the current OB condition began; it is set on the
-// first on-battery poll, cleared when mains are restored, and used to compute
-// the outage duration and to clear the retained outage message.
-func doPoll(poller nut.Poller, pub publisher.Publisher, cfg *config.Config, outageStart **time.Time) error {
-	vars, err := poller.Poll()
-	if err != nil {
-		return fmt.Errorf("polling NUT: %w", err)
-	}
-
-	varMap := nut.VarsToMap(vars)
-	m := metrics.Compute(varMap)
-
-	pubCfg := publisher.PublishConfig{
-		Prefix:   cfg.MQTT.TopicPrefix,
-		UPSName:  cfg.NUT.EffectiveLabel(),
-		Retained: cfg.MQTT.Retained,
-	}
-	if err := publisher.PublishAll(varMap, m, pubCfg, pub); err != nil {
-		return fmt.Errorf("publishing: %w", err)
-	}
+// connectMQTT connects to the broker, retrying with exponential backoff
+// (1 s → mqtt.max_backoff) while it is unreachable or refuses the
+// connection, unless once is set.  onFail is told about the first failure.  Configuration errors,
+// such as an unreadable CA certificate, are returned at once.
+func connectMQTT(ctx context.Context, cfg config.MQTTConfig, lwtTopic, lwtPayload string, once bool, onFail func(event string, err error)) (*publisher.MQTTPublisher, error) {
+	backoff := time.Second
+	maxBackoff := cfg.MaxBackoff.Duration
 
-	if m.OnBattery {
-		if *outageStart == nil {
-			now := time.Now()
-			*outageStart = &now
-			log.Printf("power outage detected — UPS on battery")
+	for attempt := 1; ; attempt++ {
+		p, err := publisher.NewMQTTPublisher(cfg, lwtTopic, lwtPayload)
+		var connErr *publisher.ConnectError
+		if err == nil || once || !errors.As(err, &connErr) {
+			return p, err
 		}
-		if err := publisher.PublishOutage(varMap, m, **outageStart, pubCfg, pub); err != nil {
-			return fmt.Errorf("publishing outage: %w", err)
+		log.Printf("MQTT connection failed: %v — retrying in %s", err, backoff)
+		if attempt == 1 {
+			onFail(publisher.ConnectFailure(err), err)
 		}
-	} else if *outageStart != nil {
-		log.Printf("power restored — clearing outage topic")
-		*outageStart = nil
-		if err := publisher.ClearOutage(pubCfg, pub); err != nil {
-			return fmt.Errorf("clearing outage: %w", err)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
 		}
 	}
+}
 
-	return nil
+// connectFanout connects to the brokers after the first in mqtt.brokers,
+// for broker_mode "fanout", and adds each to fan once connected.  Each is
+// retried in the background until ctx ends; with once, each is tried once
+// and connectFanout waits for them.  onConn is told about every connection
+// event with the broker's index in mqtt.brokers; p is nil until the broker
+// has been connected to.
+func connectFanout(ctx context.Context, cfg config.MQTTConfig, lwtTopic, lwtPayload string, once bool, fan *publisher.FanoutPublisher, onConn func(i int, p *publisher.MQTTPublisher, event string, err error)) {
+	brokers := cfg.BrokerList()
+	var wg sync.WaitGroup
+	for i := 1; i < len(brokers); i++ {
+		bc := cfg
+		bc.Brokers = brokers[i : i+1]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p, err := connectMQTT(ctx, bc, lwtTopic, lwtPayload, once, func(event string, err error) {
+				onConn(i, nil, event, err)
+			})
+			if err != nil {
+				log.Printf("MQTT broker %s: %v", brokers[i], err)
+				return
+			}
+			fan.Add(p)
+			onConn(i, p, publisher.ConnConnected, nil)
+			p.OnConnChange(func(event string, err error) { onConn(i, p, event, err) })
+		}()
+	}
+	if once {
+		wg.Wait()
+	}
+}
+
+// recordConn adds a connection event seen at now to the audit log and
+// publishes it.  While the broker is unreachable the publish fails, and the entry goes out
+// with the next one.
+func recordConn(audit *publisher.AuditLog, link, event, addr string, err error, now time.Time, pub publisher.Publisher, cfg *config.Config) {
+	audit.Record(link, event, addr, err, now)
+	if err := audit.Publish(publishConfig(cfg), pub); err != nil {
+		log.Printf("publishing connection audit log: %v", err)
+	}
 }
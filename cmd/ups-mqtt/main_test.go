@@ -1,13 +1,37 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/sweeney/ups-mqtt/internal/alerts"
+	"github.com/sweeney/ups-mqtt/internal/clock"
 	"github.com/sweeney/ups-mqtt/internal/config"
+	"github.com/sweeney/ups-mqtt/internal/hook"
+	"github.com/sweeney/ups-mqtt/internal/metrics"
+	"github.com/sweeney/ups-mqtt/internal/notify"
 	"github.com/sweeney/ups-mqtt/internal/nut"
+	"github.com/sweeney/ups-mqtt/internal/plausibility"
 	"github.com/sweeney/ups-mqtt/internal/publisher"
+	"github.com/sweeney/ups-mqtt/internal/quirks"
+	"github.com/sweeney/ups-mqtt/internal/schedule"
 )
 
 var testCfg = &config.Config{
@@ -38,11 +62,6 @@ var onBatteryVars = []nut.Variable{
 	{Name: "battery.runtime", Value: "4090"},
 }
 
-func newOutageStart() **time.Time {
-	var p *time.Time
-	return &p
-}
-
 // topicFailPublisher succeeds for every topic except failTopic, where it
 // returns an error.  Used to exercise the outage-publish and outage-clear
 // error paths in doPoll without affecting the PublishAll calls that precede them.
@@ -61,9 +80,9 @@ func (t *topicFailPublisher) Publish(msg publisher.Message) error {
 func TestDoPoll_Success(t *testing.T) {
 	fp := &nut.FakePoller{Variables: sampleVars}
 	fpub := &publisher.FakePublisher{}
-	outageStart := newOutageStart()
+	st := newPollState()
 
-	if err := doPoll(fp, fpub, testCfg, outageStart); err != nil {
+	if err := doPoll(fp, fpub, testCfg, st); err != nil {
 		t.Fatalf("doPoll: %v", err)
 	}
 	if fp.CallCount != 1 {
@@ -80,23 +99,26 @@ func TestDoPoll_Success(t *testing.T) {
 func TestDoPoll_PollError(t *testing.T) {
 	fp := &nut.FakePoller{Err: errors.New("connection lost")}
 	fpub := &publisher.FakePublisher{}
-	outageStart := newOutageStart()
+	st := newPollState()
 
-	err := doPoll(fp, fpub, testCfg, outageStart)
+	err := doPoll(fp, fpub, testCfg, st)
 	if err == nil {
 		t.Fatal("expected error when Poll fails")
 	}
-	if len(fpub.Messages) != 0 {
-		t.Error("no messages should be published when Poll fails")
+	if len(fpub.Messages) != 1 {
+		t.Fatalf("got %d messages, want only computed/communication_lost", len(fpub.Messages))
+	}
+	if msg, ok := fpub.Find("ups/cyberpower/computed/communication_lost"); !ok || msg.Payload != "true" {
+		t.Errorf("communication_lost = %+v, want true", msg)
 	}
 }
 
 func TestDoPoll_PublishError(t *testing.T) {
 	fp := &nut.FakePoller{Variables: sampleVars}
 	fpub := &publisher.FakePublisher{PublishError: errors.New("broker down")}
-	outageStart := newOutageStart()
+	st := newPollState()
 
-	err := doPoll(fp, fpub, testCfg, outageStart)
+	err := doPoll(fp, fpub, testCfg, st)
 	if err == nil {
 		t.Fatal("expected error when publish fails")
 	}
@@ -105,12 +127,12 @@ func TestDoPoll_PublishError(t *testing.T) {
 func TestDoPoll_OnBattery_SetsOutageStart(t *testing.T) {
 	fp := &nut.FakePoller{Variables: onBatteryVars}
 	fpub := &publisher.FakePublisher{}
-	outageStart := newOutageStart()
+	st := newPollState()
 
-	if err := doPoll(fp, fpub, testCfg, outageStart); err != nil {
+	if err := doPoll(fp, fpub, testCfg, st); err != nil {
 		t.Fatalf("doPoll: %v", err)
 	}
-	if *outageStart == nil {
+	if st.outageStart == nil {
 		t.Error("outageStart should be set after on-battery poll")
 	}
 	if _, ok := fpub.Find("ups/cyberpower/outage"); !ok {
@@ -121,20 +143,20 @@ func TestDoPoll_OnBattery_SetsOutageStart(t *testing.T) {
 func TestDoPoll_OutageStart_NotResetOnSubsequentOnBatteryPoll(t *testing.T) {
 	fp := &nut.FakePoller{Variables: onBatteryVars}
 	fpub := &publisher.FakePublisher{}
-	outageStart := newOutageStart()
+	st := newPollState()
 
 	// First poll — sets outageStart
-	if err := doPoll(fp, fpub, testCfg, outageStart); err != nil {
+	if err := doPoll(fp, fpub, testCfg, st); err != nil {
 		t.Fatalf("first poll: %v", err)
 	}
-	first := *outageStart
+	first := st.outageStart
 
 	// Second poll — outageStart must remain the same timestamp
 	fpub.Reset()
-	if err := doPoll(fp, fpub, testCfg, outageStart); err != nil {
+	if err := doPoll(fp, fpub, testCfg, st); err != nil {
 		t.Fatalf("second poll: %v", err)
 	}
-	if *outageStart != first {
+	if st.outageStart != first {
 		t.Error("outageStart should not change between consecutive on-battery polls")
 	}
 }
@@ -145,9 +167,9 @@ func TestDoPoll_OutagePublishError_Propagated(t *testing.T) {
 		FakePublisher: &publisher.FakePublisher{},
 		failTopic:     "ups/cyberpower/outage",
 	}
-	outageStart := newOutageStart()
+	st := newPollState()
 
-	err := doPoll(fp, fpub, testCfg, outageStart)
+	err := doPoll(fp, fpub, testCfg, st)
 	if err == nil {
 		t.Fatal("expected error when outage publish fails")
 	}
@@ -156,8 +178,8 @@ func TestDoPoll_OutagePublishError_Propagated(t *testing.T) {
 func TestDoPoll_OutageClearError_Propagated(t *testing.T) {
 	// Step 1: drive into on-battery state with a normal publisher.
 	fp := &nut.FakePoller{Sequence: [][]nut.Variable{onBatteryVars, sampleVars}}
-	outageStart := newOutageStart()
-	if err := doPoll(fp, &publisher.FakePublisher{}, testCfg, outageStart); err != nil {
+	st := newPollState()
+	if err := doPoll(fp, &publisher.FakePublisher{}, testCfg, st); err != nil {
 		t.Fatalf("on-battery poll: %v", err)
 	}
 
@@ -166,7 +188,7 @@ func TestDoPoll_OutageClearError_Propagated(t *testing.T) {
 		FakePublisher: &publisher.FakePublisher{},
 		failTopic:     "ups/cyberpower/outage",
 	}
-	err := doPoll(fp, fpub, testCfg, outageStart)
+	err := doPoll(fp, fpub, testCfg, st)
 	if err == nil {
 		t.Fatal("expected error when outage clear fails")
 	}
@@ -175,9 +197,9 @@ func TestDoPoll_OutageClearError_Propagated(t *testing.T) {
 func TestDoPoll_Label_UsedInTopics(t *testing.T) {
 	fp := &nut.FakePoller{Variables: sampleVars}
 	fpub := &publisher.FakePublisher{}
-	outageStart := newOutageStart()
+	st := newPollState()
 
-	if err := doPoll(fp, fpub, labelledCfg, outageStart); err != nil {
+	if err := doPoll(fp, fpub, labelledCfg, st); err != nil {
 		t.Fatalf("doPoll: %v", err)
 	}
 	if _, ok := fpub.Find("ups/office-ups/state"); !ok {
@@ -188,12 +210,49 @@ func TestDoPoll_Label_UsedInTopics(t *testing.T) {
 	}
 }
 
+func TestDoPoll_Label_Sanitized(t *testing.T) {
+	cfg := &config.Config{
+		NUT:  config.NUTConfig{UPSName: "apc", Label: "office ups/#1"},
+		MQTT: config.MQTTConfig{TopicPrefix: "ups"},
+	}
+	fpub := &publisher.FakePublisher{}
+	if err := doPoll(&nut.FakePoller{Variables: sampleVars}, fpub, cfg, newPollState()); err != nil {
+		t.Fatalf("doPoll: %v", err)
+	}
+	for _, m := range fpub.Messages {
+		if !strings.HasPrefix(m.Topic, "ups/office_ups__1/") {
+			t.Errorf("published on %s, want every topic under ups/office_ups__1/", m.Topic)
+		}
+	}
+	for _, topic := range []string{"ups/office_ups__1/state", "ups/office_ups__1/battery/charge", "ups/office_ups__1/computed/load_watts"} {
+		if _, ok := fpub.Find(topic); !ok {
+			t.Errorf("%s not published", topic)
+		}
+	}
+}
+
+func TestDoPoll_MetricsUnavailable(t *testing.T) {
+	cfg := *testCfg
+	cfg.Metrics.Unavailable = metrics.UnavailableNull
+	vars := []nut.Variable{{Name: "ups.status", Value: "OL"}, {Name: "ups.load", Value: "8"}}
+	fpub := &publisher.FakePublisher{}
+	if err := doPoll(&nut.FakePoller{Variables: vars}, fpub, &cfg, newPollState()); err != nil {
+		t.Fatalf("doPoll: %v", err)
+	}
+	if m, _ := fpub.Find("ups/cyberpower/computed/battery_runtime_mins"); m.Payload != "unknown" {
+		t.Errorf("battery_runtime_mins = %q, want unknown", m.Payload)
+	}
+	if m, _ := fpub.Find("ups/cyberpower/state"); !strings.Contains(m.Payload, `"battery_runtime_mins":null`) {
+		t.Errorf("state = %s, want battery_runtime_mins null", m.Payload)
+	}
+}
+
 func TestDoPoll_Label_UsedInOutageTopic(t *testing.T) {
 	fp := &nut.FakePoller{Variables: onBatteryVars}
 	fpub := &publisher.FakePublisher{}
-	outageStart := newOutageStart()
+	st := newPollState()
 
-	if err := doPoll(fp, fpub, labelledCfg, outageStart); err != nil {
+	if err := doPoll(fp, fpub, labelledCfg, st); err != nil {
 		t.Fatalf("doPoll: %v", err)
 	}
 	if _, ok := fpub.Find("ups/office-ups/outage"); !ok {
@@ -209,22 +268,22 @@ func TestDoPoll_PowerRestored_ClearsOutage(t *testing.T) {
 		Sequence: [][]nut.Variable{onBatteryVars, sampleVars},
 	}
 	fpub := &publisher.FakePublisher{}
-	outageStart := newOutageStart()
+	st := newPollState()
 
 	// Poll 1: on battery
-	if err := doPoll(fp, fpub, testCfg, outageStart); err != nil {
+	if err := doPoll(fp, fpub, testCfg, st); err != nil {
 		t.Fatalf("poll 1: %v", err)
 	}
-	if *outageStart == nil {
+	if st.outageStart == nil {
 		t.Fatal("outageStart should be set after on-battery poll")
 	}
 
 	// Poll 2: power restored
 	fpub.Reset()
-	if err := doPoll(fp, fpub, testCfg, outageStart); err != nil {
+	if err := doPoll(fp, fpub, testCfg, st); err != nil {
 		t.Fatalf("poll 2: %v", err)
 	}
-	if *outageStart != nil {
+	if st.outageStart != nil {
 		t.Error("outageStart should be nil after power restored")
 	}
 
@@ -240,3 +299,3733 @@ func TestDoPoll_PowerRestored_ClearsOutage(t *testing.T) {
 		t.Error("clear message should be retained")
 	}
 }
+
+func TestDoPoll_LastChanged_OnlyOnChange(t *testing.T) {
+	cfg := &config.Config{
+		NUT:  config.NUTConfig{UPSName: "cyberpower"},
+		MQTT: config.MQTTConfig{TopicPrefix: "ups", LastChanged: []string{"ups.status"}},
+	}
+	fp := &nut.FakePoller{Sequence: [][]nut.Variable{sampleVars, sampleVars, onBatteryVars}}
+	fpub := &publisher.FakePublisher{}
+	st := newPollState()
+	const topic = "ups/cyberpower/ups/status/$last_changed"
+
+	// Poll 1: first sighting counts as a change.
+	if err := doPoll(fp, fpub, cfg, st); err != nil {
+		t.Fatalf("poll 1: %v", err)
+	}
+	if _, ok := fpub.Find(topic); !ok {
+		t.Fatal("$last_changed not published on first poll")
+	}
+	if _, ok := fpub.Find("ups/cyberpower/ups/load/$last_changed"); ok {
+		t.Error("$last_changed published for a variable not in last_changed")
+	}
+
+	// Poll 2: status unchanged — no companion topic.
+	fpub.Reset()
+	if err := doPoll(fp, fpub, cfg, st); err != nil {
+		t.Fatalf("poll 2: %v", err)
+	}
+	if _, ok := fpub.Find(topic); ok {
+		t.Error("$last_changed republished although ups.status did not change")
+	}
+
+	// Poll 3: OL → OB DISCHRG.
+	fpub.Reset()
+	if err := doPoll(fp, fpub, cfg, st); err != nil {
+		t.Fatalf("poll 3: %v", err)
+	}
+	if _, ok := fpub.Find(topic); !ok {
+		t.Error("$last_changed not published after ups.status changed")
+	}
+}
+
+func TestDoPoll_LastChangedPublishError_Propagated(t *testing.T) {
+	cfg := &config.Config{
+		NUT:  config.NUTConfig{UPSName: "cyberpower"},
+		MQTT: config.MQTTConfig{TopicPrefix: "ups", LastChanged: []string{"*"}},
+	}
+	fp := &nut.FakePoller{Variables: sampleVars}
+	fpub := &topicFailPublisher{
+		FakePublisher: &publisher.FakePublisher{},
+		failTopic:     "ups/cyberpower/ups/status/$last_changed",
+	}
+	if err := doPoll(fp, fpub, cfg, newPollState()); err == nil {
+		t.Fatal("expected error when $last_changed publish fails")
+	}
+}
+
+func TestDoPoll_Filter_DropsGlitchAndCounts(t *testing.T) {
+	cfg := &config.Config{
+		NUT:    config.NUTConfig{UPSName: "cyberpower"},
+		MQTT:   config.MQTTConfig{TopicPrefix: "ups", Retained: true},
+		Filter: config.FilterConfig{Enabled: true, Mode: "drop"},
+	}
+	glitchVars := []nut.Variable{
+		{Name: "ups.status", Value: "OL DISCHRG"},
+		{Name: "input.voltage", Value: "0"},
+		{Name: "input.voltage.nominal", Value: "230"},
+	}
+	fp := &nut.FakePoller{Sequence: [][]nut.Variable{glitchVars, sampleVars}}
+	fpub := &publisher.FakePublisher{}
+	st := newPollState()
+
+	if err := doPoll(fp, fpub, cfg, st); err != nil {
+		t.Fatalf("poll 1: %v", err)
+	}
+	if _, ok := fpub.Find("ups/cyberpower/input/voltage"); ok {
+		t.Error("implausible input.voltage=0 should not be published")
+	}
+	if msg, _ := fpub.Find("ups/cyberpower/computed/input_voltage_deviation_pct"); msg.Payload != "0" {
+		t.Errorf("input_voltage_deviation_pct = %q, want 0 (not -100) once the glitch is dropped", msg.Payload)
+	}
+	if msg, _ := fpub.Find("ups/cyberpower/glitch_count"); msg.Payload != "1" {
+		t.Errorf("glitch_count = %q, want 1", msg.Payload)
+	}
+
+	// A clean poll keeps the cumulative count.
+	fpub.Reset()
+	if err := doPoll(fp, fpub, cfg, st); err != nil {
+		t.Fatalf("poll 2: %v", err)
+	}
+	if msg, _ := fpub.Find("ups/cyberpower/glitch_count"); msg.Payload != "1" {
+		t.Errorf("glitch_count = %q after clean poll, want 1", msg.Payload)
+	}
+}
+
+func TestDoPoll_Filter_Disabled_NoGlitchTopic(t *testing.T) {
+	fp := &nut.FakePoller{Variables: sampleVars}
+	fpub := &publisher.FakePublisher{}
+	if err := doPoll(fp, fpub, testCfg, newPollState()); err != nil {
+		t.Fatalf("doPoll: %v", err)
+	}
+	if _, ok := fpub.Find("ups/cyberpower/glitch_count"); ok {
+		t.Error("glitch_count should only be published when the filter is enabled")
+	}
+}
+
+func TestDoPoll_GlitchCountPublishError_Propagated(t *testing.T) {
+	cfg := &config.Config{
+		NUT:    config.NUTConfig{UPSName: "cyberpower"},
+		MQTT:   config.MQTTConfig{TopicPrefix: "ups"},
+		Filter: config.FilterConfig{Enabled: true, Mode: "drop"},
+	}
+	fpub := &topicFailPublisher{
+		FakePublisher: &publisher.FakePublisher{},
+		failTopic:     "ups/cyberpower/glitch_count",
+	}
+	if err := doPoll(&nut.FakePoller{Variables: sampleVars}, fpub, cfg, newPollState()); err == nil {
+		t.Fatal("expected error when glitch_count publish fails")
+	}
+}
+
+func TestNewFilter_ConfigBoundsOverrideDefaults(t *testing.T) {
+	max := 60.0
+	f := newFilter(config.FilterConfig{
+		Enabled: true,
+		Mode:    "clamp",
+		Bounds:  map[string]config.BoundsConfig{"battery.charge": {Max: &max}},
+	}, nil)
+	out, _ := f.Apply(map[string]string{"battery.charge": "80", "battery.runtime": "-1"})
+	if out["battery.charge"] != "60" {
+		t.Errorf("battery.charge = %q, want clamped to configured max 60", out["battery.charge"])
+	}
+	if out["battery.runtime"] != "0" {
+		t.Errorf("battery.runtime = %q, want built-in rule still applied", out["battery.runtime"])
+	}
+}
+
+func TestNewFilter_QuirkRules(t *testing.T) {
+	lo, hi := 180.0, 100.0
+	q := quirks.Set{{Name: "q", Rules: map[string]plausibility.Rule{
+		"input.voltage":  {Min: &lo},
+		"battery.charge": {Max: &hi},
+	}}}
+	f := newFilter(config.FilterConfig{Mode: "drop"}, q)
+	if len(f.Rules) != 2 {
+		t.Errorf("disabled filter rules = %v, want only the quirk rules", f.Rules)
+	}
+
+	max := 60.0
+	f = newFilter(config.FilterConfig{
+		Enabled: true,
+		Mode:    "drop",
+		Bounds:  map[string]config.BoundsConfig{"battery.charge": {Max: &max}},
+	}, q)
+	if r := f.Rules["input.voltage"]; r.Min == nil || *r.Min != 180 || r.ZeroOnlyOnBattery {
+		t.Errorf("input.voltage rule = %+v, want quirk rule over built-in", r)
+	}
+	if r := f.Rules["battery.charge"]; r.Max == nil || *r.Max != 60 {
+		t.Errorf("battery.charge rule = %+v, want configured bounds over quirk", r)
+	}
+}
+
+func TestDoPoll_Quirks(t *testing.T) {
+	cfg := &config.Config{
+		NUT:  config.NUTConfig{UPSName: "cyberpower"},
+		MQTT: config.MQTTConfig{TopicPrefix: "ups"},
+		Quirks: config.QuirksConfig{
+			Builtin: true,
+			Profiles: []config.QuirkProfileConfig{{
+				Name:     "nominal",
+				Model:    "CP1500*",
+				Scale:    map[string]float64{"battery.runtime": 2},
+				Defaults: map[string]config.Value{"ups.realpower.nominal": "1000"},
+			}},
+		},
+	}
+	vars := []nut.Variable{
+		{Name: "device.model", Value: "CP1500EPFCLCD"},
+		{Name: "ups.vendorid", Value: "0764"},
+		{Name: "ups.status", Value: "OL"},
+		{Name: "ups.load", Value: "8"},
+		{Name: "ups.realpower", Value: "77"},
+		{Name: "battery.runtime", Value: "2460"},
+		{Name: "battery.mfr.date", Value: "CPS"},
+		{Name: "input.voltage", Value: "0"},
+	}
+	fpub := &publisher.FakePublisher{}
+	st := newPollState()
+	if err := doPoll(&nut.FakePoller{Variables: vars}, fpub, cfg, st); err != nil {
+		t.Fatalf("doPoll: %v", err)
+	}
+	if st.quirks != "cyberpower, nominal" {
+		t.Errorf("quirks = %q", st.quirks)
+	}
+	if _, ok := fpub.Find("ups/cyberpower/battery/mfr/date"); ok {
+		t.Error("battery.mfr.date should be dropped by the cyberpower profile")
+	}
+	if _, ok := fpub.Find("ups/cyberpower/input/voltage"); ok {
+		t.Error("input.voltage=0 on mains should be filtered by the cyberpower profile")
+	}
+	if msg, _ := fpub.Find("ups/cyberpower/battery/runtime"); msg.Payload != "4920" {
+		t.Errorf("battery/runtime = %q, want scaled 4920", msg.Payload)
+	}
+	if msg, _ := fpub.Find("ups/cyberpower/computed/load_watts"); msg.Payload != "77" {
+		t.Errorf("load_watts = %q, want 77 from ups.realpower", msg.Payload)
+	}
+}
+
+func TestDoPoll_Quirks_NoneMatch(t *testing.T) {
+	cfg := &config.Config{
+		NUT:    config.NUTConfig{UPSName: "cyberpower"},
+		MQTT:   config.MQTTConfig{TopicPrefix: "ups"},
+		Quirks: config.QuirksConfig{Builtin: true},
+	}
+	fpub := &publisher.FakePublisher{}
+	st := newPollState()
+	if err := doPoll(&nut.FakePoller{Variables: sampleVars}, fpub, cfg, st); err != nil {
+		t.Fatalf("doPoll: %v", err)
+	}
+	if st.quirks != "" {
+		t.Errorf("quirks = %q, want none", st.quirks)
+	}
+	if msg, _ := fpub.Find("ups/cyberpower/computed/load_watts"); msg.Payload != "72" {
+		t.Errorf("load_watts = %q, want 72", msg.Payload)
+	}
+}
+
+func TestDoPoll_HoldMissing_RepublishesLastValue(t *testing.T) {
+	cfg := &config.Config{
+		NUT:  config.NUTConfig{UPSName: "cyberpower", HoldMissing: config.Duration{Duration: time.Minute}},
+		MQTT: config.MQTTConfig{TopicPrefix: "ups"},
+	}
+	dropped := []nut.Variable{
+		{Name: "ups.status", Value: "OL"},
+		{Name: "ups.load", Value: "8"},
+		{Name: "battery.runtime", Value: "4920"},
+		// ups.realpower.nominal missing this poll
+	}
+	fp := &nut.FakePoller{Sequence: [][]nut.Variable{sampleVars, dropped}}
+	fpub := &publisher.FakePublisher{}
+	st := newPollState()
+
+	if err := doPoll(fp, fpub, cfg, st); err != nil {
+		t.Fatalf("poll 1: %v", err)
+	}
+	fpub.Reset()
+	if err := doPoll(fp, fpub, cfg, st); err != nil {
+		t.Fatalf("poll 2: %v", err)
+	}
+	if msg, ok := fpub.Find("ups/cyberpower/ups/realpower/nominal"); !ok || msg.Payload != "900" {
+		t.Errorf("ups/realpower/nominal = %+v (found=%v), want held 900", msg, ok)
+	}
+	if msg, _ := fpub.Find("ups/cyberpower/computed/load_watts"); msg.Payload != "72" {
+		t.Errorf("load_watts = %q, want 72 computed from the held nominal", msg.Payload)
+	}
+}
+
+func TestDoPoll_Defaults_FeedMetricsOnly(t *testing.T) {
+	cfg := &config.Config{
+		NUT: config.NUTConfig{
+			UPSName:  "cyberpower",
+			Defaults: map[string]config.Value{"ups.realpower.nominal": "900", "ups.load": "50"},
+		},
+		MQTT: config.MQTTConfig{TopicPrefix: "ups"},
+	}
+	noNominal := []nut.Variable{
+		{Name: "ups.status", Value: "OL"},
+		{Name: "ups.load", Value: "8"},
+	}
+	fpub := &publisher.FakePublisher{}
+	if err := doPoll(&nut.FakePoller{Variables: noNominal}, fpub, cfg, newPollState()); err != nil {
+		t.Fatalf("doPoll: %v", err)
+	}
+	if msg, _ := fpub.Find("ups/cyberpower/computed/load_watts"); msg.Payload != "72" {
+		t.Errorf("load_watts = %q, want 72 (reported 8%% of default 900 W)", msg.Payload)
+	}
+	if _, ok := fpub.Find("ups/cyberpower/ups/realpower/nominal"); ok {
+		t.Error("a default value must not be published as if the UPS reported it")
+	}
+}
+
+func TestWithDefaults_NoDefaultsReturnsInput(t *testing.T) {
+	vars := map[string]string{"ups.load": "8"}
+	if got := withDefaults(vars, nil); len(got) != 1 || got["ups.load"] != "8" {
+		t.Errorf("withDefaults(vars, nil) = %v", got)
+	}
+}
+
+func TestDoPoll_CommunicationLost_ClearedOnSuccess(t *testing.T) {
+	fp := &nut.FakePoller{Variables: sampleVars}
+	fpub := &publisher.FakePublisher{}
+	if err := doPoll(fp, fpub, testCfg, newPollState()); err != nil {
+		t.Fatalf("doPoll: %v", err)
+	}
+	if msg, _ := fpub.Find("ups/cyberpower/computed/communication_lost"); msg.Payload != "false" {
+		t.Errorf("communication_lost = %q, want false", msg.Payload)
+	}
+}
+
+func TestDoPoll_CommunicationLostPublishError_Propagated(t *testing.T) {
+	fpub := &topicFailPublisher{
+		FakePublisher: &publisher.FakePublisher{},
+		failTopic:     "ups/cyberpower/computed/communication_lost",
+	}
+	if err := doPoll(&nut.FakePoller{Variables: sampleVars}, fpub, testCfg, newPollState()); err == nil {
+		t.Fatal("expected error when communication_lost publish fails")
+	}
+	// On a failed poll the publish error is only logged; the poll error wins.
+	fp := &nut.FakePoller{Err: errors.New("connection lost")}
+	if err := doPoll(fp, fpub, testCfg, newPollState()); err == nil {
+		t.Fatal("expected poll error")
+	}
+}
+
+func TestDoPoll_DataStale(t *testing.T) {
+	fp := &nut.FakePoller{Err: fmt.Errorf("polling: %w", nut.ErrDataStale)}
+	fpub := &publisher.FakePublisher{}
+	if err := doPoll(fp, fpub, testCfg, newPollState()); !errors.Is(err, nut.ErrDataStale) {
+		t.Fatalf("doPoll error = %v, want ErrDataStale", err)
+	}
+	if msg, _ := fpub.Find("ups/cyberpower/computed/data_stale"); msg.Payload != "true" {
+		t.Errorf("data_stale = %q, want true", msg.Payload)
+	}
+	if msg, _ := fpub.Find("ups/cyberpower/state"); !strings.Contains(msg.Payload, `"online":false`) {
+		t.Errorf("state = %q, want offline downgrade", msg.Payload)
+	}
+	if _, ok := fpub.Find("ups/cyberpower/ups/status"); ok {
+		t.Error("variables should not be published from a stale driver")
+	}
+
+	fpub.Reset()
+	fp.Err = errors.New("connection refused")
+	if err := doPoll(fp, fpub, testCfg, newPollState()); err == nil {
+		t.Fatal("expected poll error")
+	}
+	if _, ok := fpub.Find("ups/cyberpower/computed/data_stale"); ok {
+		t.Error("data_stale should only be raised for stale driver data")
+	}
+}
+
+func TestDoPoll_DataStale_DriverReconnecting(t *testing.T) {
+	vars := append([]nut.Variable{{Name: "driver.state", Value: "reconnect"}}, sampleVars...)
+	fpub := &publisher.FakePublisher{}
+	if err := doPoll(&nut.FakePoller{Variables: vars}, fpub, testCfg, newPollState()); !errors.Is(err, nut.ErrDataStale) {
+		t.Fatalf("doPoll error = %v, want ErrDataStale", err)
+	}
+	if _, ok := fpub.Find("ups/cyberpower/ups/load"); ok {
+		t.Error("frozen values should not be published while the driver reconnects")
+	}
+}
+
+func TestDoPoll_DataStale_ClearedOnSuccess(t *testing.T) {
+	fpub := &publisher.FakePublisher{}
+	if err := doPoll(&nut.FakePoller{Variables: sampleVars}, fpub, testCfg, newPollState()); err != nil {
+		t.Fatalf("doPoll: %v", err)
+	}
+	if msg, _ := fpub.Find("ups/cyberpower/computed/data_stale"); msg.Payload != "false" {
+		t.Errorf("data_stale = %q, want false", msg.Payload)
+	}
+
+	fail := &topicFailPublisher{
+		FakePublisher: &publisher.FakePublisher{},
+		failTopic:     "ups/cyberpower/computed/data_stale",
+	}
+	if err := doPoll(&nut.FakePoller{Variables: sampleVars}, fail, testCfg, newPollState()); err == nil {
+		t.Fatal("expected error when data_stale publish fails")
+	}
+}
+
+func TestDoPoll_Discovery_SentOnce(t *testing.T) {
+	cfg := &config.Config{
+		NUT:           config.NUTConfig{UPSName: "cyberpower"},
+		MQTT:          config.MQTTConfig{TopicPrefix: "ups", Retained: true},
+		HomeAssistant: config.HomeAssistantConfig{Discovery: true, DiscoveryPrefix: "homeassistant"},
+	}
+	fp := &nut.FakePoller{Variables: sampleVars}
+	fpub := &publisher.FakePublisher{}
+	st := newPollState()
+
+	if err := doPoll(fp, fpub, cfg, st); err != nil {
+		t.Fatalf("poll 1: %v", err)
+	}
+	topic := "homeassistant/binary_sensor/ups_mqtt_cyberpower/communication_lost/config"
+	if _, ok := fpub.Find(topic); !ok {
+		t.Fatalf("%s not published", topic)
+	}
+
+	fpub.Reset()
+	if err := doPoll(fp, fpub, cfg, st); err != nil {
+		t.Fatalf("poll 2: %v", err)
+	}
+	if _, ok := fpub.Find(topic); ok {
+		t.Error("discovery should only be announced once")
+	}
+}
+
+func TestDoPoll_Discovery_Disabled(t *testing.T) {
+	fpub := &publisher.FakePublisher{}
+	if err := doPoll(&nut.FakePoller{Variables: sampleVars}, fpub, testCfg, newPollState()); err != nil {
+		t.Fatalf("doPoll: %v", err)
+	}
+	for _, msg := range fpub.Messages {
+		if strings.HasPrefix(msg.Topic, "homeassistant/") {
+			t.Errorf("unexpected discovery message on %s", msg.Topic)
+		}
+	}
+}
+
+func TestDoPoll_DiscoveryPublishError_Propagated(t *testing.T) {
+	cfg := &config.Config{
+		NUT:           config.NUTConfig{UPSName: "cyberpower"},
+		MQTT:          config.MQTTConfig{TopicPrefix: "ups"},
+		HomeAssistant: config.HomeAssistantConfig{Discovery: true, DiscoveryPrefix: "homeassistant"},
+	}
+	fpub := &topicFailPublisher{
+		FakePublisher: &publisher.FakePublisher{},
+		failTopic:     "homeassistant/sensor/ups_mqtt_cyberpower/load_watts/config",
+	}
+	st := newPollState()
+	if err := doPoll(&nut.FakePoller{Variables: sampleVars}, fpub, cfg, st); err == nil {
+		t.Fatal("expected error when discovery publish fails")
+	}
+	if st.discovered {
+		t.Error("discovered should stay false so the next poll retries")
+	}
+}
+
+func TestDoPoll_Diff_PublishedFromSecondPoll(t *testing.T) {
+	cfg := &config.Config{
+		NUT:  config.NUTConfig{UPSName: "cyberpower"},
+		MQTT: config.MQTTConfig{TopicPrefix: "ups", Retained: true, Diff: true},
+	}
+	fp := &nut.FakePoller{Sequence: [][]nut.Variable{sampleVars, sampleVars, onBatteryVars}}
+	fpub := &publisher.FakePublisher{}
+	st := newPollState()
+
+	for i := 1; i <= 3; i++ {
+		fpub.Reset()
+		if err := doPoll(fp, fpub, cfg, st); err != nil {
+			t.Fatalf("poll %d: %v", i, err)
+		}
+		msg, ok := fpub.Find("ups/cyberpower/diff")
+		switch i {
+		case 1, 2:
+			if ok {
+				t.Errorf("poll %d: unexpected diff %s", i, msg.Payload)
+			}
+		case 3:
+			if !ok {
+				t.Fatal("poll 3: diff not published")
+			}
+			if !strings.Contains(msg.Payload, `"ups.status":{"old":"OL","new":"OB DISCHRG"}`) {
+				t.Errorf("diff payload = %s", msg.Payload)
+			}
+		}
+	}
+}
+
+func TestDoPoll_DiffPublishError_Propagated(t *testing.T) {
+	cfg := &config.Config{
+		NUT:  config.NUTConfig{UPSName: "cyberpower"},
+		MQTT: config.MQTTConfig{TopicPrefix: "ups", Diff: true},
+	}
+	fp := &nut.FakePoller{Sequence: [][]nut.Variable{sampleVars, onBatteryVars}}
+	fpub := &topicFailPublisher{
+		FakePublisher: &publisher.FakePublisher{},
+		failTopic:     "ups/cyberpower/diff",
+	}
+	st := newPollState()
+	if err := doPoll(fp, fpub, cfg, st); err != nil {
+		t.Fatalf("poll 1: %v", err)
+	}
+	if err := doPoll(fp, fpub, cfg, st); err == nil {
+		t.Fatal("expected error when diff publish fails")
+	}
+}
+
+func TestDoPoll_PublishEvery_Downsamples(t *testing.T) {
+	cfg := &config.Config{
+		NUT:  config.NUTConfig{UPSName: "cyberpower"},
+		MQTT: config.MQTTConfig{TopicPrefix: "ups", VariablesEvery: 3, ComputedEvery: 2},
+	}
+	fp := &nut.FakePoller{Variables: sampleVars}
+	fpub := &publisher.FakePublisher{}
+	st := newPollState()
+
+	for i := 0; i < 4; i++ {
+		fpub.Reset()
+		if err := doPoll(fp, fpub, cfg, st); err != nil {
+			t.Fatalf("poll %d: %v", i, err)
+		}
+		_, vars := fpub.Find("ups/cyberpower/battery/charge")
+		_, computed := fpub.Find("ups/cyberpower/computed/load_watts")
+		_, state := fpub.Find("ups/cyberpower/state")
+		if vars != (i%3 == 0) || computed != (i%2 == 0) || !state {
+			t.Errorf("poll %d: vars=%v computed=%v state=%v", i, vars, computed, state)
+		}
+	}
+}
+
+func TestDoPoll_PublishEvery_StatusChangePublishesAll(t *testing.T) {
+	cfg := &config.Config{
+		NUT:  config.NUTConfig{UPSName: "cyberpower"},
+		MQTT: config.MQTTConfig{TopicPrefix: "ups", VariablesEvery: 10, ComputedEvery: 10},
+	}
+	fp := &nut.FakePoller{Sequence: [][]nut.Variable{sampleVars, onBatteryVars}}
+	fpub := &publisher.FakePublisher{}
+	st := newPollState()
+
+	if err := doPoll(fp, fpub, cfg, st); err != nil {
+		t.Fatalf("poll 1: %v", err)
+	}
+	fpub.Reset()
+	if err := doPoll(fp, fpub, cfg, st); err != nil {
+		t.Fatalf("poll 2: %v", err)
+	}
+	if msg, ok := fpub.Find("ups/cyberpower/ups/status"); !ok || msg.Payload != "OB DISCHRG" {
+		t.Errorf("ups/status = %+v, want OB DISCHRG on status change", msg)
+	}
+	if msg, ok := fpub.Find("ups/cyberpower/computed/on_battery"); !ok || msg.Payload != "true" {
+		t.Errorf("computed/on_battery = %+v, want true on status change", msg)
+	}
+}
+
+func TestDoPoll_OutagePublishesCriticalTopicsFirst(t *testing.T) {
+	fp := &nut.FakePoller{Sequence: [][]nut.Variable{sampleVars, onBatteryVars, onBatteryVars}}
+	fpub := &publisher.FakePublisher{}
+	st := newPollState()
+
+	if err := doPoll(fp, fpub, testCfg, st); err != nil {
+		t.Fatalf("poll 1: %v", err)
+	}
+	index := func(topic string) int {
+		for i, msg := range fpub.Messages {
+			if msg.Topic == topic {
+				return i
+			}
+		}
+		t.Fatalf("%s not published", topic)
+		return -1
+	}
+	fpub.Reset()
+	if err := doPoll(fp, fpub, testCfg, st); err != nil {
+		t.Fatalf("poll 2: %v", err)
+	}
+	want := []string{
+		"ups/cyberpower/state",
+		"ups/cyberpower/ups/status",
+		"ups/cyberpower/computed/on_battery",
+		"ups/cyberpower/computed/low_battery",
+		"ups/cyberpower/computed/power_source",
+	}
+	for i, topic := range want {
+		if got := index(topic); got != i {
+			t.Errorf("%s published at position %d, want %d", topic, got, i)
+		}
+	}
+	states := 0
+	for _, msg := range fpub.Messages {
+		if msg.Topic == "ups/cyberpower/state" {
+			states++
+		}
+	}
+	if states != 1 {
+		t.Errorf("state published %d times, want once", states)
+	}
+
+	// Still on battery: the usual order, variables first.
+	fpub.Reset()
+	if err := doPoll(fp, fpub, testCfg, st); err != nil {
+		t.Fatalf("poll 3: %v", err)
+	}
+	if index("ups/cyberpower/state") < index("ups/cyberpower/battery/charge") {
+		t.Error("state published ahead of the variables without a transition")
+	}
+}
+
+func TestDoPoll_ComputedPublishError_Propagated(t *testing.T) {
+	fp := &nut.FakePoller{Variables: sampleVars}
+	fpub := &topicFailPublisher{
+		FakePublisher: &publisher.FakePublisher{},
+		failTopic:     "ups/cyberpower/computed/load_watts",
+	}
+	if err := doPoll(fp, fpub, testCfg, newPollState()); err == nil {
+		t.Fatal("expected error when a computed publish fails")
+	}
+}
+
+func TestDoPoll_StatePublishError_Propagated(t *testing.T) {
+	fp := &nut.FakePoller{Variables: sampleVars}
+	fpub := &topicFailPublisher{
+		FakePublisher: &publisher.FakePublisher{},
+		failTopic:     "ups/cyberpower/state",
+	}
+	if err := doPoll(fp, fpub, testCfg, newPollState()); err == nil {
+		t.Fatal("expected error when the state publish fails")
+	}
+}
+
+func TestRunOnce_PushesToPushgateway(t *testing.T) {
+	var gotPath, gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+	}))
+	defer srv.Close()
+
+	cfg := &config.Config{
+		NUT:         config.NUTConfig{UPSName: "cyberpower"},
+		MQTT:        config.MQTTConfig{TopicPrefix: "ups", Retained: true},
+		Pushgateway: config.PushgatewayConfig{URL: srv.URL, Job: "ups-mqtt"},
+	}
+	fpub := &publisher.FakePublisher{}
+	if err := runOnce(context.Background(), &nut.FakePoller{Variables: sampleVars}, fpub, cfg, srv.Client()); err != nil {
+		t.Fatalf("runOnce: %v", err)
+	}
+	if _, ok := fpub.Find("ups/cyberpower/state"); !ok {
+		t.Error("state not published")
+	}
+	if gotPath != "/metrics/job/ups-mqtt/instance/cyberpower" {
+		t.Errorf("push path = %s", gotPath)
+	}
+	if !strings.Contains(gotBody, `ups_mqtt_load_watts{ups="cyberpower"} 72`) {
+		t.Errorf("push body missing load_watts:\n%s", gotBody)
+	}
+}
+
+func TestRunOnce_NoPushgateway(t *testing.T) {
+	fpub := &publisher.FakePublisher{}
+	if err := runOnce(context.Background(), &nut.FakePoller{Variables: sampleVars}, fpub, testCfg, nil); err != nil {
+		t.Fatalf("runOnce: %v", err)
+	}
+	if _, ok := fpub.Find("ups/cyberpower/state"); !ok {
+		t.Error("state not published")
+	}
+}
+
+func TestRunOnce_DryRun(t *testing.T) {
+	var out strings.Builder
+	if err := runOnce(context.Background(), &nut.FakePoller{Variables: sampleVars}, publisher.NewPrintPublisher(&out, false), testCfg, nil); err != nil {
+		t.Fatalf("runOnce: %v", err)
+	}
+	// Variables are printed in map order, so either may come first.
+	got := "\n" + out.String()
+	for _, want := range []string{"\nups/cyberpower/state (retained) {", "\nups/cyberpower/battery/charge (retained) 100\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("dry run output lacks %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestRunOnce_PollError(t *testing.T) {
+	fp := &nut.FakePoller{Err: errors.New("connection lost")}
+	if err := runOnce(context.Background(), fp, &publisher.FakePublisher{}, testCfg, nil); err == nil {
+		t.Fatal("expected error when Poll fails")
+	}
+}
+
+func TestRunOnce_PushError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	cfg := &config.Config{
+		NUT:         config.NUTConfig{UPSName: "cyberpower"},
+		MQTT:        config.MQTTConfig{TopicPrefix: "ups"},
+		Pushgateway: config.PushgatewayConfig{URL: srv.URL, Job: "ups-mqtt"},
+	}
+	err := runOnce(context.Background(), &nut.FakePoller{Variables: sampleVars}, &publisher.FakePublisher{}, cfg, srv.Client())
+	if err == nil {
+		t.Fatal("expected error when the Pushgateway rejects the push")
+	}
+}
+
+func TestPushGrafana(t *testing.T) {
+	var gotPath, gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+	}))
+	defer srv.Close()
+
+	cfg := &config.Config{
+		NUT:     config.NUTConfig{UPSName: "cyberpower"},
+		MQTT:    config.MQTTConfig{TopicPrefix: "ups"},
+		Grafana: config.GrafanaConfig{URL: srv.URL, Token: "t", StreamID: "ups-mqtt"},
+	}
+	st := newPollState()
+	if err := pushGrafana(context.Background(), srv.Client(), cfg, st); err != nil || gotPath != "" {
+		t.Fatalf("push before the first poll: err=%v path=%q, want nothing sent", err, gotPath)
+	}
+	if err := doPoll(&nut.FakePoller{Variables: sampleVars}, &publisher.FakePublisher{}, cfg, st); err != nil {
+		t.Fatalf("doPoll: %v", err)
+	}
+	if err := pushGrafana(context.Background(), srv.Client(), cfg, st); err != nil {
+		t.Fatalf("pushGrafana: %v", err)
+	}
+	if gotPath != "/api/live/push/ups-mqtt" {
+		t.Errorf("push path = %s", gotPath)
+	}
+	if !strings.HasPrefix(gotBody, "ups,ups=cyberpower ") || !strings.Contains(gotBody, "load_watts=72") {
+		t.Errorf("push body = %q", gotBody)
+	}
+}
+
+func TestPushGrafana_Disabled(t *testing.T) {
+	st := newPollState()
+	st.lastVars = map[string]string{"ups.status": "OL"}
+	if err := pushGrafana(context.Background(), nil, testCfg, st); err != nil {
+		t.Fatalf("pushGrafana: %v", err)
+	}
+}
+
+func TestRunSelfTest_PublishesResult(t *testing.T) {
+	cfg := &config.Config{
+		NUT:  config.NUTConfig{UPSName: "cyberpower"},
+		MQTT: config.MQTTConfig{TopicPrefix: "ups", Retained: true, SelfTestTimeout: config.Duration{Duration: time.Second}},
+	}
+	fpub := &publisher.FakePublisher{}
+	if res := runSelfTest(fpub, fpub, cfg); !res.OK {
+		t.Fatalf("self-test failed: %s", res.Error)
+	}
+	msg, ok := fpub.Find("ups/cyberpower/selftest")
+	if !ok || !strings.Contains(msg.Payload, `"ok":true`) {
+		t.Errorf("selftest result = %+v", msg)
+	}
+}
+
+func TestRunSelfTest_FailureStillPublished(t *testing.T) {
+	cfg := &config.Config{
+		NUT:  config.NUTConfig{UPSName: "cyberpower"},
+		MQTT: config.MQTTConfig{TopicPrefix: "ups", SelfTestTimeout: config.Duration{Duration: time.Second}},
+	}
+	ps := &publisher.FakePublisher{SubscribeError: errors.New("not authorised")}
+	out := &publisher.FakePublisher{}
+	if res := runSelfTest(ps, out, cfg); res.OK {
+		t.Fatal("self-test should fail when subscribing fails")
+	}
+	if msg, ok := out.Find("ups/cyberpower/selftest"); !ok || !strings.Contains(msg.Payload, `"ok":false`) {
+		t.Errorf("selftest result = %+v", msg)
+	}
+
+	// A result that can't be published is only logged.
+	out.PublishError = errors.New("broker down")
+	runSelfTest(ps, out, cfg)
+}
+
+func TestACLRootsAndTopics(t *testing.T) {
+	cfg := &config.Config{
+		NUT: config.NUTConfig{UPSName: "cyberpower", Label: "office"},
+		MQTT: config.MQTTConfig{
+			TopicPrefix:       "ups",
+			SelfTest:          true,
+			NamespacePrefixes: map[string]string{"driver": "infra/nut/", "battery": "power/battery"},
+		},
+		HomeAssistant: config.HomeAssistantConfig{Discovery: true, DiscoveryPrefix: "homeassistant"},
+		Migration:     config.MigrationConfig{TopicPrefix: "home/power"},
+		Diagnostics:   config.DiagnosticsConfig{RawNUT: true},
+	}
+	roots := strings.Join(aclRoots(cfg), " ")
+	want := "ups/office power/battery infra/nut home/power/office homeassistant/sensor homeassistant/binary_sensor"
+	if roots != want {
+		t.Errorf("aclRoots = %q\nwant      %q", roots, want)
+	}
+	topics := strings.Join(aclSubscribeTopics(cfg), " ")
+	if topics != "ups/office/selftest/probe ups/office/selftest/run ups/office/diag/nut/command homeassistant/status" {
+		t.Errorf("aclSubscribeTopics = %q", topics)
+	}
+	if got := aclSubscribeTopics(testCfg); len(got) != 0 {
+		t.Errorf("aclSubscribeTopics with no command topics = %q", got)
+	}
+}
+
+func TestRunACLCheck_PublishesReport(t *testing.T) {
+	cfg := &config.Config{
+		NUT:         config.NUTConfig{UPSName: "cyberpower"},
+		MQTT:        config.MQTTConfig{TopicPrefix: "ups", Retained: true, SelfTestTimeout: config.Duration{Duration: time.Second}},
+		Diagnostics: config.DiagnosticsConfig{RawNUT: true},
+	}
+	fpub := &publisher.FakePublisher{}
+	if rep := runACLCheck(fpub, fpub, cfg); !rep.OK || len(rep.Checks) != 2 {
+		t.Fatalf("report = %+v, want 2 passing checks", rep)
+	}
+	if msg, ok := fpub.Find("ups/cyberpower/selftest/acl"); !ok || !strings.Contains(msg.Payload, `"ok":true`) {
+		t.Errorf("ACL report = %+v", msg)
+	}
+}
+
+func TestRunACLCheck_FailureLoggedAndPublished(t *testing.T) {
+	cfg := &config.Config{
+		NUT:  config.NUTConfig{UPSName: "cyberpower"},
+		MQTT: config.MQTTConfig{TopicPrefix: "ups", SelfTestTimeout: config.Duration{Duration: 10 * time.Millisecond}},
+	}
+	ps := &publisher.FakePublisher{SubscribeError: errors.New("not authorised")}
+	out := &publisher.FakePublisher{}
+	if rep := runACLCheck(ps, out, cfg); rep.OK {
+		t.Fatal("ACL check should fail when the probe can't be subscribed")
+	}
+	if msg, ok := out.Find("ups/cyberpower/selftest/acl"); !ok || !strings.Contains(msg.Payload, `"ok":false`) {
+		t.Errorf("ACL report = %+v", msg)
+	}
+
+	// A report that can't be published is only logged.
+	out.PublishError = errors.New("broker down")
+	runACLCheck(ps, out, cfg)
+}
+
+func TestWatchSelfTest_RunsOnDemand(t *testing.T) {
+	cfg := &config.Config{
+		NUT:  config.NUTConfig{UPSName: "cyberpower"},
+		MQTT: config.MQTTConfig{TopicPrefix: "ups", SelfTestTimeout: config.Duration{Duration: time.Second}},
+	}
+	ps := &publisher.FakePublisher{}
+	done := make(chan publisher.Message, 1)
+	out := &notifyPublisher{FakePublisher: &publisher.FakePublisher{}, topic: "ups/cyberpower/selftest", ch: done}
+
+	if err := watchSelfTest(ps, out, cfg); err != nil {
+		t.Fatalf("watchSelfTest: %v", err)
+	}
+	ps.Deliver(publisher.Message{Topic: "ups/cyberpower/selftest/run"})
+
+	select {
+	case msg := <-done:
+		if !strings.Contains(msg.Payload, `"ok":`) {
+			t.Errorf("unexpected result payload %s", msg.Payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("on-demand self-test did not publish a result")
+	}
+}
+
+// notifyPublisher forwards messages on topic to ch as well as recording them.
+type notifyPublisher struct {
+	*publisher.FakePublisher
+	topic string
+	ch    chan publisher.Message
+}
+
+func (n *notifyPublisher) Publish(msg publisher.Message) error {
+	if msg.Topic == n.topic {
+		n.ch <- msg
+	}
+	return nil
+}
+
+func TestWatchRawNUT_AnswersCommands(t *testing.T) {
+	fp := &nut.FakePoller{RawReplies: map[string][]string{
+		"GET VAR cyberpower battery.charge": {`VAR cyberpower battery.charge "100"`},
+	}}
+	fpub := &publisher.FakePublisher{}
+	if err := watchRawNUT(fpub, fp, fpub, testCfg); err != nil {
+		t.Fatalf("watchRawNUT: %v", err)
+	}
+
+	fpub.Deliver(publisher.Message{Topic: "ups/cyberpower/diag/nut/command", Payload: "GET VAR cyberpower battery.charge\n"})
+	msg, ok := fpub.Find("ups/cyberpower/diag/nut/response")
+	if !ok || msg.Payload != `VAR cyberpower battery.charge "100"` {
+		t.Errorf("response = %+v", msg)
+	}
+
+	fpub.Messages = nil
+	fpub.Deliver(publisher.Message{Topic: "ups/cyberpower/diag/nut/command", Payload: "INSTCMD cyberpower load.off"})
+	if msg, _ := fpub.Find("ups/cyberpower/diag/nut/response"); !strings.HasPrefix(msg.Payload, "ERR ") {
+		t.Errorf("write command response = %q, want ERR", msg.Payload)
+	}
+}
+
+func TestWatchRawNUT_ResponsePublishError(t *testing.T) {
+	fp := &nut.FakePoller{RawReplies: map[string][]string{"VER": {"upsd 2.8.1"}}}
+	ps := &publisher.FakePublisher{}
+	out := &publisher.FakePublisher{PublishError: errors.New("broker down")}
+	if err := watchRawNUT(ps, fp, out, testCfg); err != nil {
+		t.Fatalf("watchRawNUT: %v", err)
+	}
+	// The publish error is only logged.
+	ps.Deliver(publisher.Message{Topic: "ups/cyberpower/diag/nut/command", Payload: "VER"})
+	if len(fp.RawCommands) != 1 {
+		t.Errorf("RawCommands = %v", fp.RawCommands)
+	}
+}
+
+func TestWatchCommands(t *testing.T) {
+	cfg := *testCfg
+	cfg.Commands = config.CommandsConfig{Enabled: true, Allow: []string{"beeper.*", "test.battery.*"}}
+	fp := &nut.FakePoller{}
+	fpub := &publisher.FakePublisher{}
+	if err := watchCommands(fpub, fp, fpub, &cfg, clock.Real); err != nil {
+		t.Fatalf("watchCommands: %v", err)
+	}
+
+	for _, tc := range []struct {
+		payload string
+		want    string
+	}{
+		{"test.battery.start.quick\n", `"command":"test.battery.start.quick","ok":true`},
+		{"load.off", `"ok":false,"error":"load.off is not in commands.allow"`},
+		{"beeper.disable\nFSD cyberpower", `"ok":false,"error":"invalid instant command name`},
+	} {
+		fpub.Messages = nil
+		fpub.Deliver(publisher.Message{Topic: "ups/cyberpower/cmd", Payload: tc.payload})
+		msg, ok := fpub.Find("ups/cyberpower/cmd/result")
+		if !ok || !strings.Contains(msg.Payload, tc.want) {
+			t.Errorf("%q: result = %q, want %s", tc.payload, msg.Payload, tc.want)
+		}
+	}
+	if len(fp.InstCmds) != 1 || fp.InstCmds[0] != "test.battery.start.quick" {
+		t.Errorf("InstCmds = %q, want only the allowed command", fp.InstCmds)
+	}
+
+	fp.InstCmdErr = errors.New("ERR ACCESS-DENIED")
+	fpub.Messages = nil
+	fpub.Deliver(publisher.Message{Topic: "ups/cyberpower/cmd", Payload: "beeper.disable"})
+	if msg, _ := fpub.Find("ups/cyberpower/cmd/result"); !strings.Contains(msg.Payload, `"error":"ERR ACCESS-DENIED"`) {
+		t.Errorf("result = %q, want upsd's error", msg.Payload)
+	}
+}
+
+func TestWatchHABirth(t *testing.T) {
+	cfg := *testCfg
+	cfg.HomeAssistant = config.HomeAssistantConfig{Discovery: true, DiscoveryPrefix: "homeassistant"}
+	fpub := &publisher.FakePublisher{}
+	online := make(chan struct{}, 1)
+	if err := watchHABirth(fpub, &cfg, online); err != nil {
+		t.Fatalf("watchHABirth: %v", err)
+	}
+
+	fpub.Deliver(publisher.Message{Topic: "homeassistant/status", Payload: "offline"})
+	select {
+	case <-online:
+		t.Fatal("HA going offline should not trigger discovery")
+	default:
+	}
+	fpub.Deliver(publisher.Message{Topic: "homeassistant/status", Payload: "online"})
+	fpub.Deliver(publisher.Message{Topic: "homeassistant/status", Payload: "online"})
+	select {
+	case <-online:
+	default:
+		t.Fatal("HA coming online should trigger discovery")
+	}
+
+	// Announcing again publishes every discovery message anew.
+	st := newPollState()
+	st.discovered = true
+	if err := announceDiscovery(map[string]string{}, fpub, &cfg, st); err != nil || len(fpub.Messages) != 0 {
+		t.Fatalf("announced %d messages (err %v) while already announced", len(fpub.Messages), err)
+	}
+	st.discovered = false
+	if err := announceDiscovery(map[string]string{}, fpub, &cfg, st); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fpub.Find("homeassistant/sensor/ups_mqtt_cyberpower/battery_charge/config"); !ok || !st.discovered {
+		t.Errorf("discovery not announced: %v", fpub.Messages)
+	}
+}
+
+func TestWatchCommands_IgnoresRetained(t *testing.T) {
+	cfg := *testCfg
+	cfg.Commands = config.CommandsConfig{Enabled: true, Allow: []string{"*"}}
+	fp := &nut.FakePoller{}
+	fpub := &publisher.FakePublisher{}
+	if err := watchCommands(fpub, fp, fpub, &cfg, clock.Real); err != nil {
+		t.Fatalf("watchCommands: %v", err)
+	}
+	fpub.Deliver(publisher.Message{Topic: "ups/cyberpower/cmd", Payload: "load.off", Retained: true})
+	if len(fp.InstCmds) != 0 {
+		t.Errorf("InstCmds = %q, want the retained command ignored", fp.InstCmds)
+	}
+	if _, ok := fpub.Find("ups/cyberpower/cmd/result"); ok {
+		t.Error("a retained command should not get a result")
+	}
+}
+
+func TestWatchDrill(t *testing.T) {
+	cfg := *testCfg
+	cfg.Drill = config.DrillConfig{Enabled: true, Step: config.Duration{Duration: time.Minute}}
+	fpub := &publisher.FakePublisher{}
+	drills := make(chan bool, 1)
+	if err := watchDrill(fpub, &cfg, drills); err != nil {
+		t.Fatalf("watchDrill: %v", err)
+	}
+	fpub.Deliver(publisher.Message{Topic: "ups/cyberpower/bridge/drill", Payload: "start", Retained: true})
+	fpub.Deliver(publisher.Message{Topic: "ups/cyberpower/bridge/drill", Payload: "pull the plug"})
+	select {
+	case start := <-drills:
+		t.Fatalf("got %v for a retained or unknown payload", start)
+	default:
+	}
+	fpub.Deliver(publisher.Message{Topic: "ups/cyberpower/bridge/drill", Payload: " stop\n"})
+	if start := <-drills; start {
+		t.Error(`"stop" passed as a start`)
+	}
+}
+
+func TestDrill(t *testing.T) {
+	cfg := notifyCfg()
+	cfg.MQTT.Events = true
+	cfg.Drill = config.DrillConfig{Enabled: true, Step: config.Duration{Duration: time.Minute}}
+	pager := &recordingNotifier{}
+	clk := clock.NewFake(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	st := newPollStateAt(clk)
+	st.notifiers = map[string]notify.Notifier{"pager": pager}
+	fpub := &publisher.FakePublisher{}
+
+	var d drill
+	if err := d.command(true, clk.Now(), fpub, cfg, st); err != nil {
+		t.Fatal(err)
+	}
+	for d.C() != nil {
+		// The steps follow the poll state's clock, not the wall clock.
+		clk.Advance(59 * time.Second)
+		select {
+		case <-d.C():
+			t.Fatalf("step due at %s, before a minute had passed", clk.Now())
+		default:
+		}
+		clk.Advance(time.Second)
+		<-d.C()
+		if err := d.step(clk.Now(), fpub, cfg, st); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var events, notes, stamps []string
+	for _, m := range fpub.Messages {
+		switch m.Topic {
+		case "ups/cyberpower/events":
+			var e publisher.EventMessage
+			if err := json.Unmarshal([]byte(m.Payload), &e); err != nil || !e.Drill || m.Retained {
+				t.Errorf("event %s (retained %v) is not a drill", m.Payload, m.Retained)
+			}
+			events = append(events, e.Event)
+			stamps = append(stamps, e.Timestamp)
+		case "ups/cyberpower/notify":
+			var n publisher.NotificationMessage
+			if err := json.Unmarshal([]byte(m.Payload), &n); err != nil || !n.Drill || !strings.HasPrefix(n.Message, "DRILL: ") {
+				t.Errorf("notification %s is not marked as a drill", m.Payload)
+			}
+			notes = append(notes, n.Event)
+		default:
+			t.Errorf("drill published to %s; only events and notifications should hear of it", m.Topic)
+		}
+	}
+	if want := []string{"power_lost", "low_battery", "power_restored"}; !slices.Equal(events, want) {
+		t.Errorf("events = %q, want %q", events, want)
+	}
+	if want := []string{"on_battery", "low_battery", "power_restored"}; !slices.Equal(notes, want) {
+		t.Errorf("notifications = %q, want %q", notes, want)
+	}
+	if want := []string{"2026-03-01T12:00:00Z", "2026-03-01T12:01:00Z", "2026-03-01T12:02:00Z"}; !slices.Equal(stamps, want) {
+		t.Errorf("event timestamps = %q, want %q", stamps, want)
+	}
+	if len(pager.got) != 3 || !pager.got[0].Drill {
+		t.Errorf("pager got %+v, want three drill notifications", pager.got)
+	}
+}
+
+func TestDrill_Stop(t *testing.T) {
+	cfg := notifyCfg()
+	cfg.Drill = config.DrillConfig{Enabled: true, Step: config.Duration{Duration: time.Hour}}
+	st := newPollState()
+	fpub := &publisher.FakePublisher{}
+
+	var d drill
+	if err := d.command(false, time.Now(), fpub, cfg, st); err != nil || len(fpub.Messages) != 0 {
+		t.Fatalf("stop with no drill running published %v (err %v)", fpub.Messages, err)
+	}
+	if err := d.command(true, time.Now(), fpub, cfg, st); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.command(true, time.Now(), fpub, cfg, st); err != nil || len(fpub.Messages) != 1 {
+		t.Fatalf("a second start published %d messages (err %v), want it ignored", len(fpub.Messages), err)
+	}
+	if err := d.command(false, time.Now(), fpub, cfg, st); err != nil {
+		t.Fatal(err)
+	}
+	if d.C() != nil {
+		t.Error("drill still running after stop")
+	}
+	if len(fpub.Messages) != 2 || !strings.Contains(fpub.Messages[1].Payload, `"event":"power_restored"`) {
+		t.Errorf("messages = %v, want on_battery then power_restored", fpub.Messages)
+	}
+}
+
+func TestRunCommand_Allow(t *testing.T) {
+	fp := &nut.FakePoller{}
+	if err := runCommand(fp, "load.off", nil); err == nil || len(fp.InstCmds) != 0 {
+		t.Errorf("empty allow: runCommand = %v, InstCmds %q; want it refused", err, fp.InstCmds)
+	}
+	if err := runCommand(fp, "load.off", []string{"*"}); err != nil || len(fp.InstCmds) != 1 {
+		t.Errorf(`allow "*": runCommand = %v, InstCmds %q`, err, fp.InstCmds)
+	}
+}
+
+func TestBrokerStatus(t *testing.T) {
+	b := newBrokerStatus([]string{"tcp://a:1883", "tcp://b:1883", "tcp://c:1883"})
+	if got := b.set(2, true, "tcp://c:1883"); got != "tcp://c:1883" {
+		t.Errorf("set = %q", got)
+	}
+	if got := b.set(0, true, "tcp://a:1883"); got != "tcp://a:1883, tcp://c:1883" {
+		t.Errorf("set = %q, want the brokers in config order", got)
+	}
+	if got := b.set(2, false, "tcp://c:1883"); got != "tcp://a:1883" {
+		t.Errorf("set = %q after c disconnected", got)
+	}
+}
+
+func TestDoClients(t *testing.T) {
+	cfg := &config.Config{
+		NUT:  config.NUTConfig{UPSName: "cyberpower", ExpectedClients: []string{"10.0.0.2"}},
+		MQTT: config.MQTTConfig{TopicPrefix: "ups", Retained: true},
+	}
+	fp := &nut.FakePoller{ClientList: nut.Clients{Hosts: []string{"10.0.0.1"}, NumLogins: 1}}
+	fpub := &publisher.FakePublisher{}
+	if err := doClients(fp, fpub, cfg, newPollState()); err != nil {
+		t.Fatalf("doClients: %v", err)
+	}
+	msg, ok := fpub.Find("ups/cyberpower/clients")
+	if !ok {
+		t.Fatal("clients not published")
+	}
+	if !strings.Contains(msg.Payload, `"hosts":["10.0.0.1"]`) || !strings.Contains(msg.Payload, `"missing":["10.0.0.2"]`) {
+		t.Errorf("payload = %s", msg.Payload)
+	}
+}
+
+func TestDoClients_Errors(t *testing.T) {
+	fp := &nut.FakePoller{ClientsErr: errors.New("connection lost")}
+	if err := doClients(fp, &publisher.FakePublisher{}, testCfg, newPollState()); err == nil {
+		t.Error("expected error when LIST CLIENT fails")
+	}
+	fpub := &publisher.FakePublisher{PublishError: errors.New("broker down")}
+	if err := doClients(&nut.FakePoller{}, fpub, testCfg, newPollState()); err == nil {
+		t.Error("expected error when publishing fails")
+	}
+}
+
+func minLoginsCfg(min float64) *config.Config {
+	return &config.Config{
+		NUT:  config.NUTConfig{UPSName: "cyberpower", ClientsInterval: config.Duration{Duration: time.Minute}},
+		MQTT: config.MQTTConfig{TopicPrefix: "ups", Retained: true},
+		Alerts: []config.AlertConfig{
+			{Name: "redundancy", Type: "min_logins", Min: &min},
+		},
+	}
+}
+
+func TestDoClients_MinLoginsAlert(t *testing.T) {
+	cfg := minLoginsCfg(2)
+	st := newPollState()
+	var err error
+	if st.alerts, err = newAlertEngine(cfg); err != nil {
+		t.Fatalf("newAlertEngine: %v", err)
+	}
+	fpub := &publisher.FakePublisher{}
+
+	// NUMLOGINS unsupported: the host count is used instead.
+	fp := &nut.FakePoller{ClientList: nut.Clients{Hosts: []string{"10.0.0.1"}, NumLogins: -1}}
+	if err := doClients(fp, fpub, cfg, st); err != nil {
+		t.Fatalf("doClients: %v", err)
+	}
+	msg, ok := fpub.Find("ups/cyberpower/alerts/redundancy")
+	if !ok {
+		t.Fatal("alert not published")
+	}
+	if !strings.Contains(msg.Payload, `"active":true`) || !strings.Contains(msg.Payload, `"severity":"warning"`) {
+		t.Errorf("alert payload = %s", msg.Payload)
+	}
+
+	fpub.Reset()
+	fp.ClientList = nut.Clients{Hosts: []string{"10.0.0.1", "10.0.0.2"}, NumLogins: 2}
+	if err := doClients(fp, fpub, cfg, st); err != nil {
+		t.Fatalf("doClients: %v", err)
+	}
+	if msg, _ := fpub.Find("ups/cyberpower/alerts/redundancy"); !strings.Contains(msg.Payload, `"active":false`) {
+		t.Errorf("alert should clear, payload = %s", msg.Payload)
+	}
+}
+
+func TestDoClients_AlertPublishError_Propagated(t *testing.T) {
+	cfg := minLoginsCfg(2)
+	st := newPollState()
+	st.alerts, _ = newAlertEngine(cfg)
+	fpub := &topicFailPublisher{
+		FakePublisher: &publisher.FakePublisher{},
+		failTopic:     "ups/cyberpower/alerts/redundancy",
+	}
+	if err := doClients(&nut.FakePoller{ClientList: nut.Clients{NumLogins: 0}}, fpub, cfg, st); err == nil {
+		t.Fatal("expected error when the alert publish fails")
+	}
+}
+
+func TestNewAlertEngine(t *testing.T) {
+	if e, err := newAlertEngine(testCfg); e != nil || err != nil {
+		t.Errorf("no alerts configured: got %v, %v; want nil, nil", e, err)
+	}
+
+	cfg := minLoginsCfg(2)
+	cfg.NUT.ClientsInterval = config.Duration{} // only logs a warning
+	if _, err := newAlertEngine(cfg); err != nil {
+		t.Errorf("newAlertEngine: %v", err)
+	}
+
+	cfg.Alerts[0].Type = "nonsense"
+	if _, err := newAlertEngine(cfg); err == nil {
+		t.Error("expected error for an unknown alert type")
+	}
+}
+
+// TestReloadOnSIGHUP verifies that a signal on hup reloads the config and
+// hands it to the UPS's run.
+func TestReloadOnSIGHUP(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte("[nut]\nups_name = \"cyberpower\"\npoll_interval = \"5s\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hup := make(chan os.Signal, 1)
+	reloads := make(chan *config.Config, 1)
+	go reloadOnSIGHUP(ctx, cfg, []string{path}, hup, func(next *config.Config) { reloads <- next })
+
+	hup <- syscall.SIGHUP
+	select {
+	case got := <-reloads:
+		if got.NUT.PollInterval.Duration != 5*time.Second {
+			t.Errorf("poll interval = %s, want 5s", got.NUT.PollInterval)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reload after SIGHUP")
+	}
+}
+
+// TestPipelines_Reload verifies that with nut.ups_name = "*" each pipeline
+// gets the reloaded config for its own UPS.
+func TestPipelines_Reload(t *testing.T) {
+	cfg := &config.Config{
+		NUT:  config.NUTConfig{UPSName: "*", PollInterval: config.Duration{Duration: 5 * time.Second}},
+		MQTT: config.MQTTConfig{ClientID: "ups-mqtt"},
+	}
+	p := &pipelines{}
+	for _, name := range []string{"rack", "desk"} {
+		p.names = append(p.names, name)
+		p.reloads = append(p.reloads, make(chan *config.Config, 1))
+	}
+	p.reload(cfg)
+	for i, name := range p.names {
+		got := <-p.reloads[i]
+		if got.NUT.UPSName != name || got.MQTT.ClientID != "ups-mqtt-"+name || got.NUT.PollInterval.Duration != 5*time.Second {
+			t.Errorf("pipeline %d got NUT = %+v, ClientID = %q", i, got.NUT, got.MQTT.ClientID)
+		}
+	}
+}
+
+// TestAggregator verifies the summary across UPSes follows each pipeline's
+// polls, and leaves out a UPS whose last poll failed.
+func TestAggregator(t *testing.T) {
+	rack := &config.Config{NUT: config.NUTConfig{UPSName: "rack"}, MQTT: config.MQTTConfig{TopicPrefix: "ups", Aggregate: true}}
+	desk := &config.Config{NUT: config.NUTConfig{UPSName: "desk"}, MQTT: config.MQTTConfig{TopicPrefix: "ups", Aggregate: true}}
+	a := newAggregator()
+	a.add()
+	a.add()
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	pub := &publisher.FakePublisher{}
+	onBattery := metrics.Compute(map[string]string{"ups.status": "OB", "battery.runtime": "600"})
+	if err := a.report(&onBattery, now, pub, desk); err != nil {
+		t.Fatalf("report: %v", err)
+	}
+	onMains := metrics.Compute(map[string]string{"ups.status": "OL", "battery.runtime": "1800"})
+	if err := a.report(&onMains, now, pub, rack); err != nil {
+		t.Fatalf("report: %v", err)
+	}
+	var got publisher.AggregateMessage
+	msg, ok := pub.Find("ups/summary")
+	if !ok {
+		t.Fatal("no aggregate published")
+	}
+	if err := json.Unmarshal([]byte(msg.Payload), &got); err != nil {
+		t.Fatalf("aggregate %s: %v", msg.Payload, err)
+	}
+	if got.UPSes != 2 || got.Reporting != 1 || got.WorstUPS != "desk" || !got.AnyOnBattery {
+		t.Errorf("first aggregate = %s, want desk alone reporting, on battery", msg.Payload)
+	}
+
+	pub = &publisher.FakePublisher{}
+	if err := a.report(nil, now, pub, desk); err != nil {
+		t.Fatalf("report: %v", err)
+	}
+	msg, _ = pub.Find("ups/summary")
+	if err := json.Unmarshal([]byte(msg.Payload), &got); err != nil {
+		t.Fatalf("aggregate %s: %v", msg.Payload, err)
+	}
+	if got.Reporting != 1 || got.WorstUPS != "rack" || got.AnyOnBattery || *got.MinRuntimeMins != 30 {
+		t.Errorf("aggregate after desk failed = %s, want rack alone", msg.Payload)
+	}
+
+	desk.MQTT.Aggregate = false
+	pub = &publisher.FakePublisher{}
+	if err := a.report(&onBattery, now, pub, desk); err != nil || len(pub.Messages) != 0 {
+		t.Errorf("published %v (err %v) with mqtt.aggregate off", pub.Messages, err)
+	}
+	var none *aggregator
+	if err := none.report(&onBattery, now, pub, desk); err != nil {
+		t.Errorf("nil aggregator: %v", err)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	cur := &config.Config{
+		NUT:  config.NUTConfig{Host: "nas", UPSName: "cyberpower", PollInterval: config.Duration{Duration: 30 * time.Second}},
+		MQTT: config.MQTTConfig{Broker: "tcp://a:1883", TopicPrefix: "ups"},
+	}
+	next := &config.Config{
+		NUT:    config.NUTConfig{Host: "nas", UPSName: "cyberpower", PollInterval: config.Duration{Duration: 5 * time.Second}},
+		MQTT:   config.MQTTConfig{Broker: "tcp://b:1883", TopicPrefix: "home/ups"},
+		Filter: config.FilterConfig{Enabled: true, Mode: "clamp"},
+		Labels: map[string]string{"site": "lon1"},
+	}
+	got, restart := reloadConfig(cur, next)
+	if got.NUT.PollInterval.Duration != 5*time.Second || !got.Filter.Enabled || got.Labels["site"] != "lon1" {
+		t.Errorf("live settings not applied: %+v", got)
+	}
+	if got.MQTT.Broker != "tcp://a:1883" || got.MQTT.TopicPrefix != "ups" {
+		t.Errorf("broker, prefix = %q, %q, want the running ones kept", got.MQTT.Broker, got.MQTT.TopicPrefix)
+	}
+	if len(restart) != 1 || restart[0] != "mqtt" {
+		t.Errorf("restart = %v, want [mqtt]", restart)
+	}
+	if cur.NUT.PollInterval.Duration != 30*time.Second {
+		t.Error("reloadConfig modified cur")
+	}
+
+	next.MQTT.Broker = cur.MQTT.Broker
+	if _, restart := reloadConfig(cur, next); len(restart) != 1 || restart[0] != "mqtt" {
+		t.Errorf("restart = %v, want [mqtt] for the topic prefix", restart)
+	}
+	next.MQTT.TopicPrefix = cur.MQTT.TopicPrefix
+	if _, restart := reloadConfig(cur, next); len(restart) != 0 {
+		t.Errorf("restart = %v, want none", restart)
+	}
+}
+
+func TestPollState_ConfigureKeepsUnchangedAlerts(t *testing.T) {
+	cfg := minLoginsCfg(2)
+	st := newPollState()
+	if err := st.configure(nil, cfg); err != nil {
+		t.Fatalf("configure: %v", err)
+	}
+	engine := st.alerts
+
+	next := *cfg
+	next.Notifications.QuietHours = "22:00-07:00"
+	if err := st.configure(cfg, &next); err != nil {
+		t.Fatalf("configure: %v", err)
+	}
+	if st.alerts != engine {
+		t.Error("alert engine rebuilt although the rules didn't change")
+	}
+	if st.quietHours == nil {
+		t.Error("quiet hours not applied")
+	}
+
+	rules := append([]config.AlertConfig(nil), cfg.Alerts...)
+	rules[0].Type = "nonsense"
+	broken := next
+	broken.Alerts = rules
+	if err := st.configure(&next, &broken); err == nil || st.alerts != engine {
+		t.Errorf("configure = %v; want an error and the old engine kept", err)
+	}
+}
+
+func TestDoPoll_ChargeRate_PublishedAndAlerts(t *testing.T) {
+	min := -0.5
+	cfg := &config.Config{
+		NUT:     config.NUTConfig{UPSName: "cyberpower"},
+		MQTT:    config.MQTTConfig{TopicPrefix: "ups", Retained: true},
+		Metrics: config.MetricsConfig{ChargeRateWindow: config.Duration{Duration: 5 * time.Minute}},
+		Alerts: []config.AlertConfig{
+			{Name: "fast_discharge", Type: "charge_rate", Min: &min, When: "discharging"},
+		},
+	}
+	st := newPollState()
+	var err error
+	if st.alerts, err = newAlertEngine(cfg); err != nil {
+		t.Fatalf("newAlertEngine: %v", err)
+	}
+	fp := &nut.FakePoller{Sequence: [][]nut.Variable{
+		{{Name: "ups.status", Value: "OB DISCHRG"}, {Name: "battery.charge", Value: "100"}},
+		{{Name: "ups.status", Value: "OB DISCHRG"}, {Name: "battery.charge", Value: "90"}},
+	}}
+	fpub := &publisher.FakePublisher{}
+
+	if err := doPoll(fp, fpub, cfg, st); err != nil {
+		t.Fatalf("poll 1: %v", err)
+	}
+	if _, ok := fpub.Find("ups/cyberpower/computed/battery_charge_rate"); ok {
+		t.Error("charge rate needs two samples")
+	}
+
+	// Fake the elapsed time between polls rather than sleeping.
+	st.chargeRate.Reset()
+	st.chargeRate.Add(100, time.Now().Add(-time.Minute))
+	fpub.Reset()
+	if err := doPoll(fp, fpub, cfg, st); err != nil {
+		t.Fatalf("poll 2: %v", err)
+	}
+	msg, ok := fpub.Find("ups/cyberpower/computed/battery_charge_rate")
+	if got, err := strconv.ParseFloat(msg.Payload, 64); !ok || err != nil || got > -9.9 || got < -10.1 {
+		t.Errorf("battery_charge_rate = %+v, want ≈ -10 %%/min", msg)
+	}
+	if msg, _ := fpub.Find("ups/cyberpower/alerts/fast_discharge"); !strings.Contains(msg.Payload, `"active":true`) {
+		t.Errorf("fast_discharge alert = %q", msg.Payload)
+	}
+}
+
+func TestDoPoll_EstimatedRuntime(t *testing.T) {
+	cfg := &config.Config{
+		NUT:     config.NUTConfig{UPSName: "cyberpower"},
+		MQTT:    config.MQTTConfig{TopicPrefix: "ups", Retained: true},
+		Metrics: config.MetricsConfig{RuntimeWindow: config.Duration{Duration: 10 * time.Minute}},
+	}
+	st := newPollState()
+	fp := &nut.FakePoller{Sequence: [][]nut.Variable{
+		{{Name: "ups.status", Value: "OB DISCHRG"}, {Name: "battery.charge", Value: "90"}},
+		{{Name: "ups.status", Value: "OL CHRG"}, {Name: "battery.charge", Value: "90"}},
+		{{Name: "ups.status", Value: "OL CHRG"}, {Name: "battery.charge", Value: "91"}},
+	}}
+	fpub := &publisher.FakePublisher{}
+
+	// Fake an earlier reading rather than sleeping: 100 % two minutes ago.
+	st.runtime.Window = 10 * time.Minute
+	st.runtime.Add(100, time.Now().Add(-2*time.Minute))
+	if err := doPoll(fp, fpub, cfg, st); err != nil {
+		t.Fatalf("poll 1: %v", err)
+	}
+	msg, ok := fpub.Find("ups/cyberpower/computed/estimated_runtime_mins")
+	if got, err := strconv.ParseFloat(msg.Payload, 64); !ok || err != nil || got < 17.9 || got > 18.1 {
+		t.Errorf("estimated_runtime_mins = %+v, want ≈ 18 (90 %% at 5 %%/min)", msg)
+	}
+
+	// Back on mains the retained estimate is cleared, once.
+	for poll := 2; poll <= 3; poll++ {
+		fpub.Reset()
+		if err := doPoll(fp, fpub, cfg, st); err != nil {
+			t.Fatalf("poll %d: %v", poll, err)
+		}
+		msg, ok := fpub.Find("ups/cyberpower/computed/estimated_runtime_mins")
+		if poll == 2 && (!ok || msg.Payload != "" || !msg.Retained) {
+			t.Errorf("poll 2: estimate = %+v, %v; want a retained empty payload", msg, ok)
+		}
+		if poll == 3 && ok {
+			t.Errorf("poll 3: estimate published again: %+v", msg)
+		}
+	}
+}
+
+func TestDoPoll_RuntimeSmoothed(t *testing.T) {
+	cfg := &config.Config{
+		NUT:     config.NUTConfig{UPSName: "cyberpower"},
+		MQTT:    config.MQTTConfig{TopicPrefix: "ups"},
+		Metrics: config.MetricsConfig{RuntimeSmoothing: config.Duration{Duration: 5 * time.Minute}},
+	}
+	clk := clock.NewFake(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	st := newPollStateAt(clk)
+	fpub := &publisher.FakePublisher{}
+	smoothed := func(runtime string) string {
+		t.Helper()
+		fpub.Reset()
+		vars := []nut.Variable{{Name: "ups.status", Value: "OB DISCHRG"}, {Name: "battery.runtime", Value: runtime}}
+		if err := doPoll(&nut.FakePoller{Variables: vars}, fpub, cfg, st); err != nil {
+			t.Fatalf("doPoll: %v", err)
+		}
+		msg, _ := fpub.Find("ups/cyberpower/computed/battery_runtime_mins_smoothed")
+		return msg.Payload
+	}
+
+	if got := smoothed("3600"); got != "60" {
+		t.Errorf("first poll = %q, want 60, the reading itself", got)
+	}
+	// A jump to 20 min one time constant later moves it 1 − 1/e of the way.
+	clk.Advance(5 * time.Minute)
+	if got := smoothed("1200"); got != "34.72" {
+		t.Errorf("after the jump = %q, want 34.72", got)
+	}
+	if got := smoothed(""); got != "" {
+		t.Errorf("without battery.runtime = %q, want nothing published", got)
+	}
+
+	fail := &topicFailPublisher{
+		FakePublisher: &publisher.FakePublisher{},
+		failTopic:     "ups/cyberpower/computed/battery_runtime_mins_smoothed",
+	}
+	if err := doPoll(&nut.FakePoller{Variables: sampleVars}, fail, cfg, st); err == nil {
+		t.Fatal("expected error when the smoothed runtime publish fails")
+	}
+}
+
+func TestDoPoll_ChargeRatePublishError_Propagated(t *testing.T) {
+	cfg := &config.Config{
+		NUT:     config.NUTConfig{UPSName: "cyberpower"},
+		MQTT:    config.MQTTConfig{TopicPrefix: "ups"},
+		Metrics: config.MetricsConfig{ChargeRateWindow: config.Duration{Duration: time.Minute}},
+	}
+	st := newPollState()
+	st.chargeRate.Window = time.Minute
+	st.chargeRate.Add(100, time.Now().Add(-time.Minute))
+	fpub := &topicFailPublisher{
+		FakePublisher: &publisher.FakePublisher{},
+		failTopic:     "ups/cyberpower/computed/battery_charge_rate",
+	}
+	if err := doPoll(&nut.FakePoller{Variables: sampleVars}, fpub, cfg, st); err == nil {
+		t.Fatal("expected error when the charge rate publish fails")
+	}
+}
+
+func TestDoPoll_AlertPublishError_Propagated(t *testing.T) {
+	max := 0.0
+	cfg := &config.Config{
+		NUT:     config.NUTConfig{UPSName: "cyberpower"},
+		MQTT:    config.MQTTConfig{TopicPrefix: "ups"},
+		Metrics: config.MetricsConfig{ChargeRateWindow: config.Duration{Duration: time.Minute}},
+		Alerts:  []config.AlertConfig{{Name: "charging", Type: "charge_rate", Max: &max}},
+	}
+	st := newPollState()
+	st.alerts, _ = newAlertEngine(cfg)
+	st.chargeRate.Window = time.Minute
+	st.chargeRate.Add(50, time.Now().Add(-time.Minute))
+	fpub := &topicFailPublisher{
+		FakePublisher: &publisher.FakePublisher{},
+		failTopic:     "ups/cyberpower/alerts/charging",
+	}
+	if err := doPoll(&nut.FakePoller{Variables: sampleVars}, fpub, cfg, st); err == nil {
+		t.Fatal("expected error when the alert publish fails")
+	}
+}
+
+func notifyCfg() *config.Config {
+	return &config.Config{
+		NUT:           config.NUTConfig{UPSName: "cyberpower"},
+		MQTT:          config.MQTTConfig{TopicPrefix: "ups"},
+		Notifications: config.NotificationsConfig{Enabled: true, MuteBeeper: true},
+	}
+}
+
+var lowBatteryVars = []nut.Variable{
+	{Name: "ups.status", Value: "OB DISCHRG LB"},
+	{Name: "battery.charge", Value: "9"},
+}
+
+func TestDoPoll_Notifications_DisabledByDefault(t *testing.T) {
+	fp := &nut.FakePoller{Sequence: [][]nut.Variable{sampleVars, onBatteryVars}}
+	fpub := &publisher.FakePublisher{}
+	st := newPollState()
+	for i := 0; i < 2; i++ {
+		if err := doPoll(fp, fpub, testCfg, st); err != nil {
+			t.Fatalf("poll %d: %v", i+1, err)
+		}
+	}
+	if _, ok := fpub.Find("ups/cyberpower/notify"); ok {
+		t.Error("notify topic published with notifications disabled")
+	}
+}
+
+func TestDoPoll_Notifications_StatusEvents(t *testing.T) {
+	fp := &nut.FakePoller{Sequence: [][]nut.Variable{sampleVars, onBatteryVars}}
+	fpub := &publisher.FakePublisher{}
+	st := newPollState()
+	if err := doPoll(fp, fpub, notifyCfg(), st); err != nil {
+		t.Fatalf("poll 1: %v", err)
+	}
+	if _, ok := fpub.Find("ups/cyberpower/notify"); ok {
+		t.Error("first poll should not notify")
+	}
+	if err := doPoll(fp, fpub, notifyCfg(), st); err != nil {
+		t.Fatalf("poll 2: %v", err)
+	}
+	msg, ok := fpub.Find("ups/cyberpower/notify")
+	if !ok || !strings.Contains(msg.Payload, `"event":"on_battery","severity":"warning"`) {
+		t.Errorf("notify = %+v, want on_battery warning", msg)
+	}
+	if len(fp.InstCmds) != 0 {
+		t.Errorf("InstCmds = %q without quiet hours", fp.InstCmds)
+	}
+}
+
+func TestDoPoll_LowBatteryThresholds(t *testing.T) {
+	belowLow := []nut.Variable{
+		{Name: "ups.status", Value: "OB DISCHRG"},
+		{Name: "battery.charge", Value: "18"},
+		{Name: "battery.charge.low", Value: "20"},
+	}
+	fp := &nut.FakePoller{Sequence: [][]nut.Variable{onBatteryVars, belowLow}}
+	fpub := &publisher.FakePublisher{}
+	cfg := notifyCfg()
+	cfg.LowBattery.Thresholds = true
+	st := newPollState()
+	if err := doPoll(fp, fpub, cfg, st); err != nil {
+		t.Fatalf("poll 1: %v", err)
+	}
+	fpub.Reset()
+	if err := doPoll(fp, fpub, cfg, st); err != nil {
+		t.Fatalf("poll 2: %v", err)
+	}
+	if msg, ok := fpub.Find("ups/cyberpower/computed/low_battery"); !ok || msg.Payload != "true" {
+		t.Errorf("low_battery = %+v, want true below battery.charge.low", msg)
+	}
+	if msg, ok := fpub.Find("ups/cyberpower/notify"); !ok || !strings.Contains(msg.Payload, `"event":"low_battery"`) {
+		t.Errorf("notify = %+v, want low_battery", msg)
+	}
+	if msg, ok := fpub.Find("ups/cyberpower/ups/status"); !ok || msg.Payload != "OB DISCHRG" {
+		t.Errorf("ups/status = %+v, want the UPS's own status untouched", msg)
+	}
+}
+
+func TestSetLowThresholds(t *testing.T) {
+	fp := &nut.FakePoller{}
+	cfg := &config.Config{LowBattery: config.LowBatteryConfig{Charge: 25, Runtime: config.Duration{Duration: 3 * time.Minute}}}
+	setLowThresholds(fp, cfg)
+	if want := []string{"battery.charge.low=25", "battery.runtime.low=180"}; !slices.Equal(fp.SetVars, want) {
+		t.Errorf("SetVars = %q, want %q", fp.SetVars, want)
+	}
+
+	fp = &nut.FakePoller{SetVarErr: errors.New("ACCESS-DENIED")}
+	setLowThresholds(fp, &config.Config{})
+	if len(fp.SetVars) != 0 {
+		t.Errorf("SetVars = %q, want nothing written when unset", fp.SetVars)
+	}
+}
+
+func TestDoPoll_Summary(t *testing.T) {
+	fp := &nut.FakePoller{Sequence: [][]nut.Variable{sampleVars, onBatteryVars}}
+	fpub := &publisher.FakePublisher{}
+	cfg := notifyCfg()
+	cfg.Summary = config.SummaryConfig{Time: "00:00", Notify: true}
+	st := newPollState()
+	if err := st.configure(nil, cfg); err != nil {
+		t.Fatalf("configure: %v", err)
+	}
+	if err := doPoll(fp, fpub, cfg, st); err != nil {
+		t.Fatalf("poll 1: %v", err)
+	}
+	if _, ok := fpub.Find("ups/cyberpower/summary"); ok {
+		t.Fatal("summary published before it was due")
+	}
+
+	st.summaryDue = time.Now()
+	if err := doPoll(fp, fpub, cfg, st); err != nil {
+		t.Fatalf("poll 2: %v", err)
+	}
+	msg, ok := fpub.Find("ups/cyberpower/summary")
+	if !ok || !strings.Contains(msg.Payload, `"polls":1,`) || !strings.Contains(msg.Payload, `"outages":0`) {
+		t.Errorf("summary = %+v, want the first poll's digest", msg)
+	}
+	var notified bool
+	for _, m := range fpub.Messages {
+		notified = notified || (m.Topic == "ups/cyberpower/notify" && strings.Contains(m.Payload, `"event":"daily_summary","severity":"info"`))
+	}
+	if !notified {
+		t.Error("daily_summary not sent to the notify topic")
+	}
+	if !st.summaryDue.After(time.Now()) {
+		t.Errorf("next summary due %s, want it rescheduled", st.summaryDue)
+	}
+}
+
+func TestDoPoll_OutageHistory(t *testing.T) {
+	fp := &nut.FakePoller{Sequence: [][]nut.Variable{sampleVars, onBatteryVars, sampleVars}}
+	fpub := &publisher.FakePublisher{}
+	cfg := *testCfg
+	cfg.Outages.Dir = t.TempDir()
+	st := newPollState()
+
+	if err := doPoll(fp, fpub, &cfg, st); err != nil {
+		t.Fatalf("poll 1: %v", err)
+	}
+	if msg, ok := fpub.Find("ups/cyberpower/outages/stats"); !ok || !strings.Contains(msg.Payload, `"outages":0,`) {
+		t.Fatalf("stats = %+v, want them published with the first poll", msg)
+	}
+	if msg, ok := fpub.Find("ups/cyberpower/computed/outage_count"); !ok || msg.Payload != "0" {
+		t.Errorf("outage_count = %+v, want 0", msg)
+	}
+	if _, ok := fpub.Find("ups/cyberpower/computed/last_outage_duration_secs"); ok {
+		t.Error("last_outage_duration_secs published before any outage")
+	}
+	fpub.Reset()
+	if err := doPoll(fp, fpub, &cfg, st); err != nil {
+		t.Fatalf("poll 2: %v", err)
+	}
+	if _, ok := fpub.Find("ups/cyberpower/outages/stats"); ok {
+		t.Error("stats republished while the outage is still on")
+	}
+	if msg, ok := fpub.Find("ups/cyberpower/computed/time_since_last_outage_secs"); !ok || msg.Payload != "0" {
+		t.Errorf("time_since_last_outage_secs = %+v, want 0 during an outage", msg)
+	}
+	fpub.Reset()
+	if err := doPoll(fp, fpub, &cfg, st); err != nil {
+		t.Fatalf("poll 3: %v", err)
+	}
+	if msg, ok := fpub.Find("ups/cyberpower/outages/stats"); !ok || !strings.Contains(msg.Payload, `"outages":1,`) {
+		t.Errorf("stats = %+v, want the outage counted once it's over", msg)
+	}
+	if msg, ok := fpub.Find("ups/cyberpower/computed/outage_count"); !ok || msg.Payload != "1" {
+		t.Errorf("outage_count = %+v, want the outage counted once it's over", msg)
+	}
+	data, err := os.ReadFile(filepath.Join(cfg.Outages.Dir, "outages_cyberpower.json"))
+	if err != nil || !strings.Contains(string(data), `"start"`) {
+		t.Errorf("history file = %s, %v; want the outage recorded", data, err)
+	}
+
+	// A restart reads the counters back from the history.
+	fpub.Reset()
+	fp = &nut.FakePoller{Sequence: [][]nut.Variable{sampleVars}}
+	if err := doPoll(fp, fpub, &cfg, newPollState()); err != nil {
+		t.Fatalf("poll after restart: %v", err)
+	}
+	if msg, ok := fpub.Find("ups/cyberpower/computed/outage_count"); !ok || msg.Payload != "1" {
+		t.Errorf("outage_count after restart = %+v, want 1", msg)
+	}
+	if _, ok := fpub.Find("ups/cyberpower/computed/last_outage_duration_secs"); !ok {
+		t.Error("last_outage_duration_secs not published after restart")
+	}
+}
+
+func TestDoPoll_QuietHours_OnlyCriticalAndMutesBeeper(t *testing.T) {
+	fp := &nut.FakePoller{Sequence: [][]nut.Variable{sampleVars, onBatteryVars, lowBatteryVars}}
+	fpub := &publisher.FakePublisher{}
+	st := newPollState()
+	st.quietHours = &schedule.Window{Start: 0, End: 24 * 60} // all day
+
+	for i := 1; i <= 2; i++ {
+		if err := doPoll(fp, fpub, notifyCfg(), st); err != nil {
+			t.Fatalf("poll %d: %v", i, err)
+		}
+	}
+	if _, ok := fpub.Find("ups/cyberpower/notify"); ok {
+		t.Error("warning on_battery should be held back during quiet hours")
+	}
+	if len(fp.InstCmds) != 1 || fp.InstCmds[0] != "beeper.disable" {
+		t.Errorf("InstCmds = %q, want [beeper.disable]", fp.InstCmds)
+	}
+
+	if err := doPoll(fp, fpub, notifyCfg(), st); err != nil {
+		t.Fatalf("poll 3: %v", err)
+	}
+	if msg, ok := fpub.Find("ups/cyberpower/notify"); !ok || !strings.Contains(msg.Payload, `"event":"low_battery","severity":"critical"`) {
+		t.Errorf("notify = %+v, want critical low_battery during quiet hours", msg)
+	}
+
+	st.quietHours = &schedule.Window{} // quiet hours over
+	if err := doPoll(fp, fpub, notifyCfg(), st); err != nil {
+		t.Fatalf("poll 4: %v", err)
+	}
+	if len(fp.InstCmds) != 2 || fp.InstCmds[1] != "beeper.enable" {
+		t.Errorf("InstCmds = %q, want beeper.enable after quiet hours", fp.InstCmds)
+	}
+}
+
+func TestDoPoll_QuietHours_BeeperErrorOnlyLogged(t *testing.T) {
+	fp := &nut.FakePoller{Variables: sampleVars, InstCmdErr: errors.New("CMD-NOT-SUPPORTED")}
+	st := newPollState()
+	st.quietHours = &schedule.Window{Start: 0, End: 24 * 60}
+	if err := doPoll(fp, &publisher.FakePublisher{}, notifyCfg(), st); err != nil {
+		t.Fatalf("doPoll: %v", err)
+	}
+	if !st.quiet {
+		t.Error("quiet hours should be tracked despite the beeper error")
+	}
+}
+
+func TestDoClients_AlertNotification(t *testing.T) {
+	cfg := minLoginsCfg(2)
+	cfg.Notifications.Enabled = true
+	st := newPollState()
+	st.alerts, _ = newAlertEngine(cfg)
+	fpub := &publisher.FakePublisher{}
+	lister := &nut.FakePoller{ClientList: nut.Clients{Hosts: []string{"10.0.0.5"}, NumLogins: 1}}
+	if err := doClients(lister, fpub, cfg, st); err != nil {
+		t.Fatalf("doClients: %v", err)
+	}
+	if msg, ok := fpub.Find("ups/cyberpower/notify"); !ok || !strings.Contains(msg.Payload, `"event":"redundancy"`) {
+		t.Errorf("notify = %+v, want redundancy alert", msg)
+	}
+}
+
+func TestDoPoll_NotificationPublishError_Propagated(t *testing.T) {
+	fp := &nut.FakePoller{Sequence: [][]nut.Variable{sampleVars, onBatteryVars}}
+	fpub := &topicFailPublisher{
+		FakePublisher: &publisher.FakePublisher{},
+		failTopic:     "ups/cyberpower/notify",
+	}
+	st := newPollState()
+	if err := doPoll(fp, fpub, notifyCfg(), st); err != nil {
+		t.Fatalf("poll 1: %v", err)
+	}
+	if err := doPoll(fp, fpub, notifyCfg(), st); err == nil {
+		t.Fatal("expected error when the notification publish fails")
+	}
+}
+
+// clockVars returns sampleVars plus ups.date/ups.time reading t.
+func clockVars(t time.Time) []nut.Variable {
+	t = t.In(time.Local)
+	return append(append([]nut.Variable{}, sampleVars...),
+		nut.Variable{Name: "ups.date", Value: t.Format("2006/01/02")},
+		nut.Variable{Name: "ups.time", Value: t.Format("15:04:05")},
+	)
+}
+
+func TestDoPoll_ClockSkew(t *testing.T) {
+	cfg := &config.Config{
+		NUT:  config.NUTConfig{UPSName: "cyberpower", ClockSkewThreshold: config.Duration{Duration: time.Minute}},
+		MQTT: config.MQTTConfig{TopicPrefix: "ups"},
+	}
+	fpub := &publisher.FakePublisher{}
+	st := newPollState()
+
+	fp := &nut.FakePoller{Variables: clockVars(time.Now().Add(10 * time.Minute))}
+	if err := doPoll(fp, fpub, cfg, st); err != nil {
+		t.Fatalf("doPoll: %v", err)
+	}
+	msg, _ := fpub.Find("ups/cyberpower/bridge")
+	var stats publisher.BridgeStats
+	if err := json.Unmarshal([]byte(msg.Payload), &stats); err != nil {
+		t.Fatalf("bridge payload %q: %v", msg.Payload, err)
+	}
+	if !stats.ClockSkewed || stats.ClockSkewSecs == nil || *stats.ClockSkewSecs < 598 || *stats.ClockSkewSecs > 601 {
+		t.Errorf("bridge = %s, want ~600s skew flagged", msg.Payload)
+	}
+	if !st.clockSkewed {
+		t.Error("clockSkewed should be set")
+	}
+
+	fpub.Reset()
+	fp.Variables = clockVars(time.Now().Add(-20 * time.Second))
+	if err := doPoll(fp, fpub, cfg, st); err != nil {
+		t.Fatalf("doPoll: %v", err)
+	}
+	if msg, _ := fpub.Find("ups/cyberpower/bridge"); !strings.Contains(msg.Payload, `"clock_skewed":false`) || !strings.Contains(msg.Payload, `"clock_skew_secs":-`) {
+		t.Errorf("bridge = %+v, want small negative skew not flagged", msg)
+	}
+	if st.clockSkewed {
+		t.Error("clockSkewed should be cleared")
+	}
+}
+
+func TestDoPoll_ClockSkew_NoUPSClock(t *testing.T) {
+	cfg := &config.Config{
+		NUT:  config.NUTConfig{UPSName: "cyberpower", ClockSkewThreshold: config.Duration{Duration: time.Minute}},
+		MQTT: config.MQTTConfig{TopicPrefix: "ups"},
+	}
+	fpub := &publisher.FakePublisher{}
+	if err := doPoll(&nut.FakePoller{Variables: sampleVars}, fpub, cfg, newPollState()); err != nil {
+		t.Fatalf("doPoll: %v", err)
+	}
+	msg, ok := fpub.Find("ups/cyberpower/bridge")
+	if !ok || strings.Contains(msg.Payload, "clock_skew_secs") {
+		t.Errorf("bridge = %+v, want stats without a skew reading", msg)
+	}
+}
+
+func TestDoPoll_ClockSkew_Disabled(t *testing.T) {
+	fpub := &publisher.FakePublisher{}
+	if err := doPoll(&nut.FakePoller{Variables: clockVars(time.Now())}, fpub, testCfg, newPollState()); err != nil {
+		t.Fatalf("doPoll: %v", err)
+	}
+	if _, ok := fpub.Find("ups/cyberpower/bridge"); ok {
+		t.Error("bridge stats should not be published without clock_skew_threshold or skipped polls")
+	}
+}
+
+func TestDoPoll_PollTimings(t *testing.T) {
+	cfg := *testCfg
+	cfg.Diagnostics.PollTimings = true
+	fp := &nut.FakePoller{Variables: sampleVars, Timings: nut.Timings{
+		Connect: 1234 * time.Microsecond,
+		GetVars: 41720 * time.Microsecond,
+		Total:   47 * time.Millisecond,
+	}}
+	fpub := &publisher.FakePublisher{}
+	if err := doPoll(fp, fpub, &cfg, newPollState()); err != nil {
+		t.Fatalf("doPoll: %v", err)
+	}
+	msg, _ := fpub.Find("ups/cyberpower/bridge")
+	want := `"poll_timings_ms":{"connect":1.2,"auth":0,"status":0,"list_ups":0,"get_vars":41.7,"total":47}`
+	if !strings.Contains(msg.Payload, want) {
+		t.Errorf("bridge = %s, want %s", msg.Payload, want)
+	}
+
+	fpub = &publisher.FakePublisher{}
+	if err := doPoll(fp, fpub, testCfg, newPollState()); err != nil {
+		t.Fatalf("doPoll: %v", err)
+	}
+	if _, ok := fpub.Find("ups/cyberpower/bridge"); ok {
+		t.Error("bridge stats should not be published with poll_timings off")
+	}
+}
+
+func TestRepeatWriter(t *testing.T) {
+	var buf strings.Builder
+	w := newRepeatWriter(&buf)
+	for _, line := range []string{
+		"2026/03/01 12:00:00 poll error: connection refused\n",
+		"2026/03/01 12:00:30 poll error: connection refused\n",
+		"2026/03/01 12:01:00 poll error: connection refused\n",
+		"2026/03/01 12:01:30 connected to NUT at nas:3493\n",
+		"2026/03/01 12:02:00 poll error: connection refused\n",
+	} {
+		if n, err := w.Write([]byte(line)); err != nil || n != len(line) {
+			t.Fatalf("Write = %d, %v", n, err)
+		}
+	}
+	want := "2026/03/01 12:00:00 poll error: connection refused\n" +
+		"2026/03/01 12:01:00 last message repeated 2 times\n" +
+		"2026/03/01 12:01:30 connected to NUT at nas:3493\n" +
+		"2026/03/01 12:02:00 poll error: connection refused\n"
+	if buf.String() != want {
+		t.Errorf("written:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+// TestUpdateChecker verifies a release from the feed reaches every
+// subscriber and is published only when newer than the running bridge.
+func TestUpdateChecker(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"tag_name":"v1.4.0"}`)) //nolint:errcheck
+	}))
+	defer srv.Close()
+	defer func(v string) { version = v }(version)
+	version = "v1.3.2"
+
+	u := &updateChecker{}
+	first := u.subscribe()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go u.run(ctx, srv.Client(), clock.Real, config.UpdateCheckConfig{URL: srv.URL, Interval: config.Duration{Duration: time.Hour}})
+	select {
+	case got := <-first:
+		if got != "v1.4.0" {
+			t.Errorf("latest = %q, want v1.4.0", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no release from the feed")
+	}
+	if got := <-u.subscribe(); got != "v1.4.0" {
+		t.Errorf("a later subscriber got %q, want v1.4.0 straight away", got)
+	}
+
+	cfg := &config.Config{NUT: config.NUTConfig{UPSName: "cyberpower"}, MQTT: config.MQTTConfig{TopicPrefix: "ups"}}
+	pub := &publisher.FakePublisher{}
+	if err := publishUpdate("v1.4.0", pub, cfg); err != nil {
+		t.Fatalf("publishUpdate: %v", err)
+	}
+	if msg, ok := pub.Find("ups/cyberpower/bridge/update_available"); !ok || msg.Payload != "v1.4.0" {
+		t.Errorf("update_available = %+v, want v1.4.0", msg)
+	}
+	version = "v1.4.0"
+	pub = &publisher.FakePublisher{}
+	if err := publishUpdate("v1.4.0", pub, cfg); err != nil {
+		t.Fatalf("publishUpdate: %v", err)
+	}
+	if msg, ok := pub.Find("ups/cyberpower/bridge/update_available"); !ok || msg.Payload != "" {
+		t.Errorf("update_available = %+v, want it cleared when up to date", msg)
+	}
+	var off *updateChecker
+	if off.subscribe() != nil {
+		t.Error("a nil updateChecker should hand out a nil channel")
+	}
+}
+
+func TestAnnounceOnline(t *testing.T) {
+	st := newPollState()
+	fpub := &publisher.FakePublisher{}
+	if err := announceOnline(fpub, testCfg, st); err != nil || len(fpub.Messages) != 0 {
+		t.Fatalf("before the first poll: published %d messages (err %v), want none", len(fpub.Messages), err)
+	}
+
+	if err := doPoll(&nut.FakePoller{Variables: sampleVars}, &publisher.FakePublisher{}, testCfg, st); err != nil {
+		t.Fatalf("doPoll: %v", err)
+	}
+	polled := st.lastPolled.UTC().Format(time.RFC3339)
+	if err := announceOnline(fpub, testCfg, st); err != nil {
+		t.Fatalf("announceOnline: %v", err)
+	}
+	msg, ok := fpub.Find("ups/cyberpower/state")
+	if !ok || !strings.Contains(msg.Payload, `"timestamp":"`+polled+`"`) {
+		t.Errorf("state = %s, want it republished with the poll's timestamp %s", msg.Payload, polled)
+	}
+	if _, ok := fpub.Find("ups/cyberpower/battery/charge"); ok {
+		t.Error("variables should only be republished with republish_on_connect")
+	}
+
+	cfg := *testCfg
+	cfg.MQTT.RepublishOnConnect = true
+	fpub = &publisher.FakePublisher{}
+	if err := announceOnline(fpub, &cfg, st); err != nil {
+		t.Fatalf("announceOnline: %v", err)
+	}
+	if _, ok := fpub.Find("ups/cyberpower/battery/charge"); !ok {
+		t.Error("variables should be republished with republish_on_connect")
+	}
+
+	doPoll(&nut.FakePoller{Err: errors.New("connection lost")}, &publisher.FakePublisher{}, testCfg, st) //nolint:errcheck
+	fpub = &publisher.FakePublisher{}
+	if err := announceOnline(fpub, testCfg, st); err != nil || len(fpub.Messages) != 0 {
+		t.Errorf("after a failed poll: published %d messages (err %v), want none", len(fpub.Messages), err)
+	}
+}
+
+func TestDoPoll_Telemetry(t *testing.T) {
+	cfg := *testCfg
+	cfg.Diagnostics.Telemetry = true
+	st := newPollState()
+	st.brokers = newBrokerStatus([]string{"tcp://mq1:1883"})
+	st.brokers.set(0, true, "mq1:1883")
+	st.brokers.set(0, false, "")
+	st.brokers.set(0, true, "mq1:1883")
+
+	fpub := &publisher.FakePublisher{}
+	doPoll(&nut.FakePoller{Err: errors.New("connection lost")}, fpub, &cfg, st) //nolint:errcheck
+	msg, ok := fpub.Find("ups/cyberpower/bridge")
+	if !ok || !strings.Contains(msg.Payload, `"polls":1,"failed_polls":1,"consecutive_failures":1,`) {
+		t.Errorf("bridge after a failed poll = %s", msg.Payload)
+	}
+	doPoll(&nut.FakePoller{Err: errors.New("connection lost")}, fpub, &cfg, st) //nolint:errcheck
+	fpub = &publisher.FakePublisher{}
+	if err := doPoll(&nut.FakePoller{Variables: sampleVars}, fpub, &cfg, st); err != nil {
+		t.Fatalf("doPoll: %v", err)
+	}
+	var stats publisher.BridgeStats
+	msg, _ = fpub.Find("ups/cyberpower/bridge")
+	if err := json.Unmarshal([]byte(msg.Payload), &stats); err != nil {
+		t.Fatalf("bridge is not JSON: %v\n%s", err, msg.Payload)
+	}
+	if tm := stats.Telemetry; tm == nil || tm.Polls != 3 || tm.FailedPolls != 2 || tm.ConsecutiveFailures != 0 ||
+		tm.MQTTReconnects != 1 || tm.Version != "devel" {
+		t.Errorf("telemetry = %+v, want 3 polls, 2 failed, none failing now, 1 reconnect, version devel", tm)
+	}
+}
+
+func TestDoPoll_ClockSkew_PublishError_Propagated(t *testing.T) {
+	cfg := &config.Config{
+		NUT:  config.NUTConfig{UPSName: "cyberpower", ClockSkewThreshold: config.Duration{Duration: time.Minute}},
+		MQTT: config.MQTTConfig{TopicPrefix: "ups"},
+	}
+	fpub := &topicFailPublisher{
+		FakePublisher: &publisher.FakePublisher{},
+		failTopic:     "ups/cyberpower/bridge",
+	}
+	if err := doPoll(&nut.FakePoller{Variables: clockVars(time.Now())}, fpub, cfg, newPollState()); err == nil {
+		t.Fatal("expected error when the bridge stats publish fails")
+	}
+}
+
+func TestDoPoll_Efficiency(t *testing.T) {
+	vars := append([]nut.Variable{{Name: "input.realpower", Value: "80"}}, sampleVars...)
+	fpub := &publisher.FakePublisher{}
+	if err := doPoll(&nut.FakePoller{Variables: vars}, fpub, testCfg, newPollState()); err != nil {
+		t.Fatalf("doPoll: %v", err)
+	}
+	if msg, _ := fpub.Find("ups/cyberpower/computed/efficiency_pct"); msg.Payload != "90" {
+		t.Errorf("efficiency_pct = %q, want 90 (72 W out of 80 W in)", msg.Payload)
+	}
+	if msg, _ := fpub.Find("ups/cyberpower/computed/wasted_watts"); msg.Payload != "8" {
+		t.Errorf("wasted_watts = %q, want 8", msg.Payload)
+	}
+}
+
+func TestDoPoll_Efficiency_Curve(t *testing.T) {
+	cfg := &config.Config{
+		NUT:     config.NUTConfig{UPSName: "cyberpower"},
+		MQTT:    config.MQTTConfig{TopicPrefix: "ups"},
+		Metrics: config.MetricsConfig{EfficiencyCurve: map[string]float64{"0": 80, "10": 90}},
+	}
+	fpub := &publisher.FakePublisher{}
+	if err := doPoll(&nut.FakePoller{Variables: sampleVars}, fpub, cfg, newPollState()); err != nil {
+		t.Fatalf("doPoll: %v", err)
+	}
+	if msg, _ := fpub.Find("ups/cyberpower/computed/efficiency_pct"); msg.Payload != "88" {
+		t.Errorf("efficiency_pct = %q, want 88 at 8%% load", msg.Payload)
+	}
+}
+
+func TestDoPoll_Efficiency_Unavailable(t *testing.T) {
+	fpub := &publisher.FakePublisher{}
+	if err := doPoll(&nut.FakePoller{Variables: sampleVars}, fpub, testCfg, newPollState()); err != nil {
+		t.Fatalf("doPoll: %v", err)
+	}
+	if _, ok := fpub.Find("ups/cyberpower/computed/efficiency_pct"); ok {
+		t.Error("efficiency should not be published without input.realpower or a curve")
+	}
+
+	vars := append([]nut.Variable{{Name: "input.realpower", Value: "80"}}, sampleVars...)
+	fail := &topicFailPublisher{
+		FakePublisher: &publisher.FakePublisher{},
+		failTopic:     "ups/cyberpower/computed/wasted_watts",
+	}
+	if err := doPoll(&nut.FakePoller{Variables: vars}, fail, testCfg, newPollState()); err == nil {
+		t.Fatal("expected error when an efficiency publish fails")
+	}
+}
+
+func TestDoPoll_StatusDebounce(t *testing.T) {
+	cfg := &config.Config{
+		NUT:           config.NUTConfig{UPSName: "cyberpower", StatusDebounce: 2},
+		MQTT:          config.MQTTConfig{TopicPrefix: "ups"},
+		Notifications: config.NotificationsConfig{Enabled: true},
+	}
+	st := newPollState()
+	fpub := &publisher.FakePublisher{}
+	poll := func(vars []nut.Variable) {
+		t.Helper()
+		fpub.Reset()
+		if err := doPoll(&nut.FakePoller{Variables: vars}, fpub, cfg, st); err != nil {
+			t.Fatalf("doPoll: %v", err)
+		}
+	}
+	check := func(when, status, onBattery string, notified bool) {
+		t.Helper()
+		if msg, _ := fpub.Find("ups/cyberpower/ups/status"); msg.Payload != status {
+			t.Errorf("%s: ups/status = %q, want %q", when, msg.Payload, status)
+		}
+		if msg, _ := fpub.Find("ups/cyberpower/computed/on_battery"); msg.Payload != onBattery {
+			t.Errorf("%s: on_battery = %q, want %q", when, msg.Payload, onBattery)
+		}
+		if _, ok := fpub.Find("ups/cyberpower/notify"); ok != notified {
+			t.Errorf("%s: notified = %v, want %v", when, ok, notified)
+		}
+	}
+
+	poll(sampleVars)
+	poll(onBatteryVars)
+	check("first poll on battery", "OB DISCHRG", "false", false)
+	poll(sampleVars)
+	check("flap back", "OL", "false", false)
+	poll(onBatteryVars)
+	poll(onBatteryVars)
+	check("second poll on battery", "OB DISCHRG", "true", true)
+
+	// Low battery isn't held back.
+	lowVars := append([]nut.Variable{{Name: "ups.status", Value: "OB DISCHRG LB"}}, onBatteryVars[1:]...)
+	poll(lowVars)
+	if msg, _ := fpub.Find("ups/cyberpower/computed/low_battery"); msg.Payload != "true" {
+		t.Errorf("low_battery = %q, want true at once", msg.Payload)
+	}
+}
+
+func TestDoPoll_ApparentPower(t *testing.T) {
+	vars := []nut.Variable{
+		{Name: "ups.status", Value: "OL"},
+		{Name: "ups.load", Value: "8"},
+		{Name: "ups.power.nominal", Value: "1500"},
+		{Name: "ups.realpower", Value: "96"},
+	}
+	fpub := &publisher.FakePublisher{}
+	if err := doPoll(&nut.FakePoller{Variables: vars}, fpub, testCfg, newPollState()); err != nil {
+		t.Fatalf("doPoll: %v", err)
+	}
+	if msg, _ := fpub.Find("ups/cyberpower/computed/load_va"); msg.Payload != "120" {
+		t.Errorf("load_va = %q, want 120 (8%% of 1500 VA)", msg.Payload)
+	}
+	if msg, _ := fpub.Find("ups/cyberpower/computed/power_factor"); msg.Payload != "0.8" {
+		t.Errorf("power_factor = %q, want 0.8 (96 W / 120 VA)", msg.Payload)
+	}
+
+	fpub.Reset()
+	if err := doPoll(&nut.FakePoller{Variables: sampleVars}, fpub, testCfg, newPollState()); err != nil {
+		t.Fatalf("doPoll: %v", err)
+	}
+	for _, topic := range []string{"load_va", "power_factor"} {
+		if _, ok := fpub.Find("ups/cyberpower/computed/" + topic); ok {
+			t.Errorf("%s published without ups.power.nominal", topic)
+		}
+	}
+
+	fail := &topicFailPublisher{
+		FakePublisher: &publisher.FakePublisher{},
+		failTopic:     "ups/cyberpower/computed/load_va",
+	}
+	if err := doPoll(&nut.FakePoller{Variables: vars}, fail, testCfg, newPollState()); err == nil {
+		t.Fatal("expected error when an apparent power publish fails")
+	}
+}
+
+func TestDoPoll_Temperature(t *testing.T) {
+	cfg := &config.Config{
+		NUT:     config.NUTConfig{UPSName: "cyberpower"},
+		MQTT:    config.MQTTConfig{TopicPrefix: "ups", ComputedEvery: 10},
+		Metrics: config.MetricsConfig{MaxTemperature: 40, TemperatureHysteresis: 2},
+	}
+	st := newPollState()
+	fpub := &publisher.FakePublisher{}
+	poll := func(temp string) {
+		t.Helper()
+		fpub.Reset()
+		vars := append([]nut.Variable{{Name: "ups.temperature", Value: temp}, {Name: "battery.temperature", Value: "30"}}, sampleVars...)
+		if err := doPoll(&nut.FakePoller{Variables: vars}, fpub, cfg, st); err != nil {
+			t.Fatalf("doPoll: %v", err)
+		}
+	}
+
+	poll("38")
+	for topic, want := range map[string]string{"ups_temperature": "38", "battery_temperature": "30", "over_temperature": "false"} {
+		if msg, _ := fpub.Find("ups/cyberpower/computed/" + topic); msg.Payload != want {
+			t.Errorf("%s = %q, want %q", topic, msg.Payload, want)
+		}
+	}
+
+	// Crossing the limit is published at once, ahead of computed_every.
+	poll("40.5")
+	if msg, _ := fpub.Find("ups/cyberpower/computed/over_temperature"); msg.Payload != "true" {
+		t.Errorf("over_temperature = %q, want true at 40.5 °C", msg.Payload)
+	}
+	if _, ok := fpub.Find("ups/cyberpower/computed/ups_temperature"); ok {
+		t.Error("ups_temperature published before computed_every is due")
+	}
+
+	poll("38.5")
+	if _, ok := fpub.Find("ups/cyberpower/computed/over_temperature"); ok {
+		t.Error("over_temperature changed within the hysteresis")
+	}
+	poll("37")
+	if msg, _ := fpub.Find("ups/cyberpower/computed/over_temperature"); msg.Payload != "false" {
+		t.Errorf("over_temperature = %q, want false at 37 °C", msg.Payload)
+	}
+
+	fpub.Reset()
+	if err := doPoll(&nut.FakePoller{Variables: sampleVars}, fpub, testCfg, newPollState()); err != nil {
+		t.Fatalf("doPoll: %v", err)
+	}
+	for _, topic := range []string{"ups_temperature", "battery_temperature", "over_temperature"} {
+		if _, ok := fpub.Find("ups/cyberpower/computed/" + topic); ok {
+			t.Errorf("%s published for a UPS that reports no temperature", topic)
+		}
+	}
+}
+
+func TestDoPoll_LoadWattsEstimatedFromVA(t *testing.T) {
+	cfg := &config.Config{
+		NUT:     config.NUTConfig{UPSName: "cyberpower"},
+		MQTT:    config.MQTTConfig{TopicPrefix: "ups"},
+		Metrics: config.MetricsConfig{PowerFactor: 0.6},
+	}
+	vars := []nut.Variable{
+		{Name: "ups.status", Value: "OL"},
+		{Name: "ups.load", Value: "8"},
+		{Name: "ups.power.nominal", Value: "1500"},
+	}
+	fpub := &publisher.FakePublisher{}
+	if err := doPoll(&nut.FakePoller{Variables: vars}, fpub, cfg, newPollState()); err != nil {
+		t.Fatalf("doPoll: %v", err)
+	}
+	if msg, _ := fpub.Find("ups/cyberpower/computed/load_watts"); msg.Payload != "72" {
+		t.Errorf("load_watts = %q, want 72 estimated from 1500 VA", msg.Payload)
+	}
+	if msg, _ := fpub.Find("ups/cyberpower/state"); !strings.Contains(msg.Payload, `"load_watts_estimated":true`) {
+		t.Errorf("state = %s, want load_watts_estimated", msg.Payload)
+	}
+}
+
+func TestDoPoll_StateSizeGuard(t *testing.T) {
+	cfg := &config.Config{
+		NUT:  config.NUTConfig{UPSName: "cyberpower"},
+		MQTT: config.MQTTConfig{TopicPrefix: "ups", MaxStateBytes: 350, StateOverflow: "truncate"},
+	}
+	fpub := &publisher.FakePublisher{}
+	if err := doPoll(&nut.FakePoller{Variables: sampleVars}, fpub, cfg, newPollState()); err != nil {
+		t.Fatalf("doPoll: %v", err)
+	}
+	msg, _ := fpub.Find("ups/cyberpower/state")
+	if len(msg.Payload) > 350 || !strings.Contains(msg.Payload, `"truncated":true`) {
+		t.Errorf("state = %s (%d bytes), want truncated to 350 bytes", msg.Payload, len(msg.Payload))
+	}
+}
+
+func TestNewSinks_NoneConfigured(t *testing.T) {
+	fpub := &publisher.FakePublisher{}
+	pub, err := newSinks(testCfg, fpub, &atomic.Bool{})
+	if err != nil || pub != publisher.Publisher(fpub) {
+		t.Errorf("newSinks = %v, %v; want the MQTT publisher unchanged", pub, err)
+	}
+}
+
+// TestNewSinks_RoutesComputedToFile verifies computed metrics can be split
+// off to a file sink while raw variables stay on MQTT.
+func TestNewSinks_RoutesComputedToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "computed.jsonl")
+	cfg := &config.Config{
+		NUT:  config.NUTConfig{UPSName: "cyberpower"},
+		MQTT: config.MQTTConfig{TopicPrefix: "ups", Retained: true},
+		Sinks: []config.SinkConfig{
+			{Type: "mqtt", Exclude: []string{"ups/+/computed/#"}},
+			{Type: "file", Path: path, Topics: []string{"ups/+/computed/#"}},
+			{Type: "http", URL: "http://127.0.0.1:1/unused", Disabled: true},
+		},
+	}
+	fpub := &publisher.FakePublisher{}
+	pub, err := newSinks(cfg, fpub, &atomic.Bool{})
+	if err != nil {
+		t.Fatalf("newSinks: %v", err)
+	}
+	if err := doPoll(&nut.FakePoller{Variables: sampleVars}, pub, cfg, newPollState()); err != nil {
+		t.Fatalf("doPoll: %v", err)
+	}
+	pub.Close() //nolint:errcheck
+
+	if _, ok := fpub.Find("ups/cyberpower/battery/charge"); !ok {
+		t.Error("raw variables should still reach MQTT")
+	}
+	if _, ok := fpub.Find("ups/cyberpower/computed/load_watts"); ok {
+		t.Error("computed metrics should not reach MQTT")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading sink file: %v", err)
+	}
+	if !strings.Contains(string(data), `"topic":"ups/cyberpower/computed/load_watts"`) ||
+		strings.Contains(string(data), "battery/charge") {
+		t.Errorf("file sink got:\n%s", data)
+	}
+}
+
+func TestNewSinks_ImplicitMQTT(t *testing.T) {
+	cfg := &config.Config{Sinks: []config.SinkConfig{{Type: "http", URL: "http://127.0.0.1:1/", Disabled: true}}}
+	fpub := &publisher.FakePublisher{}
+	pub, err := newSinks(cfg, fpub, &atomic.Bool{})
+	if err != nil {
+		t.Fatalf("newSinks: %v", err)
+	}
+	if err := pub.Publish(publisher.Message{Topic: "ups/cyberpower/state"}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if len(fpub.Messages) != 1 {
+		t.Error("MQTT should receive everything when no mqtt sink is configured")
+	}
+}
+
+func TestNewSinks_FileError(t *testing.T) {
+	cfg := &config.Config{Sinks: []config.SinkConfig{{Type: "file", Path: filepath.Join(t.TempDir(), "missing", "x")}}}
+	if _, err := newSinks(cfg, &publisher.FakePublisher{}, &atomic.Bool{}); err == nil {
+		t.Error("expected error for an unopenable file")
+	}
+}
+
+func TestNewSinks_Plugin(t *testing.T) {
+	cfg := &config.Config{Sinks: []config.SinkConfig{{Name: "nms", Type: "plugin", Command: "/nonexistent/plugin"}}}
+	fpub := &publisher.FakePublisher{}
+	pub, err := newSinks(cfg, fpub, &atomic.Bool{})
+	if err != nil {
+		t.Fatalf("newSinks: %v", err)
+	}
+	defer pub.Close() //nolint:errcheck
+	err = pub.Publish(publisher.Message{Topic: "ups/cyberpower/state"})
+	if err == nil || !strings.Contains(err.Error(), `sink "nms"`) {
+		t.Errorf("err = %v, want the plugin sink's failure", err)
+	}
+	if len(fpub.Messages) != 1 {
+		t.Error("a failing plugin should not stop delivery to MQTT")
+	}
+}
+
+// TestDoPoll_LowPower verifies that on battery low-power mode publishes
+// the variables less often and pauses the file sink, and that both the
+// poll going on battery and the one coming off it reach the file.
+func TestDoPoll_LowPower(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sink.jsonl")
+	cfg := &config.Config{
+		NUT:      config.NUTConfig{UPSName: "cyberpower"},
+		MQTT:     config.MQTTConfig{TopicPrefix: "ups", Retained: true},
+		Sinks:    []config.SinkConfig{{Type: "file", Path: path, Topics: []string{"ups/+/state"}}},
+		LowPower: config.LowPowerConfig{Enabled: true, PollInterval: config.Duration{Duration: 5 * time.Second}, VariablesEvery: 3, ComputedEvery: 3},
+	}
+	var paused atomic.Bool
+	fpub := &publisher.FakePublisher{}
+	pub, err := newSinks(cfg, fpub, &paused)
+	if err != nil {
+		t.Fatalf("newSinks: %v", err)
+	}
+	st := newPollState()
+	st.sinksPaused = &paused
+
+	var charges []int
+	fp := &nut.FakePoller{Sequence: [][]nut.Variable{sampleVars, onBatteryVars, onBatteryVars, onBatteryVars, onBatteryVars, sampleVars}}
+	for i := range fp.Sequence {
+		fpub.Reset()
+		if err := doPoll(fp, pub, cfg, st); err != nil {
+			t.Fatalf("poll %d: %v", i+1, err)
+		}
+		n := 0
+		for _, m := range fpub.Messages {
+			if m.Topic == "ups/cyberpower/battery/charge" {
+				n++
+			}
+		}
+		charges = append(charges, n)
+		if want := i >= 1 && i <= 4; st.lowPower != want || paused.Load() != want {
+			t.Errorf("poll %d: lowPower %v, paused %v; want %v", i+1, st.lowPower, paused.Load(), want)
+		}
+	}
+	pub.Close() //nolint:errcheck
+
+	// Polls 2 and 6 change the status; of 3 to 5 only the third is due.
+	if want := []int{1, 1, 0, 1, 0, 1}; !slices.Equal(charges, want) {
+		t.Errorf("battery/charge published %v, want %v", charges, want)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 3 {
+		t.Errorf("file sink got %d messages, want 3 (before, going on and coming off battery):\n%s", lines, data)
+	}
+	if got := pollSchedule(cfg, true).PollInterval.Duration; got != 5*time.Second {
+		t.Errorf("low-power poll interval = %s, want 5s", got)
+	}
+}
+
+func TestDoPoll_ByteStats(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{
+		NUT:        config.NUTConfig{UPSName: "cyberpower"},
+		MQTT:       config.MQTTConfig{TopicPrefix: "ups", ByteBudget: 100},
+		Prometheus: config.PrometheusConfig{TextfileDir: dir},
+	}
+	fpub := &publisher.FakePublisher{}
+	st := newPollState()
+	st.published = publisher.NewByteCounter(fpub)
+	if err := st.configure(nil, cfg); err != nil {
+		t.Fatal(err)
+	}
+	fp := &nut.FakePoller{Variables: sampleVars}
+
+	if err := doPoll(fp, st.published, cfg, st); err != nil {
+		t.Fatalf("poll 1: %v", err)
+	}
+	if _, ok := fpub.Find("ups/cyberpower/bridge"); ok || st.cycleBytes != nil {
+		t.Error("the first poll has no complete cycle to report")
+	}
+	var want int64
+	for _, m := range fpub.Messages {
+		want += int64(len(m.Topic) + len(m.Payload))
+	}
+
+	fpub.Reset()
+	if err := doPoll(fp, st.published, cfg, st); err != nil {
+		t.Fatalf("poll 2: %v", err)
+	}
+	msg, _ := fpub.Find("ups/cyberpower/bridge")
+	var stats publisher.BridgeStats
+	if err := json.Unmarshal([]byte(msg.Payload), &stats); err != nil {
+		t.Fatalf("bridge payload %q: %v", msg.Payload, err)
+	}
+	if c := stats.CycleBytes; c == nil || c.Bytes != want || c.ByClass["state"] == 0 || c.ByClass["variables"] == 0 || !stats.OverByteBudget {
+		t.Errorf("bridge = %s, want %d bytes over budget", msg.Payload, want)
+	}
+
+	if err := writeTextfile(cfg, st); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "ups_mqtt_cyberpower.prom"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line := fmt.Sprintf(`ups_mqtt_cycle_published_bytes{ups="cyberpower",class="state"} %d`, stats.CycleBytes.ByClass["state"]); !strings.Contains(string(data), line) {
+		t.Errorf("textfile missing %q:\n%s", line, data)
+	}
+}
+
+func TestTakeTick(t *testing.T) {
+	const interval = 10 * time.Second
+	st := newPollState()
+	t0 := time.Now()
+
+	if !st.takeTick(t0, t0.Add(5*time.Millisecond), interval) {
+		t.Fatal("a fresh tick should run")
+	}
+	// The next poll overran by 15s: the ticker buffered the t0+10s tick and
+	// dropped the t0+20s one.
+	if st.takeTick(t0.Add(interval), t0.Add(25*time.Second), interval) {
+		t.Error("a tick already an interval old should be skipped")
+	}
+	if !st.takeTick(t0.Add(3*interval), t0.Add(3*interval+time.Millisecond), interval) {
+		t.Error("the first fresh tick after the overrun should run")
+	}
+	if st.skipped != 2 {
+		t.Errorf("skipped = %d, want 2 (one stale, one dropped)", st.skipped)
+	}
+}
+
+func TestDoPoll_BridgeStats_SkippedPolls(t *testing.T) {
+	st := newPollState()
+	st.skipped = 3
+	fpub := &publisher.FakePublisher{}
+	if err := doPoll(&nut.FakePoller{Variables: sampleVars}, fpub, testCfg, st); err != nil {
+		t.Fatalf("doPoll: %v", err)
+	}
+	msg, ok := fpub.Find("ups/cyberpower/bridge")
+	if !ok || !strings.Contains(msg.Payload, `"skipped_polls":3`) || strings.Contains(msg.Payload, "clock_skew_secs") {
+		t.Errorf("bridge = %+v, want skipped_polls without clock skew", msg)
+	}
+}
+
+func TestDoPoll_StatusDisplayOptions(t *testing.T) {
+	cfg := &config.Config{
+		NUT:  config.NUTConfig{UPSName: "cyberpower"},
+		MQTT: config.MQTTConfig{TopicPrefix: "ups"},
+		Metrics: config.MetricsConfig{
+			StatusSeparator: " + ",
+			StatusCase:      "upper",
+			StatusShort:     true,
+		},
+	}
+	fpub := &publisher.FakePublisher{}
+	vars := []nut.Variable{{Name: "ups.status", Value: "OB DISCHRG"}}
+	if err := doPoll(&nut.FakePoller{Variables: vars}, fpub, cfg, newPollState()); err != nil {
+		t.Fatalf("doPoll: %v", err)
+	}
+	if msg, _ := fpub.Find("ups/cyberpower/computed/status_display"); msg.Payload != "ON BATTERY + DISCHARGING" {
+		t.Errorf("status_display = %q", msg.Payload)
+	}
+	if msg, _ := fpub.Find("ups/cyberpower/computed/status_short"); msg.Payload != "OB/DISCHRG" {
+		t.Errorf("status_short = %q", msg.Payload)
+	}
+}
+
+func TestRunOnce_WritesSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "last-poll.json")
+	cfg := &config.Config{
+		NUT:         config.NUTConfig{UPSName: "cyberpower"},
+		MQTT:        config.MQTTConfig{TopicPrefix: "ups"},
+		Diagnostics: config.DiagnosticsConfig{SnapshotFile: path},
+	}
+	if err := runOnce(context.Background(), &nut.FakePoller{Variables: sampleVars}, &publisher.FakePublisher{}, cfg, nil); err != nil {
+		t.Fatalf("runOnce: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading snapshot: %v", err)
+	}
+	var snap pollSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		t.Fatalf("snapshot is not JSON: %v\n%s", err, data)
+	}
+	if snap.UPSName != "cyberpower" || snap.Variables["battery.charge"] != "100" || snap.Timestamp == "" {
+		t.Errorf("snapshot = %+v", snap)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("directory has %d entries, want only the snapshot (no temp files left)", len(entries))
+	}
+}
+
+func TestWriteSnapshot_Disabled(t *testing.T) {
+	st := newPollState()
+	st.lastVars = map[string]string{"ups.status": "OL"}
+	if err := writeSnapshot(testCfg, st); err != nil {
+		t.Errorf("writeSnapshot without snapshot_file: %v", err)
+	}
+}
+
+func TestDoPoll_Record(t *testing.T) {
+	cfg := *testCfg
+	cfg.Diagnostics.RecordFile = filepath.Join(t.TempDir(), "polls.jsonl")
+	clk := clock.NewFake(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	st := newPollStateAt(clk)
+	for _, vars := range [][]nut.Variable{sampleVars, onBatteryVars} {
+		if err := doPoll(&nut.FakePoller{Variables: vars}, &publisher.FakePublisher{}, &cfg, st); err != nil {
+			t.Fatalf("doPoll: %v", err)
+		}
+		clk.Advance(30 * time.Second)
+	}
+	// A failed poll records nothing.
+	doPoll(&nut.FakePoller{Err: errors.New("upsd went away")}, &publisher.FakePublisher{}, &cfg, st) //nolint:errcheck
+
+	data, err := os.ReadFile(cfg.Diagnostics.RecordFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("recorded %d lines, want 2:\n%s", len(lines), data)
+	}
+	var second pollSnapshot
+	if err := json.Unmarshal([]byte(lines[1]), &second); err != nil {
+		t.Fatalf("line 2: %v", err)
+	}
+	if second.Timestamp != "2026-03-01T12:00:30Z" || second.UPSName != "cyberpower" || second.Variables["ups.status"] != "OB DISCHRG" {
+		t.Errorf("line 2 = %+v", second)
+	}
+}
+
+func TestRecordPoll_CSV(t *testing.T) {
+	cfg := *testCfg
+	cfg.Diagnostics.RecordFile = filepath.Join(t.TempDir(), "polls.csv")
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, status := range []string{"OL", "OB, DISCHRG"} {
+		if err := recordPoll(map[string]string{"ups.status": status, "battery.charge": "100"}, now, &cfg); err != nil {
+			t.Fatalf("recordPoll: %v", err)
+		}
+	}
+	data, err := os.ReadFile(cfg.Diagnostics.RecordFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `timestamp,ups_name,variable,value
+2026-03-01T12:00:00Z,cyberpower,battery.charge,100
+2026-03-01T12:00:00Z,cyberpower,ups.status,OL
+2026-03-01T12:00:00Z,cyberpower,battery.charge,100
+2026-03-01T12:00:00Z,cyberpower,ups.status,"OB, DISCHRG"
+`
+	if string(data) != want {
+		t.Errorf("record file =\n%s\nwant\n%s", data, want)
+	}
+
+	cfg.Diagnostics.RecordFile = filepath.Join(t.TempDir(), "missing", "polls.csv")
+	if err := recordPoll(map[string]string{"ups.status": "OL"}, now, &cfg); err == nil {
+		t.Error("expected an error for a record file in a missing directory")
+	}
+}
+
+func TestReplay(t *testing.T) {
+	cfg := *testCfg
+	cfg.Diagnostics.RecordFile = filepath.Join(t.TempDir(), "polls.jsonl")
+	clk := clock.NewFake(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	st := newPollStateAt(clk)
+	for _, vars := range [][]nut.Variable{sampleVars, onBatteryVars, onBatteryVars} {
+		if err := doPoll(&nut.FakePoller{Variables: vars}, &publisher.FakePublisher{}, &cfg, st); err != nil {
+			t.Fatalf("doPoll: %v", err)
+		}
+		clk.Advance(30 * time.Second)
+	}
+
+	polls, err := readRecording(cfg.Diagnostics.RecordFile)
+	if err != nil {
+		t.Fatalf("readRecording: %v", err)
+	}
+	if len(polls) != 3 {
+		t.Fatalf("read %d polls, want 3", len(polls))
+	}
+	fpub := &publisher.FakePublisher{}
+	if err := replay(context.Background(), polls, 0, fpub, &cfg); err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	var last publisher.OutageMessage
+	for _, msg := range fpub.Messages {
+		if msg.Topic == "ups/cyberpower/outage" {
+			if err := json.Unmarshal([]byte(msg.Payload), &last); err != nil {
+				t.Fatalf("outage payload: %v", err)
+			}
+		}
+	}
+	// The outage is timed by the clock of the recording, not the wall clock.
+	if last.OutageStartedAt != "2026-03-01T12:00:30Z" || last.OutageDurationSecs != 30 {
+		t.Errorf("outage = started %s, lasted %ds; want 2026-03-01T12:00:30Z, 30s", last.OutageStartedAt, last.OutageDurationSecs)
+	}
+	// Replaying doesn't record the polls again.
+	data, err := os.ReadFile(cfg.Diagnostics.RecordFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "\n"); n != 3 {
+		t.Errorf("record file has %d lines after the replay, want 3", n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	fpub.Reset()
+	if err := replay(ctx, polls, 1, fpub, &cfg); err != nil || len(fpub.Messages) != 0 {
+		t.Errorf("cancelled replay = %v with %d messages, want nil with none", err, len(fpub.Messages))
+	}
+}
+
+func TestReadRecording_CSV(t *testing.T) {
+	cfg := *testCfg
+	cfg.Diagnostics.RecordFile = filepath.Join(t.TempDir(), "polls.csv")
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, status := range []string{"OL", "OB, DISCHRG"} {
+		at := start.Add(time.Duration(i) * time.Minute)
+		if err := recordPoll(map[string]string{"ups.status": status, "battery.charge": "100"}, at, &cfg); err != nil {
+			t.Fatalf("recordPoll: %v", err)
+		}
+	}
+	polls, err := readRecording(cfg.Diagnostics.RecordFile)
+	if err != nil {
+		t.Fatalf("readRecording: %v", err)
+	}
+	if len(polls) != 2 {
+		t.Fatalf("read %d polls, want 2", len(polls))
+	}
+	p := polls[1]
+	if !p.at.Equal(start.Add(time.Minute)) || p.upsName != "cyberpower" || p.vars["ups.status"] != "OB, DISCHRG" || len(p.vars) != 2 {
+		t.Errorf("poll 2 = %+v", p)
+	}
+
+	bad := filepath.Join(t.TempDir(), "polls.jsonl")
+	if err := os.WriteFile(bad, []byte("{\"timestamp\":\"yesterday\"}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readRecording(bad); err == nil {
+		t.Error("expected an error for a bad timestamp")
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	cfg := *testCfg
+	cfg.Checkpoint.Dir = t.TempDir()
+	fpub := &publisher.FakePublisher{}
+	if err := publishCheckpoint(fpub, &cfg); err != nil || len(fpub.Messages) != 0 {
+		t.Fatalf("without a checkpoint: published %d messages (err %v), want none", len(fpub.Messages), err)
+	}
+
+	st := newPollState()
+	if err := doPoll(&nut.FakePoller{Variables: sampleVars}, &publisher.FakePublisher{}, &cfg, st); err != nil {
+		t.Fatalf("doPoll: %v", err)
+	}
+	if err := writeCheckpoint(&cfg, st); err != nil {
+		t.Fatalf("writeCheckpoint: %v", err)
+	}
+	if err := publishCheckpoint(fpub, &cfg); err != nil {
+		t.Fatalf("publishCheckpoint: %v", err)
+	}
+	msg, _ := fpub.Find("ups/cyberpower/state")
+	var state publisher.StateMessage
+	if err := json.Unmarshal([]byte(msg.Payload), &state); err != nil {
+		t.Fatalf("state is not JSON: %v\n%s", err, msg.Payload)
+	}
+	polled := st.lastPolled.UTC().Format(time.RFC3339)
+	if !state.Stale || state.Timestamp != polled || state.Variables["battery.charge"] != "100" || state.Computed != st.lastMetrics {
+		t.Errorf("state = %+v, want the checkpointed poll from %s marked stale", state, polled)
+	}
+}
+
+func TestWriteFileAtomic_Replaces(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out")
+	for _, body := range []string{"old contents", "new"} {
+		if err := writeFileAtomic(path, []byte(body)); err != nil {
+			t.Fatalf("writeFileAtomic: %v", err)
+		}
+	}
+	if data, _ := os.ReadFile(path); string(data) != "new" {
+		t.Errorf("contents = %q, want %q", data, "new")
+	}
+	if err := writeFileAtomic(filepath.Join(t.TempDir(), "missing", "out"), nil); err == nil {
+		t.Error("expected error for a missing directory")
+	}
+}
+
+func TestRunOnce_WritesTextfile(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{
+		NUT:        config.NUTConfig{UPSName: "cyberpower", Label: "office-ups"},
+		MQTT:       config.MQTTConfig{TopicPrefix: "ups"},
+		Prometheus: config.PrometheusConfig{TextfileDir: dir},
+	}
+	if err := runOnce(context.Background(), &nut.FakePoller{Variables: sampleVars}, &publisher.FakePublisher{}, cfg, nil); err != nil {
+		t.Fatalf("runOnce: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "ups_mqtt_office_ups.prom"))
+	if err != nil {
+		t.Fatalf("reading textfile: %v", err)
+	}
+	if !strings.Contains(string(data), `ups_mqtt_load_watts{ups="office-ups"} 72`) {
+		t.Errorf("textfile missing load_watts:\n%s", data)
+	}
+}
+
+func TestRunOnce_TextfileError(t *testing.T) {
+	cfg := &config.Config{
+		NUT:        config.NUTConfig{UPSName: "cyberpower"},
+		MQTT:       config.MQTTConfig{TopicPrefix: "ups"},
+		Prometheus: config.PrometheusConfig{TextfileDir: filepath.Join(t.TempDir(), "missing")},
+	}
+	if err := runOnce(context.Background(), &nut.FakePoller{Variables: sampleVars}, &publisher.FakePublisher{}, cfg, nil); err == nil {
+		t.Error("expected error when the textfile directory doesn't exist")
+	}
+}
+
+// wolRecorder returns a pollState whose Wake-on-LAN packets are recorded in
+// *sent instead of going to the network.
+func wolRecorder(sent *[]string) *pollState {
+	st := newPollState()
+	st.wake = func(addr, mac string) error {
+		*sent = append(*sent, addr+" "+mac)
+		return nil
+	}
+	return st
+}
+
+func TestWakeOnLAN_AfterShutdownOutage(t *testing.T) {
+	cfg := &config.Config{WakeOnLAN: config.WakeOnLANConfig{
+		Targets:   []string{"00:11:22:aa:bb:cc", "00:11:22:aa:bb:cd"},
+		Broadcast: "192.168.1.255:9",
+		Settle:    config.Duration{Duration: 2 * time.Minute},
+	}}
+	var sent []string
+	st := wolRecorder(&sent)
+	t0 := time.Now()
+
+	for i, status := range []string{"OL", "OB DISCHRG", "OB LB", "OL CHRG"} {
+		wakeOnLAN(status, strings.Contains(status, "OB"), t0.Add(time.Duration(i)*time.Minute), cfg, st)
+	}
+	if len(sent) != 0 || st.wakeAt == nil {
+		t.Fatalf("sent %v right after restore; want a pending wake", sent)
+	}
+	// Mains flap before the settle time is up: the wait starts again.
+	wakeOnLAN("OB", true, t0.Add(4*time.Minute), cfg, st)
+	wakeOnLAN("OL", false, t0.Add(5*time.Minute), cfg, st)
+	wakeOnLAN("OL", false, t0.Add(6*time.Minute), cfg, st)
+	if len(sent) != 0 {
+		t.Fatalf("sent %v before mains were stable", sent)
+	}
+	wakeOnLAN("OL", false, t0.Add(7*time.Minute), cfg, st)
+	if want := []string{"192.168.1.255:9 00:11:22:aa:bb:cc", "192.168.1.255:9 00:11:22:aa:bb:cd"}; strings.Join(sent, ",") != strings.Join(want, ",") {
+		t.Errorf("sent %v, want %v", sent, want)
+	}
+	wakeOnLAN("OL", false, t0.Add(8*time.Minute), cfg, st)
+	if len(sent) != 2 {
+		t.Errorf("packets sent again: %v", sent)
+	}
+}
+
+func TestWakeOnLAN_ShortOutageIgnored(t *testing.T) {
+	cfg := &config.Config{WakeOnLAN: config.WakeOnLANConfig{Targets: []string{"00:11:22:aa:bb:cc"}}}
+	var sent []string
+	st := wolRecorder(&sent)
+	t0 := time.Now()
+	for i, status := range []string{"OB DISCHRG", "OL", "OL"} {
+		wakeOnLAN(status, strings.Contains(status, "OB"), t0.Add(time.Duration(i)*time.Minute), cfg, st)
+	}
+	if len(sent) != 0 {
+		t.Errorf("sent %v after an outage that never reached LB/FSD", sent)
+	}
+
+	cfg.WakeOnLAN.Always = true
+	for i, status := range []string{"OB DISCHRG", "OL"} {
+		wakeOnLAN(status, strings.Contains(status, "OB"), t0.Add(time.Duration(i)*time.Minute), cfg, st)
+	}
+	if len(sent) != 1 {
+		t.Errorf("sent %v, want a packet after any outage with always", sent)
+	}
+}
+
+func TestDoPoll_WakeOnLAN(t *testing.T) {
+	cfg := &config.Config{
+		NUT:       config.NUTConfig{UPSName: "cyberpower"},
+		MQTT:      config.MQTTConfig{TopicPrefix: "ups"},
+		WakeOnLAN: config.WakeOnLANConfig{Targets: []string{"00:11:22:aa:bb:cc"}, Broadcast: "255.255.255.255:9"},
+	}
+	var sent []string
+	st := wolRecorder(&sent)
+	st.wake = func(addr, mac string) error {
+		sent = append(sent, mac)
+		return errors.New("network unreachable")
+	}
+	fp := &nut.FakePoller{}
+	for _, status := range []string{"OB LB", "OL"} {
+		fp.Variables = []nut.Variable{{Name: "ups.status", Value: status}}
+		if err := doPoll(fp, &publisher.FakePublisher{}, cfg, st); err != nil {
+			t.Fatalf("doPoll(%s): %v", status, err)
+		}
+	}
+	if len(sent) != 1 || st.wakePending {
+		t.Errorf("sent %v, pending %v; want one attempt, failures only logged", sent, st.wakePending)
+	}
+}
+
+func TestOutageOngoing_MainsStable(t *testing.T) {
+	st := newPollState()
+	t0 := time.Now()
+	st.outageStart = &t0
+	const stable = 30 * time.Second
+
+	if !st.outageOngoing(false, t0.Add(time.Minute), stable) {
+		t.Error("outage should continue right after mains return")
+	}
+	if !st.outageOngoing(true, t0.Add(70*time.Second), stable) {
+		t.Error("outage should continue on battery")
+	}
+	if !st.outageOngoing(false, t0.Add(80*time.Second), stable) {
+		t.Error("the wait should restart after going back on battery")
+	}
+	if st.outageOngoing(false, t0.Add(110*time.Second), stable) {
+		t.Error("outage should end once mains stayed up for mains_stable")
+	}
+	st.outageStart = nil
+	if st.outageOngoing(false, t0.Add(2*time.Minute), stable) {
+		t.Error("no outage without an outage start")
+	}
+}
+
+// TestDoPoll_MainsStable_FlappingSuppressed verifies that OB→OL→OB inside
+// mains_stable neither clears the outage nor notifies nor publishes events,
+// and that power_restored follows once mains have been stable.
+func TestDoPoll_MainsStable_FlappingSuppressed(t *testing.T) {
+	cfg := &config.Config{
+		NUT:           config.NUTConfig{UPSName: "cyberpower", MainsStable: config.Duration{Duration: time.Hour}},
+		MQTT:          config.MQTTConfig{TopicPrefix: "ups", Events: true},
+		Notifications: config.NotificationsConfig{Enabled: true},
+	}
+	fpub := &publisher.FakePublisher{}
+	st := newPollState()
+	fp := &nut.FakePoller{}
+	poll := func(status string) {
+		t.Helper()
+		fp.Variables = []nut.Variable{{Name: "ups.status", Value: status}}
+		if err := doPoll(fp, fpub, cfg, st); err != nil {
+			t.Fatalf("doPoll(%s): %v", status, err)
+		}
+	}
+	notifications := func() []string {
+		var names []string
+		for _, m := range fpub.Messages {
+			if m.Topic == "ups/cyberpower/notify" {
+				var ev struct{ Event string }
+				json.Unmarshal([]byte(m.Payload), &ev) //nolint:errcheck
+				names = append(names, ev.Event)
+			}
+		}
+		return names
+	}
+	events := func() []string {
+		var names []string
+		for _, m := range fpub.Messages {
+			if m.Topic == "ups/cyberpower/events" {
+				var ev publisher.EventMessage
+				json.Unmarshal([]byte(m.Payload), &ev) //nolint:errcheck
+				names = append(names, ev.Event)
+			}
+		}
+		return names
+	}
+
+	for _, status := range []string{"OL", "OB DISCHRG", "OL CHRG", "OB DISCHRG", "OL CHRG"} {
+		poll(status)
+	}
+	if got := notifications(); strings.Join(got, ",") != "on_battery" {
+		t.Errorf("notifications = %v, want only the first on_battery", got)
+	}
+	if got := events(); strings.Join(got, ",") != "power_lost" {
+		t.Errorf("events = %v, want only the first power_lost", got)
+	}
+	if st.outageStart == nil {
+		t.Error("outage should still be on while mains aren't stable")
+	}
+	for _, m := range fpub.Messages {
+		if m.Topic == "ups/cyberpower/outage" && m.Payload == "" {
+			t.Error("outage topic cleared before mains were stable")
+		}
+	}
+
+	past := time.Now().Add(-2 * time.Hour)
+	st.restoredAt = &past
+	poll("OL CHRG")
+	if got := notifications(); strings.Join(got, ",") != "on_battery,power_restored" {
+		t.Errorf("notifications = %v, want power_restored once stable", got)
+	}
+	if got := events(); strings.Join(got, ",") != "power_lost,power_restored" {
+		t.Errorf("events = %v, want power_restored once stable", got)
+	}
+	if st.outageStart != nil {
+		t.Error("outage should be over")
+	}
+}
+
+// recordingNotifier is a notify.Notifier that records what it is sent.
+type recordingNotifier struct {
+	got []notify.Notification
+	err error
+}
+
+func (r *recordingNotifier) Notify(n notify.Notification) error {
+	r.got = append(r.got, n)
+	return r.err
+}
+
+// TestSendNotification_Routes verifies routed events reach only their
+// notifiers, unrouted ones reach all, and notifier failures are only logged.
+func TestSendNotification_Routes(t *testing.T) {
+	cfg := notifyCfg()
+	pager := &recordingNotifier{err: errors.New("pager offline")}
+	st := newPollState()
+	st.notifiers = map[string]notify.Notifier{"pager": pager}
+	st.notifyRoutes = []notify.Route{{Events: []string{"on_battery"}, Notifiers: []string{"pager"}}}
+
+	fpub := &publisher.FakePublisher{}
+	fp := &nut.FakePoller{Sequence: [][]nut.Variable{sampleVars, onBatteryVars, sampleVars}}
+	for i := 0; i < 3; i++ {
+		if err := doPoll(fp, fpub, cfg, st); err != nil {
+			t.Fatalf("poll %d: %v", i+1, err)
+		}
+	}
+	if len(pager.got) != 2 || pager.got[0].Event != "on_battery" || pager.got[1].Event != "power_restored" {
+		t.Fatalf("pager got %+v, want on_battery then power_restored", pager.got)
+	}
+	if n := pager.got[0]; n.UPS != "cyberpower" || n.Severity != alerts.SeverityWarning || n.Message == "" {
+		t.Errorf("notification = %+v", n)
+	}
+	var topics []string
+	for _, m := range fpub.Messages {
+		if m.Topic == "ups/cyberpower/notify" {
+			topics = append(topics, m.Payload)
+		}
+	}
+	if len(topics) != 1 || !strings.Contains(topics[0], `"event":"power_restored"`) {
+		t.Errorf("notify topic got %v, want only the unrouted power_restored", topics)
+	}
+}
+
+// TestSendNotification_Templates verifies the notify topic and notifiers
+// get the message in their language, falling back to the English one.
+func TestSendNotification_Templates(t *testing.T) {
+	cfg := notifyCfg()
+	cfg.Notifications.Language = "de"
+	cfg.Notifications.Templates = map[string]map[string]string{
+		"de": {"on_battery": `{{.UPS}} läuft auf Batterie ({{index .Variables "battery.charge"}} %)`},
+	}
+	cfg.Notifications.Notifiers = []config.NotifierConfig{{Name: "ops", Language: "en"}}
+	family, ops := &recordingNotifier{}, &recordingNotifier{}
+	st := newPollState()
+	if err := st.configure(nil, cfg); err != nil {
+		t.Fatal(err)
+	}
+	st.notifiers = map[string]notify.Notifier{"family": family, "ops": ops}
+
+	fpub := &publisher.FakePublisher{}
+	fp := &nut.FakePoller{Sequence: [][]nut.Variable{sampleVars, onBatteryVars, sampleVars}}
+	for i := 0; i < 3; i++ {
+		if err := doPoll(fp, fpub, cfg, st); err != nil {
+			t.Fatalf("poll %d: %v", i+1, err)
+		}
+	}
+	if msg, _ := fpub.Find("ups/cyberpower/notify"); !strings.Contains(msg.Payload, `"message":"cyberpower läuft auf Batterie (100 %)"`) {
+		t.Errorf("notify topic = %s, want the German message", msg.Payload)
+	}
+	if len(family.got) != 2 || family.got[0].Message != "cyberpower läuft auf Batterie (100 %)" || family.got[1].Message != "mains power restored" {
+		t.Errorf("family got %+v, want the German on_battery and the English power_restored without a template", family.got)
+	}
+	if len(ops.got) != 2 || ops.got[0].Message != "UPS is running on battery, 68 min runtime remaining" {
+		t.Errorf("ops got %+v, want its own language, English", ops.got)
+	}
+
+	if err := publishDrillStep("OL", "OB DISCHRG", time.Now(), fpub, cfg, st); err != nil {
+		t.Fatal(err)
+	}
+	if got := family.got[2].Message; got != "DRILL: cyberpower läuft auf Batterie (100 %)" {
+		t.Errorf("drill message = %q, want the template prefixed DRILL:", got)
+	}
+}
+
+func TestNewNotifiers(t *testing.T) {
+	cfg := &config.Config{Notifications: config.NotificationsConfig{
+		Notifiers: []config.NotifierConfig{
+			{Name: "ops", Type: "webhook", URL: "http://hooks.local/ups"},
+			{Name: "mail", Type: "email", SMTPHost: "smtp.local:25", From: "ups@local", To: []string{"me@local"}},
+		},
+		Routes: []config.NotificationRouteConfig{{Events: []string{"low_battery"}, MinSeverity: "critical", Notifiers: []string{"ops"}}},
+	}}
+	notifiers, routes := newNotifiers(cfg)
+	if _, ok := notifiers["ops"].(*notify.Webhook); !ok {
+		t.Errorf("ops = %T, want *notify.Webhook", notifiers["ops"])
+	}
+	if e, ok := notifiers["mail"].(*notify.Email); !ok || e.Addr != "smtp.local:25" {
+		t.Errorf("mail = %+v", notifiers["mail"])
+	}
+	if len(routes) != 1 || routes[0].MinSeverity != alerts.SeverityCritical {
+		t.Errorf("routes = %+v", routes)
+	}
+}
+
+// replayBroker replays the retained messages published to it to each new
+// subscription, as an MQTT broker does.
+type replayBroker struct {
+	publisher.FakePublisher
+}
+
+func (b *replayBroker) Subscribe(topic string, handler func(publisher.Message)) error {
+	if err := b.FakePublisher.Subscribe(topic, handler); err != nil {
+		return err
+	}
+	for _, msg := range b.Messages {
+		if msg.Retained && publisher.TopicMatches(topic, msg.Topic) {
+			handler(msg)
+		}
+	}
+	return nil
+}
+
+// TestExportImport_RoundTrip verifies import publishes a file's messages
+// and export writes back the retained ones under the bridge's roots.
+func TestExportImport_RoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.jsonl")
+	body := `{"time":"2026-01-01T00:00:00Z","topic":"ups/cyberpower/battery/charge","payload":"100","retained":true}
+{"time":"2026-01-01T00:00:00Z","topic":"ups/cyberpower/notify","payload":"{}"}
+{"time":"2026-01-01T00:00:00Z","topic":"elsewhere/x","payload":"1","retained":true}
+`
+	if err := os.WriteFile(in, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	b := &replayBroker{}
+	if err := importTopics(b, in); err != nil {
+		t.Fatalf("importTopics: %v", err)
+	}
+	if len(b.Messages) != 3 || b.Messages[1].Retained {
+		t.Fatalf("imported %+v", b.Messages)
+	}
+
+	out := filepath.Join(dir, "out.jsonl")
+	if err := exportTopics(b, testCfg, out, 10*time.Millisecond); err != nil {
+		t.Fatalf("exportTopics: %v", err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 1 || !strings.Contains(lines[0], `"topic":"ups/cyberpower/battery/charge"`) {
+		t.Errorf("exported:\n%s", data)
+	}
+}
+
+func TestImportTopics_Errors(t *testing.T) {
+	if err := importTopics(&publisher.FakePublisher{}, filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("expected error for a missing file")
+	}
+	path := filepath.Join(t.TempDir(), "in.jsonl")
+	os.WriteFile(path, []byte(`{"topic":"a","payload":"1"}`+"\n"), 0o644) //nolint:errcheck
+	err := importTopics(&publisher.FakePublisher{PublishError: errors.New("refused")}, path)
+	if err == nil || !strings.Contains(err.Error(), "publishing a") {
+		t.Errorf("err = %v, want the failing topic named", err)
+	}
+}
+
+// TestRecordConn verifies connection events accumulate on the retained audit
+// topic, and a failed publish leaves the entry for the next one.
+func TestRecordConn(t *testing.T) {
+	audit := publisher.NewAuditLog(10)
+	fpub := &publisher.FakePublisher{PublishError: errors.New("not connected")}
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	recordConn(audit, "mqtt", "disconnected", "tcp://broker:1883", errors.New("EOF"), at, fpub, testCfg)
+	fpub.PublishError = nil
+	recordConn(audit, "mqtt", "connected", "tcp://broker:1883", nil, at.Add(time.Second), fpub, testCfg)
+
+	msg, ok := fpub.Find("ups/cyberpower/diag/connections")
+	if !ok || !msg.Retained {
+		t.Fatalf("audit topic = %+v (found %v), want retained", msg, ok)
+	}
+	var got publisher.AuditMessage
+	if err := json.Unmarshal([]byte(msg.Payload), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Entries) != 2 || got.Entries[0].Event != "disconnected" || got.Entries[0].Error != "EOF" || got.Entries[1].Event != "connected" {
+		t.Errorf("entries = %+v", got.Entries)
+	}
+	if got.Entries[1].Timestamp != "2026-03-01T12:00:01Z" {
+		t.Errorf("timestamp = %q, want the time passed in", got.Entries[1].Timestamp)
+	}
+}
+
+func TestNewPollTicker(t *testing.T) {
+	for _, align := range []bool{false, true} {
+		c, stop := newPollTicker(clock.Real, config.NUTConfig{PollInterval: config.Duration{Duration: 10 * time.Millisecond}, AlignPolls: align})
+		select {
+		case <-c:
+		case <-time.After(time.Second):
+			t.Errorf("align=%v: no tick", align)
+		}
+		stop()
+	}
+}
+
+// TestDoPoll_EventHooks verifies the [hooks] commands run once per event,
+// with the NUT variables and the event in their environment.
+func TestDoPoll_EventHooks(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "events")
+	cfg := *testCfg
+	cfg.Hooks = config.HooksConfig{
+		OnBattery:     `echo "$UPS_MQTT_UPS $UPS_MQTT_EVENT $NUT_UPS_STATUS" >> ` + out,
+		PowerRestored: `echo "$UPS_MQTT_EVENT $NUT_BATTERY_CHARGE" >> ` + out,
+		PollFailure:   `echo "$UPS_MQTT_EVENT $UPS_MQTT_ERROR" >> ` + out,
+		Timeout:       config.Duration{Duration: 5 * time.Second},
+	}
+	st := newPollState()
+	fp := &nut.FakePoller{Sequence: [][]nut.Variable{sampleVars, onBatteryVars, sampleVars}}
+	for i := 0; i < 3; i++ {
+		if err := doPoll(fp, &publisher.FakePublisher{}, &cfg, st); err != nil {
+			t.Fatalf("poll %d: %v", i, err)
+		}
+		st.eventHooks.Wait()
+	}
+	failing := &nut.FakePoller{Err: errors.New("connection lost")}
+	for i := 0; i < 2; i++ {
+		doPoll(failing, &publisher.FakePublisher{}, &cfg, st) //nolint:errcheck
+		st.eventHooks.Wait()
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "cyberpower on_battery OB DISCHRG\npower_restored 100\npoll_failure connection lost\n"
+	if string(data) != want {
+		t.Errorf("hooks ran:\n%s\nwant:\n%s", data, want)
+	}
+}
+
+// TestDoPoll_PollComplete verifies every poll cycle, failed or not, ends
+// with a poll_complete marker counting the messages before it.
+func TestDoPoll_PollComplete(t *testing.T) {
+	cfg := *testCfg
+	cfg.MQTT.PollComplete = true
+	clk := clock.NewFake(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	st := newPollStateAt(clk)
+	fpub := &publisher.FakePublisher{}
+	st.published = publisher.NewByteCounter(fpub)
+
+	for i, fp := range []nut.Poller{&nut.FakePoller{Variables: sampleVars}, &nut.FakePoller{Err: errors.New("connection lost")}} {
+		fpub.Reset()
+		doPoll(fp, st.published, &cfg, st) //nolint:errcheck
+		last := fpub.Messages[len(fpub.Messages)-1]
+		var got publisher.PollComplete
+		if err := json.Unmarshal([]byte(last.Payload), &got); err != nil || last.Topic != "ups/cyberpower/poll_complete" {
+			t.Fatalf("poll %d: last message = %+v, want the marker", i, last)
+		}
+		if want := fmt.Sprintf("%d-%d", clk.Now().Unix(), i+1); got.CorrelationID != want || got.Topics != int64(len(fpub.Messages)-1) || last.Retained {
+			t.Errorf("poll %d: marker = %+v (retained %v), want ID %s and %d topics", i, got, last.Retained, want, len(fpub.Messages)-1)
+		}
+	}
+}
+
+// TestDoPoll_FakeClock drives the poll ticker and doPoll from a fake clock:
+// messages carry its time, and an outage lasts exactly as long as it was
+// moved on by.
+func TestDoPoll_FakeClock(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+	cfg := *testCfg
+	cfg.NUT.PollInterval = config.Duration{Duration: 150 * time.Second}
+	cfg.Outages.Dir = t.TempDir()
+	st := newPollStateAt(clk)
+	fp := &nut.FakePoller{Sequence: [][]nut.Variable{sampleVars, onBatteryVars, sampleVars}}
+	fpub := &publisher.FakePublisher{}
+	tickC, stop := newPollTicker(clk, cfg.NUT)
+	defer stop()
+
+	for i := 0; i < 3; i++ {
+		clk.Advance(cfg.NUT.PollInterval.Duration)
+		select {
+		case tick := <-tickC:
+			if !st.takeTick(tick, clk.Now(), cfg.NUT.PollInterval.Duration) {
+				t.Fatalf("tick %d at %s skipped", i, tick)
+			}
+		default:
+			t.Fatalf("no tick %d at %s", i, clk.Now())
+		}
+		fpub.Reset()
+		if err := doPoll(fp, fpub, &cfg, st); err != nil {
+			t.Fatalf("poll %d: %v", i, err)
+		}
+		polled := clk.Now().UTC().Format(time.RFC3339)
+		if msg, ok := fpub.Find("ups/cyberpower/state"); !ok || !strings.Contains(msg.Payload, `"timestamp":"`+polled+`"`) {
+			t.Errorf("poll %d: state = %+v, want the timestamp %s", i, msg, polled)
+		}
+	}
+	if msg, ok := fpub.Find("ups/cyberpower/computed/last_outage_duration_secs"); !ok || msg.Payload != "150" {
+		t.Errorf("last_outage_duration_secs = %+v, want 150", msg)
+	}
+}
+
+// TestDoPoll_ChargerState verifies computed/charger_state follows the status
+// after the hold and reports going on battery at once.
+func TestDoPoll_ChargerState(t *testing.T) {
+	cfg := &config.Config{
+		NUT:     config.NUTConfig{UPSName: "cyberpower"},
+		MQTT:    config.MQTTConfig{TopicPrefix: "ups"},
+		Metrics: config.MetricsConfig{ChargerStateHold: config.Duration{Duration: time.Hour}},
+	}
+	st := newPollState()
+	fp := &nut.FakePoller{Sequence: [][]nut.Variable{
+		{{Name: "ups.status", Value: "OL"}, {Name: "battery.charge", Value: "100"}},
+		{{Name: "ups.status", Value: "OL CHRG"}, {Name: "battery.charge", Value: "100"}},
+		{{Name: "ups.status", Value: "OB DISCHRG"}, {Name: "battery.charge", Value: "99"}},
+	}}
+	fpub := &publisher.FakePublisher{}
+	for i, want := range []string{"floating", "floating", "discharging"} {
+		fpub.Reset()
+		if err := doPoll(fp, fpub, cfg, st); err != nil {
+			t.Fatalf("poll %d: %v", i+1, err)
+		}
+		if msg, _ := fpub.Find("ups/cyberpower/computed/charger_state"); msg.Payload != want {
+			t.Errorf("poll %d: charger_state = %q, want %q", i+1, msg.Payload, want)
+		}
+	}
+}
+
+func TestDoPoll_ChargerStatePublishError_Propagated(t *testing.T) {
+	fpub := &topicFailPublisher{
+		FakePublisher: &publisher.FakePublisher{},
+		failTopic:     "ups/cyberpower/computed/charger_state",
+	}
+	if err := doPoll(&nut.FakePoller{Variables: sampleVars}, fpub, testCfg, newPollState()); err == nil {
+		t.Fatal("expected error when the charger state publish fails")
+	}
+}
+
+// TestDoPoll_OnChange verifies that in on_change mode a repeated poll only
+// republishes the topics whose value changed.  (The state topic changes with
+// its timestamp, which can't be relied on within one second.)
+func TestDoPoll_OnChange(t *testing.T) {
+	fpub := &publisher.FakePublisher{}
+	pub := publisher.NewOnChangePublisher(fpub)
+	st := newPollState()
+	fp := &nut.FakePoller{Variables: sampleVars}
+	if err := doPoll(fp, pub, testCfg, st); err != nil {
+		t.Fatalf("poll 1: %v", err)
+	}
+	first := len(fpub.Messages)
+	fpub.Messages = nil
+	if err := doPoll(fp, pub, testCfg, st); err != nil {
+		t.Fatalf("poll 2: %v", err)
+	}
+	if _, ok := fpub.Find("ups/cyberpower/battery/charge"); ok {
+		t.Error("an unchanged variable should not be republished")
+	}
+	if len(fpub.Messages) >= first/2 {
+		t.Errorf("second poll published %d of %d messages", len(fpub.Messages), first)
+	}
+}
+
+// TestDoPoll_Events verifies status transitions, including a failed poll,
+// are published to the events topic when enabled.
+func TestDoPoll_Events(t *testing.T) {
+	cfg := *testCfg
+	cfg.MQTT.Events = true
+	st := newPollState()
+	fpub := &publisher.FakePublisher{}
+	polls := []*nut.FakePoller{
+		{Variables: sampleVars},
+		{Variables: onBatteryVars},
+		{Err: errors.New("connection refused")},
+		{Variables: sampleVars},
+	}
+	for _, fp := range polls {
+		doPoll(fp, fpub, &cfg, st) //nolint:errcheck
+	}
+	var got []string
+	for _, m := range fpub.Messages {
+		if m.Topic != "ups/cyberpower/events" {
+			continue
+		}
+		if m.Retained {
+			t.Error("events must not be retained")
+		}
+		var ev publisher.EventMessage
+		if err := json.Unmarshal([]byte(m.Payload), &ev); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, ev.Event+":"+ev.PreviousStatus+">"+ev.Status)
+	}
+	want := []string{"power_lost:OL>OB DISCHRG", "comms_lost:OB DISCHRG>OB DISCHRG", "comms_restored:OB DISCHRG>OL", "power_restored:OB DISCHRG>OL"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("events = %q\nwant     %q", got, want)
+	}
+}
+
+// TestDoPoll_UPSMissing verifies a UPS that upsd stops listing is marked
+// offline once, announced as ups_missing, and as ups_found on its return.
+func TestDoPoll_UPSMissing(t *testing.T) {
+	cfg := notifyCfg()
+	cfg.MQTT.Events = true
+	st := newPollState()
+	fpub := &publisher.FakePublisher{}
+	missing := &nut.FakePoller{Err: fmt.Errorf("UPS \"cyberpower\" %w", nut.ErrUPSNotFound)}
+	polls := []*nut.FakePoller{{Variables: sampleVars}, missing, missing, {Variables: sampleVars}}
+	for _, fp := range polls {
+		doPoll(fp, fpub, cfg, st) //nolint:errcheck
+	}
+	var events, notes []string
+	offline := 0
+	for _, m := range fpub.Messages {
+		switch m.Topic {
+		case "ups/cyberpower/events":
+			var ev publisher.EventMessage
+			if err := json.Unmarshal([]byte(m.Payload), &ev); err != nil {
+				t.Fatal(err)
+			}
+			events = append(events, ev.Event)
+		case "ups/cyberpower/notify":
+			var n struct{ Event string }
+			if err := json.Unmarshal([]byte(m.Payload), &n); err != nil {
+				t.Fatal(err)
+			}
+			notes = append(notes, n.Event)
+		case "ups/cyberpower/state":
+			if m.Payload == publisher.FormatOffline() {
+				offline++
+			}
+		}
+	}
+	if want := "comms_lost,ups_missing,comms_restored,ups_found"; strings.Join(events, ",") != want {
+		t.Errorf("events = %q, want %s", events, want)
+	}
+	if want := "ups_missing,ups_found"; strings.Join(notes, ",") != want {
+		t.Errorf("notifications = %q, want %s", notes, want)
+	}
+	if offline != 1 {
+		t.Errorf("offline state published %d times, want 1", offline)
+	}
+	if st.reading.Missing {
+		t.Error("still missing after a good poll")
+	}
+}
+
+func TestDoPoll_EventsOff(t *testing.T) {
+	st := newPollState()
+	fpub := &publisher.FakePublisher{}
+	doPoll(&nut.FakePoller{Variables: sampleVars}, fpub, testCfg, st)    //nolint:errcheck
+	doPoll(&nut.FakePoller{Variables: onBatteryVars}, fpub, testCfg, st) //nolint:errcheck
+	if _, ok := fpub.Find("ups/cyberpower/events"); ok {
+		t.Error("events should only be published with mqtt.events")
+	}
+}
+
+func TestDoPoll_EventPublishError_Propagated(t *testing.T) {
+	cfg := *testCfg
+	cfg.MQTT.Events = true
+	st := newPollState()
+	fpub := &topicFailPublisher{FakePublisher: &publisher.FakePublisher{}, failTopic: "ups/cyberpower/events"}
+	doPoll(&nut.FakePoller{Variables: sampleVars}, fpub, &cfg, st) //nolint:errcheck
+	if err := doPoll(&nut.FakePoller{Variables: onBatteryVars}, fpub, &cfg, st); err == nil {
+		t.Fatal("expected error when the event publish fails")
+	}
+}
+
+// TestHookHelperProcess is the [hook] program of the doPoll hook tests: the
+// test binary re-runs itself with HOOK_HELPER set.
+func TestHookHelperProcess(t *testing.T) {
+	mode := os.Getenv("HOOK_HELPER")
+	if mode == "" {
+		return
+	}
+	if mode == "plugin" {
+		sc := bufio.NewScanner(os.Stdin)
+		for n := 1; sc.Scan(); n++ {
+			fmt.Printf(`{"result":{"computed":{"polls":"%d"}}}`+"\n", n)
+		}
+		os.Exit(0)
+	}
+	var in hook.Input
+	json.NewDecoder(os.Stdin).Decode(&in) //nolint:errcheck
+	switch mode {
+	case "transform":
+		in.Variables["ups.load"] = "50"
+		json.NewEncoder(os.Stdout).Encode(hook.Output{ //nolint:errcheck
+			Variables: in.Variables,
+			Computed:  map[string]string{"site": "rack4"},
+		})
+	case "veto":
+		fmt.Print(`{"veto":true}`)
+	case "fail":
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func hookCfg(t *testing.T, mode string) *config.Config {
+	t.Setenv("HOOK_HELPER", mode)
+	cfg := *testCfg
+	cfg.Hook = config.HookConfig{
+		Command: os.Args[0],
+		Args:    []string{"-test.run=TestHookHelperProcess"},
+		Timeout: config.Duration{Duration: 10 * time.Second},
+	}
+	return &cfg
+}
+
+func TestDoPoll_HookTransforms(t *testing.T) {
+	fpub := &publisher.FakePublisher{}
+	if err := doPoll(&nut.FakePoller{Variables: sampleVars}, fpub, hookCfg(t, "transform"), newPollState()); err != nil {
+		t.Fatalf("doPoll: %v", err)
+	}
+	if msg, _ := fpub.Find("ups/cyberpower/ups/load"); msg.Payload != "50" {
+		t.Errorf("load = %q, want the hook's 50", msg.Payload)
+	}
+	if msg, _ := fpub.Find("ups/cyberpower/computed/load_watts"); msg.Payload != "450" {
+		t.Errorf("load_watts = %q, want 450 computed from the hook's load", msg.Payload)
+	}
+	if msg, _ := fpub.Find("ups/cyberpower/computed/site"); msg.Payload != "rack4" {
+		t.Errorf("computed/site = %q, want rack4", msg.Payload)
+	}
+}
+
+func TestDoPoll_HookVeto(t *testing.T) {
+	fpub := &publisher.FakePublisher{}
+	if err := doPoll(&nut.FakePoller{Variables: sampleVars}, fpub, hookCfg(t, "veto"), newPollState()); err != nil {
+		t.Fatalf("doPoll: %v", err)
+	}
+	if len(fpub.Messages) != 0 {
+		t.Errorf("published %d messages despite the veto", len(fpub.Messages))
+	}
+}
+
+func TestDoPoll_HookFailurePublishesUnmodified(t *testing.T) {
+	fpub := &publisher.FakePublisher{}
+	if err := doPoll(&nut.FakePoller{Variables: sampleVars}, fpub, hookCfg(t, "fail"), newPollState()); err != nil {
+		t.Fatalf("doPoll: %v", err)
+	}
+	if msg, _ := fpub.Find("ups/cyberpower/ups/load"); msg.Payload != "8" {
+		t.Errorf("load = %q, want the polled 8", msg.Payload)
+	}
+}
+
+func TestDoPoll_HookScript(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hook.lua")
+	src := `function process(input) return {computed = {site = input.ups_name .. "@rack4"}} end`
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := *testCfg
+	cfg.Hook = config.HookConfig{Script: path, Timeout: config.Duration{Duration: time.Second}}
+	st := newPollState()
+	defer st.close()
+	fpub := &publisher.FakePublisher{}
+	if err := doPoll(&nut.FakePoller{Variables: sampleVars}, fpub, &cfg, st); err != nil {
+		t.Fatalf("doPoll: %v", err)
+	}
+	if msg, _ := fpub.Find("ups/cyberpower/computed/site"); msg.Payload != "cyberpower@rack4" {
+		t.Errorf("computed/site = %q", msg.Payload)
+	}
+}
+
+func TestDoPoll_HookPluginKeptRunning(t *testing.T) {
+	cfg := hookCfg(t, "plugin")
+	cfg.Hook.Plugin = true
+	st := newPollState()
+	defer st.close()
+	for want := 1; want <= 2; want++ {
+		fpub := &publisher.FakePublisher{}
+		if err := doPoll(&nut.FakePoller{Variables: sampleVars}, fpub, cfg, st); err != nil {
+			t.Fatalf("doPoll: %v", err)
+		}
+		if msg, _ := fpub.Find("ups/cyberpower/computed/polls"); msg.Payload != fmt.Sprint(want) {
+			t.Errorf("poll %d: computed/polls = %q", want, msg.Payload)
+		}
+	}
+}
+
+func TestSetup_WritesConfig(t *testing.T) {
+	answers := strings.Join([]string{
+		"1", "", // found server, no NUT login: listing fails
+		"nut.lan", "upsmon", "secret", // typed server with a login
+		"rack",                       // UPS by name
+		"tcp://mq.lan:1883", "", "n", // broker fails, try again
+		"tcp://mq.lan:1883", "bridge", "pw", // broker connects
+		"", // Home Assistant: default yes
+		"", // default path
+	}, "\n") + "\n"
+	var out strings.Builder
+	var brokers int
+	w := &wizard{
+		in:   bufio.NewScanner(strings.NewReader(answers)),
+		out:  &out,
+		scan: func() []string { return []string{"10.0.0.5"} },
+		listUPS: func(host, username, password string) ([]nut.UPSInfo, error) {
+			if username != "upsmon" || password != "secret" {
+				return nil, errors.New("access denied")
+			}
+			return []nut.UPSInfo{{Name: "cyberpower"}, {Name: "rack", Description: "Rack UPS"}}, nil
+		},
+		tryBroker: func(c config.MQTTConfig) error {
+			if brokers++; c.Username == "" {
+				return errors.New("not authorised")
+			}
+			return nil
+		},
+	}
+	f, err := w.run()
+	if err != nil {
+		t.Fatalf("run: %v\n%s", err, out.String())
+	}
+	path := filepath.Join(t.TempDir(), "ups-mqtt", "config.toml")
+	if err := w.write(path, f); err != nil {
+		t.Fatalf("write: %v\n%s", err, out.String())
+	}
+	if brokers != 2 || !strings.Contains(out.String(), "Can't list the UPSes on 10.0.0.5: access denied") {
+		t.Errorf("output:\n%s", out.String())
+	}
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("loading the written config: %v", err)
+	}
+	if cfg.NUT.Host != "nut.lan" || cfg.NUT.Username != "upsmon" || cfg.NUT.Password != "secret" || cfg.NUT.UPSName != "rack" {
+		t.Errorf("nut = %+v", cfg.NUT)
+	}
+	if cfg.MQTT.Broker != "tcp://mq.lan:1883" || cfg.MQTT.Username != "bridge" || cfg.MQTT.Password != "pw" || !cfg.HomeAssistant.Discovery {
+		t.Errorf("mqtt = %+v, discovery %v", cfg.MQTT, cfg.HomeAssistant.Discovery)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Errorf("config file mode = %v, %v; want 0600", fi.Mode(), err)
+	}
+
+	w.in = bufio.NewScanner(strings.NewReader("\n\n"))
+	if err := w.write(path, f); !errors.Is(err, errSetupAborted) {
+		t.Errorf("write over an existing file = %v, want it left alone by default", err)
+	}
+}
+
+func TestInitConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := initConfigMain([]string{path}); err != nil {
+		t.Fatalf("init-config: %v", err)
+	}
+	if _, err := config.Load(path); err != nil {
+		t.Errorf("loading the written config: %v", err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Errorf("stat = %v, %v; want mode 0600", fi, err)
+	}
+	if err := initConfigMain([]string{path}); err == nil {
+		t.Error("init-config should not replace an existing file")
+	}
+}
+
+func TestHistoryExport(t *testing.T) {
+	dir := t.TempDir()
+	history := `{"outages":[
+		{"start":"2026-02-27T23:50:00Z","end":"2026-02-28T00:10:00Z"},
+		{"start":"2026-03-01T09:00:00Z","end":"2026-03-01T09:02:30Z"},
+		{"start":"2026-03-02T12:00:00Z","end":"2026-03-02T12:00:05Z"}]}`
+	if err := os.WriteFile(filepath.Join(dir, "outages_cyberpower.json"), []byte(history), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		NUT:     config.NUTConfig{UPSName: "cyberpower"},
+		Outages: config.OutagesConfig{Dir: dir},
+	}
+	path := filepath.Join(dir, "export.csv")
+	if err := historyMain(cfg, []string{"export", "-from", "2026-03-01T00:00:00Z", "-to", "2026-03-02T00:00:00Z", path}); err != nil {
+		t.Fatalf("history export: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "ups,start,end,duration_secs\ncyberpower,2026-03-01T09:00:00Z,2026-03-01T09:02:30Z,150\n"
+	if string(data) != want {
+		t.Errorf("export =\n%s\nwant\n%s", data, want)
+	}
+	sum, err := os.ReadFile(path + ".sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("%x  export.csv\n", sha256.Sum256(data)); string(sum) != want {
+		t.Errorf("checksum file = %q, want %q", sum, want)
+	}
+
+	if err := historyMain(&config.Config{NUT: cfg.NUT}, []string{"export", path}); err == nil {
+		t.Error("export without outages.dir should fail")
+	}
+	if err := historyMain(cfg, []string{"export", "-from", "March", path}); err == nil {
+		t.Error("export with a bad -from should fail")
+	}
+}
+
+func TestSystemd(t *testing.T) {
+	dir, err := os.MkdirTemp("", "sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	recv := func() string {
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		buf := make([]byte, 64)
+		n, _ := conn.Read(buf)
+		return string(buf[:n])
+	}
+
+	t.Setenv("NOTIFY_SOCKET", socket)
+	sd := newSystemd(2)
+	if _, ok := os.LookupEnv("NOTIFY_SOCKET"); ok {
+		t.Error("NOTIFY_SOCKET should be removed from the environment")
+	}
+	sd.connected()
+	if got := recv(); got != "" {
+		t.Errorf("sent %q with one pipeline of two connected", got)
+	}
+	sd.connected()
+	if got := recv(); got != "READY=1" {
+		t.Errorf("sent %q, want READY=1", got)
+	}
+	sd.polledOK(0)
+	sd.polledOK(0)
+	if got := recv(); got != "" {
+		t.Errorf("sent %q before the second pipeline polled", got)
+	}
+	sd.polledOK(1)
+	if got := recv(); got != "WATCHDOG=1" {
+		t.Errorf("sent %q, want WATCHDOG=1", got)
+	}
+	sd.polledOK(1)
+	if got := recv(); got != "" {
+		t.Errorf("sent %q before the first pipeline polled again", got)
+	}
+	sd.add()
+	sd.polledOK(0)
+	if got := recv(); got != "" {
+		t.Errorf("sent %q before the added pipeline polled", got)
+	}
+	sd.polledOK(2)
+	if got := recv(); got != "WATCHDOG=1" {
+		t.Errorf("sent %q, want WATCHDOG=1", got)
+	}
+	sd.stopping()
+	if got := recv(); got != "STOPPING=1" {
+		t.Errorf("sent %q, want STOPPING=1", got)
+	}
+
+	if newSystemd(1) != nil {
+		t.Error("newSystemd without NOTIFY_SOCKET should return nil")
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/clock"
+	"github.com/sweeney/ups-mqtt/internal/config"
+	"github.com/sweeney/ups-mqtt/internal/metrics"
+	"github.com/sweeney/ups-mqtt/internal/nut"
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+)
+
+// pipelines runs a pipeline, run, for each UPS and hands each its share of
+// a reloaded config.  With nut.ups_name = "*" a pipeline is added for every
+// UPS upsd starts serving while the bridge runs.  If one fails to start,
+// the rest are stopped too so a supervisor restarts the whole daemon.
+type pipelines struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	pool   *nut.Pool
+	once   bool
+	dry    publisher.Publisher
+	sd     *systemd
+	agg    *aggregator
+	update *updateChecker
+	clock  clock.Clock
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	names   []string // the UPS each pipeline polls, by index
+	reloads []chan *config.Config
+	errs    []error
+}
+
+// start starts a pipeline for the UPS in c.
+func (p *pipelines) start(c *config.Config) {
+	p.mu.Lock()
+	i := len(p.names)
+	p.names = append(p.names, c.NUT.UPSName)
+	reloads := make(chan *config.Config, 1)
+	p.reloads = append(p.reloads, reloads)
+	p.errs = append(p.errs, nil)
+	p.mu.Unlock()
+	p.agg.add()
+	updates := p.update.subscribe()
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		err := run(p.ctx, c, p.pool, reloads, p.once, p.dry, p.sd, p.agg, updates, p.clock, i)
+		p.mu.Lock()
+		p.errs[i] = err
+		p.mu.Unlock()
+		if err != nil && !p.once {
+			p.cancel()
+		}
+	}()
+}
+
+// wait waits for every pipeline to stop and returns their errors.
+func (p *pipelines) wait() error {
+	p.wg.Wait()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return errors.Join(p.errs...)
+}
+
+// reload hands each pipeline its UPS's share of next.
+func (p *pipelines) reload(next *config.Config) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	perUPS := next.PerUPS()
+	for i, name := range p.names {
+		var c *config.Config
+		if next.NUT.Discover() {
+			c = next.ForUPS(name)
+		} else {
+			c = perUPS[i]
+		}
+		select {
+		case p.reloads[i] <- c:
+		default:
+			log.Printf("reload: %s is still applying the previous reload", c.NUT.EffectiveLabel())
+		}
+	}
+}
+
+// watch lists the UPSes upsd serves every poll interval until ctx is
+// cancelled, starting a pipeline for each new one.  A UPS that goes away
+// keeps its pipeline, which marks it offline and picks it up again if it
+// comes back.
+func (p *pipelines) watch(cfg *config.Config) {
+	ticker := p.clock.NewTicker(cfg.NUT.PollInterval.Duration)
+	defer ticker.Stop()
+	failing := false
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C():
+		}
+		names, err := listUPS(cfg, p.pool)
+		if err != nil {
+			if !failing {
+				log.Printf("listing the UPSes on upsd: %v", err)
+			}
+			failing = true
+			continue
+		}
+		failing = false
+		p.mu.Lock()
+		var added []string
+		for _, name := range names {
+			if !slices.Contains(p.names, name) {
+				added = append(added, name)
+			}
+		}
+		p.mu.Unlock()
+		for _, name := range added {
+			log.Printf("upsd now serves UPS %s; polling it", name)
+			p.sd.add()
+			p.start(cfg.ForUPS(name))
+		}
+	}
+}
+
+// aggregator keeps the latest metrics of every pipeline for the
+// mqtt.aggregate summary across UPSes.  A nil *aggregator, with one UPS or
+// --once, does nothing.
+type aggregator struct {
+	mu     sync.Mutex
+	upses  int
+	latest map[string]metrics.Metrics // by label, of the UPSes whose last poll succeeded
+}
+
+func newAggregator() *aggregator {
+	return &aggregator{latest: make(map[string]metrics.Metrics)}
+}
+
+// add counts one more pipeline.
+func (a *aggregator) add() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.upses++
+}
+
+// report records a poll of the UPS in cfg, which yielded m or, when m is
+// nil, failed, and publishes the summary to pub when mqtt.aggregate is set.
+// The lock is held while publishing so that pipelines polling at the same
+// time can't leave an older summary retained.
+func (a *aggregator) report(m *metrics.Metrics, now time.Time, pub publisher.Publisher, cfg *config.Config) error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if m != nil {
+		a.latest[topicLabel(cfg)] = *m
+	} else {
+		delete(a.latest, topicLabel(cfg))
+	}
+	if !cfg.MQTT.Aggregate {
+		return nil
+	}
+	return publisher.PublishAggregate(metrics.Combine(a.upses, a.latest), now, publishConfig(cfg), pub)
+}
+
+// discoverUPS returns the config of every UPS upsd serves, for nut.ups_name
+// = "*", retrying with connectNUT's backoff while upsd can't be reached,
+// unless once is set.
+func discoverUPS(ctx context.Context, cfg *config.Config, pool *nut.Pool, once bool) ([]*config.Config, error) {
+	backoff := time.Second
+	maxBackoff := cfg.NUT.MaxBackoff.Duration
+	for {
+		names, err := listUPS(cfg, pool)
+		if err == nil {
+			if len(names) == 0 {
+				log.Printf("upsd serves no UPS yet; polling each one as it appears")
+			}
+			cfgs := make([]*config.Config, len(names))
+			for i, name := range names {
+				cfgs[i] = cfg.ForUPS(name)
+			}
+			return cfgs, nil
+		}
+		if once {
+			return nil, err
+		}
+		log.Printf("listing the UPSes on upsd: %v — retrying in %s", err, backoff)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// listUPS returns the names of the UPSes upsd serves.
+func listUPS(cfg *config.Config, pool *nut.Pool) ([]string, error) {
+	nutCfg := cfg.NUT
+	nutCfg.UPSName = ""
+	c, err := newNUTClient(nutCfg, pool)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close() //nolint:errcheck
+	list, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(list))
+	for i, u := range list {
+		names[i] = u.Name
+	}
+	return names, nil
+}
+
+// upsConfigs returns the config of each UPS cfg polls, as PerUPS does, but
+// with nut.ups_name = "*" asks upsd once which it serves now.
+func upsConfigs(cfg *config.Config) ([]*config.Config, error) {
+	if !cfg.NUT.Discover() {
+		return cfg.PerUPS(), nil
+	}
+	cfgs, err := discoverUPS(context.Background(), cfg, nil, true)
+	if err != nil {
+		return nil, fmt.Errorf("listing the UPSes on upsd: %w", err)
+	}
+	return cfgs, nil
+}
@@ -0,0 +1,1009 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/alerts"
+	"github.com/sweeney/ups-mqtt/internal/clock"
+	"github.com/sweeney/ups-mqtt/internal/config"
+	"github.com/sweeney/ups-mqtt/internal/hook"
+	"github.com/sweeney/ups-mqtt/internal/metrics"
+	"github.com/sweeney/ups-mqtt/internal/notify"
+	"github.com/sweeney/ups-mqtt/internal/nut"
+	"github.com/sweeney/ups-mqtt/internal/outages"
+	"github.com/sweeney/ups-mqtt/internal/plausibility"
+	"github.com/sweeney/ups-mqtt/internal/plugin"
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+	"github.com/sweeney/ups-mqtt/internal/quirks"
+	"github.com/sweeney/ups-mqtt/internal/schedule"
+	"github.com/sweeney/ups-mqtt/internal/summary"
+	"github.com/sweeney/ups-mqtt/internal/trend"
+)
+
+// pollState carries what doPoll needs to remember from one poll to the next.
+type pollState struct {
+	// clock is what polls read the time from: clock.Real, but for tests
+	// and replays, which stamp messages with recorded times.
+	clock clock.Clock
+
+	// outageStart is when the current OB condition began; it is set on the
+	// first on-battery poll, cleared when mains are restored, and used to
+	// compute the outage duration and to clear the retained outage message.
+	outageStart *time.Time
+
+	// changes holds the previous variable values for $last_changed topics.
+	changes *publisher.ChangeTracker
+
+	// glitches counts readings rejected or clamped by the plausibility
+	// filter since startup.
+	glitches int64
+
+	// held remembers recent values for variables the driver drops from a poll.
+	held nut.HoldLast
+
+	// lastVars and lastMetrics are the most recent successful poll, taken
+	// at lastPolled, used for the diff topic, the Pushgateway snapshot and
+	// the announcement on reconnecting to the broker; lastVars is nil until
+	// the first successful poll.
+	lastVars    map[string]string
+	lastMetrics metrics.Metrics
+	lastPolled  time.Time
+
+	// discovered is set once Home Assistant discovery has been announced.
+	discovered bool
+
+	// chargeRate smooths d(battery.charge)/dt for computed/battery_charge_rate.
+	chargeRate trend.Rate
+
+	// charger debounces computed/charger_state.
+	charger trend.ChargerState
+
+	// runtime fits the discharge rate for computed/estimated_runtime_mins;
+	// runtimeShown records that the topic holds an estimate to clear once
+	// mains return.
+	runtime      trend.Runtime
+	runtimeShown bool
+
+	// runtimeAvg smooths battery.runtime for
+	// computed/battery_runtime_mins_smoothed.
+	runtimeAvg trend.Average
+
+	// status holds back changes of ups.status for nut.status_debounce.
+	status trend.StatusDebounce
+
+	// overTemperature is the last computed/over_temperature, which
+	// metrics.temperature_hysteresis keeps until the UPS has cooled down.
+	overTemperature bool
+
+	// reading is what the events topic compares the next poll with; see
+	// alerts.Transitions.
+	reading alerts.Reading
+
+	// alerts evaluates the configured [[alerts]] rules; nil when none are
+	// configured.
+	alerts *alerts.Engine
+
+	// polls counts successful polls, for the variables_every and
+	// computed_every publish ratios.
+	polls int64
+
+	// notifiers are the configured notification backends besides the
+	// notify topic, and notifyRoutes decide which of them each event goes to.
+	notifiers    map[string]notify.Notifier
+	notifyRoutes []notify.Route
+
+	// templates are [notifications.templates], and notifyVars the
+	// variables of the poll being handled, or the last, for them.
+	templates  notify.Templates
+	notifyVars map[string]string
+
+	// quietHours is the configured notification quiet window, nil when
+	// unset; quiet records whether the last poll fell inside it.
+	quietHours *schedule.Window
+	quiet      bool
+
+	// summary accumulates the polls since the last daily summary, which is
+	// next due at summaryDue; zero when summary.time is unset.
+	summary    summary.Period
+	summaryDue time.Time
+
+	// clockSkewed records whether the last clock comparison exceeded
+	// nut.clock_skew_threshold, so the transition is logged once.
+	clockSkewed bool
+
+	// quirks names the quirk profiles applied to the last poll, so changes
+	// are logged once.
+	quirks string
+
+	// lastTick is the poll ticker's last tick, and skipped counts the poll
+	// cycles skipped because the previous one overran; see takeTick.
+	lastTick time.Time
+	skipped  int64
+
+	// restoredAt is when the UPS came off battery while the restore waits
+	// for nut.mains_stable, and eventStatus the ups.status status events
+	// last saw, which stays on the outage's status during that wait.
+	restoredAt  *time.Time
+	eventStatus string
+
+	// outageEnd is when the outage last confirmed over came off battery.
+	// outageHistory is the record kept in outages.dir, read with the first
+	// poll once outageHistoryRead is set; it stays nil if the file couldn't
+	// be read, so that it isn't overwritten.
+	outageEnd         time.Time
+	outageHistory     *outages.History
+	outageHistoryRead bool
+
+	// wakePending is set once an outage reaches the point where hosts will
+	// have shut down, and wakeAt is when, mains having returned, their
+	// Wake-on-LAN packets are due; see wakeOnLAN.  wake sends one packet and
+	// defaults to wol.Send.
+	wakePending bool
+	wakeAt      *time.Time
+	wake        func(addr, mac string) error
+
+	// lowPower is set while low_power.enabled and the UPS is on battery;
+	// sinksPaused, when not nil, pauses the secondary sinks meanwhile.
+	lowPower    bool
+	sinksPaused *atomic.Bool
+
+	// published, when not nil, counts what is published to the broker;
+	// cycleBytes is what the last complete poll cycle published, nil until
+	// there is one or when mqtt.byte_stats is off, and overBudget whether
+	// that exceeded mqtt.byte_budget, so the transition is logged once.
+	published  *publisher.ByteCounter
+	cycleBytes *publisher.PublishedBytes
+	overBudget bool
+
+	// pollTimings is the upsd request breakdown of the last poll, nil when
+	// diagnostics.poll_timings is off.
+	pollTimings *publisher.PollTimings
+
+	// started, pollsMade, failedPolls, failures (in a row), lastPollTook
+	// and brokers, for reconnects, feed diagnostics.telemetry.  brokers is
+	// nil for --once runs.
+	started                          time.Time
+	pollsMade, failedPolls, failures int64
+	lastPollTook                     time.Duration
+	brokers                          *brokerStatus
+
+	// hook is the [hook] script, or program when it runs as a plugin,
+	// started with the first poll and stopped by close.
+	hook interface {
+		Run(hook.Input) (hook.Output, error)
+		Close() error
+	}
+
+	// eventHooks tracks the [hooks] commands still running, which close
+	// waits for.
+	eventHooks sync.WaitGroup
+}
+
+// close stops anything the polls started and waits for [hooks] commands,
+// each bounded by hooks.timeout, to finish.
+func (st *pollState) close() {
+	if st.hook != nil {
+		st.hook.Close() //nolint:errcheck
+	}
+	st.eventHooks.Wait()
+}
+
+// configure sets up the parts of st built from cfg: at startup, with prev
+// nil, and on a reload, with prev the config being replaced.  The alert
+// engine is only rebuilt when the rules changed, since a new one forgets
+// which alerts are active.
+func (st *pollState) configure(prev, cfg *config.Config) error {
+	if prev == nil || !reflect.DeepEqual(prev.Alerts, cfg.Alerts) {
+		engine, err := newAlertEngine(cfg)
+		if err != nil {
+			return fmt.Errorf("configuring alerts: %w", err)
+		}
+		st.alerts = engine
+	}
+	st.notifiers, st.notifyRoutes = newNotifiers(cfg)
+	st.templates, _ = notify.ParseTemplates(cfg.Notifications.Templates) // validated by config.Load
+	st.quietHours = nil
+	if w := cfg.Notifications.QuietHours; w != "" {
+		qh, _ := schedule.Parse(w) // validated by config.Load
+		st.quietHours = &qh
+	}
+	if prev == nil || prev.Summary.Time != cfg.Summary.Time {
+		st.summaryDue = time.Time{}
+		if cfg.Summary.Time != "" {
+			at, _ := schedule.ParseDaily(cfg.Summary.Time) // validated by config.Load
+			st.summaryDue = at.Next(st.clock.Now())
+		}
+	}
+	if st.published != nil {
+		st.published.SetClasses(topicClasses(cfg))
+	}
+	return nil
+}
+
+func newPollState() *pollState {
+	return newPollStateAt(clock.Real)
+}
+
+// newPollStateAt returns a pollState whose polls read the time from clk.
+func newPollStateAt(clk clock.Clock) *pollState {
+	return &pollState{clock: clk, changes: publisher.NewChangeTracker(), started: clk.Now()}
+}
+
+// doPoll fetches NUT variables, computes metrics, and publishes everything,
+// updating st with the cross-poll state.  Each step is a function of its
+// own below, in the order the poll runs them.
+func doPoll(poller nut.Poller, pub publisher.Publisher, cfg *config.Config, st *pollState) error {
+	countCycle(cfg, st)
+	if cfg.MQTT.PollComplete {
+		defer publishPollComplete(pub, cfg, st)
+	}
+	sent := st.clock.Now()
+	vars, err := poller.Poll()
+	recordTimings(poller, err, cfg, st)
+	if err == nil && nut.DriverStale(nut.VarsToMap(vars)) {
+		err = fmt.Errorf("driver is reconnecting to the UPS: %w", nut.ErrDataStale)
+	}
+	countPoll(st.clock.Now().Sub(sent), err, st)
+	if err != nil {
+		publishPollFailure(err, pub, cfg, st)
+		if cfg.Diagnostics.Telemetry {
+			if perr := publishBridge(nil, sent, st.clock.Now(), pub, cfg, st); perr != nil {
+				log.Print(perr)
+			}
+		}
+		return fmt.Errorf("polling NUT: %w", err)
+	}
+	now := st.clock.Now()
+	if err := recordPoll(nut.VarsToMap(vars), now, cfg); err != nil {
+		log.Printf("record file: %v", err)
+	}
+
+	varMap, q := cleanVars(nut.VarsToMap(vars), now, cfg, st)
+	var hookComputed map[string]string
+	if cfg.Hook.Command != "" || cfg.Hook.Script != "" {
+		out, err := runHook(varMap, now, cfg, st)
+		switch {
+		case err != nil:
+			log.Printf("hook: %v — publishing the poll unmodified", err)
+		case out.Veto:
+			log.Printf("hook: vetoed this poll's publishes")
+			return nil
+		default:
+			if out.Variables != nil {
+				varMap = out.Variables
+			}
+			hookComputed = out.Computed
+		}
+	}
+	st.notifyVars = varMap
+	metricVars := debounceStatus(q.MetricsVars(withDefaults(varMap, cfg.NUT.Defaults)), cfg, st)
+	m := metrics.ComputeWith(metricVars, metricsOptions(cfg))
+	// Low-power mode ends before the poll that finds mains back publishes,
+	// and starts after the first one on battery has, so the secondary
+	// sinks see both.
+	lowPower := cfg.LowPower.Enabled && m.OnBattery
+	if !lowPower {
+		st.setLowPower(false, cfg)
+	}
+
+	if err := publishReading(varMap, metricVars, m, hookComputed, now, pub, cfg, st); err != nil {
+		return err
+	}
+	obs, err := publishBattery(varMap, metricVars["ups.status"], m, now, pub, cfg, st)
+	if err != nil {
+		return err
+	}
+	// Until mains have been back for nut.mains_stable the outage is still
+	// on, and both status events and the events topic see the status from
+	// before power returned, so a flapping grid raises neither
+	// power_restored nor a new on_battery / power_lost.
+	outage := st.outageOngoing(m.OnBattery, now, cfg.NUT.MainsStable.Duration)
+	status := withLowBattery(metricVars["ups.status"], m.LowBattery)
+	eventStatus := status
+	if outage && !m.OnBattery {
+		eventStatus = st.eventStatus
+	}
+	charge, chargeErr := strconv.ParseFloat(varMap["battery.charge"], 64)
+	reading := alerts.Reading{Status: eventStatus, Charge: charge, ChargeOK: chargeErr == nil}
+	if err := publishTransitions(reading, now, pub, cfg, st); err != nil {
+		return err
+	}
+	if err := publishBridge(varMap, sent, now, pub, cfg, st); err != nil {
+		return err
+	}
+	updateQuietHours(poller, now, cfg, st)
+	if err := evaluateAlerts(obs, pub, cfg, st); err != nil {
+		return err
+	}
+	for _, ev := range alerts.StatusEvents(st.eventStatus, eventStatus) {
+		runEventHook(ev.Name, varMap, nil, cfg, st)
+		if err := sendNotification(withRuntime(ev, m), now, pub, cfg, st); err != nil {
+			return err
+		}
+	}
+	st.eventStatus = eventStatus
+
+	if err := publishExtras(varMap, now, pub, cfg, st); err != nil {
+		return err
+	}
+	st.lastVars, st.lastMetrics, st.lastPolled = varMap, m, now
+
+	if err := trackOutage(varMap, m, outage, now, pub, cfg, st); err != nil {
+		return err
+	}
+	wakeOnLAN(status, outage, now, cfg, st)
+	if err := publishSummary(varMap, m, now, pub, cfg, st); err != nil {
+		return err
+	}
+	st.setLowPower(lowPower, cfg)
+	return nil
+}
+
+// withLowBattery adds LB to status when low_battery is set but the UPS
+// hasn't raised it, as with low_battery.thresholds, so status events, the
+// events topic and Wake-on-LAN treat the threshold like the UPS's own flag.
+func withLowBattery(status string, low bool) string {
+	if !low || slices.Contains(strings.Fields(status), "LB") {
+		return status
+	}
+	return strings.TrimSpace(status + " LB")
+}
+
+// publishPollFailure marks communication as lost, and the data as stale
+// when the driver says so, after a failed poll.  Frozen values are never
+// republished as live; the state topic is downgraded to offline until the
+// driver recovers, or until upsd lists the UPS again when it has gone
+// missing.
+func publishPollFailure(err error, pub publisher.Publisher, cfg *config.Config, st *pollState) {
+	pubCfg := publishConfig(cfg)
+	if perr := publisher.PublishCommunicationLost(true, pubCfg, pub); perr != nil {
+		log.Printf("publishing communication_lost: %v", perr)
+	}
+	if errors.Is(err, nut.ErrDataStale) {
+		if perr := publisher.PublishDataStale(true, pubCfg, pub); perr != nil {
+			log.Printf("publishing data_stale: %v", perr)
+		}
+	}
+	if !st.reading.CommsLost {
+		runEventHook("poll_failure", st.lastVars, []string{"UPS_MQTT_ERROR=" + err.Error()}, cfg, st)
+	}
+	lost := st.reading
+	lost.CommsLost = true
+	lost.Missing = lost.Missing || errors.Is(err, nut.ErrUPSNotFound)
+	if perr := publishTransitions(lost, st.clock.Now(), pub, cfg, st); perr != nil {
+		log.Print(perr)
+	}
+}
+
+// cleanVars applies the matching quirk profiles, the plausibility filter
+// and hold_missing to a poll's variables, and returns them with the quirks
+// that applied.
+func cleanVars(varMap map[string]string, now time.Time, cfg *config.Config, st *pollState) (map[string]string, quirks.Set) {
+	q := quirks.Match(quirkProfiles(cfg), varMap)
+	if names := strings.Join(q.Names(), ", "); names != st.quirks {
+		if names == "" {
+			log.Printf("no quirk profiles apply any more")
+		} else {
+			log.Printf("applying quirk profiles: %s", names)
+		}
+		st.quirks = names
+	}
+	varMap = q.Apply(varMap)
+	if filter := newFilter(cfg.Filter, q); len(filter.Rules) > 0 {
+		var glitches []plausibility.Glitch
+		varMap, glitches = filter.Apply(varMap)
+		for _, g := range glitches {
+			log.Printf("implausible %s=%q (%s) — %s", g.Variable, g.Value, g.Reason, cfg.Filter.Mode)
+		}
+		st.glitches += int64(len(glitches))
+	}
+	if cfg.NUT.HoldMissing.Duration > 0 {
+		st.held.MaxAge = cfg.NUT.HoldMissing.Duration
+		varMap, _ = st.held.Apply(varMap, now)
+	}
+	return varMap, q
+}
+
+// publishReading publishes the variables, the computed metrics and the
+// state topic, as often as variables_every and computed_every (or their
+// low_power counterparts) allow, and clears communication_lost and
+// data_stale.
+//
+// A status change, either of ups.status or, with nut.status_debounce, of
+// the status the metrics follow, or low_battery changing with
+// low_battery.thresholds, publishes everything immediately so the individual
+// topics never lag behind the state topic on an outage.  Going on or off
+// battery, or reaching low battery, also sends the topics automations react
+// to first, ahead of the bulk of the poll.
+func publishReading(varMap, metricVars map[string]string, m metrics.Metrics, hookComputed map[string]string, now time.Time, pub publisher.Publisher, cfg *config.Config, st *pollState) error {
+	pubCfg := publishConfig(cfg)
+	statusChanged := st.lastVars != nil && (st.lastVars["ups.status"] != varMap["ups.status"] || m.StatusDisplay != st.lastMetrics.StatusDisplay || m.LowBattery != st.lastMetrics.LowBattery)
+	urgent := statusChanged && (m.OnBattery != st.lastMetrics.OnBattery || m.LowBattery != st.lastMetrics.LowBattery)
+	if urgent {
+		if err := publisher.PublishCritical(varMap, m, now, pubCfg, pub); err != nil {
+			return fmt.Errorf("publishing: %w", err)
+		}
+	}
+	varsEvery, computedEvery := cfg.MQTT.VariablesEvery, cfg.MQTT.ComputedEvery
+	if st.lowPower {
+		varsEvery, computedEvery = cfg.LowPower.VariablesEvery, cfg.LowPower.ComputedEvery
+	}
+	if statusChanged || due(varsEvery, st.polls) {
+		if err := publisher.PublishVariables(varMap, pubCfg, pub); err != nil {
+			return fmt.Errorf("publishing: %w", err)
+		}
+	}
+	if statusChanged || due(computedEvery, st.polls) {
+		if err := publisher.PublishMetrics(m, pubCfg, pub); err != nil {
+			return fmt.Errorf("publishing: %w", err)
+		}
+		if e, ok := metrics.ComputeEfficiency(metricVars, efficiencyCurve(cfg), metricsOptions(cfg)); ok {
+			for name, payload := range e.AsTopicMap() {
+				if err := publisher.PublishComputed(name, payload, pubCfg, pub); err != nil {
+					return fmt.Errorf("publishing efficiency: %w", err)
+				}
+			}
+		}
+		if a, ok := metrics.ComputeApparentPower(metricVars); ok {
+			for name, payload := range a.AsTopicMap() {
+				if err := publisher.PublishComputed(name, payload, pubCfg, pub); err != nil {
+					return fmt.Errorf("publishing apparent power: %w", err)
+				}
+			}
+		}
+		for name, payload := range hookComputed {
+			if err := publisher.PublishComputed(name, payload, pubCfg, pub); err != nil {
+				return fmt.Errorf("publishing hook values: %w", err)
+			}
+		}
+	}
+	if err := publishTemperature(metrics.ComputeTemperature(metricVars), statusChanged || due(computedEvery, st.polls), pubCfg, pub, cfg, st); err != nil {
+		return err
+	}
+	if !urgent {
+		if err := publisher.PublishStateAt(varMap, m, now, pubCfg, pub); err != nil {
+			return fmt.Errorf("publishing: %w", err)
+		}
+	}
+	st.polls++
+	if err := publisher.PublishCommunicationLost(false, pubCfg, pub); err != nil {
+		return fmt.Errorf("publishing communication_lost: %w", err)
+	}
+	if err := publisher.PublishDataStale(false, pubCfg, pub); err != nil {
+		return fmt.Errorf("publishing data_stale: %w", err)
+	}
+	return nil
+}
+
+// publishTemperature publishes computed/ups_temperature and
+// battery_temperature when all is set, and computed/over_temperature when
+// all is or it has just changed, so that crossing the limit isn't held
+// back by computed_every.  over_temperature needs metrics.max_temperature
+// and at least one of the temperatures.
+func publishTemperature(temp metrics.Temperature, all bool, pubCfg publisher.PublishConfig, pub publisher.Publisher, cfg *config.Config, st *pollState) error {
+	if all {
+		for name, payload := range temp.AsTopicMap() {
+			if err := publisher.PublishComputed(name, payload, pubCfg, pub); err != nil {
+				return fmt.Errorf("publishing temperature: %w", err)
+			}
+		}
+	}
+	if _, ok := temp.Hottest(); !ok || cfg.Metrics.MaxTemperature == 0 {
+		return nil
+	}
+	was := st.overTemperature
+	st.overTemperature = temp.Over(cfg.Metrics.MaxTemperature, cfg.Metrics.TemperatureHysteresis, was)
+	if !all && st.overTemperature == was {
+		return nil
+	}
+	if err := publisher.PublishComputed("over_temperature", strconv.FormatBool(st.overTemperature), pubCfg, pub); err != nil {
+		return fmt.Errorf("publishing over_temperature: %w", err)
+	}
+	return nil
+}
+
+// publishBattery publishes the metrics tracked across polls — the charge
+// rate, the smoothed runtime, the runtime estimate and the charger state — and returns the
+// observation the alert rules are evaluated against.  status is ups.status
+// after nut.status_debounce.
+func publishBattery(varMap map[string]string, status string, m metrics.Metrics, now time.Time, pub publisher.Publisher, cfg *config.Config, st *pollState) (alerts.Observation, error) {
+	pubCfg := publishConfig(cfg)
+	obs := alerts.Observation{Time: now, Status: status}
+	if window := cfg.Metrics.ChargeRateWindow.Duration; window > 0 {
+		if charge, err := strconv.ParseFloat(varMap["battery.charge"], 64); err == nil {
+			st.chargeRate.Window = window
+			if rate, ok := st.chargeRate.Add(charge, now); ok {
+				obs.ChargeRate = &rate
+				payload := strconv.FormatFloat(math.Round(rate*1000)/1000, 'f', -1, 64)
+				if err := publisher.PublishComputed("battery_charge_rate", payload, pubCfg, pub); err != nil {
+					return obs, fmt.Errorf("publishing charge rate: %w", err)
+				}
+			}
+		}
+	}
+	if window := cfg.Metrics.RuntimeSmoothing.Duration; window > 0 {
+		if runtime, err := strconv.ParseFloat(varMap["battery.runtime"], 64); err == nil {
+			st.runtimeAvg.Window = window
+			mins := st.runtimeAvg.Add(runtime/60, now)
+			payload := strconv.FormatFloat(math.Round(mins*100)/100, 'f', -1, 64)
+			if err := publisher.PublishComputed("battery_runtime_mins_smoothed", payload, pubCfg, pub); err != nil {
+				return obs, fmt.Errorf("publishing smoothed runtime: %w", err)
+			}
+		}
+	}
+	charge, chargeErr := strconv.ParseFloat(varMap["battery.charge"], 64)
+	if err := publishRuntimeEstimate(m.OnBattery, charge, chargeErr == nil, now, pub, cfg, st); err != nil {
+		return obs, err
+	}
+	var rate float64
+	if obs.ChargeRate != nil {
+		rate = *obs.ChargeRate
+	}
+	st.charger.Hold = cfg.Metrics.ChargerStateHold.Duration
+	state := st.charger.Update(status, charge, chargeErr == nil, rate, obs.ChargeRate != nil, now)
+	if err := publisher.PublishComputed("charger_state", state, pubCfg, pub); err != nil {
+		return obs, fmt.Errorf("publishing charger state: %w", err)
+	}
+	return obs, nil
+}
+
+// publishExtras publishes the optional per-poll topics: Home Assistant
+// discovery (once), the glitch count, last-changed times and the diff.
+func publishExtras(varMap map[string]string, now time.Time, pub publisher.Publisher, cfg *config.Config, st *pollState) error {
+	pubCfg := publishConfig(cfg)
+	if err := announceDiscovery(varMap, pub, cfg, st); err != nil {
+		return err
+	}
+
+	if cfg.Filter.Enabled {
+		if err := publisher.PublishGlitchCount(st.glitches, pubCfg, pub); err != nil {
+			return fmt.Errorf("publishing glitch count: %w", err)
+		}
+	}
+
+	changed := st.changes.Changed(varMap)
+	if err := publisher.PublishLastChanged(changed, now, pubCfg, pub); err != nil {
+		return fmt.Errorf("publishing last-changed: %w", err)
+	}
+
+	if cfg.MQTT.Diff && st.lastVars != nil {
+		if err := publisher.PublishDiff(publisher.DiffVars(st.lastVars, varMap), now, pubCfg, pub); err != nil {
+			return fmt.Errorf("publishing diff: %w", err)
+		}
+	}
+	return nil
+}
+
+// trackOutage publishes the outage topic while on battery and clears it
+// once the outage is over (see outageOngoing), recording it in the outage
+// history and publishing the counters over it.
+func trackOutage(varMap map[string]string, m metrics.Metrics, outage bool, now time.Time, pub publisher.Publisher, cfg *config.Config, st *pollState) error {
+	pubCfg := publishConfig(cfg)
+	if m.OnBattery {
+		if st.outageStart == nil {
+			st.outageStart = &now
+			log.Printf("power outage detected — UPS on battery")
+		}
+		if err := publisher.PublishOutageAt(varMap, m, *st.outageStart, now, pubCfg, pub); err != nil {
+			return fmt.Errorf("publishing outage: %w", err)
+		}
+	} else if st.outageStart != nil && !outage {
+		log.Printf("power restored — clearing outage topic")
+		ended := outages.Outage{Start: *st.outageStart, End: st.outageEnd}
+		st.outageStart = nil
+		if err := publisher.ClearOutage(pubCfg, pub); err != nil {
+			return fmt.Errorf("clearing outage: %w", err)
+		}
+		if err := recordOutage(&ended, pub, cfg, st); err != nil {
+			return err
+		}
+		return publishOutageCounters(now, pub, cfg, st)
+	}
+	if err := recordOutage(nil, pub, cfg, st); err != nil {
+		return err
+	}
+	return publishOutageCounters(now, pub, cfg, st)
+}
+
+// publishOutageCounters publishes the outage count, the last outage's
+// duration and the time since it ended, as computed/ topics, from the
+// history in outages.dir, so they carry over restarts.  An outage that is
+// still on counts once it is over, and time_since_last_outage_secs is 0
+// until then.
+func publishOutageCounters(now time.Time, pub publisher.Publisher, cfg *config.Config, st *pollState) error {
+	if st.outageHistory == nil {
+		return nil
+	}
+	c := st.outageHistory.Counters(now)
+	if st.outageStart != nil {
+		zero := 0.0
+		c.SinceLastSecs = &zero
+	}
+	pubCfg := publishConfig(cfg)
+	for name, payload := range c.AsTopicMap() {
+		if err := publisher.PublishComputed(name, payload, pubCfg, pub); err != nil {
+			return fmt.Errorf("publishing outage counters: %w", err)
+		}
+	}
+	return nil
+}
+
+// announceDiscovery announces the UPS to Home Assistant, when discovery is
+// on, unless that was done since st.discovered was last cleared.
+func announceDiscovery(varMap map[string]string, pub publisher.Publisher, cfg *config.Config, st *pollState) error {
+	if !cfg.HomeAssistant.Discovery || st.discovered {
+		return nil
+	}
+	dcfg := publisher.DiscoveryConfig{Prefix: cfg.HomeAssistant.DiscoveryPrefix}
+	if err := publisher.PublishDiscovery(varMap, dcfg, publishConfig(cfg), pub); err != nil {
+		return fmt.Errorf("publishing discovery: %w", err)
+	}
+	st.discovered = true
+	return nil
+}
+
+// outageHistoryPath returns the file in outages.dir holding the outage
+// history of the UPS in cfg.
+func outageHistoryPath(cfg *config.Config) string {
+	return filepath.Join(cfg.Outages.Dir, "outages_"+topicLabel(cfg)+".json")
+}
+
+// recordOutage adds ended, when not nil, to the outage history in
+// outages.dir and publishes the statistics over it; they are also
+// published with the first poll.  The history is read then too, and a file
+// that can't be read or parsed turns the history off until a restart, so
+// that it isn't replaced.  Failing to write the file is logged, not fatal.
+func recordOutage(ended *outages.Outage, pub publisher.Publisher, cfg *config.Config, st *pollState) error {
+	if cfg.Outages.Dir == "" {
+		return nil
+	}
+	path := outageHistoryPath(cfg)
+	first := !st.outageHistoryRead
+	if first {
+		st.outageHistoryRead = true
+		data, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("outages: %v — outage history disabled", err)
+			return nil
+		}
+		h, err := outages.Parse(data)
+		if err != nil {
+			log.Printf("outages: parsing %s: %v — outage history disabled", path, err)
+			return nil
+		}
+		st.outageHistory = &h
+	}
+	if st.outageHistory == nil || (ended == nil && !first) {
+		return nil
+	}
+	if ended != nil {
+		st.outageHistory.Add(*ended)
+		data, err := st.outageHistory.Marshal()
+		if err == nil {
+			err = writeFileAtomic(path, data)
+		}
+		if err != nil {
+			log.Printf("outages: writing %s: %v", path, err)
+		}
+	}
+	if err := publisher.PublishOutageStats(st.outageHistory.Stats(time.Local), publishConfig(cfg), pub); err != nil {
+		return fmt.Errorf("publishing outage stats: %w", err)
+	}
+	return nil
+}
+
+// publishSummary adds the poll to the daily summary, first publishing the
+// summary of the polls before it once summary.time has passed, and sending
+// it to the notifiers as daily_summary with summary.notify.  Gaps of more
+// than three poll intervals, when the bridge was down or polls failed,
+// count towards neither energy nor time on battery.
+func publishSummary(varMap map[string]string, m metrics.Metrics, now time.Time, pub publisher.Publisher, cfg *config.Config, st *pollState) error {
+	if st.summaryDue.IsZero() {
+		return nil
+	}
+	if !now.Before(st.summaryDue) {
+		report := st.summary.Report(now)
+		st.summary = summary.Period{}
+		at, _ := schedule.ParseDaily(cfg.Summary.Time)
+		st.summaryDue = at.Next(now)
+		if err := publisher.PublishSummary(report, publishConfig(cfg), pub); err != nil {
+			return fmt.Errorf("publishing summary: %w", err)
+		}
+		if cfg.Summary.Notify {
+			ev := alerts.Event{Name: "daily_summary", Severity: alerts.SeverityInfo, Message: report.Message()}
+			if err := sendNotification(ev, now, pub, cfg, st); err != nil {
+				return err
+			}
+		}
+	}
+	volts, voltsErr := strconv.ParseFloat(varMap["input.voltage"], 64)
+	load, loadErr := strconv.ParseFloat(varMap["ups.load"], 64)
+	st.summary.MaxGap = 3 * cfg.NUT.PollInterval.Duration
+	st.summary.Add(summary.Reading{
+		Time:           now,
+		OnBattery:      m.OnBattery,
+		InputVoltage:   volts,
+		InputVoltageOK: voltsErr == nil,
+		LoadPct:        load,
+		LoadPctOK:      loadErr == nil,
+		LoadWatts:      m.LoadWatts,
+	})
+	return nil
+}
+
+// publishRuntimeEstimate feeds the charge to st.runtime while on battery
+// and publishes computed/estimated_runtime_mins once the fitted discharge
+// rate gives one.  When mains return the estimate is forgotten and the
+// topic cleared, so a stale figure from the last outage doesn't linger.
+func publishRuntimeEstimate(onBattery bool, charge float64, chargeOK bool, now time.Time, pub publisher.Publisher, cfg *config.Config, st *pollState) error {
+	pubCfg := publishConfig(cfg)
+	window := cfg.Metrics.RuntimeWindow.Duration
+	if window <= 0 {
+		return nil
+	}
+	if !onBattery {
+		st.runtime.Reset()
+		if !st.runtimeShown {
+			return nil
+		}
+		st.runtimeShown = false
+		if err := publisher.PublishComputed("estimated_runtime_mins", "", pubCfg, pub); err != nil {
+			return fmt.Errorf("clearing runtime estimate: %w", err)
+		}
+		return nil
+	}
+	if !chargeOK {
+		return nil
+	}
+	st.runtime.Window = window
+	mins, ok := st.runtime.Add(charge, now)
+	if !ok {
+		return nil
+	}
+	st.runtimeShown = true
+	payload := strconv.FormatFloat(math.Round(mins*10)/10, 'f', -1, 64)
+	if err := publisher.PublishComputed("estimated_runtime_mins", payload, pubCfg, pub); err != nil {
+		return fmt.Errorf("publishing runtime estimate: %w", err)
+	}
+	return nil
+}
+
+// runHook passes the poll's variables through the [hook] program, started
+// for this poll or, with hook.plugin, kept running in st, or through the
+// hook.script Lua script, also kept in st.
+func runHook(vars map[string]string, now time.Time, cfg *config.Config, st *pollState) (hook.Output, error) {
+	in := hook.Input{
+		Timestamp: now.UTC().Format(time.RFC3339),
+		UPSName:   cfg.NUT.EffectiveLabel(),
+		Variables: vars,
+	}
+	if cfg.Hook.Script != "" {
+		if st.hook == nil {
+			st.hook = &hook.Script{Path: cfg.Hook.Script, Timeout: cfg.Hook.Timeout.Duration}
+		}
+		return st.hook.Run(in)
+	}
+	if cfg.Hook.Plugin {
+		if st.hook == nil {
+			st.hook = &hook.Plugin{Process: &plugin.Process{
+				Command: cfg.Hook.Command,
+				Args:    cfg.Hook.Args,
+				Timeout: cfg.Hook.Timeout.Duration,
+			}}
+		}
+		return st.hook.Run(in)
+	}
+	h := hook.Hook{Command: cfg.Hook.Command, Args: cfg.Hook.Args, Timeout: cfg.Hook.Timeout.Duration}
+	return h.Run(in)
+}
+
+// outageOngoing reports whether the current outage is still on: the UPS is
+// on battery, or came off battery less than stable ago.  restoredAt records
+// when it did, and is cleared when it goes back on battery or the restore is
+// confirmed.
+func (st *pollState) outageOngoing(onBattery bool, now time.Time, stable time.Duration) bool {
+	if onBattery {
+		st.restoredAt = nil
+		return true
+	}
+	if st.outageStart == nil {
+		return false
+	}
+	if st.restoredAt == nil {
+		st.restoredAt = &now
+		if stable > 0 {
+			log.Printf("UPS back on mains; power counts as restored once it stays up for %s", stable)
+		}
+	}
+	if now.Sub(*st.restoredAt) < stable {
+		return true
+	}
+	st.outageEnd = *st.restoredAt
+	st.restoredAt = nil
+	return false
+}
+
+// setLowPower enters or leaves low-power mode, pausing or resuming the
+// secondary sinks; the poll loop picks up the change of interval.
+func (st *pollState) setLowPower(on bool, cfg *config.Config) {
+	if on == st.lowPower {
+		return
+	}
+	st.lowPower = on
+	if st.sinksPaused != nil {
+		st.sinksPaused.Store(on)
+	}
+	if on {
+		log.Printf("on battery: low-power mode — polling every %s, pausing secondary sinks and exports", cfg.LowPower.PollInterval)
+	} else {
+		log.Printf("leaving low-power mode")
+	}
+}
+
+// takeTick reports whether the poll for the tick at t should run.  A tick
+// that is already an interval old was queued behind a poll that overran —
+// typically a slow broker holding up publishing — so it is skipped instead
+// of starting the next poll straight away.  Skipped ticks are counted in
+// st.skipped, along with any the ticker dropped while the loop was busy.
+func (st *pollState) takeTick(t, now time.Time, interval time.Duration) bool {
+	if !st.lastTick.IsZero() {
+		if missed := int64(t.Sub(st.lastTick)/interval) - 1; missed > 0 {
+			st.skipped += missed
+		}
+	}
+	st.lastTick = t
+	if now.Sub(t) >= interval {
+		st.skipped++
+		return false
+	}
+	return true
+}
+
+// due reports whether a topic class published every Nth poll is due on the
+// poll numbered n (counting from zero).  every <= 1 means every poll.
+func due(every int, n int64) bool {
+	return every <= 1 || n%int64(every) == 0
+}
+
+// topicLabel returns the UPS label as it appears in topics, made safe by
+// publisher.TopicLevel.
+func topicLabel(cfg *config.Config) string {
+	return publisher.TopicLevel(cfg.NUT.EffectiveLabel())
+}
+
+// publishConfig derives the publisher routing parameters from cfg.
+func publishConfig(cfg *config.Config) publisher.PublishConfig {
+	return publisher.PublishConfig{
+		Prefix:            cfg.MQTT.TopicPrefix,
+		UPSName:           topicLabel(cfg),
+		Retained:          cfg.MQTT.Retained,
+		LastChanged:       cfg.MQTT.LastChanged,
+		NonRetained:       cfg.MQTT.NonRetained,
+		IncludeVars:       cfg.MQTT.IncludeVars,
+		ExcludeVars:       cfg.MQTT.ExcludeVars,
+		NamespacePrefixes: cfg.MQTT.NamespacePrefixes,
+		MaxStateBytes:     cfg.MQTT.MaxStateBytes,
+		StateOverflow:     cfg.MQTT.StateOverflow,
+		Labels:            cfg.Labels,
+		RetainTTL:         cfg.MQTT.RetainTTL.Duration,
+	}
+}
+
+// newFilter builds the plausibility filter from the built-in rules overlaid
+// with the matched quirk profiles' rules and then any per-variable bounds
+// from config.  With the filter disabled only the quirk rules apply.
+func newFilter(cfg config.FilterConfig, q quirks.Set) plausibility.Filter {
+	rules := q.Rules()
+	if cfg.Enabled {
+		for name, r := range plausibility.DefaultRules() {
+			if _, ok := rules[name]; !ok {
+				rules[name] = r
+			}
+		}
+		for name, b := range cfg.Bounds {
+			rules[name] = plausibility.Rule{Min: b.Min, Max: b.Max, ZeroOnlyOnBattery: b.ZeroOnlyOnBattery}
+		}
+	}
+	return plausibility.Filter{Mode: plausibility.Mode(cfg.Mode), Rules: rules}
+}
+
+// debounceStatus returns vars with ups.status held at its settled value
+// until a new one has been seen on nut.status_debounce polls in a row.
+func debounceStatus(vars map[string]string, cfg *config.Config, st *pollState) map[string]string {
+	st.status.Polls = cfg.NUT.StatusDebounce
+	status := st.status.Update(vars["ups.status"])
+	if status == vars["ups.status"] {
+		return vars
+	}
+	out := maps.Clone(vars)
+	out["ups.status"] = status
+	return out
+}
+
+// metricsOptions returns the metrics.Options configured under [metrics].
+func metricsOptions(cfg *config.Config) metrics.Options {
+	return metrics.Options{
+		PowerFactor:     cfg.Metrics.PowerFactor,
+		StatusSeparator: cfg.Metrics.StatusSeparator,
+		StatusCase:      cfg.Metrics.StatusCase,
+		StatusShort:     cfg.Metrics.StatusShort,
+		LowThresholds:   cfg.LowBattery.Thresholds,
+		Unavailable:     cfg.Metrics.Unavailable,
+	}
+}
+
+// efficiencyCurve converts metrics.efficiency_curve, whose keys config has
+// already validated as load percentages.
+func efficiencyCurve(cfg *config.Config) []metrics.CurvePoint {
+	curve := make([]metrics.CurvePoint, 0, len(cfg.Metrics.EfficiencyCurve))
+	for load, eff := range cfg.Metrics.EfficiencyCurve {
+		l, _ := strconv.ParseFloat(load, 64)
+		curve = append(curve, metrics.CurvePoint{LoadPct: l, EfficiencyPct: eff})
+	}
+	return curve
+}
+
+// quirkProfiles returns the built-in quirk profiles, if enabled, followed by
+// the configured ones.
+func quirkProfiles(cfg *config.Config) []quirks.Profile {
+	var profiles []quirks.Profile
+	if cfg.Quirks.Builtin {
+		profiles = quirks.Builtin()
+	}
+	for _, qc := range cfg.Quirks.Profiles {
+		p := quirks.Profile{
+			Name:              qc.Name,
+			Manufacturer:      qc.Manufacturer,
+			Model:             qc.Model,
+			VendorID:          qc.VendorID,
+			Drop:              qc.Drop,
+			Scale:             qc.Scale,
+			LoadFromRealPower: qc.LoadFromRealPower,
+		}
+		if len(qc.Bounds) > 0 {
+			p.Rules = make(map[string]plausibility.Rule, len(qc.Bounds))
+			for name, b := range qc.Bounds {
+				p.Rules[name] = plausibility.Rule{Min: b.Min, Max: b.Max, ZeroOnlyOnBattery: b.ZeroOnlyOnBattery}
+			}
+		}
+		if len(qc.Defaults) > 0 {
+			p.Defaults = make(map[string]string, len(qc.Defaults))
+			for name, v := range qc.Defaults {
+				p.Defaults[name] = string(v)
+			}
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles
+}
+
+// withDefaults returns vars with any configured fallback values added for
+// variables the UPS did not report.  Only metrics see the result; the raw
+// variable topics keep publishing what the UPS actually said.
+func withDefaults(vars map[string]string, defaults map[string]config.Value) map[string]string {
+	if len(defaults) == 0 {
+		return vars
+	}
+	out := make(map[string]string, len(vars)+len(defaults))
+	for name, v := range defaults {
+		out[name] = string(v)
+	}
+	for name, v := range vars {
+		out[name] = v
+	}
+	return out
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/config"
+)
+
+// recordMu serializes appends to diagnostics.record_file, which the
+// pipelines of several UPSes share.
+var recordMu sync.Mutex
+
+// recordPoll appends vars, a successful poll as upsd returned it, to
+// diagnostics.record_file when it is set: a pollSnapshot per line, or, for
+// a .csv file, a timestamp,ups_name,variable,value row per variable under
+// a header written when the file is new.
+func recordPoll(vars map[string]string, now time.Time, cfg *config.Config) error {
+	path := cfg.Diagnostics.RecordFile
+	if path == "" {
+		return nil
+	}
+	recordMu.Lock()
+	defer recordMu.Unlock()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close() //nolint:errcheck // closed and checked below
+	timestamp := now.UTC().Format(time.RFC3339)
+
+	var b strings.Builder
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		w := csv.NewWriter(&b)
+		if info, err := f.Stat(); err == nil && info.Size() == 0 {
+			w.Write([]string{"timestamp", "ups_name", "variable", "value"}) //nolint:errcheck // writes to a strings.Builder
+		}
+		names := make([]string, 0, len(vars))
+		for name := range vars {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			w.Write([]string{timestamp, cfg.NUT.EffectiveLabel(), name, vars[name]}) //nolint:errcheck
+		}
+		w.Flush()
+	} else {
+		data, err := json.Marshal(pollSnapshot{Timestamp: timestamp, UPSName: cfg.NUT.EffectiveLabel(), Variables: vars})
+		if err != nil {
+			return fmt.Errorf("marshalling poll: %w", err)
+		}
+		b.Write(data)
+		b.WriteByte('\n')
+	}
+	// One write per poll, so that an append is never interleaved with
+	// another process's.
+	if _, err := f.WriteString(b.String()); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"reflect"
+	"slices"
+
+	"github.com/sweeney/ups-mqtt/internal/config"
+)
+
+// reloadOnSIGHUP reloads the config from paths on every signal received on
+// hup until ctx is cancelled, and hands it to reload, which passes each
+// UPS's share of it to the matching run.  A config that fails to load, or
+// that changes the [[nut.ups]] list, is logged and ignored.
+func reloadOnSIGHUP(ctx context.Context, cfg *config.Config, paths []string, hup <-chan os.Signal, reload func(next *config.Config)) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		}
+		log.Printf("SIGHUP: reloading configuration")
+		next, err := config.Load(paths...)
+		if err != nil {
+			log.Printf("reload: %v — keeping the running config", err)
+			continue
+		}
+		if !slices.Equal(upsLabels(next), upsLabels(cfg)) {
+			log.Printf("reload: the [[nut.ups]] list changed — restart to apply it")
+			continue
+		}
+		reload(next)
+	}
+}
+
+// upsLabels returns the name and label of every UPS cfg polls, in order.
+func upsLabels(cfg *config.Config) []string {
+	var out []string
+	for _, c := range cfg.PerUPS() {
+		out = append(out, c.NUT.UPSName+"/"+c.NUT.EffectiveLabel())
+	}
+	return out
+}
+
+// reloadConfig returns cur with the settings that can change while the
+// poll loop runs taken from next: poll timing, publishing options, filters,
+// quirks, metrics, alerts, notifications, labels, low-power mode, the
+// [hooks] commands and the per-poll exports.
+// Connections, subscriptions, sinks, the hook, the topic prefix (which the
+// LWT, subscriptions and migration mirror are bound to) and anything else
+// set up once at startup keep their current values; the names of the
+// sections where next differs in those are returned.
+func reloadConfig(cur, next *config.Config) (*config.Config, []string) {
+	merged := *cur
+
+	n, m := next.NUT, &merged.NUT
+	m.PollInterval, m.AlignPolls, m.HoldMissing = n.PollInterval, n.AlignPolls, n.HoldMissing
+	m.PollVars = n.PollVars
+	m.Defaults, m.ExpectedClients = n.Defaults, n.ExpectedClients
+	m.ClockSkewThreshold, m.MainsStable, m.StatusDebounce = n.ClockSkewThreshold, n.MainsStable, n.StatusDebounce
+
+	q, mq := next.MQTT, &merged.MQTT
+	mq.Retained, mq.LastChanged, mq.NonRetained = q.Retained, q.LastChanged, q.NonRetained
+	mq.NamespacePrefixes, mq.Diff, mq.Events, mq.RetainTTL = q.NamespacePrefixes, q.Diff, q.Events, q.RetainTTL
+	mq.VariablesEvery, mq.ComputedEvery = q.VariablesEvery, q.ComputedEvery
+	mq.MaxStateBytes, mq.StateOverflow = q.MaxStateBytes, q.StateOverflow
+	mq.ByteStats, mq.ByteBudget = q.ByteStats, q.ByteBudget
+	mq.IncludeVars, mq.ExcludeVars = q.IncludeVars, q.ExcludeVars
+	mq.RepublishOnConnect, mq.Aggregate, mq.PollComplete = q.RepublishOnConnect, q.Aggregate, q.PollComplete
+
+	merged.Filter, merged.Quirks, merged.Metrics = next.Filter, next.Quirks, next.Metrics
+	merged.Alerts, merged.Notifications, merged.Labels = next.Alerts, next.Notifications, next.Labels
+	merged.HomeAssistant, merged.WakeOnLAN, merged.Summary = next.HomeAssistant, next.WakeOnLAN, next.Summary
+	merged.Prometheus, merged.Pushgateway, merged.Grafana = next.Prometheus, next.Pushgateway, next.Grafana
+	merged.LowPower, merged.Hooks = next.LowPower, next.Hooks
+
+	var restart []string
+	mv, nv := reflect.ValueOf(merged), reflect.ValueOf(*next)
+	for i := range mv.NumField() {
+		if !reflect.DeepEqual(mv.Field(i).Interface(), nv.Field(i).Interface()) {
+			restart = append(restart, mv.Type().Field(i).Tag.Get("toml"))
+		}
+	}
+	return &merged, restart
+}
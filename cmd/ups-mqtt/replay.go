@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/clock"
+	"github.com/sweeney/ups-mqtt/internal/config"
+	"github.com/sweeney/ups-mqtt/internal/nut"
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+)
+
+// recordedPoll is one poll read back from a file written by --record.
+type recordedPoll struct {
+	at      time.Time
+	upsName string
+	vars    map[string]string
+}
+
+// replayMain publishes the polls recorded in path, connecting to the
+// broker as {client_id}-replay without an LWT, or printing them when dry is
+// set, and returns once the file has been played through or ctx is
+// cancelled.
+func replayMain(ctx context.Context, cfg *config.Config, path string, speed float64, dry publisher.Publisher) error {
+	polls, err := readRecording(path)
+	if err != nil {
+		return err
+	}
+	pub := dry
+	if pub == nil {
+		mqttCfg := cfg.MQTT
+		mqttCfg.ClientID += "-replay"
+		ps, err := publisher.NewMQTTPublisher(mqttCfg, "", "")
+		if err != nil {
+			return fmt.Errorf("connecting to MQTT broker: %w", err)
+		}
+		pub = ps
+	}
+	// Low-power mode never pauses the sinks of a replay.
+	var paused atomic.Bool
+	if pub, err = newSinks(cfg, pub, &paused); err != nil {
+		return fmt.Errorf("configuring sinks: %w", err)
+	}
+	defer pub.Close() //nolint:errcheck
+	return replay(ctx, polls, speed, pub, cfg)
+}
+
+// replay runs each poll through doPoll on a clock set to the time it was
+// recorded, waiting between polls for the time that passed between them
+// divided by speed; a speed of 0 doesn't wait.  Each UPS in the recording
+// gets a pollState and config of its own, as its pipeline would, with
+// nothing that reaches beyond MQTT: external notifiers, [hooks] commands,
+// Wake-on-LAN, the outage history and recording are left out.
+func replay(ctx context.Context, polls []recordedPoll, speed float64, pub publisher.Publisher, cfg *config.Config) error {
+	if len(polls) == 0 {
+		return nil
+	}
+	clk := clock.NewFake(polls[0].at)
+	type ups struct {
+		cfg *config.Config
+		st  *pollState
+	}
+	upses := make(map[string]ups)
+	defer func() {
+		for _, u := range upses {
+			u.st.close()
+		}
+	}()
+	for i, p := range polls {
+		if i > 0 && speed > 0 {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(time.Duration(float64(p.at.Sub(polls[i-1].at)) / speed)):
+			}
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		u, ok := upses[p.upsName]
+		if !ok {
+			c := cfg.ForUPS(p.upsName)
+			c.Notifications.Notifiers = nil
+			c.Hooks = config.HooksConfig{Timeout: c.Hooks.Timeout}
+			c.WakeOnLAN.Targets = nil
+			c.Outages.Dir = ""
+			c.Diagnostics.RecordFile = ""
+			u = ups{cfg: c, st: newPollStateAt(clk)}
+			if err := u.st.configure(nil, c); err != nil {
+				return err
+			}
+			upses[p.upsName] = u
+		}
+		clk.Set(p.at)
+		log.Printf("replay: %s poll of %s (%d/%d)", p.upsName, p.at.Format(time.RFC3339), i+1, len(polls))
+		if err := doPoll(replayPoller(p.vars), pub, u.cfg, u.st); err != nil {
+			log.Printf("replay: poll error: %v", err)
+		}
+	}
+	log.Printf("replay: %d poll(s) replayed", len(polls))
+	return nil
+}
+
+// replayPoller is a nut.Poller returning the variables of a recorded poll.
+type replayPoller map[string]string
+
+func (p replayPoller) Poll() ([]nut.Variable, error) {
+	vars := make([]nut.Variable, 0, len(p))
+	for name, value := range p {
+		vars = append(vars, nut.Variable{Name: name, Value: value})
+	}
+	slices.SortFunc(vars, func(a, b nut.Variable) int { return strings.Compare(a.Name, b.Name) })
+	return vars, nil
+}
+
+func (replayPoller) Close() error { return nil }
+
+// readRecording reads the polls in path, a file written by --record: JSON
+// lines, or CSV when the name ends in .csv.
+func readRecording(path string) ([]recordedPoll, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() //nolint:errcheck
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return readRecordingCSV(f)
+	}
+	var polls []recordedPoll
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		if strings.TrimSpace(sc.Text()) == "" {
+			continue
+		}
+		var s pollSnapshot
+		if err := json.Unmarshal(sc.Bytes(), &s); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		at, err := time.Parse(time.RFC3339, s.Timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		polls = append(polls, recordedPoll{at: at, upsName: s.UPSName, vars: s.Variables})
+	}
+	return polls, sc.Err()
+}
+
+// readRecordingCSV reads timestamp,ups_name,variable,value rows, the
+// consecutive rows of the same timestamp and UPS making up one poll.
+func readRecordingCSV(r io.Reader) ([]recordedPoll, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 4
+	var polls []recordedPoll
+	for line := 1; ; line++ {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return polls, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line == 1 && row[0] == "timestamp" {
+			continue
+		}
+		at, err := time.Parse(time.RFC3339, row[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if n := len(polls); n == 0 || !polls[n-1].at.Equal(at) || polls[n-1].upsName != row[1] {
+			polls = append(polls, recordedPoll{at: at, upsName: row[1], vars: make(map[string]string)})
+		}
+		polls[len(polls)-1].vars[row[2]] = row[3]
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/sweeney/ups-mqtt/internal/config"
+	"github.com/sweeney/ups-mqtt/internal/nut"
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+)
+
+// setupScanTimeout is how long setup waits for each address it probes for
+// upsd.
+const setupScanTimeout = 500 * time.Millisecond
+
+// errSetupAborted is returned when the answers run out part-way through.
+var errSetupAborted = errors.New("setup aborted")
+
+// wizard asks the questions of the setup subcommand.  Finding upsd,
+// listing its UPSes and trying the broker are functions so tests can
+// stand in for the network.
+type wizard struct {
+	in  *bufio.Scanner
+	out io.Writer
+
+	scan      func() []string
+	listUPS   func(host, username, password string) ([]nut.UPSInfo, error)
+	tryBroker func(config.MQTTConfig) error
+}
+
+// setupFile is the config setup writes: only what was asked, the rest
+// being left to the defaults.
+type setupFile struct {
+	Profile string `toml:"profile,omitempty"`
+	NUT     struct {
+		Host     string `toml:"host"`
+		Username string `toml:"username"`
+		Password string `toml:"password"`
+		UPSName  string `toml:"ups_name"`
+	} `toml:"nut"`
+	MQTT struct {
+		Broker   string `toml:"broker"`
+		Username string `toml:"username"`
+		Password string `toml:"password"`
+	} `toml:"mqtt"`
+}
+
+// setupMain runs the setup subcommand: it finds upsd, lists its UPSes,
+// tries the broker and writes a working config to path, for users who'd
+// rather answer a few questions than read the configuration reference.
+func setupMain(path string, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("usage: ups-mqtt [--config FILE] setup")
+	}
+	w := &wizard{
+		in:  bufio.NewScanner(os.Stdin),
+		out: os.Stdout,
+		scan: func() []string {
+			return nut.Scan(context.Background(), 3493, setupScanTimeout)
+		},
+		listUPS: func(host, username, password string) ([]nut.UPSInfo, error) {
+			c, err := nut.NewClient([]string{host}, 3493, username, password, "")
+			if err != nil {
+				return nil, err
+			}
+			defer c.Close() //nolint:errcheck
+			return c.List()
+		},
+		tryBroker: func(cfg config.MQTTConfig) error {
+			cfg.ClientID, cfg.QOS = "ups-mqtt-setup", 1
+			p, err := publisher.NewMQTTPublisher(cfg, "", "")
+			if err != nil {
+				return err
+			}
+			return p.Close()
+		},
+	}
+	f, err := w.run()
+	if err != nil {
+		return err
+	}
+	return w.write(path, f)
+}
+
+// run asks for the NUT server, UPS, broker and whether to set up Home
+// Assistant, checking each answer against the network before moving on.
+func (w *wizard) run() (*setupFile, error) {
+	f := &setupFile{}
+	fmt.Fprintln(w.out, "Looking for upsd on this host and the local network…")
+	found := w.scan()
+	def := "localhost"
+	if len(found) > 0 {
+		fmt.Fprintln(w.out, "Found upsd on:")
+		for i, host := range found {
+			fmt.Fprintf(w.out, "  %d) %s\n", i+1, host)
+		}
+		def = found[0]
+	} else {
+		fmt.Fprintln(w.out, "No upsd found; enter its address by hand.")
+	}
+
+	var ups []nut.UPSInfo
+	for {
+		host, err := w.ask("NUT server (number or host[:port])", def)
+		if err != nil {
+			return nil, err
+		}
+		if n, err := strconv.Atoi(host); err == nil && n >= 1 && n <= len(found) {
+			host = found[n-1]
+		}
+		f.NUT.Host = host
+		if f.NUT.Username, err = w.ask("NUT username (empty if upsd needs none)", ""); err != nil {
+			return nil, err
+		}
+		if f.NUT.Username != "" {
+			if f.NUT.Password, err = w.ask("NUT password (shown as typed)", ""); err != nil {
+				return nil, err
+			}
+		}
+		ups, err = w.listUPS(host, f.NUT.Username, f.NUT.Password)
+		if err == nil && len(ups) == 0 {
+			err = errors.New("upsd serves no UPS; check ups.conf")
+		}
+		if err == nil {
+			break
+		}
+		fmt.Fprintf(w.out, "Can't list the UPSes on %s: %v\n", host, err)
+	}
+
+	fmt.Fprintln(w.out, "UPSes on this server:")
+	for i, u := range ups {
+		fmt.Fprintf(w.out, "  %d) %s %s\n", i+1, u.Name, u.Description)
+	}
+	for f.NUT.UPSName == "" {
+		name, err := w.ask("UPS (number or name)", "1")
+		if err != nil {
+			return nil, err
+		}
+		for i, u := range ups {
+			if name == u.Name || name == strconv.Itoa(i+1) {
+				f.NUT.UPSName = u.Name
+			}
+		}
+		if f.NUT.UPSName == "" {
+			fmt.Fprintf(w.out, "No UPS %q on this server.\n", name)
+		}
+	}
+
+	for {
+		var err error
+		if f.MQTT.Broker, err = w.ask("MQTT broker URL", "tcp://localhost:1883"); err != nil {
+			return nil, err
+		}
+		if f.MQTT.Username, err = w.ask("MQTT username (empty for none)", ""); err != nil {
+			return nil, err
+		}
+		if f.MQTT.Username != "" {
+			if f.MQTT.Password, err = w.ask("MQTT password (shown as typed)", ""); err != nil {
+				return nil, err
+			}
+		}
+		fmt.Fprintf(w.out, "Connecting to %s…\n", f.MQTT.Broker)
+		err = w.tryBroker(config.MQTTConfig{Broker: f.MQTT.Broker, Username: f.MQTT.Username, Password: f.MQTT.Password})
+		if err == nil {
+			fmt.Fprintln(w.out, "Connected.")
+			break
+		}
+		fmt.Fprintf(w.out, "Can't connect: %v\n", err)
+		keep, err := w.confirm("Keep these settings anyway?", false)
+		if err != nil {
+			return nil, err
+		}
+		if keep {
+			break
+		}
+	}
+
+	ha, err := w.confirm("Announce the UPS to Home Assistant (MQTT discovery)?", true)
+	if err != nil {
+		return nil, err
+	}
+	if ha {
+		f.Profile = "home-assistant"
+	}
+	return f, nil
+}
+
+// write saves f to path, asking before replacing an existing file.  The
+// file holds passwords, so only its owner may read it.
+func (w *wizard) write(path string, f *setupFile) error {
+	path, err := w.ask("Write the config to", path)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		replace, err := w.confirm(path+" exists. Replace it?", false)
+		if err != nil {
+			return err
+		}
+		if !replace {
+			return errSetupAborted
+		}
+	}
+	var buf bytes.Buffer
+	buf.WriteString("# Written by ups-mqtt setup.  See config.toml.example for every setting.\n\n")
+	if err := toml.NewEncoder(&buf).Encode(f); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		return err
+	}
+	fmt.Fprintf(w.out, "Wrote %s.  Start the bridge with: ups-mqtt --config %s\n", path, path)
+	return nil
+}
+
+// ask prints prompt with its default and reads an answer; an empty answer
+// is the default.
+func (w *wizard) ask(prompt, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(w.out, "%s [%s]: ", prompt, def)
+	} else {
+		fmt.Fprintf(w.out, "%s: ", prompt)
+	}
+	if !w.in.Scan() {
+		fmt.Fprintln(w.out)
+		if err := w.in.Err(); err != nil {
+			return "", err
+		}
+		return "", errSetupAborted
+	}
+	if answer := strings.TrimSpace(w.in.Text()); answer != "" {
+		return answer, nil
+	}
+	return def, nil
+}
+
+// confirm asks a yes/no question.
+func (w *wizard) confirm(prompt string, def bool) (bool, error) {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	for {
+		answer, err := w.ask(prompt+" ("+hint+")", "")
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(answer) {
+		case "":
+			return def, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+	}
+}
+
+// initConfigMain runs the init-config subcommand: it writes the default
+// config, every setting commented, to stdout or to a new file.  The file
+// will hold passwords, so only its owner may read it.
+func initConfigMain(args []string) error {
+	switch len(args) {
+	case 0:
+		return config.WriteDefault(os.Stdout)
+	case 1:
+	default:
+		return fmt.Errorf("usage: ups-mqtt init-config [FILE]")
+	}
+	f, err := os.OpenFile(args[0], os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := config.WriteDefault(f); err != nil {
+		f.Close() //nolint:errcheck
+		return err
+	}
+	return f.Close()
+}
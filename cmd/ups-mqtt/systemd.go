@@ -0,0 +1,142 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/config"
+)
+
+// systemd tells the service manager how the bridge is doing over the
+// sd_notify protocol: READY=1 once every pipeline has connected to the
+// broker and upsd, WATCHDOG=1 whenever every pipeline has polled
+// successfully since the last one, and STOPPING=1 on shutdown.  A unit
+// with Type=notify then waits for the connections before starting what
+// depends on it, and one with WatchdogSec restarts a bridge whose poll
+// loop has hung.  A nil *systemd, the bridge not running under systemd,
+// sends nothing.
+type systemd struct {
+	socket string
+
+	mu       sync.Mutex
+	waiting  int    // pipelines yet to connect
+	polled   []bool // pipelines that polled since the last WATCHDOG=1
+	unpolled int
+}
+
+// newSystemd returns a notifier for pipelines pipelines when systemd
+// passed a notification socket in NOTIFY_SOCKET, and nil otherwise.  The
+// variable is removed from the environment so that hook programs and
+// plugins don't talk to systemd on the bridge's behalf.
+func newSystemd(pipelines int) *systemd {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	os.Unsetenv("NOTIFY_SOCKET") //nolint:errcheck
+	if socket == "" {
+		return nil
+	}
+	return &systemd{
+		socket:   socket,
+		waiting:  pipelines,
+		polled:   make([]bool, pipelines),
+		unpolled: pipelines,
+	}
+}
+
+// checkWatchdog warns when systemd's watchdog would fire between two
+// polls of cfg even when nothing is wrong.
+func (s *systemd) checkWatchdog(cfg *config.Config) {
+	if s == nil {
+		return
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	timeout := time.Duration(usec) * time.Microsecond
+	interval := cfg.NUT.PollInterval.Duration
+	if cfg.LowPower.Enabled {
+		interval = max(interval, cfg.LowPower.PollInterval.Duration)
+	}
+	if timeout <= interval {
+		log.Printf("warning: the systemd watchdog (WatchdogSec=%s) is no longer than the poll interval (%s), so the bridge will be restarted between polls; set WatchdogSec to a few poll intervals", timeout, interval)
+	}
+}
+
+// add counts one more pipeline, started after the others, which has to
+// connect and poll like them.
+func (s *systemd) add() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.waiting++
+	s.polled = append(s.polled, false)
+	s.unpolled++
+}
+
+// connected reports that a pipeline has connected to the broker and upsd.
+// The last pipeline to do so sends READY=1.
+func (s *systemd) connected() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.waiting--
+	ready := s.waiting == 0
+	s.mu.Unlock()
+	if ready {
+		s.notify("READY=1")
+	}
+}
+
+// polledOK reports a successful poll by pipeline i.  WATCHDOG=1 is sent once
+// every pipeline has polled since the last one, so that one hung pipeline
+// is enough for the watchdog to fire.
+func (s *systemd) polledOK(i int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if !s.polled[i] {
+		s.polled[i] = true
+		s.unpolled--
+	}
+	ping := s.unpolled == 0
+	if ping {
+		clear(s.polled)
+		s.unpolled = len(s.polled)
+	}
+	s.mu.Unlock()
+	if ping {
+		s.notify("WATCHDOG=1")
+	}
+}
+
+// stopping tells systemd the bridge is shutting down.
+func (s *systemd) stopping() {
+	if s == nil {
+		return
+	}
+	s.notify("STOPPING=1")
+}
+
+// notify sends state to the notification socket.  An abstract socket's
+// name starts with "@", which net understands.  Failures are logged: they
+// mean systemd restarts the bridge, or never sees it start, and the log
+// says why.
+func (s *systemd) notify(state string) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: s.socket, Net: "unixgram"})
+	if err != nil {
+		log.Printf("systemd: sending %s: %v", state, err)
+		return
+	}
+	defer conn.Close() //nolint:errcheck
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.Printf("systemd: sending %s: %v", state, err)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/config"
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+)
+
+// exportQuiet is how long export waits for further retained messages
+// before it considers the broker's replay finished.
+const exportQuiet = 2 * time.Second
+
+// transferMain runs the export and import subcommands, which copy the
+// bridge's retained topic tree to a file and back, e.g. to move to a new
+// broker or seed a test one.  They connect with their own client ID and no
+// LWT, so a running daemon is unaffected.
+func transferMain(cfg *config.Config, args []string) error {
+	if len(args) != 2 || (args[0] != "export" && args[0] != "import") {
+		return fmt.Errorf("usage: ups-mqtt [flags] export|import FILE")
+	}
+	mqttCfg := cfg.MQTT
+	mqttCfg.ClientID += "-" + args[0]
+	ps, err := publisher.NewMQTTPublisher(mqttCfg, "", "")
+	if err != nil {
+		return fmt.Errorf("connecting to MQTT broker: %w", err)
+	}
+	defer ps.Close() //nolint:errcheck
+
+	if args[0] == "export" {
+		return exportTopics(ps, cfg, args[1], exportQuiet)
+	}
+	return importTopics(ps, args[1])
+}
+
+// exportTopics writes the retained messages under every root the bridge
+// publishes to with cfg, for each configured UPS, to path.
+func exportTopics(s publisher.Subscriber, cfg *config.Config, path string, quiet time.Duration) error {
+	cfgs, err := upsConfigs(cfg)
+	if err != nil {
+		return err
+	}
+	var filters []string
+	for _, c := range cfgs {
+		for _, root := range aclRoots(c) {
+			filters = append(filters, root+"/#")
+		}
+	}
+	msgs, err := publisher.ExportRetained(s, filters, quiet)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := publisher.WriteExport(f, msgs); err != nil {
+		f.Close() //nolint:errcheck
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	log.Printf("exported %d retained message(s) to %s", len(msgs), path)
+	return nil
+}
+
+// importTopics republishes the messages in path, as written by export,
+// keeping their retain flags.
+func importTopics(pub publisher.Publisher, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close() //nolint:errcheck
+	msgs, err := publisher.ReadExport(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for _, msg := range msgs {
+		if err := pub.Publish(msg); err != nil {
+			return fmt.Errorf("publishing %s: %w", msg.Topic, err)
+		}
+	}
+	log.Printf("imported %d message(s) from %s", len(msgs), path)
+	return nil
+}
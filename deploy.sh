@@ -53,7 +53,7 @@ REMOTE_BIN="${SERVICE}-${VERSION}"
 
 echo "==> Building ${LOCAL_BIN}..."
 GOOS="${GOOS}" GOARCH="${GOARCH}" GOARM="${GOARM}" \
-  go build -ldflags="-s -w" -o "${LOCAL_BIN}" ./cmd/${SERVICE}/
+  go build -ldflags="-s -w -X main.version=${VERSION}" -o "${LOCAL_BIN}" ./cmd/${SERVICE}/
 trap 'rm -f "${LOCAL_BIN}"' EXIT
 
 # ---------------------------------------------------------------------------
@@ -1,15 +1,23 @@
 module github.com/sweeney/ups-mqtt
 
-go 1.23
+go 1.23.0
 
 require (
 	github.com/BurntSushi/toml v1.3.2
 	github.com/eclipse/paho.mqtt.golang v1.4.3
 	github.com/robbiet480/go.nut v0.0.0-20240622015809-60e196249c53
+	github.com/segmentio/kafka-go v0.4.50
+	github.com/yuin/gopher-lua v1.1.1
 )
 
 require (
 	github.com/gorilla/websocket v1.5.0 // indirect
-	golang.org/x/net v0.8.0 // indirect
-	golang.org/x/sync v0.1.0 // indirect
+	github.com/klauspost/compress v1.15.9 // indirect
+	github.com/pierrec/lz4/v4 v4.1.15 // indirect
+	github.com/xdg-go/pbkdf2 v1.0.0 // indirect
+	github.com/xdg-go/scram v1.1.2 // indirect
+	github.com/xdg-go/stringprep v1.0.4 // indirect
+	golang.org/x/net v0.38.0 // indirect
+	golang.org/x/sync v0.12.0 // indirect
+	golang.org/x/text v0.23.0 // indirect
 )
@@ -0,0 +1,204 @@
+// Package alerts evaluates configured alert rules against UPS readings and
+// tracks which alerts are active.  It performs no I/O: callers feed it
+// observations and publish or notify on the transitions it returns.
+package alerts
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Severity ranks an alert.  Critical alerts are never suppressed.
+type Severity string
+
+const (
+	SeverityInfo     Severity = "info"
+	SeverityWarning  Severity = "warning"
+	SeverityCritical Severity = "critical"
+)
+
+// Rule types.
+const (
+	// TypeMinLogins fires while fewer than Min upsd clients (upsmon
+	// instances) are logged in to the UPS.
+	TypeMinLogins = "min_logins"
+
+	// TypeChargeRate fires while the smoothed battery charge rate (%/min)
+	// is below Min or above Max, optionally only while the UPS reports
+	// charging (When "charging", status CHRG) or discharging ("discharging",
+	// status DISCHRG or OB).
+	TypeChargeRate = "charge_rate"
+)
+
+// Rule is one configured alert.
+type Rule struct {
+	Name     string
+	Type     string
+	Severity Severity
+	Min      *float64
+	Max      *float64
+	When     string // charge_rate only: "", "charging" or "discharging"
+}
+
+// Observation is whatever the caller has just measured.  Fields that were
+// not measured this time are nil and leave the alerts that depend on them
+// unchanged.
+type Observation struct {
+	Time       time.Time
+	NumLogins  *int
+	ChargeRate *float64 // smoothed battery charge rate, %/min
+	Status     string   // ups.status, for rules restricted by When
+}
+
+// Alert is the state of one rule.
+type Alert struct {
+	Name     string
+	Type     string
+	Severity Severity
+	Active   bool
+	Message  string
+	Since    time.Time // when the alert last changed state
+}
+
+// Engine evaluates rules and remembers which are active.  It is not safe
+// for concurrent use.
+type Engine struct {
+	rules  []Rule
+	states map[string]Alert
+}
+
+// NewEngine validates rules and returns an engine with every alert inactive.
+func NewEngine(rules []Rule) (*Engine, error) {
+	seen := make(map[string]bool, len(rules))
+	for i, r := range rules {
+		if r.Name == "" {
+			return nil, fmt.Errorf("alert %d: name is required", i+1)
+		}
+		if seen[r.Name] {
+			return nil, fmt.Errorf("alert %q: duplicate name", r.Name)
+		}
+		seen[r.Name] = true
+		switch r.Severity {
+		case SeverityInfo, SeverityWarning, SeverityCritical:
+		default:
+			return nil, fmt.Errorf("alert %q: unknown severity %q", r.Name, r.Severity)
+		}
+		switch r.Type {
+		case TypeMinLogins:
+			if r.Min == nil {
+				return nil, fmt.Errorf("alert %q: %s requires min", r.Name, r.Type)
+			}
+		case TypeChargeRate:
+			if r.Min == nil && r.Max == nil {
+				return nil, fmt.Errorf("alert %q: %s requires min or max", r.Name, r.Type)
+			}
+			switch r.When {
+			case "", "charging", "discharging":
+			default:
+				return nil, fmt.Errorf("alert %q: when must be \"charging\" or \"discharging\", got %q", r.Name, r.When)
+			}
+		default:
+			return nil, fmt.Errorf("alert %q: unknown type %q", r.Name, r.Type)
+		}
+	}
+	return &Engine{rules: rules, states: make(map[string]Alert)}, nil
+}
+
+// Uses reports whether any rule has type typ, so callers can warn when the
+// input it needs is never collected.
+func (e *Engine) Uses(typ string) bool {
+	for _, r := range e.rules {
+		if r.Type == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluate applies obs to every rule it has data for and returns the alerts
+// that changed state (fired or cleared), sorted by name.
+func (e *Engine) Evaluate(obs Observation) []Alert {
+	var changed []Alert
+	for _, r := range e.rules {
+		active, msg, ok := r.check(obs)
+		if !ok {
+			continue
+		}
+		prev := e.states[r.Name]
+		if active == prev.Active {
+			continue
+		}
+		a := Alert{Name: r.Name, Type: r.Type, Severity: r.Severity, Active: active, Message: msg, Since: obs.Time}
+		e.states[r.Name] = a
+		changed = append(changed, a)
+	}
+	sort.Slice(changed, func(i, j int) bool { return changed[i].Name < changed[j].Name })
+	return changed
+}
+
+// Active returns the currently active alerts, sorted by name.
+func (e *Engine) Active() []Alert {
+	var out []Alert
+	for _, a := range e.states {
+		if a.Active {
+			out = append(out, a)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// check evaluates r against obs.  ok is false when obs lacks the input r
+// needs.
+func (r Rule) check(obs Observation) (active bool, msg string, ok bool) {
+	switch r.Type {
+	case TypeMinLogins:
+		if obs.NumLogins == nil {
+			return false, "", false
+		}
+		n := *obs.NumLogins
+		if float64(n) < *r.Min {
+			return true, fmt.Sprintf("%d upsd client(s) logged in, expected at least %g", n, *r.Min), true
+		}
+		return false, fmt.Sprintf("%d upsd client(s) logged in", n), true
+	case TypeChargeRate:
+		if obs.ChargeRate == nil {
+			return false, "", false
+		}
+		rate := *obs.ChargeRate
+		if !r.applies(obs.Status) {
+			return false, fmt.Sprintf("battery charge rate %.2f %%/min (not %s)", rate, r.When), true
+		}
+		if r.Min != nil && rate < *r.Min {
+			return true, fmt.Sprintf("battery charge rate %.2f %%/min is below %g", rate, *r.Min), true
+		}
+		if r.Max != nil && rate > *r.Max {
+			return true, fmt.Sprintf("battery charge rate %.2f %%/min is above %g", rate, *r.Max), true
+		}
+		return false, fmt.Sprintf("battery charge rate %.2f %%/min", rate), true
+	}
+	return false, "", false
+}
+
+// applies reports whether a charge_rate rule's When condition holds for the
+// given ups.status.
+func (r Rule) applies(status string) bool {
+	tokens := strings.Fields(status)
+	has := func(tok string) bool {
+		for _, t := range tokens {
+			if t == tok {
+				return true
+			}
+		}
+		return false
+	}
+	switch r.When {
+	case "charging":
+		return has("CHRG")
+	case "discharging":
+		return has("DISCHRG") || has("OB")
+	}
+	return true
+}
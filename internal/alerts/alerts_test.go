@@ -0,0 +1,154 @@
+package alerts
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func ptr(f float64) *float64 { return &f }
+
+func logins(n int) *int { return &n }
+
+var t0 = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func TestNewEngine_Validation(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []Rule
+		want  string
+	}{
+		{"missing name", []Rule{{Type: TypeMinLogins, Severity: SeverityWarning, Min: ptr(2)}}, "name is required"},
+		{"duplicate", []Rule{
+			{Name: "a", Type: TypeMinLogins, Severity: SeverityWarning, Min: ptr(2)},
+			{Name: "a", Type: TypeMinLogins, Severity: SeverityWarning, Min: ptr(2)},
+		}, "duplicate"},
+		{"bad severity", []Rule{{Name: "a", Type: TypeMinLogins, Severity: "loud", Min: ptr(2)}}, "severity"},
+		{"bad type", []Rule{{Name: "a", Type: "vibes", Severity: SeverityInfo}}, "unknown type"},
+		{"min_logins without min", []Rule{{Name: "a", Type: TypeMinLogins, Severity: SeverityInfo}}, "requires min"},
+		{"charge_rate without bounds", []Rule{{Name: "a", Type: TypeChargeRate, Severity: SeverityInfo}}, "requires min or max"},
+		{"charge_rate bad when", []Rule{{Name: "a", Type: TypeChargeRate, Severity: SeverityInfo, Min: ptr(0), When: "sometimes"}}, "when must be"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewEngine(tc.rules)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("err = %v, want containing %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestMinLogins_FiresAndClears(t *testing.T) {
+	e, err := NewEngine([]Rule{{Name: "redundancy", Type: TypeMinLogins, Severity: SeverityWarning, Min: ptr(2)}})
+	if err != nil {
+		t.Fatalf("NewEngine: %v", err)
+	}
+
+	if got := e.Evaluate(Observation{Time: t0, NumLogins: logins(2)}); len(got) != 0 {
+		t.Errorf("healthy start should not report a transition, got %+v", got)
+	}
+
+	got := e.Evaluate(Observation{Time: t0.Add(time.Minute), NumLogins: logins(1)})
+	if len(got) != 1 || !got[0].Active || got[0].Severity != SeverityWarning {
+		t.Fatalf("expected redundancy to fire, got %+v", got)
+	}
+	if !strings.Contains(got[0].Message, "1 upsd client(s)") || !got[0].Since.Equal(t0.Add(time.Minute)) {
+		t.Errorf("alert = %+v", got[0])
+	}
+	if active := e.Active(); len(active) != 1 || active[0].Name != "redundancy" {
+		t.Errorf("Active = %+v", active)
+	}
+
+	// Still below minimum: no new transition.
+	if got := e.Evaluate(Observation{Time: t0.Add(2 * time.Minute), NumLogins: logins(0)}); len(got) != 0 {
+		t.Errorf("repeat should not re-fire, got %+v", got)
+	}
+	// No login data this time: state unchanged.
+	if got := e.Evaluate(Observation{Time: t0.Add(3 * time.Minute)}); len(got) != 0 {
+		t.Errorf("observation without logins should be ignored, got %+v", got)
+	}
+
+	got = e.Evaluate(Observation{Time: t0.Add(4 * time.Minute), NumLogins: logins(3)})
+	if len(got) != 1 || got[0].Active {
+		t.Fatalf("expected redundancy to clear, got %+v", got)
+	}
+	if len(e.Active()) != 0 {
+		t.Errorf("Active = %+v, want none", e.Active())
+	}
+}
+
+func TestEvaluate_SortedByName(t *testing.T) {
+	e, err := NewEngine([]Rule{
+		{Name: "zeta", Type: TypeMinLogins, Severity: SeverityInfo, Min: ptr(5)},
+		{Name: "alpha", Type: TypeMinLogins, Severity: SeverityCritical, Min: ptr(5)},
+	})
+	if err != nil {
+		t.Fatalf("NewEngine: %v", err)
+	}
+	got := e.Evaluate(Observation{Time: t0, NumLogins: logins(1)})
+	if len(got) != 2 || got[0].Name != "alpha" || got[1].Name != "zeta" {
+		t.Errorf("got %+v", got)
+	}
+	if a := e.Active(); len(a) != 2 || a[0].Name != "alpha" {
+		t.Errorf("Active = %+v", a)
+	}
+}
+
+func TestUses(t *testing.T) {
+	e, _ := NewEngine([]Rule{{Name: "a", Type: TypeMinLogins, Severity: SeverityInfo, Min: ptr(1)}})
+	if !e.Uses(TypeMinLogins) || e.Uses("other") {
+		t.Error("Uses reported the wrong rule types")
+	}
+}
+
+func TestCheck_UnknownType(t *testing.T) {
+	if _, _, ok := (Rule{Type: "other"}).check(Observation{NumLogins: logins(1)}); ok {
+		t.Error("unknown rule types should never evaluate")
+	}
+}
+
+func rate(f float64) *float64 { return &f }
+
+func TestChargeRate_SlowChargingOnlyWhileCharging(t *testing.T) {
+	e, err := NewEngine([]Rule{{Name: "slow_charge", Type: TypeChargeRate, Severity: SeverityWarning, Min: ptr(0.1), When: "charging"}})
+	if err != nil {
+		t.Fatalf("NewEngine: %v", err)
+	}
+	// Fully charged and idle: rate 0 but not charging, so no alert.
+	if got := e.Evaluate(Observation{Time: t0, ChargeRate: rate(0), Status: "OL"}); len(got) != 0 {
+		t.Errorf("not charging: got %+v", got)
+	}
+	got := e.Evaluate(Observation{Time: t0, ChargeRate: rate(0.02), Status: "OL CHRG"})
+	if len(got) != 1 || !got[0].Active || !strings.Contains(got[0].Message, "below 0.1") {
+		t.Fatalf("slow charge should fire, got %+v", got)
+	}
+	// Charging stops: the condition no longer applies and the alert clears.
+	got = e.Evaluate(Observation{Time: t0, ChargeRate: rate(0), Status: "OL"})
+	if len(got) != 1 || got[0].Active {
+		t.Fatalf("slow charge should clear, got %+v", got)
+	}
+}
+
+func TestChargeRate_FastDischarge(t *testing.T) {
+	e, err := NewEngine([]Rule{{Name: "fast_discharge", Type: TypeChargeRate, Severity: SeverityCritical, Min: ptr(-2), When: "discharging"}})
+	if err != nil {
+		t.Fatalf("NewEngine: %v", err)
+	}
+	if got := e.Evaluate(Observation{Time: t0, ChargeRate: rate(-1), Status: "OB DISCHRG"}); len(got) != 0 {
+		t.Errorf("normal discharge: got %+v", got)
+	}
+	if got := e.Evaluate(Observation{Time: t0, ChargeRate: rate(-3.5), Status: "OB DISCHRG"}); len(got) != 1 || !got[0].Active {
+		t.Errorf("fast discharge should fire, got %+v", got)
+	}
+	if got := e.Evaluate(Observation{Time: t0, NumLogins: logins(1)}); len(got) != 0 {
+		t.Errorf("observation without a rate should be ignored, got %+v", got)
+	}
+}
+
+func TestChargeRate_Max(t *testing.T) {
+	e, _ := NewEngine([]Rule{{Name: "a", Type: TypeChargeRate, Severity: SeverityInfo, Max: ptr(5)}})
+	if got := e.Evaluate(Observation{Time: t0, ChargeRate: rate(6)}); len(got) != 1 || !strings.Contains(got[0].Message, "above 5") {
+		t.Errorf("got %+v", got)
+	}
+}
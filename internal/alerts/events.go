@@ -0,0 +1,53 @@
+package alerts
+
+import "strings"
+
+// Event is a one-off status change worth notifying about, as opposed to an
+// Alert, which has a lasting active/cleared state.
+type Event struct {
+	Name     string
+	Severity Severity
+	Message  string
+
+	// Drill marks an event from a rehearsal, not the UPS.
+	Drill bool
+}
+
+// statusEvents are the ups.status flags that produce an Event when they
+// appear.  LB and FSD precede a shutdown, so they are critical.
+var statusEvents = []struct {
+	flag string
+	Event
+}{
+	{"OB", Event{Name: "on_battery", Severity: SeverityWarning, Message: "UPS is running on battery"}},
+	{"LB", Event{Name: "low_battery", Severity: SeverityCritical, Message: "UPS battery is low"}},
+	{"FSD", Event{Name: "forced_shutdown", Severity: SeverityCritical, Message: "UPS forced shutdown in progress"}},
+}
+
+// StatusEvents compares two successive ups.status values and returns the
+// events for flags that have just appeared, plus "power_restored" when OB
+// clears.  An empty prev (first poll) yields no events.
+func StatusEvents(prev, cur string) []Event {
+	if prev == "" {
+		return nil
+	}
+	before, after := flags(prev), flags(cur)
+	var out []Event
+	for _, se := range statusEvents {
+		if after[se.flag] && !before[se.flag] {
+			out = append(out, se.Event)
+		}
+	}
+	if before["OB"] && !after["OB"] {
+		out = append(out, Event{Name: "power_restored", Severity: SeverityInfo, Message: "mains power restored"})
+	}
+	return out
+}
+
+func flags(status string) map[string]bool {
+	m := make(map[string]bool)
+	for _, f := range strings.Fields(status) {
+		m[f] = true
+	}
+	return m
+}
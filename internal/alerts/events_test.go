@@ -0,0 +1,50 @@
+package alerts
+
+import "testing"
+
+func eventNames(evs []Event) []string {
+	var names []string
+	for _, e := range evs {
+		names = append(names, e.Name)
+	}
+	return names
+}
+
+func TestStatusEvents(t *testing.T) {
+	cases := []struct {
+		prev, cur string
+		want      []string
+	}{
+		{"", "OB LB", nil},
+		{"OL", "OL", nil},
+		{"OL CHRG", "OB DISCHRG", []string{"on_battery"}},
+		{"OB DISCHRG", "OB DISCHRG LB", []string{"low_battery"}},
+		{"OL", "OB LB FSD", []string{"on_battery", "low_battery", "forced_shutdown"}},
+		{"OB LB", "OL CHRG", []string{"power_restored"}},
+	}
+	for _, c := range cases {
+		got := eventNames(StatusEvents(c.prev, c.cur))
+		if len(got) != len(c.want) {
+			t.Errorf("%q→%q: events = %v, want %v", c.prev, c.cur, got, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("%q→%q: events = %v, want %v", c.prev, c.cur, got, c.want)
+				break
+			}
+		}
+	}
+}
+
+func TestStatusEvents_Severity(t *testing.T) {
+	for _, e := range StatusEvents("OL", "OB LB FSD") {
+		want := SeverityCritical
+		if e.Name == "on_battery" {
+			want = SeverityWarning
+		}
+		if e.Severity != want {
+			t.Errorf("%s severity = %s, want %s", e.Name, e.Severity, want)
+		}
+	}
+}
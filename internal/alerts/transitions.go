@@ -0,0 +1,72 @@
+package alerts
+
+// Transition names reported by Transitions.
+const (
+	TransitionPowerLost      = "power_lost"
+	TransitionPowerRestored  = "power_restored"
+	TransitionLowBattery     = "low_battery"
+	TransitionBatteryCharged = "battery_charged"
+	TransitionCommsLost      = "comms_lost"
+	TransitionCommsRestored  = "comms_restored"
+	TransitionUPSMissing     = "ups_missing"
+	TransitionUPSFound       = "ups_found"
+)
+
+// Reading is what Transitions compares between polls.  While
+// communication is lost, Status and Charge keep the last values read, so
+// that the poll after recovery is compared with the state before the loss.
+// Missing marks a loss where upsd no longer lists the UPS; it stays set
+// through other failures until a poll succeeds.
+type Reading struct {
+	Status    string
+	Charge    float64
+	ChargeOK  bool
+	CommsLost bool
+	Missing   bool
+}
+
+// Transitions returns the state changes between two successive readings,
+// for automations that would otherwise diff the retained topics themselves:
+// OB appearing or clearing, LB appearing, the battery reaching full charge
+// on mains (charge reaching 100 %, or CHRG clearing), polls starting or
+// ceasing to fail, and the UPS dropping out of upsd's list and returning.
+// A zero prev (first poll) yields only comms_lost and ups_missing.
+func Transitions(prev, cur Reading) []string {
+	var out []string
+	if cur.CommsLost {
+		if !prev.CommsLost {
+			out = append(out, TransitionCommsLost)
+		}
+		if cur.Missing && !prev.Missing {
+			out = append(out, TransitionUPSMissing)
+		}
+		return out
+	}
+	if prev.CommsLost {
+		out = append(out, TransitionCommsRestored)
+	}
+	if prev.Missing {
+		out = append(out, TransitionUPSFound)
+	}
+	if prev.Status == "" {
+		return out
+	}
+	before, after := flags(prev.Status), flags(cur.Status)
+	if after["OB"] && !before["OB"] {
+		out = append(out, TransitionPowerLost)
+	}
+	if before["OB"] && !after["OB"] {
+		out = append(out, TransitionPowerRestored)
+	}
+	if after["LB"] && !before["LB"] {
+		out = append(out, TransitionLowBattery)
+	}
+	if !after["OB"] {
+		full := cur.ChargeOK && cur.Charge >= 100 && !(prev.ChargeOK && prev.Charge >= 100)
+		chargeDone := before["CHRG"] && !after["CHRG"] && !after["DISCHRG"]
+		if full || chargeDone {
+			out = append(out, TransitionBatteryCharged)
+		}
+	}
+	return out
+}
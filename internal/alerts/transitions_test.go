@@ -0,0 +1,37 @@
+package alerts
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTransitions(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		prev, cur Reading
+		want      string
+	}{
+		{"first poll", Reading{}, Reading{Status: "OB"}, ""},
+		{"first poll fails", Reading{}, Reading{CommsLost: true}, "comms_lost"},
+		{"steady", Reading{Status: "OL"}, Reading{Status: "OL"}, ""},
+		{"power lost", Reading{Status: "OL"}, Reading{Status: "OB DISCHRG"}, "power_lost"},
+		{"low battery", Reading{Status: "OB"}, Reading{Status: "OB LB"}, "low_battery"},
+		{"lost and low at once", Reading{Status: "OL"}, Reading{Status: "OB LB"}, "power_lost,low_battery"},
+		{"power restored", Reading{Status: "OB LB"}, Reading{Status: "OL CHRG"}, "power_restored"},
+		{"charged to 100", Reading{Status: "OL", Charge: 99, ChargeOK: true}, Reading{Status: "OL", Charge: 100, ChargeOK: true}, "battery_charged"},
+		{"stays at 100", Reading{Status: "OL", Charge: 100, ChargeOK: true}, Reading{Status: "OL", Charge: 100, ChargeOK: true}, ""},
+		{"CHRG clears", Reading{Status: "OL CHRG", Charge: 97, ChargeOK: true}, Reading{Status: "OL", Charge: 97, ChargeOK: true}, "battery_charged"},
+		{"CHRG to DISCHRG", Reading{Status: "OL CHRG"}, Reading{Status: "OL DISCHRG"}, ""},
+		{"100 on battery", Reading{Status: "OL", Charge: 99, ChargeOK: true}, Reading{Status: "OB", Charge: 100, ChargeOK: true}, "power_lost"},
+		{"comms lost", Reading{Status: "OL"}, Reading{Status: "OL", CommsLost: true}, "comms_lost"},
+		{"still lost", Reading{Status: "OL", CommsLost: true}, Reading{Status: "OL", CommsLost: true}, ""},
+		{"restored on battery", Reading{Status: "OL", CommsLost: true}, Reading{Status: "OB"}, "comms_restored,power_lost"},
+		{"UPS missing", Reading{Status: "OL"}, Reading{Status: "OL", CommsLost: true, Missing: true}, "comms_lost,ups_missing"},
+		{"goes missing while lost", Reading{Status: "OL", CommsLost: true}, Reading{Status: "OL", CommsLost: true, Missing: true}, "ups_missing"},
+		{"UPS found", Reading{Status: "OL", CommsLost: true, Missing: true}, Reading{Status: "OL"}, "comms_restored,ups_found"},
+	} {
+		if got := strings.Join(Transitions(tc.prev, tc.cur), ","); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Package clock abstracts reading the time and waiting for it, so the poll
+// loop can run on recorded times for replays and on a clock tests move by
+// hand.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time and makes timers and tickers that follow it.
+type Clock interface {
+	Now() time.Time
+	// After returns a channel that receives the time once d has passed.
+	After(d time.Duration) <-chan time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker is a time.Ticker of a Clock.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the system clock.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTicker(d time.Duration) Ticker       { return realTicker{time.NewTicker(d)} }
+
+type realTicker struct{ t *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+func (t realTicker) Stop()               { t.t.Stop() }
+
+// Fake is a Clock that stands still until Set or Advance moves it.  Timers
+// and tickers due by then fire as it moves, before Set returns; like
+// time.Ticker, a ticker whose last tick hasn't been received drops the
+// next.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*waiter
+}
+
+type waiter struct {
+	at     time.Time
+	period time.Duration // 0 for After, which fires once
+	c      chan time.Time
+}
+
+// NewFake returns a Fake clock set to now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// Now returns the time the clock was last set to.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Advance moves the clock on by d.
+func (f *Fake) Advance(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// Set moves the clock to now, firing what is due by then.  A ticker that
+// missed several ticks fires once, with the time of the last.  Setting it
+// back fires nothing.
+func (f *Fake) Set(now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = now
+	kept := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.at.After(now) {
+			kept = append(kept, w)
+			continue
+		}
+		at := w.at
+		if w.period > 0 {
+			at = at.Add(now.Sub(at) / w.period * w.period)
+			w.at = at.Add(w.period)
+		}
+		select {
+		case w.c <- at:
+		default:
+		}
+		if w.period > 0 {
+			kept = append(kept, w)
+		}
+	}
+	f.waiters = kept
+}
+
+// After returns a channel that receives the time once the clock has moved
+// on by d.
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	return f.add(d, 0).c
+}
+
+// NewTicker returns a ticker that ticks every d of the clock's time.
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	return &fakeTicker{f, f.add(d, d)}
+}
+
+func (f *Fake) add(d, period time.Duration) *waiter {
+	f.mu.Lock()
+	w := &waiter{at: f.now.Add(d), period: period, c: make(chan time.Time, 1)}
+	f.waiters = append(f.waiters, w)
+	f.mu.Unlock()
+	if d <= 0 {
+		f.Set(f.Now())
+	}
+	return w
+}
+
+func (f *Fake) remove(w *waiter) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, o := range f.waiters {
+		if o == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+type fakeTicker struct {
+	f *Fake
+	w *waiter
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.w.c }
+func (t *fakeTicker) Stop()               { t.f.remove(t.w) }
@@ -0,0 +1,78 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func received(c <-chan time.Time) (time.Time, bool) {
+	select {
+	case t := <-c:
+		return t, true
+	default:
+		return time.Time{}, false
+	}
+}
+
+func TestFake_After(t *testing.T) {
+	f := NewFake(t0)
+	c := f.After(time.Minute)
+	f.Advance(59 * time.Second)
+	if _, ok := received(c); ok {
+		t.Fatal("After fired early")
+	}
+	f.Advance(time.Second)
+	if at, ok := received(c); !ok || !at.Equal(t0.Add(time.Minute)) {
+		t.Fatalf("After = %s, %v; want %s", at, ok, t0.Add(time.Minute))
+	}
+	f.Advance(time.Hour)
+	if _, ok := received(c); ok {
+		t.Error("After fired twice")
+	}
+	if _, ok := received(f.After(0)); !ok {
+		t.Error("After(0) didn't fire straight away")
+	}
+}
+
+func TestFake_Ticker(t *testing.T) {
+	f := NewFake(t0)
+	tk := f.NewTicker(30 * time.Second)
+	f.Advance(30 * time.Second)
+	if at, ok := received(tk.C()); !ok || !at.Equal(t0.Add(30*time.Second)) {
+		t.Fatalf("tick = %s, %v; want %s", at, ok, t0.Add(30*time.Second))
+	}
+
+	// Missed ticks are dropped, as with time.Ticker.
+	f.Advance(100 * time.Second)
+	if at, ok := received(tk.C()); !ok || !at.Equal(t0.Add(2*time.Minute)) {
+		t.Fatalf("tick = %s, %v; want the last one due, %s", at, ok, t0.Add(2*time.Minute))
+	}
+	f.Advance(20 * time.Second)
+	if at, ok := received(tk.C()); !ok || !at.Equal(t0.Add(150*time.Second)) {
+		t.Fatalf("tick = %s, %v; want %s", at, ok, t0.Add(150*time.Second))
+	}
+
+	tk.Stop()
+	f.Advance(time.Hour)
+	if _, ok := received(tk.C()); ok {
+		t.Error("ticker ticked after Stop")
+	}
+	if !f.Now().Equal(t0.Add(150*time.Second + time.Hour)) {
+		t.Errorf("Now = %s", f.Now())
+	}
+}
+
+func TestReal(t *testing.T) {
+	if d := time.Since(Real.Now()); d < 0 || d > time.Second {
+		t.Errorf("Real.Now is %s off", d)
+	}
+	tk := Real.NewTicker(time.Millisecond)
+	defer tk.Stop()
+	select {
+	case <-tk.C():
+	case <-Real.After(time.Second):
+		t.Fatal("no tick")
+	}
+}
@@ -5,11 +5,19 @@ package config
 import (
 	"fmt"
 	"log"
+	"net"
 	"os"
+	"path"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/BurntSushi/toml"
+
+	"github.com/sweeney/ups-mqtt/internal/notify"
+	"github.com/sweeney/ups-mqtt/internal/schedule"
+	"github.com/sweeney/ups-mqtt/internal/update"
+	"github.com/sweeney/ups-mqtt/internal/wol"
 )
 
 // Duration wraps time.Duration so that BurntSushi/toml can decode "30s"-style
@@ -28,15 +36,143 @@ func (d *Duration) UnmarshalText(text []byte) error {
 	return nil
 }
 
+// Value is a NUT variable value that may be written in TOML as a string,
+// integer, float or boolean; it is normalised to the string form NUT uses.
+type Value string
+
+// UnmarshalTOML implements toml.Unmarshaler.
+func (v *Value) UnmarshalTOML(data interface{}) error {
+	switch d := data.(type) {
+	case string:
+		*v = Value(d)
+	case int64:
+		*v = Value(strconv.FormatInt(d, 10))
+	case float64:
+		*v = Value(strconv.FormatFloat(d, 'f', -1, 64))
+	case bool:
+		*v = Value(strconv.FormatBool(d))
+	default:
+		return fmt.Errorf("unsupported value %v (%T)", data, data)
+	}
+	return nil
+}
+
 // NUTConfig holds Network UPS Tools client settings.
 type NUTConfig struct {
-	Host         string   `toml:"host"`
-	Port         int      `toml:"port"`
-	Username     string   `toml:"username"`
-	Password     string   `toml:"password"`
+	Host string `toml:"host"`
+
+	// Hosts, when set, replaces Host with a list of upsd servers in
+	// priority order: the first one reachable is used, and a connection to
+	// a later one moves back once an earlier one answers again.
+	Hosts []string `toml:"hosts"`
+
+	Port     int    `toml:"port"`
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+
+	// PasswordCommand, when set, is run through sh at load time and its
+	// output, less the trailing newline, used as Password.
+	PasswordCommand string `toml:"password_command"`
+
+	// UPSName is the UPS to poll, as upsd names it.  "*" polls every UPS
+	// upsd serves, each under its own name, and starts polling those it
+	// serves later as they appear.
 	UPSName      string   `toml:"ups_name"`
 	Label        string   `toml:"label"`
 	PollInterval Duration `toml:"poll_interval"`
+
+	// AlignPolls schedules polls on wall-clock multiples of PollInterval
+	// (e.g. every :00 and :30 for 30s) instead of from process start.
+	AlignPolls bool `toml:"align_polls"`
+
+	// HoldMissing keeps publishing a variable's last value for this long after
+	// the driver stops reporting it.  Zero disables holding.
+	HoldMissing Duration `toml:"hold_missing"`
+
+	// PollVars, when set, fetches only these variables each poll, with a
+	// GET VAR apiece instead of LIST VAR, for upsd servers slow to list
+	// every variable.  Everything else the UPS reports goes unpublished.
+	PollVars []string `toml:"poll_vars"`
+
+	// Defaults supplies fallback values for variables the UPS never reports
+	// (e.g. ups.realpower.nominal), used when computing metrics.
+	Defaults map[string]Value `toml:"defaults"`
+
+	// ClientsInterval is how often to publish the upsd clients attached to
+	// the UPS (LIST CLIENT).  Zero disables it.
+	ClientsInterval Duration `toml:"clients_interval"`
+
+	// ExpectedClients lists hosts (as upsd reports them, usually IP
+	// addresses) that should always be attached; absent ones are reported
+	// as missing.
+	ExpectedClients []string `toml:"expected_clients"`
+
+	// ClockSkewThreshold flags the bridge clock as skewed against the UPS
+	// driver's ups.date/ups.time when they differ by more than this.  Zero
+	// disables the check.
+	ClockSkewThreshold Duration `toml:"clock_skew_threshold"`
+
+	// MainsStable is how long the UPS must stay off battery before power
+	// counts as restored: the power_restored notification, clearing the
+	// outage topic and Wake-on-LAN all wait for it.  Zero reacts at once.
+	MainsStable Duration `toml:"mains_stable"`
+
+	// StatusDebounce is how many polls in a row a new ups.status must be
+	// seen on before the computed metrics, the state topic, events and
+	// notifications follow it; the variables topics follow every poll.  A
+	// status with LB or FSD is taken at once.  0 or 1 disables it.
+	StatusDebounce int `toml:"status_debounce"`
+
+	// UPS lists the UPSes to poll when upsd serves more than one.  Each
+	// entry replaces UPSName and Label and shares every other setting; when
+	// empty, only UPSName is polled.
+	UPS []UPSConfig `toml:"ups"`
+
+	// MaxConnections caps the upsd connections shared by the [[nut.ups]]
+	// entries, and so how many of them are polled at once.  Unused when
+	// only one UPS is configured.
+	MaxConnections int `toml:"max_connections"`
+
+	// MaxBackoff caps the wait between attempts to reach upsd, which
+	// starts at one second and doubles after each failure.
+	MaxBackoff Duration `toml:"max_backoff"`
+}
+
+// UPSConfig is one [[nut.ups]] entry.  The optional settings override
+// the shared ones for this UPS only; unset, the UPS uses [nut] poll_interval,
+// the [mqtt] topic_prefix, retained and qos, and the [filter] section.
+type UPSConfig struct {
+	Name  string `toml:"name"`
+	Label string `toml:"label"`
+
+	PollInterval *Duration     `toml:"poll_interval"`
+	TopicPrefix  string        `toml:"topic_prefix"`
+	Retained     *bool         `toml:"retained"`
+	QOS          *byte         `toml:"qos"`
+	Filter       *FilterConfig `toml:"filter"`
+}
+
+// BrokerList returns the brokers to connect to, in order: Brokers when set,
+// otherwise Broker alone.
+func (c MQTTConfig) BrokerList() []string {
+	if len(c.Brokers) > 0 {
+		return c.Brokers
+	}
+	return []string{c.Broker}
+}
+
+// Servers returns the upsd servers to connect to, in priority order: Hosts
+// when set, otherwise Host alone.
+func (c NUTConfig) Servers() []string {
+	if len(c.Hosts) > 0 {
+		return c.Hosts
+	}
+	return []string{c.Host}
+}
+
+// Discover reports whether UPSName is "*", every UPS upsd serves.
+func (c NUTConfig) Discover() bool {
+	return c.UPSName == "*"
 }
 
 // EffectiveLabel returns Label if set, otherwise UPSName.
@@ -50,122 +186,1756 @@ func (c NUTConfig) EffectiveLabel() string {
 
 // MQTTConfig holds MQTT broker connection settings.
 type MQTTConfig struct {
-	Broker      string `toml:"broker"`
-	Username    string `toml:"username"`
-	Password    string `toml:"password"`
+	Broker string `toml:"broker"`
+
+	// Brokers, when set, replaces Broker with a list of broker URLs.  With
+	// BrokerMode "failover" (the default) they are tried in order on every
+	// connect and reconnect and the first that accepts is used; with
+	// "fanout" the bridge connects to all of them and publishes everything
+	// to each.
+	Brokers    []string `toml:"brokers"`
+	BrokerMode string   `toml:"broker_mode"`
+
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+
+	// PasswordCommand, when set, is run like NUTConfig.PasswordCommand
+	// and its output used as Password.
+	PasswordCommand string `toml:"password_command"`
+
 	ClientID    string `toml:"client_id"`
 	TopicPrefix string `toml:"topic_prefix"`
 	Retained    bool   `toml:"retained"`
 	QOS         byte   `toml:"qos"`
 	TLSCACert   string `toml:"tls_ca_cert"`
+
+	// TLSInsecure skips verification of the broker's certificate, for
+	// self-signed brokers without a CA to hand.  TLSServerName is the name
+	// the certificate is checked against instead of the broker URL's host,
+	// for brokers reached by IP address.
+	TLSInsecure   bool   `toml:"tls_insecure"`
+	TLSServerName string `toml:"tls_server_name"`
+
+	// TLSClientCert and TLSClientKey are PEM files of a client certificate
+	// and its key, presented to brokers that authenticate clients by
+	// certificate, such as AWS IoT Core.  They must be set together.
+	TLSClientCert string `toml:"tls_client_cert"`
+	TLSClientKey  string `toml:"tls_client_key"`
+
+	// LastChanged lists NUT variable name patterns (path.Match globs, e.g.
+	// "ups.status" or "battery.*") that get a {topic}/$last_changed companion
+	// topic.  "*" selects every variable; empty disables the feature.
+	LastChanged []string `toml:"last_changed"`
+
+	// NonRetained lists NUT variable name patterns whose per-variable topics
+	// are published without the retain flag regardless of Retained — useful
+	// for transient, event-like values such as ups.test.result.
+	NonRetained []string `toml:"non_retained"`
+
+	// IncludeVars and ExcludeVars narrow the NUT variables published, as
+	// per-variable topics and in the state topic's variables map: when
+	// IncludeVars is set only variables matching it are, and those matching
+	// ExcludeVars never are.  Both hold path.Match globs such as "driver.*".
+	// Computed metrics are still derived from every variable.
+	IncludeVars []string `toml:"include_vars"`
+	ExcludeVars []string `toml:"exclude_vars"`
+
+	// NamespacePrefixes routes NUT variable namespaces to their own topic
+	// roots, e.g. "battery" → "power/ups1/battery" publishes battery.charge
+	// on power/ups1/battery/charge instead of {prefix}/{label}/battery/charge.
+	// The longest matching namespace wins.
+	NamespacePrefixes map[string]string `toml:"namespace_prefixes"`
+
+	// Diff publishes a non-retained {prefix}/{label}/diff JSON message with
+	// the old and new values of every variable that changed since the
+	// previous poll.
+	Diff bool `toml:"diff"`
+
+	// SelfTest runs a publish/subscribe loopback check at startup and
+	// whenever a message arrives on {prefix}/{label}/selftest/run.
+	SelfTest        bool     `toml:"self_test"`
+	SelfTestTimeout Duration `toml:"self_test_timeout"`
+
+	// ACLCheck probes, at startup, publish permission under every topic
+	// root the bridge writes to and subscribe permission on every command
+	// topic it listens on, and reports what is missing.  Each probe waits
+	// up to SelfTestTimeout.
+	ACLCheck bool `toml:"acl_check"`
+
+	// VariablesEvery and ComputedEvery publish the per-variable and
+	// computed/ topics only on every Nth poll, so NUT can be polled often
+	// for fast on-battery detection without a matching broker write volume.
+	// The state topic is published every poll.  0 or 1 publishes every poll.
+	VariablesEvery int `toml:"variables_every"`
+	ComputedEvery  int `toml:"computed_every"`
+
+	// MaxStateBytes caps the size of the state message for brokers with a
+	// message size limit; StateOverflow is how an oversized one is cut down:
+	// "drop_driver" (default), "truncate" or "split".  Zero means no limit.
+	MaxStateBytes int    `toml:"max_state_bytes"`
+	StateOverflow string `toml:"state_overflow"`
+
+	// PublishMode is "always" (default), or "on_change" to skip retained
+	// messages whose payload hasn't changed since they were last published.
+	PublishMode string `toml:"publish_mode"`
+
+	// Events publishes status transitions (power_lost, low_battery, …) as
+	// non-retained JSON messages on {prefix}/{label}/events.
+	Events bool `toml:"events"`
+
+	// PollComplete publishes a non-retained marker on
+	// {prefix}/{label}/poll_complete at the end of every poll cycle, with
+	// an ID for the cycle and how many messages it published, so batch
+	// consumers know when a snapshot's topics have all arrived.
+	PollComplete bool `toml:"poll_complete"`
+
+	// RetainTTL stamps the state message with an "expires_at" time this far
+	// after it was published, so retained data left behind by a bridge that
+	// is long gone can be recognised as stale.  Only the state message is
+	// stamped: the per-variable and computed topics are bare values, and
+	// MQTT 3.1.1 has no message expiry to set on them.  Zero disables it.
+	RetainTTL Duration `toml:"retain_ttl"`
+
+	// ByteStats counts the bytes published to the broker in each poll
+	// cycle, by topic class, and reports them on the bridge topic and in
+	// the Prometheus textfile.  ByteBudget, when non-zero, logs a warning
+	// when a cycle publishes more than this many bytes, and turns
+	// ByteStats on.
+	ByteStats  bool `toml:"byte_stats"`
+	ByteBudget int  `toml:"byte_budget"`
+
+	// BufferSize, when non-zero, keeps up to this many messages published
+	// while the broker is unreachable and sends them, in order, once it is
+	// back, dropping the oldest when full.  BufferFile keeps the buffer in
+	// a file as well, so what hasn't been sent survives a restart.
+	BufferSize int    `toml:"buffer_size"`
+	BufferFile string `toml:"buffer_file"`
+
+	// RepublishOnConnect, on every reconnect to the broker, publishes the
+	// last poll's variables, computed metrics and Home Assistant discovery
+	// again along with its state message, which is always republished, for
+	// brokers that lose their retained messages when they restart.
+	RepublishOnConnect bool `toml:"republish_on_connect"`
+
+	// MaxBackoff caps the wait between attempts to connect to the broker
+	// at startup, which starts at one second and doubles after each
+	// failure.  Reconnecting later is left to the MQTT client.
+	MaxBackoff Duration `toml:"max_backoff"`
+
+	// Aggregate, when several UPSes are polled, publishes
+	// {topic_prefix}/summary after every poll: their total load, the
+	// lowest runtime, the worst status and whether any is on battery.
+	Aggregate bool `toml:"aggregate"`
+}
+
+// FilterConfig controls the plausibility filter that drops or clamps
+// physically impossible readings before metrics are computed.
+type FilterConfig struct {
+	Enabled bool   `toml:"enabled"`
+	Mode    string `toml:"mode"` // "drop" or "clamp"
+
+	// Bounds adds to or replaces the built-in per-variable rules.
+	Bounds map[string]BoundsConfig `toml:"bounds"`
+}
+
+// BoundsConfig is the plausible range of a single NUT variable.
+// An omitted min or max leaves that side open.
+type BoundsConfig struct {
+	Min               *float64 `toml:"min"`
+	Max               *float64 `toml:"max"`
+	ZeroOnlyOnBattery bool     `toml:"zero_only_on_battery"`
+}
+
+// HomeAssistantConfig controls Home Assistant MQTT discovery.
+type HomeAssistantConfig struct {
+	Discovery       bool   `toml:"discovery"`
+	DiscoveryPrefix string `toml:"discovery_prefix"`
+}
+
+// MigrationConfig describes a second topic layout that is published in
+// parallel with the configured one while automations are moved across.
+// Fields left empty default to the corresponding mqtt.topic_prefix /
+// nut.label value; the mirror is off when both are empty.
+type MigrationConfig struct {
+	TopicPrefix string `toml:"topic_prefix"`
+	Label       string `toml:"label"`
+}
+
+// PushgatewayConfig holds the Prometheus Pushgateway used by --once runs.
+// An empty URL disables pushing.
+type PushgatewayConfig struct {
+	URL string `toml:"url"`
+	Job string `toml:"job"`
+}
+
+// PrometheusConfig holds the local Prometheus outputs.  TextfileDir is
+// node_exporter's --collector.textfile.directory; when set, every successful
+// poll is written there as a .prom file.
+type PrometheusConfig struct {
+	TextfileDir string `toml:"textfile_dir"`
+}
+
+// GrafanaConfig holds the Grafana Live stream each poll is pushed to.  An
+// empty URL disables pushing.
+type GrafanaConfig struct {
+	URL      string `toml:"url"`
+	Token    string `toml:"token"`     // service account token with live push rights
+	StreamID string `toml:"stream_id"` // data appears on channel stream/{stream_id}/ups
+}
+
+// DiagnosticsConfig enables remote troubleshooting features.  Everything
+// here is off by default.
+type DiagnosticsConfig struct {
+	// RawNUT accepts read-only NUT protocol lines on
+	// {prefix}/{label}/diag/nut/command and publishes upsd's reply.
+	RawNUT bool `toml:"raw_nut"`
+
+	// SnapshotFile, when set, is atomically replaced after every successful
+	// poll with the latest variables as JSON, for host-local scripts.
+	SnapshotFile string `toml:"snapshot_file"`
+
+	// RecordFile, when set, has every successful poll's variables, as upsd
+	// returned them, appended to it with the time of the poll: one JSON
+	// object per line, or CSV rows when the name ends in .csv.  Set by
+	// --record.
+	RecordFile string `toml:"record_file"`
+
+	// AuditLog keeps the last AuditLog NUT and MQTT connection events on the
+	// retained {prefix}/{label}/diag/connections topic.  Zero disables it.
+	AuditLog int `toml:"audit_log"`
+
+	// PollTimings logs how long each upsd request of every poll took —
+	// connecting, logging in, GET VAR, LIST UPS and the variable batch —
+	// and adds the breakdown to the bridge stats topic, for finding out
+	// whether slow polls are down to the network, upsd or the driver.
+	PollTimings bool `toml:"poll_timings"`
+
+	// Telemetry adds the bridge's own health to the bridge stats topic —
+	// uptime, polls made and failed, consecutive failures, how long the
+	// last poll took, broker reconnects and the build version — published
+	// after every poll, failed ones included, for monitoring the monitor.
+	Telemetry bool `toml:"telemetry"`
+}
+
+// MetricsConfig tunes metrics derived across polls.
+type MetricsConfig struct {
+	// ChargeRateWindow is the smoothing time constant of
+	// computed/battery_charge_rate.  Zero disables the metric.
+	ChargeRateWindow Duration `toml:"charge_rate_window"`
+
+	// ChargerStateHold is how long a new computed/charger_state must persist
+	// before it is reported, to ride out flapping CHRG/DISCHRG tokens.
+	ChargerStateHold Duration `toml:"charger_state_hold"`
+
+	// RuntimeWindow is how far back computed/estimated_runtime_mins looks
+	// when fitting the discharge rate.  Zero disables the metric.
+	RuntimeWindow Duration `toml:"runtime_window"`
+
+	// RuntimeSmoothing is the time constant of the moving average of
+	// battery.runtime published as computed/battery_runtime_mins_smoothed.
+	// Zero disables the metric.
+	RuntimeSmoothing Duration `toml:"runtime_smoothing"`
+
+	// EfficiencyCurve maps load percent to the UPS's efficiency percent at
+	// that load, e.g. from its datasheet.  It is used to estimate
+	// computed/efficiency_pct and wasted_watts when the UPS doesn't report
+	// input.realpower; between points efficiency is interpolated.
+	EfficiencyCurve map[string]float64 `toml:"efficiency_curve"`
+
+	// PowerFactor estimates load_watts from ups.power.nominal (VA) for
+	// UPSes that don't report ups.realpower.nominal.  Zero disables it.
+	PowerFactor float64 `toml:"power_factor"`
+
+	// StatusSeparator and StatusCase ("title", "upper" or "lower") control
+	// how computed/status_display is rendered; StatusShort also publishes
+	// the raw tokens as computed/status_short, e.g. "OB/LB".
+	StatusSeparator string `toml:"status_separator"`
+	StatusCase      string `toml:"status_case"`
+	StatusShort     bool   `toml:"status_short"`
+
+	// Unavailable is what is published for load_watts, battery_runtime_mins,
+	// battery_runtime_hours and input_voltage_deviation_pct when the
+	// variables they derive from are missing: "zero" publishes 0, as if it
+	// were real; "skip" leaves the metric out; "null" publishes "unknown" to
+	// its computed/ topic and null in the state JSON; "flag" publishes 0
+	// with a {name}_valid companion, false when the 0 isn't real.
+	Unavailable string `toml:"unavailable"`
+
+	// MaxTemperature publishes computed/over_temperature, true once
+	// ups.temperature or battery.temperature reaches it (°C) and false
+	// again once both are TemperatureHysteresis below it.  Zero disables it.
+	MaxTemperature        float64 `toml:"max_temperature"`
+	TemperatureHysteresis float64 `toml:"temperature_hysteresis"`
+}
+
+// NotificationsConfig controls the {prefix}/{label}/notify topic and when
+// non-critical notifications are held back.
+type NotificationsConfig struct {
+	// Enabled publishes status events (on battery, low battery, forced
+	// shutdown, power restored) and alert transitions to the notify topic.
+	Enabled bool `toml:"enabled"`
+
+	// QuietHours is a daily local-time window, e.g. "22:00-07:00", during
+	// which only critical notifications are published.  Empty disables it.
+	QuietHours string `toml:"quiet_hours"`
+
+	// MuteBeeper sends INSTCMD beeper.disable when quiet hours start and
+	// beeper.enable when they end.  Needs a NUT user allowed those commands.
+	MuteBeeper bool `toml:"mute_beeper"`
+
+	// Notifiers are backends notified besides the notify topic, which is
+	// always available as the notifier named "mqtt".
+	Notifiers []NotifierConfig `toml:"notifiers"`
+
+	// Routes send matching events to particular notifiers; events no
+	// route matches go to every notifier.
+	Routes []NotificationRouteConfig `toml:"routes"`
+
+	// Language picks the [notifications.templates] table used for the
+	// notify topic and the notifiers that don't set their own.  Empty, or
+	// "en" without a table, keeps the built-in English messages.
+	Language string `toml:"language"`
+
+	// Templates replace the built-in English messages, by language and
+	// then event name — on_battery, an alert's name and {name}_cleared,
+	// daily_summary and so on — with text/template source executed with
+	// notify.TemplateData: .UPS, .Event, .Severity, .Message (the English
+	// one), .Time and .Variables.  Events without one keep the built-in
+	// message.
+	Templates map[string]map[string]string `toml:"templates"`
+}
+
+// NotifierConfig is one [[notifications.notifiers]] entry.  Type "webhook"
+// POSTs JSON to URL; "email" mails To through the SMTP server SMTPHost
+// (host:port), logging in when Username is set; "telegram" sends from the
+// bot Token to ChatID; "pushover" sends with the application Token to the
+// user or group UserKey; "slack" posts to the incoming webhook URL.
+// Language, when set, replaces notifications.language for this notifier.
+type NotifierConfig struct {
+	Name     string   `toml:"name"`
+	Type     string   `toml:"type"`
+	URL      string   `toml:"url"`
+	Timeout  Duration `toml:"timeout"`
+	SMTPHost string   `toml:"smtp_host"`
+	Username string   `toml:"username"`
+	Password string   `toml:"password"`
+	From     string   `toml:"from"`
+	To       []string `toml:"to"`
+	Token    string   `toml:"token"`
+	ChatID   string   `toml:"chat_id"`
+	UserKey  string   `toml:"user_key"`
+	Language string   `toml:"language"`
+}
+
+// NotificationRouteConfig is one [[notifications.routes]] entry: events
+// whose name matches one of Events (globs; all when empty) and whose
+// severity is at least MinSeverity go to Notifiers.
+type NotificationRouteConfig struct {
+	Events      []string `toml:"events"`
+	MinSeverity string   `toml:"min_severity"`
+	Notifiers   []string `toml:"notifiers"`
+}
+
+// QuirksConfig selects per-model quirk profiles; see internal/quirks.
+type QuirksConfig struct {
+	// Builtin enables the profiles shipped with the bridge.
+	Builtin bool `toml:"builtin"`
+
+	// Profiles are user-supplied [[quirks.profiles]], applied after (and so
+	// taking precedence over) the built-in ones.
+	Profiles []QuirkProfileConfig `toml:"profiles"`
+}
+
+// QuirkProfileConfig is one [[quirks.profiles]] entry.  At least one of
+// manufacturer, model and vendor_id must be set; manufacturer and model are
+// globs.
+type QuirkProfileConfig struct {
+	Name              string                  `toml:"name"`
+	Manufacturer      string                  `toml:"manufacturer"`
+	Model             string                  `toml:"model"`
+	VendorID          string                  `toml:"vendor_id"`
+	Drop              []string                `toml:"drop"`
+	Scale             map[string]float64      `toml:"scale"`
+	Bounds            map[string]BoundsConfig `toml:"bounds"`
+	Defaults          map[string]Value        `toml:"defaults"`
+	LoadFromRealPower bool                    `toml:"load_from_realpower"`
+}
+
+// AlertConfig is one [[alerts]] rule.  Which fields apply depends on Type;
+// see internal/alerts.
+type AlertConfig struct {
+	Name     string   `toml:"name"`
+	Type     string   `toml:"type"`
+	Severity string   `toml:"severity"` // "info", "warning" (default) or "critical"
+	Min      *float64 `toml:"min"`
+	Max      *float64 `toml:"max"`
+	When     string   `toml:"when"`
+}
+
+// WakeOnLANConfig sends Wake-on-LAN packets to hosts shut down during an
+// outage once mains power has returned.  An empty Targets disables it.
+type WakeOnLANConfig struct {
+	// Targets are the MAC addresses to wake.
+	Targets []string `toml:"targets"`
+
+	// Broadcast is the host:port the magic packets are sent to.
+	Broadcast string `toml:"broadcast"`
+
+	// Settle is how long mains must stay up before the packets are sent.
+	Settle Duration `toml:"settle"`
+
+	// Always wakes the targets after every outage, not only those that
+	// reached low battery or FSD, when upsmon will have shut hosts down.
+	Always bool `toml:"always"`
+}
+
+// SinkConfig is one [[sinks]] entry: an output that published messages are
+// routed to.  Type is "mqtt" (the broker connection configured under [mqtt]),
+// "file" (JSON lines appended to Path), "http" (each message POSTed as JSON
+// to URL), "plugin" (each message handed to the plugin program Command,
+// see internal/plugin) or "kafka" (each state message written to
+// KafkaTopic on the cluster at Brokers, keyed by the UPS label).  Topics
+// and Exclude are MQTT topic filters; a sink receives the
+// messages matching any of Topics (all of them when Topics is empty) and
+// none of Exclude.
+//
+// Kafka is a sink type rather than an output.type switch because sinks are
+// already how outputs are chosen: Kafka can run beside the broker, or
+// replace it with a disabled mqtt entry, and takes the same topic filters,
+// without a second setting that could contradict [[sinks]].
+type SinkConfig struct {
+	Name     string   `toml:"name"`
+	Type     string   `toml:"type"`
+	Disabled bool     `toml:"disabled"`
+	Path     string   `toml:"path"`
+	URL      string   `toml:"url"`
+	Command  string   `toml:"command"`
+	Args     []string `toml:"args"`
+	Timeout  Duration `toml:"timeout"`
+	Topics   []string `toml:"topics"`
+	Exclude  []string `toml:"exclude"`
+
+	Brokers    []string `toml:"brokers"`
+	KafkaTopic string   `toml:"kafka_topic"`
+
+	// TLS connects a kafka sink to its brokers over TLS, with the CA,
+	// verification and server name options of [mqtt].  SASLMechanism
+	// ("plain", "scram-sha-256" or "scram-sha-512") logs in as Username
+	// with Password.
+	TLS           bool   `toml:"tls"`
+	TLSCACert     string `toml:"tls_ca_cert"`
+	TLSInsecure   bool   `toml:"tls_insecure"`
+	TLSServerName string `toml:"tls_server_name"`
+	SASLMechanism string `toml:"sasl_mechanism"`
+	Username      string `toml:"username"`
+	Password      string `toml:"password"`
+}
+
+// CommandsConfig lets MQTT clients run NUT instant commands by publishing
+// their names to {prefix}/{label}/cmd.  Allow holds glob patterns
+// (path.Match syntax) of the commands accepted and must not be empty when
+// Enabled; "*" accepts every command upsd grants the configured NUT user.
+type CommandsConfig struct {
+	Enabled bool     `toml:"enabled"`
+	Allow   []string `toml:"allow"`
+}
+
+// SummaryConfig publishes a daily digest of the polls — input voltage
+// range, energy used, outages, time on battery and average load — to
+// {prefix}/{label}/summary at Time, a local "HH:MM"; empty disables it.
+// Notify also sends it to the notifiers as the info event daily_summary,
+// when notifications are enabled.
+type SummaryConfig struct {
+	Time   string `toml:"time"`
+	Notify bool   `toml:"notify"`
+}
+
+// OutagesConfig keeps a long-term record of outages in Dir, one
+// outages_{label}.json file per UPS, and publishes statistics over it —
+// outages per month, mean time between outages, the longest outage and a
+// histogram of durations — retained to {prefix}/{label}/outages/stats.
+// An empty Dir disables it.
+type OutagesConfig struct {
+	Dir string `toml:"dir"`
+}
+
+// CheckpointConfig keeps the last successful poll in Dir, one
+// checkpoint_{label}.json file per UPS, replaced after every poll.  At
+// startup the bridge publishes it to the state topic, marked "stale":true
+// with its original timestamp, so dashboards have the last reading while
+// upsd is still being reached, such as after a restart during an outage.
+// An empty Dir disables it.
+type CheckpointConfig struct {
+	Dir string `toml:"dir"`
+}
+
+// DrillConfig lets MQTT clients rehearse an outage by publishing "start" to
+// {prefix}/{label}/bridge/drill: the bridge runs a scripted one — power
+// lost, low battery, power restored, Step apart — through the events topic
+// and the notifiers, every message marked as a drill, while the state and
+// variable topics keep the UPS's real readings.  "stop" ends a drill early
+// with the power_restored step.
+type DrillConfig struct {
+	Enabled bool     `toml:"enabled"`
+	Step    Duration `toml:"step"`
+}
+
+// UpdateCheckConfig has the bridge look for a newer release of itself
+// every Interval and publish it on the retained
+// {prefix}/{label}/bridge/update_available topic, so fleet dashboards can
+// show which bridges are outdated.  URL is the release feed, GitHub's
+// latest release API by default.  Nothing is ever downloaded or installed.
+type UpdateCheckConfig struct {
+	Enabled  bool     `toml:"enabled"`
+	URL      string   `toml:"url"`
+	Interval Duration `toml:"interval"`
+}
+
+// LoggingConfig controls the bridge's own log output.
+type LoggingConfig struct {
+	// SuppressRepeats writes a log message that repeats the one before it
+	// only once, then, when a different one comes, how many times it was
+	// repeated, like syslog does, so a long outage of upsd or the broker
+	// doesn't fill a small disk or wear out an SD card.
+	SuppressRepeats bool `toml:"suppress_repeats"`
+}
+
+// LowPowerConfig reduces the bridge's own work while the UPS is on
+// battery, for hosts powered by the UPS they report on.  Polls come every
+// PollInterval, usually more often than nut.poll_interval so the battery
+// is followed closely, while the per-variable and computed topics are
+// published only every VariablesEvery and ComputedEvery polls, and the
+// [[sinks]] other than mqtt, the clients report and the Grafana,
+// Prometheus textfile and snapshot exports pause until mains return.
+type LowPowerConfig struct {
+	Enabled        bool     `toml:"enabled"`
+	PollInterval   Duration `toml:"poll_interval"`
+	VariablesEvery int      `toml:"variables_every"`
+	ComputedEvery  int      `toml:"computed_every"`
+}
+
+// LowBatteryConfig ties low_battery to the UPS's own thresholds.
+// Thresholds also counts the battery as low, while on battery, once
+// battery.charge or battery.runtime falls to battery.charge.low or
+// battery.runtime.low, rather than waiting for the UPS to raise LB.
+// Charge and Runtime, when non-zero, are written to those two variables
+// with SET VAR at startup, so the UPS and the bridge agree on them; the NUT
+// user needs SET rights in upsd.users.
+type LowBatteryConfig struct {
+	Thresholds bool     `toml:"thresholds"`
+	Charge     float64  `toml:"charge"`
+	Runtime    Duration `toml:"runtime"`
+}
+
+// HookConfig names a program run once per poll to transform the variables,
+// add computed values or veto the poll's publishes (see internal/hook).  An
+// empty Command disables it.  Plugin keeps the program running and talks to
+// it over the plugin protocol (see internal/plugin) instead of starting it
+// for every poll.  Script is a Lua file run by the embedded interpreter
+// instead of a program; it can't be combined with Command.
+type HookConfig struct {
+	Script  string   `toml:"script"`
+	Command string   `toml:"command"`
+	Args    []string `toml:"args"`
+	Timeout Duration `toml:"timeout"`
+	Plugin  bool     `toml:"plugin"`
+}
+
+// HooksConfig runs a shell command, through sh -c, when the UPS goes on
+// battery, mains are restored, the battery runs low or polling starts to
+// fail, for integrations that don't go through MQTT.  Each command runs
+// in the background with the poll's NUT variables in its environment, as
+// NUT_BATTERY_CHARGE and so on, and is killed after Timeout.  An empty
+// command runs nothing.
+type HooksConfig struct {
+	OnBattery     string   `toml:"on_battery"`
+	PowerRestored string   `toml:"power_restored"`
+	LowBattery    string   `toml:"low_battery"`
+	PollFailure   string   `toml:"poll_failure"`
+	Timeout       Duration `toml:"timeout"`
+}
+
+// Command returns the command configured for event, one of on_battery,
+// power_restored, low_battery and poll_failure, or "" for none.
+func (h HooksConfig) Command(event string) string {
+	switch event {
+	case "on_battery":
+		return h.OnBattery
+	case "power_restored":
+		return h.PowerRestored
+	case "low_battery":
+		return h.LowBattery
+	case "poll_failure":
+		return h.PollFailure
+	}
+	return ""
 }
 
 // Config is the top-level configuration struct.
 type Config struct {
-	NUT  NUTConfig  `toml:"nut"`
-	MQTT MQTTConfig `toml:"mqtt"`
+	// Profile names a preset for a kind of broker ("home-assistant",
+	// "aws-iot" or "thingsboard") applied to the defaults before the rest
+	// of the config; see profiles.
+	Profile string `toml:"profile"`
+
+	// Preset names a preset for the host the bridge runs on ("rpi"),
+	// applied to the defaults before the profile; see presets.
+	Preset string `toml:"preset"`
+
+	NUT           NUTConfig           `toml:"nut"`
+	MQTT          MQTTConfig          `toml:"mqtt"`
+	Filter        FilterConfig        `toml:"filter"`
+	HomeAssistant HomeAssistantConfig `toml:"homeassistant"`
+	Migration     MigrationConfig     `toml:"migration"`
+	Pushgateway   PushgatewayConfig   `toml:"pushgateway"`
+	Prometheus    PrometheusConfig    `toml:"prometheus"`
+	Grafana       GrafanaConfig       `toml:"grafana"`
+	Diagnostics   DiagnosticsConfig   `toml:"diagnostics"`
+	Metrics       MetricsConfig       `toml:"metrics"`
+	Notifications NotificationsConfig `toml:"notifications"`
+	WakeOnLAN     WakeOnLANConfig     `toml:"wake_on_lan"`
+	Alerts        []AlertConfig       `toml:"alerts"`
+	Quirks        QuirksConfig        `toml:"quirks"`
+	Sinks         []SinkConfig        `toml:"sinks"`
+	Hook          HookConfig          `toml:"hook"`
+	Hooks         HooksConfig         `toml:"hooks"`
+	Commands      CommandsConfig      `toml:"commands"`
+	LowBattery    LowBatteryConfig    `toml:"low_battery"`
+	LowPower      LowPowerConfig      `toml:"low_power"`
+	Summary       SummaryConfig       `toml:"summary"`
+	Outages       OutagesConfig       `toml:"outages"`
+	Checkpoint    CheckpointConfig    `toml:"checkpoint"`
+	Drill         DrillConfig         `toml:"drill"`
+	UpdateCheck   UpdateCheckConfig   `toml:"update_check"`
+	Logging       LoggingConfig       `toml:"logging"`
+
+	// Labels are site-specific tags (site, rack, room, …) added to the
+	// state message, Prometheus labels, Grafana/Influx tags and Home
+	// Assistant discovery, so fleets can tell UPSes apart without encoding
+	// location into topic prefixes.
+	Labels map[string]string `toml:"labels"`
+}
+
+// checkLanguage rejects a notification language, named what, that has no
+// [notifications.templates] table, so a typo doesn't quietly leave the
+// messages in English.
+func (c *Config) checkLanguage(what, lang string) error {
+	if _, ok := c.Notifications.Templates[lang]; ok || lang == "" || lang == "en" {
+		return nil
+	}
+	return fmt.Errorf("%s %q has no [notifications.templates.%s] table", what, lang, lang)
+}
+
+// MirrorRoot returns the {prefix}/{label} root of the migration layout, or
+// "" when no migration is configured or it resolves to the current root.
+func (c *Config) MirrorRoot() string {
+	if c.Migration.TopicPrefix == "" && c.Migration.Label == "" {
+		return ""
+	}
+	prefix, label := c.Migration.TopicPrefix, c.Migration.Label
+	if prefix == "" {
+		prefix = c.MQTT.TopicPrefix
+	}
+	if label == "" {
+		label = c.NUT.EffectiveLabel()
+	}
+	if prefix == c.MQTT.TopicPrefix && label == c.NUT.EffectiveLabel() {
+		return ""
+	}
+	return prefix + "/" + label
+}
+
+// PerUPS returns one Config per UPS to poll: c itself when [[nut.ups]] is
+// empty, otherwise a copy for each entry with its name, label and
+// overrides, and an MQTT client ID suffixed with the label so each gets its
+// own connection and last will.
+func (c *Config) PerUPS() []*Config {
+	if len(c.NUT.UPS) == 0 {
+		return []*Config{c}
+	}
+	cfgs := make([]*Config, len(c.NUT.UPS))
+	for i, u := range c.NUT.UPS {
+		uc := *c
+		uc.NUT.UPSName, uc.NUT.Label, uc.NUT.UPS = u.Name, u.Label, nil
+		uc.MQTT.ClientID = c.MQTT.ClientID + "-" + uc.NUT.EffectiveLabel()
+		if u.PollInterval != nil {
+			uc.NUT.PollInterval = *u.PollInterval
+		}
+		if u.TopicPrefix != "" {
+			uc.MQTT.TopicPrefix = u.TopicPrefix
+		}
+		if u.Retained != nil {
+			uc.MQTT.Retained = *u.Retained
+		}
+		if u.QOS != nil {
+			uc.MQTT.QOS = *u.QOS
+		}
+		if u.Filter != nil {
+			uc.Filter = *u.Filter
+		}
+		cfgs[i] = &uc
+	}
+	return cfgs
+}
+
+// ForUPS returns the config of the UPS upsName found by nut.ups_name =
+// "*": published under its own name, with a client ID of its own.
+func (c *Config) ForUPS(upsName string) *Config {
+	uc := *c
+	uc.NUT.UPSName, uc.NUT.Label = upsName, ""
+	uc.MQTT.ClientID = c.MQTT.ClientID + "-" + upsName
+	return &uc
+}
+
+// multiUPS reports whether c may poll more than one UPS.
+func (c *Config) multiUPS() bool {
+	return len(c.NUT.UPS) > 1 || c.NUT.Discover()
 }
 
-// Load reads config from the first existing path in paths, then applies
-// environment variable overrides.  Missing files are skipped silently;
+// Load reads config from the first existing path in paths, over the
+// defaults as adjusted by the profile it names, then applies environment
+// variable overrides.  Missing files are skipped silently;
 // a malformed file returns an error.  Calling Load() with no arguments
 // returns pure defaults plus any env overrides.
 func Load(paths ...string) (*Config, error) {
 	cfg := defaults()
 
+	var file string
 	for _, path := range paths {
 		if path == "" {
 			continue
 		}
 		if _, statErr := os.Stat(path); statErr == nil {
-			if _, err := toml.DecodeFile(path, cfg); err != nil {
-				return nil, fmt.Errorf("parsing config %q: %w", path, err)
-			}
+			file = path
 			break // first found file wins
 		} else if !os.IsNotExist(statErr) {
 			return nil, fmt.Errorf("checking config path %q: %w", path, statErr)
 		}
 	}
+	if err := applyProfile(cfg, file); err != nil {
+		return nil, err
+	}
+	if file != "" {
+		if _, err := toml.DecodeFile(file, cfg); err != nil {
+			return nil, fmt.Errorf("parsing config %q: %w", file, err)
+		}
+	}
 
-	applyEnvOverrides(cfg)
+	if err := applyEnvOverrides(cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if err := resolveSecrets(cfg); err != nil {
+		return nil, err
+	}
 	return cfg, nil
 }
 
-func defaults() *Config {
-	return &Config{
-		NUT: NUTConfig{
-			Host:         "localhost",
-			Port:         3493,
-			UPSName:      "cyberpower",
-			PollInterval: Duration{30 * time.Second},
-		},
-		MQTT: MQTTConfig{
-			Broker:      "tcp://localhost:1883",
-			ClientID:    "ups-mqtt",
-			TopicPrefix: "ups",
-			Retained:    true,
-			QOS:         1,
-		},
+// validate rejects settings that would otherwise fail obscurely at runtime.
+func (c *Config) validate() error {
+	if err := c.validatePerUPS(); err != nil {
+		return err
 	}
-}
-
-// applyEnvOverrides copies any set UPS_MQTT_* environment variables into cfg.
-func applyEnvOverrides(cfg *Config) {
-	if v := os.Getenv("UPS_MQTT_NUT_HOST"); v != "" {
-		cfg.NUT.Host = v
+	if c.NUT.Password != "" && c.NUT.PasswordCommand != "" {
+		return fmt.Errorf("nut.password and nut.password_command are mutually exclusive")
 	}
-	if v := os.Getenv("UPS_MQTT_NUT_PORT"); v != "" {
-		if p, err := strconv.Atoi(v); err == nil {
-			cfg.NUT.Port = p
-		} else {
-			log.Printf("config: ignoring invalid UPS_MQTT_NUT_PORT=%q: %v", v, err)
+	if c.MQTT.Password != "" && c.MQTT.PasswordCommand != "" {
+		return fmt.Errorf("mqtt.password and mqtt.password_command are mutually exclusive")
+	}
+	if (c.MQTT.TLSClientCert == "") != (c.MQTT.TLSClientKey == "") {
+		return fmt.Errorf("mqtt.tls_client_cert and mqtt.tls_client_key must be set together")
+	}
+	switch c.MQTT.StateOverflow {
+	case "drop_driver", "truncate", "split":
+	default:
+		return fmt.Errorf("mqtt.state_overflow must be \"drop_driver\", \"truncate\" or \"split\", got %q", c.MQTT.StateOverflow)
+	}
+	switch c.MQTT.PublishMode {
+	case "always", "on_change":
+	default:
+		return fmt.Errorf("mqtt.publish_mode must be \"always\" or \"on_change\", got %q", c.MQTT.PublishMode)
+	}
+	switch c.MQTT.BrokerMode {
+	case "failover", "fanout":
+	default:
+		return fmt.Errorf("mqtt.broker_mode must be \"failover\" or \"fanout\", got %q", c.MQTT.BrokerMode)
+	}
+	for _, b := range c.MQTT.Brokers {
+		if b == "" {
+			return fmt.Errorf("mqtt.brokers must not contain empty entries")
 		}
 	}
-	if v := os.Getenv("UPS_MQTT_NUT_USERNAME"); v != "" {
-		cfg.NUT.Username = v
+	labels := make(map[string]bool)
+	for i, u := range c.NUT.UPS {
+		if u.Name == "" {
+			return fmt.Errorf("nut.ups[%d]: name is required", i)
+		}
+		label := NUTConfig{UPSName: u.Name, Label: u.Label}.EffectiveLabel()
+		if labels[label] {
+			return fmt.Errorf("nut.ups[%d]: label %q already in use", i, label)
+		}
+		labels[label] = true
 	}
-	if v := os.Getenv("UPS_MQTT_NUT_PASSWORD"); v != "" {
-		cfg.NUT.Password = v
+	for i, uc := range c.PerUPS() {
+		if uc == c {
+			break // no [[nut.ups]] entries: checked above
+		}
+		if err := uc.validatePerUPS(); err != nil {
+			return fmt.Errorf("nut.ups[%d]: %w", i, err)
+		}
 	}
-	if v := os.Getenv("UPS_MQTT_NUT_UPS_NAME"); v != "" {
-		cfg.NUT.UPSName = v
+	if c.NUT.StatusDebounce < 0 {
+		return fmt.Errorf("nut.status_debounce must not be negative, got %d", c.NUT.StatusDebounce)
 	}
-	if v := os.Getenv("UPS_MQTT_NUT_LABEL"); v != "" {
-		cfg.NUT.Label = v
+	if c.NUT.MaxConnections < 1 {
+		return fmt.Errorf("nut.max_connections must be at least 1, got %d", c.NUT.MaxConnections)
 	}
-	if v := os.Getenv("UPS_MQTT_NUT_POLL_INTERVAL"); v != "" {
-		if d, err := time.ParseDuration(v); err == nil {
-			cfg.NUT.PollInterval = Duration{d}
-		} else {
-			log.Printf("config: ignoring invalid UPS_MQTT_NUT_POLL_INTERVAL=%q: %v", v, err)
+	if c.NUT.MaxBackoff.Duration < time.Second {
+		return fmt.Errorf("nut.max_backoff must be at least 1s, got %s", c.NUT.MaxBackoff.Duration)
+	}
+	if c.MQTT.MaxBackoff.Duration < time.Second {
+		return fmt.Errorf("mqtt.max_backoff must be at least 1s, got %s", c.MQTT.MaxBackoff.Duration)
+	}
+	if c.NUT.Discover() && len(c.NUT.UPS) > 0 {
+		return fmt.Errorf("nut.ups_name = \"*\" can't be combined with [[nut.ups]] entries")
+	}
+	if c.NUT.Discover() && c.NUT.Label != "" {
+		return fmt.Errorf("nut.label can't be used with nut.ups_name = \"*\": each UPS is published under its own name")
+	}
+	if c.multiUPS() && c.Migration.Label != "" {
+		return fmt.Errorf("migration.label can't be used with more than one [[nut.ups]] entry or nut.ups_name = \"*\"")
+	}
+	if c.Diagnostics.AuditLog < 0 {
+		return fmt.Errorf("diagnostics.audit_log must not be negative, got %d", c.Diagnostics.AuditLog)
+	}
+	if c.multiUPS() && c.Diagnostics.SnapshotFile != "" {
+		return fmt.Errorf("diagnostics.snapshot_file can't be used with more than one [[nut.ups]] entry or nut.ups_name = \"*\"")
+	}
+	if c.multiUPS() && c.MQTT.BufferFile != "" {
+		return fmt.Errorf("mqtt.buffer_file can't be used with more than one [[nut.ups]] entry or nut.ups_name = \"*\"")
+	}
+	if c.MQTT.Aggregate && !c.multiUPS() {
+		return fmt.Errorf("mqtt.aggregate needs more than one [[nut.ups]] entry or nut.ups_name = \"*\"")
+	}
+	if c.Summary.Time != "" {
+		if _, err := schedule.ParseDaily(c.Summary.Time); err != nil {
+			return fmt.Errorf("summary.time: %w", err)
 		}
 	}
-	if v := os.Getenv("UPS_MQTT_MQTT_BROKER"); v != "" {
-		cfg.MQTT.Broker = v
+	if c.Notifications.QuietHours != "" {
+		if _, err := schedule.Parse(c.Notifications.QuietHours); err != nil {
+			return fmt.Errorf("notifications.quiet_hours: %w", err)
+		}
 	}
-	if v := os.Getenv("UPS_MQTT_MQTT_USERNAME"); v != "" {
-		cfg.MQTT.Username = v
+	if _, err := notify.ParseTemplates(c.Notifications.Templates); err != nil {
+		return fmt.Errorf("notifications.templates: %w", err)
 	}
-	if v := os.Getenv("UPS_MQTT_MQTT_PASSWORD"); v != "" {
-		cfg.MQTT.Password = v
+	if err := c.checkLanguage("notifications.language", c.Notifications.Language); err != nil {
+		return err
 	}
-	if v := os.Getenv("UPS_MQTT_MQTT_CLIENT_ID"); v != "" {
-		cfg.MQTT.ClientID = v
+	notifiers := map[string]bool{"mqtt": true}
+	for i, n := range c.Notifications.Notifiers {
+		if n.Name == "" {
+			return fmt.Errorf("notifications.notifiers[%d]: name is required", i)
+		}
+		if notifiers[n.Name] {
+			return fmt.Errorf("notifier %q: name already in use", n.Name)
+		}
+		notifiers[n.Name] = true
+		switch n.Type {
+		case "webhook":
+			if n.URL == "" {
+				return fmt.Errorf("notifier %q: webhook needs a url", n.Name)
+			}
+		case "email":
+			if n.SMTPHost == "" || n.From == "" || len(n.To) == 0 {
+				return fmt.Errorf("notifier %q: email needs smtp_host, from and to", n.Name)
+			}
+			if _, _, err := net.SplitHostPort(n.SMTPHost); err != nil {
+				return fmt.Errorf("notifier %q: smtp_host: %w", n.Name, err)
+			}
+		case "telegram":
+			if n.Token == "" || n.ChatID == "" {
+				return fmt.Errorf("notifier %q: telegram needs a token and chat_id", n.Name)
+			}
+		case "pushover":
+			if n.Token == "" || n.UserKey == "" {
+				return fmt.Errorf("notifier %q: pushover needs a token and user_key", n.Name)
+			}
+		case "slack":
+			if n.URL == "" {
+				return fmt.Errorf("notifier %q: slack needs a url", n.Name)
+			}
+		default:
+			return fmt.Errorf("notifier %q: type must be \"webhook\", \"email\", \"telegram\", \"pushover\" or \"slack\", got %q", n.Name, n.Type)
+		}
+		if err := c.checkLanguage(fmt.Sprintf("notifier %q: language", n.Name), n.Language); err != nil {
+			return err
+		}
 	}
-	if v := os.Getenv("UPS_MQTT_MQTT_TOPIC_PREFIX"); v != "" {
-		cfg.MQTT.TopicPrefix = v
+	for i, r := range c.Notifications.Routes {
+		switch r.MinSeverity {
+		case "", "info", "warning", "critical":
+		default:
+			return fmt.Errorf("notifications.routes[%d]: min_severity must be \"info\", \"warning\" or \"critical\", got %q", i, r.MinSeverity)
+		}
+		for _, glob := range r.Events {
+			if _, err := path.Match(glob, ""); err != nil {
+				return fmt.Errorf("notifications.routes[%d]: invalid event pattern %q", i, glob)
+			}
+		}
+		if len(r.Notifiers) == 0 {
+			return fmt.Errorf("notifications.routes[%d]: notifiers is required", i)
+		}
+		for _, name := range r.Notifiers {
+			if !notifiers[name] {
+				return fmt.Errorf("notifications.routes[%d]: unknown notifier %q", i, name)
+			}
+		}
 	}
-	if v := os.Getenv("UPS_MQTT_MQTT_RETAINED"); v != "" {
-		cfg.MQTT.Retained = v == "true" || v == "1"
+	for _, mac := range c.WakeOnLAN.Targets {
+		if _, err := wol.MagicPacket(mac); err != nil {
+			return fmt.Errorf("wake_on_lan.targets: %w", err)
+		}
 	}
-	if v := os.Getenv("UPS_MQTT_MQTT_QOS"); v != "" {
-		if q, err := strconv.ParseUint(v, 10, 8); err == nil {
-			cfg.MQTT.QOS = byte(q)
-		} else {
-			log.Printf("config: ignoring invalid UPS_MQTT_MQTT_QOS=%q: %v", v, err)
+	if len(c.WakeOnLAN.Targets) > 0 {
+		if _, _, err := net.SplitHostPort(c.WakeOnLAN.Broadcast); err != nil {
+			return fmt.Errorf("wake_on_lan.broadcast: %w", err)
 		}
 	}
-	if v := os.Getenv("UPS_MQTT_MQTT_TLS_CA_CERT"); v != "" {
-		cfg.MQTT.TLSCACert = v
+	for name, v := range c.Labels {
+		if !validLabelName(name) {
+			return fmt.Errorf("labels: %q must be letters, digits and underscores, not starting with a digit or \"__\", and not \"ups\"", name)
+		}
+		if v == "" {
+			return fmt.Errorf("labels.%s must not be empty", name)
+		}
+	}
+	for key, globs := range map[string][]string{"mqtt.include_vars": c.MQTT.IncludeVars, "mqtt.exclude_vars": c.MQTT.ExcludeVars} {
+		for _, glob := range globs {
+			if _, err := path.Match(glob, ""); err != nil {
+				return fmt.Errorf("%s: invalid pattern %q", key, glob)
+			}
+		}
+	}
+	if c.Commands.Enabled && len(c.Commands.Allow) == 0 {
+		return fmt.Errorf("commands.allow must list the commands to accept (\"*\" for any) when commands.enabled is set")
+	}
+	for _, glob := range c.Commands.Allow {
+		if _, err := path.Match(glob, ""); err != nil {
+			return fmt.Errorf("commands.allow: invalid pattern %q", glob)
+		}
+	}
+	if c.LowBattery.Charge < 0 || c.LowBattery.Charge > 100 {
+		return fmt.Errorf("low_battery.charge must be between 0 and 100, got %v", c.LowBattery.Charge)
+	}
+	if c.LowBattery.Runtime.Duration < 0 {
+		return fmt.Errorf("low_battery.runtime must not be negative, got %s", c.LowBattery.Runtime.Duration)
+	}
+	if c.Hook.Script != "" && c.Hook.Command != "" {
+		return fmt.Errorf("hook.script and hook.command are mutually exclusive")
+	}
+	if (c.Hook.Command != "" || c.Hook.Script != "") && c.Hook.Timeout.Duration <= 0 {
+		return fmt.Errorf("hook.timeout must be positive, got %s", c.Hook.Timeout.Duration)
+	}
+	if c.Hooks != (HooksConfig{Timeout: c.Hooks.Timeout}) && c.Hooks.Timeout.Duration <= 0 {
+		return fmt.Errorf("hooks.timeout must be positive, got %s", c.Hooks.Timeout.Duration)
+	}
+	switch c.Metrics.StatusCase {
+	case "", "title", "upper", "lower":
+	default:
+		return fmt.Errorf("metrics.status_case must be \"title\", \"upper\" or \"lower\", got %q", c.Metrics.StatusCase)
+	}
+	switch c.Metrics.Unavailable {
+	case "", "zero", "skip", "null", "flag":
+	default:
+		return fmt.Errorf("metrics.unavailable must be \"zero\", \"skip\", \"null\" or \"flag\", got %q", c.Metrics.Unavailable)
+	}
+	if c.Metrics.PowerFactor < 0 || c.Metrics.PowerFactor > 1 {
+		return fmt.Errorf("metrics.power_factor must be between 0 and 1, got %v", c.Metrics.PowerFactor)
+	}
+	if c.Metrics.TemperatureHysteresis < 0 {
+		return fmt.Errorf("metrics.temperature_hysteresis must not be negative, got %v", c.Metrics.TemperatureHysteresis)
+	}
+	for load, eff := range c.Metrics.EfficiencyCurve {
+		if l, err := strconv.ParseFloat(load, 64); err != nil || l < 0 || l > 100 {
+			return fmt.Errorf("metrics.efficiency_curve: load %q must be a percentage", load)
+		}
+		if eff <= 0 || eff > 100 {
+			return fmt.Errorf("metrics.efficiency_curve: efficiency %v at %s%% load must be in (0, 100]", eff, load)
+		}
+	}
+	for i, q := range c.Quirks.Profiles {
+		if q.Name == "" {
+			return fmt.Errorf("quirks.profiles[%d]: name is required", i)
+		}
+		if q.Manufacturer == "" && q.Model == "" && q.VendorID == "" {
+			return fmt.Errorf("quirks profile %q: set at least one of manufacturer, model or vendor_id", q.Name)
+		}
+		for _, glob := range []string{q.Manufacturer, q.Model} {
+			if _, err := path.Match(glob, ""); err != nil {
+				return fmt.Errorf("quirks profile %q: invalid pattern %q", q.Name, glob)
+			}
+		}
+	}
+	mqttSinks := 0
+	for i, sk := range c.Sinks {
+		switch sk.Type {
+		case "mqtt":
+			if mqttSinks++; mqttSinks > 1 {
+				return fmt.Errorf("sinks[%d]: only one mqtt sink may be configured", i)
+			}
+		case "file":
+			if sk.Path == "" {
+				return fmt.Errorf("sinks[%d]: file sink needs a path", i)
+			}
+		case "http":
+			if sk.URL == "" {
+				return fmt.Errorf("sinks[%d]: http sink needs a url", i)
+			}
+		case "plugin":
+			if sk.Command == "" {
+				return fmt.Errorf("sinks[%d]: plugin sink needs a command", i)
+			}
+		case "kafka":
+			if len(sk.Brokers) == 0 || sk.KafkaTopic == "" {
+				return fmt.Errorf("sinks[%d]: kafka sink needs brokers and a kafka_topic", i)
+			}
+			switch sk.SASLMechanism {
+			case "":
+			case "plain", "scram-sha-256", "scram-sha-512":
+				if sk.Username == "" {
+					return fmt.Errorf("sinks[%d]: sasl_mechanism needs a username", i)
+				}
+			default:
+				return fmt.Errorf("sinks[%d]: sasl_mechanism must be \"plain\", \"scram-sha-256\" or \"scram-sha-512\", got %q", i, sk.SASLMechanism)
+			}
+		default:
+			return fmt.Errorf("sinks[%d]: type must be \"mqtt\", \"file\", \"http\", \"plugin\" or \"kafka\", got %q", i, sk.Type)
+		}
+		for _, filter := range append(sk.Topics, sk.Exclude...) {
+			if filter == "" {
+				return fmt.Errorf("sinks[%d]: empty topic filter", i)
+			}
+		}
+	}
+	return nil
+}
+
+// validatePerUPS checks the settings a [[nut.ups]] entry can override,
+// as they apply to one UPS.
+func (c *Config) validatePerUPS() error {
+	switch c.Filter.Mode {
+	case "drop", "clamp":
+	default:
+		return fmt.Errorf("filter.mode must be \"drop\" or \"clamp\", got %q", c.Filter.Mode)
+	}
+	if c.NUT.PollInterval.Duration <= 0 {
+		return fmt.Errorf("nut.poll_interval must be positive, got %s", c.NUT.PollInterval.Duration)
+	}
+	if c.MQTT.QOS > 2 {
+		return fmt.Errorf("mqtt.qos must be 0, 1 or 2, got %d", c.MQTT.QOS)
+	}
+	if c.MQTT.ByteBudget < 0 {
+		return fmt.Errorf("mqtt.byte_budget must not be negative, got %d", c.MQTT.ByteBudget)
+	}
+	if c.MQTT.BufferSize < 0 {
+		return fmt.Errorf("mqtt.buffer_size must not be negative, got %d", c.MQTT.BufferSize)
+	}
+	if c.MQTT.BufferFile != "" && c.MQTT.BufferSize == 0 {
+		return fmt.Errorf("mqtt.buffer_file needs mqtt.buffer_size")
+	}
+	if d := c.MQTT.RetainTTL.Duration; d != 0 && d <= c.NUT.PollInterval.Duration {
+		return fmt.Errorf("mqtt.retain_ttl must be longer than nut.poll_interval (%s), got %s", c.NUT.PollInterval.Duration, d)
+	}
+	if c.NUT.AlignPolls {
+		if d := c.NUT.PollInterval.Duration; (24*time.Hour)%d != 0 {
+			return fmt.Errorf("nut.align_polls needs a poll_interval that divides a day evenly, got %s", d)
+		}
+	}
+	if c.LowPower.Enabled {
+		d := c.LowPower.PollInterval.Duration
+		if d <= 0 {
+			return fmt.Errorf("low_power.poll_interval must be positive, got %s", d)
+		}
+		if c.NUT.AlignPolls && (24*time.Hour)%d != 0 {
+			return fmt.Errorf("nut.align_polls needs a low_power.poll_interval that divides a day evenly, got %s", d)
+		}
+	}
+	if c.Drill.Enabled && c.Drill.Step.Duration <= 0 {
+		return fmt.Errorf("drill.step must be positive, got %s", c.Drill.Step.Duration)
+	}
+	if c.UpdateCheck.Enabled {
+		if c.UpdateCheck.URL == "" {
+			return fmt.Errorf("update_check.url must be set when update_check.enabled is")
+		}
+		if c.UpdateCheck.Interval.Duration < time.Hour {
+			return fmt.Errorf("update_check.interval must be at least 1h, got %s", c.UpdateCheck.Interval.Duration)
+		}
+	}
+	return nil
+}
+
+// validLabelName reports whether name can be used as a Prometheus label
+// (and so also an Influx tag key): [a-zA-Z_][a-zA-Z0-9_]*, without the
+// reserved "__" prefix or the "ups" label every output already carries.
+func validLabelName(name string) bool {
+	if name == "" || name == "ups" || strings.HasPrefix(name, "__") {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func defaults() *Config {
+	return &Config{
+		NUT: NUTConfig{
+			Host:           "localhost",
+			Port:           3493,
+			UPSName:        "cyberpower",
+			PollInterval:   Duration{30 * time.Second},
+			MaxConnections: 4,
+			MaxBackoff:     Duration{time.Minute},
+		},
+		MQTT: MQTTConfig{
+			Broker:      "tcp://localhost:1883",
+			ClientID:    "ups-mqtt",
+			TopicPrefix: "ups",
+			Retained:    true,
+			QOS:         1,
+
+			SelfTestTimeout: Duration{5 * time.Second},
+			StateOverflow:   "drop_driver",
+			PublishMode:     "always",
+			BrokerMode:      "failover",
+			MaxBackoff:      Duration{time.Minute},
+		},
+		Filter: FilterConfig{
+			Mode: "drop",
+		},
+		HomeAssistant: HomeAssistantConfig{
+			DiscoveryPrefix: "homeassistant",
+		},
+		Pushgateway: PushgatewayConfig{
+			Job: "ups-mqtt",
+		},
+		Grafana: GrafanaConfig{
+			StreamID: "ups-mqtt",
+		},
+		WakeOnLAN: WakeOnLANConfig{
+			Broadcast: wol.DefaultAddr,
+			Settle:    Duration{2 * time.Minute},
+		},
+		Metrics: MetricsConfig{
+			ChargeRateWindow:      Duration{5 * time.Minute},
+			ChargerStateHold:      Duration{time.Minute},
+			RuntimeWindow:         Duration{10 * time.Minute},
+			RuntimeSmoothing:      Duration{5 * time.Minute},
+			PowerFactor:           0.6,
+			StatusSeparator:       ", ",
+			StatusCase:            "title",
+			Unavailable:           "zero",
+			TemperatureHysteresis: 2,
+		},
+		Hook: HookConfig{
+			Timeout: Duration{schedule.CallTimeout},
+		},
+		Hooks: HooksConfig{
+			Timeout: Duration{30 * time.Second},
+		},
+		LowPower: LowPowerConfig{
+			PollInterval:   Duration{10 * time.Second},
+			VariablesEvery: 12,
+			ComputedEvery:  12,
+		},
+		Drill: DrillConfig{
+			Step: Duration{30 * time.Second},
+		},
+		UpdateCheck: UpdateCheckConfig{
+			URL:      update.DefaultURL,
+			Interval: Duration{24 * time.Hour},
+		},
+	}
+}
+
+// applyEnvOverrides copies any set UPS_MQTT_* environment variables into cfg.
+// Each may instead be given as a file to read it from, with _FILE appended
+// to its name (see envReader); a file that can't be read is an error.
+func applyEnvOverrides(cfg *Config) error {
+	env := &envReader{}
+	if v := env.get("UPS_MQTT_NUT_HOST"); v != "" {
+		cfg.NUT.Host = v
+	}
+	if v := env.get("UPS_MQTT_NUT_HOSTS"); v != "" {
+		cfg.NUT.Hosts = splitList(v)
+	}
+	if v := env.get("UPS_MQTT_NUT_PORT"); v != "" {
+		if p, err := strconv.Atoi(v); err == nil {
+			cfg.NUT.Port = p
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_NUT_PORT=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_NUT_USERNAME"); v != "" {
+		cfg.NUT.Username = v
+	}
+	if v := env.get("UPS_MQTT_NUT_PASSWORD"); v != "" {
+		cfg.NUT.Password = v
+	}
+	if v := env.get("UPS_MQTT_NUT_PASSWORD_COMMAND"); v != "" {
+		cfg.NUT.PasswordCommand = v
+	}
+	if v := env.get("UPS_MQTT_NUT_UPS_NAME"); v != "" {
+		cfg.NUT.UPSName = v
+	}
+	if v := env.get("UPS_MQTT_NUT_LABEL"); v != "" {
+		cfg.NUT.Label = v
+	}
+	if v := env.get("UPS_MQTT_NUT_POLL_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.NUT.PollInterval = Duration{d}
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_NUT_POLL_INTERVAL=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_NUT_ALIGN_POLLS"); v != "" {
+		cfg.NUT.AlignPolls = v == "true" || v == "1"
+	}
+	if v := env.get("UPS_MQTT_NUT_HOLD_MISSING"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.NUT.HoldMissing = Duration{d}
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_NUT_HOLD_MISSING=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_NUT_POLL_VARS"); v != "" {
+		cfg.NUT.PollVars = splitList(v)
+	}
+	if v := env.get("UPS_MQTT_NUT_CLIENTS_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.NUT.ClientsInterval = Duration{d}
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_NUT_CLIENTS_INTERVAL=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_NUT_EXPECTED_CLIENTS"); v != "" {
+		cfg.NUT.ExpectedClients = splitList(v)
+	}
+	if v := env.get("UPS_MQTT_NUT_CLOCK_SKEW_THRESHOLD"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.NUT.ClockSkewThreshold = Duration{d}
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_NUT_CLOCK_SKEW_THRESHOLD=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_NUT_MAINS_STABLE"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.NUT.MainsStable = Duration{d}
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_NUT_MAINS_STABLE=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_NUT_STATUS_DEBOUNCE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.NUT.StatusDebounce = n
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_NUT_STATUS_DEBOUNCE=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_NUT_MAX_CONNECTIONS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.NUT.MaxConnections = n
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_NUT_MAX_CONNECTIONS=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_NUT_MAX_BACKOFF"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.NUT.MaxBackoff = Duration{d}
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_NUT_MAX_BACKOFF=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_NUT_DEFAULTS"); v != "" {
+		cfg.NUT.Defaults = make(map[string]Value)
+		for name, val := range splitMap(v) {
+			cfg.NUT.Defaults[name] = Value(val)
+		}
+	}
+	if v := env.get("UPS_MQTT_MQTT_BROKER"); v != "" {
+		cfg.MQTT.Broker = v
+	}
+	if v := env.get("UPS_MQTT_MQTT_BROKERS"); v != "" {
+		cfg.MQTT.Brokers = splitList(v)
+	}
+	if v := env.get("UPS_MQTT_MQTT_BROKER_MODE"); v != "" {
+		cfg.MQTT.BrokerMode = v
+	}
+	if v := env.get("UPS_MQTT_MQTT_USERNAME"); v != "" {
+		cfg.MQTT.Username = v
+	}
+	if v := env.get("UPS_MQTT_MQTT_PASSWORD"); v != "" {
+		cfg.MQTT.Password = v
+	}
+	if v := env.get("UPS_MQTT_MQTT_PASSWORD_COMMAND"); v != "" {
+		cfg.MQTT.PasswordCommand = v
+	}
+	if v := env.get("UPS_MQTT_MQTT_CLIENT_ID"); v != "" {
+		cfg.MQTT.ClientID = v
+	}
+	if v := env.get("UPS_MQTT_MQTT_TOPIC_PREFIX"); v != "" {
+		cfg.MQTT.TopicPrefix = v
+	}
+	if v := env.get("UPS_MQTT_MQTT_RETAINED"); v != "" {
+		cfg.MQTT.Retained = v == "true" || v == "1"
+	}
+	if v := env.get("UPS_MQTT_MQTT_QOS"); v != "" {
+		if q, err := strconv.ParseUint(v, 10, 8); err == nil {
+			cfg.MQTT.QOS = byte(q)
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_MQTT_QOS=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_MQTT_TLS_CA_CERT"); v != "" {
+		cfg.MQTT.TLSCACert = v
+	}
+	if v := env.get("UPS_MQTT_MQTT_TLS_INSECURE"); v != "" {
+		cfg.MQTT.TLSInsecure = v == "true" || v == "1"
+	}
+	if v := env.get("UPS_MQTT_MQTT_TLS_SERVER_NAME"); v != "" {
+		cfg.MQTT.TLSServerName = v
+	}
+	if v := env.get("UPS_MQTT_MQTT_TLS_CLIENT_CERT"); v != "" {
+		cfg.MQTT.TLSClientCert = v
+	}
+	if v := env.get("UPS_MQTT_MQTT_TLS_CLIENT_KEY"); v != "" {
+		cfg.MQTT.TLSClientKey = v
+	}
+	if v := env.get("UPS_MQTT_MQTT_LAST_CHANGED"); v != "" {
+		cfg.MQTT.LastChanged = splitList(v)
+	}
+	if v := env.get("UPS_MQTT_MQTT_NON_RETAINED"); v != "" {
+		cfg.MQTT.NonRetained = splitList(v)
+	}
+	if v := env.get("UPS_MQTT_MQTT_INCLUDE_VARS"); v != "" {
+		cfg.MQTT.IncludeVars = splitList(v)
+	}
+	if v := env.get("UPS_MQTT_MQTT_EXCLUDE_VARS"); v != "" {
+		cfg.MQTT.ExcludeVars = splitList(v)
+	}
+	if v := env.get("UPS_MQTT_MQTT_NAMESPACE_PREFIXES"); v != "" {
+		cfg.MQTT.NamespacePrefixes = splitMap(v)
+	}
+	if v := env.get("UPS_MQTT_MQTT_DIFF"); v != "" {
+		cfg.MQTT.Diff = v == "true" || v == "1"
+	}
+	if v := env.get("UPS_MQTT_MQTT_SELF_TEST"); v != "" {
+		cfg.MQTT.SelfTest = v == "true" || v == "1"
+	}
+	if v := env.get("UPS_MQTT_MQTT_SELF_TEST_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.MQTT.SelfTestTimeout = Duration{d}
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_MQTT_SELF_TEST_TIMEOUT=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_MQTT_ACL_CHECK"); v != "" {
+		cfg.MQTT.ACLCheck = v == "true" || v == "1"
+	}
+	if v := env.get("UPS_MQTT_MQTT_VARIABLES_EVERY"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.MQTT.VariablesEvery = n
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_MQTT_VARIABLES_EVERY=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_MQTT_COMPUTED_EVERY"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.MQTT.ComputedEvery = n
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_MQTT_COMPUTED_EVERY=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_MQTT_MAX_STATE_BYTES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.MQTT.MaxStateBytes = n
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_MQTT_MAX_STATE_BYTES=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_MQTT_STATE_OVERFLOW"); v != "" {
+		cfg.MQTT.StateOverflow = v
+	}
+	if v := env.get("UPS_MQTT_MQTT_PUBLISH_MODE"); v != "" {
+		cfg.MQTT.PublishMode = v
+	}
+	if v := env.get("UPS_MQTT_MQTT_EVENTS"); v != "" {
+		cfg.MQTT.Events = v == "true" || v == "1"
+	}
+	if v := env.get("UPS_MQTT_MQTT_RETAIN_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.MQTT.RetainTTL = Duration{d}
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_MQTT_RETAIN_TTL=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_MQTT_BYTE_STATS"); v != "" {
+		cfg.MQTT.ByteStats = v == "true" || v == "1"
+	}
+	if v := env.get("UPS_MQTT_MQTT_BYTE_BUDGET"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.MQTT.ByteBudget = n
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_MQTT_BYTE_BUDGET=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_MQTT_BUFFER_SIZE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.MQTT.BufferSize = n
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_MQTT_BUFFER_SIZE=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_MQTT_BUFFER_FILE"); v != "" {
+		cfg.MQTT.BufferFile = v
+	}
+	if v := env.get("UPS_MQTT_MQTT_REPUBLISH_ON_CONNECT"); v != "" {
+		cfg.MQTT.RepublishOnConnect = v == "true" || v == "1"
+	}
+	if v := env.get("UPS_MQTT_MQTT_AGGREGATE"); v != "" {
+		cfg.MQTT.Aggregate = v == "true" || v == "1"
+	}
+	if v := env.get("UPS_MQTT_MQTT_POLL_COMPLETE"); v != "" {
+		cfg.MQTT.PollComplete = v == "true" || v == "1"
+	}
+	if v := env.get("UPS_MQTT_MQTT_MAX_BACKOFF"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.MQTT.MaxBackoff = Duration{d}
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_MQTT_MAX_BACKOFF=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_FILTER_ENABLED"); v != "" {
+		cfg.Filter.Enabled = v == "true" || v == "1"
+	}
+	if v := env.get("UPS_MQTT_FILTER_MODE"); v != "" {
+		cfg.Filter.Mode = v
+	}
+	if v := env.get("UPS_MQTT_QUIRKS_BUILTIN"); v != "" {
+		cfg.Quirks.Builtin = v == "true" || v == "1"
+	}
+	if v := env.get("UPS_MQTT_MIGRATION_TOPIC_PREFIX"); v != "" {
+		cfg.Migration.TopicPrefix = v
+	}
+	if v := env.get("UPS_MQTT_MIGRATION_LABEL"); v != "" {
+		cfg.Migration.Label = v
+	}
+	if v := env.get("UPS_MQTT_METRICS_CHARGE_RATE_WINDOW"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.Metrics.ChargeRateWindow = Duration{d}
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_METRICS_CHARGE_RATE_WINDOW=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_METRICS_CHARGER_STATE_HOLD"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.Metrics.ChargerStateHold = Duration{d}
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_METRICS_CHARGER_STATE_HOLD=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_METRICS_RUNTIME_WINDOW"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.Metrics.RuntimeWindow = Duration{d}
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_METRICS_RUNTIME_WINDOW=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_METRICS_RUNTIME_SMOOTHING"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.Metrics.RuntimeSmoothing = Duration{d}
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_METRICS_RUNTIME_SMOOTHING=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_METRICS_POWER_FACTOR"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			cfg.Metrics.PowerFactor = f
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_METRICS_POWER_FACTOR=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_METRICS_STATUS_SEPARATOR"); v != "" {
+		cfg.Metrics.StatusSeparator = v
+	}
+	if v := env.get("UPS_MQTT_METRICS_STATUS_CASE"); v != "" {
+		cfg.Metrics.StatusCase = v
+	}
+	if v := env.get("UPS_MQTT_METRICS_STATUS_SHORT"); v != "" {
+		cfg.Metrics.StatusShort = v == "true" || v == "1"
+	}
+	if v := env.get("UPS_MQTT_METRICS_UNAVAILABLE"); v != "" {
+		cfg.Metrics.Unavailable = v
+	}
+	if v := env.get("UPS_MQTT_METRICS_MAX_TEMPERATURE"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			cfg.Metrics.MaxTemperature = f
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_METRICS_MAX_TEMPERATURE=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_METRICS_TEMPERATURE_HYSTERESIS"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			cfg.Metrics.TemperatureHysteresis = f
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_METRICS_TEMPERATURE_HYSTERESIS=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_METRICS_EFFICIENCY_CURVE"); v != "" {
+		cfg.Metrics.EfficiencyCurve = make(map[string]float64)
+		for load, val := range splitMap(v) {
+			if f, err := strconv.ParseFloat(val, 64); err == nil {
+				cfg.Metrics.EfficiencyCurve[load] = f
+			} else {
+				log.Printf("config: ignoring invalid UPS_MQTT_METRICS_EFFICIENCY_CURVE point %s=%q", load, val)
+			}
+		}
+	}
+	if v := env.get("UPS_MQTT_NOTIFICATIONS_ENABLED"); v != "" {
+		cfg.Notifications.Enabled = v == "true" || v == "1"
+	}
+	if v := env.get("UPS_MQTT_NOTIFICATIONS_QUIET_HOURS"); v != "" {
+		cfg.Notifications.QuietHours = v
+	}
+	if v := env.get("UPS_MQTT_SUMMARY_TIME"); v != "" {
+		cfg.Summary.Time = v
+	}
+	if v := env.get("UPS_MQTT_SUMMARY_NOTIFY"); v != "" {
+		cfg.Summary.Notify = v == "true" || v == "1"
+	}
+	if v := env.get("UPS_MQTT_OUTAGES_DIR"); v != "" {
+		cfg.Outages.Dir = v
+	}
+	if v := env.get("UPS_MQTT_CHECKPOINT_DIR"); v != "" {
+		cfg.Checkpoint.Dir = v
+	}
+	if v := env.get("UPS_MQTT_UPDATE_CHECK_ENABLED"); v != "" {
+		cfg.UpdateCheck.Enabled = v == "true" || v == "1"
+	}
+	if v := env.get("UPS_MQTT_UPDATE_CHECK_URL"); v != "" {
+		cfg.UpdateCheck.URL = v
+	}
+	if v := env.get("UPS_MQTT_UPDATE_CHECK_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.UpdateCheck.Interval = Duration{d}
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_UPDATE_CHECK_INTERVAL=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_LOGGING_SUPPRESS_REPEATS"); v != "" {
+		cfg.Logging.SuppressRepeats = v == "true" || v == "1"
+	}
+	if v := env.get("UPS_MQTT_DRILL_ENABLED"); v != "" {
+		cfg.Drill.Enabled = v == "true" || v == "1"
+	}
+	if v := env.get("UPS_MQTT_DRILL_STEP"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.Drill.Step = Duration{d}
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_DRILL_STEP=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_LOW_POWER_ENABLED"); v != "" {
+		cfg.LowPower.Enabled = v == "true" || v == "1"
+	}
+	if v := env.get("UPS_MQTT_LOW_POWER_POLL_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.LowPower.PollInterval = Duration{d}
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_LOW_POWER_POLL_INTERVAL=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_LOW_POWER_VARIABLES_EVERY"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.LowPower.VariablesEvery = n
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_LOW_POWER_VARIABLES_EVERY=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_LOW_POWER_COMPUTED_EVERY"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.LowPower.ComputedEvery = n
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_LOW_POWER_COMPUTED_EVERY=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_NOTIFICATIONS_LANGUAGE"); v != "" {
+		cfg.Notifications.Language = v
+	}
+	if v := env.get("UPS_MQTT_NOTIFICATIONS_MUTE_BEEPER"); v != "" {
+		cfg.Notifications.MuteBeeper = v == "true" || v == "1"
+	}
+	if v := env.get("UPS_MQTT_WAKE_ON_LAN_TARGETS"); v != "" {
+		cfg.WakeOnLAN.Targets = splitList(v)
+	}
+	if v := env.get("UPS_MQTT_WAKE_ON_LAN_BROADCAST"); v != "" {
+		cfg.WakeOnLAN.Broadcast = v
+	}
+	if v := env.get("UPS_MQTT_WAKE_ON_LAN_SETTLE"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.WakeOnLAN.Settle = Duration{d}
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_WAKE_ON_LAN_SETTLE=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_WAKE_ON_LAN_ALWAYS"); v != "" {
+		cfg.WakeOnLAN.Always = v == "true" || v == "1"
+	}
+	if v := env.get("UPS_MQTT_PUSHGATEWAY_URL"); v != "" {
+		cfg.Pushgateway.URL = v
+	}
+	if v := env.get("UPS_MQTT_PUSHGATEWAY_JOB"); v != "" {
+		cfg.Pushgateway.Job = v
+	}
+	if v := env.get("UPS_MQTT_PROMETHEUS_TEXTFILE_DIR"); v != "" {
+		cfg.Prometheus.TextfileDir = v
+	}
+	if v := env.get("UPS_MQTT_GRAFANA_URL"); v != "" {
+		cfg.Grafana.URL = v
+	}
+	if v := env.get("UPS_MQTT_GRAFANA_TOKEN"); v != "" {
+		cfg.Grafana.Token = v
+	}
+	if v := env.get("UPS_MQTT_GRAFANA_STREAM_ID"); v != "" {
+		cfg.Grafana.StreamID = v
+	}
+	if v := env.get("UPS_MQTT_DIAGNOSTICS_RAW_NUT"); v != "" {
+		cfg.Diagnostics.RawNUT = v == "true" || v == "1"
+	}
+	if v := env.get("UPS_MQTT_DIAGNOSTICS_SNAPSHOT_FILE"); v != "" {
+		cfg.Diagnostics.SnapshotFile = v
+	}
+	if v := env.get("UPS_MQTT_DIAGNOSTICS_RECORD_FILE"); v != "" {
+		cfg.Diagnostics.RecordFile = v
+	}
+	if v := env.get("UPS_MQTT_DIAGNOSTICS_AUDIT_LOG"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.Diagnostics.AuditLog = n
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_DIAGNOSTICS_AUDIT_LOG=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_DIAGNOSTICS_POLL_TIMINGS"); v != "" {
+		cfg.Diagnostics.PollTimings = v == "true" || v == "1"
+	}
+	if v := env.get("UPS_MQTT_DIAGNOSTICS_TELEMETRY"); v != "" {
+		cfg.Diagnostics.Telemetry = v == "true" || v == "1"
+	}
+	if v := env.get("UPS_MQTT_LABELS"); v != "" {
+		cfg.Labels = splitMap(v)
+	}
+	if v := env.get("UPS_MQTT_COMMANDS_ENABLED"); v != "" {
+		cfg.Commands.Enabled = v == "true" || v == "1"
+	}
+	if v := env.get("UPS_MQTT_COMMANDS_ALLOW"); v != "" {
+		cfg.Commands.Allow = splitList(v)
+	}
+	if v := env.get("UPS_MQTT_LOW_BATTERY_THRESHOLDS"); v != "" {
+		cfg.LowBattery.Thresholds = v == "true" || v == "1"
+	}
+	if v := env.get("UPS_MQTT_LOW_BATTERY_CHARGE"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			cfg.LowBattery.Charge = f
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_LOW_BATTERY_CHARGE=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_LOW_BATTERY_RUNTIME"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.LowBattery.Runtime = Duration{d}
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_LOW_BATTERY_RUNTIME=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_HOOK_SCRIPT"); v != "" {
+		cfg.Hook.Script = v
+	}
+	if v := env.get("UPS_MQTT_HOOK_COMMAND"); v != "" {
+		cfg.Hook.Command = v
+	}
+	if v := env.get("UPS_MQTT_HOOK_ARGS"); v != "" {
+		cfg.Hook.Args = splitList(v)
+	}
+	if v := env.get("UPS_MQTT_HOOK_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.Hook.Timeout = Duration{d}
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_HOOK_TIMEOUT=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_HOOK_PLUGIN"); v != "" {
+		cfg.Hook.Plugin = v == "true" || v == "1"
+	}
+	if v := env.get("UPS_MQTT_HOOKS_ON_BATTERY"); v != "" {
+		cfg.Hooks.OnBattery = v
+	}
+	if v := env.get("UPS_MQTT_HOOKS_POWER_RESTORED"); v != "" {
+		cfg.Hooks.PowerRestored = v
+	}
+	if v := env.get("UPS_MQTT_HOOKS_LOW_BATTERY"); v != "" {
+		cfg.Hooks.LowBattery = v
+	}
+	if v := env.get("UPS_MQTT_HOOKS_POLL_FAILURE"); v != "" {
+		cfg.Hooks.PollFailure = v
+	}
+	if v := env.get("UPS_MQTT_HOOKS_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.Hooks.Timeout = Duration{d}
+		} else {
+			log.Printf("config: ignoring invalid UPS_MQTT_HOOKS_TIMEOUT=%q: %v", v, err)
+		}
+	}
+	if v := env.get("UPS_MQTT_HOMEASSISTANT_DISCOVERY"); v != "" {
+		cfg.HomeAssistant.Discovery = v == "true" || v == "1"
+	}
+	if v := env.get("UPS_MQTT_HOMEASSISTANT_DISCOVERY_PREFIX"); v != "" {
+		cfg.HomeAssistant.DiscoveryPrefix = v
+	}
+	return env.err
+}
+
+// splitList parses a comma-separated environment value into its non-empty,
+// whitespace-trimmed elements.
+func splitList(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// splitMap parses a comma-separated list of key=value pairs, as used for
+// map-valued environment overrides.  Elements without "=" are ignored.
+func splitMap(v string) map[string]string {
+	out := make(map[string]string)
+	for _, kv := range splitList(v) {
+		k, val, ok := strings.Cut(kv, "=")
+		if !ok {
+			log.Printf("config: ignoring malformed key=value element %q", kv)
+			continue
+		}
+		out[strings.TrimSpace(k)] = strings.TrimSpace(val)
 	}
+	return out
 }
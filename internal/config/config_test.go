@@ -1,7 +1,12 @@
 package config_test
 
 import (
+	"bytes"
 	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -68,7 +73,7 @@ func TestLoad_MalformedFile(t *testing.T) {
 	}
 	defer os.Remove(f.Name())
 	f.WriteString("this is not valid toml ][") //nolint:errcheck
-	f.Close()                                   //nolint:errcheck
+	f.Close()                                  //nolint:errcheck
 
 	_, err = config.Load(f.Name())
 	if err == nil {
@@ -89,6 +94,41 @@ func TestLoad_EnvOverride_Host(t *testing.T) {
 	}
 }
 
+// TestLoad_EnvOverride_Hosts verifies that UPS_MQTT_NUT_HOSTS sets the
+// server list, which then replaces nut.host.
+func TestLoad_EnvOverride_Hosts(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if got := cfg.NUT.Servers(); len(got) != 1 || got[0] != "localhost" {
+		t.Errorf("Servers() = %q, want [localhost]", got)
+	}
+	t.Setenv("UPS_MQTT_NUT_HOSTS", "ups1:3493, ups2:3493")
+	if cfg, err = config.Load(); err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if got := cfg.NUT.Servers(); len(got) != 2 || got[0] != "ups1:3493" || got[1] != "ups2:3493" {
+		t.Errorf("Servers() = %q, want [ups1:3493 ups2:3493]", got)
+	}
+}
+
+func TestLoad_EnvOverride_Brokers(t *testing.T) {
+	t.Setenv("UPS_MQTT_MQTT_BROKERS", "tcp://a:1883, ssl://b:8883")
+	t.Setenv("UPS_MQTT_MQTT_BROKER_MODE", "fanout")
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if got := cfg.MQTT.BrokerList(); !slices.Equal(got, []string{"tcp://a:1883", "ssl://b:8883"}) || cfg.MQTT.BrokerMode != "fanout" {
+		t.Errorf("BrokerList() = %q, mode %q", got, cfg.MQTT.BrokerMode)
+	}
+	t.Setenv("UPS_MQTT_MQTT_BROKER_MODE", "roundrobin")
+	if _, err := config.Load(); err == nil || !strings.Contains(err.Error(), "mqtt.broker_mode") {
+		t.Errorf("err = %v, want an invalid broker_mode rejected", err)
+	}
+}
+
 // TestLoad_EnvOverride_Port verifies that UPS_MQTT_NUT_PORT is applied.
 func TestLoad_EnvOverride_Port(t *testing.T) {
 	t.Setenv("UPS_MQTT_NUT_PORT", "3494")
@@ -253,7 +293,7 @@ func TestLoad_EnvOverride_NUTCredentials(t *testing.T) {
 }
 
 // TestLoad_EnvOverride_MQTTFields verifies Broker, Username, Password,
-// ClientID, TopicPrefix, and TLSCACert.
+// ClientID, TopicPrefix and the TLS settings.
 func TestLoad_EnvOverride_MQTTFields(t *testing.T) {
 	t.Setenv("UPS_MQTT_MQTT_BROKER", "ssl://mybroker:8883")
 	t.Setenv("UPS_MQTT_MQTT_USERNAME", "mqttuser")
@@ -261,6 +301,8 @@ func TestLoad_EnvOverride_MQTTFields(t *testing.T) {
 	t.Setenv("UPS_MQTT_MQTT_CLIENT_ID", "my-client")
 	t.Setenv("UPS_MQTT_MQTT_TOPIC_PREFIX", "home/ups")
 	t.Setenv("UPS_MQTT_MQTT_TLS_CA_CERT", "/etc/ssl/ca.pem")
+	t.Setenv("UPS_MQTT_MQTT_TLS_INSECURE", "true")
+	t.Setenv("UPS_MQTT_MQTT_TLS_SERVER_NAME", "broker.lan")
 	cfg, err := config.Load()
 	if err != nil {
 		t.Fatalf("Load() error: %v", err)
@@ -283,6 +325,9 @@ func TestLoad_EnvOverride_MQTTFields(t *testing.T) {
 	if cfg.MQTT.TLSCACert != "/etc/ssl/ca.pem" {
 		t.Errorf("MQTT.TLSCACert = %q, want /etc/ssl/ca.pem", cfg.MQTT.TLSCACert)
 	}
+	if !cfg.MQTT.TLSInsecure || cfg.MQTT.TLSServerName != "broker.lan" {
+		t.Errorf("MQTT.TLSInsecure = %v, TLSServerName = %q", cfg.MQTT.TLSInsecure, cfg.MQTT.TLSServerName)
+	}
 }
 
 // TestLoad_EnvOverride_Retained tests both truthy and falsy values.
@@ -342,7 +387,7 @@ func TestLoad_Label_FromTOML(t *testing.T) {
 	}
 	defer os.Remove(f.Name())
 	f.WriteString("[nut]\nups_name = \"apc\"\nlabel = \"office-ups\"\n") //nolint:errcheck
-	f.Close()                                                              //nolint:errcheck
+	f.Close()                                                            //nolint:errcheck
 
 	cfg, err := config.Load(f.Name())
 	if err != nil {
@@ -380,3 +425,1870 @@ func TestEffectiveLabel_FallsBackToUPSName(t *testing.T) {
 		t.Errorf("EffectiveLabel() = %q, want %q", got, "apc")
 	}
 }
+
+// TestLoad_LastChanged_FromTOML verifies that last_changed is parsed as a list.
+func TestLoad_LastChanged_FromTOML(t *testing.T) {
+	f, err := os.CreateTemp("", "ups-mqtt-*.toml")
+	if err != nil {
+		t.Fatalf("creating temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("[mqtt]\nlast_changed = [\"ups.status\", \"battery.*\"]\n") //nolint:errcheck
+	f.Close()                                                                 //nolint:errcheck
+
+	cfg, err := config.Load(f.Name())
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if len(cfg.MQTT.LastChanged) != 2 || cfg.MQTT.LastChanged[1] != "battery.*" {
+		t.Errorf("MQTT.LastChanged = %v, want [ups.status battery.*]", cfg.MQTT.LastChanged)
+	}
+}
+
+// TestLoad_LastChanged_EnvOverride verifies the comma-separated env form,
+// including whitespace trimming and skipping of empty elements.
+func TestLoad_LastChanged_EnvOverride(t *testing.T) {
+	t.Setenv("UPS_MQTT_MQTT_LAST_CHANGED", "ups.status, battery.charge,,")
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if len(cfg.MQTT.LastChanged) != 2 || cfg.MQTT.LastChanged[0] != "ups.status" || cfg.MQTT.LastChanged[1] != "battery.charge" {
+		t.Errorf("MQTT.LastChanged = %q, want [ups.status battery.charge]", cfg.MQTT.LastChanged)
+	}
+}
+
+// TestLoad_NonRetained_EnvOverride verifies UPS_MQTT_MQTT_NON_RETAINED.
+func TestLoad_NonRetained_EnvOverride(t *testing.T) {
+	t.Setenv("UPS_MQTT_MQTT_NON_RETAINED", "ups.test.result,ups.beeper.status")
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if len(cfg.MQTT.NonRetained) != 2 || cfg.MQTT.NonRetained[0] != "ups.test.result" {
+		t.Errorf("MQTT.NonRetained = %q, want [ups.test.result ups.beeper.status]", cfg.MQTT.NonRetained)
+	}
+}
+
+// TestLoad_IncludeExcludeVars verifies the variable filter env overrides
+// and that a malformed pattern is rejected.
+func TestLoad_IncludeExcludeVars(t *testing.T) {
+	t.Setenv("UPS_MQTT_MQTT_INCLUDE_VARS", "ups.*,battery.*")
+	t.Setenv("UPS_MQTT_MQTT_EXCLUDE_VARS", "battery.date")
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if len(cfg.MQTT.IncludeVars) != 2 || cfg.MQTT.IncludeVars[1] != "battery.*" || len(cfg.MQTT.ExcludeVars) != 1 {
+		t.Errorf("MQTT = %q, %q; want [ups.* battery.*], [battery.date]", cfg.MQTT.IncludeVars, cfg.MQTT.ExcludeVars)
+	}
+
+	t.Setenv("UPS_MQTT_MQTT_EXCLUDE_VARS", "driver.[")
+	if _, err := config.Load(); err == nil {
+		t.Error("expected error for a malformed exclude_vars pattern")
+	}
+}
+
+// TestLoad_NamespacePrefixes_FromTOML verifies the namespace → prefix table.
+func TestLoad_NamespacePrefixes_FromTOML(t *testing.T) {
+	f, err := os.CreateTemp("", "ups-mqtt-*.toml")
+	if err != nil {
+		t.Fatalf("creating temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`
+[mqtt.namespace_prefixes]
+battery          = "power/ups1/battery"
+"driver.version" = "infra/nut/ups1/version"
+`) //nolint:errcheck
+	f.Close() //nolint:errcheck
+
+	cfg, err := config.Load(f.Name())
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if got := cfg.MQTT.NamespacePrefixes["battery"]; got != "power/ups1/battery" {
+		t.Errorf(`NamespacePrefixes["battery"] = %q`, got)
+	}
+	if got := cfg.MQTT.NamespacePrefixes["driver.version"]; got != "infra/nut/ups1/version" {
+		t.Errorf(`NamespacePrefixes["driver.version"] = %q`, got)
+	}
+}
+
+// TestLoad_NamespacePrefixes_EnvOverride verifies the key=value list form and
+// that malformed elements are skipped.
+func TestLoad_NamespacePrefixes_EnvOverride(t *testing.T) {
+	t.Setenv("UPS_MQTT_MQTT_NAMESPACE_PREFIXES", "battery=power/ups1/battery, driver = infra/nut/ups1,bogus")
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	want := map[string]string{"battery": "power/ups1/battery", "driver": "infra/nut/ups1"}
+	if len(cfg.MQTT.NamespacePrefixes) != len(want) {
+		t.Fatalf("NamespacePrefixes = %v, want %v", cfg.MQTT.NamespacePrefixes, want)
+	}
+	for k, v := range want {
+		if cfg.MQTT.NamespacePrefixes[k] != v {
+			t.Errorf("NamespacePrefixes[%q] = %q, want %q", k, cfg.MQTT.NamespacePrefixes[k], v)
+		}
+	}
+}
+
+// TestLoad_Filter_Defaults verifies the plausibility filter is off by default
+// and defaults to drop mode.
+func TestLoad_Filter_Defaults(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Filter.Enabled {
+		t.Error("Filter.Enabled should default to false")
+	}
+	if cfg.Filter.Mode != "drop" {
+		t.Errorf("Filter.Mode = %q, want drop", cfg.Filter.Mode)
+	}
+}
+
+// TestLoad_Filter_FromTOML verifies bounds tables with optional min/max.
+func TestLoad_Filter_FromTOML(t *testing.T) {
+	f, err := os.CreateTemp("", "ups-mqtt-*.toml")
+	if err != nil {
+		t.Fatalf("creating temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`
+[filter]
+enabled = true
+mode    = "clamp"
+
+[filter.bounds."input.frequency"]
+min = 45
+max = 65
+`) //nolint:errcheck
+	f.Close() //nolint:errcheck
+
+	cfg, err := config.Load(f.Name())
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if !cfg.Filter.Enabled || cfg.Filter.Mode != "clamp" {
+		t.Errorf("Filter = %+v, want enabled clamp", cfg.Filter)
+	}
+	b := cfg.Filter.Bounds["input.frequency"]
+	if b.Min == nil || *b.Min != 45 || b.Max == nil || *b.Max != 65 {
+		t.Errorf("Bounds[input.frequency] = %+v, want 45..65", b)
+	}
+}
+
+// TestLoad_Filter_EnvOverride verifies UPS_MQTT_FILTER_ENABLED and _MODE.
+func TestLoad_Filter_EnvOverride(t *testing.T) {
+	t.Setenv("UPS_MQTT_FILTER_ENABLED", "1")
+	t.Setenv("UPS_MQTT_FILTER_MODE", "clamp")
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if !cfg.Filter.Enabled || cfg.Filter.Mode != "clamp" {
+		t.Errorf("Filter = %+v, want enabled clamp", cfg.Filter)
+	}
+}
+
+// TestLoad_Filter_BadMode verifies that an unknown filter mode is rejected.
+func TestLoad_Filter_BadMode(t *testing.T) {
+	t.Setenv("UPS_MQTT_FILTER_MODE", "ignore")
+	if _, err := config.Load(); err == nil {
+		t.Fatal("Load() should reject an unknown filter.mode")
+	}
+}
+
+// TestLoad_HoldMissing verifies the default (disabled) and env override.
+func TestLoad_HoldMissing(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.NUT.HoldMissing.Duration != 0 {
+		t.Errorf("NUT.HoldMissing = %v, want 0 (disabled) by default", cfg.NUT.HoldMissing.Duration)
+	}
+
+	t.Setenv("UPS_MQTT_NUT_HOLD_MISSING", "2m")
+	if cfg, err = config.Load(); err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.NUT.HoldMissing.Duration != 2*time.Minute {
+		t.Errorf("NUT.HoldMissing = %v, want 2m", cfg.NUT.HoldMissing.Duration)
+	}
+
+	t.Setenv("UPS_MQTT_NUT_HOLD_MISSING", "soon")
+	if cfg, err = config.Load(); err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.NUT.HoldMissing.Duration != 0 {
+		t.Errorf("NUT.HoldMissing = %v with bad env, want default 0", cfg.NUT.HoldMissing.Duration)
+	}
+}
+
+// TestLoad_Defaults_FromTOML verifies that [nut.defaults] accepts strings,
+// integers, floats and booleans, normalising them to NUT's string form.
+func TestLoad_Defaults_FromTOML(t *testing.T) {
+	f, err := os.CreateTemp("", "ups-mqtt-*.toml")
+	if err != nil {
+		t.Fatalf("creating temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`
+[nut.defaults]
+"ups.realpower.nominal"   = 900
+"input.voltage.nominal"   = "230"
+"battery.voltage.nominal" = 24.5
+"ups.beeper.status"       = false
+`) //nolint:errcheck
+	f.Close() //nolint:errcheck
+
+	cfg, err := config.Load(f.Name())
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	want := map[string]config.Value{
+		"ups.realpower.nominal":   "900",
+		"input.voltage.nominal":   "230",
+		"battery.voltage.nominal": "24.5",
+		"ups.beeper.status":       "false",
+	}
+	for k, v := range want {
+		if cfg.NUT.Defaults[k] != v {
+			t.Errorf("Defaults[%q] = %q, want %q", k, cfg.NUT.Defaults[k], v)
+		}
+	}
+}
+
+// TestLoad_Defaults_UnsupportedType verifies that a table value is rejected.
+func TestLoad_Defaults_UnsupportedType(t *testing.T) {
+	f, err := os.CreateTemp("", "ups-mqtt-*.toml")
+	if err != nil {
+		t.Fatalf("creating temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("[nut.defaults]\n\"ups.load\" = [1, 2]\n") //nolint:errcheck
+	f.Close()                                                //nolint:errcheck
+
+	if _, err := config.Load(f.Name()); err == nil {
+		t.Fatal("Load() should reject an array as a default value")
+	}
+}
+
+// TestLoad_Defaults_EnvOverride verifies UPS_MQTT_NUT_DEFAULTS.
+func TestLoad_Defaults_EnvOverride(t *testing.T) {
+	t.Setenv("UPS_MQTT_NUT_DEFAULTS", "ups.realpower.nominal=900")
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.NUT.Defaults["ups.realpower.nominal"] != "900" {
+		t.Errorf("Defaults = %v, want ups.realpower.nominal=900", cfg.NUT.Defaults)
+	}
+}
+
+// TestLoad_HomeAssistant_Defaults verifies discovery is off with the standard prefix.
+func TestLoad_HomeAssistant_Defaults(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.HomeAssistant.Discovery {
+		t.Error("HomeAssistant.Discovery should default to false")
+	}
+	if cfg.HomeAssistant.DiscoveryPrefix != "homeassistant" {
+		t.Errorf("DiscoveryPrefix = %q, want homeassistant", cfg.HomeAssistant.DiscoveryPrefix)
+	}
+}
+
+// TestLoad_HomeAssistant_EnvOverride verifies the UPS_MQTT_HOMEASSISTANT_* variables.
+func TestLoad_HomeAssistant_EnvOverride(t *testing.T) {
+	t.Setenv("UPS_MQTT_HOMEASSISTANT_DISCOVERY", "true")
+	t.Setenv("UPS_MQTT_HOMEASSISTANT_DISCOVERY_PREFIX", "ha")
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if !cfg.HomeAssistant.Discovery || cfg.HomeAssistant.DiscoveryPrefix != "ha" {
+		t.Errorf("HomeAssistant = %+v, want discovery on with prefix ha", cfg.HomeAssistant)
+	}
+}
+
+// TestLoad_Diff_EnvOverride verifies UPS_MQTT_MQTT_DIFF.
+func TestLoad_Diff_EnvOverride(t *testing.T) {
+	t.Setenv("UPS_MQTT_MQTT_DIFF", "true")
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if !cfg.MQTT.Diff {
+		t.Error("MQTT.Diff should be true")
+	}
+}
+
+// TestMirrorRoot covers the defaulting of the migration layout root.
+func TestMirrorRoot(t *testing.T) {
+	tests := []struct {
+		name      string
+		migration config.MigrationConfig
+		want      string
+	}{
+		{"unset", config.MigrationConfig{}, ""},
+		{"new prefix", config.MigrationConfig{TopicPrefix: "home/power"}, "home/power/office-ups"},
+		{"new label", config.MigrationConfig{Label: "rack-ups"}, "ups/rack-ups"},
+		{"both", config.MigrationConfig{TopicPrefix: "home", Label: "rack-ups"}, "home/rack-ups"},
+		{"same as current", config.MigrationConfig{TopicPrefix: "ups", Label: "office-ups"}, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &config.Config{
+				NUT:       config.NUTConfig{UPSName: "cyberpower", Label: "office-ups"},
+				MQTT:      config.MQTTConfig{TopicPrefix: "ups"},
+				Migration: tc.migration,
+			}
+			if got := cfg.MirrorRoot(); got != tc.want {
+				t.Errorf("MirrorRoot() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestLoad_Migration_EnvOverride verifies the UPS_MQTT_MIGRATION_* variables.
+func TestLoad_Migration_EnvOverride(t *testing.T) {
+	t.Setenv("UPS_MQTT_MIGRATION_TOPIC_PREFIX", "home/power")
+	t.Setenv("UPS_MQTT_MIGRATION_LABEL", "rack-ups")
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Migration.TopicPrefix != "home/power" || cfg.Migration.Label != "rack-ups" {
+		t.Errorf("Migration = %+v", cfg.Migration)
+	}
+}
+
+// TestLoad_Pushgateway verifies the default job and the env overrides.
+func TestLoad_Pushgateway(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Pushgateway.URL != "" || cfg.Pushgateway.Job != "ups-mqtt" {
+		t.Errorf("Pushgateway defaults = %+v", cfg.Pushgateway)
+	}
+
+	t.Setenv("UPS_MQTT_PUSHGATEWAY_URL", "http://pushgw:9091")
+	t.Setenv("UPS_MQTT_PUSHGATEWAY_JOB", "ups")
+	cfg, err = config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Pushgateway.URL != "http://pushgw:9091" || cfg.Pushgateway.Job != "ups" {
+		t.Errorf("Pushgateway = %+v", cfg.Pushgateway)
+	}
+}
+
+// TestLoad_Prometheus verifies the textfile directory is off by default and
+// settable from the environment.
+func TestLoad_Prometheus(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Prometheus.TextfileDir != "" {
+		t.Errorf("Prometheus.TextfileDir = %q, want empty by default", cfg.Prometheus.TextfileDir)
+	}
+	t.Setenv("UPS_MQTT_PROMETHEUS_TEXTFILE_DIR", "/var/lib/node_exporter/textfile")
+	if cfg, err = config.Load(); err != nil || cfg.Prometheus.TextfileDir != "/var/lib/node_exporter/textfile" {
+		t.Errorf("Prometheus.TextfileDir = %q (err %v)", cfg.Prometheus.TextfileDir, err)
+	}
+}
+
+// TestLoad_SelfTest verifies the self-test and ACL check defaults and env
+// overrides.
+func TestLoad_SelfTest(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.MQTT.SelfTest || cfg.MQTT.SelfTestTimeout.Duration != 5*time.Second {
+		t.Errorf("defaults: SelfTest=%v timeout=%s, want false 5s", cfg.MQTT.SelfTest, cfg.MQTT.SelfTestTimeout)
+	}
+
+	if cfg.MQTT.ACLCheck {
+		t.Error("ACLCheck should default to false")
+	}
+
+	t.Setenv("UPS_MQTT_MQTT_SELF_TEST", "1")
+	t.Setenv("UPS_MQTT_MQTT_SELF_TEST_TIMEOUT", "2s")
+	t.Setenv("UPS_MQTT_MQTT_ACL_CHECK", "true")
+	cfg, err = config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if !cfg.MQTT.SelfTest || cfg.MQTT.SelfTestTimeout.Duration != 2*time.Second {
+		t.Errorf("env: SelfTest=%v timeout=%s, want true 2s", cfg.MQTT.SelfTest, cfg.MQTT.SelfTestTimeout)
+	}
+	if !cfg.MQTT.ACLCheck {
+		t.Error("env: ACLCheck should be true")
+	}
+}
+
+// TestLoad_SelfTest_InvalidTimeout verifies a bad timeout is ignored.
+func TestLoad_SelfTest_InvalidTimeout(t *testing.T) {
+	t.Setenv("UPS_MQTT_MQTT_SELF_TEST_TIMEOUT", "soon")
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.MQTT.SelfTestTimeout.Duration != 5*time.Second {
+		t.Errorf("SelfTestTimeout = %s, want default 5s", cfg.MQTT.SelfTestTimeout)
+	}
+}
+
+// TestLoad_Diagnostics verifies raw NUT passthrough and the snapshot file
+// are off unless enabled.
+func TestLoad_Diagnostics(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Diagnostics.RawNUT {
+		t.Error("Diagnostics.RawNUT should default to false")
+	}
+	t.Setenv("UPS_MQTT_DIAGNOSTICS_RAW_NUT", "true")
+	if cfg, err = config.Load(); err != nil || !cfg.Diagnostics.RawNUT {
+		t.Errorf("Diagnostics.RawNUT = %v (err %v), want true", cfg.Diagnostics.RawNUT, err)
+	}
+	if cfg.Diagnostics.SnapshotFile != "" {
+		t.Errorf("Diagnostics.SnapshotFile = %q, want empty by default", cfg.Diagnostics.SnapshotFile)
+	}
+	t.Setenv("UPS_MQTT_DIAGNOSTICS_SNAPSHOT_FILE", "/run/ups-mqtt/last-poll.json")
+	if cfg, err = config.Load(); err != nil || cfg.Diagnostics.SnapshotFile != "/run/ups-mqtt/last-poll.json" {
+		t.Errorf("Diagnostics.SnapshotFile = %q (err %v)", cfg.Diagnostics.SnapshotFile, err)
+	}
+	if cfg.Diagnostics.RecordFile != "" {
+		t.Errorf("Diagnostics.RecordFile = %q, want empty by default", cfg.Diagnostics.RecordFile)
+	}
+	t.Setenv("UPS_MQTT_DIAGNOSTICS_RECORD_FILE", "polls.jsonl")
+	if cfg, err = config.Load(); err != nil || cfg.Diagnostics.RecordFile != "polls.jsonl" {
+		t.Errorf("Diagnostics.RecordFile = %q (err %v)", cfg.Diagnostics.RecordFile, err)
+	}
+	if cfg.Diagnostics.AuditLog != 0 {
+		t.Errorf("Diagnostics.AuditLog = %d, want 0 by default", cfg.Diagnostics.AuditLog)
+	}
+	t.Setenv("UPS_MQTT_DIAGNOSTICS_AUDIT_LOG", "50")
+	if cfg, err = config.Load(); err != nil || cfg.Diagnostics.AuditLog != 50 {
+		t.Errorf("Diagnostics.AuditLog = %d (err %v), want 50", cfg.Diagnostics.AuditLog, err)
+	}
+	t.Setenv("UPS_MQTT_DIAGNOSTICS_AUDIT_LOG", "0")
+	t.Setenv("UPS_MQTT_DIAGNOSTICS_POLL_TIMINGS", "true")
+	if cfg, err = config.Load(); err != nil || !cfg.Diagnostics.PollTimings {
+		t.Errorf("Diagnostics.PollTimings = %v (err %v), want true", cfg.Diagnostics.PollTimings, err)
+	}
+	t.Setenv("UPS_MQTT_DIAGNOSTICS_AUDIT_LOG", "-1")
+	if _, err = config.Load(); err == nil {
+		t.Error("expected error for a negative audit_log")
+	}
+}
+
+// TestLoad_PollVars_EnvOverride verifies UPS_MQTT_NUT_POLL_VARS.
+func TestLoad_PollVars_EnvOverride(t *testing.T) {
+	t.Setenv("UPS_MQTT_NUT_POLL_VARS", "ups.status, battery.charge")
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if len(cfg.NUT.PollVars) != 2 || cfg.NUT.PollVars[1] != "battery.charge" {
+		t.Errorf("PollVars = %v", cfg.NUT.PollVars)
+	}
+}
+
+// TestLoad_Clients_EnvOverride verifies UPS_MQTT_NUT_CLIENTS_INTERVAL and
+// UPS_MQTT_NUT_EXPECTED_CLIENTS.
+func TestLoad_Clients_EnvOverride(t *testing.T) {
+	t.Setenv("UPS_MQTT_NUT_CLIENTS_INTERVAL", "5m")
+	t.Setenv("UPS_MQTT_NUT_EXPECTED_CLIENTS", "192.168.1.10, 192.168.1.11")
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.NUT.ClientsInterval.Duration != 5*time.Minute {
+		t.Errorf("ClientsInterval = %s, want 5m", cfg.NUT.ClientsInterval)
+	}
+	if len(cfg.NUT.ExpectedClients) != 2 || cfg.NUT.ExpectedClients[1] != "192.168.1.11" {
+		t.Errorf("ExpectedClients = %v", cfg.NUT.ExpectedClients)
+	}
+}
+
+// TestLoad_Clients_InvalidInterval verifies a bad interval leaves clients off.
+func TestLoad_Clients_InvalidInterval(t *testing.T) {
+	t.Setenv("UPS_MQTT_NUT_CLIENTS_INTERVAL", "often")
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.NUT.ClientsInterval.Duration != 0 {
+		t.Errorf("ClientsInterval = %s, want 0", cfg.NUT.ClientsInterval)
+	}
+}
+
+// TestLoad_Alerts_FromTOML verifies [[alerts]] array tables.
+func TestLoad_Alerts_FromTOML(t *testing.T) {
+	f, err := os.CreateTemp("", "ups-mqtt-*.toml")
+	if err != nil {
+		t.Fatalf("creating temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`
+[[alerts]]
+name     = "upsmon_redundancy"
+type     = "min_logins"
+min      = 2
+severity = "critical"
+`) //nolint:errcheck
+	f.Close() //nolint:errcheck
+
+	cfg, err := config.Load(f.Name())
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if len(cfg.Alerts) != 1 {
+		t.Fatalf("Alerts = %+v, want 1 rule", cfg.Alerts)
+	}
+	a := cfg.Alerts[0]
+	if a.Name != "upsmon_redundancy" || a.Type != "min_logins" || a.Severity != "critical" || a.Min == nil || *a.Min != 2 {
+		t.Errorf("Alerts[0] = %+v", a)
+	}
+}
+
+// TestLoad_ChargeRateWindow verifies the default and env override.
+func TestLoad_ChargeRateWindow(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Metrics.ChargeRateWindow.Duration != 5*time.Minute {
+		t.Errorf("ChargeRateWindow = %s, want 5m", cfg.Metrics.ChargeRateWindow)
+	}
+	t.Setenv("UPS_MQTT_METRICS_CHARGE_RATE_WINDOW", "0s")
+	if cfg, _ = config.Load(); cfg.Metrics.ChargeRateWindow.Duration != 0 {
+		t.Errorf("ChargeRateWindow = %s, want 0s", cfg.Metrics.ChargeRateWindow)
+	}
+	t.Setenv("UPS_MQTT_METRICS_CHARGE_RATE_WINDOW", "a while")
+	if cfg, _ = config.Load(); cfg.Metrics.ChargeRateWindow.Duration != 5*time.Minute {
+		t.Errorf("invalid value should keep the default, got %s", cfg.Metrics.ChargeRateWindow)
+	}
+}
+
+// TestLoad_ChargerStateHold verifies the default and env override.
+func TestLoad_ChargerStateHold(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Metrics.ChargerStateHold.Duration != time.Minute {
+		t.Errorf("ChargerStateHold = %s, want 1m", cfg.Metrics.ChargerStateHold)
+	}
+	t.Setenv("UPS_MQTT_METRICS_CHARGER_STATE_HOLD", "3m")
+	if cfg, _ = config.Load(); cfg.Metrics.ChargerStateHold.Duration != 3*time.Minute {
+		t.Errorf("ChargerStateHold = %s, want 3m", cfg.Metrics.ChargerStateHold)
+	}
+	t.Setenv("UPS_MQTT_METRICS_CHARGER_STATE_HOLD", "soon")
+	if cfg, _ = config.Load(); cfg.Metrics.ChargerStateHold.Duration != time.Minute {
+		t.Errorf("invalid value should keep the default, got %s", cfg.Metrics.ChargerStateHold)
+	}
+}
+
+// TestLoad_RuntimeWindow verifies the default and env override.
+func TestLoad_RuntimeWindow(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Metrics.RuntimeWindow.Duration != 10*time.Minute {
+		t.Errorf("RuntimeWindow = %s, want 10m", cfg.Metrics.RuntimeWindow)
+	}
+	t.Setenv("UPS_MQTT_METRICS_RUNTIME_WINDOW", "0s")
+	if cfg, _ = config.Load(); cfg.Metrics.RuntimeWindow.Duration != 0 {
+		t.Errorf("RuntimeWindow = %s, want 0s", cfg.Metrics.RuntimeWindow)
+	}
+	t.Setenv("UPS_MQTT_METRICS_RUNTIME_WINDOW", "soon")
+	if cfg, _ = config.Load(); cfg.Metrics.RuntimeWindow.Duration != 10*time.Minute {
+		t.Errorf("invalid value should keep the default, got %s", cfg.Metrics.RuntimeWindow)
+	}
+}
+
+// TestLoad_RuntimeSmoothing verifies the default and env override.
+func TestLoad_RuntimeSmoothing(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Metrics.RuntimeSmoothing.Duration != 5*time.Minute {
+		t.Errorf("RuntimeSmoothing = %s, want 5m", cfg.Metrics.RuntimeSmoothing)
+	}
+	t.Setenv("UPS_MQTT_METRICS_RUNTIME_SMOOTHING", "0s")
+	if cfg, _ = config.Load(); cfg.Metrics.RuntimeSmoothing.Duration != 0 {
+		t.Errorf("RuntimeSmoothing = %s, want 0s", cfg.Metrics.RuntimeSmoothing)
+	}
+	t.Setenv("UPS_MQTT_METRICS_RUNTIME_SMOOTHING", "later")
+	if cfg, _ = config.Load(); cfg.Metrics.RuntimeSmoothing.Duration != 5*time.Minute {
+		t.Errorf("invalid value should keep the default, got %s", cfg.Metrics.RuntimeSmoothing)
+	}
+}
+
+// TestLoad_PublishEvery verifies the downsampling env overrides and that an
+// invalid ratio is ignored.
+func TestLoad_PublishEvery(t *testing.T) {
+	t.Setenv("UPS_MQTT_MQTT_VARIABLES_EVERY", "12")
+	t.Setenv("UPS_MQTT_MQTT_COMPUTED_EVERY", "often")
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.MQTT.VariablesEvery != 12 || cfg.MQTT.ComputedEvery != 0 {
+		t.Errorf("VariablesEvery=%d ComputedEvery=%d, want 12 0", cfg.MQTT.VariablesEvery, cfg.MQTT.ComputedEvery)
+	}
+}
+
+// TestLoad_Notifications verifies the notification env overrides.
+func TestLoad_Notifications(t *testing.T) {
+	t.Setenv("UPS_MQTT_NOTIFICATIONS_ENABLED", "true")
+	t.Setenv("UPS_MQTT_NOTIFICATIONS_QUIET_HOURS", "22:00-07:00")
+	t.Setenv("UPS_MQTT_NOTIFICATIONS_MUTE_BEEPER", "1")
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	n := cfg.Notifications
+	if !n.Enabled || n.QuietHours != "22:00-07:00" || !n.MuteBeeper {
+		t.Errorf("Notifications = %+v", n)
+	}
+}
+
+// TestLoad_Notifications_InvalidQuietHours verifies a malformed window is
+// rejected at load time rather than silently never matching.
+func TestLoad_Notifications_InvalidQuietHours(t *testing.T) {
+	t.Setenv("UPS_MQTT_NOTIFICATIONS_QUIET_HOURS", "10pm-7am")
+	if _, err := config.Load(); err == nil {
+		t.Fatal("expected error for invalid quiet_hours")
+	}
+}
+
+// TestLoad_Grafana verifies the default stream ID and the env overrides.
+func TestLoad_Grafana(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Grafana.URL != "" || cfg.Grafana.StreamID != "ups-mqtt" {
+		t.Errorf("Grafana defaults = %+v", cfg.Grafana)
+	}
+
+	t.Setenv("UPS_MQTT_GRAFANA_URL", "http://grafana:3000")
+	t.Setenv("UPS_MQTT_GRAFANA_TOKEN", "glsa_x")
+	t.Setenv("UPS_MQTT_GRAFANA_STREAM_ID", "rack")
+	cfg, err = config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Grafana.URL != "http://grafana:3000" || cfg.Grafana.Token != "glsa_x" || cfg.Grafana.StreamID != "rack" {
+		t.Errorf("Grafana = %+v", cfg.Grafana)
+	}
+}
+
+// TestLoad_ClockSkewThreshold verifies the check is off by default and that
+// an invalid UPS_MQTT_NUT_CLOCK_SKEW_THRESHOLD is ignored.
+func TestLoad_ClockSkewThreshold(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.NUT.ClockSkewThreshold.Duration != 0 {
+		t.Errorf("ClockSkewThreshold = %s, want 0", cfg.NUT.ClockSkewThreshold)
+	}
+
+	t.Setenv("UPS_MQTT_NUT_CLOCK_SKEW_THRESHOLD", "30s")
+	if cfg, err = config.Load(); err != nil || cfg.NUT.ClockSkewThreshold.Duration != 30*time.Second {
+		t.Errorf("ClockSkewThreshold = %s (err %v), want 30s", cfg.NUT.ClockSkewThreshold, err)
+	}
+
+	t.Setenv("UPS_MQTT_NUT_CLOCK_SKEW_THRESHOLD", "a bit")
+	if cfg, err = config.Load(); err != nil || cfg.NUT.ClockSkewThreshold.Duration != 0 {
+		t.Errorf("ClockSkewThreshold = %s (err %v), want 0", cfg.NUT.ClockSkewThreshold, err)
+	}
+}
+
+// TestLoad_Quirks_FromTOML verifies [[quirks.profiles]] and the builtin switch.
+func TestLoad_Quirks_FromTOML(t *testing.T) {
+	f, err := os.CreateTemp("", "ups-mqtt-*.toml")
+	if err != nil {
+		t.Fatalf("creating temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`
+[quirks]
+builtin = true
+
+[[quirks.profiles]]
+name                = "back-ups"
+model               = "Back-UPS ES*"
+drop                = ["battery.mfr.date"]
+scale               = { "battery.runtime" = 60 }
+defaults            = { "ups.realpower.nominal" = 405 }
+load_from_realpower = true
+
+[quirks.profiles.bounds."input.voltage"]
+min = 90
+zero_only_on_battery = true
+`) //nolint:errcheck
+	f.Close() //nolint:errcheck
+
+	cfg, err := config.Load(f.Name())
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if !cfg.Quirks.Builtin || len(cfg.Quirks.Profiles) != 1 {
+		t.Fatalf("Quirks = %+v", cfg.Quirks)
+	}
+	q := cfg.Quirks.Profiles[0]
+	if q.Name != "back-ups" || q.Model != "Back-UPS ES*" || len(q.Drop) != 1 || q.Scale["battery.runtime"] != 60 ||
+		q.Defaults["ups.realpower.nominal"] != "405" || !q.LoadFromRealPower {
+		t.Errorf("Quirks.Profiles[0] = %+v", q)
+	}
+	if b := q.Bounds["input.voltage"]; b.Min == nil || *b.Min != 90 || !b.ZeroOnlyOnBattery {
+		t.Errorf("bounds = %+v", b)
+	}
+}
+
+// TestLoad_Quirks_Env verifies the builtin profiles are off by default and
+// UPS_MQTT_QUIRKS_BUILTIN enables them.
+func TestLoad_Quirks_Env(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Quirks.Builtin {
+		t.Error("Quirks.Builtin should default to false")
+	}
+	t.Setenv("UPS_MQTT_QUIRKS_BUILTIN", "1")
+	if cfg, err = config.Load(); err != nil || !cfg.Quirks.Builtin {
+		t.Errorf("Quirks.Builtin = %v (err %v), want true", cfg.Quirks.Builtin, err)
+	}
+}
+
+// TestLoad_Quirks_Invalid verifies malformed profiles are rejected at load.
+func TestLoad_Quirks_Invalid(t *testing.T) {
+	for name, body := range map[string]string{
+		"no name":     "[[quirks.profiles]]\nmodel = \"X*\"\n",
+		"no match":    "[[quirks.profiles]]\nname = \"x\"\ndrop = [\"ups.load\"]\n",
+		"bad pattern": "[[quirks.profiles]]\nname = \"x\"\nmodel = \"[\"\n",
+	} {
+		f, err := os.CreateTemp("", "ups-mqtt-*.toml")
+		if err != nil {
+			t.Fatalf("creating temp file: %v", err)
+		}
+		defer os.Remove(f.Name())
+		f.WriteString(body) //nolint:errcheck
+		f.Close()           //nolint:errcheck
+		if _, err := config.Load(f.Name()); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+// TestLoad_EfficiencyCurve verifies the TOML table, the env override and
+// validation of the points.
+func TestLoad_EfficiencyCurve(t *testing.T) {
+	f, err := os.CreateTemp("", "ups-mqtt-*.toml")
+	if err != nil {
+		t.Fatalf("creating temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`
+[metrics]
+efficiency_curve = { "10" = 80, "50" = 92.5, "100" = 95 }
+`) //nolint:errcheck
+	f.Close() //nolint:errcheck
+
+	cfg, err := config.Load(f.Name())
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if c := cfg.Metrics.EfficiencyCurve; len(c) != 3 || c["50"] != 92.5 {
+		t.Errorf("EfficiencyCurve = %v", c)
+	}
+
+	t.Setenv("UPS_MQTT_METRICS_EFFICIENCY_CURVE", "25=88, 75=high")
+	if cfg, err = config.Load(); err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if c := cfg.Metrics.EfficiencyCurve; len(c) != 1 || c["25"] != 88 {
+		t.Errorf("EfficiencyCurve = %v, want only the valid env point", c)
+	}
+
+	for _, v := range []string{"half=90", "150=90", "50=0", "50=101"} {
+		t.Setenv("UPS_MQTT_METRICS_EFFICIENCY_CURVE", v)
+		if _, err := config.Load(); err == nil {
+			t.Errorf("%s: expected validation error", v)
+		}
+	}
+}
+
+// TestLoad_PowerFactor verifies the default, the env override and validation.
+func TestLoad_PowerFactor(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Metrics.PowerFactor != 0.6 {
+		t.Errorf("PowerFactor = %v, want default 0.6", cfg.Metrics.PowerFactor)
+	}
+	t.Setenv("UPS_MQTT_METRICS_POWER_FACTOR", "0")
+	if cfg, err = config.Load(); err != nil || cfg.Metrics.PowerFactor != 0 {
+		t.Errorf("PowerFactor = %v (err %v), want 0", cfg.Metrics.PowerFactor, err)
+	}
+	t.Setenv("UPS_MQTT_METRICS_POWER_FACTOR", "unity")
+	if cfg, err = config.Load(); err != nil || cfg.Metrics.PowerFactor != 0.6 {
+		t.Errorf("PowerFactor = %v (err %v), want default kept", cfg.Metrics.PowerFactor, err)
+	}
+	t.Setenv("UPS_MQTT_METRICS_POWER_FACTOR", "1.2")
+	if _, err := config.Load(); err == nil {
+		t.Error("expected error for power_factor above 1")
+	}
+}
+
+// TestLoad_StatusDebounce verifies the default, the env override and
+// validation of nut.status_debounce.
+func TestLoad_StatusDebounce(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.NUT.StatusDebounce != 0 {
+		t.Errorf("StatusDebounce = %d, want 0", cfg.NUT.StatusDebounce)
+	}
+	t.Setenv("UPS_MQTT_NUT_STATUS_DEBOUNCE", "3")
+	if cfg, err = config.Load(); err != nil || cfg.NUT.StatusDebounce != 3 {
+		t.Errorf("StatusDebounce = %d (err %v), want 3", cfg.NUT.StatusDebounce, err)
+	}
+	t.Setenv("UPS_MQTT_NUT_STATUS_DEBOUNCE", "-2")
+	if _, err := config.Load(); err == nil {
+		t.Error("expected error for a negative status_debounce")
+	}
+}
+
+// TestLoad_Temperature verifies the defaults, the env overrides and
+// validation of the over-temperature settings.
+func TestLoad_Temperature(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Metrics.MaxTemperature != 0 || cfg.Metrics.TemperatureHysteresis != 2 {
+		t.Errorf("defaults = %v, %v; want 0, 2", cfg.Metrics.MaxTemperature, cfg.Metrics.TemperatureHysteresis)
+	}
+	t.Setenv("UPS_MQTT_METRICS_MAX_TEMPERATURE", "45")
+	t.Setenv("UPS_MQTT_METRICS_TEMPERATURE_HYSTERESIS", "3.5")
+	if cfg, err = config.Load(); err != nil || cfg.Metrics.MaxTemperature != 45 || cfg.Metrics.TemperatureHysteresis != 3.5 {
+		t.Errorf("env = %v, %v (err %v); want 45, 3.5", cfg.Metrics.MaxTemperature, cfg.Metrics.TemperatureHysteresis, err)
+	}
+	t.Setenv("UPS_MQTT_METRICS_MAX_TEMPERATURE", "hot")
+	if cfg, err = config.Load(); err != nil || cfg.Metrics.MaxTemperature != 0 {
+		t.Errorf("MaxTemperature = %v (err %v), want default kept", cfg.Metrics.MaxTemperature, err)
+	}
+	t.Setenv("UPS_MQTT_METRICS_TEMPERATURE_HYSTERESIS", "-1")
+	if _, err := config.Load(); err == nil {
+		t.Error("expected error for a negative temperature_hysteresis")
+	}
+}
+
+// TestLoad_StateSizeGuard verifies the defaults, env overrides and that an
+// unknown overflow strategy is rejected.
+func TestLoad_StateSizeGuard(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.MQTT.MaxStateBytes != 0 || cfg.MQTT.StateOverflow != "drop_driver" {
+		t.Errorf("defaults = %d, %q; want 0, drop_driver", cfg.MQTT.MaxStateBytes, cfg.MQTT.StateOverflow)
+	}
+
+	t.Setenv("UPS_MQTT_MQTT_MAX_STATE_BYTES", "65536")
+	t.Setenv("UPS_MQTT_MQTT_STATE_OVERFLOW", "split")
+	if cfg, err = config.Load(); err != nil || cfg.MQTT.MaxStateBytes != 65536 || cfg.MQTT.StateOverflow != "split" {
+		t.Errorf("MQTT = %d, %q (err %v); want 65536, split", cfg.MQTT.MaxStateBytes, cfg.MQTT.StateOverflow, err)
+	}
+
+	t.Setenv("UPS_MQTT_MQTT_MAX_STATE_BYTES", "64k")
+	if cfg, err = config.Load(); err != nil || cfg.MQTT.MaxStateBytes != 0 {
+		t.Errorf("MaxStateBytes = %d (err %v), want invalid value ignored", cfg.MQTT.MaxStateBytes, err)
+	}
+
+	t.Setenv("UPS_MQTT_MQTT_STATE_OVERFLOW", "compress")
+	if _, err := config.Load(); err == nil {
+		t.Error("expected error for unknown state_overflow")
+	}
+}
+
+// TestLoad_ByteBudget verifies the byte stats env overrides and that a
+// negative budget is rejected.
+func TestLoad_ByteBudget(t *testing.T) {
+	t.Setenv("UPS_MQTT_MQTT_BYTE_STATS", "true")
+	t.Setenv("UPS_MQTT_MQTT_BYTE_BUDGET", "65536")
+	cfg, err := config.Load()
+	if err != nil || !cfg.MQTT.ByteStats || cfg.MQTT.ByteBudget != 65536 {
+		t.Errorf("MQTT = %v, %d (err %v); want true, 65536", cfg.MQTT.ByteStats, cfg.MQTT.ByteBudget, err)
+	}
+
+	t.Setenv("UPS_MQTT_MQTT_BYTE_BUDGET", "-1")
+	if _, err := config.Load(); err == nil {
+		t.Error("expected error for a negative byte_budget")
+	}
+}
+
+// TestLoad_Buffer verifies the publish buffer env overrides and that a
+// buffer file needs a size.
+func TestLoad_Buffer(t *testing.T) {
+	t.Setenv("UPS_MQTT_MQTT_BUFFER_SIZE", "1000")
+	t.Setenv("UPS_MQTT_MQTT_BUFFER_FILE", "/var/lib/ups-mqtt/buffer.jsonl")
+	cfg, err := config.Load()
+	if err != nil || cfg.MQTT.BufferSize != 1000 || cfg.MQTT.BufferFile != "/var/lib/ups-mqtt/buffer.jsonl" {
+		t.Errorf("MQTT = %d, %q (err %v)", cfg.MQTT.BufferSize, cfg.MQTT.BufferFile, err)
+	}
+
+	t.Setenv("UPS_MQTT_MQTT_BUFFER_SIZE", "0")
+	if _, err := config.Load(); err == nil {
+		t.Error("expected error for buffer_file without buffer_size")
+	}
+	t.Setenv("UPS_MQTT_MQTT_BUFFER_SIZE", "-1")
+	if _, err := config.Load(); err == nil {
+		t.Error("expected error for a negative buffer_size")
+	}
+}
+
+// TestLoad_Sinks_FromTOML verifies [[sinks]] entries are parsed.
+func TestLoad_Sinks_FromTOML(t *testing.T) {
+	f, err := os.CreateTemp("", "ups-mqtt-*.toml")
+	if err != nil {
+		t.Fatalf("creating temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`
+[[sinks]]
+type    = "mqtt"
+exclude = ["ups/+/computed/#"]
+
+[[sinks]]
+name    = "metrics-log"
+type    = "file"
+path    = "/var/log/ups-mqtt/computed.jsonl"
+topics  = ["ups/+/computed/#"]
+
+[[sinks]]
+type     = "http"
+url      = "http://collector.local/ingest"
+timeout  = "2s"
+disabled = true
+
+[[sinks]]
+name    = "nms"
+type    = "plugin"
+command = "/usr/local/libexec/ups-nms-plugin"
+args    = ["--site", "lon1"]
+
+[[sinks]]
+type        = "kafka"
+brokers     = ["kafka1:9092", "kafka2:9092"]
+kafka_topic = "facility.ups"
+topics      = ["ups/+/state"]
+tls         = true
+sasl_mechanism = "scram-sha-256"
+username    = "bridge"
+`) //nolint:errcheck
+	f.Close() //nolint:errcheck
+
+	cfg, err := config.Load(f.Name())
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if len(cfg.Sinks) != 5 {
+		t.Fatalf("Sinks = %+v, want 5 entries", cfg.Sinks)
+	}
+	if s := cfg.Sinks[0]; s.Type != "mqtt" || len(s.Exclude) != 1 || len(s.Topics) != 0 {
+		t.Errorf("Sinks[0] = %+v", s)
+	}
+	if s := cfg.Sinks[1]; s.Name != "metrics-log" || s.Path != "/var/log/ups-mqtt/computed.jsonl" || s.Topics[0] != "ups/+/computed/#" {
+		t.Errorf("Sinks[1] = %+v", s)
+	}
+	if s := cfg.Sinks[2]; s.URL != "http://collector.local/ingest" || s.Timeout.Duration != 2*time.Second || !s.Disabled {
+		t.Errorf("Sinks[2] = %+v", s)
+	}
+	if s := cfg.Sinks[3]; s.Command != "/usr/local/libexec/ups-nms-plugin" || len(s.Args) != 2 || s.Args[1] != "lon1" {
+		t.Errorf("Sinks[3] = %+v", s)
+	}
+	if s := cfg.Sinks[4]; len(s.Brokers) != 2 || s.Brokers[1] != "kafka2:9092" || s.KafkaTopic != "facility.ups" || !s.TLS || s.SASLMechanism != "scram-sha-256" || s.Username != "bridge" {
+		t.Errorf("Sinks[4] = %+v", s)
+	}
+}
+
+// TestLoad_Sinks_Invalid verifies malformed sinks are rejected at load.
+func TestLoad_Sinks_Invalid(t *testing.T) {
+	for name, body := range map[string]string{
+		"unknown type":   "[[sinks]]\ntype = \"amqp\"\n",
+		"kafka no topic": "[[sinks]]\ntype = \"kafka\"\nbrokers = [\"kafka1:9092\"]\n",
+		"kafka bad sasl": "[[sinks]]\ntype = \"kafka\"\nbrokers = [\"kafka1:9092\"]\nkafka_topic = \"t\"\nsasl_mechanism = \"gssapi\"\nusername = \"u\"\n",
+		"kafka no user":  "[[sinks]]\ntype = \"kafka\"\nbrokers = [\"kafka1:9092\"]\nkafka_topic = \"t\"\nsasl_mechanism = \"plain\"\n",
+		"file no path":   "[[sinks]]\ntype = \"file\"\n",
+		"http no url":    "[[sinks]]\ntype = \"http\"\n",
+		"plugin no cmd":  "[[sinks]]\ntype = \"plugin\"\n",
+		"two mqtt":       "[[sinks]]\ntype = \"mqtt\"\n[[sinks]]\ntype = \"mqtt\"\n",
+		"empty filter":   "[[sinks]]\ntype = \"mqtt\"\ntopics = [\"\"]\n",
+		"empty exclude":  "[[sinks]]\ntype = \"mqtt\"\nexclude = [\"\"]\n",
+	} {
+		f, err := os.CreateTemp("", "ups-mqtt-*.toml")
+		if err != nil {
+			t.Fatalf("creating temp file: %v", err)
+		}
+		defer os.Remove(f.Name())
+		f.WriteString(body) //nolint:errcheck
+		f.Close()           //nolint:errcheck
+		if _, err := config.Load(f.Name()); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+// TestLoad_StatusDisplay verifies the defaults, env overrides and that an
+// unknown casing is rejected.
+func TestLoad_StatusDisplay(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if m := cfg.Metrics; m.StatusSeparator != ", " || m.StatusCase != "title" || m.StatusShort {
+		t.Errorf("defaults = %q, %q, %v; want \", \", title, false", m.StatusSeparator, m.StatusCase, m.StatusShort)
+	}
+	t.Setenv("UPS_MQTT_METRICS_STATUS_SEPARATOR", " / ")
+	t.Setenv("UPS_MQTT_METRICS_STATUS_CASE", "upper")
+	t.Setenv("UPS_MQTT_METRICS_STATUS_SHORT", "true")
+	if cfg, err = config.Load(); err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if m := cfg.Metrics; m.StatusSeparator != " / " || m.StatusCase != "upper" || !m.StatusShort {
+		t.Errorf("env overrides = %q, %q, %v", m.StatusSeparator, m.StatusCase, m.StatusShort)
+	}
+	t.Setenv("UPS_MQTT_METRICS_STATUS_CASE", "camel")
+	if _, err := config.Load(); err == nil {
+		t.Error("expected error for unknown status_case")
+	}
+}
+
+// TestLoad_WakeOnLAN verifies the defaults, the TOML section, env overrides
+// and validation of the addresses.
+func TestLoad_WakeOnLAN(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if w := cfg.WakeOnLAN; len(w.Targets) != 0 || w.Broadcast != "255.255.255.255:9" || w.Settle.Duration != 2*time.Minute || w.Always {
+		t.Errorf("WakeOnLAN defaults = %+v", w)
+	}
+
+	f, err := os.CreateTemp("", "ups-mqtt-*.toml")
+	if err != nil {
+		t.Fatalf("creating temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`
+[wake_on_lan]
+targets   = ["00:11:22:aa:bb:cc", "00-11-22-aa-bb-cd"]
+broadcast = "192.168.1.255:9"
+settle    = "5m"
+`) //nolint:errcheck
+	f.Close() //nolint:errcheck
+	if cfg, err = config.Load(f.Name()); err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if w := cfg.WakeOnLAN; len(w.Targets) != 2 || w.Broadcast != "192.168.1.255:9" || w.Settle.Duration != 5*time.Minute {
+		t.Errorf("WakeOnLAN = %+v", w)
+	}
+
+	t.Setenv("UPS_MQTT_WAKE_ON_LAN_TARGETS", "00:11:22:aa:bb:ce")
+	t.Setenv("UPS_MQTT_WAKE_ON_LAN_SETTLE", "30s")
+	t.Setenv("UPS_MQTT_WAKE_ON_LAN_ALWAYS", "true")
+	if cfg, err = config.Load(f.Name()); err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if w := cfg.WakeOnLAN; len(w.Targets) != 1 || w.Settle.Duration != 30*time.Second || !w.Always {
+		t.Errorf("WakeOnLAN env overrides = %+v", w)
+	}
+
+	t.Setenv("UPS_MQTT_WAKE_ON_LAN_TARGETS", "not-a-mac")
+	if _, err := config.Load(); err == nil {
+		t.Error("expected error for an invalid MAC address")
+	}
+	t.Setenv("UPS_MQTT_WAKE_ON_LAN_TARGETS", "00:11:22:aa:bb:cc")
+	t.Setenv("UPS_MQTT_WAKE_ON_LAN_BROADCAST", "192.168.1.255")
+	if _, err := config.Load(); err == nil {
+		t.Error("expected error for a broadcast address without a port")
+	}
+}
+
+// TestLoad_NotificationRouting verifies notifiers and routes are parsed.
+func TestLoad_NotificationRouting(t *testing.T) {
+	f, err := os.CreateTemp("", "ups-mqtt-*.toml")
+	if err != nil {
+		t.Fatalf("creating temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`
+[notifications]
+enabled = true
+
+[[notifications.notifiers]]
+name    = "ops"
+type    = "webhook"
+url     = "https://hooks.example.com/ups"
+timeout = "3s"
+
+[[notifications.notifiers]]
+name      = "mail"
+type      = "email"
+smtp_host = "smtp.example.com:587"
+username  = "ups"
+password  = "secret"
+from      = "ups@example.com"
+to        = ["ops@example.com"]
+
+[[notifications.notifiers]]
+name    = "phone"
+type    = "telegram"
+token   = "123:abc"
+chat_id = "-100200"
+
+[[notifications.routes]]
+events    = ["low_battery", "forced_shutdown"]
+notifiers = ["ops", "mqtt"]
+
+[[notifications.routes]]
+events       = ["replace_*"]
+min_severity = "warning"
+notifiers    = ["mail"]
+
+[notifications.templates.de]
+on_battery = "{{.UPS}} läuft auf Batterie"
+`) //nolint:errcheck
+	f.Close() //nolint:errcheck
+
+	cfg, err := config.Load(f.Name())
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	n := cfg.Notifications
+	if len(n.Notifiers) != 3 || len(n.Routes) != 2 {
+		t.Fatalf("Notifications = %+v", n)
+	}
+	if w := n.Notifiers[0]; w.Type != "webhook" || w.URL != "https://hooks.example.com/ups" || w.Timeout.Duration != 3*time.Second {
+		t.Errorf("Notifiers[0] = %+v", w)
+	}
+	if e := n.Notifiers[1]; e.SMTPHost != "smtp.example.com:587" || e.Username != "ups" || e.From != "ups@example.com" || len(e.To) != 1 {
+		t.Errorf("Notifiers[1] = %+v", e)
+	}
+	if tg := n.Notifiers[2]; tg.Type != "telegram" || tg.Token != "123:abc" || tg.ChatID != "-100200" {
+		t.Errorf("Notifiers[2] = %+v", tg)
+	}
+	if r := n.Routes[1]; r.Events[0] != "replace_*" || r.MinSeverity != "warning" || r.Notifiers[0] != "mail" {
+		t.Errorf("Routes[1] = %+v", r)
+	}
+	if n.Templates["de"]["on_battery"] != "{{.UPS}} läuft auf Batterie" {
+		t.Errorf("Templates = %v", n.Templates)
+	}
+}
+
+// TestLoad_NotificationRouting_Invalid verifies malformed notifiers and
+// routes are rejected at load.
+func TestLoad_NotificationRouting_Invalid(t *testing.T) {
+	hook := "[[notifications.notifiers]]\nname = \"ops\"\ntype = \"webhook\"\nurl = \"http://x\"\n"
+	for name, body := range map[string]string{
+		"no name":           "[[notifications.notifiers]]\ntype = \"webhook\"\nurl = \"http://x\"\n",
+		"reserved name":     "[[notifications.notifiers]]\nname = \"mqtt\"\ntype = \"webhook\"\nurl = \"http://x\"\n",
+		"duplicate":         hook + hook,
+		"unknown type":      "[[notifications.notifiers]]\nname = \"x\"\ntype = \"pager\"\n",
+		"webhook no url":    "[[notifications.notifiers]]\nname = \"x\"\ntype = \"webhook\"\n",
+		"email incomplete":  "[[notifications.notifiers]]\nname = \"x\"\ntype = \"email\"\nsmtp_host = \"smtp:25\"\n",
+		"email bad host":    "[[notifications.notifiers]]\nname = \"x\"\ntype = \"email\"\nsmtp_host = \"smtp\"\nfrom = \"a@b\"\nto = [\"c@d\"]\n",
+		"telegram no chat":  "[[notifications.notifiers]]\nname = \"x\"\ntype = \"telegram\"\ntoken = \"t\"\n",
+		"pushover no user":  "[[notifications.notifiers]]\nname = \"x\"\ntype = \"pushover\"\ntoken = \"t\"\n",
+		"slack no url":      "[[notifications.notifiers]]\nname = \"x\"\ntype = \"slack\"\n",
+		"unknown notifier":  "[[notifications.routes]]\nnotifiers = [\"telegram\"]\n",
+		"no notifiers":      "[[notifications.routes]]\nevents = [\"low_battery\"]\n",
+		"bad severity":      "[[notifications.routes]]\nmin_severity = \"urgent\"\nnotifiers = [\"mqtt\"]\n",
+		"bad pattern":       "[[notifications.routes]]\nevents = [\"[\"]\nnotifiers = [\"mqtt\"]\n",
+		"bad template":      "[notifications.templates.de]\non_battery = \"{{.UPS\"\n",
+		"no language":       "[notifications]\nlanguage = \"fr\"\n[notifications.templates.de]\non_battery = \"x\"\n",
+		"notifier language": hook + "language = \"fr\"\n",
+	} {
+		f, err := os.CreateTemp("", "ups-mqtt-*.toml")
+		if err != nil {
+			t.Fatalf("creating temp file: %v", err)
+		}
+		defer os.Remove(f.Name())
+		f.WriteString(body) //nolint:errcheck
+		f.Close()           //nolint:errcheck
+		if _, err := config.Load(f.Name()); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestLoad_MultipleUPS(t *testing.T) {
+	f, err := os.CreateTemp("", "ups-mqtt-*.toml")
+	if err != nil {
+		t.Fatalf("creating temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`
+[nut]
+host = "nas.local"
+
+[[nut.ups]]
+name = "rack"
+
+[[nut.ups]]
+name  = "desk"
+label = "office"
+`) //nolint:errcheck
+	f.Close() //nolint:errcheck
+
+	cfg, err := config.Load(f.Name())
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	cfgs := cfg.PerUPS()
+	if len(cfgs) != 2 {
+		t.Fatalf("PerUPS() returned %d configs, want 2", len(cfgs))
+	}
+	if c := cfgs[0]; c.NUT.UPSName != "rack" || c.NUT.EffectiveLabel() != "rack" || c.MQTT.ClientID != "ups-mqtt-rack" {
+		t.Errorf("cfgs[0] NUT = %+v, ClientID = %q", c.NUT, c.MQTT.ClientID)
+	}
+	if c := cfgs[1]; c.NUT.UPSName != "desk" || c.NUT.EffectiveLabel() != "office" || c.MQTT.ClientID != "ups-mqtt-office" {
+		t.Errorf("cfgs[1] NUT = %+v, ClientID = %q", c.NUT, c.MQTT.ClientID)
+	}
+	if cfgs[1].NUT.Host != "nas.local" || len(cfgs[1].NUT.UPS) != 0 {
+		t.Errorf("entries should share [nut] settings: %+v", cfgs[1].NUT)
+	}
+	if cfg.NUT.UPSName != "cyberpower" {
+		t.Errorf("PerUPS() must not modify the original, UPSName = %q", cfg.NUT.UPSName)
+	}
+}
+
+func TestPerUPS_Single(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfgs := cfg.PerUPS(); len(cfgs) != 1 || cfgs[0] != cfg {
+		t.Errorf("PerUPS() = %v, want the config itself", cfgs)
+	}
+}
+
+func TestForUPS(t *testing.T) {
+	t.Setenv("UPS_MQTT_NUT_UPS_NAME", "*")
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if !cfg.NUT.Discover() {
+		t.Fatal("Discover() = false for ups_name = \"*\"")
+	}
+	c := cfg.ForUPS("rack")
+	if c.NUT.UPSName != "rack" || c.NUT.EffectiveLabel() != "rack" || c.MQTT.ClientID != "ups-mqtt-rack" {
+		t.Errorf("ForUPS() NUT = %+v, ClientID = %q", c.NUT, c.MQTT.ClientID)
+	}
+	if c.NUT.Discover() || cfg.NUT.UPSName != "*" {
+		t.Errorf("ForUPS() must not modify the original, UPSName = %q", cfg.NUT.UPSName)
+	}
+}
+
+// TestLoad_MultipleUPS_Invalid verifies malformed [[nut.ups]] entries are
+// rejected at load.
+func TestLoad_MultipleUPS_Invalid(t *testing.T) {
+	two := "[[nut.ups]]\nname = \"a\"\n[[nut.ups]]\nname = \"b\"\n"
+	for name, body := range map[string]string{
+		"no name":         "[[nut.ups]]\nlabel = \"x\"\n",
+		"duplicate label": "[[nut.ups]]\nname = \"a\"\n[[nut.ups]]\nname = \"b\"\nlabel = \"a\"\n",
+		"migration label": "[migration]\nlabel = \"old\"\n" + two,
+		"snapshot file":   "[diagnostics]\nsnapshot_file = \"/tmp/s.json\"\n" + two,
+		"discover list":   "[nut]\nups_name = \"*\"\n" + two,
+		"discover label":  "[nut]\nups_name = \"*\"\nlabel = \"x\"\n",
+		"discover buffer": "[nut]\nups_name = \"*\"\n[mqtt]\nbuffer_file = \"/tmp/b\"\n",
+		"aggregate one":   "[mqtt]\naggregate = true\n",
+	} {
+		f, err := os.CreateTemp("", "ups-mqtt-*.toml")
+		if err != nil {
+			t.Fatalf("creating temp file: %v", err)
+		}
+		defer os.Remove(f.Name())
+		f.WriteString(body) //nolint:errcheck
+		f.Close()           //nolint:errcheck
+		if _, err := config.Load(f.Name()); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestLoad_AlignPolls(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.NUT.AlignPolls {
+		t.Error("NUT.AlignPolls should default to false")
+	}
+	t.Setenv("UPS_MQTT_NUT_ALIGN_POLLS", "true")
+	if cfg, err = config.Load(); err != nil || !cfg.NUT.AlignPolls {
+		t.Errorf("NUT.AlignPolls = %v (err %v), want true", cfg.NUT.AlignPolls, err)
+	}
+	t.Setenv("UPS_MQTT_NUT_POLL_INTERVAL", "7s")
+	if _, err = config.Load(); err == nil {
+		t.Error("expected error for an interval that doesn't divide a day")
+	}
+}
+
+func TestLoad_PublishMode(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.MQTT.PublishMode != "always" {
+		t.Errorf("PublishMode = %q, want always", cfg.MQTT.PublishMode)
+	}
+	t.Setenv("UPS_MQTT_MQTT_PUBLISH_MODE", "on_change")
+	if cfg, err = config.Load(); err != nil || cfg.MQTT.PublishMode != "on_change" {
+		t.Errorf("PublishMode = %q (err %v), want on_change", cfg.MQTT.PublishMode, err)
+	}
+	t.Setenv("UPS_MQTT_MQTT_PUBLISH_MODE", "sometimes")
+	if _, err = config.Load(); err == nil {
+		t.Error("expected error for an unknown publish_mode")
+	}
+}
+
+func TestLoad_Events(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.MQTT.Events {
+		t.Error("MQTT.Events should default to false")
+	}
+	t.Setenv("UPS_MQTT_MQTT_EVENTS", "1")
+	if cfg, err = config.Load(); err != nil || !cfg.MQTT.Events {
+		t.Errorf("MQTT.Events = %v (err %v), want true", cfg.MQTT.Events, err)
+	}
+}
+
+func TestLoad_Hook(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Hook.Command != "" || cfg.Hook.Timeout.Duration != 5*time.Second {
+		t.Errorf("Hook = %+v, want disabled with a 5s timeout", cfg.Hook)
+	}
+	t.Setenv("UPS_MQTT_HOOK_COMMAND", "/usr/bin/lua")
+	t.Setenv("UPS_MQTT_HOOK_ARGS", "/etc/ups-mqtt/hook.lua, --verbose")
+	t.Setenv("UPS_MQTT_HOOK_TIMEOUT", "2s")
+	t.Setenv("UPS_MQTT_HOOK_PLUGIN", "true")
+	if cfg, err = config.Load(); err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Hook.Command != "/usr/bin/lua" || len(cfg.Hook.Args) != 2 || cfg.Hook.Args[1] != "--verbose" || cfg.Hook.Timeout.Duration != 2*time.Second || !cfg.Hook.Plugin {
+		t.Errorf("Hook = %+v", cfg.Hook)
+	}
+	t.Setenv("UPS_MQTT_HOOK_TIMEOUT", "0s")
+	if _, err = config.Load(); err == nil {
+		t.Error("expected error for a zero hook timeout")
+	}
+	t.Setenv("UPS_MQTT_HOOK_TIMEOUT", "2s")
+	t.Setenv("UPS_MQTT_HOOK_SCRIPT", "/etc/ups-mqtt/hook.lua")
+	if _, err = config.Load(); err == nil {
+		t.Error("expected error for both hook.script and hook.command")
+	}
+	t.Setenv("UPS_MQTT_HOOK_COMMAND", "")
+	t.Setenv("UPS_MQTT_HOOK_ARGS", "")
+	if cfg, err = config.Load(); err != nil || cfg.Hook.Script != "/etc/ups-mqtt/hook.lua" {
+		t.Errorf("Hook = %+v (err %v), want the script", cfg.Hook, err)
+	}
+}
+
+func TestLoad_Commands(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Commands.Enabled || len(cfg.Commands.Allow) != 0 {
+		t.Errorf("Commands = %+v, want disabled", cfg.Commands)
+	}
+	t.Setenv("UPS_MQTT_COMMANDS_ENABLED", "true")
+	t.Setenv("UPS_MQTT_COMMANDS_ALLOW", "beeper.*, test.battery.*")
+	if cfg, err = config.Load(); err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if !cfg.Commands.Enabled || len(cfg.Commands.Allow) != 2 || cfg.Commands.Allow[1] != "test.battery.*" {
+		t.Errorf("Commands = %+v", cfg.Commands)
+	}
+	t.Setenv("UPS_MQTT_COMMANDS_ALLOW", "beeper.[")
+	if _, err = config.Load(); err == nil {
+		t.Error("expected error for a malformed allow pattern")
+	}
+	t.Setenv("UPS_MQTT_COMMANDS_ALLOW", "")
+	if _, err = config.Load(); err == nil || !strings.Contains(err.Error(), "commands.allow") {
+		t.Errorf("err = %v, want an empty allow list rejected", err)
+	}
+}
+
+func TestLoad_MetricsUnavailable(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Metrics.Unavailable != "zero" {
+		t.Errorf("Unavailable = %q, want zero", cfg.Metrics.Unavailable)
+	}
+	t.Setenv("UPS_MQTT_METRICS_UNAVAILABLE", "flag")
+	if cfg, err = config.Load(); err != nil || cfg.Metrics.Unavailable != "flag" {
+		t.Fatalf("Load() = %q, %v", cfg.Metrics.Unavailable, err)
+	}
+	t.Setenv("UPS_MQTT_METRICS_UNAVAILABLE", "nan")
+	if _, err = config.Load(); err == nil || !strings.Contains(err.Error(), "metrics.unavailable") {
+		t.Errorf("err = %v, want an unknown strategy rejected", err)
+	}
+}
+
+func TestLoad_Drill(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Drill.Enabled || cfg.Drill.Step.Duration != 30*time.Second {
+		t.Errorf("Drill = %+v, want disabled with a 30s step", cfg.Drill)
+	}
+	t.Setenv("UPS_MQTT_DRILL_ENABLED", "true")
+	t.Setenv("UPS_MQTT_DRILL_STEP", "5s")
+	if cfg, err = config.Load(); err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if !cfg.Drill.Enabled || cfg.Drill.Step.Duration != 5*time.Second {
+		t.Errorf("Drill = %+v", cfg.Drill)
+	}
+	t.Setenv("UPS_MQTT_DRILL_STEP", "0s")
+	if _, err = config.Load(); err == nil || !strings.Contains(err.Error(), "drill.step") {
+		t.Errorf("err = %v, want a zero step rejected", err)
+	}
+}
+
+func TestLoad_UpdateCheck(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.UpdateCheck.Enabled || cfg.UpdateCheck.URL == "" || cfg.UpdateCheck.Interval.Duration != 24*time.Hour {
+		t.Errorf("UpdateCheck = %+v, want disabled, daily, with the default feed", cfg.UpdateCheck)
+	}
+	t.Setenv("UPS_MQTT_UPDATE_CHECK_ENABLED", "true")
+	t.Setenv("UPS_MQTT_UPDATE_CHECK_URL", "https://git.example/releases/latest")
+	t.Setenv("UPS_MQTT_UPDATE_CHECK_INTERVAL", "6h")
+	if cfg, err = config.Load(); err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if !cfg.UpdateCheck.Enabled || cfg.UpdateCheck.URL != "https://git.example/releases/latest" || cfg.UpdateCheck.Interval.Duration != 6*time.Hour {
+		t.Errorf("UpdateCheck = %+v", cfg.UpdateCheck)
+	}
+	t.Setenv("UPS_MQTT_UPDATE_CHECK_INTERVAL", "10m")
+	if _, err = config.Load(); err == nil || !strings.Contains(err.Error(), "update_check.interval") {
+		t.Errorf("err = %v, want an interval under an hour rejected", err)
+	}
+}
+
+func TestLoad_Hooks(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Hooks.OnBattery != "" || cfg.Hooks.Timeout.Duration != 30*time.Second {
+		t.Errorf("Hooks = %+v, want none, with a 30s timeout", cfg.Hooks)
+	}
+	t.Setenv("UPS_MQTT_HOOKS_ON_BATTERY", "logger on battery")
+	t.Setenv("UPS_MQTT_HOOKS_POLL_FAILURE", "logger poll failed")
+	t.Setenv("UPS_MQTT_HOOKS_TIMEOUT", "5s")
+	if cfg, err = config.Load(); err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Hooks.Command("on_battery") != "logger on battery" || cfg.Hooks.Command("poll_failure") != "logger poll failed" ||
+		cfg.Hooks.Command("low_battery") != "" || cfg.Hooks.Timeout.Duration != 5*time.Second {
+		t.Errorf("Hooks = %+v", cfg.Hooks)
+	}
+	t.Setenv("UPS_MQTT_HOOKS_TIMEOUT", "0s")
+	if _, err = config.Load(); err == nil || !strings.Contains(err.Error(), "hooks.timeout") {
+		t.Errorf("err = %v, want a zero timeout rejected", err)
+	}
+}
+
+func TestLoad_Labels(t *testing.T) {
+	f, err := os.CreateTemp("", "ups-mqtt-*.toml")
+	if err != nil {
+		t.Fatalf("creating temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("[labels]\nsite = \"lon1\"\nrack = \"r4\"\n") //nolint:errcheck
+	f.Close()                                                   //nolint:errcheck
+
+	cfg, err := config.Load(f.Name())
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if len(cfg.Labels) != 2 || cfg.Labels["site"] != "lon1" || cfg.Labels["rack"] != "r4" {
+		t.Errorf("Labels = %v", cfg.Labels)
+	}
+	t.Setenv("UPS_MQTT_LABELS", "site=ams2, room=comms")
+	if cfg, err = config.Load(f.Name()); err != nil || cfg.Labels["site"] != "ams2" || cfg.Labels["room"] != "comms" || len(cfg.Labels) != 2 {
+		t.Errorf("Labels = %v (err %v), want the env map", cfg.Labels, err)
+	}
+}
+
+func TestLoad_Labels_Invalid(t *testing.T) {
+	for name, body := range map[string]string{
+		"reserved ups":  "[labels]\nups = \"x\"\n",
+		"double under":  "[labels]\n__site = \"x\"\n",
+		"leading digit": "[labels]\n1site = \"x\"\n",
+		"dash":          "[labels]\n\"data-centre\" = \"x\"\n",
+		"empty value":   "[labels]\nsite = \"\"\n",
+	} {
+		f, err := os.CreateTemp("", "ups-mqtt-*.toml")
+		if err != nil {
+			t.Fatalf("creating temp file: %v", err)
+		}
+		defer os.Remove(f.Name())
+		f.WriteString(body) //nolint:errcheck
+		f.Close()           //nolint:errcheck
+		if _, err := config.Load(f.Name()); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestLoad_RetainTTL(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.MQTT.RetainTTL.Duration != 0 {
+		t.Errorf("default RetainTTL = %s, want 0 (off)", cfg.MQTT.RetainTTL.Duration)
+	}
+
+	t.Setenv("UPS_MQTT_MQTT_RETAIN_TTL", "15m")
+	if cfg, err = config.Load(); err != nil || cfg.MQTT.RetainTTL.Duration != 15*time.Minute {
+		t.Errorf("RetainTTL = %s (err %v), want 15m", cfg.MQTT.RetainTTL.Duration, err)
+	}
+
+	t.Setenv("UPS_MQTT_NUT_POLL_INTERVAL", "30m")
+	if _, err := config.Load(); err == nil {
+		t.Error("expected error for a retain_ttl shorter than the poll interval")
+	}
+}
+
+func TestLoad_MaxConnections(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.NUT.MaxConnections != 4 {
+		t.Errorf("default MaxConnections = %d, want 4", cfg.NUT.MaxConnections)
+	}
+
+	t.Setenv("UPS_MQTT_NUT_MAX_CONNECTIONS", "2")
+	if cfg, err = config.Load(); err != nil || cfg.NUT.MaxConnections != 2 {
+		t.Errorf("MaxConnections = %d (err %v), want 2", cfg.NUT.MaxConnections, err)
+	}
+
+	t.Setenv("UPS_MQTT_NUT_MAX_CONNECTIONS", "0")
+	if _, err := config.Load(); err == nil {
+		t.Error("expected error for max_connections = 0")
+	}
+}
+
+func TestLoad_MultipleUPS_Overrides(t *testing.T) {
+	f, err := os.CreateTemp("", "ups-mqtt-*.toml")
+	if err != nil {
+		t.Fatalf("creating temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`
+[nut]
+poll_interval = "30s"
+
+[mqtt]
+topic_prefix = "ups"
+qos = 1
+
+[[nut.ups]]
+name          = "rack"
+poll_interval = "5s"
+topic_prefix  = "dc/ups"
+retained      = false
+qos           = 0
+
+[nut.ups.filter]
+enabled = true
+mode    = "clamp"
+
+[[nut.ups]]
+name = "desk"
+`) //nolint:errcheck
+	f.Close() //nolint:errcheck
+
+	cfg, err := config.Load(f.Name())
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	cfgs := cfg.PerUPS()
+	rack, desk := cfgs[0], cfgs[1]
+	if rack.NUT.PollInterval.Duration != 5*time.Second || rack.MQTT.TopicPrefix != "dc/ups" || rack.MQTT.Retained || rack.MQTT.QOS != 0 {
+		t.Errorf("rack: poll_interval %s, topic_prefix %q, retained %v, qos %d", rack.NUT.PollInterval, rack.MQTT.TopicPrefix, rack.MQTT.Retained, rack.MQTT.QOS)
+	}
+	if !rack.Filter.Enabled || rack.Filter.Mode != "clamp" {
+		t.Errorf("rack: filter = %+v", rack.Filter)
+	}
+	if desk.NUT.PollInterval.Duration != 30*time.Second || desk.MQTT.TopicPrefix != "ups" || !desk.MQTT.Retained || desk.MQTT.QOS != 1 || desk.Filter.Enabled {
+		t.Errorf("desk should keep the shared settings: NUT %+v, MQTT %+v, filter %+v", desk.NUT, desk.MQTT, desk.Filter)
+	}
+}
+
+func TestLoad_MultipleUPS_InvalidOverrides(t *testing.T) {
+	for name, body := range map[string]string{
+		"filter mode":   "[[nut.ups]]\nname = \"a\"\n[nut.ups.filter]\nmode = \"shrink\"\n",
+		"poll interval": "[[nut.ups]]\nname = \"a\"\npoll_interval = \"0s\"\n",
+		"qos":           "[[nut.ups]]\nname = \"a\"\nqos = 3\n",
+		"retain ttl":    "[mqtt]\nretain_ttl = \"1m\"\n[[nut.ups]]\nname = \"a\"\npoll_interval = \"2m\"\n",
+		"aligned polls": "[nut]\nalign_polls = true\n[[nut.ups]]\nname = \"a\"\npoll_interval = \"7s\"\n",
+	} {
+		f, err := os.CreateTemp("", "ups-mqtt-*.toml")
+		if err != nil {
+			t.Fatalf("creating temp file: %v", err)
+		}
+		defer os.Remove(f.Name())
+		f.WriteString(body) //nolint:errcheck
+		f.Close()           //nolint:errcheck
+		if _, err := config.Load(f.Name()); err == nil || !strings.Contains(err.Error(), "nut.ups[0]") {
+			t.Errorf("%s: err = %v, want a nut.ups[0] error", name, err)
+		}
+	}
+}
+
+func TestLoad_PasswordCommand(t *testing.T) {
+	t.Setenv("UPS_MQTT_NUT_PASSWORD_COMMAND", `printf 'nut s3cret\n'`)
+	t.Setenv("UPS_MQTT_MQTT_PASSWORD_COMMAND", "echo mqtt-pw")
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.NUT.Password != "nut s3cret" || cfg.MQTT.Password != "mqtt-pw" {
+		t.Errorf("passwords = %q, %q", cfg.NUT.Password, cfg.MQTT.Password)
+	}
+
+	for command, want := range map[string]string{
+		"echo vault sealed >&2; exit 2": "vault sealed",
+		"true":                          "printed no password",
+	} {
+		t.Setenv("UPS_MQTT_MQTT_PASSWORD_COMMAND", command)
+		if _, err := config.Load(); err == nil || !strings.Contains(err.Error(), "mqtt.password_command") || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: err = %v, want it to mention %q", command, err, want)
+		}
+	}
+
+	t.Setenv("UPS_MQTT_MQTT_PASSWORD_COMMAND", "")
+	t.Setenv("UPS_MQTT_NUT_PASSWORD", "inline")
+	if _, err := config.Load(); err == nil || !strings.Contains(err.Error(), "mutually exclusive") {
+		t.Errorf("err = %v, want password and password_command rejected together", err)
+	}
+}
+
+// TestLoad_Profile verifies that a profile adjusts the defaults and that the
+// file and environment still override it.
+func TestLoad_Profile(t *testing.T) {
+	f, err := os.CreateTemp("", "ups-mqtt-*.toml")
+	if err != nil {
+		t.Fatalf("creating temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`
+profile = "thingsboard"
+
+[mqtt]
+qos = 0
+`) //nolint:errcheck
+	f.Close() //nolint:errcheck
+
+	cfg, err := config.Load(f.Name())
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.MQTT.Retained || cfg.MQTT.QOS != 0 {
+		t.Errorf("retained %v, qos %d; want the profile's retain and the file's qos", cfg.MQTT.Retained, cfg.MQTT.QOS)
+	}
+	if len(cfg.Sinks) != 1 || cfg.Sinks[0].Type != "mqtt" || cfg.Sinks[0].Topics[0] != "+/+/state" {
+		t.Errorf("sinks = %+v, want only the state topic sent to MQTT", cfg.Sinks)
+	}
+
+	t.Setenv("UPS_MQTT_PROFILE", "home-assistant")
+	t.Setenv("UPS_MQTT_HOMEASSISTANT_DISCOVERY_PREFIX", "ha")
+	cfg, err = config.Load(f.Name())
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if !cfg.HomeAssistant.Discovery || cfg.HomeAssistant.DiscoveryPrefix != "ha" || len(cfg.Sinks) != 0 {
+		t.Errorf("config = %+v, %+v; want discovery on under the env's prefix and no sinks", cfg.HomeAssistant, cfg.Sinks)
+	}
+
+	t.Setenv("UPS_MQTT_PROFILE", "mosquitto")
+	if _, err := config.Load(); err == nil || !strings.Contains(err.Error(), `"aws-iot"`) {
+		t.Errorf("err = %v, want an unknown profile rejected with the valid names", err)
+	}
+}
+
+func TestLoad_Preset(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.NUT.MaxBackoff.Duration != time.Minute || cfg.MQTT.MaxBackoff.Duration != time.Minute || cfg.Logging.SuppressRepeats {
+		t.Errorf("defaults: backoff %s/%s, suppress_repeats %v", cfg.NUT.MaxBackoff, cfg.MQTT.MaxBackoff, cfg.Logging.SuppressRepeats)
+	}
+
+	f, err := os.CreateTemp("", "ups-mqtt-*.toml")
+	if err != nil {
+		t.Fatalf("creating temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`
+preset = "rpi"
+
+[mqtt]
+max_backoff = "2m"
+`) //nolint:errcheck
+	f.Close() //nolint:errcheck
+
+	if cfg, err = config.Load(f.Name()); err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.NUT.MaxConnections != 1 || cfg.NUT.MaxBackoff.Duration != 5*time.Minute || !cfg.Logging.SuppressRepeats {
+		t.Errorf("rpi: max_connections %d, nut backoff %s, suppress_repeats %v", cfg.NUT.MaxConnections, cfg.NUT.MaxBackoff, cfg.Logging.SuppressRepeats)
+	}
+	if cfg.MQTT.MaxBackoff.Duration != 2*time.Minute {
+		t.Errorf("mqtt.max_backoff = %s, want the file's 2m over the preset's", cfg.MQTT.MaxBackoff)
+	}
+
+	t.Setenv("UPS_MQTT_PRESET", "pi")
+	if _, err := config.Load(f.Name()); err == nil || !strings.Contains(err.Error(), `"rpi"`) {
+		t.Errorf("err = %v, want an unknown preset rejected with the valid names", err)
+	}
+	t.Setenv("UPS_MQTT_PRESET", "")
+	t.Setenv("UPS_MQTT_NUT_MAX_BACKOFF", "500ms")
+	if _, err := config.Load(); err == nil || !strings.Contains(err.Error(), "nut.max_backoff") {
+		t.Errorf("err = %v, want a backoff under a second rejected", err)
+	}
+}
+
+// TestLoad_EnvFile verifies that UPS_MQTT_* variables can be read from the
+// file named by the same variable with _FILE appended.
+func TestLoad_EnvFile(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "mqtt_password")
+	os.WriteFile(secret, []byte("s3cret\n"), 0o600)                 //nolint:errcheck
+	os.WriteFile(filepath.Join(dir, "port"), []byte("4000"), 0o600) //nolint:errcheck
+	t.Setenv("UPS_MQTT_MQTT_PASSWORD_FILE", secret)
+	t.Setenv("UPS_MQTT_NUT_PORT_FILE", filepath.Join(dir, "port"))
+	t.Setenv("UPS_MQTT_DIAGNOSTICS_SNAPSHOT_FILE", "/run/ups-mqtt/snapshot.json")
+
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.MQTT.Password != "s3cret" || cfg.NUT.Port != 4000 {
+		t.Errorf("password %q, port %d; want them read from their files", cfg.MQTT.Password, cfg.NUT.Port)
+	}
+	if cfg.Diagnostics.SnapshotFile != "/run/ups-mqtt/snapshot.json" {
+		t.Errorf("snapshot_file = %q, want the variable's own value", cfg.Diagnostics.SnapshotFile)
+	}
+
+	t.Setenv("UPS_MQTT_MQTT_PASSWORD_FILE", filepath.Join(dir, "missing"))
+	if _, err := config.Load(); err == nil || !strings.Contains(err.Error(), "UPS_MQTT_MQTT_PASSWORD_FILE") {
+		t.Errorf("err = %v, want an unreadable file reported", err)
+	}
+
+	t.Setenv("UPS_MQTT_MQTT_PASSWORD_FILE", secret)
+	t.Setenv("UPS_MQTT_MQTT_PASSWORD", "inline")
+	if _, err := config.Load(); err == nil || !strings.Contains(err.Error(), "mutually exclusive") {
+		t.Errorf("err = %v, want the variable and its _FILE rejected together", err)
+	}
+}
+
+func TestLoad_LowPower(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if lp := cfg.LowPower; lp.Enabled || lp.PollInterval.Duration != 10*time.Second || lp.VariablesEvery != 12 || lp.ComputedEvery != 12 {
+		t.Errorf("default LowPower = %+v", lp)
+	}
+
+	t.Setenv("UPS_MQTT_LOW_POWER_ENABLED", "true")
+	t.Setenv("UPS_MQTT_LOW_POWER_POLL_INTERVAL", "5s")
+	t.Setenv("UPS_MQTT_LOW_POWER_VARIABLES_EVERY", "20")
+	if cfg, err = config.Load(); err != nil || !cfg.LowPower.Enabled || cfg.LowPower.PollInterval.Duration != 5*time.Second || cfg.LowPower.VariablesEvery != 20 {
+		t.Errorf("LowPower = %+v (err %v)", cfg.LowPower, err)
+	}
+
+	t.Setenv("UPS_MQTT_NUT_ALIGN_POLLS", "true")
+	t.Setenv("UPS_MQTT_LOW_POWER_POLL_INTERVAL", "7s")
+	if _, err := config.Load(); err == nil {
+		t.Error("expected error for an aligned low_power.poll_interval that doesn't divide a day")
+	}
+}
+
+// TestWriteDefault verifies the generated config loads to the defaults and
+// documents the settings and their environment variables.
+func TestWriteDefault(t *testing.T) {
+	var buf bytes.Buffer
+	if err := config.WriteDefault(&buf); err != nil {
+		t.Fatalf("WriteDefault: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load(generated) error: %v\n%s", err, buf.String())
+	}
+	want, _ := config.Load()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("generated config loads as\n%+v\nwant the defaults\n%+v", got, want)
+	}
+	for _, s := range []string{
+		"# [nut] holds Network UPS Tools client settings.\n[nut]\n",
+		"# env: UPS_MQTT_NUT_POLL_INTERVAL\npoll_interval = \"30s\"\n",
+		"# hold_missing keeps publishing a variable's last value",
+		"# env: UPS_MQTT_PROFILE\nprofile = \"\"\n",
+		"# password_command, when set, is run like nut.password_command",
+		"# [[alerts]]\n# name = \"\"\n",
+		"charge_rate_window = \"5m\"\n",
+	} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("generated config lacks %q", s)
+		}
+	}
+}
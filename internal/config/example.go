@@ -0,0 +1,321 @@
+package config
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// source is this package's code, read by WriteDefault for the doc comments
+// of the config structs and the environment variables applyEnvOverrides
+// and applyProfile look up.
+//
+//go:embed config.go profiles.go
+var source embed.FS
+
+// WriteDefault writes a config file holding every setting at its default,
+// each under its doc comment and the environment variable that overrides
+// it.  All of it is read from the code, so the file can't drift from what
+// Load accepts.  Settings without a default — lists, tables of free-form
+// keys and repeatable sections such as [[alerts]] — are commented out.
+func WriteDefault(w io.Writer) error {
+	docs, env, err := parseSource()
+	if err != nil {
+		return fmt.Errorf("reading config docs: %w", err)
+	}
+	e := &exampleWriter{docs: docs, env: env, sections: map[string]string{}}
+	ct := reflect.TypeOf(Config{})
+	for i := range ct.NumField() {
+		if f := ct.Field(i); f.Type.Kind() == reflect.Struct {
+			e.sections[f.Type.Name()] = key(f)
+		}
+	}
+	e.buf.WriteString(`# ups-mqtt configuration, written by "ups-mqtt init-config".
+#
+# Every setting is shown at its default; delete the ones you don't change.
+# Each can also be set by the environment variable named above it, or by
+# that name with _FILE appended naming a file that holds the value.
+`)
+	e.table(reflect.ValueOf(*defaults()), "", "", "", false)
+	_, err = w.Write(e.buf.Bytes())
+	return err
+}
+
+// parseSource returns the doc comments in source by type and field name,
+// with a type's own under "", and the UPS_MQTT_* variables it names.
+func parseSource() (map[string]map[string]string, map[string]bool, error) {
+	docs, env := map[string]map[string]string{}, map[string]bool{}
+	entries, err := source.ReadDir(".")
+	if err != nil {
+		return nil, nil, err
+	}
+	fset := token.NewFileSet()
+	for _, entry := range entries {
+		data, err := source.ReadFile(entry.Name())
+		if err != nil {
+			return nil, nil, err
+		}
+		f, err := parser.ParseFile(fset, entry.Name(), data, parser.ParseComments)
+		if err != nil {
+			return nil, nil, err
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.GenDecl:
+				if n.Tok != token.TYPE {
+					return true
+				}
+				for _, spec := range n.Specs {
+					ts := spec.(*ast.TypeSpec)
+					doc := ts.Doc
+					if doc == nil {
+						doc = n.Doc
+					}
+					fields := map[string]string{"": doc.Text()}
+					if st, ok := ts.Type.(*ast.StructType); ok {
+						for _, field := range st.Fields.List {
+							text := field.Doc.Text()
+							if text == "" {
+								text = field.Comment.Text()
+							}
+							for _, name := range field.Names {
+								fields[name.Name] = text
+							}
+						}
+					}
+					docs[ts.Name.Name] = fields
+				}
+			case *ast.BasicLit:
+				if s, err := strconv.Unquote(n.Value); err == nil && n.Kind == token.STRING && strings.HasPrefix(s, "UPS_MQTT_") {
+					env[s] = true
+				}
+			}
+			return true
+		})
+	}
+	return docs, env, nil
+}
+
+// exampleWriter builds the file WriteDefault writes.  sections maps the
+// types of the top-level sections to their names, for doc comments that
+// refer to another section's settings.
+type exampleWriter struct {
+	buf      bytes.Buffer
+	docs     map[string]map[string]string
+	env      map[string]bool
+	sections map[string]string
+}
+
+var durationType = reflect.TypeOf(Duration{})
+
+// table writes the settings of the struct v, at the TOML table path (""
+// for the top level): first its plain keys, then its sub-tables.  header
+// is the table's header line, none at the top level, and fieldDoc the doc
+// comment of the field holding the table, written before the type's own.
+// Everything is commented out when off is set.
+func (e *exampleWriter) table(v reflect.Value, path, header, fieldDoc string, off bool) {
+	t := v.Type()
+	docs := e.docs[t.Name()]
+	names := map[string]string{t.Name(): header}
+	if fieldDoc != "" {
+		names[t.Name()] = "This"
+	}
+	for typ, section := range e.sections {
+		if typ != t.Name() {
+			names[typ] = section
+		}
+	}
+	// Fields named as plain words, such as Labels or UPS, read better
+	// left as they are.
+	for i := range t.NumField() {
+		if f := t.Field(i); isScalar(f.Type) || strings.ToLower(f.Name) != key(f) {
+			names[f.Name] = key(f)
+		}
+	}
+	rename := renamer(names)
+
+	if header != "" {
+		e.buf.WriteString("\n")
+		e.comment(fieldDoc)
+		if fieldDoc != "" && docs[""] != "" {
+			e.buf.WriteString("#\n")
+		}
+		e.comment(rename(docs[""]))
+		e.line(header, off)
+	}
+	var nested []int
+	for i := range t.NumField() {
+		f, fv := t.Field(i), v.Field(i)
+		if !isScalar(f.Type) {
+			nested = append(nested, i)
+			continue
+		}
+		k := key(f)
+		if doc := rename(docs[f.Name]); doc != "" {
+			e.buf.WriteString("\n")
+			e.comment(doc)
+		}
+		if !off {
+			e.envComment(strings.TrimPrefix(path+"."+k, "."))
+		}
+		unset := fv.Kind() == reflect.Pointer && fv.IsNil() || fv.Kind() == reflect.Slice && fv.IsNil()
+		if fv.Kind() == reflect.Pointer {
+			if fv.IsNil() {
+				fv = reflect.Zero(f.Type.Elem())
+			} else {
+				fv = fv.Elem()
+			}
+		}
+		e.line(k+" = "+formatValue(fv), off || unset)
+	}
+
+	for _, i := range nested {
+		f, fv := t.Field(i), v.Field(i)
+		sub := strings.TrimPrefix(path+"."+key(f), ".")
+		ft := f.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+			fv = reflect.Zero(ft)
+		}
+		doc := rename(docs[f.Name])
+		switch {
+		case ft.Kind() == reflect.Struct:
+			e.table(fv, sub, "["+sub+"]", doc, off || f.Type.Kind() == reflect.Pointer)
+		case ft.Kind() == reflect.Slice:
+			e.table(reflect.Zero(ft.Elem()), sub, "[["+sub+"]]", doc, true)
+		case ft.Elem().Kind() == reflect.Struct:
+			e.table(reflect.Zero(ft.Elem()), sub+".name", "["+sub+".name]", doc, true)
+		case ft.Elem().Kind() == reflect.Map:
+			e.buf.WriteString("\n")
+			e.comment(doc)
+			e.line("["+sub+".name]", true)
+			e.line("key = "+formatValue(reflect.Zero(ft.Elem().Elem())), true)
+		default:
+			e.buf.WriteString("\n")
+			e.comment(doc)
+			if !off {
+				e.envComment(sub)
+			}
+			e.line("["+sub+"]", true)
+			e.line("name = "+formatValue(reflect.Zero(ft.Elem())), true)
+		}
+	}
+}
+
+// comment writes text as comment lines.
+func (e *exampleWriter) comment(text string) {
+	if text == "" {
+		return
+	}
+	for _, l := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		e.buf.WriteString(strings.TrimRight("# "+l, " ") + "\n")
+	}
+}
+
+// envComment names the environment variable overriding the setting at
+// path, if there is one.
+func (e *exampleWriter) envComment(path string) {
+	if name := "UPS_MQTT_" + strings.ToUpper(strings.ReplaceAll(path, ".", "_")); e.env[name] {
+		e.comment("env: " + name)
+	}
+}
+
+// line writes s, commented out when off is set.
+func (e *exampleWriter) line(s string, off bool) {
+	if off {
+		s = "# " + s
+	}
+	e.buf.WriteString(s + "\n")
+}
+
+// key returns the TOML key of a struct field.
+func key(f reflect.StructField) string {
+	return strings.Split(f.Tag.Get("toml"), ",")[0]
+}
+
+// isScalar reports whether values of t are written as a key = value line
+// rather than a table of their own.
+func isScalar(t reflect.Type) bool {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		return t == durationType
+	case reflect.Map:
+		return false
+	case reflect.Slice:
+		return isScalar(t.Elem())
+	}
+	return true
+}
+
+// renamer returns a function replacing the Go names in a doc comment,
+// the keys of names, with what the config file calls them.
+func renamer(names map[string]string) func(string) string {
+	var alts []string
+	for n := range names {
+		if n != "" && names[n] != "" {
+			alts = append(alts, regexp.QuoteMeta(n))
+		}
+	}
+	if len(alts) == 0 {
+		return func(s string) string { return s }
+	}
+	re := regexp.MustCompile(`\b(` + strings.Join(alts, "|") + `)\b`)
+	return func(s string) string {
+		return re.ReplaceAllStringFunc(s, func(n string) string { return names[n] })
+	}
+}
+
+// formatValue returns v as a TOML value.
+func formatValue(v reflect.Value) string {
+	if d, ok := v.Interface().(Duration); ok {
+		return strconv.Quote(shortDuration(d.Duration))
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return strconv.Quote(v.String())
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		s := strconv.FormatFloat(v.Float(), 'f', -1, 64)
+		if !strings.Contains(s, ".") {
+			s += ".0"
+		}
+		return s
+	case reflect.Slice:
+		elems := make([]string, v.Len())
+		for i := range elems {
+			elems[i] = formatValue(v.Index(i))
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// shortDuration formats d like time.Duration.String without the zero
+// minutes and seconds it appends: "2m" rather than "2m0s".
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// profiles are the presets selectable with the top-level profile key, one
+// per kind of broker.  Each adjusts the defaults before the config file and
+// environment are applied, so anything it sets can still be overridden.
+var profiles = map[string]func(*Config){
+	// Home Assistant's Mosquitto add-on: retained topics and discovery, so
+	// entities appear on their own and survive restarts.
+	"home-assistant": func(c *Config) {
+		c.MQTT.Retained, c.MQTT.QOS = true, 1
+		c.HomeAssistant.Discovery = true
+		c.HomeAssistant.DiscoveryPrefix = "homeassistant"
+	},
+
+	// AWS IoT Core: QoS 0 or 1 only, messages up to 128 KiB, client
+	// certificate authentication, and every message billed, so unchanged
+	// retained values aren't published again.
+	"aws-iot": func(c *Config) {
+		c.MQTT.Retained, c.MQTT.QOS = true, 1
+		c.MQTT.MaxStateBytes = 128 * 1024
+		c.MQTT.PublishMode = "on_change"
+	},
+
+	// ThingsBoard, with a device profile using MQTT transport whose
+	// telemetry topic filter is "+/+/state": it takes JSON telemetry and
+	// keeps no retained messages, so only the state topic is sent.  The
+	// device's access token is the MQTT username.
+	"thingsboard": func(c *Config) {
+		c.MQTT.Retained, c.MQTT.QOS = false, 1
+		c.Sinks = []SinkConfig{{Name: "thingsboard", Type: "mqtt", Topics: []string{"+/+/state"}}}
+	},
+}
+
+// presets are the presets selectable with the top-level preset key or the
+// -preset flag, one per kind of host.  They are applied to the defaults
+// before the profile, so a profile and the config still win.
+var presets = map[string]func(*Config){
+	// Raspberry Pi and other low-memory ARM single-board computers, often
+	// on an SD card and Wi-Fi: one upsd connection however many UPSes are
+	// polled, retries that back off to five minutes while upsd or the
+	// broker is unreachable, and repeated log lines collapsed.
+	"rpi": func(c *Config) {
+		c.NUT.MaxConnections = 1
+		c.NUT.MaxBackoff = Duration{5 * time.Minute}
+		c.MQTT.MaxBackoff = Duration{5 * time.Minute}
+		c.Logging.SuppressRepeats = true
+	},
+}
+
+// sortedNames returns the names in table, quoted and sorted.
+func sortedNames(table map[string]func(*Config)) []string {
+	names := make([]string, 0, len(table))
+	for name := range table {
+		names = append(names, fmt.Sprintf("%q", name))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyProfile applies the preset and the profile named in the config
+// file at path, or in UPS_MQTT_PRESET and UPS_MQTT_PROFILE, to cfg, in
+// that order.  An empty path, preset or profile leaves cfg unchanged.
+func applyProfile(cfg *Config, path string) error {
+	if path != "" {
+		var head struct {
+			Profile string `toml:"profile"`
+			Preset  string `toml:"preset"`
+		}
+		if _, err := toml.DecodeFile(path, &head); err != nil {
+			return fmt.Errorf("parsing config %q: %w", path, err)
+		}
+		cfg.Profile, cfg.Preset = head.Profile, head.Preset
+	}
+	if v := os.Getenv("UPS_MQTT_PROFILE"); v != "" {
+		cfg.Profile = v
+	}
+	if v := os.Getenv("UPS_MQTT_PRESET"); v != "" {
+		cfg.Preset = v
+	}
+	if err := apply(cfg, "preset", presets, cfg.Preset); err != nil {
+		return err
+	}
+	return apply(cfg, "profile", profiles, cfg.Profile)
+}
+
+// apply applies the entry of table called name, the value of key, to cfg.
+func apply(cfg *Config, key string, table map[string]func(*Config), name string) error {
+	if name == "" {
+		return nil
+	}
+	fn, ok := table[name]
+	if !ok {
+		return fmt.Errorf("%s must be one of %s, got %q", key, strings.Join(sortedNames(table), ", "), name)
+	}
+	fn(cfg)
+	return nil
+}
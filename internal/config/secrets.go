@@ -0,0 +1,96 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// passwordCommandTimeout bounds a password command, which may have to
+// reach a secrets manager such as Vault.
+const passwordCommandTimeout = 30 * time.Second
+
+// resolveSecrets fills in the passwords that come from a command.
+func resolveSecrets(cfg *Config) error {
+	for _, s := range []struct {
+		key      string
+		command  string
+		password *string
+	}{
+		{"nut.password_command", cfg.NUT.PasswordCommand, &cfg.NUT.Password},
+		{"mqtt.password_command", cfg.MQTT.PasswordCommand, &cfg.MQTT.Password},
+	} {
+		if s.command == "" {
+			continue
+		}
+		pw, err := runPasswordCommand(s.command)
+		if err != nil {
+			return fmt.Errorf("%s: %w", s.key, err)
+		}
+		*s.password = pw
+	}
+	return nil
+}
+
+// runPasswordCommand runs command with sh -c and returns its output less
+// the trailing newline.  A failure includes what the command wrote to
+// stderr, but never its stdout.
+func runPasswordCommand(command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), passwordCommandTimeout)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	cmd.WaitDelay = time.Second
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = fmt.Errorf("timed out after %s", passwordCommandTimeout)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("running %q: %w: %s", command, err, msg)
+		}
+		return "", fmt.Errorf("running %q: %w", command, err)
+	}
+	pw := strings.TrimSuffix(strings.TrimSuffix(stdout.String(), "\n"), "\r")
+	if pw == "" {
+		return "", fmt.Errorf("%q printed no password", command)
+	}
+	return pw, nil
+}
+
+// envReader reads environment variables for applyEnvOverrides.  A variable
+// NAME can also be set as NAME_FILE, the path of a file holding the value
+// less one trailing newline — the usual way Docker and Kubernetes secrets
+// are passed in.  err records the first file that couldn't be read, or the
+// first variable set both ways.
+type envReader struct {
+	err error
+}
+
+// get returns the value of name, or of the file named by name_FILE.
+func (e *envReader) get(name string) string {
+	v, file := os.Getenv(name), os.Getenv(name+"_FILE")
+	if file == "" {
+		return v
+	}
+	if v != "" {
+		e.fail(fmt.Errorf("%s and %s_FILE are mutually exclusive", name, name))
+		return v
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		e.fail(fmt.Errorf("%s_FILE: %w", name, err))
+		return ""
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r")
+}
+
+func (e *envReader) fail(err error) {
+	if e.err == nil {
+		e.err = err
+	}
+}
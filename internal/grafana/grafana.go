@@ -0,0 +1,118 @@
+// Package grafana renders UPS readings in the InfluxDB line protocol and
+// pushes them to a Grafana Live stream, so a Grafana panel can follow the
+// UPS in real time without a datasource in between.
+package grafana
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/metrics"
+	"github.com/sweeney/ups-mqtt/internal/schedule"
+)
+
+// Format renders one line-protocol point for measurement "ups" tagged with
+// ups=label plus labels, such as site or rack, in name order.  Numeric NUT variables become fields with dots turned into
+// underscores (non-numeric ones are skipped); computed metrics are added
+// under their MQTT topic names, with booleans as true/false, leaving out
+// those metrics.unavailable doesn't fill with 0.  Fields are
+// sorted so the output is stable between calls.
+func Format(label string, labels map[string]string, vars map[string]string, m metrics.Metrics, t time.Time) string {
+	fields := make(map[string]string)
+	for name, v := range vars {
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			continue
+		}
+		fields[strings.ReplaceAll(name, ".", "_")] = strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	for name, v := range map[string]float64{
+		"load_watts":                  m.LoadWatts,
+		"battery_runtime_mins":        m.BatteryRuntimeMins,
+		"battery_runtime_hours":       m.BatteryRuntimeHours,
+		"input_voltage_deviation_pct": m.InputVoltageDeviationPct,
+	} {
+		if m.Reported(name) {
+			fields[name] = strconv.FormatFloat(v, 'g', -1, 64)
+		}
+	}
+	fields["on_battery"] = strconv.FormatBool(m.OnBattery)
+	fields["low_battery"] = strconv.FormatBool(m.LowBattery)
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("ups,ups=")
+	b.WriteString(escape(label))
+	tags := make([]string, 0, len(labels))
+	for k := range labels {
+		tags = append(tags, k)
+	}
+	sort.Strings(tags)
+	for _, k := range tags {
+		b.WriteByte(',')
+		b.WriteString(escape(k))
+		b.WriteByte('=')
+		b.WriteString(escape(labels[k]))
+	}
+	for i, name := range names {
+		if i == 0 {
+			b.WriteByte(' ')
+		} else {
+			b.WriteByte(',')
+		}
+		b.WriteString(escape(name))
+		b.WriteByte('=')
+		b.WriteString(fields[name])
+	}
+	fmt.Fprintf(&b, " %d\n", t.UnixNano())
+	return b.String()
+}
+
+// escape backslash-escapes the characters that delimit line-protocol tag
+// values and field keys.
+func escape(s string) string {
+	return strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `).Replace(s)
+}
+
+// Push posts body (line protocol) to the Grafana Live stream
+// stream/{streamID} on the Grafana at baseURL, authenticating with a
+// service account token.
+func Push(ctx context.Context, client *http.Client, baseURL, token, streamID, body string) error {
+	endpoint := fmt.Sprintf("%s/api/live/push/%s", strings.TrimSuffix(baseURL, "/"), url.PathEscape(streamID))
+
+	ctx, cancel := context.WithTimeout(ctx, schedule.CallTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("building grafana live request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("pushing to %s: %w", endpoint, err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("grafana live returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
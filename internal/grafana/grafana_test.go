@@ -0,0 +1,111 @@
+package grafana
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/metrics"
+)
+
+func TestFormat(t *testing.T) {
+	vars := map[string]string{
+		"battery.charge": "100",
+		"ups.status":     "OL",
+		"input.voltage":  "242.0",
+	}
+	m := metrics.Metrics{LoadWatts: 72, OnBattery: true}
+	got := Format("office ups", nil, vars, m, time.Unix(1700000000, 0))
+
+	want := `ups,ups=office\ ups battery_charge=100,battery_runtime_hours=0,battery_runtime_mins=0,` +
+		`input_voltage=242,input_voltage_deviation_pct=0,load_watts=72,low_battery=false,on_battery=true ` +
+		"1700000000000000000\n"
+	if got != want {
+		t.Errorf("Format =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFormat_Labels(t *testing.T) {
+	got := Format("office-ups", map[string]string{"site": "lon 1", "rack": "4"}, nil, metrics.Metrics{}, time.Unix(1700000000, 0))
+	if !strings.HasPrefix(got, `ups,ups=office-ups,rack=4,site=lon\ 1 `) {
+		t.Errorf("Format = %s, want the labels as sorted tags", got)
+	}
+}
+
+func TestEscape(t *testing.T) {
+	if got := escape("a,b=c d"); got != `a\,b\=c\ d` {
+		t.Errorf("escape = %q", got)
+	}
+}
+
+func TestPush(t *testing.T) {
+	var gotMethod, gotPath, gotAuth, gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod, gotPath, gotAuth = r.Method, r.URL.Path, r.Header.Get("Authorization")
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+	}))
+	defer srv.Close()
+
+	if err := Push(context.Background(), srv.Client(), srv.URL+"/", "glsa_secret", "ups-mqtt", "ups x=1\n"); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+	if gotMethod != http.MethodPost {
+		t.Errorf("method = %s, want POST", gotMethod)
+	}
+	if gotPath != "/api/live/push/ups-mqtt" {
+		t.Errorf("path = %s", gotPath)
+	}
+	if gotAuth != "Bearer glsa_secret" {
+		t.Errorf("Authorization = %q", gotAuth)
+	}
+	if gotBody != "ups x=1\n" {
+		t.Errorf("body = %q", gotBody)
+	}
+}
+
+func TestPush_NoToken(t *testing.T) {
+	var gotAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+	}))
+	defer srv.Close()
+
+	if err := Push(context.Background(), srv.Client(), srv.URL, "", "ups", ""); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+	if gotAuth != "" {
+		t.Errorf("Authorization = %q, want none", gotAuth)
+	}
+}
+
+func TestPush_ErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid API key", http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	err := Push(context.Background(), srv.Client(), srv.URL, "bad", "ups", "ups x=1\n")
+	if err == nil || !strings.Contains(err.Error(), "invalid API key") {
+		t.Fatalf("err = %v, want Grafana's message", err)
+	}
+}
+
+func TestPush_Unreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+	if err := Push(context.Background(), http.DefaultClient, url, "t", "ups", ""); err == nil {
+		t.Fatal("expected error for unreachable Grafana")
+	}
+}
+
+func TestPush_BadURL(t *testing.T) {
+	if err := Push(context.Background(), http.DefaultClient, "://bad", "t", "ups", ""); err == nil {
+		t.Fatal("expected error for malformed URL")
+	}
+}
@@ -0,0 +1,66 @@
+package hook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Event is a shell command run when something happens to the UPS, as
+// opposed to a Hook, which runs with every poll and can change it.
+type Event struct {
+	Command string
+	Timeout time.Duration
+}
+
+// Run runs the command with sh -c, with env added to the bridge's own
+// environment, and waits for it to finish.  A non-zero exit or a timeout
+// is an error, with anything the command wrote to stderr included.
+func (e Event) Run(env []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), e.Timeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", e.Command)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stderr = &stderr
+	cmd.WaitDelay = time.Second
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = fmt.Errorf("timed out after %s", e.Timeout)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("running %q: %w: %s", e.Command, err, msg)
+		}
+		return fmt.Errorf("running %q: %w", e.Command, err)
+	}
+	return nil
+}
+
+// Env returns vars as NAME=value environment entries, sorted: each name is
+// upper-cased, prefixed NUT_ and has anything but letters and digits
+// replaced by _, so battery.charge becomes NUT_BATTERY_CHARGE.
+func Env(vars map[string]string) []string {
+	env := make([]string, 0, len(vars))
+	for name, value := range vars {
+		env = append(env, "NUT_"+envName(name)+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+}
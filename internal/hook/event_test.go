@@ -0,0 +1,40 @@
+package hook
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnv(t *testing.T) {
+	got := Env(map[string]string{"battery.charge": "100", "ups.status": "OB DISCHRG", "driver.parameter.pollinterval": "2"})
+	want := []string{"NUT_BATTERY_CHARGE=100", "NUT_DRIVER_PARAMETER_POLLINTERVAL=2", "NUT_UPS_STATUS=OB DISCHRG"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Env = %q, want %q", got, want)
+	}
+}
+
+func TestEvent_Run(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	e := Event{Command: `echo "$NUT_UPS_STATUS" > ` + out, Timeout: 5 * time.Second}
+	if err := e.Run([]string{"NUT_UPS_STATUS=OB"}); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(out); err != nil || string(data) != "OB\n" {
+		t.Errorf("command saw %q, %v; want OB", data, err)
+	}
+}
+
+func TestEvent_RunErrors(t *testing.T) {
+	err := Event{Command: "echo broken >&2; exit 3", Timeout: 5 * time.Second}.Run(nil)
+	if err == nil || !strings.Contains(err.Error(), "exit status 3: broken") {
+		t.Errorf("err = %v, want the exit status and stderr", err)
+	}
+	err = Event{Command: "sleep 5", Timeout: 50 * time.Millisecond}.Run(nil)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("err = %v, want a timeout", err)
+	}
+}
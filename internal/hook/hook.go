@@ -0,0 +1,126 @@
+// Package hook runs a user-supplied program once per poll so it can rewrite
+// the variables, add computed values or veto the poll's publishes.  The
+// program gets the poll as JSON on stdin and answers with JSON on stdout,
+// so custom processing can be written in any language without rebuilding
+// the bridge.  Lua scripts can instead run in the embedded interpreter
+// (see Script), with no interpreter needed on the host.
+package hook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/plugin"
+	"github.com/sweeney/ups-mqtt/internal/schedule"
+)
+
+// Input is what the program reads from stdin.
+type Input struct {
+	Timestamp string            `json:"timestamp"`
+	UPSName   string            `json:"ups_name"`
+	Variables map[string]string `json:"variables"`
+}
+
+// Output is what the program writes to stdout.  Every field is optional,
+// and empty output leaves the poll unchanged.
+type Output struct {
+	// Variables, when present, replaces the poll's variables before
+	// metrics are computed and anything is published.
+	Variables map[string]string `json:"variables,omitempty"`
+
+	// Computed adds computed/<name> topics alongside the built-in metrics.
+	Computed map[string]string `json:"computed,omitempty"`
+
+	// Veto skips publishing this poll altogether.
+	Veto bool `json:"veto,omitempty"`
+}
+
+// Hook is the program to run.
+type Hook struct {
+	Command string
+	Args    []string
+	Timeout time.Duration
+}
+
+// Run feeds in to the program and parses its reply.  A non-zero exit, a
+// timeout or unparseable output is an error, with anything the program
+// wrote to stderr included.
+func (h Hook) Run(in Input) (Output, error) {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return Output{}, fmt.Errorf("marshalling hook input: %w", err)
+	}
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = schedule.CallTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, h.Command, h.Args...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	cmd.WaitDelay = time.Second
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = fmt.Errorf("timed out after %s", timeout)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return Output{}, fmt.Errorf("running %s: %w: %s", h.Command, err, msg)
+		}
+		return Output{}, fmt.Errorf("running %s: %w", h.Command, err)
+	}
+
+	var out Output
+	if len(bytes.TrimSpace(stdout.Bytes())) == 0 {
+		return out, nil
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return Output{}, fmt.Errorf("parsing %s output: %w", h.Command, err)
+	}
+	if err := checkOutput(out); err != nil {
+		return Output{}, fmt.Errorf("%s: %w", h.Command, err)
+	}
+	return out, nil
+}
+
+// Plugin is a Hook kept running between polls instead of started for each
+// one: every poll is a "process" call (see internal/plugin) with the Input
+// as params and the Output as result.  It suits interpreters that are slow
+// to start, and programs that keep state of their own.
+type Plugin struct {
+	Process *plugin.Process
+}
+
+// Run hands in to the plugin and returns its reply.
+func (h *Plugin) Run(in Input) (Output, error) {
+	var out Output
+	if err := h.Process.Call("process", in, &out); err != nil {
+		return Output{}, err
+	}
+	if err := checkOutput(out); err != nil {
+		return Output{}, fmt.Errorf("%s: %w", h.Process.Command, err)
+	}
+	return out, nil
+}
+
+// Close stops the plugin.
+func (h *Plugin) Close() error {
+	return h.Process.Close()
+}
+
+// checkOutput rejects computed names that can't be published as a topic.
+func checkOutput(out Output) error {
+	for name := range out.Computed {
+		if name == "" || strings.ContainsAny(name, "+#") {
+			return fmt.Errorf("invalid computed name %q", name)
+		}
+	}
+	return nil
+}
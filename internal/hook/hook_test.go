@@ -0,0 +1,154 @@
+package hook
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/plugin"
+)
+
+// TestHelperProcess is the hook program: the test binary re-runs itself
+// with HOOK_HELPER set to the behaviour wanted.
+func TestHelperProcess(t *testing.T) {
+	mode := os.Getenv("HOOK_HELPER")
+	if mode == "" {
+		return
+	}
+	if mode == "plugin" {
+		servePlugin()
+	}
+	var in Input
+	if err := json.NewDecoder(os.Stdin).Decode(&in); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	switch mode {
+	case "transform":
+		in.Variables["battery.charge"] = "99"
+		json.NewEncoder(os.Stdout).Encode(Output{ //nolint:errcheck
+			Variables: in.Variables,
+			Computed:  map[string]string{"site": in.UPSName + "@rack4"},
+		})
+	case "veto":
+		fmt.Print(`{"veto":true}`)
+	case "silent":
+	case "garbage":
+		fmt.Print("not json")
+	case "badname":
+		fmt.Print(`{"computed":{"a/#":"1"}}`)
+	case "fail":
+		fmt.Fprintln(os.Stderr, "script error on line 3")
+		os.Exit(1)
+	case "hang":
+		time.Sleep(time.Minute)
+	}
+	os.Exit(0)
+}
+
+// servePlugin answers "process" calls, counting polls in a computed value
+// to show the process is kept between them.
+func servePlugin() {
+	sc := bufio.NewScanner(os.Stdin)
+	for n := 1; sc.Scan(); n++ {
+		var req struct {
+			Params Input `json:"params"`
+		}
+		json.Unmarshal(sc.Bytes(), &req) //nolint:errcheck
+		out := Output{Computed: map[string]string{"polls": fmt.Sprint(n)}}
+		if req.Params.Variables["ups.status"] == "CAL" {
+			out = Output{Computed: map[string]string{"+": "x"}}
+		}
+		json.NewEncoder(os.Stdout).Encode(map[string]Output{"result": out}) //nolint:errcheck
+	}
+	os.Exit(0)
+}
+
+func helper(t *testing.T, mode string) Hook {
+	t.Setenv("HOOK_HELPER", mode)
+	return Hook{Command: os.Args[0], Args: []string{"-test.run=TestHelperProcess"}, Timeout: 10 * time.Second}
+}
+
+var input = Input{
+	Timestamp: "2026-03-01T12:00:00Z",
+	UPSName:   "cyberpower",
+	Variables: map[string]string{"ups.status": "OL", "battery.charge": "100"},
+}
+
+func TestRun_Transform(t *testing.T) {
+	out, err := helper(t, "transform").Run(input)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	want := Output{
+		Variables: map[string]string{"ups.status": "OL", "battery.charge": "99"},
+		Computed:  map[string]string{"site": "cyberpower@rack4"},
+	}
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("got %+v, want %+v", out, want)
+	}
+}
+
+func TestRun_Veto(t *testing.T) {
+	out, err := helper(t, "veto").Run(input)
+	if err != nil || !out.Veto {
+		t.Errorf("got %+v, %v; want a veto", out, err)
+	}
+}
+
+func TestRun_EmptyOutputChangesNothing(t *testing.T) {
+	out, err := helper(t, "silent").Run(input)
+	if err != nil || !reflect.DeepEqual(out, Output{}) {
+		t.Errorf("got %+v, %v; want an empty Output", out, err)
+	}
+}
+
+func TestRun_Errors(t *testing.T) {
+	for mode, want := range map[string]string{
+		"garbage": "parsing",
+		"badname": `invalid computed name "a/#"`,
+		"fail":    "script error on line 3",
+	} {
+		if _, err := helper(t, mode).Run(input); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: err = %v, want it to mention %q", mode, err, want)
+		}
+	}
+}
+
+func TestRun_Timeout(t *testing.T) {
+	h := helper(t, "hang")
+	h.Timeout = 50 * time.Millisecond
+	if _, err := h.Run(input); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("err = %v, want a timeout", err)
+	}
+}
+
+func TestRun_MissingProgram(t *testing.T) {
+	if _, err := (Hook{Command: "/nonexistent/hook"}).Run(input); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestPlugin_KeptBetweenPolls(t *testing.T) {
+	t.Setenv("HOOK_HELPER", "plugin")
+	h := &Plugin{Process: &plugin.Process{Command: os.Args[0], Args: []string{"-test.run=TestHelperProcess"}, Timeout: 10 * time.Second}}
+	defer h.Close() //nolint:errcheck
+	for want := 1; want <= 2; want++ {
+		out, err := h.Run(input)
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		if got := out.Computed["polls"]; got != fmt.Sprint(want) {
+			t.Errorf("poll %d: computed polls = %q", want, got)
+		}
+	}
+	cal := Input{Variables: map[string]string{"ups.status": "CAL"}}
+	if _, err := h.Run(cal); err == nil || !strings.Contains(err.Error(), "invalid computed name") {
+		t.Errorf("err = %v, want an invalid name", err)
+	}
+}
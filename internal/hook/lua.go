@@ -0,0 +1,165 @@
+package hook
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+
+	"github.com/sweeney/ups-mqtt/internal/schedule"
+)
+
+// Script is a hook written in Lua and run by the interpreter embedded in
+// the bridge, so it needs nothing installed on the host.  The file is
+// loaded with the first poll and must define a global function
+//
+//	function process(input) ... end
+//
+// which is called once per poll with the Input as a table
+// ({timestamp=…, ups_name=…, variables={…}}) and returns a table shaped
+// like Output, or nil to leave the poll unchanged.  The state is kept
+// between polls, so globals can carry values from one poll to the next.
+// Only the base, table, string and math libraries are loaded.
+type Script struct {
+	Path    string
+	Timeout time.Duration
+
+	state *lua.LState
+}
+
+// Run calls process with in and converts its result.  A script that fails
+// to load, raises an error or outlives Timeout is an error; after a timeout
+// the state is discarded and the script loaded afresh on the next poll.
+func (s *Script) Run(in Input) (Output, error) {
+	if s.state == nil {
+		if err := s.load(); err != nil {
+			return Output{}, err
+		}
+	}
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = schedule.CallTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	L := s.state
+	L.SetContext(ctx)
+	defer L.RemoveContext()
+	err := L.CallByParam(lua.P{Fn: L.GetGlobal("process"), NRet: 1, Protect: true}, inputTable(L, in))
+	if err != nil {
+		if ctx.Err() != nil {
+			s.Close() //nolint:errcheck
+			return Output{}, fmt.Errorf("running %s: timed out after %s", s.Path, timeout)
+		}
+		return Output{}, fmt.Errorf("running %s: %w", s.Path, err)
+	}
+	ret := L.Get(-1)
+	L.Pop(1)
+
+	out, err := outputFromLua(ret)
+	if err != nil {
+		return Output{}, fmt.Errorf("%s: %w", s.Path, err)
+	}
+	if err := checkOutput(out); err != nil {
+		return Output{}, fmt.Errorf("%s: %w", s.Path, err)
+	}
+	return out, nil
+}
+
+// Close releases the interpreter.
+func (s *Script) Close() error {
+	if s.state != nil {
+		s.state.Close()
+		s.state = nil
+	}
+	return nil
+}
+
+// load starts an interpreter and runs the script's top level.
+func (s *Script) load() error {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	if err := L.DoFile(s.Path); err != nil {
+		L.Close()
+		return fmt.Errorf("loading %s: %w", s.Path, err)
+	}
+	if L.GetGlobal("process").Type() != lua.LTFunction {
+		L.Close()
+		return fmt.Errorf("loading %s: no process function defined", s.Path)
+	}
+	s.state = L
+	return nil
+}
+
+// inputTable converts in to the table process is called with.
+func inputTable(L *lua.LState, in Input) *lua.LTable {
+	vars := L.NewTable()
+	for name, value := range in.Variables {
+		vars.RawSetString(name, lua.LString(value))
+	}
+	t := L.NewTable()
+	t.RawSetString("timestamp", lua.LString(in.Timestamp))
+	t.RawSetString("ups_name", lua.LString(in.UPSName))
+	t.RawSetString("variables", vars)
+	return t
+}
+
+// outputFromLua converts what process returned.  Variable and computed
+// values may be strings, numbers or booleans; nil means no change.
+func outputFromLua(v lua.LValue) (Output, error) {
+	var out Output
+	if v == lua.LNil {
+		return out, nil
+	}
+	t, ok := v.(*lua.LTable)
+	if !ok {
+		return out, fmt.Errorf("process returned a %s, want a table or nil", v.Type())
+	}
+	var err error
+	if out.Variables, err = stringMap(t.RawGetString("variables"), "variables"); err != nil {
+		return Output{}, err
+	}
+	if out.Computed, err = stringMap(t.RawGetString("computed"), "computed"); err != nil {
+		return Output{}, err
+	}
+	out.Veto = lua.LVAsBool(t.RawGetString("veto"))
+	return out, nil
+}
+
+// stringMap converts a table of scalars; nil gives a nil map.
+func stringMap(v lua.LValue, field string) (map[string]string, error) {
+	if v == lua.LNil {
+		return nil, nil
+	}
+	t, ok := v.(*lua.LTable)
+	if !ok {
+		return nil, fmt.Errorf("%s is a %s, want a table", field, v.Type())
+	}
+	m := map[string]string{}
+	var err error
+	t.ForEach(func(key, value lua.LValue) {
+		switch value.Type() {
+		case lua.LTString, lua.LTNumber, lua.LTBool:
+			m[key.String()] = value.String()
+		default:
+			if err == nil {
+				err = fmt.Errorf("%s.%s is a %s, want a string, number or boolean", field, key, value.Type())
+			}
+		}
+	})
+	return m, err
+}
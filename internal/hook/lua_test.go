@@ -0,0 +1,96 @@
+package hook
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// script writes src to a file and returns a Script for it.
+func script(t *testing.T, src string) *Script {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hook.lua")
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := &Script{Path: path, Timeout: time.Second}
+	t.Cleanup(func() { s.Close() }) //nolint:errcheck
+	return s
+}
+
+func TestScript_Transform(t *testing.T) {
+	s := script(t, `
+polls = 0
+function process(input)
+  polls = polls + 1
+  local vars = input.variables
+  vars["battery.charge"] = tonumber(vars["battery.charge"]) - 1
+  return {variables = vars, computed = {site = input.ups_name .. "@rack4", polls = polls, mains = true}}
+end`)
+	for want := 1; want <= 2; want++ {
+		out, err := s.Run(input)
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		exp := Output{
+			Variables: map[string]string{"ups.status": "OL", "battery.charge": "99"},
+			Computed:  map[string]string{"site": "cyberpower@rack4", "polls": fmt.Sprint(want), "mains": "true"},
+		}
+		if !reflect.DeepEqual(out, exp) {
+			t.Errorf("poll %d: got %+v, want %+v", want, out, exp)
+		}
+	}
+}
+
+func TestScript_VetoAndNil(t *testing.T) {
+	s := script(t, `function process(input)
+  if input.variables["ups.status"] == "OL" then return nil end
+  return {veto = true}
+end`)
+	if out, err := s.Run(input); err != nil || !reflect.DeepEqual(out, Output{}) {
+		t.Errorf("got %+v, %v; want an empty Output", out, err)
+	}
+	ob := Input{UPSName: "cyberpower", Variables: map[string]string{"ups.status": "OB"}}
+	if out, err := s.Run(ob); err != nil || !out.Veto {
+		t.Errorf("got %+v, %v; want a veto", out, err)
+	}
+}
+
+func TestScript_Errors(t *testing.T) {
+	for src, want := range map[string]string{
+		`function process(`:                    "loading",
+		`x = 1`:                                "no process function",
+		`function process() error("boom") end`: "boom",
+		`function process() return 3 end`:      "want a table",
+		`function process() return {computed = {x = {}}} end`:      "computed.x is a table",
+		`function process() return {computed = {["a/#"] = 1}} end`: `invalid computed name "a/#"`,
+		`function process() return {variables = "all"} end`:        "variables is a string",
+		`function process() os.execute("true") return nil end`:     "non-table object",
+	} {
+		if _, err := script(t, src).Run(input); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: err = %v, want it to mention %q", src, err, want)
+		}
+	}
+}
+
+func TestScript_Timeout(t *testing.T) {
+	s := script(t, `function process() while true do end end`)
+	s.Timeout = 50 * time.Millisecond
+	if _, err := s.Run(input); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("err = %v, want a timeout", err)
+	}
+	if s.state != nil {
+		t.Error("state should be discarded after a timeout")
+	}
+}
+
+func TestScript_MissingFile(t *testing.T) {
+	s := &Script{Path: "/nonexistent/hook.lua"}
+	if _, err := s.Run(input); err == nil {
+		t.Error("expected error")
+	}
+}
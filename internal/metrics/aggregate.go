@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"math"
+	"slices"
+)
+
+// Aggregate combines the metrics of several UPSes for automations that act
+// on a whole rack rather than on one UPS.
+type Aggregate struct {
+	// UPSes is how many UPSes are polled and Reporting how many of them
+	// the other fields cover: those whose last poll succeeded.
+	UPSes     int `json:"ups_count"`
+	Reporting int `json:"reporting"`
+
+	// LoadWatts is the sum of load_watts over the UPSes that report it,
+	// and MinRuntimeMins the lowest battery_runtime_mins.  Each is left
+	// out when no UPS reports it.
+	LoadWatts      *float64 `json:"total_load_watts,omitempty"`
+	MinRuntimeMins *float64 `json:"min_runtime_mins,omitempty"`
+
+	// WorstStatus is the status_display of the UPS in the worst state,
+	// WorstUPS: off, then low battery, on battery, bypass, unknown and
+	// mains, the first by name among equals.
+	WorstStatus string `json:"worst_status"`
+	WorstUPS    string `json:"worst_ups"`
+
+	AnyOnBattery  bool `json:"any_on_battery"`
+	AnyLowBattery bool `json:"any_low_battery"`
+}
+
+// Combine aggregates ms, the metrics of the reporting UPSes by name, out of
+// upses polled in all.
+func Combine(upses int, ms map[string]Metrics) Aggregate {
+	a := Aggregate{UPSes: upses, Reporting: len(ms)}
+	names := make([]string, 0, len(ms))
+	for name := range ms {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	worst := -1
+	for _, name := range names {
+		m := ms[name]
+		if m.Computed("load_watts") {
+			a.LoadWatts = ptr(value(a.LoadWatts) + m.LoadWatts)
+		}
+		if m.Computed("battery_runtime_mins") && (a.MinRuntimeMins == nil || m.BatteryRuntimeMins < *a.MinRuntimeMins) {
+			a.MinRuntimeMins = ptr(m.BatteryRuntimeMins)
+		}
+		a.AnyOnBattery = a.AnyOnBattery || m.OnBattery
+		a.AnyLowBattery = a.AnyLowBattery || m.LowBattery
+		if s := severity(m); s > worst {
+			worst, a.WorstStatus, a.WorstUPS = s, m.StatusDisplay, name
+		}
+	}
+	if a.LoadWatts != nil {
+		a.LoadWatts = ptr(math.Round(*a.LoadWatts*10) / 10)
+	}
+	return a
+}
+
+// severity ranks how badly off a UPS is, for Aggregate.WorstStatus.
+func severity(m Metrics) int {
+	switch {
+	case m.PowerSource == PowerSourceOff:
+		return 5
+	case m.LowBattery:
+		return 4
+	case m.PowerSource == PowerSourceBattery:
+		return 3
+	case m.PowerSource == PowerSourceBypass:
+		return 2
+	case m.PowerSource == PowerSourceUnknown:
+		return 1
+	}
+	return 0
+}
+
+func ptr(f float64) *float64 { return &f }
+
+func value(f *float64) float64 {
+	if f == nil {
+		return 0
+	}
+	return *f
+}
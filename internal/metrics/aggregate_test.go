@@ -0,0 +1,59 @@
+package metrics
+
+import "testing"
+
+func TestCombine(t *testing.T) {
+	ms := map[string]Metrics{
+		"rack": Compute(map[string]string{"ups.status": "OL", "ups.load": "50", "ups.realpower.nominal": "900", "battery.runtime": "1800"}),
+		"desk": Compute(map[string]string{"ups.status": "OB", "ups.load": "20", "ups.realpower.nominal": "500", "battery.runtime": "600"}),
+		"nas":  Compute(map[string]string{"ups.status": "OB"}),
+	}
+	a := Combine(4, ms)
+	if a.UPSes != 4 || a.Reporting != 3 {
+		t.Errorf("UPSes, Reporting = %d, %d; want 4, 3", a.UPSes, a.Reporting)
+	}
+	if a.LoadWatts == nil || *a.LoadWatts != 550 {
+		t.Errorf("LoadWatts = %v, want 550", a.LoadWatts)
+	}
+	if a.MinRuntimeMins == nil || *a.MinRuntimeMins != 10 {
+		t.Errorf("MinRuntimeMins = %v, want 10", a.MinRuntimeMins)
+	}
+	if !a.AnyOnBattery || a.AnyLowBattery {
+		t.Errorf("AnyOnBattery, AnyLowBattery = %v, %v; want true, false", a.AnyOnBattery, a.AnyLowBattery)
+	}
+	if a.WorstUPS != "desk" || a.WorstStatus != ms["desk"].StatusDisplay {
+		t.Errorf("worst = %s (%q), want desk, the first on battery by name", a.WorstUPS, a.WorstStatus)
+	}
+
+	ms["nas"] = Compute(map[string]string{"ups.status": "OB LB"})
+	if a := Combine(3, ms); a.WorstUPS != "nas" || !a.AnyLowBattery {
+		t.Errorf("worst = %s, AnyLowBattery = %v; want nas on low battery", a.WorstUPS, a.AnyLowBattery)
+	}
+}
+
+func TestCombine_NoneReported(t *testing.T) {
+	a := Combine(2, map[string]Metrics{"rack": Compute(map[string]string{"ups.status": "OL"})})
+	if a.LoadWatts != nil || a.MinRuntimeMins != nil {
+		t.Errorf("LoadWatts, MinRuntimeMins = %v, %v; want both left out", a.LoadWatts, a.MinRuntimeMins)
+	}
+	if a.WorstUPS != "rack" || a.AnyOnBattery {
+		t.Errorf("Combine = %+v", a)
+	}
+	if a := Combine(2, nil); a.Reporting != 0 || a.WorstUPS != "" {
+		t.Errorf("Combine with no reporting UPS = %+v", a)
+	}
+}
+
+func TestCombine_Severity(t *testing.T) {
+	// Each status beats the ones after it.
+	statuses := []string{"OFF", "OB LB", "OB", "OL BYPASS", "", "OL"}
+	for i := range statuses[:len(statuses)-1] {
+		ms := map[string]Metrics{
+			"a": Compute(map[string]string{"ups.status": statuses[i+1]}),
+			"b": Compute(map[string]string{"ups.status": statuses[i]}),
+		}
+		if a := Combine(2, ms); a.WorstUPS != "b" {
+			t.Errorf("%q vs %q: worst = %s, want b", statuses[i], statuses[i+1], a.WorstUPS)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package metrics
+
+import "math"
+
+// ApparentPower is the load in volt-amperes and, when the UPS measures its
+// real power output too, the power factor of the load.
+type ApparentPower struct {
+	LoadVA      float64
+	PowerFactor *float64
+}
+
+// ComputeApparentPower returns ups.power, or ups.load × ups.power.nominal
+// when the UPS doesn't measure it, with the power factor ups.realpower /
+// that.  The power factor needs a measured ups.realpower: one worked out
+// from ups.load as well would only be the ratio of the two ratings.  ok is
+// false when the VA can't be worked out.
+func ComputeApparentPower(vars map[string]string) (a ApparentPower, ok bool) {
+	va, ok := parseFloat(vars["ups.power"])
+	if !ok {
+		load, loadOK := parseFloat(vars["ups.load"])
+		nominal, nominalOK := parseFloat(vars["ups.power.nominal"])
+		if !loadOK || !nominalOK {
+			return ApparentPower{}, false
+		}
+		va = load / 100 * nominal
+	}
+	a.LoadVA = math.Round(va*100) / 100
+	if watts, ok := parseFloat(vars["ups.realpower"]); ok && va > 0 {
+		a.PowerFactor = ptr(math.Round(watts/va*100) / 100)
+	}
+	return a, true
+}
+
+// AsTopicMap returns load_va, and power_factor when known, as computed/
+// topic-name → payload pairs.
+func (a ApparentPower) AsTopicMap() map[string]string {
+	topics := map[string]string{"load_va": formatFloat(a.LoadVA)}
+	if a.PowerFactor != nil {
+		topics["power_factor"] = formatFloat(*a.PowerFactor)
+	}
+	return topics
+}
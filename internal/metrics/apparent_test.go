@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestComputeApparentPower(t *testing.T) {
+	cases := []struct {
+		name   string
+		vars   map[string]string
+		want   map[string]string
+		wantOK bool
+	}{
+		{"from the rating", map[string]string{"ups.load": "8", "ups.power.nominal": "1500"},
+			map[string]string{"load_va": "120"}, true},
+		{"with real power", map[string]string{"ups.load": "8", "ups.power.nominal": "1500", "ups.realpower": "72"},
+			map[string]string{"load_va": "120", "power_factor": "0.6"}, true},
+		{"measured", map[string]string{"ups.power": "133", "ups.load": "8", "ups.power.nominal": "1500", "ups.realpower": "120"},
+			map[string]string{"load_va": "133", "power_factor": "0.9"}, true},
+		{"no load", map[string]string{"ups.load": "0", "ups.power.nominal": "1500", "ups.realpower": "0"},
+			map[string]string{"load_va": "0"}, true},
+		{"no rating", map[string]string{"ups.load": "8", "ups.realpower": "72"}, nil, false},
+		{"no load reported", map[string]string{"ups.power.nominal": "1500"}, nil, false},
+	}
+	for _, c := range cases {
+		a, ok := ComputeApparentPower(c.vars)
+		if ok != c.wantOK {
+			t.Errorf("%s: ok = %v, want %v", c.name, ok, c.wantOK)
+			continue
+		}
+		if got := a.AsTopicMap(); ok && !maps.Equal(got, c.want) {
+			t.Errorf("%s: AsTopicMap = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
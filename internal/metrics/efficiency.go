@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+)
+
+// CurvePoint is one point of a UPS efficiency curve, typically read off the
+// manufacturer's datasheet.
+type CurvePoint struct {
+	LoadPct       float64
+	EfficiencyPct float64
+}
+
+// Efficiency is the estimated conversion efficiency of the UPS itself and
+// the power it dissipates doing so.
+type Efficiency struct {
+	Pct         float64 `json:"efficiency_pct"`
+	WastedWatts float64 `json:"wasted_watts"`
+}
+
+// AsTopicMap returns each field as a computed/ topic-name → payload pair.
+func (e Efficiency) AsTopicMap() map[string]string {
+	return map[string]string{
+		"efficiency_pct": formatFloat(e.Pct),
+		"wasted_watts":   formatFloat(e.WastedWatts),
+	}
+}
+
+// ComputeEfficiency estimates efficiency while the UPS is on mains.  When
+// the UPS reports input.realpower, efficiency is measured against the
+// output power (ups.realpower, or load_watts as ComputeWith derives it from
+// opts); otherwise it is interpolated from curve at ups.load.  ok is false on battery, when the data for neither
+// method is available, or when the readings are implausible (output above
+// input).
+func ComputeEfficiency(vars map[string]string, curve []CurvePoint, opts Options) (e Efficiency, ok bool) {
+	if hasStatusToken(vars["ups.status"], "OB") {
+		return Efficiency{}, false
+	}
+	output, ok := outputWatts(vars, opts)
+	if !ok {
+		return Efficiency{}, false
+	}
+	if input, ok := parseFloat(vars["input.realpower"]); ok {
+		if input <= 0 || output > input {
+			return Efficiency{}, false
+		}
+		return Efficiency{
+			Pct:         math.Round(output/input*100*100) / 100,
+			WastedWatts: math.Round((input-output)*100) / 100,
+		}, true
+	}
+	load, ok := parseFloat(vars["ups.load"])
+	if !ok || len(curve) == 0 {
+		return Efficiency{}, false
+	}
+	pct := interpolate(curve, load)
+	if pct <= 0 {
+		return Efficiency{}, false
+	}
+	return Efficiency{
+		Pct:         math.Round(pct*100) / 100,
+		WastedWatts: math.Round((output/pct*100-output)*100) / 100,
+	}, true
+}
+
+// outputWatts returns ups.realpower, falling back to load_watts.
+func outputWatts(vars map[string]string, opts Options) (float64, bool) {
+	if w, ok := parseFloat(vars["ups.realpower"]); ok {
+		return w, true
+	}
+	w, _, ok := loadWatts(vars, opts)
+	return w, ok
+}
+
+// interpolate returns the efficiency at load along curve, linearly between
+// points and flat beyond the first and last.
+func interpolate(curve []CurvePoint, load float64) float64 {
+	pts := append([]CurvePoint(nil), curve...)
+	sort.Slice(pts, func(i, j int) bool { return pts[i].LoadPct < pts[j].LoadPct })
+	if load <= pts[0].LoadPct {
+		return pts[0].EfficiencyPct
+	}
+	for i := 1; i < len(pts); i++ {
+		if load <= pts[i].LoadPct {
+			a, b := pts[i-1], pts[i]
+			return a.EfficiencyPct + (load-a.LoadPct)/(b.LoadPct-a.LoadPct)*(b.EfficiencyPct-a.EfficiencyPct)
+		}
+	}
+	return pts[len(pts)-1].EfficiencyPct
+}
@@ -0,0 +1,84 @@
+package metrics
+
+import "testing"
+
+var testCurve = []CurvePoint{
+	{LoadPct: 100, EfficiencyPct: 95},
+	{LoadPct: 10, EfficiencyPct: 80},
+	{LoadPct: 50, EfficiencyPct: 92},
+}
+
+func TestComputeEfficiency_Measured(t *testing.T) {
+	vars := map[string]string{"ups.status": "OL", "input.realpower": "80", "ups.realpower": "72"}
+	e, ok := ComputeEfficiency(vars, testCurve, Options{})
+	if !ok || e.Pct != 90 || e.WastedWatts != 8 {
+		t.Errorf("ComputeEfficiency = %+v, %v; want 90%%, 8 W", e, ok)
+	}
+}
+
+func TestComputeEfficiency_MeasuredFromLoadWatts(t *testing.T) {
+	vars := map[string]string{"ups.status": "OL", "input.realpower": "80", "ups.load": "8", "ups.realpower.nominal": "900"}
+	e, ok := ComputeEfficiency(vars, nil, Options{})
+	if !ok || e.Pct != 90 || e.WastedWatts != 8 {
+		t.Errorf("ComputeEfficiency = %+v, %v; want 90%%, 8 W", e, ok)
+	}
+}
+
+func TestComputeEfficiency_MeasuredFromEstimatedLoadWatts(t *testing.T) {
+	vars := map[string]string{"ups.status": "OL", "input.realpower": "80", "ups.load": "8", "ups.power.nominal": "1500"}
+	e, ok := ComputeEfficiency(vars, nil, Options{PowerFactor: 0.6})
+	if !ok || e.Pct != 90 || e.WastedWatts != 8 {
+		t.Errorf("ComputeEfficiency = %+v, %v; want 90%%, 8 W", e, ok)
+	}
+	if _, ok := ComputeEfficiency(vars, nil, Options{}); ok {
+		t.Error("ComputeEfficiency without a power factor should be unavailable")
+	}
+}
+
+func TestComputeEfficiency_Curve(t *testing.T) {
+	cases := []struct {
+		load      string
+		wantPct   float64
+		wantWaste float64
+	}{
+		{"5", 80, 22.5},   // below the first point: flat
+		{"30", 86, 14.65}, // halfway between 10 % and 50 %
+		{"50", 92, 7.83},  // on a point
+		{"120", 95, 4.74}, // beyond the last point: flat
+	}
+	for _, c := range cases {
+		vars := map[string]string{"ups.status": "OL CHRG", "ups.load": c.load, "ups.realpower": "90"}
+		e, ok := ComputeEfficiency(vars, testCurve, Options{})
+		if !ok || !nearlyEqual(e.Pct, c.wantPct) || !nearlyEqual(e.WastedWatts, c.wantWaste) {
+			t.Errorf("load %s: ComputeEfficiency = %+v, %v; want %v%%, %v W", c.load, e, ok, c.wantPct, c.wantWaste)
+		}
+	}
+}
+
+func TestComputeEfficiency_Unavailable(t *testing.T) {
+	cases := []struct {
+		name  string
+		vars  map[string]string
+		curve []CurvePoint
+	}{
+		{"on battery", map[string]string{"ups.status": "OB DISCHRG", "input.realpower": "0", "ups.realpower": "72"}, testCurve},
+		{"no output power", map[string]string{"ups.status": "OL", "input.realpower": "80", "ups.load": "8"}, testCurve},
+		{"zero input", map[string]string{"ups.status": "OL", "input.realpower": "0", "ups.realpower": "72"}, testCurve},
+		{"output above input", map[string]string{"ups.status": "OL", "input.realpower": "70", "ups.realpower": "72"}, testCurve},
+		{"no input, no load", map[string]string{"ups.status": "OL", "ups.realpower": "72"}, testCurve},
+		{"no curve", map[string]string{"ups.status": "OL", "ups.realpower": "72", "ups.load": "8"}, nil},
+		{"zero on curve", map[string]string{"ups.status": "OL", "ups.realpower": "72", "ups.load": "8"}, []CurvePoint{{LoadPct: 50}}},
+	}
+	for _, c := range cases {
+		if e, ok := ComputeEfficiency(c.vars, c.curve, Options{}); ok {
+			t.Errorf("%s: ComputeEfficiency = %+v, want unavailable", c.name, e)
+		}
+	}
+}
+
+func TestEfficiency_AsTopicMap(t *testing.T) {
+	got := Efficiency{Pct: 90.5, WastedWatts: 8}.AsTopicMap()
+	if got["efficiency_pct"] != "90.5" || got["wasted_watts"] != "8" || len(got) != 2 {
+		t.Errorf("AsTopicMap = %v", got)
+	}
+}
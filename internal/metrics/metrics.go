@@ -4,7 +4,9 @@
 package metrics
 
 import (
+	"encoding/json"
 	"math"
+	"slices"
 	"strconv"
 	"strings"
 )
@@ -22,8 +24,83 @@ type Metrics struct {
 	LowBattery               bool    `json:"low_battery"`
 	StatusDisplay            string  `json:"status_display"`
 	InputVoltageDeviationPct float64 `json:"input_voltage_deviation_pct"`
+
+	// PowerSource is one of the PowerSource* values: where the load is
+	// being powered from, for automations that only need one compare.
+	PowerSource string `json:"power_source"`
+
+	// LoadWattsEstimated marks LoadWatts as derived from the VA rating and
+	// a configured power factor (see Options).  It only appears in the
+	// state JSON, not as a computed/ topic.
+	LoadWattsEstimated bool `json:"load_watts_estimated,omitempty"`
+
+	// StatusShort is the raw status tokens joined with "/", e.g. "OB/LB",
+	// for displays with strict length limits.  It is only set when
+	// Options.StatusShort is.
+	StatusShort string `json:"status_short,omitempty"`
+
+	// unknown has bit i set when nullable[i] couldn't be computed, and
+	// unavailable is Options.Unavailable.
+	unknown     uint8
+	unavailable string
 }
 
+// nullable are the metrics that can't be computed when the variables they
+// derive from are missing; the others always have a value.
+var nullable = []string{"load_watts", "battery_runtime_mins", "battery_runtime_hours", "input_voltage_deviation_pct"}
+
+// Options tunes Compute for UPSes that under-report.
+type Options struct {
+	// PowerFactor estimates the real power rating as ups.power.nominal (VA)
+	// × PowerFactor when the UPS doesn't report ups.realpower.nominal.
+	// Zero disables the estimate, leaving LoadWatts 0.
+	PowerFactor float64
+
+	// StatusSeparator joins the decoded tokens of StatusDisplay; empty
+	// means ", ".  StatusCase is StatusCaseTitle (the default when empty),
+	// StatusCaseUpper or StatusCaseLower.
+	StatusSeparator string
+	StatusCase      string
+
+	// StatusShort also sets Metrics.StatusShort.
+	StatusShort bool
+
+	// LowThresholds also sets LowBattery while on battery once
+	// battery.charge or battery.runtime falls to the UPS's own
+	// battery.charge.low or battery.runtime.low, for UPSes that raise LB
+	// late or not at all.
+	LowThresholds bool
+
+	// Unavailable is what is published for a metric that can't be computed:
+	// one of the Unavailable* values, empty meaning UnavailableZero.
+	Unavailable string
+}
+
+// Values of Options.Unavailable.
+const (
+	UnavailableZero = "zero" // 0, as if it had been computed
+	UnavailableSkip = "skip" // nothing: the metric is left out
+	UnavailableNull = "null" // "unknown" on computed/ topics, null in JSON
+	UnavailableFlag = "flag" // 0, with a {name}_valid companion saying whether it's real
+)
+
+// Values of PowerSource.  PowerSourceUnknown is used when ups.status
+// carries none of OL, OB, BYPASS or OFF.
+const (
+	PowerSourceMains   = "mains"
+	PowerSourceBattery = "battery"
+	PowerSourceBypass  = "bypass"
+	PowerSourceOff     = "off"
+	PowerSourceUnknown = "unknown"
+)
+
+// Casings of StatusDisplay.
+const (
+	StatusCaseTitle = "title" // "On Battery, Low Battery"
+	StatusCaseUpper = "upper" // "ON BATTERY, LOW BATTERY"
+	StatusCaseLower = "lower" // "on battery, low battery"
+)
+
 // AsTopicMap returns each metric as a topic-name → string-payload pair,
 // ready to publish as individual MQTT computed/ topics.
 //
@@ -32,7 +109,7 @@ type Metrics struct {
 // entry here; the JSON state topic picks it up automatically via the
 // struct tags above.
 func (m Metrics) AsTopicMap() map[string]string {
-	return map[string]string{
+	topics := map[string]string{
 		"load_watts":                  formatFloat(m.LoadWatts),
 		"battery_runtime_mins":        formatFloat(m.BatteryRuntimeMins),
 		"battery_runtime_hours":       formatFloat(m.BatteryRuntimeHours),
@@ -40,7 +117,95 @@ func (m Metrics) AsTopicMap() map[string]string {
 		"low_battery":                 strconv.FormatBool(m.LowBattery),
 		"status_display":              m.StatusDisplay,
 		"input_voltage_deviation_pct": formatFloat(m.InputVoltageDeviationPct),
+		"power_source":                m.PowerSource,
+	}
+	if m.StatusShort != "" {
+		topics["status_short"] = m.StatusShort
+	}
+	for _, name := range nullable {
+		switch {
+		case m.unavailable == UnavailableFlag:
+			topics[name+"_valid"] = strconv.FormatBool(m.Computed(name))
+		case m.Reported(name):
+		case m.unavailable == UnavailableNull:
+			topics[name] = "unknown"
+		default:
+			delete(topics, name)
+		}
+	}
+	return topics
+}
+
+// Computed reports whether the metric name could be computed from the
+// variables; false means it is 0 for want of them.
+func (m Metrics) Computed(name string) bool {
+	i := slices.Index(nullable, name)
+	return i < 0 || m.unknown&(1<<i) == 0
+}
+
+// Reported reports whether the metric name has a value to publish: it was
+// computed, or Options.Unavailable publishes 0 in its place.  Outputs with
+// no null, such as Prometheus, leave out the metrics that aren't.
+func (m Metrics) Reported(name string) bool {
+	return m.Computed(name) || m.zeroFilled()
+}
+
+// zeroFilled reports whether Options.Unavailable publishes 0 for metrics
+// that couldn't be computed.
+func (m Metrics) zeroFilled() bool {
+	switch m.unavailable {
+	case "", UnavailableZero, UnavailableFlag:
+		return true
+	}
+	return false
+}
+
+// MarshalJSON applies Options.Unavailable to the metrics that couldn't be
+// computed: they are left out, null, or 0 with {name}_valid beside them.
+// Otherwise the JSON tags above are the wire format.
+func (m Metrics) MarshalJSON() ([]byte, error) {
+	type plain Metrics
+	flag := m.unavailable == UnavailableFlag
+	if !flag && (m.unknown == 0 || m.zeroFilled()) {
+		return json.Marshal(plain(m))
+	}
+	value := func(name string, v float64) json.RawMessage {
+		switch {
+		case m.Reported(name):
+			return json.RawMessage(formatFloat(v))
+		case m.unavailable == UnavailableNull:
+			return json.RawMessage("null")
+		}
+		return nil
+	}
+	valid := func(name string) *bool {
+		if !flag {
+			return nil
+		}
+		ok := m.Computed(name)
+		return &ok
 	}
+	return json.Marshal(struct {
+		plain
+		LoadWatts                     json.RawMessage `json:"load_watts,omitempty"`
+		LoadWattsValid                *bool           `json:"load_watts_valid,omitempty"`
+		BatteryRuntimeMins            json.RawMessage `json:"battery_runtime_mins,omitempty"`
+		BatteryRuntimeMinsValid       *bool           `json:"battery_runtime_mins_valid,omitempty"`
+		BatteryRuntimeHours           json.RawMessage `json:"battery_runtime_hours,omitempty"`
+		BatteryRuntimeHoursValid      *bool           `json:"battery_runtime_hours_valid,omitempty"`
+		InputVoltageDeviationPct      json.RawMessage `json:"input_voltage_deviation_pct,omitempty"`
+		InputVoltageDeviationPctValid *bool           `json:"input_voltage_deviation_pct_valid,omitempty"`
+	}{
+		plain:                         plain(m),
+		LoadWatts:                     value("load_watts", m.LoadWatts),
+		LoadWattsValid:                valid("load_watts"),
+		BatteryRuntimeMins:            value("battery_runtime_mins", m.BatteryRuntimeMins),
+		BatteryRuntimeMinsValid:       valid("battery_runtime_mins"),
+		BatteryRuntimeHours:           value("battery_runtime_hours", m.BatteryRuntimeHours),
+		BatteryRuntimeHoursValid:      valid("battery_runtime_hours"),
+		InputVoltageDeviationPct:      value("input_voltage_deviation_pct", m.InputVoltageDeviationPct),
+		InputVoltageDeviationPctValid: valid("input_voltage_deviation_pct"),
+	})
 }
 
 // statusTokens maps NUT status tokens to human-readable labels.
@@ -62,29 +227,55 @@ var statusTokens = map[string]string{
 }
 
 // Compute derives all metrics from vars, a map of NUT variable name → string value.
-// Missing or unparseable variables gracefully produce zero values rather than panics.
+// Missing or unparseable variables gracefully produce zero values rather than panics;
+// Computed tells those zeroes from real ones.
 func Compute(vars map[string]string) Metrics {
-	return Metrics{
-		LoadWatts:                computeLoadWatts(vars),
-		BatteryRuntimeMins:       computeBatteryRuntimeMins(vars),
-		BatteryRuntimeHours:      computeBatteryRuntimeHours(vars),
-		OnBattery:                hasStatusToken(vars["ups.status"], "OB"),
-		LowBattery:               hasStatusToken(vars["ups.status"], "LB"),
-		StatusDisplay:            computeStatusDisplay(vars),
-		InputVoltageDeviationPct: computeInputVoltageDeviationPct(vars),
+	return ComputeWith(vars, Options{})
+}
+
+// ComputeWith is Compute with the fallbacks in opts.
+func ComputeWith(vars map[string]string, opts Options) Metrics {
+	m := Metrics{
+		BatteryRuntimeMins:  computeBatteryRuntimeMins(vars),
+		BatteryRuntimeHours: computeBatteryRuntimeHours(vars),
+		OnBattery:           hasStatusToken(vars["ups.status"], "OB"),
+		LowBattery:          hasStatusToken(vars["ups.status"], "LB") || (opts.LowThresholds && belowLowThreshold(vars)),
+		StatusDisplay:       computeStatusDisplay(vars, opts),
+		PowerSource:         computePowerSource(vars["ups.status"]),
+		unavailable:         opts.Unavailable,
 	}
+	var wattsOK, deviationOK bool
+	m.LoadWatts, m.LoadWattsEstimated, wattsOK = loadWatts(vars, opts)
+	m.InputVoltageDeviationPct, deviationOK = computeInputVoltageDeviationPct(vars)
+	_, runtimeOK := parseFloat(vars["battery.runtime"])
+	// In the order of nullable.
+	for i, ok := range []bool{wattsOK, runtimeOK, runtimeOK, deviationOK} {
+		if !ok {
+			m.unknown |= 1 << i
+		}
+	}
+	if opts.StatusShort {
+		m.StatusShort = strings.Join(strings.Fields(vars["ups.status"]), "/")
+	}
+	return m
 }
 
-func computeLoadWatts(vars map[string]string) float64 {
+// loadWatts is ups.load × ups.realpower.nominal, or, when the UPS only
+// reports its VA rating, ups.load × ups.power.nominal × opts.PowerFactor
+// (estimated).  ok is false, and watts 0, when neither can be worked out.
+func loadWatts(vars map[string]string, opts Options) (watts float64, estimated, ok bool) {
 	load, ok := parseFloat(vars["ups.load"])
 	if !ok {
-		return 0
+		return 0, false, false
 	}
-	nominal, ok := parseFloat(vars["ups.realpower.nominal"])
-	if !ok {
-		return 0
+	if nominal, ok := parseFloat(vars["ups.realpower.nominal"]); ok {
+		return math.Round(load/100*nominal*100) / 100, false, true
+	}
+	va, ok := parseFloat(vars["ups.power.nominal"])
+	if !ok || opts.PowerFactor <= 0 {
+		return 0, false, false
 	}
-	return math.Round(load/100*nominal*100) / 100
+	return math.Round(load/100*va*opts.PowerFactor*100) / 100, true, true
 }
 
 func computeBatteryRuntimeMins(vars map[string]string) float64 {
@@ -103,7 +294,7 @@ func computeBatteryRuntimeHours(vars map[string]string) float64 {
 	return math.Round(runtime/3600*100) / 100
 }
 
-func computeStatusDisplay(vars map[string]string) string {
+func computeStatusDisplay(vars map[string]string, opts Options) string {
 	status := vars["ups.status"]
 	if status == "" {
 		return ""
@@ -117,19 +308,68 @@ func computeStatusDisplay(vars map[string]string) string {
 			decoded = append(decoded, t)
 		}
 	}
-	return strings.Join(decoded, ", ")
+	sep := opts.StatusSeparator
+	if sep == "" {
+		sep = ", "
+	}
+	display := strings.Join(decoded, sep)
+	switch opts.StatusCase {
+	case StatusCaseUpper:
+		return strings.ToUpper(display)
+	case StatusCaseLower:
+		return strings.ToLower(display)
+	}
+	return display
+}
+
+// computePowerSource reduces the status tokens to a single source.  OFF
+// wins over everything (the outlets are dead whatever else is reported),
+// then BYPASS (mains passing straight through, unprotected), then OB; OL
+// alone means normal mains operation.
+func computePowerSource(status string) string {
+	switch {
+	case hasStatusToken(status, "OFF"):
+		return PowerSourceOff
+	case hasStatusToken(status, "BYPASS"):
+		return PowerSourceBypass
+	case hasStatusToken(status, "OB"):
+		return PowerSourceBattery
+	case hasStatusToken(status, "OL"):
+		return PowerSourceMains
+	}
+	return PowerSourceUnknown
+}
+
+// belowLowThreshold reports whether the UPS is on battery with
+// battery.charge at or below battery.charge.low, or battery.runtime at or
+// below battery.runtime.low.  A pair with either half missing is ignored.
+func belowLowThreshold(vars map[string]string) bool {
+	if !hasStatusToken(vars["ups.status"], "OB") {
+		return false
+	}
+	for _, pair := range [][2]string{
+		{"battery.charge", "battery.charge.low"},
+		{"battery.runtime", "battery.runtime.low"},
+	} {
+		v, ok := parseFloat(vars[pair[0]])
+		low, lowOK := parseFloat(vars[pair[1]])
+		if ok && lowOK && v <= low {
+			return true
+		}
+	}
+	return false
 }
 
-func computeInputVoltageDeviationPct(vars map[string]string) float64 {
+func computeInputVoltageDeviationPct(vars map[string]string) (float64, bool) {
 	voltage, ok := parseFloat(vars["input.voltage"])
 	if !ok {
-		return 0
+		return 0, false
 	}
 	nominal, ok := parseFloat(vars["input.voltage.nominal"])
 	if !ok || nominal == 0 {
-		return 0
+		return 0, false
 	}
-	return math.Round((voltage-nominal)/nominal*100*100) / 100
+	return math.Round((voltage-nominal)/nominal*100*100) / 100, true
 }
 
 // hasStatusToken reports whether the space-separated status string contains token.
@@ -1,6 +1,8 @@
 package metrics
 
 import (
+	"encoding/json"
+	"strings"
 	"testing"
 )
 
@@ -146,6 +148,28 @@ func TestOnBattery_LowBattery_BothTrue(t *testing.T) {
 	}
 }
 
+func TestLowBattery_Thresholds(t *testing.T) {
+	tests := []struct {
+		name string
+		vars map[string]string
+		want bool
+	}{
+		{"charge at low", map[string]string{"ups.status": "OB DISCHRG", "battery.charge": "20", "battery.charge.low": "20"}, true},
+		{"runtime below low", map[string]string{"ups.status": "OB", "battery.runtime": "90", "battery.runtime.low": "120"}, true},
+		{"above both", map[string]string{"ups.status": "OB", "battery.charge": "60", "battery.charge.low": "20", "battery.runtime": "900", "battery.runtime.low": "120"}, false},
+		{"on mains", map[string]string{"ups.status": "OL CHRG", "battery.charge": "10", "battery.charge.low": "20"}, false},
+		{"no threshold", map[string]string{"ups.status": "OB", "battery.charge": "5"}, false},
+	}
+	for _, tt := range tests {
+		if got := ComputeWith(tt.vars, Options{LowThresholds: true}).LowBattery; got != tt.want {
+			t.Errorf("%s: LowBattery = %v, want %v", tt.name, got, tt.want)
+		}
+		if Compute(tt.vars).LowBattery {
+			t.Errorf("%s: LowBattery set without LowThresholds", tt.name)
+		}
+	}
+}
+
 func TestOnBattery_EmptyStatus(t *testing.T) {
 	vars := map[string]string{"ups.status": ""}
 	m := Compute(vars)
@@ -186,6 +210,38 @@ func TestStatusDisplay_UnknownToken(t *testing.T) {
 	}
 }
 
+func TestStatusDisplay_Options(t *testing.T) {
+	vars := map[string]string{"ups.status": "OB LB"}
+	cases := []struct {
+		opts Options
+		want string
+	}{
+		{Options{StatusSeparator: " | "}, "On Battery | Low Battery"},
+		{Options{StatusCase: StatusCaseUpper}, "ON BATTERY, LOW BATTERY"},
+		{Options{StatusCase: StatusCaseLower, StatusSeparator: "; "}, "on battery; low battery"},
+		{Options{StatusCase: StatusCaseTitle}, "On Battery, Low Battery"},
+	}
+	for _, tc := range cases {
+		if m := ComputeWith(vars, tc.opts); m.StatusDisplay != tc.want {
+			t.Errorf("ComputeWith(%+v).StatusDisplay = %q, want %q", tc.opts, m.StatusDisplay, tc.want)
+		}
+	}
+}
+
+func TestStatusShort(t *testing.T) {
+	vars := map[string]string{"ups.status": "OB  LB"}
+	if m := Compute(vars); m.StatusShort != "" {
+		t.Errorf("StatusShort = %q without the option, want empty", m.StatusShort)
+	}
+	m := ComputeWith(vars, Options{StatusShort: true})
+	if m.StatusShort != "OB/LB" {
+		t.Errorf("StatusShort = %q, want %q", m.StatusShort, "OB/LB")
+	}
+	if got := m.AsTopicMap()["status_short"]; got != "OB/LB" {
+		t.Errorf("AsTopicMap()[status_short] = %q, want %q", got, "OB/LB")
+	}
+}
+
 func TestStatusDisplay_AllKnownTokens(t *testing.T) {
 	tokens := []struct {
 		token string
@@ -273,6 +329,66 @@ func TestParseFloat_EmptyString(t *testing.T) {
 	}
 }
 
+// ---- ComputeWith / estimated LoadWatts ------------------------------------
+
+func TestLoadWatts_EstimatedFromVA(t *testing.T) {
+	vars := map[string]string{"ups.load": "8", "ups.power.nominal": "1500"}
+	m := ComputeWith(vars, Options{PowerFactor: 0.6})
+	if m.LoadWatts != 72 || !m.LoadWattsEstimated {
+		t.Errorf("LoadWatts = %v (estimated %v), want 72 estimated", m.LoadWatts, m.LoadWattsEstimated)
+	}
+}
+
+func TestLoadWatts_RealPowerNominalPreferredOverEstimate(t *testing.T) {
+	vars := map[string]string{"ups.load": "8", "ups.realpower.nominal": "900", "ups.power.nominal": "2000"}
+	m := ComputeWith(vars, Options{PowerFactor: 0.6})
+	if m.LoadWatts != 72 || m.LoadWattsEstimated {
+		t.Errorf("LoadWatts = %v (estimated %v), want measured 72", m.LoadWatts, m.LoadWattsEstimated)
+	}
+}
+
+func TestLoadWatts_EstimateUnavailable(t *testing.T) {
+	cases := []struct {
+		name string
+		vars map[string]string
+		pf   float64
+	}{
+		{"disabled", map[string]string{"ups.load": "8", "ups.power.nominal": "1500"}, 0},
+		{"no VA rating", map[string]string{"ups.load": "8"}, 0.6},
+		{"no load", map[string]string{"ups.power.nominal": "1500"}, 0.6},
+	}
+	for _, c := range cases {
+		if m := ComputeWith(c.vars, Options{PowerFactor: c.pf}); m.LoadWatts != 0 || m.LoadWattsEstimated {
+			t.Errorf("%s: LoadWatts = %v (estimated %v), want 0", c.name, m.LoadWatts, m.LoadWattsEstimated)
+		}
+	}
+}
+
+// ---- PowerSource ---------------------------------------------------------
+
+func TestPowerSource(t *testing.T) {
+	cases := []struct {
+		status string
+		want   string
+	}{
+		{"OL", PowerSourceMains},
+		{"OL CHRG", PowerSourceMains},
+		{"OB DISCHRG", PowerSourceBattery},
+		{"OB LB", PowerSourceBattery},
+		{"OL BYPASS", PowerSourceBypass},
+		{"OFF", PowerSourceOff},
+		{"OL BYPASS OFF", PowerSourceOff},
+		{"", PowerSourceUnknown},
+		{"CAL", PowerSourceUnknown},
+	}
+	for _, tc := range cases {
+		m := Compute(map[string]string{"ups.status": tc.status})
+		if m.PowerSource != tc.want {
+			t.Errorf("status %q: PowerSource = %q, want %q", tc.status, m.PowerSource, tc.want)
+		}
+	}
+}
+
 // ---- AsTopicMap ----------------------------------------------------------
 
 func TestAsTopicMap(t *testing.T) {
@@ -290,6 +406,7 @@ func TestAsTopicMap(t *testing.T) {
 		{"low_battery", "false"},
 		{"status_display", "Online"},
 		{"input_voltage_deviation_pct", "5.22"},
+		{"power_source", "mains"},
 	}
 	for _, tc := range cases {
 		t.Run(tc.key, func(t *testing.T) {
@@ -304,7 +421,80 @@ func TestAsTopicMap(t *testing.T) {
 	}
 
 	// Verify key count matches struct field count to catch any future drift.
-	if len(tm) != 7 {
-		t.Errorf("AsTopicMap() returned %d keys, want 7", len(tm))
+	if len(tm) != 8 {
+		t.Errorf("AsTopicMap() returned %d keys, want 8", len(tm))
+	}
+}
+
+// ---- Unavailable ---------------------------------------------------------
+
+func TestUnavailable(t *testing.T) {
+	// No ups.load or battery.runtime: load_watts and both runtimes can't be
+	// computed, while the input voltage deviation can.
+	vars := map[string]string{
+		"ups.status":            "OL",
+		"input.voltage":         "242.0",
+		"input.voltage.nominal": "230",
+	}
+	cases := []struct {
+		strategy string
+		topic    string // computed/load_watts; "-" when left out
+		valid    string // computed/load_watts_valid; "-" when left out
+		json     string // what the state JSON holds for load_watts
+	}{
+		{"", "0", "-", `"load_watts":0,`},
+		{UnavailableZero, "0", "-", `"load_watts":0,`},
+		{UnavailableSkip, "-", "-", ""},
+		{UnavailableNull, "unknown", "-", `"load_watts":null`},
+		{UnavailableFlag, "0", "false", `"load_watts":0,"load_watts_valid":false`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.strategy, func(t *testing.T) {
+			m := ComputeWith(vars, Options{Unavailable: tc.strategy})
+			if m.Computed("load_watts") || !m.Computed("input_voltage_deviation_pct") || !m.Computed("on_battery") {
+				t.Errorf("Computed wrong: load_watts %v, input_voltage_deviation_pct %v",
+					m.Computed("load_watts"), m.Computed("input_voltage_deviation_pct"))
+			}
+			tm := m.AsTopicMap()
+			for key, want := range map[string]string{"load_watts": tc.topic, "load_watts_valid": tc.valid} {
+				got, ok := tm[key]
+				if !ok {
+					got = "-"
+				}
+				if got != want {
+					t.Errorf("AsTopicMap()[%q] = %q, want %q", key, got, want)
+				}
+			}
+			if tm["input_voltage_deviation_pct"] != "5.22" {
+				t.Errorf("input_voltage_deviation_pct = %q, want it computed", tm["input_voltage_deviation_pct"])
+			}
+
+			b, err := json.Marshal(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := string(b)
+			if tc.json == "" {
+				if strings.Contains(got, `"load_watts"`) {
+					t.Errorf("JSON %s holds load_watts, want it left out", got)
+				}
+			} else if !strings.Contains(got, tc.json) {
+				t.Errorf("JSON %s lacks %s", got, tc.json)
+			}
+			if !strings.Contains(got, `"input_voltage_deviation_pct":5.22`) || !strings.Contains(got, `"power_source":"mains"`) {
+				t.Errorf("JSON %s lost the computed metrics", got)
+			}
+		})
+	}
+}
+
+func TestUnavailable_FlagValid(t *testing.T) {
+	m := ComputeWith(sampleVars, Options{Unavailable: UnavailableFlag})
+	if got := m.AsTopicMap()["battery_runtime_mins_valid"]; got != "true" {
+		t.Errorf("battery_runtime_mins_valid = %q, want true", got)
+	}
+	b, _ := json.Marshal(m)
+	if !strings.Contains(string(b), `"battery_runtime_mins":82,"battery_runtime_mins_valid":true`) {
+		t.Errorf("JSON = %s", b)
 	}
 }
@@ -0,0 +1,63 @@
+package metrics
+
+// Temperature is what the UPS reports of its own and its battery's
+// temperature, in °C.  Each is nil when the UPS doesn't report it.
+type Temperature struct {
+	UPS     *float64
+	Battery *float64
+}
+
+// ComputeTemperature reads ups.temperature and battery.temperature.
+func ComputeTemperature(vars map[string]string) Temperature {
+	var t Temperature
+	if v, ok := parseFloat(vars["ups.temperature"]); ok {
+		t.UPS = ptr(v)
+	}
+	if v, ok := parseFloat(vars["battery.temperature"]); ok {
+		t.Battery = ptr(v)
+	}
+	return t
+}
+
+// Hottest returns the higher of the two temperatures; ok is false when the
+// UPS reports neither.
+func (t Temperature) Hottest() (c float64, ok bool) {
+	switch {
+	case t.UPS == nil && t.Battery == nil:
+		return 0, false
+	case t.UPS == nil:
+		return *t.Battery, true
+	case t.Battery == nil:
+		return *t.UPS, true
+	}
+	return max(*t.UPS, *t.Battery), true
+}
+
+// Over reports whether the UPS is over temperature, given whether it was at
+// the last reading: it becomes so once the hottest temperature reaches
+// limit, and stops being so only once it has fallen below limit −
+// hysteresis, so a reading hovering around the limit doesn't flap.  With
+// neither temperature reported it stays as it was.
+func (t Temperature) Over(limit, hysteresis float64, was bool) bool {
+	c, ok := t.Hottest()
+	switch {
+	case !ok:
+		return was
+	case was:
+		return c >= limit-hysteresis
+	}
+	return c >= limit
+}
+
+// AsTopicMap returns the reported temperatures as computed/ topic-name →
+// payload pairs.
+func (t Temperature) AsTopicMap() map[string]string {
+	topics := make(map[string]string, 2)
+	if t.UPS != nil {
+		topics["ups_temperature"] = formatFloat(*t.UPS)
+	}
+	if t.Battery != nil {
+		topics["battery_temperature"] = formatFloat(*t.Battery)
+	}
+	return topics
+}
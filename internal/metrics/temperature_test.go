@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestComputeTemperature(t *testing.T) {
+	cases := []struct {
+		name        string
+		vars        map[string]string
+		wantHottest float64
+		wantOK      bool
+		wantTopics  map[string]string
+	}{
+		{"both", map[string]string{"ups.temperature": "31.5", "battery.temperature": "28"}, 31.5, true,
+			map[string]string{"ups_temperature": "31.5", "battery_temperature": "28"}},
+		{"battery hotter", map[string]string{"ups.temperature": "30", "battery.temperature": "36.2"}, 36.2, true,
+			map[string]string{"ups_temperature": "30", "battery_temperature": "36.2"}},
+		{"ups only", map[string]string{"ups.temperature": "40", "battery.temperature": "n/a"}, 40, true,
+			map[string]string{"ups_temperature": "40"}},
+		{"battery only", map[string]string{"battery.temperature": "25"}, 25, true,
+			map[string]string{"battery_temperature": "25"}},
+		{"neither", map[string]string{"ups.status": "OL"}, 0, false, map[string]string{}},
+	}
+	for _, c := range cases {
+		temp := ComputeTemperature(c.vars)
+		if got, ok := temp.Hottest(); got != c.wantHottest || ok != c.wantOK {
+			t.Errorf("%s: Hottest = %v, %v; want %v, %v", c.name, got, ok, c.wantHottest, c.wantOK)
+		}
+		if got := temp.AsTopicMap(); !maps.Equal(got, c.wantTopics) {
+			t.Errorf("%s: AsTopicMap = %v, want %v", c.name, got, c.wantTopics)
+		}
+	}
+}
+
+func TestTemperature_Over(t *testing.T) {
+	over := false
+	for _, step := range []struct {
+		temp string
+		want bool
+	}{
+		{"38", false},
+		{"40", true}, // reaches the limit
+		{"39", true}, // within the hysteresis
+		{"38", true}, // on its edge
+		{"37.9", false},
+		{"39.9", false}, // below the limit again
+		{"", false},     // nothing reported: unchanged
+		{"41", true},
+		{"", true},
+	} {
+		over = ComputeTemperature(map[string]string{"ups.temperature": step.temp}).Over(40, 2, over)
+		if over != step.want {
+			t.Fatalf("at %q: Over = %v, want %v", step.temp, over, step.want)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/alerts"
+	"github.com/sweeney/ups-mqtt/internal/schedule"
+)
+
+// webhookPayload is the JSON body of a webhook request: the notify topic's
+// payload plus the UPS it is about.
+type webhookPayload struct {
+	UPS       string `json:"ups"`
+	Event     string `json:"event"`
+	Severity  string `json:"severity"`
+	Message   string `json:"message"`
+	Timestamp string `json:"timestamp"`
+	Drill     bool   `json:"drill,omitempty"`
+}
+
+// Webhook POSTs each notification to URL as JSON.
+type Webhook struct {
+	URL     string
+	Client  *http.Client
+	Timeout time.Duration
+}
+
+// Notify posts n and fails on anything but a 2xx response.
+func (w *Webhook) Notify(n Notification) error {
+	body, err := json.Marshal(webhookPayload{
+		UPS:       n.UPS,
+		Event:     n.Event,
+		Severity:  string(n.Severity),
+		Message:   n.Message,
+		Timestamp: n.Time.UTC().Format(time.RFC3339),
+		Drill:     n.Drill,
+	})
+	if err != nil {
+		return fmt.Errorf("marshalling notification: %w", err)
+	}
+	return post(w.Client, w.Timeout, w.URL, w.URL, "application/json", body)
+}
+
+// post POSTs body to url and fails on anything but a 2xx response.  Errors
+// name the endpoint as name, so a URL holding a token can be left out of
+// the log.
+func post(client *http.Client, timeout time.Duration, url, name, contentType string, body []byte) error {
+	if timeout <= 0 {
+		timeout = schedule.CallTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("building request to %s: %w", name, err)
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if url != name {
+			// The error quotes the URL; keep just its cause.
+			var uerr *neturl.Error
+			if errors.As(err, &uerr) {
+				err = uerr.Err
+			}
+		}
+		return fmt.Errorf("posting to %s: %w", name, err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s returned %s: %s", name, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// text renders n as one line for the chat backends, e.g. "office-ups: UPS
+// battery is low".
+func text(n Notification) string {
+	return n.UPS + ": " + n.Message
+}
+
+// TelegramAPI is the Telegram Bot API endpoint.
+const TelegramAPI = "https://api.telegram.org"
+
+// Telegram sends each notification as a message from the bot whose token
+// is Token to the chat ChatID.  API defaults to TelegramAPI.
+type Telegram struct {
+	Token   string
+	ChatID  string
+	API     string
+	Client  *http.Client
+	Timeout time.Duration
+}
+
+// Notify sends n with the Bot API's sendMessage.
+func (t *Telegram) Notify(n Notification) error {
+	body, err := json.Marshal(map[string]string{"chat_id": t.ChatID, "text": text(n)})
+	if err != nil {
+		return fmt.Errorf("marshalling notification: %w", err)
+	}
+	api := t.API
+	if api == "" {
+		api = TelegramAPI
+	}
+	return post(t.Client, t.Timeout, api+"/bot"+t.Token+"/sendMessage", "Telegram", "application/json", body)
+}
+
+// PushoverAPI is the Pushover message endpoint.
+const PushoverAPI = "https://api.pushover.net/1/messages.json"
+
+// Pushover sends each notification through the Pushover application whose
+// token is Token to the user or group key UserKey, titled with the UPS.
+// Critical events go out at high priority, which bypasses the recipient's
+// quiet hours.  API defaults to PushoverAPI.
+type Pushover struct {
+	Token   string
+	UserKey string
+	API     string
+	Client  *http.Client
+	Timeout time.Duration
+}
+
+// Notify posts n to the messages API.
+func (p *Pushover) Notify(n Notification) error {
+	form := neturl.Values{
+		"token":     {p.Token},
+		"user":      {p.UserKey},
+		"title":     {n.UPS},
+		"message":   {n.Message},
+		"timestamp": {strconv.FormatInt(n.Time.Unix(), 10)},
+	}
+	if n.Severity == alerts.SeverityCritical {
+		form.Set("priority", "1")
+	}
+	api := p.API
+	if api == "" {
+		api = PushoverAPI
+	}
+	return post(p.Client, p.Timeout, api, "Pushover", "application/x-www-form-urlencoded", []byte(form.Encode()))
+}
+
+// Slack posts each notification to a Slack incoming webhook, URL.
+type Slack struct {
+	URL     string
+	Client  *http.Client
+	Timeout time.Duration
+}
+
+// Notify posts n as the message text.
+func (s *Slack) Notify(n Notification) error {
+	body, err := json.Marshal(map[string]string{"text": text(n)})
+	if err != nil {
+		return fmt.Errorf("marshalling notification: %w", err)
+	}
+	return post(s.Client, s.Timeout, s.URL, "Slack", "application/json", body)
+}
+
+// Email sends each notification as a plain-text mail through the SMTP
+// server at Addr (host:port), authenticating with PLAIN when Username is
+// set.  The connection is upgraded with STARTTLS when the server offers it.
+type Email struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+	To       []string
+
+	// send defaults to smtp.SendMail; tests replace it.
+	send func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// Notify mails n to every address in To.
+func (e *Email) Notify(n Notification) error {
+	var auth smtp.Auth
+	if e.Username != "" {
+		host, _, _ := net.SplitHostPort(e.Addr)
+		auth = smtp.PlainAuth("", e.Username, e.Password, host)
+	}
+	send := e.send
+	if send == nil {
+		send = smtp.SendMail
+	}
+	if err := send(e.Addr, auth, e.From, e.To, e.message(n)); err != nil {
+		return fmt.Errorf("sending mail via %s: %w", e.Addr, err)
+	}
+	return nil
+}
+
+// message renders n as an RFC 5322 message.  A drill says so in the
+// subject, so it can't be mistaken for an outage in a crowded inbox.
+func (e *Email) message(n Notification) []byte {
+	drill := ""
+	if n.Drill {
+		drill = "[DRILL] "
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", e.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s[%s] %s: %s\r\n", drill, n.Severity, n.UPS, n.Event)
+	fmt.Fprintf(&b, "Date: %s\r\n", n.Time.Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&b, "%s\r\n\r\nUPS: %s\r\nEvent: %s\r\nSeverity: %s\r\nTime: %s\r\n",
+		n.Message, n.UPS, n.Event, n.Severity, n.Time.UTC().Format(time.RFC3339))
+	return []byte(b.String())
+}
@@ -0,0 +1,209 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/alerts"
+)
+
+var sample = Notification{
+	UPS:      "office-ups",
+	Event:    "low_battery",
+	Severity: alerts.SeverityCritical,
+	Message:  "UPS battery is low",
+	Time:     time.Date(2026, 3, 1, 3, 12, 0, 0, time.UTC),
+}
+
+func TestWebhook_Notify(t *testing.T) {
+	var got map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("request = %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		json.NewDecoder(r.Body).Decode(&got) //nolint:errcheck
+	}))
+	defer srv.Close()
+
+	if err := (&Webhook{URL: srv.URL, Client: srv.Client()}).Notify(sample); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	want := map[string]string{
+		"ups": "office-ups", "event": "low_battery", "severity": "critical",
+		"message": "UPS battery is low", "timestamp": "2026-03-01T03:12:00Z",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %q, want %q", k, got[k], v)
+		}
+	}
+}
+
+func TestWebhook_Errors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "rate limited", http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+	if err := (&Webhook{URL: srv.URL}).Notify(sample); err == nil || !strings.Contains(err.Error(), "rate limited") {
+		t.Errorf("err = %v, want the response reported", err)
+	}
+	if err := (&Webhook{URL: "http://127.0.0.1:1/", Timeout: time.Second}).Notify(sample); err == nil {
+		t.Error("expected error for an unreachable endpoint")
+	}
+	if err := (&Webhook{URL: "://bad"}).Notify(sample); err == nil {
+		t.Error("expected error for a bad URL")
+	}
+}
+
+func TestEmail_Notify(t *testing.T) {
+	var gotAddr, gotFrom string
+	var gotTo []string
+	var gotAuth smtp.Auth
+	var gotMsg string
+	e := &Email{
+		Addr: "smtp.example.com:587", Username: "ups", Password: "secret",
+		From: "ups@example.com", To: []string{"ops@example.com", "me@example.com"},
+		send: func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+			gotAddr, gotAuth, gotFrom, gotTo, gotMsg = addr, a, from, to, string(msg)
+			return nil
+		},
+	}
+	if err := e.Notify(sample); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if gotAddr != "smtp.example.com:587" || gotFrom != "ups@example.com" || len(gotTo) != 2 || gotAuth == nil {
+		t.Errorf("send(%q, %v, %q, %v)", gotAddr, gotAuth, gotFrom, gotTo)
+	}
+	for _, want := range []string{
+		"To: ops@example.com, me@example.com\r\n",
+		"Subject: [critical] office-ups: low_battery\r\n",
+		"\r\n\r\nUPS battery is low\r\n",
+	} {
+		if !strings.Contains(gotMsg, want) {
+			t.Errorf("message missing %q:\n%s", want, gotMsg)
+		}
+	}
+}
+
+func TestEmail_Drill(t *testing.T) {
+	var gotMsg string
+	e := &Email{
+		Addr: "smtp.example.com:25", From: "ups@example.com", To: []string{"ops@example.com"},
+		send: func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+			gotMsg = string(msg)
+			return nil
+		},
+	}
+	n := sample
+	n.Drill = true
+	if err := e.Notify(n); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if want := "Subject: [DRILL] [critical] office-ups: low_battery\r\n"; !strings.Contains(gotMsg, want) {
+		t.Errorf("message missing %q:\n%s", want, gotMsg)
+	}
+}
+
+func TestEmail_NoAuth_Error(t *testing.T) {
+	var gotAuth smtp.Auth = smtp.CRAMMD5Auth("x", "y")
+	e := &Email{
+		Addr: "localhost:25", From: "ups@example.com", To: []string{"ops@example.com"},
+		send: func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+			gotAuth = a
+			return errors.New("connection refused")
+		},
+	}
+	if err := e.Notify(sample); err == nil || !strings.Contains(err.Error(), "localhost:25") {
+		t.Errorf("err = %v, want the server named", err)
+	}
+	if gotAuth != nil {
+		t.Error("no auth expected without a username")
+	}
+}
+
+func TestTelegram_Notify(t *testing.T) {
+	var path string
+	var got map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&got) //nolint:errcheck
+	}))
+	defer srv.Close()
+
+	tg := &Telegram{Token: "123:abc", ChatID: "-100200", API: srv.URL, Client: srv.Client()}
+	if err := tg.Notify(sample); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if path != "/bot123:abc/sendMessage" || got["chat_id"] != "-100200" || got["text"] != "office-ups: UPS battery is low" {
+		t.Errorf("request = %s %v", path, got)
+	}
+}
+
+func TestPushover_Notify(t *testing.T) {
+	var got url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm() //nolint:errcheck
+		got = r.PostForm
+	}))
+	defer srv.Close()
+
+	p := &Pushover{Token: "app", UserKey: "user", API: srv.URL, Client: srv.Client()}
+	if err := p.Notify(sample); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	want := url.Values{
+		"token": {"app"}, "user": {"user"}, "title": {"office-ups"}, "message": {"UPS battery is low"},
+		"timestamp": {"1772334720"}, "priority": {"1"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("form = %v, want %v", got, want)
+	}
+
+	info := sample
+	info.Severity = alerts.SeverityInfo
+	if err := p.Notify(info); err != nil || got.Has("priority") {
+		t.Errorf("info notification: err %v, priority %q; want the default priority", err, got.Get("priority"))
+	}
+}
+
+func TestSlack_Notify(t *testing.T) {
+	var got map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got) //nolint:errcheck
+	}))
+	defer srv.Close()
+
+	if err := (&Slack{URL: srv.URL, Client: srv.Client()}).Notify(sample); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if got["text"] != "office-ups: UPS battery is low" {
+		t.Errorf("text = %q", got["text"])
+	}
+}
+
+// TestChatBackends_ErrorsHideURL verifies a failure names the service
+// rather than the URL, which holds the bot token or webhook secret.
+func TestChatBackends_ErrorsHideURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+	for _, n := range []Notifier{
+		&Telegram{Token: "secret-token", API: srv.URL},
+		&Slack{URL: srv.URL + "/services/secret-token"},
+		&Telegram{Token: "secret-token", API: "http://127.0.0.1:1", Timeout: time.Second},
+	} {
+		err := n.Notify(sample)
+		if err == nil || strings.Contains(err.Error(), "secret-token") {
+			t.Errorf("%T: err = %v, want an error without the token", n, err)
+		}
+	}
+}
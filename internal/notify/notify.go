@@ -0,0 +1,93 @@
+// Package notify delivers notifications — status events and alert
+// transitions — to backends such as webhooks and email, and decides which
+// backends each one goes to.
+package notify
+
+import (
+	"path"
+	"sort"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/alerts"
+)
+
+// MQTT is the name of the built-in backend, the {prefix}/{label}/notify
+// topic, which the caller delivers to itself.
+const MQTT = "mqtt"
+
+// Notification is one event to deliver.
+type Notification struct {
+	UPS      string // the bridge's label
+	Event    string
+	Severity alerts.Severity
+	Message  string
+	Time     time.Time
+	Drill    bool // from a drill; see alerts.Event
+}
+
+// Notifier is a notification backend.
+type Notifier interface {
+	Notify(n Notification) error
+}
+
+// Route sends events whose name matches one of Events (globs, path.Match
+// syntax; every event when empty) and whose severity is at least
+// MinSeverity (any when empty) to the named Notifiers.
+type Route struct {
+	Events      []string
+	MinSeverity alerts.Severity
+	Notifiers   []string
+}
+
+// Matches reports whether r applies to ev.
+func (r Route) Matches(ev alerts.Event) bool {
+	if r.MinSeverity != "" && rank(ev.Severity) < rank(r.MinSeverity) {
+		return false
+	}
+	if len(r.Events) == 0 {
+		return true
+	}
+	for _, pattern := range r.Events {
+		if ok, err := path.Match(pattern, ev.Name); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Targets returns the sorted names of the backends ev goes to: those of
+// every route that matches it, or all of them when none does, so events
+// nobody routed are still broadcast as before routing was configured.
+func Targets(routes []Route, all []string, ev alerts.Event) []string {
+	set := make(map[string]bool)
+	for _, r := range routes {
+		if r.Matches(ev) {
+			for _, name := range r.Notifiers {
+				set[name] = true
+			}
+		}
+	}
+	if len(set) == 0 {
+		for _, name := range all {
+			set[name] = true
+		}
+	}
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// rank orders severities; unknown ones rank with warning, the default.
+func rank(s alerts.Severity) int {
+	switch s {
+	case alerts.SeverityInfo:
+		return 0
+	case alerts.SeverityCritical:
+		return 2
+	default:
+		return 1
+	}
+}
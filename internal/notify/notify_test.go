@@ -0,0 +1,60 @@
+package notify
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sweeney/ups-mqtt/internal/alerts"
+)
+
+var (
+	lowBattery     = alerts.Event{Name: "low_battery", Severity: alerts.SeverityCritical}
+	replaceBattery = alerts.Event{Name: "replace_battery", Severity: alerts.SeverityWarning}
+	powerRestored  = alerts.Event{Name: "power_restored", Severity: alerts.SeverityInfo}
+)
+
+func TestRoute_Matches(t *testing.T) {
+	cases := []struct {
+		name string
+		r    Route
+		ev   alerts.Event
+		want bool
+	}{
+		{"everything", Route{}, powerRestored, true},
+		{"event name", Route{Events: []string{"low_battery"}}, lowBattery, true},
+		{"other event", Route{Events: []string{"low_battery"}}, replaceBattery, false},
+		{"glob", Route{Events: []string{"replace_*"}}, replaceBattery, true},
+		{"bad glob", Route{Events: []string{"["}}, replaceBattery, false},
+		{"severity met", Route{MinSeverity: alerts.SeverityWarning}, lowBattery, true},
+		{"severity too low", Route{MinSeverity: alerts.SeverityWarning}, powerRestored, false},
+		{"unknown severity ranks as warning", Route{MinSeverity: alerts.SeverityWarning}, alerts.Event{Name: "x", Severity: "odd"}, true},
+		{"both", Route{Events: []string{"*_battery"}, MinSeverity: alerts.SeverityCritical}, replaceBattery, false},
+	}
+	for _, c := range cases {
+		if got := c.r.Matches(c.ev); got != c.want {
+			t.Errorf("%s: Matches = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestTargets(t *testing.T) {
+	routes := []Route{
+		{Events: []string{"low_battery"}, Notifiers: []string{"webhook", "telegram"}},
+		{Events: []string{"replace_battery"}, Notifiers: []string{"email"}},
+		{MinSeverity: alerts.SeverityCritical, Notifiers: []string{MQTT, "webhook"}},
+	}
+	all := []string{"email", MQTT, "telegram", "webhook"}
+
+	if got := Targets(routes, all, lowBattery); !reflect.DeepEqual(got, []string{MQTT, "telegram", "webhook"}) {
+		t.Errorf("low_battery → %v", got)
+	}
+	if got := Targets(routes, all, replaceBattery); !reflect.DeepEqual(got, []string{"email"}) {
+		t.Errorf("replace_battery → %v", got)
+	}
+	if got := Targets(routes, all, powerRestored); !reflect.DeepEqual(got, all) {
+		t.Errorf("unrouted power_restored → %v, want broadcast", got)
+	}
+	if got := Targets(nil, []string{MQTT}, powerRestored); !reflect.DeepEqual(got, []string{MQTT}) {
+		t.Errorf("no routes → %v", got)
+	}
+}
@@ -0,0 +1,63 @@
+package notify
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Templates replace the built-in English notification messages: a
+// text/template by language and then event name.
+type Templates map[string]map[string]*template.Template
+
+// TemplateData is what a message template is executed with.  Message is
+// the built-in English message, and Variables the UPS variables of the
+// poll the event came from, or of the last one.
+type TemplateData struct {
+	UPS       string
+	Event     string
+	Severity  string
+	Message   string
+	Time      time.Time
+	Variables map[string]string
+}
+
+// ParseTemplates parses template source by language and event name, as
+// in [notifications.templates].
+func ParseTemplates(src map[string]map[string]string) (Templates, error) {
+	t := make(Templates, len(src))
+	for lang, events := range src {
+		t[lang] = make(map[string]*template.Template, len(events))
+		for event, text := range events {
+			tmpl, err := template.New(lang + "." + event).Parse(text)
+			if err != nil {
+				return nil, fmt.Errorf("template %s.%s: %w", lang, event, err)
+			}
+			t[lang][event] = tmpl
+		}
+	}
+	return t, nil
+}
+
+// Message returns n's message in lang: its template executed with n and
+// vars, or n.Message when lang has no template for the event.
+func (t Templates) Message(lang string, n Notification, vars map[string]string) (string, error) {
+	tmpl := t[lang][n.Event]
+	if tmpl == nil {
+		return n.Message, nil
+	}
+	var b strings.Builder
+	err := tmpl.Execute(&b, TemplateData{
+		UPS:       n.UPS,
+		Event:     n.Event,
+		Severity:  string(n.Severity),
+		Message:   n.Message,
+		Time:      n.Time,
+		Variables: vars,
+	})
+	if err != nil {
+		return n.Message, err
+	}
+	return strings.TrimSpace(b.String()), nil
+}
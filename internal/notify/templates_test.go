@@ -0,0 +1,48 @@
+package notify
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/alerts"
+)
+
+func TestTemplates_Message(t *testing.T) {
+	tmpls, err := ParseTemplates(map[string]map[string]string{
+		"de": {
+			"on_battery": `Stromausfall: {{.UPS}} läuft auf Batterie ({{index .Variables "battery.charge"}} %) um {{.Time.Format "15:04"}}`,
+			"broken":     `{{.Nope}}`,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := Notification{
+		UPS:      "office",
+		Event:    "on_battery",
+		Severity: alerts.SeverityWarning,
+		Message:  "UPS is running on battery",
+		Time:     time.Date(2026, 3, 1, 3, 12, 0, 0, time.UTC),
+	}
+	vars := map[string]string{"battery.charge": "97"}
+	cases := []struct {
+		lang, event, want string
+		wantErr           bool
+	}{
+		{"de", "on_battery", "Stromausfall: office läuft auf Batterie (97 %) um 03:12", false},
+		{"de", "low_battery", "UPS is running on battery", false},
+		{"", "on_battery", "UPS is running on battery", false},
+		{"de", "broken", "UPS is running on battery", true},
+	}
+	for _, c := range cases {
+		n.Event = c.event
+		got, err := tmpls.Message(c.lang, n, vars)
+		if got != c.want || (err != nil) != c.wantErr {
+			t.Errorf("Message(%q, %s) = %q, %v; want %q (error %v)", c.lang, c.event, got, err, c.want, c.wantErr)
+		}
+	}
+
+	if _, err := ParseTemplates(map[string]map[string]string{"de": {"on_battery": "{{.UPS"}}); err == nil {
+		t.Error("expected an error for a template that doesn't parse")
+	}
+}
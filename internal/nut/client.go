@@ -1,29 +1,116 @@
 package nut
 
 import (
+	"errors"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	gonut "github.com/robbiet480/go.nut"
 )
 
 // Client connects to a NUT upsd daemon and implements Poller.
 // On Poll error the connection is marked stale; the next Poll reconnects
-// automatically before fetching variables.
+// automatically before fetching variables.  Poll and Raw may be called from
+// different goroutines; they are serialised on the single upsd connection.
+// A Client obtained from a Pool has no connection of its own and borrows
+// one of the pool's for each request instead.
 type Client struct {
-	host     string
+	mu       sync.Mutex
+	hosts    []string
 	port     int
 	username string
 	password string
 	upsName  string
 	conn     *gonut.Client
+	addr     string
 	stale    bool
+
+	// server is the index in hosts of the server connected to, and since
+	// when; a connection to a backup server fails back (see ready).
+	server int
+	since  time.Time
+
+	// onConn, when set, is told about connection changes; failing records
+	// that a failed reconnect was already reported.
+	onConn  func(event, addr string, err error)
+	failing bool
+
+	// pool, when set, supplies the connection; see Pool.Client.
+	pool *Pool
+
+	// dialed is how long the logins since it was last cleared spent
+	// connecting and authenticating.  timings is the last Poll's, and vars
+	// the variables Poll fetches one by one (see SetVariables), both
+	// guarded by tmu, since a pool client has no connection of its own to
+	// lock.
+	dialed  Timings
+	tmu     sync.Mutex
+	timings Timings
+	vars    []string
+}
+
+// Connection events reported to the OnConnChange handler.
+const (
+	ConnConnected  = "connected"
+	ConnLost       = "disconnected"
+	ConnFailed     = "connect_failed"
+	ConnAuthFailed = "auth_failed"
+)
+
+// ErrAuthFailed is wrapped by connection errors caused by upsd rejecting
+// the configured username or password.
+var ErrAuthFailed = errors.New("authentication failed")
+
+// failbackInterval is how often a connection to a backup server checks
+// whether a higher-priority server is reachable again.
+var failbackInterval = 5 * time.Minute
+
+// OnConnChange registers f to be told when the connection is lost, when a
+// reconnect succeeds, and when reconnecting first fails (repeated failures
+// are reported once).  f runs with the connection locked and must not call
+// back into c.
+func (c *Client) OnConnChange(f func(event, addr string, err error)) {
+	if c.pool != nil {
+		c.pool.onConnChange(f)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onConn = f
+}
+
+// acquire returns the Client holding the connection to use, locked, and
+// the function that releases it: c itself, or an idle connection of c's
+// pool, waiting for one to come free if all are busy.
+func (c *Client) acquire() (*Client, func()) {
+	if c.pool != nil {
+		return c.pool.get()
+	}
+	c.mu.Lock()
+	return c, c.mu.Unlock
+}
+
+// markStale makes the next request reconnect after err broke this one.
+func (c *Client) markStale(err error) {
+	if !c.stale && c.onConn != nil {
+		c.onConn(ConnLost, c.addr, err)
+	}
+	c.stale = true
 }
 
 // NewClient dials upsd and returns a ready Client, or an error if the
-// initial connection fails.
-func NewClient(host string, port int, username, password, upsName string) (*Client, error) {
+// initial connection fails.  hosts lists the upsd servers in priority
+// order: each is tried in turn and the first that accepts the connection is
+// used.  An entry may itself list several addresses of one server, which
+// are dialled together (see ParseEndpoints and dial).  port is the default
+// for entries that don't give one.
+func NewClient(hosts []string, port int, username, password, upsName string) (*Client, error) {
 	c := &Client{
-		host:     host,
+		hosts:    hosts,
 		port:     port,
 		username: username,
 		password: password,
@@ -35,66 +122,235 @@ func NewClient(host string, port int, username, password, upsName string) (*Clie
 	return c, nil
 }
 
+// connect dials upsd (see dial) and reports the outcome to onConn.
 func (c *Client) connect() error {
-	conn, err := gonut.Connect(c.host, c.port)
+	err := c.dial()
+	switch {
+	case c.onConn == nil:
+	case err == nil:
+		c.failing = false
+		c.onConn(ConnConnected, c.addr, nil)
+	case !c.failing:
+		c.failing = true
+		event := ConnFailed
+		if errors.Is(err, ErrAuthFailed) {
+			event = ConnAuthFailed
+		}
+		c.onConn(event, "", err)
+	}
+	return err
+}
+
+// dial connects to the first of the configured servers that answers, in
+// priority order.  A refused login is returned at once rather than tried
+// on the next server, since they share the credentials.
+func (c *Client) dial() error {
+	var errs []string
+	for i, host := range c.hosts {
+		conn, ep, err := c.login(host)
+		if errors.Is(err, ErrAuthFailed) {
+			return err
+		}
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		c.conn = &conn
+		c.addr = ep.String()
+		c.stale = false
+		c.server, c.since = i, time.Now()
+		return nil
+	}
+	if len(errs) == 0 {
+		return errors.New("no NUT host configured")
+	}
+	return errors.New(strings.Join(errs, "; "))
+}
+
+// login connects to the server host and logs in.  host is parsed as an
+// endpoint list, every entry is resolved afresh and the resulting
+// addresses are dialled Happy Eyeballs style (see dialFirst), so DNS-based
+// failover, multi-homed servers and dual-stack hosts with one broken
+// address family all work across reconnects.
+func (c *Client) login(host string) (gonut.Client, Endpoint, error) {
+	eps, err := ParseEndpoints(host, c.port)
+	if err != nil {
+		return gonut.Client{}, Endpoint{}, err
+	}
+	start := time.Now()
+	cands, errs := candidates(eps)
+	if len(cands) == 0 {
+		c.dialed.Connect += time.Since(start)
+		return gonut.Client{}, Endpoint{}, fmt.Errorf("connecting to NUT at %s: %s", host, strings.Join(errs, "; "))
+	}
+	conn, ep, err := dialFirst(cands, happyEyeballsDelay)
+	c.dialed.Connect += time.Since(start)
 	if err != nil {
-		return fmt.Errorf("connecting to NUT at %s:%d: %w", c.host, c.port, err)
+		return gonut.Client{}, Endpoint{}, fmt.Errorf("connecting to NUT at %s: %s", host, strings.Join(append(errs, err.Error()), "; "))
 	}
 	if c.username != "" {
-		if _, err := conn.Authenticate(c.username, c.password); err != nil {
+		start = time.Now()
+		_, err := conn.Authenticate(c.username, c.password)
+		c.dialed.Auth += time.Since(start)
+		if err != nil {
 			_, _ = conn.Disconnect()
-			return fmt.Errorf("authenticating with NUT: %w", err)
+			return gonut.Client{}, Endpoint{}, fmt.Errorf("authenticating with NUT: %w: %w", ErrAuthFailed, err)
 		}
 	}
-	c.conn = &conn
-	c.stale = false
-	return nil
+	return conn, ep, nil
 }
 
-// Poll fetches the current variable set from the configured UPS.
-// If the connection is stale it reconnects first.
-func (c *Client) Poll() ([]Variable, error) {
+// ready prepares the connection for a request: a stale one is reconnected,
+// and one to a backup server moves back to a higher-priority server once
+// that answers again, checked every failbackInterval.
+func (c *Client) ready() error {
 	if c.stale {
-		if err := c.connect(); err != nil {
-			return nil, err
+		return c.connect()
+	}
+	if c.server == 0 || time.Since(c.since) < failbackInterval {
+		return nil
+	}
+	c.since = time.Now()
+	for i, host := range c.hosts[:c.server] {
+		conn, ep, err := c.login(host)
+		if err != nil {
+			continue
 		}
+		_, _ = c.conn.Disconnect()
+		c.conn, c.addr, c.server = &conn, ep.String(), i
+		if c.onConn != nil {
+			c.onConn(ConnConnected, c.addr, nil)
+		}
+		return nil
 	}
+	return nil
+}
+
+// Addr returns the host:port of the upsd server currently connected to.
+func (c *Client) Addr() string {
+	l, release := c.acquire()
+	defer release()
+	return l.addr
+}
 
-	upsList, err := c.conn.GetUPSList()
+// Poll fetches the current variable set from the configured UPS, or only
+// the variables given to SetVariables.
+// If the connection is stale it reconnects first.  How long each request
+// took is kept for LastTimings, whether the poll succeeds or not.
+func (c *Client) Poll() ([]Variable, error) {
+	var t Timings
+	start := time.Now()
+	defer func() {
+		t.Total = time.Since(start)
+		c.setTimings(t)
+	}()
+	l, release := c.acquire()
+	defer release()
+	l.dialed = Timings{}
+	err := l.ready()
+	t.Connect, t.Auth = l.dialed.Connect, l.dialed.Auth
 	if err != nil {
-		c.stale = true
-		return nil, fmt.Errorf("listing UPS: %w", err)
+		return nil, err
+	}
+	mark := time.Now()
+	lap := func() time.Duration {
+		d := time.Since(mark)
+		mark = time.Now()
+		return d
 	}
 
-	var target *gonut.UPS
-	for i := range upsList {
-		if upsList[i].Name == c.upsName {
-			target = &upsList[i]
-			break
-		}
+	if names := c.variables(); len(names) > 0 {
+		vars, err := l.getVars(c.upsName, names)
+		t.GetVars = lap()
+		return vars, err
+	}
+
+	// Ask for a single variable first: upsd answers ERR DATA-STALE for a
+	// driver that has stopped updating and ERR UNKNOWN-UPS for a UPS it
+	// doesn't serve, but go.nut only recognises ERR replies to single-line
+	// commands (on LIST VAR it waits for an END that never comes).  Other
+	// errors surface from the calls below.
+	_, err = l.conn.SendCommand("GET VAR " + c.upsName + " ups.status")
+	t.Status = lap()
+	switch {
+	case isDataStale(err):
+		return nil, fmt.Errorf("polling %q: %w", c.upsName, ErrDataStale)
+	case isUnknownUPS(err):
+		return nil, fmt.Errorf("UPS %q %w", c.upsName, ErrUPSNotFound)
+	}
+
+	upsList, err := l.listUPS()
+	t.ListUPS = lap()
+	if err != nil {
+		l.markStale(err)
+		return nil, fmt.Errorf("listing UPS: %w", err)
 	}
-	if target == nil {
-		return nil, fmt.Errorf("UPS %q not found in upsd", c.upsName)
+	if !slices.ContainsFunc(upsList, func(u UPSInfo) bool { return u.Name == c.upsName }) {
+		return nil, fmt.Errorf("UPS %q %w", c.upsName, ErrUPSNotFound)
 	}
 
-	nutVars, err := target.GetVariables()
+	vars, err := l.listVars(c.upsName)
+	t.GetVars = lap()
 	if err != nil {
-		c.stale = true
+		l.markStale(err)
 		return nil, fmt.Errorf("getting variables for %q: %w", c.upsName, err)
 	}
+	return vars, nil
+}
+
+// Raw sends a single read-only protocol line (see CheckReadOnly) to upsd and
+// returns the response lines.  upsd ERR replies are returned as errors.
+func (c *Client) Raw(line string) ([]string, error) {
+	if err := CheckReadOnly(line); err != nil {
+		return nil, err
+	}
+	l, release := c.acquire()
+	defer release()
+	if err := l.ready(); err != nil {
+		return nil, err
+	}
+	resp, err := l.conn.SendCommand(line)
+	if err != nil {
+		// As in Poll, reconnect next time: go.nut can't tell an ERR reply
+		// from a broken connection.
+		l.markStale(err)
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Clients returns the hosts logged in to the UPS (LIST CLIENT) and, where
+// upsd supports it, the login count (GET NUMLOGINS).  NumLogins is -1 when
+// unavailable.
+func (c *Client) Clients() (Clients, error) {
+	l, release := c.acquire()
+	defer release()
+	if err := l.ready(); err != nil {
+		return Clients{}, err
+	}
+	resp, err := l.conn.SendCommand("LIST CLIENT " + c.upsName)
+	if err != nil {
+		l.markStale(err)
+		return Clients{}, fmt.Errorf("listing clients of %q: %w", c.upsName, err)
+	}
+	out := Clients{Hosts: parseClientList(resp, c.upsName), NumLogins: -1}
 
-	vars := make([]Variable, len(nutVars))
-	for i, v := range nutVars {
-		vars[i] = Variable{
-			Name:  v.Name,
-			Value: fmt.Sprintf("%v", v.Value),
+	if resp, err := l.conn.SendCommand("GET NUMLOGINS " + c.upsName); err == nil && len(resp) > 0 {
+		if n, err := strconv.Atoi(strings.TrimPrefix(resp[0], "NUMLOGINS "+c.upsName+" ")); err == nil {
+			out.NumLogins = n
 		}
 	}
-	return vars, nil
+	return out, nil
 }
 
-// Close disconnects from upsd.
+// Close disconnects from upsd.  For a Client from a Pool it does nothing;
+// the pool's connections are closed with the pool.
 func (c *Client) Close() error {
+	if c.pool != nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if c.conn != nil {
 		_, err := c.conn.Disconnect()
 		c.conn = nil
@@ -1,9 +1,14 @@
 package nut
 
 import (
+	"bufio"
 	"errors"
 	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestFakePoller_Poll_ReturnsVariables(t *testing.T) {
@@ -206,7 +211,7 @@ func TestNewClient_ConnectionRefused(t *testing.T) {
 	port := ln.Addr().(*net.TCPAddr).Port
 	ln.Close()
 
-	_, err = NewClient("127.0.0.1", port, "", "", "test")
+	_, err = NewClient([]string{"127.0.0.1"}, port, "", "", "test")
 	if err == nil {
 		t.Fatal("NewClient should return an error when nothing is listening")
 	}
@@ -220,3 +225,303 @@ func TestClient_Close_NilConn(t *testing.T) {
 		t.Errorf("Close on nil conn returned error: %v", err)
 	}
 }
+
+// fakeUPSD starts a minimal upsd on a loopback port that answers each
+// request line with replies[line] (or "ERR UNKNOWN-COMMAND"), plus the
+// VER/NETVER/LOGOUT exchanges go.nut performs itself.  It returns the port.
+func fakeUPSD(t *testing.T, replies map[string]string) int {
+	t.Helper()
+	port, _ := countingUPSD(t, replies)
+	return port
+}
+
+// countingUPSD is fakeUPSD that also counts the connections accepted.
+func countingUPSD(t *testing.T, replies map[string]string) (int, *atomic.Int32) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() }) //nolint:errcheck
+	var conns atomic.Int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns.Add(1)
+			go func(conn net.Conn) {
+				defer conn.Close() //nolint:errcheck
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					line = strings.TrimSuffix(line, "\n")
+					reply, ok := replies[line]
+					switch {
+					case ok:
+					case line == "VER":
+						reply = "Network UPS Tools upsd 2.8.1 - http://www.networkupstools.org/"
+					case line == "NETVER":
+						reply = "1.3"
+					case line == "LOGOUT":
+						reply = "OK Goodbye"
+					default:
+						reply = "ERR UNKNOWN-COMMAND"
+					}
+					conn.Write([]byte(reply + "\n")) //nolint:errcheck
+				}
+			}(conn)
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port, &conns
+}
+
+func TestClient_Raw(t *testing.T) {
+	port := fakeUPSD(t, map[string]string{
+		"GET VAR cyberpower battery.charge": `VAR cyberpower battery.charge "100"`,
+	})
+	c, err := NewClient([]string{"127.0.0.1"}, port, "", "", "cyberpower")
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer c.Close() //nolint:errcheck
+
+	resp, err := c.Raw("GET VAR cyberpower battery.charge")
+	if err != nil {
+		t.Fatalf("Raw: %v", err)
+	}
+	if len(resp) != 1 || resp[0] != `VAR cyberpower battery.charge "100"` {
+		t.Errorf("Raw = %q", resp)
+	}
+}
+
+func TestClient_Raw_ErrReplyReconnects(t *testing.T) {
+	port := fakeUPSD(t, map[string]string{
+		"GET VAR cyberpower battery.charge": `VAR cyberpower battery.charge "100"`,
+	})
+	c, err := NewClient([]string{"127.0.0.1"}, port, "", "", "cyberpower")
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer c.Close() //nolint:errcheck
+
+	if _, err := c.Raw("GET VAR cyberpower nope"); err == nil {
+		t.Fatal("expected error for ERR reply")
+	}
+	if !c.stale {
+		t.Error("connection should be marked stale after an error")
+	}
+	if _, err := c.Raw("GET VAR cyberpower battery.charge"); err != nil {
+		t.Errorf("Raw after reconnect: %v", err)
+	}
+}
+
+func TestClient_Raw_RejectsWrites(t *testing.T) {
+	c := &Client{} // never reaches the connection
+	if _, err := c.Raw("INSTCMD cyberpower load.off"); err == nil {
+		t.Fatal("expected INSTCMD to be rejected")
+	}
+}
+
+func TestClient_Raw_ReconnectFails(t *testing.T) {
+	c := &Client{hosts: []string{"127.0.0.1"}, port: 1, stale: true}
+	if _, err := c.Raw("VER"); err == nil {
+		t.Fatal("expected reconnect error")
+	}
+}
+
+func TestClient_Clients(t *testing.T) {
+	port := fakeUPSD(t, map[string]string{
+		"LIST CLIENT cyberpower": "BEGIN LIST CLIENT cyberpower\n" +
+			"CLIENT cyberpower 192.168.1.11\n" +
+			"CLIENT cyberpower 192.168.1.10\n" +
+			"END LIST CLIENT cyberpower",
+		"GET NUMLOGINS cyberpower": "NUMLOGINS cyberpower 2",
+	})
+	c, err := NewClient([]string{"127.0.0.1"}, port, "", "", "cyberpower")
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer c.Close() //nolint:errcheck
+
+	got, err := c.Clients()
+	if err != nil {
+		t.Fatalf("Clients: %v", err)
+	}
+	if len(got.Hosts) != 2 || got.Hosts[0] != "192.168.1.10" || got.Hosts[1] != "192.168.1.11" {
+		t.Errorf("Hosts = %v", got.Hosts)
+	}
+	if got.NumLogins != 2 {
+		t.Errorf("NumLogins = %d, want 2", got.NumLogins)
+	}
+}
+
+func TestClient_Clients_NoNumLogins(t *testing.T) {
+	port := fakeUPSD(t, map[string]string{
+		"LIST CLIENT cyberpower": "BEGIN LIST CLIENT cyberpower\nEND LIST CLIENT cyberpower",
+	})
+	c, err := NewClient([]string{"127.0.0.1"}, port, "", "", "cyberpower")
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer c.Close() //nolint:errcheck
+
+	got, err := c.Clients()
+	if err != nil {
+		t.Fatalf("Clients: %v", err)
+	}
+	if len(got.Hosts) != 0 || got.NumLogins != -1 {
+		t.Errorf("Clients = %+v, want no hosts and NumLogins -1", got)
+	}
+}
+
+func TestClient_Clients_Error(t *testing.T) {
+	port := fakeUPSD(t, nil)
+	c, err := NewClient([]string{"127.0.0.1"}, port, "", "", "cyberpower")
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer c.Close() //nolint:errcheck
+
+	if _, err := c.Clients(); err == nil {
+		t.Fatal("expected error when LIST CLIENT fails")
+	}
+	if !c.stale {
+		t.Error("connection should be marked stale")
+	}
+}
+
+func TestClient_Clients_ReconnectFails(t *testing.T) {
+	c := &Client{hosts: []string{"127.0.0.1"}, port: 1, stale: true}
+	if _, err := c.Clients(); err == nil {
+		t.Fatal("expected reconnect error")
+	}
+}
+
+func TestFakePoller_Clients(t *testing.T) {
+	f := &FakePoller{ClientList: Clients{Hosts: []string{"10.0.0.1"}, NumLogins: 1}}
+	if got, err := f.Clients(); err != nil || got.NumLogins != 1 {
+		t.Errorf("Clients = %+v, %v", got, err)
+	}
+	f.ClientsErr = errors.New("boom")
+	if _, err := f.Clients(); err == nil {
+		t.Error("expected ClientsErr")
+	}
+}
+
+func TestClient_OnConnChange(t *testing.T) {
+	port := fakeUPSD(t, map[string]string{
+		"GET VAR cyberpower battery.charge": `VAR cyberpower battery.charge "100"`,
+	})
+	c, err := NewClient([]string{"127.0.0.1"}, port, "", "", "cyberpower")
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer c.Close() //nolint:errcheck
+	var events []string
+	c.OnConnChange(func(event, addr string, err error) { events = append(events, event+" "+addr) })
+
+	c.Raw("GET VAR cyberpower nope")           //nolint:errcheck
+	c.Raw("GET VAR cyberpower nope")           //nolint:errcheck
+	c.Raw("GET VAR cyberpower battery.charge") //nolint:errcheck
+	addr := c.Addr()
+	want := []string{"disconnected " + addr, "connected " + addr, "disconnected " + addr, "connected " + addr}
+	if strings.Join(events, ",") != strings.Join(want, ",") {
+		t.Errorf("events = %q, want %q", events, want)
+	}
+}
+
+func TestClient_OnConnChange_FailureReportedOnce(t *testing.T) {
+	c := &Client{hosts: []string{"127.0.0.1"}, port: 1, stale: true}
+	var events []string
+	c.OnConnChange(func(event, addr string, err error) { events = append(events, event) })
+	c.Raw("VER") //nolint:errcheck
+	c.Raw("VER") //nolint:errcheck
+	if len(events) != 1 || events[0] != ConnFailed {
+		t.Errorf("events = %q, want a single %s", events, ConnFailed)
+	}
+}
+
+func TestNewClient_AuthFailed(t *testing.T) {
+	port := fakeUPSD(t, map[string]string{
+		"USERNAME monitor": "OK",
+		"PASSWORD wrong":   "ERR ACCESS-DENIED",
+	})
+	_, err := NewClient([]string{"127.0.0.1"}, port, "monitor", "wrong", "cyberpower")
+	if !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("err = %v, want ErrAuthFailed", err)
+	}
+}
+
+// closedPort returns a local port nothing listens on.
+func closedPort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close() //nolint:errcheck
+	return port
+}
+
+func TestNewClient_FailsOverInPriorityOrder(t *testing.T) {
+	backup := fakeUPSD(t, nil)
+	hosts := []string{"127.0.0.1:" + strconv.Itoa(closedPort(t)), "127.0.0.1:" + strconv.Itoa(backup)}
+	c, err := NewClient(hosts, 3493, "", "", "cyberpower")
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer c.Close() //nolint:errcheck
+	if want := "127.0.0.1:" + strconv.Itoa(backup); c.Addr() != want {
+		t.Errorf("Addr = %q, want the backup %q", c.Addr(), want)
+	}
+}
+
+func TestClient_FailsBackToPrimary(t *testing.T) {
+	primary, backup := fakeUPSD(t, nil), fakeUPSD(t, nil)
+	down := "127.0.0.1:" + strconv.Itoa(closedPort(t))
+	c, err := NewClient([]string{down, "127.0.0.1:" + strconv.Itoa(backup)}, 3493, "", "", "cyberpower")
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer c.Close() //nolint:errcheck
+	var events []string
+	c.OnConnChange(func(event, addr string, err error) { events = append(events, event+" "+addr) })
+
+	// The primary comes back: the next request after failbackInterval
+	// moves to it.
+	c.hosts[0] = "127.0.0.1:" + strconv.Itoa(primary)
+	c.Raw("VER") //nolint:errcheck
+	if len(events) != 0 {
+		t.Fatalf("events = %q, want no failback before failbackInterval", events)
+	}
+	defer func(d time.Duration) { failbackInterval = d }(failbackInterval)
+	failbackInterval = 0
+	if _, err := c.Raw("VER"); err != nil {
+		t.Fatalf("Raw: %v", err)
+	}
+	want := ConnConnected + " 127.0.0.1:" + strconv.Itoa(primary)
+	if len(events) != 1 || events[0] != want || c.server != 0 {
+		t.Errorf("events = %q, server %d; want %q", events, c.server, want)
+	}
+}
+
+func TestNewClient_AuthFailureDoesNotFailOver(t *testing.T) {
+	primary := fakeUPSD(t, map[string]string{
+		"USERNAME monitor": "OK",
+		"PASSWORD wrong":   "ERR ACCESS-DENIED",
+	})
+	backup, conns := countingUPSD(t, nil)
+	hosts := []string{"127.0.0.1:" + strconv.Itoa(primary), "127.0.0.1:" + strconv.Itoa(backup)}
+	if _, err := NewClient(hosts, 3493, "monitor", "wrong", "cyberpower"); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("err = %v, want ErrAuthFailed", err)
+	}
+	if n := conns.Load(); n != 0 {
+		t.Errorf("backup got %d connections, want none", n)
+	}
+}
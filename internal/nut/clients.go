@@ -0,0 +1,35 @@
+package nut
+
+import (
+	"sort"
+	"strings"
+)
+
+// Clients describes the upsd sessions attached to a UPS, typically the
+// upsmon instances that will shut their hosts down on low battery.
+type Clients struct {
+	Hosts     []string // addresses from LIST CLIENT, sorted
+	NumLogins int      // from GET NUMLOGINS, or -1 if upsd didn't answer
+}
+
+// ClientLister reports who is attached to the UPS.
+type ClientLister interface {
+	Clients() (Clients, error)
+}
+
+// parseClientList extracts the host addresses from a LIST CLIENT response:
+//
+//	BEGIN LIST CLIENT cyberpower
+//	CLIENT cyberpower 192.168.1.10
+//	END LIST CLIENT cyberpower
+func parseClientList(resp []string, upsName string) []string {
+	prefix := "CLIENT " + upsName + " "
+	hosts := []string{}
+	for _, line := range resp {
+		if host, ok := strings.CutPrefix(line, prefix); ok {
+			hosts = append(hosts, strings.TrimSpace(host))
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
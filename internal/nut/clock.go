@@ -0,0 +1,25 @@
+package nut
+
+import (
+	"strings"
+	"time"
+)
+
+// upsDateLayouts are the ups.date formats seen across NUT drivers.
+var upsDateLayouts = []string{"2006/01/02", "2006-01-02", "01/02/2006", "01/02/06"}
+
+// UPSClock returns the time reported by drivers that expose the UPS or
+// driver clock as ups.date and ups.time, interpreted in loc.  ok is false
+// when either is missing or in an unrecognised format.
+func UPSClock(vars map[string]string, loc *time.Location) (t time.Time, ok bool) {
+	date, clock := strings.TrimSpace(vars["ups.date"]), strings.TrimSpace(vars["ups.time"])
+	if date == "" || clock == "" {
+		return time.Time{}, false
+	}
+	for _, layout := range upsDateLayouts {
+		if t, err := time.ParseInLocation(layout+" 15:04:05", date+" "+clock, loc); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
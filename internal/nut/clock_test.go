@@ -0,0 +1,29 @@
+package nut
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUPSClock(t *testing.T) {
+	want := time.Date(2026, 3, 1, 12, 30, 5, 0, time.UTC)
+	for _, date := range []string{"2026/03/01", "2026-03-01", "03/01/2026", "03/01/26"} {
+		got, ok := UPSClock(map[string]string{"ups.date": date, "ups.time": "12:30:05"}, time.UTC)
+		if !ok || !got.Equal(want) {
+			t.Errorf("UPSClock(%q) = %s, %v; want %s", date, got, ok, want)
+		}
+	}
+}
+
+func TestUPSClock_Unavailable(t *testing.T) {
+	for _, vars := range []map[string]string{
+		{},
+		{"ups.date": "2026/03/01"},
+		{"ups.date": "1 March 2026", "ups.time": "12:30:05"},
+		{"ups.date": "2026/03/01", "ups.time": "noon"},
+	} {
+		if _, ok := UPSClock(vars, time.UTC); ok {
+			t.Errorf("UPSClock(%v) should be unavailable", vars)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package nut
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	gonut "github.com/robbiet480/go.nut"
+)
+
+// happyEyeballsDelay is how long a connection attempt gets before the next
+// candidate is started alongside it (RFC 8305 recommends 250 ms).
+const happyEyeballsDelay = 250 * time.Millisecond
+
+// dialNUT opens a upsd session; tests replace it to simulate slow hosts.
+var dialNUT = func(addr string, port int) (gonut.Client, error) {
+	return gonut.Connect(addr, port)
+}
+
+// Endpoint is one upsd server address to try.
+type Endpoint struct {
+	Host string
+	Port int
+}
+
+// String returns the endpoint as host:port, bracketing IPv6 literals.
+func (e Endpoint) String() string {
+	return net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+}
+
+// ParseEndpoints parses a comma-separated list of upsd servers.  Each entry
+// is a host name or IP literal with an optional port: "nut.lan",
+// "10.0.0.5:3494", "::1", "[fd00::5]" or "[fd00::5]:3494".  Entries without
+// a port use defaultPort.
+func ParseEndpoints(hosts string, defaultPort int) ([]Endpoint, error) {
+	var eps []Endpoint
+	for _, item := range strings.Split(hosts, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		ep := Endpoint{Host: item, Port: defaultPort}
+		switch {
+		case net.ParseIP(item) != nil:
+			// Bare IPv6 literal: its colons are not a port separator.
+		case strings.HasPrefix(item, "[") && strings.HasSuffix(item, "]"):
+			ep.Host = item[1 : len(item)-1]
+		case strings.Contains(item, ":"):
+			host, port, err := net.SplitHostPort(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid NUT host %q: %w", item, err)
+			}
+			p, err := strconv.Atoi(port)
+			if err != nil || p < 1 || p > 65535 {
+				return nil, fmt.Errorf("invalid NUT host %q: bad port %q", item, port)
+			}
+			ep = Endpoint{Host: host, Port: p}
+		}
+		eps = append(eps, ep)
+	}
+	if len(eps) == 0 {
+		return nil, errors.New("no NUT host configured")
+	}
+	return eps, nil
+}
+
+// candidates resolves every endpoint afresh and returns the addresses to
+// dial, in configuration order and then resolver order.  Endpoints that
+// fail to resolve are reported in errs and skipped.
+func candidates(eps []Endpoint) (cands []Endpoint, errs []string) {
+	for _, ep := range eps {
+		addrs, err := resolve(ep.Host)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", ep, err))
+			continue
+		}
+		for _, a := range addrs {
+			cands = append(cands, Endpoint{Host: strings.Trim(a, "[]"), Port: ep.Port})
+		}
+	}
+	return cands, errs
+}
+
+type dialResult struct {
+	ep   Endpoint
+	conn gonut.Client
+	err  error
+}
+
+// dialFirst connects to the first candidate that answers, Happy Eyeballs
+// style: candidates are started in order, each one as soon as the previous
+// attempt fails or after delay, whichever comes first, and the earliest
+// success wins.  Sessions that connect after the winner are logged out.
+// The error lists what every candidate returned.
+func dialFirst(cands []Endpoint, delay time.Duration) (gonut.Client, Endpoint, error) {
+	results := make(chan dialResult, len(cands))
+	next, pending := 0, 0
+	start := func() {
+		ep := cands[next]
+		next++
+		pending++
+		go func() {
+			conn, err := dialNUT(bracket(ep.Host), ep.Port)
+			results <- dialResult{ep: ep, conn: conn, err: err}
+		}()
+	}
+
+	var errs []string
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	start()
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				go logoutStragglers(results, pending)
+				return r.conn, r.ep, nil
+			}
+			errs = append(errs, fmt.Sprintf("%s: %v", r.ep, r.err))
+			if next < len(cands) {
+				start()
+				timer.Reset(delay)
+			}
+		case <-timer.C:
+			if next < len(cands) {
+				start()
+				timer.Reset(delay)
+			}
+		}
+	}
+	return gonut.Client{}, Endpoint{}, errors.New(strings.Join(errs, "; "))
+}
+
+// logoutStragglers waits for the n attempts still in flight after a winner
+// was chosen and closes any that connected.
+func logoutStragglers(results <-chan dialResult, n int) {
+	for i := 0; i < n; i++ {
+		if r := <-results; r.err == nil {
+			_, _ = r.conn.Disconnect()
+		}
+	}
+}
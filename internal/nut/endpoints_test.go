@@ -0,0 +1,154 @@
+package nut
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gonut "github.com/robbiet480/go.nut"
+)
+
+func TestParseEndpoints(t *testing.T) {
+	eps, err := ParseEndpoints("nut.lan, 10.0.0.5:3494,::1,[fd00::5],[fd00::6]:3495", 3493)
+	if err != nil {
+		t.Fatalf("ParseEndpoints: %v", err)
+	}
+	want := []Endpoint{
+		{"nut.lan", 3493},
+		{"10.0.0.5", 3494},
+		{"::1", 3493},
+		{"fd00::5", 3493},
+		{"fd00::6", 3495},
+	}
+	if len(eps) != len(want) {
+		t.Fatalf("endpoints = %+v, want %+v", eps, want)
+	}
+	for i := range want {
+		if eps[i] != want[i] {
+			t.Errorf("endpoint %d = %+v, want %+v", i, eps[i], want[i])
+		}
+	}
+}
+
+func TestParseEndpoints_Invalid(t *testing.T) {
+	for _, s := range []string{"", " , ", "nut.lan:http", "nut.lan:0", "[fd00::5]:99999", "a:b:c:3493x"} {
+		if _, err := ParseEndpoints(s, 3493); err == nil {
+			t.Errorf("ParseEndpoints(%q): expected error", s)
+		}
+	}
+}
+
+func TestEndpoint_String(t *testing.T) {
+	if got := (Endpoint{"fd00::5", 3493}).String(); got != "[fd00::5]:3493" {
+		t.Errorf("String = %q", got)
+	}
+	if got := (Endpoint{"nut.lan", 3493}).String(); got != "nut.lan:3493" {
+		t.Errorf("String = %q", got)
+	}
+}
+
+func TestCandidates(t *testing.T) {
+	fakeDNS(t, map[string][]string{"nut.lan": {"10.0.0.1", "fd00::1"}})
+	cands, errs := candidates([]Endpoint{{"missing.lan", 3493}, {"nut.lan", 3494}, {"::1", 3493}})
+	want := []Endpoint{{"10.0.0.1", 3494}, {"fd00::1", 3494}, {"::1", 3493}}
+	if len(cands) != len(want) {
+		t.Fatalf("candidates = %+v, want %+v", cands, want)
+	}
+	for i := range want {
+		if cands[i] != want[i] {
+			t.Errorf("candidate %d = %+v, want %+v", i, cands[i], want[i])
+		}
+	}
+	if len(errs) != 1 || !strings.HasPrefix(errs[0], "missing.lan:3493: ") {
+		t.Errorf("errs = %q", errs)
+	}
+}
+
+// fakeDial replaces dialNUT: hosts in slow hang for the given time and then
+// fail, "fail" fails at once, and anything else dials for real.
+func fakeDial(t *testing.T, slow map[string]time.Duration) *[]string {
+	t.Helper()
+	var mu sync.Mutex
+	var dialled []string
+	orig := dialNUT
+	dialNUT = func(addr string, port int) (gonut.Client, error) {
+		mu.Lock()
+		dialled = append(dialled, addr)
+		mu.Unlock()
+		if d, ok := slow[addr]; ok {
+			time.Sleep(d)
+			return gonut.Client{}, errors.New("i/o timeout")
+		}
+		if addr == "fail" {
+			return gonut.Client{}, errors.New("connection refused")
+		}
+		return orig(addr, port)
+	}
+	t.Cleanup(func() { dialNUT = orig })
+	return &dialled
+}
+
+func TestDialFirst_SlowCandidateDoesNotBlock(t *testing.T) {
+	port := fakeUPSD(t, nil)
+	fakeDial(t, map[string]time.Duration{"[fd00::dead]": 2 * time.Second})
+
+	start := time.Now()
+	conn, ep, err := dialFirst([]Endpoint{{"fd00::dead", port}, {"127.0.0.1", port}}, 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("dialFirst: %v", err)
+	}
+	defer conn.Disconnect() //nolint:errcheck
+	if ep.Host != "127.0.0.1" {
+		t.Errorf("winner = %+v, want 127.0.0.1", ep)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %s: the slow candidate should not hold up the next one", elapsed)
+	}
+}
+
+func TestDialFirst_FailureStartsNextImmediately(t *testing.T) {
+	port := fakeUPSD(t, nil)
+	dialled := fakeDial(t, nil)
+
+	conn, ep, err := dialFirst([]Endpoint{{"fail", port}, {"127.0.0.1", port}}, time.Hour)
+	if err != nil {
+		t.Fatalf("dialFirst: %v", err)
+	}
+	defer conn.Disconnect() //nolint:errcheck
+	if ep.Host != "127.0.0.1" || len(*dialled) != 2 {
+		t.Errorf("winner = %+v after %q", ep, *dialled)
+	}
+}
+
+func TestDialFirst_AllFail(t *testing.T) {
+	fakeDial(t, map[string]time.Duration{"slow": 10 * time.Millisecond})
+	_, _, err := dialFirst([]Endpoint{{"fail", 3493}, {"slow", 3493}}, time.Millisecond)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "fail:3493: connection refused") || !strings.Contains(err.Error(), "slow:3493: i/o timeout") {
+		t.Errorf("err = %v, want every candidate's error", err)
+	}
+}
+
+func TestClient_Connect_HostList(t *testing.T) {
+	port := fakeUPSD(t, nil)
+	fakeDNS(t, nil)
+	c, err := NewClient([]string{"127.0.0.2:1, 127.0.0.1:" + strconv.Itoa(port)}, 3493, "", "", "cyberpower")
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer c.Close() //nolint:errcheck
+	if c.Addr() != "127.0.0.1:"+strconv.Itoa(port) {
+		t.Errorf("Addr = %q", c.Addr())
+	}
+}
+
+func TestClient_Connect_InvalidHostList(t *testing.T) {
+	if _, err := NewClient([]string{"nut.lan:http"}, 3493, "", "", "cyberpower"); err == nil {
+		t.Fatal("expected error for an invalid host entry")
+	}
+}
@@ -1,17 +1,38 @@
 package nut
 
+import "fmt"
+
 // FakePoller is a test double for Poller.
 //
 // Single-snapshot mode: pre-seed Variables; every Poll() returns that slice.
 // Sequence mode: pre-seed Sequence; each Poll() returns the next element.
 // When the sequence is exhausted the last element is repeated, simulating a
 // steady post-event state.  Set Err to inject a failure on every call.
+//
+// Raw answers from RawReplies and records each line in RawCommands.
+// Clients returns ClientList, or ClientsErr if set.
+// InstCmd records each command in InstCmds and returns InstCmdErr.
+// SetVar records each "name=value" in SetVars and returns SetVarErr.
 type FakePoller struct {
 	Variables []Variable   // returned when Sequence is nil/empty
 	Sequence  [][]Variable // each Poll() advances through this list
 	Err       error
 	CallCount int
 	Closed    bool
+
+	RawReplies  map[string][]string
+	RawCommands []string
+
+	ClientList Clients
+	ClientsErr error
+
+	InstCmds   []string
+	InstCmdErr error
+
+	SetVars   []string
+	SetVarErr error
+
+	Timings Timings // returned by LastTimings
 }
 
 // Poll returns the pre-seeded variables for the current call index,
@@ -36,6 +57,45 @@ func (f *FakePoller) Poll() ([]Variable, error) {
 	return out, nil
 }
 
+// Raw returns RawReplies[line], applying the same read-only check as the
+// real client.  Lines without a canned reply fail like an upsd ERR would.
+func (f *FakePoller) Raw(line string) ([]string, error) {
+	f.RawCommands = append(f.RawCommands, line)
+	if err := CheckReadOnly(line); err != nil {
+		return nil, err
+	}
+	resp, ok := f.RawReplies[line]
+	if !ok {
+		return nil, fmt.Errorf("no canned reply for %q", line)
+	}
+	return resp, nil
+}
+
+// Clients returns ClientList, or ClientsErr if set.
+func (f *FakePoller) Clients() (Clients, error) {
+	if f.ClientsErr != nil {
+		return Clients{}, f.ClientsErr
+	}
+	return f.ClientList, nil
+}
+
+// InstCmd records cmd and returns InstCmdErr.
+func (f *FakePoller) InstCmd(cmd string) error {
+	f.InstCmds = append(f.InstCmds, cmd)
+	return f.InstCmdErr
+}
+
+// SetVar records name=value and returns SetVarErr.
+func (f *FakePoller) SetVar(name, value string) error {
+	f.SetVars = append(f.SetVars, name+"="+value)
+	return f.SetVarErr
+}
+
+// LastTimings returns the pre-seeded Timings.
+func (f *FakePoller) LastTimings() Timings {
+	return f.Timings
+}
+
 // Close records that the poller was closed.
 func (f *FakePoller) Close() error {
 	f.Closed = true
@@ -49,4 +109,13 @@ func (f *FakePoller) Reset() {
 	f.Err = nil
 	f.CallCount = 0
 	f.Closed = false
+	f.RawReplies = nil
+	f.RawCommands = nil
+	f.ClientList = Clients{}
+	f.ClientsErr = nil
+	f.InstCmds = nil
+	f.InstCmdErr = nil
+	f.SetVars = nil
+	f.SetVarErr = nil
+	f.Timings = Timings{}
 }
@@ -0,0 +1,112 @@
+package nut
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SetVariables makes Poll fetch only names, each with a GET VAR of its
+// own, instead of every variable with LIST VAR, for upsd servers where
+// listing a hundred variables takes far longer than asking for the few
+// that matter.  Variables the UPS doesn't have are left out.  No names
+// goes back to LIST VAR.
+func (c *Client) SetVariables(names []string) {
+	c.tmu.Lock()
+	defer c.tmu.Unlock()
+	c.vars = names
+}
+
+// variables returns the names set by SetVariables.
+func (c *Client) variables() []string {
+	c.tmu.Lock()
+	defer c.tmu.Unlock()
+	return c.vars
+}
+
+// getVars fetches names of the UPS upsName with GET VAR, one at a time:
+// go.nut reads each reply before the next request can be sent, so they
+// can't be pipelined.  The replies stand in for the checks Poll makes
+// otherwise: ERR DATA-STALE for a driver that stopped updating and ERR
+// UNKNOWN-UPS for a UPS upsd doesn't serve.
+func (c *Client) getVars(upsName string, names []string) ([]Variable, error) {
+	vars := make([]Variable, 0, len(names))
+	for _, name := range names {
+		resp, err := c.conn.SendCommand("GET VAR " + upsName + " " + name)
+		switch {
+		case isDataStale(err):
+			return nil, fmt.Errorf("polling %q: %w", upsName, ErrDataStale)
+		case isUnknownUPS(err):
+			return nil, fmt.Errorf("UPS %q %w", upsName, ErrUPSNotFound)
+		case isVarNotSupported(err):
+			continue
+		case err != nil:
+			c.markStale(err)
+			return nil, fmt.Errorf("getting %s for %q: %w", name, upsName, err)
+		}
+		value, ok := parseVar(resp, upsName, name)
+		if !ok {
+			return nil, fmt.Errorf("getting %s for %q: unexpected reply %q", name, upsName, strings.Join(resp, "\\n"))
+		}
+		vars = append(vars, Variable{Name: name, Value: value})
+	}
+	return vars, nil
+}
+
+// listVars fetches every variable of the UPS upsName with LIST VAR.  The
+// VAR <ups> <name> "<value>" lines are parsed here, as for GET VAR, so the
+// values are what upsd sent: go.nut's UPS.GetVariables would need a UPS
+// from GetUPSList and converts numbers and enabled/disabled on the way.
+func (c *Client) listVars(upsName string) ([]Variable, error) {
+	resp, err := c.conn.SendCommand("LIST VAR " + upsName)
+	if err != nil {
+		return nil, err
+	}
+	prefix := "VAR " + upsName + " "
+	var vars []Variable
+	for _, line := range resp {
+		rest, ok := strings.CutPrefix(line, prefix)
+		if !ok {
+			continue
+		}
+		name, quoted, _ := strings.Cut(rest, " ")
+		value, err := strconv.Unquote(quoted)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected reply %q", line)
+		}
+		vars = append(vars, Variable{Name: name, Value: value})
+	}
+	return vars, nil
+}
+
+// parseVar returns the value in resp, upsd's reply to GET VAR upsName
+// name: VAR <ups> <name> "<value>", with \" and \\ escaped.
+func parseVar(resp []string, upsName, name string) (string, bool) {
+	if len(resp) == 0 {
+		return "", false
+	}
+	quoted, ok := strings.CutPrefix(resp[0], "VAR "+upsName+" "+name+" ")
+	if !ok {
+		return "", false
+	}
+	value, err := strconv.Unquote(quoted)
+	if err != nil {
+		return "", false
+	}
+	return value, true
+}
+
+// varNotSupported is go.nut's message for ERR VAR-NOT-SUPPORTED, with the
+// typographic apostrophe go.nut writes it with.
+const varNotSupported = "The specified UPS doesn’t support the variable"
+
+// isUnknownUPS and isVarNotSupported report whether err is upsd's ERR
+// UNKNOWN-UPS or ERR VAR-NOT-SUPPORTED reply, matching go.nut's prose for
+// them as isDataStale does, or the bare code it passes on otherwise.
+func isUnknownUPS(err error) bool {
+	return err != nil && (strings.Contains(err.Error(), "not known to upsd") || strings.Contains(err.Error(), "UNKNOWN-UPS"))
+}
+
+func isVarNotSupported(err error) bool {
+	return err != nil && (strings.Contains(err.Error(), varNotSupported) || strings.Contains(err.Error(), "VAR-NOT-SUPPORTED"))
+}
@@ -0,0 +1,75 @@
+package nut
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestClient_SetVariables(t *testing.T) {
+	port := fakeUPSD(t, map[string]string{
+		"GET VAR cyberpower ups.status":       `VAR cyberpower ups.status "OB DISCHRG"`,
+		"GET VAR cyberpower battery.charge":   `VAR cyberpower battery.charge "87"`,
+		"GET VAR cyberpower battery.runtime":  "ERR VAR-NOT-SUPPORTED",
+		"GET VAR cyberpower ups.mfr":          `VAR cyberpower ups.mfr "Cyber \"Power\""`,
+		"LIST VAR cyberpower":                 "ERR ACCESS-DENIED",
+		"GET VAR cyberpower driver.parameter": `VAR rack driver.parameter "x"`,
+	})
+	c, err := NewClient([]string{"127.0.0.1"}, port, "", "", "cyberpower")
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer c.Close() //nolint:errcheck
+
+	c.SetVariables([]string{"ups.status", "battery.charge", "battery.runtime", "ups.mfr"})
+	vars, err := c.Poll()
+	if err != nil {
+		t.Fatalf("Poll: %v", err)
+	}
+	want := []Variable{{"ups.status", "OB DISCHRG"}, {"battery.charge", "87"}, {"ups.mfr", `Cyber "Power"`}}
+	if !slices.Equal(vars, want) {
+		t.Errorf("Poll = %+v, want %+v", vars, want)
+	}
+	if tm := c.LastTimings(); tm.GetVars <= 0 || tm.Status != 0 || tm.ListUPS != 0 {
+		t.Errorf("Timings = %+v, want only get_vars timed", tm)
+	}
+
+	c.SetVariables([]string{"driver.parameter"})
+	if _, err := c.Poll(); err == nil {
+		t.Error("expected an error for a reply about another UPS")
+	}
+}
+
+func TestClient_SetVariables_Errors(t *testing.T) {
+	port := fakeUPSD(t, map[string]string{
+		"GET VAR cyberpower ups.status": "ERR DATA-STALE",
+		"GET VAR rack ups.status":       "ERR UNKNOWN-UPS",
+	})
+	for ups, want := range map[string]error{"cyberpower": ErrDataStale, "rack": ErrUPSNotFound} {
+		c, err := NewClient([]string{"127.0.0.1"}, port, "", "", ups)
+		if err != nil {
+			t.Fatalf("NewClient: %v", err)
+		}
+		c.SetVariables([]string{"ups.status"})
+		if _, err := c.Poll(); !errors.Is(err, want) {
+			t.Errorf("%s: Poll error = %v, want %v", ups, err, want)
+		}
+		if c.stale {
+			t.Errorf("%s: %v should not mark the connection for reconnect", ups, want)
+		}
+		c.Close() //nolint:errcheck
+	}
+}
+
+func TestIsVarNotSupported(t *testing.T) {
+	for err, want := range map[error]bool{
+		errors.New("The specified UPS doesn’t support the variable"): true, // go.nut
+		errors.New("VAR-NOT-SUPPORTED"):                              true,
+		errors.New("UNKNOWN-UPS"):                                    false,
+		nil:                                                          false,
+	} {
+		if got := isVarNotSupported(err); got != want {
+			t.Errorf("isVarNotSupported(%v) = %v, want %v", err, got, want)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package nut
+
+import (
+	"sort"
+	"time"
+)
+
+// HoldLast fills in variables that a driver intermittently drops from LIST
+// VAR with their last reported value, for up to MaxAge after they were last
+// seen.  It is not safe for concurrent use.
+type HoldLast struct {
+	MaxAge time.Duration
+
+	last map[string]heldValue
+}
+
+type heldValue struct {
+	value  string
+	seenAt time.Time
+}
+
+// Apply records every variable in vars as seen at now and returns a copy of
+// vars with recently-seen missing variables restored, plus the sorted names
+// of the variables that were restored.  Values older than MaxAge are
+// forgotten, so a variable that is really gone stops being published.
+func (h *HoldLast) Apply(vars map[string]string, now time.Time) (map[string]string, []string) {
+	if h.last == nil {
+		h.last = make(map[string]heldValue)
+	}
+
+	out := make(map[string]string, len(vars))
+	for name, value := range vars {
+		out[name] = value
+		h.last[name] = heldValue{value: value, seenAt: now}
+	}
+
+	var held []string
+	for name, hv := range h.last {
+		if _, ok := vars[name]; ok {
+			continue
+		}
+		if now.Sub(hv.seenAt) > h.MaxAge {
+			delete(h.last, name)
+			continue
+		}
+		out[name] = hv.value
+		held = append(held, name)
+	}
+	sort.Strings(held)
+	return out, held
+}
@@ -0,0 +1,61 @@
+package nut
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2026, 2, 23, 16, 40, 0, 0, time.UTC)
+
+func TestHoldLast_RestoresMissingVariable(t *testing.T) {
+	h := &HoldLast{MaxAge: time.Minute}
+	h.Apply(map[string]string{"ups.status": "OL", "battery.runtime": "4920"}, t0)
+
+	out, held := h.Apply(map[string]string{"ups.status": "OL"}, t0.Add(30*time.Second))
+	if out["battery.runtime"] != "4920" {
+		t.Errorf("battery.runtime = %q, want held value 4920", out["battery.runtime"])
+	}
+	if !reflect.DeepEqual(held, []string{"battery.runtime"}) {
+		t.Errorf("held = %v, want [battery.runtime]", held)
+	}
+}
+
+func TestHoldLast_ExpiresAfterMaxAge(t *testing.T) {
+	h := &HoldLast{MaxAge: time.Minute}
+	h.Apply(map[string]string{"battery.runtime": "4920"}, t0)
+
+	out, held := h.Apply(map[string]string{}, t0.Add(61*time.Second))
+	if _, ok := out["battery.runtime"]; ok {
+		t.Error("battery.runtime should not be held past MaxAge")
+	}
+	if len(held) != 0 {
+		t.Errorf("held = %v, want none", held)
+	}
+
+	// Once expired it stays gone even if the age check would pass again.
+	if out, _ := h.Apply(map[string]string{}, t0.Add(62*time.Second)); len(out) != 0 {
+		t.Errorf("out = %v, want empty after expiry", out)
+	}
+}
+
+func TestHoldLast_AgeMeasuredFromLastSighting(t *testing.T) {
+	h := &HoldLast{MaxAge: time.Minute}
+	h.Apply(map[string]string{"battery.runtime": "4920"}, t0)
+	h.Apply(map[string]string{"battery.runtime": "4800"}, t0.Add(50*time.Second))
+
+	out, _ := h.Apply(map[string]string{}, t0.Add(100*time.Second))
+	if out["battery.runtime"] != "4800" {
+		t.Errorf("battery.runtime = %q, want latest value 4800 still within MaxAge", out["battery.runtime"])
+	}
+}
+
+func TestHoldLast_DoesNotModifyInput(t *testing.T) {
+	h := &HoldLast{MaxAge: time.Minute}
+	h.Apply(map[string]string{"a": "1"}, t0)
+	in := map[string]string{}
+	h.Apply(in, t0.Add(time.Second))
+	if len(in) != 0 {
+		t.Error("Apply must not modify its input map")
+	}
+}
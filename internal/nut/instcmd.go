@@ -0,0 +1,54 @@
+package nut
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidCommand is returned for an instant command name that isn't a
+// plain NUT identifier, so nothing but the one command reaches upsd.
+var ErrInvalidCommand = errors.New("invalid instant command name")
+
+// InstCommander runs NUT instant commands (INSTCMD) such as beeper.disable.
+// upsd only accepts them from a session with a user that upsd.users grants
+// the command to.
+type InstCommander interface {
+	InstCmd(cmd string) error
+}
+
+// ValidCommandName reports whether cmd looks like a NUT instant command
+// name such as test.battery.start.quick: letters, digits, '.', '_' and '-'.
+func ValidCommandName(cmd string) bool {
+	if cmd == "" {
+		return false
+	}
+	for _, r := range cmd {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// InstCmd runs the instant command cmd on the configured UPS.
+func (c *Client) InstCmd(cmd string) error {
+	if !ValidCommandName(cmd) {
+		return fmt.Errorf("%w: %q", ErrInvalidCommand, cmd)
+	}
+	l, release := c.acquire()
+	defer release()
+	if err := l.ready(); err != nil {
+		return err
+	}
+	resp, err := l.conn.SendCommand(fmt.Sprintf("INSTCMD %s %s", c.upsName, cmd))
+	if err != nil {
+		l.markStale(err)
+		return fmt.Errorf("INSTCMD %s: %w", cmd, err)
+	}
+	if len(resp) == 0 || resp[0] != "OK" {
+		return fmt.Errorf("INSTCMD %s: unexpected reply %q", cmd, resp)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package nut
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestClient_InstCmd(t *testing.T) {
+	port := fakeUPSD(t, map[string]string{
+		"INSTCMD cyberpower beeper.disable": "OK",
+		"INSTCMD cyberpower beeper.enable":  "OK TRACKING 1bd31808-cb49-4aec-9d75-d056e6f018d2",
+	})
+	c, err := NewClient([]string{"127.0.0.1"}, port, "", "", "cyberpower")
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer c.Close() //nolint:errcheck
+
+	if err := c.InstCmd("beeper.disable"); err != nil {
+		t.Errorf("InstCmd: %v", err)
+	}
+	if err := c.InstCmd("beeper.enable"); err == nil {
+		t.Error("expected error for a reply other than OK")
+	}
+	if err := c.InstCmd("beeper.mute"); err == nil {
+		t.Error("expected error for ERR reply")
+	}
+	if !c.stale {
+		t.Error("connection should be marked stale after an error")
+	}
+}
+
+func TestClient_InstCmd_InvalidName(t *testing.T) {
+	c := &Client{hosts: []string{"127.0.0.1"}, port: 1, stale: true}
+	for _, cmd := range []string{"", "beeper.disable\nFSD cyberpower", "load.off now"} {
+		if err := c.InstCmd(cmd); !errors.Is(err, ErrInvalidCommand) {
+			t.Errorf("InstCmd(%q) = %v, want ErrInvalidCommand before connecting", cmd, err)
+		}
+	}
+	if !ValidCommandName("test.battery.start.quick") || !ValidCommandName("shutdown_return-2") {
+		t.Error("valid names rejected")
+	}
+}
+
+func TestClient_InstCmd_ReconnectFails(t *testing.T) {
+	c := &Client{hosts: []string{"127.0.0.1"}, port: 1, stale: true}
+	if err := c.InstCmd("beeper.disable"); err == nil {
+		t.Fatal("expected reconnect error")
+	}
+}
+
+func TestFakePoller_InstCmd(t *testing.T) {
+	f := &FakePoller{}
+	if err := f.InstCmd("beeper.disable"); err != nil {
+		t.Errorf("InstCmd: %v", err)
+	}
+	f.InstCmdErr = errors.New("ACCESS-DENIED")
+	if err := f.InstCmd("beeper.enable"); err == nil {
+		t.Error("expected InstCmdErr")
+	}
+	if len(f.InstCmds) != 2 || f.InstCmds[0] != "beeper.disable" {
+		t.Errorf("InstCmds = %q", f.InstCmds)
+	}
+}
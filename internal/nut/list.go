@@ -0,0 +1,59 @@
+package nut
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrUPSNotFound is returned by Poll when upsd doesn't list the configured
+// UPS: it was renamed in ups.conf, or its driver isn't running.  upsd is
+// still reachable, so polling carries on and picks the UPS up again once
+// it is back.
+var ErrUPSNotFound = errors.New("not found in upsd")
+
+// UPSInfo is a UPS served by upsd, as listed by LIST UPS.
+type UPSInfo struct {
+	Name        string
+	Description string
+}
+
+// List returns the UPSes upsd serves.  It doesn't need the client's UPS
+// name, so a client created with an empty one can be used to find it.
+func (c *Client) List() ([]UPSInfo, error) {
+	l, release := c.acquire()
+	defer release()
+	if err := l.ready(); err != nil {
+		return nil, err
+	}
+	out, err := l.listUPS()
+	if err != nil {
+		l.markStale(err)
+		return nil, fmt.Errorf("listing UPS: %w", err)
+	}
+	return out, nil
+}
+
+// listUPS sends LIST UPS and parses the UPS <name> "<description>" lines
+// of the reply.  go.nut's GetUPSList isn't used: it goes on to fetch the
+// variables, commands and clients of every UPS it lists.
+func (c *Client) listUPS() ([]UPSInfo, error) {
+	resp, err := c.conn.SendCommand("LIST UPS")
+	if err != nil {
+		return nil, err
+	}
+	var out []UPSInfo
+	for _, line := range resp {
+		rest, ok := strings.CutPrefix(line, "UPS ")
+		if !ok {
+			continue
+		}
+		name, desc, _ := strings.Cut(rest, " ")
+		if unquoted, err := strconv.Unquote(desc); err == nil {
+			desc = unquoted
+		}
+		out = append(out, UPSInfo{Name: name, Description: desc})
+	}
+	return out, nil
+}
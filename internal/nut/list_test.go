@@ -0,0 +1,46 @@
+package nut
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestClient_List(t *testing.T) {
+	port := fakeUPSD(t, map[string]string{
+		"LIST UPS": "BEGIN LIST UPS\nUPS cyberpower \"CP1500\"\nUPS rack \"Rack \\\"B\\\" UPS\"\nEND LIST UPS",
+	})
+	c, err := NewClient([]string{"127.0.0.1"}, port, "", "", "")
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer c.Close() //nolint:errcheck
+
+	got, err := c.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	want := []UPSInfo{{"cyberpower", "CP1500"}, {"rack", `Rack "B" UPS`}}
+	if !slices.Equal(got, want) {
+		t.Errorf("List = %+v, want %+v", got, want)
+	}
+}
+
+func TestClient_Poll_UPSNotFound(t *testing.T) {
+	port := fakeUPSD(t, map[string]string{
+		// Nothing else is answered: the reply alone must do.
+		"GET VAR cyberpower ups.status": "ERR UNKNOWN-UPS",
+	})
+	c, err := NewClient([]string{"127.0.0.1"}, port, "", "", "cyberpower")
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer c.Close() //nolint:errcheck
+
+	if _, err := c.Poll(); !errors.Is(err, ErrUPSNotFound) {
+		t.Fatalf("Poll error = %v, want ErrUPSNotFound", err)
+	}
+	if c.stale {
+		t.Error("a missing UPS should not mark the upsd connection for reconnect")
+	}
+}
@@ -0,0 +1,96 @@
+package nut
+
+import (
+	"sync"
+)
+
+// Pool shares a fixed number of upsd connections between the UPSes served
+// by one upsd, so polling many of them neither opens a socket per UPS nor
+// queues every poll behind a single connection.  Up to size requests run at
+// once, each on its own connection; further ones wait for a connection to
+// come free.  Connections are opened on first use and reopened after an
+// error, as for a Client.
+type Pool struct {
+	idle chan *Client
+
+	mu       sync.Mutex
+	handlers []func(event, addr string, err error)
+}
+
+// NewPool returns a pool of up to size connections to the upsd servers in
+// hosts and port (see NewClient), logging in with username and password
+// when username is set.  Nothing is dialled until the pool is first used.
+func NewPool(hosts []string, port int, username, password string, size int) *Pool {
+	size = max(size, 1)
+	p := &Pool{idle: make(chan *Client, size)}
+	for range size {
+		p.idle <- &Client{
+			hosts:    hosts,
+			port:     port,
+			username: username,
+			password: password,
+			stale:    true,
+			onConn:   p.report,
+		}
+	}
+	return p
+}
+
+// Client returns a Client for the UPS upsName whose requests use the
+// pool's connections, after checking that a connection to upsd can be
+// made.
+func (p *Pool) Client(upsName string) (*Client, error) {
+	l, release := p.get()
+	defer release()
+	if err := l.ready(); err != nil {
+		return nil, err
+	}
+	return &Client{upsName: upsName, pool: p}, nil
+}
+
+// get takes an idle connection, waiting for one if all are in use, and
+// returns it locked along with the function that hands it back.
+func (p *Pool) get() (*Client, func()) {
+	l := <-p.idle
+	l.mu.Lock()
+	return l, func() {
+		l.mu.Unlock()
+		p.idle <- l
+	}
+}
+
+// onConnChange adds f to the handlers told about changes to any of the
+// pool's connections.
+func (p *Pool) onConnChange(f func(event, addr string, err error)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handlers = append(p.handlers, f)
+}
+
+// report passes a connection event on to every registered handler.
+func (p *Pool) report(event, addr string, err error) {
+	p.mu.Lock()
+	handlers := p.handlers
+	p.mu.Unlock()
+	for _, f := range handlers {
+		f(event, addr, err)
+	}
+}
+
+// Close disconnects every pool connection, waiting for requests in
+// progress to finish.  A Client from the pool reconnects if used again.
+func (p *Pool) Close() error {
+	conns := make([]*Client, 0, cap(p.idle))
+	for range cap(p.idle) {
+		conns = append(conns, <-p.idle)
+	}
+	var firstErr error
+	for _, l := range conns {
+		if err := l.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		l.stale = true
+		p.idle <- l
+	}
+	return firstErr
+}
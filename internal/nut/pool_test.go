@@ -0,0 +1,88 @@
+package nut
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+)
+
+func TestPool_SharesConnections(t *testing.T) {
+	replies := map[string]string{}
+	for i := 1; i <= 5; i++ {
+		name := fmt.Sprintf("ups%d", i)
+		replies["LIST CLIENT "+name] = fmt.Sprintf("BEGIN LIST CLIENT %s\nCLIENT %s 10.0.0.%d\nEND LIST CLIENT %s", name, name, i, name)
+	}
+	port, conns := countingUPSD(t, replies)
+	p := NewPool([]string{"127.0.0.1"}, port, "", "", 2)
+	defer p.Close() //nolint:errcheck
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 5; i++ {
+		c, err := p.Client(fmt.Sprintf("ups%d", i))
+		if err != nil {
+			t.Fatalf("Client: %v", err)
+		}
+		for range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				got, err := c.Clients()
+				if want := fmt.Sprintf("10.0.0.%d", i); err != nil || len(got.Hosts) != 1 || got.Hosts[0] != want {
+					t.Errorf("ups%d: Clients = %+v, %v; want %s", i, got, err, want)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	if n := conns.Load(); n > 2 {
+		t.Errorf("%d connections opened, want at most 2", n)
+	}
+}
+
+func TestPool_ClientConnectFails(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not allocate test port: %v", err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	if _, err := NewPool([]string{"127.0.0.1"}, port, "", "", 2).Client("ups1"); err == nil {
+		t.Fatal("expected error when nothing is listening")
+	}
+}
+
+func TestPool_ReconnectsAfterErrorAndClose(t *testing.T) {
+	port := fakeUPSD(t, map[string]string{
+		"GET VAR ups1 battery.charge": `VAR ups1 battery.charge "100"`,
+	})
+	p := NewPool([]string{"127.0.0.1"}, port, "", "", 1)
+	defer p.Close() //nolint:errcheck
+	c, err := p.Client("ups1")
+	if err != nil {
+		t.Fatalf("Client: %v", err)
+	}
+	var events []string
+	c.OnConnChange(func(event, addr string, err error) { events = append(events, event) })
+
+	if _, err := c.Raw("GET VAR ups1 nope"); err == nil {
+		t.Fatal("expected error for ERR reply")
+	}
+	if _, err := c.Raw("GET VAR ups1 battery.charge"); err != nil {
+		t.Errorf("Raw after error: %v", err)
+	}
+	if want := []string{ConnLost, ConnConnected}; fmt.Sprint(events) != fmt.Sprint(want) {
+		t.Errorf("events = %q, want %q", events, want)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("Client.Close: %v", err)
+	}
+	if _, err := c.Raw("GET VAR ups1 battery.charge"); err != nil {
+		t.Errorf("Raw after Close: %v", err)
+	}
+}
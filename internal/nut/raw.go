@@ -0,0 +1,36 @@
+package nut
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RawCommander sends single NUT protocol lines, for diagnostics.
+type RawCommander interface {
+	Raw(line string) ([]string, error)
+}
+
+// readOnlyCommands are the upsd protocol verbs that cannot change UPS or
+// session state, i.e. what upsc itself uses.
+var readOnlyCommands = map[string]bool{
+	"GET":    true,
+	"LIST":   true,
+	"VER":    true,
+	"NETVER": true,
+	"HELP":   true,
+}
+
+// CheckReadOnly returns an error unless line is a single read-only upsd
+// command.  SET, INSTCMD, LOGIN, USERNAME/PASSWORD, FSD and the like are
+// rejected, as is anything containing a line break that would smuggle in a
+// second command.
+func CheckReadOnly(line string) error {
+	if strings.ContainsAny(line, "\r\n") {
+		return fmt.Errorf("command must be a single line")
+	}
+	verb, _, _ := strings.Cut(strings.TrimSpace(line), " ")
+	if !readOnlyCommands[strings.ToUpper(verb)] {
+		return fmt.Errorf("command %q not allowed: only GET, LIST, VER, NETVER and HELP are accepted", verb)
+	}
+	return nil
+}
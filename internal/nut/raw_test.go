@@ -0,0 +1,44 @@
+package nut
+
+import "testing"
+
+func TestCheckReadOnly(t *testing.T) {
+	tests := []struct {
+		line string
+		ok   bool
+	}{
+		{"GET VAR cyberpower battery.charge", true},
+		{"LIST VAR cyberpower", true},
+		{"list ups", true},
+		{"VER", true},
+		{"SET VAR cyberpower ups.delay.shutdown 20", false},
+		{"INSTCMD cyberpower test.battery.start", false},
+		{"FSD cyberpower", false},
+		{"USERNAME admin", false},
+		{"GET VAR cyberpower ups.status\nINSTCMD cyberpower load.off", false},
+		{"", false},
+	}
+	for _, tc := range tests {
+		err := CheckReadOnly(tc.line)
+		if (err == nil) != tc.ok {
+			t.Errorf("CheckReadOnly(%q) = %v, want ok=%v", tc.line, err, tc.ok)
+		}
+	}
+}
+
+func TestFakePoller_Raw(t *testing.T) {
+	f := &FakePoller{RawReplies: map[string][]string{"VER": {"Network UPS Tools upsd 2.8.1"}}}
+	resp, err := f.Raw("VER")
+	if err != nil || len(resp) != 1 {
+		t.Fatalf("Raw(VER) = %v, %v", resp, err)
+	}
+	if _, err := f.Raw("LIST UPS"); err == nil {
+		t.Error("expected error for a command without a canned reply")
+	}
+	if _, err := f.Raw("FSD cyberpower"); err == nil {
+		t.Error("expected error for a write command")
+	}
+	if len(f.RawCommands) != 3 {
+		t.Errorf("RawCommands = %v, want 3 entries", f.RawCommands)
+	}
+}
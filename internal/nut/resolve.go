@@ -0,0 +1,41 @@
+package nut
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+)
+
+// lookupTimeout bounds a single DNS lookup of the NUT host.
+const lookupTimeout = 10 * time.Second
+
+// lookupHost resolves a host name to its addresses.  It is a variable so
+// tests can substitute their own DNS.
+var lookupHost = net.DefaultResolver.LookupHost
+
+// resolve returns every address of host, in resolver order, in the form
+// gonut.Connect expects: IPv6 addresses are bracketed so that appending
+// ":port" stays unambiguous.  IP literals are returned as they are.
+func resolve(host string) ([]string, error) {
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+		return []string{bracket(ip.String())}, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+	addrs, err := lookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for i, a := range addrs {
+		addrs[i] = bracket(a)
+	}
+	return addrs, nil
+}
+
+func bracket(addr string) string {
+	if strings.Contains(addr, ":") {
+		return "[" + addr + "]"
+	}
+	return addr
+}
@@ -0,0 +1,112 @@
+package nut
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// fakeDNS replaces lookupHost for the duration of the test, answering from
+// answers and counting lookups.
+func fakeDNS(t *testing.T, answers map[string][]string) *int {
+	t.Helper()
+	calls := 0
+	orig := lookupHost
+	lookupHost = func(_ context.Context, host string) ([]string, error) {
+		calls++
+		addrs, ok := answers[host]
+		if !ok {
+			return nil, errors.New("no such host")
+		}
+		return append([]string(nil), addrs...), nil
+	}
+	t.Cleanup(func() { lookupHost = orig })
+	return &calls
+}
+
+func TestResolve(t *testing.T) {
+	fakeDNS(t, map[string][]string{"nut.example": {"192.0.2.10", "2001:db8::10"}})
+
+	got, err := resolve("nut.example")
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if strings.Join(got, " ") != "192.0.2.10 [2001:db8::10]" {
+		t.Errorf("resolve = %q", got)
+	}
+	if _, err := resolve("missing.example"); err == nil {
+		t.Error("expected lookup error")
+	}
+}
+
+func TestResolve_IPLiteralsSkipDNS(t *testing.T) {
+	calls := fakeDNS(t, nil)
+	for host, want := range map[string]string{
+		"127.0.0.1": "127.0.0.1",
+		"::1":       "[::1]",
+		"[::1]":     "[::1]",
+	} {
+		got, err := resolve(host)
+		if err != nil || len(got) != 1 || got[0] != want {
+			t.Errorf("resolve(%q) = %q, %v; want [%s]", host, got, err, want)
+		}
+	}
+	if *calls != 0 {
+		t.Errorf("IP literals caused %d DNS lookups", *calls)
+	}
+}
+
+func TestClient_Connect_TriesEveryAddress(t *testing.T) {
+	port := fakeUPSD(t, nil)
+	// 127.0.0.2 is loopback too but nothing listens there, like a host
+	// whose first address is dead.
+	fakeDNS(t, map[string][]string{"nut.example": {"127.0.0.2", "127.0.0.1"}})
+
+	c, err := NewClient([]string{"nut.example"}, port, "", "", "cyberpower")
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	c.Close() //nolint:errcheck
+}
+
+func TestClient_Connect_AllAddressesFail(t *testing.T) {
+	fakeDNS(t, map[string][]string{"nut.example": {"127.0.0.2", "127.0.0.3"}})
+	_, err := NewClient([]string{"nut.example"}, 1, "", "", "cyberpower")
+	if err == nil {
+		t.Fatal("expected error when no address answers")
+	}
+	if !strings.Contains(err.Error(), "127.0.0.2") || !strings.Contains(err.Error(), "127.0.0.3") {
+		t.Errorf("error %q should mention every address tried", err)
+	}
+}
+
+func TestClient_Connect_LookupError(t *testing.T) {
+	fakeDNS(t, nil)
+	if _, err := NewClient([]string{"missing.example"}, 3493, "", "", "cyberpower"); err == nil {
+		t.Fatal("expected error for unresolvable host")
+	}
+}
+
+func TestClient_Reconnect_ResolvesAgain(t *testing.T) {
+	port := fakeUPSD(t, nil)
+	answers := map[string][]string{"nut.example": {"127.0.0.1"}}
+	calls := fakeDNS(t, answers)
+
+	c, err := NewClient([]string{"nut.example"}, port, "", "", "cyberpower")
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer c.Close() //nolint:errcheck
+
+	// The record moves to a dead address: the reconnect must look it up
+	// again rather than reuse the old one.
+	answers["nut.example"] = []string{"127.0.0.2"}
+	c.stale = true
+	if _, err := c.Clients(); err == nil {
+		t.Fatal("expected reconnect to the new, dead address to fail")
+	}
+	if *calls != 2 {
+		t.Errorf("DNS lookups = %d, want 2", *calls)
+	}
+}
@@ -0,0 +1,87 @@
+package nut
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// scanParallel bounds the connection attempts Scan has in flight.
+const scanParallel = 64
+
+// Scan looks for upsd on this host and its local networks: it tries port
+// on localhost and on every address of each IPv4 network the host is on,
+// and returns those that accept a TCP connection within timeout, localhost
+// first and the rest in address order.  Networks larger than a /24 are
+// only scanned in the /24 around the host's own address, so a scan takes
+// a few seconds at most.
+func Scan(ctx context.Context, port int, timeout time.Duration) []string {
+	addrs, _ := net.InterfaceAddrs()
+	targets := append([]string{"localhost"}, scanTargets(addrs)...)
+
+	open := make([]bool, len(targets))
+	sem := make(chan struct{}, scanParallel)
+	var wg sync.WaitGroup
+	d := net.Dialer{Timeout: timeout}
+	for i, host := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+			if err == nil {
+				conn.Close() //nolint:errcheck
+				open[i] = true
+			}
+		}()
+	}
+	wg.Wait()
+
+	var found []string
+	for i, host := range targets {
+		if open[i] {
+			found = append(found, host)
+		}
+	}
+	return found
+}
+
+// scanTargets lists the host addresses of the IPv4 networks in addrs,
+// limited to the /24 around the interface's address, leaving out the
+// network and broadcast addresses and loopback networks.
+func scanTargets(addrs []net.Addr) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipnet.IP.To4()
+		if ip == nil || ip.IsLoopback() {
+			continue
+		}
+		ones, _ := ipnet.Mask.Size()
+		if ones < 24 {
+			ones = 24
+		}
+		if ones > 30 {
+			continue
+		}
+		mask := net.CIDRMask(ones, 32)
+		base := ip.Mask(mask)
+		size := uint32(1) << (32 - ones)
+		for n := uint32(1); n < size-1; n++ {
+			v := uint32(base[0])<<24 | uint32(base[1])<<16 | uint32(base[2])<<8 | uint32(base[3]) + n
+			host := net.IPv4(byte(v>>24), byte(v>>16), byte(v>>8), byte(v)).String()
+			if !seen[host] {
+				seen[host] = true
+				out = append(out, host)
+			}
+		}
+	}
+	return out
+}
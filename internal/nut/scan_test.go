@@ -0,0 +1,34 @@
+package nut
+
+import (
+	"context"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestScanTargets(t *testing.T) {
+	_, lan, _ := net.ParseCIDR("192.168.1.0/24")
+	lan.IP = net.ParseIP("192.168.1.20").To4()
+	_, big, _ := net.ParseCIDR("10.0.0.0/8")
+	big.IP = net.ParseIP("10.1.2.3").To4()
+	_, lo, _ := net.ParseCIDR("127.0.0.0/8")
+	_, v6, _ := net.ParseCIDR("fd00::/64")
+
+	got := scanTargets([]net.Addr{lan, big, lo, v6, lan})
+	if len(got) != 2*254 {
+		t.Fatalf("%d targets, want two /24s of hosts", len(got))
+	}
+	if got[0] != "192.168.1.1" || got[253] != "192.168.1.254" || got[254] != "10.1.2.1" || got[507] != "10.1.2.254" {
+		t.Errorf("targets run %s…%s, %s…%s", got[0], got[253], got[254], got[507])
+	}
+}
+
+func TestScan_FindsLocalhost(t *testing.T) {
+	port := fakeUPSD(t, nil)
+	found := Scan(context.Background(), port, 200*time.Millisecond)
+	if !slices.Contains(found, "localhost") {
+		t.Errorf("Scan = %v, want localhost found", found)
+	}
+}
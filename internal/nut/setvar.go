@@ -0,0 +1,39 @@
+package nut
+
+import (
+	"fmt"
+	"strings"
+)
+
+// VarSetter changes writable UPS variables (SET VAR) such as
+// battery.charge.low.  Like INSTCMD, upsd only accepts it from a session
+// whose user upsd.users grants SET.
+type VarSetter interface {
+	SetVar(name, value string) error
+}
+
+// SetVar sets the variable name to value on the configured UPS.  The value
+// is sent quoted, so it may contain spaces.
+func (c *Client) SetVar(name, value string) error {
+	if !ValidCommandName(name) {
+		return fmt.Errorf("invalid variable name %q", name)
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("SET VAR %s: value contains a line break", name)
+	}
+	l, release := c.acquire()
+	defer release()
+	if err := l.ready(); err != nil {
+		return err
+	}
+	quoted := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value)
+	resp, err := l.conn.SendCommand(fmt.Sprintf(`SET VAR %s %s "%s"`, c.upsName, name, quoted))
+	if err != nil {
+		l.markStale(err)
+		return fmt.Errorf("SET VAR %s: %w", name, err)
+	}
+	if len(resp) == 0 || resp[0] != "OK" {
+		return fmt.Errorf("SET VAR %s: unexpected reply %q", name, resp)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package nut
+
+import "testing"
+
+func TestClient_SetVar(t *testing.T) {
+	port := fakeUPSD(t, map[string]string{
+		`SET VAR cyberpower battery.charge.low "25"`:  "OK",
+		`SET VAR cyberpower ups.id "rack \"A\" \\ 2"`: "OK",
+	})
+	c, err := NewClient([]string{"127.0.0.1"}, port, "", "", "cyberpower")
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer c.Close() //nolint:errcheck
+
+	if err := c.SetVar("battery.charge.low", "25"); err != nil {
+		t.Errorf("SetVar: %v", err)
+	}
+	if err := c.SetVar("ups.id", `rack "A" \ 2`); err != nil {
+		t.Errorf("SetVar with quotes: %v", err)
+	}
+	if err := c.SetVar("battery.runtime.low", "120"); err == nil {
+		t.Error("expected error for ERR reply")
+	}
+	if err := c.SetVar("ups.id", "a\nFSD cyberpower"); err == nil {
+		t.Error("expected a value with a line break to be rejected")
+	}
+	if err := c.SetVar("battery.charge.low 1", "2"); err == nil {
+		t.Error("expected an invalid variable name to be rejected")
+	}
+}
@@ -0,0 +1,24 @@
+package nut
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrDataStale is returned by Poll when upsd is connected to the driver but
+// refuses to serve its variables because the driver has stopped updating
+// them (ERR DATA-STALE), typically after losing contact with the hardware.
+var ErrDataStale = errors.New("driver data is stale")
+
+// isDataStale reports whether err is upsd's ERR DATA-STALE reply.  go.nut
+// replaces ERR codes with prose, so this matches its wording.
+func isDataStale(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "marked the data as stale")
+}
+
+// DriverStale reports whether vars come from a driver that says it has lost
+// the UPS and is reconnecting (driver.state "reconnect", NUT 2.8+), in which
+// case upsd may still be serving the last values it received.
+func DriverStale(vars map[string]string) bool {
+	return vars["driver.state"] == "reconnect"
+}
@@ -0,0 +1,32 @@
+package nut
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestClient_Poll_DataStale(t *testing.T) {
+	port := fakeUPSD(t, map[string]string{
+		"GET VAR cyberpower ups.status": "ERR DATA-STALE",
+	})
+	c, err := NewClient([]string{"127.0.0.1"}, port, "", "", "cyberpower")
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer c.Close() //nolint:errcheck
+
+	if _, err := c.Poll(); !errors.Is(err, ErrDataStale) {
+		t.Fatalf("Poll error = %v, want ErrDataStale", err)
+	}
+	if c.stale {
+		t.Error("a stale driver should not mark the upsd connection for reconnect")
+	}
+}
+
+func TestDriverStale(t *testing.T) {
+	for state, want := range map[string]bool{"reconnect": true, "quiet": false, "updateinfo": false, "": false} {
+		if got := DriverStale(map[string]string{"driver.state": state}); got != want {
+			t.Errorf("DriverStale(%q) = %v, want %v", state, got, want)
+		}
+	}
+}
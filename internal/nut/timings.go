@@ -0,0 +1,34 @@
+package nut
+
+import "time"
+
+// Timings breaks a poll down by upsd request, so that a slow one can be
+// blamed on the network (Connect), upsd (Auth, ListUPS) or the driver
+// (Status, GetVars).  Connect and Auth are zero unless the poll had to
+// connect first; a request the poll never got to is zero too.
+type Timings struct {
+	Connect time.Duration // resolving and dialling upsd, every server tried
+	Auth    time.Duration // USERNAME and PASSWORD
+	Status  time.Duration // GET VAR ups.status, the stale-data check
+	ListUPS time.Duration // LIST UPS
+	GetVars time.Duration // the variable batch: LIST VAR and go.nut's GET TYPE and GET DESC for each
+	Total   time.Duration // the whole poll, waiting for a pooled connection included
+}
+
+// Timer reports the Timings of the last poll.
+type Timer interface {
+	LastTimings() Timings
+}
+
+// LastTimings returns the Timings of the last Poll.
+func (c *Client) LastTimings() Timings {
+	c.tmu.Lock()
+	defer c.tmu.Unlock()
+	return c.timings
+}
+
+func (c *Client) setTimings(t Timings) {
+	c.tmu.Lock()
+	defer c.tmu.Unlock()
+	c.timings = t
+}
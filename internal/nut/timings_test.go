@@ -0,0 +1,57 @@
+package nut
+
+import "testing"
+
+func TestClient_LastTimings(t *testing.T) {
+	port := fakeUPSD(t, map[string]string{
+		"GET VAR cyberpower ups.status": `VAR cyberpower ups.status "OL"`,
+		"LIST UPS":                      "BEGIN LIST UPS\nUPS cyberpower \"CP1500\"\nEND LIST UPS",
+		"LIST VAR cyberpower":           "BEGIN LIST VAR cyberpower\nVAR cyberpower ups.status \"OL\"\nEND LIST VAR cyberpower",
+	})
+	c, err := NewClient([]string{"127.0.0.1"}, port, "", "", "cyberpower")
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer c.Close() //nolint:errcheck
+
+	if _, err := c.Poll(); err != nil {
+		t.Fatalf("Poll: %v", err)
+	}
+	tm := c.LastTimings()
+	if tm.Connect != 0 || tm.Auth != 0 {
+		t.Errorf("Connect %s, Auth %s on an open connection, want 0", tm.Connect, tm.Auth)
+	}
+	if tm.Status <= 0 || tm.ListUPS <= 0 || tm.GetVars <= 0 {
+		t.Errorf("Timings = %+v, want every request timed", tm)
+	}
+	if tm.Total < tm.Status+tm.ListUPS+tm.GetVars {
+		t.Errorf("Total %s is less than its parts: %+v", tm.Total, tm)
+	}
+
+	// A poll that reconnects counts the connection.
+	c.stale = true
+	if _, err := c.Poll(); err != nil {
+		t.Fatalf("Poll: %v", err)
+	}
+	if tm := c.LastTimings(); tm.Connect <= 0 {
+		t.Errorf("Connect = %s after reconnecting, want it timed", tm.Connect)
+	}
+}
+
+func TestClient_LastTimings_Pool(t *testing.T) {
+	port := fakeUPSD(t, map[string]string{
+		"GET VAR rack ups.status": "ERR DATA-STALE",
+	})
+	p := NewPool([]string{"127.0.0.1"}, port, "", "", 1)
+	defer p.Close() //nolint:errcheck
+	c, err := p.Client("rack")
+	if err != nil {
+		t.Fatalf("Client: %v", err)
+	}
+	if _, err := c.Poll(); err == nil {
+		t.Fatal("expected a stale-data error")
+	}
+	if tm := c.LastTimings(); tm.Status <= 0 || tm.ListUPS != 0 || tm.Total < tm.Status {
+		t.Errorf("Timings = %+v, want the failed poll timed up to the stale check", tm)
+	}
+}
@@ -0,0 +1,181 @@
+// Package outages keeps the long-term record of mains outages and derives
+// reliability statistics from it: outages per month, mean time between
+// outages, the longest outage and a histogram of durations, so the local
+// grid — and whether the UPS runtime is adequate for it — can be judged
+// from data.  Reading and writing the record is left to the caller; the
+// statistics are pure arithmetic over it.
+package outages
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Outage is one period on battery, from the first on-battery poll to the
+// poll at which mains counted as restored.
+type Outage struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Duration returns how long o lasted.
+func (o Outage) Duration() time.Duration {
+	return o.End.Sub(o.Start)
+}
+
+// History is the persisted record of outages, oldest first.
+type History struct {
+	Outages []Outage `json:"outages"`
+}
+
+// Parse decodes a History written by Marshal.  Empty data is an empty
+// History, so a file that doesn't exist yet can be treated as one.
+func Parse(data []byte) (History, error) {
+	var h History
+	if len(data) == 0 {
+		return h, nil
+	}
+	err := json.Unmarshal(data, &h)
+	return h, err
+}
+
+// Marshal encodes h for storage.
+func (h History) Marshal() ([]byte, error) {
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// Add records o, keeping the history in start order.
+func (h *History) Add(o Outage) {
+	h.Outages = append(h.Outages, o)
+	sort.SliceStable(h.Outages, func(i, j int) bool { return h.Outages[i].Start.Before(h.Outages[j].Start) })
+}
+
+// Between returns the outages of h that started at or after from and
+// before to, oldest first.  A zero from or to leaves that side open.
+func (h History) Between(from, to time.Time) []Outage {
+	var in []Outage
+	for _, o := range h.Outages {
+		if (from.IsZero() || !o.Start.Before(from)) && (to.IsZero() || o.Start.Before(to)) {
+			in = append(in, o)
+		}
+	}
+	return in
+}
+
+// Buckets are the upper bounds of the duration histogram; longer outages
+// fall in a final, unbounded bucket.
+var Buckets = []time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute, time.Hour, 4 * time.Hour}
+
+// Bucket is one bar of the duration histogram: outages lasting at most
+// LESecs seconds and longer than the previous bucket's bound.  LESecs is
+// nil for the last, unbounded bucket.
+type Bucket struct {
+	LESecs *float64 `json:"le_secs"`
+	Count  int      `json:"count"`
+}
+
+// Stats is the JSON payload of the outage statistics topic.  The duration
+// figures are omitted until there is an outage to base them on, and
+// MTBOSecs until there are two.
+type Stats struct {
+	Since   string `json:"since,omitempty"`
+	Outages int    `json:"outages"`
+
+	// PerMonth counts outages by the local month they started in, "2006-01".
+	PerMonth map[string]int `json:"per_month"`
+
+	// MTBOSecs is the mean time between outages: the mean gap from the end
+	// of one outage to the start of the next.
+	MTBOSecs     *float64 `json:"mtbo_secs,omitempty"`
+	LongestSecs  *float64 `json:"longest_secs,omitempty"`
+	LongestStart string   `json:"longest_start,omitempty"`
+	MeanSecs     *float64 `json:"mean_secs,omitempty"`
+	TotalSecs    float64  `json:"total_secs"`
+
+	Histogram []Bucket `json:"histogram"`
+}
+
+// Stats computes the statistics of h, with months in loc.
+func (h History) Stats(loc *time.Location) Stats {
+	s := Stats{Outages: len(h.Outages), PerMonth: map[string]int{}}
+	for _, le := range Buckets {
+		s.Histogram = append(s.Histogram, Bucket{LESecs: ptr(le.Seconds())})
+	}
+	s.Histogram = append(s.Histogram, Bucket{})
+	if len(h.Outages) == 0 {
+		return s
+	}
+	s.Since = h.Outages[0].Start.UTC().Format(time.RFC3339)
+
+	var total, longest time.Duration
+	var longestStart time.Time
+	for _, o := range h.Outages {
+		d := o.Duration()
+		total += d
+		if d > longest {
+			longest, longestStart = d, o.Start
+		}
+		s.PerMonth[o.Start.In(loc).Format("2006-01")]++
+		i := sort.Search(len(Buckets), func(i int) bool { return d <= Buckets[i] })
+		s.Histogram[i].Count++
+	}
+	s.TotalSecs = math.Round(total.Seconds())
+	s.LongestSecs, s.LongestStart = ptr(longest.Seconds()), longestStart.UTC().Format(time.RFC3339)
+	s.MeanSecs = ptr(total.Seconds() / float64(len(h.Outages)))
+
+	if n := len(h.Outages); n > 1 {
+		var between time.Duration
+		for i := 1; i < n; i++ {
+			between += h.Outages[i].Start.Sub(h.Outages[i-1].End)
+		}
+		s.MTBOSecs = ptr(between.Seconds() / float64(n-1))
+	}
+	return s
+}
+
+// Counters are the running outage figures published as computed/ topics:
+// how many outages h holds, how long the last one lasted and how long ago
+// it ended.  The durations are nil until there is an outage.
+type Counters struct {
+	Count            int
+	LastDurationSecs *float64
+	SinceLastSecs    *float64
+}
+
+// Counters returns the counters of h as of now.
+func (h History) Counters(now time.Time) Counters {
+	c := Counters{Count: len(h.Outages)}
+	if len(h.Outages) == 0 {
+		return c
+	}
+	last := h.Outages[len(h.Outages)-1]
+	c.LastDurationSecs = ptr(last.Duration().Seconds())
+	c.SinceLastSecs = ptr(max(now.Sub(last.End), 0).Seconds())
+	return c
+}
+
+// AsTopicMap returns each counter as a computed/ topic-name → payload
+// pair, leaving out those that are nil.
+func (c Counters) AsTopicMap() map[string]string {
+	topics := map[string]string{"outage_count": strconv.Itoa(c.Count)}
+	if c.LastDurationSecs != nil {
+		topics["last_outage_duration_secs"] = strconv.FormatFloat(*c.LastDurationSecs, 'f', -1, 64)
+	}
+	if c.SinceLastSecs != nil {
+		topics["time_since_last_outage_secs"] = strconv.FormatFloat(*c.SinceLastSecs, 'f', -1, 64)
+	}
+	return topics
+}
+
+// ptr rounds v to whole seconds and returns a pointer to it.
+func ptr(v float64) *float64 {
+	v = math.Round(v)
+	return &v
+}
@@ -0,0 +1,132 @@
+package outages
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+func outage(startMin, mins int) Outage {
+	start := t0.Add(time.Duration(startMin) * time.Minute)
+	return Outage{Start: start, End: start.Add(time.Duration(mins) * time.Minute)}
+}
+
+func TestHistory_Stats(t *testing.T) {
+	var h History
+	h.Add(outage(60*24*40, 300)) // April, 5h
+	h.Add(outage(0, 1))          // March, 1m
+	h.Add(outage(60*24*10, 10))  // March, 10m
+
+	got := h.Stats(time.UTC)
+	if got.Outages != 3 || got.Since != "2026-03-01T00:00:00Z" {
+		t.Errorf("outages %d since %s", got.Outages, got.Since)
+	}
+	if got.PerMonth["2026-03"] != 2 || got.PerMonth["2026-04"] != 1 {
+		t.Errorf("per month = %v", got.PerMonth)
+	}
+	if *got.LongestSecs != 18000 || got.LongestStart != "2026-04-10T00:00:00Z" {
+		t.Errorf("longest %vs at %s", *got.LongestSecs, got.LongestStart)
+	}
+	if got.TotalSecs != 18660 || *got.MeanSecs != 6220 {
+		t.Errorf("total %vs, mean %vs", got.TotalSecs, *got.MeanSecs)
+	}
+	// Gaps: 10d - 1m, then 30d - 10m.
+	if want := float64((40*24*60 - 11) * 60 / 2); *got.MTBOSecs != want {
+		t.Errorf("MTBOSecs = %v, want %v", *got.MTBOSecs, want)
+	}
+	var counts []int
+	for _, b := range got.Histogram {
+		counts = append(counts, b.Count)
+	}
+	if want := []int{1, 0, 1, 0, 0, 1}; !equal(counts, want) {
+		t.Errorf("histogram = %v, want %v", counts, want)
+	}
+}
+
+func TestHistory_Counters(t *testing.T) {
+	got := History{}.Counters(t0).AsTopicMap()
+	if len(got) != 1 || got["outage_count"] != "0" {
+		t.Errorf("empty counters = %v, want only outage_count 0", got)
+	}
+
+	var h History
+	h.Add(outage(60, 10)) // 01:00–01:10
+	h.Add(outage(0, 1))   // 00:00–00:01
+	got = h.Counters(t0.Add(2 * time.Hour)).AsTopicMap()
+	want := map[string]string{
+		"outage_count":                "2",
+		"last_outage_duration_secs":   "600",
+		"time_since_last_outage_secs": "3000",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %q, want %q", k, got[k], v)
+		}
+	}
+	if c := h.Counters(t0); *c.SinceLastSecs != 0 {
+		t.Errorf("SinceLastSecs before the last outage ended = %v, want 0", *c.SinceLastSecs)
+	}
+}
+
+func TestHistory_Empty(t *testing.T) {
+	b, err := json.Marshal(History{}.Stats(time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"outages":0,"per_month":{},"total_secs":0,"histogram":[` +
+		`{"le_secs":60,"count":0},{"le_secs":300,"count":0},{"le_secs":900,"count":0},` +
+		`{"le_secs":3600,"count":0},{"le_secs":14400,"count":0},{"le_secs":null,"count":0}]}`
+	if string(b) != want {
+		t.Errorf("stats = %s\nwant    %s", b, want)
+	}
+}
+
+func TestHistory_RoundTrip(t *testing.T) {
+	h, err := Parse(nil)
+	if err != nil || len(h.Outages) != 0 {
+		t.Fatalf("Parse(nil) = %v, %v", h, err)
+	}
+	h.Add(outage(0, 5))
+	data, err := h.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Outages) != 1 || !got.Outages[0].End.Equal(h.Outages[0].End) {
+		t.Errorf("round trip = %+v", got)
+	}
+	if _, err := Parse([]byte("{")); err == nil {
+		t.Error("Parse of bad JSON should fail")
+	}
+}
+
+func TestHistory_Between(t *testing.T) {
+	var h History
+	h.Add(outage(0, 1))
+	h.Add(outage(60, 1))
+	h.Add(outage(120, 1))
+	if got := h.Between(time.Time{}, time.Time{}); len(got) != 3 {
+		t.Errorf("open range = %d outages, want 3", len(got))
+	}
+	got := h.Between(t0.Add(time.Hour), t0.Add(2*time.Hour))
+	if len(got) != 1 || !got[0].Start.Equal(t0.Add(time.Hour)) {
+		t.Errorf("Between = %+v, want the outage starting at 01:00", got)
+	}
+}
+
+func equal(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,120 @@
+// Package plausibility filters physically impossible NUT readings — firmware
+// glitches such as the CyberPower's transient input.voltage=0 while still on
+// mains — before they reach metrics and MQTT.  Like internal/metrics it is
+// pure: no I/O and no state beyond the configured rules.
+package plausibility
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Mode selects what happens to a reading that breaks its rule.
+type Mode string
+
+const (
+	// ModeDrop removes the reading from the poll, as if the driver had not
+	// reported it.
+	ModeDrop Mode = "drop"
+	// ModeClamp pulls an out-of-range reading to the nearest bound.  Readings
+	// that cannot be clamped (unparseable, or zero while on mains) are dropped.
+	ModeClamp Mode = "clamp"
+)
+
+// Rule bounds a single NUT variable.  A nil Min or Max leaves that side open.
+type Rule struct {
+	Min *float64
+	Max *float64
+
+	// ZeroOnlyOnBattery rejects a reading of exactly 0 unless ups.status
+	// contains OB — mains voltage cannot be zero while the UPS is online.
+	ZeroOnlyOnBattery bool
+}
+
+// Glitch describes one rejected or adjusted reading.
+type Glitch struct {
+	Variable string
+	Value    string // the raw value as reported
+	Reason   string
+}
+
+// Filter applies a set of Rules keyed by NUT variable name.
+type Filter struct {
+	Mode  Mode
+	Rules map[string]Rule
+}
+
+// DefaultRules returns the built-in bounds: battery.charge within 0–100 %,
+// non-negative runtimes, loads and voltages, and input.voltage=0 only on
+// battery.
+func DefaultRules() map[string]Rule {
+	zero, hundred := 0.0, 100.0
+	return map[string]Rule{
+		"battery.charge":  {Min: &zero, Max: &hundred},
+		"battery.runtime": {Min: &zero},
+		"ups.load":        {Min: &zero},
+		"input.voltage":   {Min: &zero, ZeroOnlyOnBattery: true},
+		"output.voltage":  {Min: &zero},
+		"battery.voltage": {Min: &zero},
+	}
+}
+
+// Apply returns a copy of vars with implausible readings dropped or clamped
+// according to f.Mode, plus one Glitch per affected variable (sorted by
+// name).  vars itself is never modified.
+func (f Filter) Apply(vars map[string]string) (map[string]string, []Glitch) {
+	onBattery := false
+	for _, t := range strings.Fields(vars["ups.status"]) {
+		if t == "OB" {
+			onBattery = true
+		}
+	}
+
+	out := make(map[string]string, len(vars))
+	var glitches []Glitch
+	for name, value := range vars {
+		rule, ok := f.Rules[name]
+		if !ok {
+			out[name] = value
+			continue
+		}
+		fixed, keep, reason := rule.check(value, onBattery, f.Mode)
+		if reason != "" {
+			glitches = append(glitches, Glitch{Variable: name, Value: value, Reason: reason})
+		}
+		if keep {
+			out[name] = fixed
+		}
+	}
+	sort.Slice(glitches, func(i, j int) bool { return glitches[i].Variable < glitches[j].Variable })
+	return out, glitches
+}
+
+// check validates value against r.  It returns the (possibly clamped) value,
+// whether to keep it, and a non-empty reason when the reading was a glitch.
+func (r Rule) check(value string, onBattery bool, mode Mode) (string, bool, string) {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return value, false, "not a number"
+	}
+	if r.ZeroOnlyOnBattery && v == 0 && !onBattery {
+		return value, false, "zero while not on battery"
+	}
+	switch {
+	case r.Min != nil && v < *r.Min:
+		return r.bound(*r.Min, "below minimum", mode)
+	case r.Max != nil && v > *r.Max:
+		return r.bound(*r.Max, "above maximum", mode)
+	}
+	return value, true, ""
+}
+
+func (r Rule) bound(limit float64, why string, mode Mode) (string, bool, string) {
+	reason := fmt.Sprintf("%s %s", why, strconv.FormatFloat(limit, 'f', -1, 64))
+	if mode == ModeClamp {
+		return strconv.FormatFloat(limit, 'f', -1, 64), true, reason
+	}
+	return "", false, reason
+}
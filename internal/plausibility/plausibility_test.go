@@ -0,0 +1,111 @@
+package plausibility
+
+import (
+	"testing"
+)
+
+func f64(v float64) *float64 { return &v }
+
+func TestApply_ZeroVoltageOnMains_Dropped(t *testing.T) {
+	// The CyberPower reports input.voltage=0 for one poll while reconnecting
+	// to mains (ups.status = "OL DISCHRG").
+	vars := map[string]string{"ups.status": "OL DISCHRG", "input.voltage": "0"}
+	f := Filter{Mode: ModeDrop, Rules: DefaultRules()}
+
+	out, glitches := f.Apply(vars)
+	if _, ok := out["input.voltage"]; ok {
+		t.Error("input.voltage=0 on mains should be dropped")
+	}
+	if len(glitches) != 1 || glitches[0].Variable != "input.voltage" || glitches[0].Value != "0" {
+		t.Errorf("glitches = %+v, want one for input.voltage", glitches)
+	}
+	if out["ups.status"] != "OL DISCHRG" {
+		t.Error("variables without a rule must pass through unchanged")
+	}
+}
+
+func TestApply_ZeroVoltageOnBattery_Kept(t *testing.T) {
+	vars := map[string]string{"ups.status": "OB DISCHRG", "input.voltage": "0"}
+	out, glitches := Filter{Mode: ModeDrop, Rules: DefaultRules()}.Apply(vars)
+	if out["input.voltage"] != "0" {
+		t.Errorf("input.voltage = %q on battery, want 0 kept", out["input.voltage"])
+	}
+	if len(glitches) != 0 {
+		t.Errorf("glitches = %+v, want none", glitches)
+	}
+}
+
+func TestApply_ZeroVoltageOnMains_ClampModeStillDrops(t *testing.T) {
+	vars := map[string]string{"ups.status": "OL", "input.voltage": "0"}
+	out, glitches := Filter{Mode: ModeClamp, Rules: DefaultRules()}.Apply(vars)
+	if _, ok := out["input.voltage"]; ok {
+		t.Error("a zero reading cannot be clamped and should be dropped")
+	}
+	if len(glitches) != 1 {
+		t.Errorf("glitches = %d, want 1", len(glitches))
+	}
+}
+
+func TestApply_OutOfRange(t *testing.T) {
+	cases := []struct {
+		name      string
+		mode      Mode
+		variable  string
+		value     string
+		wantKept  bool
+		wantValue string
+	}{
+		{"charge above max dropped", ModeDrop, "battery.charge", "255", false, ""},
+		{"charge above max clamped", ModeClamp, "battery.charge", "255", true, "100"},
+		{"negative runtime dropped", ModeDrop, "battery.runtime", "-60", false, ""},
+		{"negative runtime clamped", ModeClamp, "battery.runtime", "-60", true, "0"},
+		{"in range kept", ModeDrop, "battery.charge", "87", true, "87"},
+		{"unparseable dropped", ModeClamp, "battery.charge", "n/a", false, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			out, glitches := Filter{Mode: tc.mode, Rules: DefaultRules()}.Apply(map[string]string{tc.variable: tc.value})
+			got, kept := out[tc.variable]
+			if kept != tc.wantKept || got != tc.wantValue {
+				t.Errorf("out[%s] = %q (kept=%v), want %q (kept=%v)", tc.variable, got, kept, tc.wantValue, tc.wantKept)
+			}
+			wantGlitch := tc.value != tc.wantValue
+			if (len(glitches) == 1) != wantGlitch {
+				t.Errorf("glitches = %+v, want glitch=%v", glitches, wantGlitch)
+			}
+		})
+	}
+}
+
+func TestApply_CustomRule(t *testing.T) {
+	f := Filter{Mode: ModeClamp, Rules: map[string]Rule{"input.frequency": {Min: f64(45), Max: f64(65)}}}
+	out, glitches := f.Apply(map[string]string{"input.frequency": "0.5"})
+	if out["input.frequency"] != "45" {
+		t.Errorf("input.frequency = %q, want clamped to 45", out["input.frequency"])
+	}
+	if len(glitches) != 1 || glitches[0].Reason != "below minimum 45" {
+		t.Errorf("glitches = %+v", glitches)
+	}
+}
+
+func TestApply_DoesNotModifyInput(t *testing.T) {
+	vars := map[string]string{"battery.charge": "255"}
+	Filter{Mode: ModeClamp, Rules: DefaultRules()}.Apply(vars)
+	if vars["battery.charge"] != "255" {
+		t.Error("Apply must not modify its input map")
+	}
+}
+
+func TestApply_GlitchesSorted(t *testing.T) {
+	vars := map[string]string{"ups.status": "OL", "input.voltage": "0", "battery.charge": "-1", "ups.load": "-5"}
+	_, glitches := Filter{Mode: ModeDrop, Rules: DefaultRules()}.Apply(vars)
+	want := []string{"battery.charge", "input.voltage", "ups.load"}
+	if len(glitches) != len(want) {
+		t.Fatalf("glitches = %+v, want %d", glitches, len(want))
+	}
+	for i, w := range want {
+		if glitches[i].Variable != w {
+			t.Errorf("glitches[%d] = %s, want %s", i, glitches[i].Variable, w)
+		}
+	}
+}
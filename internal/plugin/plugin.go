@@ -0,0 +1,175 @@
+// Package plugin runs long-lived helper programs that extend the bridge at
+// runtime.  A plugin is any executable that reads requests from stdin and
+// answers on stdout, one JSON object per line:
+//
+//	→ {"method":"publish","params":{…}}
+//	← {"result":{…}}             or  {"error":"why it failed"}
+//
+// Requests are sent one at a time and each waits for its answer, so a
+// plugin needs no framing beyond newlines and no concurrency of its own.
+// Whatever it writes to stderr goes to the bridge's log.
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/schedule"
+)
+
+// ErrPlugin wraps an error the plugin reported itself, as opposed to a
+// failure to run it or talk to it.
+var ErrPlugin = errors.New("plugin error")
+
+type request struct {
+	Method string `json:"method"`
+	Params any    `json:"params,omitempty"`
+}
+
+type response struct {
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// Process is a plugin program.  It is started on the first Call and kept
+// running; if it exits, hangs past Timeout or answers with something that
+// isn't a response, it is stopped and started afresh on the next Call.
+// Process is safe for concurrent use.
+type Process struct {
+	Command string
+	Args    []string
+	Timeout time.Duration
+
+	mu    sync.Mutex
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	lines chan []byte // stdout, one line per element; closed at EOF
+}
+
+// Call sends method with params and decodes the plugin's result into
+// result, which may be nil when the result doesn't matter.
+func (p *Process) Call(method string, params, result any) error {
+	line, err := json.Marshal(request{Method: method, Params: params})
+	if err != nil {
+		return fmt.Errorf("marshalling %s request: %w", method, err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cmd == nil {
+		if err := p.start(); err != nil {
+			return err
+		}
+	}
+	if _, err := p.stdin.Write(append(line, '\n')); err != nil {
+		p.stop()
+		return fmt.Errorf("writing to %s: %w", p.Command, err)
+	}
+
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = schedule.CallTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var reply []byte
+	select {
+	case l, ok := <-p.lines:
+		if !ok {
+			p.stop()
+			return fmt.Errorf("%s exited", p.Command)
+		}
+		reply = l
+	case <-timer.C:
+		p.stop()
+		return fmt.Errorf("%s: no reply to %s within %s", p.Command, method, timeout)
+	}
+
+	var resp response
+	if err := json.Unmarshal(reply, &resp); err != nil {
+		p.stop()
+		return fmt.Errorf("%s: invalid reply to %s: %w", p.Command, method, err)
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("%w: %s: %s", ErrPlugin, p.Command, resp.Error)
+	}
+	if result != nil && len(resp.Result) > 0 {
+		if err := json.Unmarshal(resp.Result, result); err != nil {
+			return fmt.Errorf("%s: decoding %s result: %w", p.Command, method, err)
+		}
+	}
+	return nil
+}
+
+// Close stops the plugin, if it is running, by closing its stdin and then
+// killing it if it hasn't exited within Timeout.
+func (p *Process) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cmd == nil {
+		return nil
+	}
+	p.stdin.Close() //nolint:errcheck
+	done := make(chan struct{})
+	go func() {
+		for range p.lines {
+		}
+		close(done)
+	}()
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = schedule.CallTimeout
+	}
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+	p.stop()
+	return nil
+}
+
+func (p *Process) start() error {
+	cmd := exec.Command(p.Command, p.Args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("starting %s: %w", p.Command, err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("starting %s: %w", p.Command, err)
+	}
+	cmd.Stderr = os.Stderr
+	cmd.WaitDelay = time.Second
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting %s: %w", p.Command, err)
+	}
+	lines := make(chan []byte)
+	go func() {
+		defer close(lines)
+		sc := bufio.NewScanner(stdout)
+		sc.Buffer(nil, 1<<20)
+		for sc.Scan() {
+			lines <- append([]byte(nil), sc.Bytes()...)
+		}
+	}()
+	p.cmd, p.stdin, p.lines = cmd, stdin, lines
+	return nil
+}
+
+// stop kills the plugin and reaps it; the next Call starts a new one.
+func (p *Process) stop() {
+	p.cmd.Process.Kill() //nolint:errcheck
+	go func(lines chan []byte) {
+		for range lines {
+		}
+	}(p.lines)
+	p.cmd.Wait() //nolint:errcheck
+	p.cmd, p.stdin, p.lines = nil, nil, nil
+}
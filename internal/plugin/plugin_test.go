@@ -0,0 +1,131 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHelperProcess is the plugin: the test binary re-runs itself with
+// PLUGIN_HELPER set.  It answers "echo" with its params and its pid,
+// "fail" with an error, "garbage" with a non-JSON line, "hang" not at all
+// and "exit" by exiting.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("PLUGIN_HELPER") == "" {
+		return
+	}
+	sc := bufio.NewScanner(os.Stdin)
+	for sc.Scan() {
+		var req struct {
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+		json.Unmarshal(sc.Bytes(), &req) //nolint:errcheck
+		switch req.Method {
+		case "echo":
+			fmt.Printf(`{"result":{"params":%s,"pid":%d}}`+"\n", req.Params, os.Getpid())
+		case "fail":
+			fmt.Println(`{"error":"disk full"}`)
+		case "garbage":
+			fmt.Println("hello")
+		case "hang":
+			time.Sleep(time.Minute)
+		case "exit":
+			os.Exit(3)
+		}
+	}
+	os.Exit(0)
+}
+
+func helper(t *testing.T) *Process {
+	t.Setenv("PLUGIN_HELPER", "1")
+	p := &Process{Command: os.Args[0], Args: []string{"-test.run=TestHelperProcess"}, Timeout: 10 * time.Second}
+	t.Cleanup(func() { p.Close() }) //nolint:errcheck
+	return p
+}
+
+type echoResult struct {
+	Params map[string]string `json:"params"`
+	PID    int               `json:"pid"`
+}
+
+func echo(t *testing.T, p *Process) echoResult {
+	t.Helper()
+	var r echoResult
+	if err := p.Call("echo", map[string]string{"a": "b"}, &r); err != nil {
+		t.Fatalf("echo: %v", err)
+	}
+	if r.Params["a"] != "b" {
+		t.Errorf("echo params = %v", r.Params)
+	}
+	return r
+}
+
+func TestCall_KeepsProcess(t *testing.T) {
+	p := helper(t)
+	first, second := echo(t, p), echo(t, p)
+	if first.PID != second.PID {
+		t.Errorf("pid %d then %d, want the same process", first.PID, second.PID)
+	}
+}
+
+func TestCall_PluginError(t *testing.T) {
+	p := helper(t)
+	before := echo(t, p)
+	err := p.Call("fail", nil, nil)
+	if !errors.Is(err, ErrPlugin) || !strings.Contains(err.Error(), "disk full") {
+		t.Errorf("err = %v, want ErrPlugin with the message", err)
+	}
+	if after := echo(t, p); after.PID != before.PID {
+		t.Error("a reported error should not restart the plugin")
+	}
+}
+
+func TestCall_RestartsAfterFailure(t *testing.T) {
+	for method, want := range map[string]string{
+		"exit":    "exited",
+		"garbage": "invalid reply",
+	} {
+		p := helper(t)
+		before := echo(t, p)
+		if err := p.Call(method, nil, nil); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: err = %v, want %q", method, err, want)
+		}
+		if after := echo(t, p); after.PID == before.PID {
+			t.Errorf("%s: plugin not restarted", method)
+		}
+	}
+}
+
+func TestCall_Timeout(t *testing.T) {
+	p := helper(t)
+	p.Timeout = 50 * time.Millisecond
+	if err := p.Call("hang", nil, nil); err == nil || !strings.Contains(err.Error(), "no reply") {
+		t.Errorf("err = %v, want a timeout", err)
+	}
+	p.Timeout = 10 * time.Second
+	echo(t, p)
+}
+
+func TestCall_MissingProgram(t *testing.T) {
+	p := &Process{Command: "/nonexistent/plugin"}
+	if err := p.Call("echo", nil, nil); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestClose(t *testing.T) {
+	p := helper(t)
+	echo(t, p)
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+// Package prom renders UPS readings in the Prometheus text exposition format
+// and pushes them to a Pushgateway.
+package prom
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sweeney/ups-mqtt/internal/metrics"
+)
+
+// Format renders the numeric NUT variables and the computed metrics as
+// Prometheus gauges labelled with ups=label plus labels, such as site or
+// rack, in name order.  Raw variables become
+// nut_<name> (dots → underscores) and are skipped when not numeric; computed
+// metrics become ups_mqtt_<name>, with booleans as 0/1.  Output is sorted by
+// metric name so it is stable between calls.  Metrics that couldn't be
+// computed are left out unless metrics.unavailable fills them with 0.
+func Format(label string, labels map[string]string, vars map[string]string, m metrics.Metrics) string {
+	gauges := make(map[string]float64)
+	for name, v := range vars {
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			continue
+		}
+		gauges["nut_"+sanitize(name)] = f
+	}
+	for name, v := range map[string]float64{
+		"load_watts":                  m.LoadWatts,
+		"battery_runtime_mins":        m.BatteryRuntimeMins,
+		"battery_runtime_hours":       m.BatteryRuntimeHours,
+		"on_battery":                  boolGauge(m.OnBattery),
+		"low_battery":                 boolGauge(m.LowBattery),
+		"input_voltage_deviation_pct": m.InputVoltageDeviationPct,
+	} {
+		if m.Reported(name) {
+			gauges["ups_mqtt_"+name] = v
+		}
+	}
+
+	names := make([]string, 0, len(gauges))
+	for name := range gauges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	lbl := "{" + labelSet(label, labels) + "}"
+	for _, name := range names {
+		fmt.Fprintf(&b, "# TYPE %s gauge\n", name)
+		fmt.Fprintf(&b, "%s%s %s\n", name, lbl, strconv.FormatFloat(gauges[name], 'g', -1, 64))
+	}
+	return b.String()
+}
+
+// FormatPublished renders the bytes a poll cycle published to the broker,
+// by topic class, as the gauge ups_mqtt_cycle_published_bytes with a class
+// label besides Format's, and the messages as
+// ups_mqtt_cycle_published_messages.
+func FormatPublished(label string, labels map[string]string, byClass map[string]int64, messages int64) string {
+	var b strings.Builder
+	lbl := labelSet(label, labels)
+	b.WriteString("# TYPE ups_mqtt_cycle_published_bytes gauge\n")
+	classes := make([]string, 0, len(byClass))
+	for class := range byClass {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	for _, class := range classes {
+		fmt.Fprintf(&b, "ups_mqtt_cycle_published_bytes{%s,class=%q} %d\n", lbl, class, byClass[class])
+	}
+	b.WriteString("# TYPE ups_mqtt_cycle_published_messages gauge\n")
+	fmt.Fprintf(&b, "ups_mqtt_cycle_published_messages{%s} %d\n", lbl, messages)
+	return b.String()
+}
+
+// labelSet returns the labels every series carries, ups=label and then
+// labels in name order, without the braces.
+func labelSet(label string, labels map[string]string) string {
+	lbl := fmt.Sprintf("ups=%q", label)
+	for _, k := range sortedKeys(labels) {
+		lbl += fmt.Sprintf(",%s=%q", k, labels[k])
+	}
+	return lbl
+}
+
+// TextfileName returns the file name under which node_exporter's textfile
+// collector should find the readings of the UPS with the given label.  The
+// label is part of the name so several bridges can share one directory.
+func TextfileName(label string) string {
+	return "ups_mqtt_" + sanitize(label) + ".prom"
+}
+
+// sanitize maps a NUT variable name onto the Prometheus metric name
+// alphabet [a-zA-Z0-9_].
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,132 @@
+package prom
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sweeney/ups-mqtt/internal/metrics"
+)
+
+func TestFormat(t *testing.T) {
+	vars := map[string]string{
+		"battery.charge": "100",
+		"ups.status":     "OL",
+		"input.voltage":  "242.0",
+	}
+	m := metrics.Metrics{LoadWatts: 72, OnBattery: true}
+	got := Format("office-ups", nil, vars, m)
+
+	for _, want := range []string{
+		"# TYPE nut_battery_charge gauge\nnut_battery_charge{ups=\"office-ups\"} 100\n",
+		"nut_input_voltage{ups=\"office-ups\"} 242\n",
+		"ups_mqtt_load_watts{ups=\"office-ups\"} 72\n",
+		"ups_mqtt_on_battery{ups=\"office-ups\"} 1\n",
+		"ups_mqtt_low_battery{ups=\"office-ups\"} 0\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output missing %q\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "ups_status") {
+		t.Errorf("non-numeric ups.status should be skipped\n%s", got)
+	}
+	if strings.Index(got, "nut_battery_charge") > strings.Index(got, "nut_input_voltage") {
+		t.Error("output should be sorted by metric name")
+	}
+}
+
+func TestFormat_Labels(t *testing.T) {
+	got := Format("office-ups", map[string]string{"site": "lon1", "rack": "4"}, nil, metrics.Metrics{LoadWatts: 72})
+	if want := `ups_mqtt_load_watts{ups="office-ups",rack="4",site="lon1"} 72` + "\n"; !strings.Contains(got, want) {
+		t.Errorf("output missing %q\n%s", want, got)
+	}
+}
+
+func TestFormat_Unavailable(t *testing.T) {
+	vars := map[string]string{"ups.status": "OL"}
+	if got := Format("ups", nil, vars, metrics.Compute(vars)); !strings.Contains(got, "ups_mqtt_load_watts{") {
+		t.Errorf("zero strategy: load_watts missing:\n%s", got)
+	}
+	got := Format("ups", nil, vars, metrics.ComputeWith(vars, metrics.Options{Unavailable: metrics.UnavailableNull}))
+	if strings.Contains(got, "ups_mqtt_load_watts") || !strings.Contains(got, "ups_mqtt_on_battery{") {
+		t.Errorf("null strategy: want load_watts left out:\n%s", got)
+	}
+}
+
+func TestFormatPublished(t *testing.T) {
+	got := FormatPublished("office-ups", map[string]string{"site": "lon1"}, map[string]int64{"state": 812, "other": 0}, 14)
+	want := "# TYPE ups_mqtt_cycle_published_bytes gauge\n" +
+		`ups_mqtt_cycle_published_bytes{ups="office-ups",site="lon1",class="other"} 0` + "\n" +
+		`ups_mqtt_cycle_published_bytes{ups="office-ups",site="lon1",class="state"} 812` + "\n" +
+		"# TYPE ups_mqtt_cycle_published_messages gauge\n" +
+		`ups_mqtt_cycle_published_messages{ups="office-ups",site="lon1"} 14` + "\n"
+	if got != want {
+		t.Errorf("output =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	if got := sanitize("ups.realpower-nominal"); got != "ups_realpower_nominal" {
+		t.Errorf("sanitize = %q", got)
+	}
+}
+
+func TestTextfileName(t *testing.T) {
+	if got := TextfileName("office-ups"); got != "ups_mqtt_office_ups.prom" {
+		t.Errorf("TextfileName = %q", got)
+	}
+}
+
+func TestPush(t *testing.T) {
+	var gotMethod, gotPath, gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod, gotPath = r.Method, r.URL.EscapedPath()
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+	}))
+	defer srv.Close()
+
+	if err := Push(context.Background(), srv.Client(), srv.URL+"/", "ups-mqtt", "office ups", "x 1\n"); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+	if gotMethod != http.MethodPut {
+		t.Errorf("method = %s, want PUT", gotMethod)
+	}
+	if gotPath != "/metrics/job/ups-mqtt/instance/office%20ups" {
+		t.Errorf("path = %s", gotPath)
+	}
+	if gotBody != "x 1\n" {
+		t.Errorf("body = %q", gotBody)
+	}
+}
+
+func TestPush_ErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad metric", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	err := Push(context.Background(), srv.Client(), srv.URL, "ups-mqtt", "ups", "x 1\n")
+	if err == nil || !strings.Contains(err.Error(), "bad metric") {
+		t.Fatalf("err = %v, want the gateway's message", err)
+	}
+}
+
+func TestPush_Unreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+	if err := Push(context.Background(), http.DefaultClient, url, "j", "i", ""); err == nil {
+		t.Fatal("expected error for unreachable gateway")
+	}
+}
+
+func TestPush_BadURL(t *testing.T) {
+	if err := Push(context.Background(), http.DefaultClient, "://bad", "j", "i", ""); err == nil {
+		t.Fatal("expected error for malformed URL")
+	}
+}
@@ -0,0 +1,43 @@
+package prom
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// pushTimeout bounds a single Pushgateway request.
+const pushTimeout = 10 * time.Second
+
+// Push replaces the metrics group job/instance on the Pushgateway at
+// gatewayURL with body (text exposition format), using HTTP PUT so stale
+// series from earlier runs are dropped.
+func Push(ctx context.Context, client *http.Client, gatewayURL, job, instance, body string) error {
+	endpoint := fmt.Sprintf("%s/metrics/job/%s/instance/%s",
+		strings.TrimSuffix(gatewayURL, "/"), url.PathEscape(job), url.PathEscape(instance))
+
+	ctx, cancel := context.WithTimeout(ctx, pushTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint, strings.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("building pushgateway request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("pushing to %s: %w", endpoint, err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("pushgateway returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
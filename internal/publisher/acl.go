@@ -0,0 +1,119 @@
+package publisher
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ACLCheck is the outcome of probing one broker permission the bridge needs.
+type ACLCheck struct {
+	Topic  string `json:"topic"`
+	Access string `json:"access"` // "publish" or "subscribe"
+	OK     bool   `json:"ok"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ACLReport collects the startup ACL checks, published as JSON to the ACL
+// report topic.
+type ACLReport struct {
+	OK        bool       `json:"ok"`
+	Checks    []ACLCheck `json:"checks"`
+	Timestamp string     `json:"timestamp"`
+}
+
+// ACLReportTopic returns the topic carrying the startup ACL report.
+func ACLReportTopic(prefix, upsName string) string {
+	return SelfTestTopic(prefix, upsName) + "/acl"
+}
+
+// ACLProbeTopic returns the topic probed to verify publishing under root.
+func ACLProbeTopic(root string) string {
+	return root + "/aclcheck"
+}
+
+// CheckACL verifies that this client may publish (retained) under each of
+// roots and subscribe to each of subscribeTopics.  Publishing is checked by
+// looping a retained probe back through ACLProbeTopic(root), waiting up to
+// timeout for it, then clearing it again, so the check leaves nothing
+// behind.  Duplicate roots and topics are checked once.
+func CheckACL(ps PubSub, roots, subscribeTopics []string, timeout time.Duration) ACLReport {
+	rep := ACLReport{OK: true, Timestamp: time.Now().UTC().Format(time.RFC3339)}
+	seen := make(map[string]bool)
+	for _, root := range roots {
+		if seen["pub:"+root] {
+			continue
+		}
+		seen["pub:"+root] = true
+		rep.add(checkPublish(ps, root, timeout))
+	}
+	for _, topic := range subscribeTopics {
+		if seen["sub:"+topic] {
+			continue
+		}
+		seen["sub:"+topic] = true
+		c := ACLCheck{Topic: topic, Access: "subscribe", OK: true}
+		if err := ps.Subscribe(topic, func(Message) {}); err != nil {
+			c.OK, c.Error = false, err.Error()
+		} else {
+			_ = ps.Unsubscribe(topic)
+		}
+		rep.add(c)
+	}
+	return rep
+}
+
+func (r *ACLReport) add(c ACLCheck) {
+	r.Checks = append(r.Checks, c)
+	r.OK = r.OK && c.OK
+}
+
+// checkPublish loops a retained probe back through the probe topic under
+// root.
+func checkPublish(ps PubSub, root string, timeout time.Duration) ACLCheck {
+	topic := ACLProbeTopic(root)
+	c := ACLCheck{Topic: root + "/#", Access: "publish"}
+	nonce := strconv.FormatInt(time.Now().UnixNano(), 10)
+	got := make(chan struct{}, 1)
+
+	if err := ps.Subscribe(topic, func(msg Message) {
+		if msg.Payload == nonce {
+			select {
+			case got <- struct{}{}:
+			default:
+			}
+		}
+	}); err != nil {
+		c.Error = fmt.Sprintf("cannot verify: subscribing to %s: %v", topic, err)
+		return c
+	}
+	defer ps.Unsubscribe(topic) //nolint:errcheck
+
+	if err := ps.Publish(Message{Topic: topic, Payload: nonce, Retained: true}); err != nil {
+		c.Error = fmt.Sprintf("publishing to %s: %v", topic, err)
+		return c
+	}
+	defer ps.Publish(Message{Topic: topic, Payload: "", Retained: true}) //nolint:errcheck
+
+	select {
+	case <-got:
+		c.OK = true
+	case <-time.After(timeout):
+		c.Error = fmt.Sprintf("retained probe on %s not received within %s — publish or retain appears to be denied", topic, timeout)
+	}
+	return c
+}
+
+// PublishACLReport publishes rep as JSON to the ACL report topic.
+func PublishACLReport(rep ACLReport, cfg PublishConfig, pub Publisher) error {
+	payload, err := json.Marshal(rep)
+	if err != nil {
+		return fmt.Errorf("marshalling ACL report: %w", err)
+	}
+	return pub.Publish(Message{
+		Topic:    ACLReportTopic(cfg.Prefix, cfg.UPSName),
+		Payload:  string(payload),
+		Retained: cfg.Retained,
+	})
+}
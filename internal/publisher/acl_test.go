@@ -0,0 +1,132 @@
+package publisher_test
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+)
+
+// aclBroker is a loopback broker that silently drops publishes matching
+// denyPublish and refuses subscriptions to denySubscribe.
+type aclBroker struct {
+	*publisher.FakePublisher
+	denyPublish   string
+	denySubscribe string
+}
+
+func (b aclBroker) Publish(msg publisher.Message) error {
+	if publisher.TopicMatches(b.denyPublish, msg.Topic) {
+		return nil
+	}
+	return b.FakePublisher.Publish(msg)
+}
+
+func (b aclBroker) Subscribe(topic string, handler func(publisher.Message)) error {
+	if topic == b.denySubscribe {
+		return errors.New("broker refused subscription to " + topic)
+	}
+	return b.FakePublisher.Subscribe(topic, handler)
+}
+
+func TestCheckACL_AllAllowed(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	rep := publisher.CheckACL(fp,
+		[]string{"ups/cyberpower", "homeassistant", "ups/cyberpower"},
+		[]string{"ups/cyberpower/selftest/run"}, time.Second)
+	if !rep.OK {
+		t.Fatalf("report = %+v, want OK", rep)
+	}
+	if len(rep.Checks) != 3 {
+		t.Errorf("checks = %+v, want duplicate root checked once", rep.Checks)
+	}
+	if rep.Checks[0].Topic != "ups/cyberpower/#" || rep.Checks[0].Access != "publish" {
+		t.Errorf("first check = %+v", rep.Checks[0])
+	}
+	if rep.Checks[2].Topic != "ups/cyberpower/selftest/run" || rep.Checks[2].Access != "subscribe" {
+		t.Errorf("last check = %+v", rep.Checks[2])
+	}
+
+	// The probe is retained and must be cleared again.
+	var probes []publisher.Message
+	for _, m := range fp.Messages {
+		if m.Topic == "ups/cyberpower/aclcheck" {
+			probes = append(probes, m)
+		}
+	}
+	if len(probes) != 2 || !probes[0].Retained || probes[1].Payload != "" || !probes[1].Retained {
+		t.Errorf("probe messages = %+v, want retained probe then empty retained clear", probes)
+	}
+	if len(fp.Subscriptions) != 0 {
+		t.Errorf("subscriptions left behind: %v", fp.Subscriptions)
+	}
+}
+
+func TestCheckACL_ReportsMissingPermissions(t *testing.T) {
+	b := aclBroker{
+		FakePublisher: &publisher.FakePublisher{},
+		denyPublish:   "homeassistant/#",
+		denySubscribe: "ups/cyberpower/diag/nut/command",
+	}
+	rep := publisher.CheckACL(b,
+		[]string{"ups/cyberpower", "homeassistant"},
+		[]string{"ups/cyberpower/diag/nut/command"}, 10*time.Millisecond)
+	if rep.OK {
+		t.Fatal("report should not be OK")
+	}
+	want := map[string]bool{
+		"publish ups/cyberpower/#":                  true,
+		"publish homeassistant/#":                   false,
+		"subscribe ups/cyberpower/diag/nut/command": false,
+	}
+	for _, c := range rep.Checks {
+		key := c.Access + " " + c.Topic
+		if ok, found := want[key]; !found || ok != c.OK {
+			t.Errorf("check %s: OK=%v (%s)", key, c.OK, c.Error)
+		}
+		if !c.OK && c.Error == "" {
+			t.Errorf("check %s: failure without an error message", key)
+		}
+	}
+}
+
+func TestCheckACL_ProbeSubscribeDenied(t *testing.T) {
+	b := aclBroker{FakePublisher: &publisher.FakePublisher{}, denySubscribe: "ups/cyberpower/aclcheck"}
+	rep := publisher.CheckACL(b, []string{"ups/cyberpower"}, nil, time.Second)
+	if rep.OK || !strings.Contains(rep.Checks[0].Error, "cannot verify") {
+		t.Errorf("report = %+v, want unverifiable publish", rep)
+	}
+}
+
+func TestCheckACL_PublishError(t *testing.T) {
+	fp := &publisher.FakePublisher{PublishError: errors.New("not connected")}
+	rep := publisher.CheckACL(fp, []string{"ups/cyberpower"}, nil, time.Second)
+	if rep.OK || !strings.Contains(rep.Checks[0].Error, "not connected") {
+		t.Errorf("report = %+v, want publish error", rep)
+	}
+}
+
+func TestPublishACLReport(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	rep := publisher.ACLReport{
+		Checks:    []publisher.ACLCheck{{Topic: "homeassistant/#", Access: "publish", Error: "denied"}},
+		Timestamp: "2026-03-01T12:00:00Z",
+	}
+	if err := publisher.PublishACLReport(rep, selfTestCfg, fp); err != nil {
+		t.Fatalf("PublishACLReport: %v", err)
+	}
+	msg, ok := fp.Find("ups/cyberpower/selftest/acl")
+	if !ok || !msg.Retained {
+		t.Fatalf("ACL report = %+v, want retained on selftest/acl", msg)
+	}
+	var got publisher.ACLReport
+	if err := json.Unmarshal([]byte(msg.Payload), &got); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if got.OK || len(got.Checks) != 1 || got.Checks[0].Topic != "homeassistant/#" {
+		t.Errorf("report = %+v", got)
+	}
+}
@@ -0,0 +1,35 @@
+package publisher
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/metrics"
+)
+
+// AggregateTopic returns the topic carrying the summary across every UPS
+// the bridge polls.
+func AggregateTopic(prefix string) string {
+	return prefix + "/summary"
+}
+
+// AggregateMessage is the JSON payload of the aggregate topic.
+type AggregateMessage struct {
+	Timestamp string `json:"timestamp"`
+	metrics.Aggregate
+}
+
+// PublishAggregate publishes a, as of now, to the aggregate topic.  It is
+// retained when cfg.Retained is, like the state topics it sums up.
+func PublishAggregate(a metrics.Aggregate, now time.Time, cfg PublishConfig, pub Publisher) error {
+	payload, err := json.Marshal(AggregateMessage{Timestamp: now.UTC().Format(time.RFC3339), Aggregate: a})
+	if err != nil {
+		return fmt.Errorf("marshalling aggregate: %w", err)
+	}
+	return pub.Publish(Message{
+		Topic:    AggregateTopic(cfg.Prefix),
+		Payload:  string(payload),
+		Retained: cfg.Retained,
+	})
+}
@@ -0,0 +1,29 @@
+package publisher_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/metrics"
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+)
+
+func TestPublishAggregate(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "rack", Retained: true}
+	a := metrics.Combine(2, map[string]metrics.Metrics{
+		"rack": metrics.Compute(map[string]string{"ups.status": "OB", "ups.load": "50", "ups.realpower.nominal": "900", "battery.runtime": "600"}),
+	})
+
+	if err := publisher.PublishAggregate(a, time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC), cfg, fp); err != nil {
+		t.Fatalf("PublishAggregate: %v", err)
+	}
+	msg, ok := fp.Find("ups/summary")
+	if !ok || !msg.Retained {
+		t.Fatalf("aggregate = %+v, want it published retained", msg)
+	}
+	want := `{"timestamp":"2026-03-01T12:00:00Z","ups_count":2,"reporting":1,"total_load_watts":450,"min_runtime_mins":10,"worst_status":"On Battery","worst_ups":"rack","any_on_battery":true,"any_low_battery":false}`
+	if msg.Payload != want {
+		t.Errorf("payload = %s\nwant      %s", msg.Payload, want)
+	}
+}
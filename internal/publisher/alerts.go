@@ -0,0 +1,51 @@
+package publisher
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/alerts"
+)
+
+// AlertMessage is the JSON payload of an alert topic.
+type AlertMessage struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Severity string `json:"severity"`
+	Active   bool   `json:"active"`
+	Message  string `json:"message"`
+	Since    string `json:"since"`
+}
+
+// AlertTopic returns the topic carrying the state of the named alert.
+func AlertTopic(prefix, upsName, name string) string {
+	return fmt.Sprintf("%s/%s/alerts/%s", prefix, upsName, TopicLevel(name))
+}
+
+// PublishAlerts publishes each alert's current state to its alert topic.
+// Alert states are always retained so a subscriber connecting mid-incident
+// sees what is active.
+func PublishAlerts(changed []alerts.Alert, cfg PublishConfig, pub Publisher) error {
+	for _, a := range changed {
+		payload, err := json.Marshal(AlertMessage{
+			Name:     a.Name,
+			Type:     a.Type,
+			Severity: string(a.Severity),
+			Active:   a.Active,
+			Message:  a.Message,
+			Since:    a.Since.UTC().Format(time.RFC3339),
+		})
+		if err != nil {
+			return fmt.Errorf("marshalling alert %s: %w", a.Name, err)
+		}
+		if err := pub.Publish(Message{
+			Topic:    AlertTopic(cfg.Prefix, cfg.UPSName, a.Name),
+			Payload:  string(payload),
+			Retained: true,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
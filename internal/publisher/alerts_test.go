@@ -0,0 +1,46 @@
+package publisher_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/alerts"
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+)
+
+func TestPublishAlerts(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower", Retained: false}
+	changed := []alerts.Alert{{
+		Name:     "redundancy",
+		Type:     alerts.TypeMinLogins,
+		Severity: alerts.SeverityWarning,
+		Active:   true,
+		Message:  "1 upsd client(s) logged in, expected at least 2",
+		Since:    time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+	}}
+
+	if err := publisher.PublishAlerts(changed, cfg, fp); err != nil {
+		t.Fatalf("PublishAlerts: %v", err)
+	}
+	msg, ok := fp.Find("ups/cyberpower/alerts/redundancy")
+	if !ok {
+		t.Fatal("alert topic not published")
+	}
+	if !msg.Retained {
+		t.Error("alert state should always be retained")
+	}
+	want := `{"name":"redundancy","type":"min_logins","severity":"warning","active":true,"message":"1 upsd client(s) logged in, expected at least 2","since":"2026-03-01T12:00:00Z"}`
+	if msg.Payload != want {
+		t.Errorf("payload = %s\nwant      %s", msg.Payload, want)
+	}
+}
+
+func TestPublishAlerts_PublishError(t *testing.T) {
+	fp := &publisher.FakePublisher{PublishError: errors.New("broker down")}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower"}
+	if err := publisher.PublishAlerts([]alerts.Alert{{Name: "a"}}, cfg, fp); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,79 @@
+package publisher
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// AuditEntry is one connection event in the audit log.
+type AuditEntry struct {
+	Timestamp string `json:"timestamp"`
+	Link      string `json:"link"`  // "nut" or "mqtt"
+	Event     string `json:"event"` // e.g. "connected", "disconnected", "auth_failed"
+	Addr      string `json:"addr,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// AuditMessage is the JSON payload of the {prefix}/{label}/diag/connections
+// topic, oldest entry first.
+type AuditMessage struct {
+	Entries []AuditEntry `json:"entries"`
+}
+
+// AuditTopic returns the topic carrying the connection audit log.
+func AuditTopic(prefix, upsName string) string {
+	return fmt.Sprintf("%s/%s/diag/connections", prefix, upsName)
+}
+
+// AuditLog keeps the most recent connection events, so problems between the
+// bridge, upsd and the broker can be traced after the fact.  It is safe for
+// concurrent use: the MQTT client reports its events on its own goroutines.
+type AuditLog struct {
+	mu      sync.Mutex
+	max     int
+	entries []AuditEntry
+}
+
+// NewAuditLog returns an AuditLog that keeps the last max entries.
+func NewAuditLog(max int) *AuditLog {
+	return &AuditLog{max: max}
+}
+
+// Record adds an event, dropping the oldest once the log is full.
+func (a *AuditLog) Record(link, event, addr string, err error, at time.Time) {
+	e := AuditEntry{
+		Timestamp: at.UTC().Format(time.RFC3339),
+		Link:      link,
+		Event:     event,
+		Addr:      addr,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.entries = append(a.entries, e)
+	if n := len(a.entries) - a.max; n > 0 {
+		a.entries = append([]AuditEntry(nil), a.entries[n:]...)
+	}
+}
+
+// Publish publishes the whole log, always retained.  Entries recorded while
+// the broker was unreachable go out with the next successful publish.
+func (a *AuditLog) Publish(cfg PublishConfig, pub Publisher) error {
+	a.mu.Lock()
+	msg := AuditMessage{Entries: append([]AuditEntry{}, a.entries...)}
+	a.mu.Unlock()
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("marshalling audit log: %w", err)
+	}
+	return pub.Publish(Message{
+		Topic:    AuditTopic(cfg.Prefix, cfg.UPSName),
+		Payload:  string(payload),
+		Retained: true,
+	})
+}
@@ -0,0 +1,59 @@
+package publisher_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+)
+
+var auditAt = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func TestAuditLog_Publish(t *testing.T) {
+	a := publisher.NewAuditLog(5)
+	a.Record("mqtt", "connected", "tcp://broker:1883", nil, auditAt)
+	a.Record("nut", "disconnected", "10.0.0.5:3493", errors.New("EOF"), auditAt.Add(time.Minute))
+
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower"}
+	if err := a.Publish(cfg, fp); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	msg, ok := fp.Find("ups/cyberpower/diag/connections")
+	if !ok {
+		t.Fatal("audit topic not published")
+	}
+	want := `{"entries":[` +
+		`{"timestamp":"2026-03-01T12:00:00Z","link":"mqtt","event":"connected","addr":"tcp://broker:1883"},` +
+		`{"timestamp":"2026-03-01T12:01:00Z","link":"nut","event":"disconnected","addr":"10.0.0.5:3493","error":"EOF"}]}`
+	if msg.Payload != want {
+		t.Errorf("payload = %s\nwant      %s", msg.Payload, want)
+	}
+	if !msg.Retained {
+		t.Error("audit log should always be retained")
+	}
+}
+
+func TestAuditLog_Bounded(t *testing.T) {
+	a := publisher.NewAuditLog(2)
+	for _, ev := range []string{"connected", "disconnected", "connect_failed"} {
+		a.Record("nut", ev, "", nil, auditAt)
+	}
+	fp := &publisher.FakePublisher{}
+	a.Publish(publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower"}, fp) //nolint:errcheck
+	want := `{"entries":[` +
+		`{"timestamp":"2026-03-01T12:00:00Z","link":"nut","event":"disconnected"},` +
+		`{"timestamp":"2026-03-01T12:00:00Z","link":"nut","event":"connect_failed"}]}`
+	if got := fp.Messages[0].Payload; got != want {
+		t.Errorf("payload = %s\nwant      %s", got, want)
+	}
+}
+
+func TestAuditLog_Empty(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	publisher.NewAuditLog(3).Publish(publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower"}, fp) //nolint:errcheck
+	if got := fp.Messages[0].Payload; got != `{"entries":[]}` {
+		t.Errorf("payload = %s", got)
+	}
+}
@@ -0,0 +1,128 @@
+package publisher
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// BridgeStats is the JSON payload of the bridge stats topic, which reports
+// on the health of the bridge itself rather than the UPS.
+type BridgeStats struct {
+	Timestamp string `json:"timestamp"`
+
+	// ClockSkewSecs is the NUT server/driver clock minus the bridge clock,
+	// when the driver reports its time; ClockSkewed is set when it exceeds
+	// the configured threshold after allowing for the poll round trip.
+	ClockSkewSecs *float64 `json:"clock_skew_secs,omitempty"`
+	ClockSkewed   bool     `json:"clock_skewed"`
+
+	// SkippedPolls counts poll cycles skipped since startup because the
+	// previous cycle was still running, usually held up by a slow broker.
+	SkippedPolls int64 `json:"skipped_polls"`
+
+	// CycleBytes is what the last complete poll cycle published to the
+	// broker, when mqtt.byte_stats is on; OverByteBudget is set when that
+	// exceeded mqtt.byte_budget.
+	CycleBytes     *PublishedBytes `json:"cycle_bytes,omitempty"`
+	OverByteBudget bool            `json:"over_byte_budget,omitempty"`
+
+	// PollTimings breaks the last poll down by upsd request, when
+	// diagnostics.poll_timings is on.
+	PollTimings *PollTimings `json:"poll_timings_ms,omitempty"`
+
+	// Telemetry is the bridge's own health, when diagnostics.telemetry is
+	// on; its fields appear at the top level.
+	*Telemetry
+}
+
+// Telemetry is how the bridge itself is doing: how long it has run, its
+// polls since startup, failed ones, and the failures in a row up to the
+// last poll, how long that poll took, how often the connection to the
+// broker was re-established and the version it was built as.
+type Telemetry struct {
+	UptimeSecs          int64   `json:"uptime_secs"`
+	Polls               int64   `json:"polls"`
+	FailedPolls         int64   `json:"failed_polls"`
+	ConsecutiveFailures int64   `json:"consecutive_failures"`
+	LastPollMs          float64 `json:"last_poll_ms"`
+	MQTTReconnects      int64   `json:"mqtt_reconnects"`
+	Version             string  `json:"version"`
+}
+
+// PollTimings is how long each upsd request of a poll took, in
+// milliseconds: connecting and logging in, when the poll had to, the
+// ups.status check, LIST UPS, the variable batch and the whole poll.
+type PollTimings struct {
+	Connect float64 `json:"connect"`
+	Auth    float64 `json:"auth"`
+	Status  float64 `json:"status"`
+	ListUPS float64 `json:"list_ups"`
+	GetVars float64 `json:"get_vars"`
+	Total   float64 `json:"total"`
+}
+
+// BridgeTopic returns the topic carrying the bridge stats.
+func BridgeTopic(prefix, upsName string) string {
+	return fmt.Sprintf("%s/%s/bridge", prefix, upsName)
+}
+
+// PublishBridgeStats publishes s as JSON to the bridge stats topic.
+func PublishBridgeStats(s BridgeStats, cfg PublishConfig, pub Publisher) error {
+	payload, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("marshalling bridge stats: %w", err)
+	}
+	return pub.Publish(Message{
+		Topic:    BridgeTopic(cfg.Prefix, cfg.UPSName),
+		Payload:  string(payload),
+		Retained: cfg.Retained,
+	})
+}
+
+// NUTServerTopic returns the topic carrying the host:port of the upsd
+// server the bridge is connected to, which changes on failover.
+func NUTServerTopic(prefix, upsName string) string {
+	return BridgeTopic(prefix, upsName) + "/nut_server"
+}
+
+// PublishNUTServer publishes addr to the NUT server topic.
+func PublishNUTServer(addr string, cfg PublishConfig, pub Publisher) error {
+	return pub.Publish(Message{
+		Topic:    NUTServerTopic(cfg.Prefix, cfg.UPSName),
+		Payload:  addr,
+		Retained: cfg.Retained,
+	})
+}
+
+// MQTTBrokerTopic returns the topic carrying the URL of the broker the
+// bridge is publishing to, which changes on failover, or with
+// mqtt.broker_mode "fanout" the connected brokers separated by ", ".
+func MQTTBrokerTopic(prefix, upsName string) string {
+	return BridgeTopic(prefix, upsName) + "/mqtt_broker"
+}
+
+// PublishMQTTBroker publishes brokers to the MQTT broker topic.
+func PublishMQTTBroker(brokers string, cfg PublishConfig, pub Publisher) error {
+	return pub.Publish(Message{
+		Topic:    MQTTBrokerTopic(cfg.Prefix, cfg.UPSName),
+		Payload:  brokers,
+		Retained: cfg.Retained,
+	})
+}
+
+// UpdateAvailableTopic returns the topic carrying the latest release of
+// the bridge when it is newer than the one running.
+func UpdateAvailableTopic(prefix, upsName string) string {
+	return BridgeTopic(prefix, upsName) + "/update_available"
+}
+
+// PublishUpdateAvailable publishes latest, or "" when the bridge is up to
+// date, to the update topic.  It is always retained, so a dashboard sees
+// it whenever it looks; the check runs once a day or so.
+func PublishUpdateAvailable(latest string, cfg PublishConfig, pub Publisher) error {
+	return pub.Publish(Message{
+		Topic:    UpdateAvailableTopic(cfg.Prefix, cfg.UPSName),
+		Payload:  latest,
+		Retained: true,
+	})
+}
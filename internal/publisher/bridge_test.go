@@ -0,0 +1,69 @@
+package publisher_test
+
+import (
+	"testing"
+
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+)
+
+func TestPublishBridgeStats(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower", Retained: true}
+	skew := -93.5
+	s := publisher.BridgeStats{Timestamp: "2026-03-01T12:00:00Z", ClockSkewSecs: &skew, ClockSkewed: true, SkippedPolls: 2}
+	if err := publisher.PublishBridgeStats(s, cfg, fp); err != nil {
+		t.Fatalf("PublishBridgeStats: %v", err)
+	}
+	msg, ok := fp.Find("ups/cyberpower/bridge")
+	if !ok || !msg.Retained {
+		t.Fatalf("bridge stats = %+v, want retained on ups/cyberpower/bridge", msg)
+	}
+	want := `{"timestamp":"2026-03-01T12:00:00Z","clock_skew_secs":-93.5,"clock_skewed":true,"skipped_polls":2}`
+	if msg.Payload != want {
+		t.Errorf("payload = %s\nwant      %s", msg.Payload, want)
+	}
+}
+
+func TestPublishBridgeStats_NoSkewReading(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower"}
+	if err := publisher.PublishBridgeStats(publisher.BridgeStats{Timestamp: "t"}, cfg, fp); err != nil {
+		t.Fatalf("PublishBridgeStats: %v", err)
+	}
+	if msg, _ := fp.Find("ups/cyberpower/bridge"); msg.Payload != `{"timestamp":"t","clock_skewed":false,"skipped_polls":0}` {
+		t.Errorf("payload = %s", msg.Payload)
+	}
+}
+
+func TestPublishNUTServer(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower", Retained: true}
+	if err := publisher.PublishNUTServer("10.0.0.6:3493", cfg, fp); err != nil {
+		t.Fatalf("PublishNUTServer: %v", err)
+	}
+	if msg, ok := fp.Find("ups/cyberpower/bridge/nut_server"); !ok || msg.Payload != "10.0.0.6:3493" || !msg.Retained {
+		t.Errorf("nut_server = %+v, want the retained address", msg)
+	}
+}
+
+func TestPublishMQTTBroker(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower", Retained: true}
+	if err := publisher.PublishMQTTBroker("tcp://standby:1883", cfg, fp); err != nil {
+		t.Fatalf("PublishMQTTBroker: %v", err)
+	}
+	if msg, ok := fp.Find("ups/cyberpower/bridge/mqtt_broker"); !ok || msg.Payload != "tcp://standby:1883" || !msg.Retained {
+		t.Errorf("mqtt_broker = %+v, want the retained URL", msg)
+	}
+}
+
+func TestPublishUpdateAvailable(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower"}
+	if err := publisher.PublishUpdateAvailable("v1.4.0", cfg, fp); err != nil {
+		t.Fatalf("PublishUpdateAvailable: %v", err)
+	}
+	if msg, ok := fp.Find("ups/cyberpower/bridge/update_available"); !ok || msg.Payload != "v1.4.0" || !msg.Retained {
+		t.Errorf("update_available = %+v, want the version retained even with retained off", msg)
+	}
+}
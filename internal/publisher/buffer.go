@@ -0,0 +1,227 @@
+package publisher
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"sync"
+)
+
+// BufferPublisher wraps a Publisher and holds on to what is published
+// while the broker is unreachable, up to a fixed number of messages, so a
+// broker outage delays data instead of losing it.  The messages are sent,
+// oldest first, once the connection is back; when the buffer is full the
+// oldest are dropped.  With a file the buffer also survives a restart.
+//
+// The wrapped publisher is never called while offline: paho would silently
+// drop QoS 0 messages and hold QoS 1 and 2 ones until the reconnect, with
+// the publish blocked.
+type BufferPublisher struct {
+	Publisher
+
+	mu      sync.Mutex
+	online  bool
+	max     int
+	queue   []Message
+	dropped int
+
+	// path is the file backing the buffer, empty for memory only.  Queued
+	// messages are appended to it in the file sink's format, and it is
+	// emptied once they are sent; lines counts what it holds, which grows
+	// past max as the oldest are dropped until it is rewritten.
+	path  string
+	file  *os.File
+	lines int
+}
+
+// NewBufferPublisher returns pub wrapped to buffer up to max messages while
+// offline, starting online.  With path set the buffer is kept in that file
+// too, and anything a previous run left there is loaded to be sent first.
+func NewBufferPublisher(pub Publisher, max int, path string) (*BufferPublisher, error) {
+	b := &BufferPublisher{Publisher: pub, online: true, max: max, path: path}
+	if path == "" {
+		return b, nil
+	}
+	if err := b.load(); err != nil {
+		return nil, fmt.Errorf("reading buffer file: %w", err)
+	}
+	if err := b.rewrite(); err != nil {
+		return nil, fmt.Errorf("writing buffer file: %w", err)
+	}
+	if len(b.queue) > 0 {
+		log.Printf("mqtt buffer: %d message(s) left from the last run will be sent first", len(b.queue))
+	}
+	return b, nil
+}
+
+// load reads the messages in the buffer file, keeping the newest max.
+func (b *BufferPublisher) load() error {
+	f, err := os.Open(b.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close() //nolint:errcheck
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		var rec sinkRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// A line cut short by a crash mid-write.
+			continue
+		}
+		b.queue = append(b.queue, Message{Topic: rec.Topic, Payload: rec.Payload, Retained: rec.Retained})
+	}
+	if n := len(b.queue) - b.max; n > 0 {
+		b.queue = b.queue[n:]
+	}
+	return scanner.Err()
+}
+
+// SetOnline records whether the broker is reachable.  Coming back online
+// sends the buffered messages; an error means some are still buffered,
+// to be tried again with the next publish.
+func (b *BufferPublisher) SetOnline(online bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.online == online {
+		return nil
+	}
+	b.online = online
+	if !online {
+		return nil
+	}
+	if b.dropped > 0 {
+		log.Printf("mqtt buffer: %d message(s) were dropped while the broker was unreachable", b.dropped)
+		b.dropped = 0
+	}
+	return b.flush()
+}
+
+// Publish sends msg, after anything still buffered, or buffers it while
+// offline.  When the buffered messages can't be sent, msg is buffered
+// behind them and the error returned.
+func (b *BufferPublisher) Publish(msg Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.online {
+		return b.push(msg)
+	}
+	if err := b.flush(); err != nil {
+		if perr := b.push(msg); perr != nil {
+			return errors.Join(err, perr)
+		}
+		return err
+	}
+	return b.Publisher.Publish(msg)
+}
+
+// Buffered returns the number of messages waiting to be sent.
+func (b *BufferPublisher) Buffered() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.queue)
+}
+
+// Close closes the buffer file and the wrapped publisher.  Messages still
+// buffered stay in the file for the next run, and are lost without one.
+func (b *BufferPublisher) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if n := len(b.queue); n > 0 && b.file == nil {
+		log.Printf("mqtt buffer: %d message(s) never reached the broker", n)
+	}
+	var errs []error
+	if b.file != nil {
+		errs = append(errs, b.file.Close())
+		b.file = nil
+	}
+	return errors.Join(append(errs, b.Publisher.Close())...)
+}
+
+// push buffers msg, dropping the oldest message when the buffer is full.
+func (b *BufferPublisher) push(msg Message) error {
+	if len(b.queue) >= b.max {
+		b.queue = b.queue[1:]
+		b.dropped++
+	}
+	b.queue = append(b.queue, msg)
+	if b.file == nil {
+		return nil
+	}
+	// Rewriting only once the file holds twice what is buffered keeps
+	// the cost of dropping the oldest messages down.
+	if b.lines >= 2*b.max {
+		return b.rewrite()
+	}
+	return b.writeLine(msg)
+}
+
+// flush sends the buffered messages in order, stopping at the first that
+// fails.
+func (b *BufferPublisher) flush() error {
+	if len(b.queue) == 0 {
+		return nil
+	}
+	sent := 0
+	var err error
+	for _, msg := range b.queue {
+		if err = b.Publisher.Publish(msg); err != nil {
+			break
+		}
+		sent++
+	}
+	b.queue = b.queue[sent:]
+	if sent > 0 {
+		log.Printf("mqtt buffer: sent %d buffered message(s)", sent)
+	}
+	if b.file != nil {
+		if werr := b.rewrite(); werr != nil {
+			err = errors.Join(err, werr)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("sending buffered messages (%d left): %w", len(b.queue), err)
+	}
+	return nil
+}
+
+// writeLine adds msg to the buffer file.
+func (b *BufferPublisher) writeLine(msg Message) error {
+	line, err := newSinkRecord(msg)
+	if err != nil {
+		return err
+	}
+	if _, err := b.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("writing buffer file: %w", err)
+	}
+	b.lines++
+	return nil
+}
+
+// rewrite replaces the buffer file with what is buffered now, writing a
+// new file and renaming it into place so a crash leaves one or the other.
+func (b *BufferPublisher) rewrite() error {
+	if b.file != nil {
+		b.file.Close() //nolint:errcheck
+		b.file = nil
+	}
+	tmp := b.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	b.file, b.lines = f, 0
+	for _, msg := range b.queue {
+		if err := b.writeLine(msg); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmp, b.path)
+}
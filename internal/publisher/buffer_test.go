@@ -0,0 +1,97 @@
+package publisher_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+)
+
+func payloads(msgs []publisher.Message) []string {
+	var out []string
+	for _, m := range msgs {
+		out = append(out, m.Payload)
+	}
+	return out
+}
+
+func TestBufferPublisher(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	b, err := publisher.NewBufferPublisher(fp, 3, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Publish(publisher.Message{Topic: "t", Payload: "1"}) //nolint:errcheck
+	b.SetOnline(false)                                     //nolint:errcheck
+	for _, p := range []string{"2", "3", "4", "5"} {
+		if err := b.Publish(publisher.Message{Topic: "t", Payload: p}); err != nil {
+			t.Fatalf("Publish while offline: %v", err)
+		}
+	}
+	if len(fp.Messages) != 1 || b.Buffered() != 3 {
+		t.Fatalf("published %v with %d buffered, want only 1 published and 3 buffered", payloads(fp.Messages), b.Buffered())
+	}
+	if err := b.SetOnline(true); err != nil {
+		t.Fatalf("SetOnline: %v", err)
+	}
+	b.Publish(publisher.Message{Topic: "t", Payload: "6"}) //nolint:errcheck
+	if got := payloads(fp.Messages); len(got) != 5 || got[1] != "3" || got[3] != "5" || got[4] != "6" {
+		t.Errorf("published %v, want 1 then the newest buffered 3 4 5 in order, then 6", got)
+	}
+}
+
+func TestBufferPublisher_FailedFlushRetried(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	b, _ := publisher.NewBufferPublisher(fp, 10, "")
+	b.SetOnline(false)                                     //nolint:errcheck
+	b.Publish(publisher.Message{Topic: "t", Payload: "1"}) //nolint:errcheck
+	fp.PublishError = errors.New("broker gone again")
+	if err := b.SetOnline(true); err == nil {
+		t.Error("SetOnline should report the failed flush")
+	}
+	if err := b.Publish(publisher.Message{Topic: "t", Payload: "2"}); err == nil {
+		t.Error("Publish should report the failed flush")
+	}
+	fp.PublishError = nil
+	b.Publish(publisher.Message{Topic: "t", Payload: "3"}) //nolint:errcheck
+	if got := payloads(fp.Messages); len(got) != 3 || got[0] != "1" || got[1] != "2" || got[2] != "3" {
+		t.Errorf("published %v, want 1 2 3", got)
+	}
+}
+
+func TestBufferPublisher_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buffer.jsonl")
+	fp := &publisher.FakePublisher{}
+	b, err := publisher.NewBufferPublisher(fp, 2, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.SetOnline(false) //nolint:errcheck
+	for _, p := range []string{"1", "2", "3", "4", "5", "6"} {
+		if err := b.Publish(publisher.Message{Topic: "t", Payload: p, Retained: true}); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	b.Close() //nolint:errcheck
+
+	fp = &publisher.FakePublisher{}
+	b, err = publisher.NewBufferPublisher(fp, 2, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Buffered() != 2 {
+		t.Fatalf("Buffered() = %d after a restart, want 2", b.Buffered())
+	}
+	b.Publish(publisher.Message{Topic: "t", Payload: "7"}) //nolint:errcheck
+	if got := payloads(fp.Messages); len(got) != 3 || got[0] != "5" || got[1] != "6" || got[2] != "7" || !fp.Messages[0].Retained {
+		t.Errorf("published %+v, want the retained 5 and 6 left from the last run, then 7", fp.Messages)
+	}
+	b.Close() //nolint:errcheck
+
+	b, _ = publisher.NewBufferPublisher(&publisher.FakePublisher{}, 2, path)
+	if b.Buffered() != 0 {
+		t.Errorf("Buffered() = %d, want the file emptied once sent", b.Buffered())
+	}
+	b.Close() //nolint:errcheck
+}
@@ -0,0 +1,150 @@
+package publisher
+
+import (
+	"strings"
+	"sync"
+)
+
+// Topic classes reported by ByteCounter.
+const (
+	ClassState     = "state"
+	ClassVariables = "variables"
+	ClassComputed  = "computed"
+	ClassDiscovery = "discovery"
+	ClassOther     = "other"
+)
+
+// TopicClassNames lists every topic class, in the order they are reported.
+var TopicClassNames = []string{ClassState, ClassVariables, ClassComputed, ClassDiscovery, ClassOther}
+
+// ownTopics are the first topic levels under {prefix}/{label} that the
+// bridge publishes itself rather than carrying a NUT variable.
+var ownTopics = map[string]bool{
+	"aclcheck": true, "alerts": true, "bridge": true, "clients": true,
+	"cmd": true, "diag": true, "diff": true, "events": true,
+	"glitch_count": true, "notify": true, "outage": true, "outages": true,
+	"selftest": true, "summary": true,
+}
+
+// TopicClasses sorts topics into the classes ByteCounter reports.
+type TopicClasses struct {
+	// Roots are the {prefix}/{label} roots the bridge publishes under,
+	// including a migration mirror's.
+	Roots []string
+
+	// VariableRoots are the roots of mqtt.namespace_prefixes, which carry
+	// only variables.
+	VariableRoots []string
+
+	// DiscoveryPrefix is the Home Assistant discovery prefix, empty when
+	// discovery is off.
+	DiscoveryPrefix string
+}
+
+// Class returns the class of topic: "state" for the state topic and its
+// parts, "computed" for computed/ topics, "discovery" for Home Assistant
+// discovery, "variables" for per-variable topics and their $last_changed
+// companions, and "other" for everything else, such as events, alerts and
+// the bridge's own status.
+func (c TopicClasses) Class(topic string) string {
+	if c.DiscoveryPrefix != "" && strings.HasPrefix(topic, c.DiscoveryPrefix+"/") {
+		return ClassDiscovery
+	}
+	for _, root := range c.Roots {
+		rest, ok := strings.CutPrefix(topic, root+"/")
+		if !ok {
+			continue
+		}
+		first, _, _ := strings.Cut(rest, "/")
+		switch {
+		case first == "state":
+			return ClassState
+		case first == "computed":
+			return ClassComputed
+		case ownTopics[first]:
+			return ClassOther
+		}
+		return ClassVariables
+	}
+	for _, root := range c.VariableRoots {
+		root = strings.TrimSuffix(root, "/")
+		if topic == root || strings.HasPrefix(topic, root+"/") {
+			return ClassVariables
+		}
+	}
+	return ClassOther
+}
+
+// PublishedBytes is what a ByteCounter counted: the messages published and
+// their bytes, topic and payload together, in total and by topic class.
+type PublishedBytes struct {
+	Bytes    int64            `json:"bytes"`
+	Messages int64            `json:"messages"`
+	ByClass  map[string]int64 `json:"by_class"`
+}
+
+// ByteCounter wraps a Publisher and counts the messages it accepts and
+// their size, for sizing a broker or a metered cloud plan.  A message's
+// size is its topic plus its payload: the bulk of what the broker bills
+// or stores, leaving out the few bytes of MQTT framing.
+type ByteCounter struct {
+	Publisher
+
+	mu      sync.Mutex
+	classes TopicClasses
+	counts  PublishedBytes
+}
+
+// NewByteCounter returns pub wrapped to count what is published through it.
+func NewByteCounter(pub Publisher) *ByteCounter {
+	return &ByteCounter{Publisher: pub}
+}
+
+// SetClasses sets how topics are classified from now on.
+func (c *ByteCounter) SetClasses(classes TopicClasses) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.classes = classes
+}
+
+// Publish sends msg and counts it once the wrapped Publisher accepted it.
+func (c *ByteCounter) Publish(msg Message) error {
+	if err := c.Publisher.Publish(msg); err != nil {
+		return err
+	}
+	n := int64(len(msg.Topic) + len(msg.Payload))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts.ByClass == nil {
+		c.counts.ByClass = map[string]int64{}
+	}
+	c.counts.Bytes += n
+	c.counts.Messages++
+	c.counts.ByClass[c.classes.Class(msg.Topic)] += n
+	return nil
+}
+
+// Messages returns how many messages were counted since the last Take.
+func (c *ByteCounter) Messages() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts.Messages
+}
+
+// Take returns what was counted since the last Take and starts again.
+// Every class is present in ByClass, counted or not.
+func (c *ByteCounter) Take() PublishedBytes {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := c.counts
+	c.counts = PublishedBytes{}
+	if counts.ByClass == nil {
+		counts.ByClass = map[string]int64{}
+	}
+	for _, class := range TopicClassNames {
+		if _, ok := counts.ByClass[class]; !ok {
+			counts.ByClass[class] = 0
+		}
+	}
+	return counts
+}
@@ -0,0 +1,61 @@
+package publisher_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+)
+
+func TestTopicClasses(t *testing.T) {
+	c := publisher.TopicClasses{
+		Roots:           []string{"ups/office", "power/office"},
+		VariableRoots:   []string{"site/battery/"},
+		DiscoveryPrefix: "homeassistant",
+	}
+	for topic, want := range map[string]string{
+		"ups/office/state":                    "state",
+		"ups/office/state/part/2":             "state",
+		"ups/office/battery/charge":           "variables",
+		"ups/office/ups/status/$last_changed": "variables",
+		"power/office/input/voltage":          "variables",
+		"site/battery/charge":                 "variables",
+		"ups/office/computed/load_watts":      "computed",
+		"homeassistant/sensor/office/config":  "discovery",
+		"ups/office/events":                   "other",
+		"ups/office/bridge/mqtt_broker":       "other",
+		"ups/other/battery/charge":            "other",
+		"ups/officeish/battery/charge":        "other",
+	} {
+		if got := c.Class(topic); got != want {
+			t.Errorf("Class(%q) = %q, want %q", topic, got, want)
+		}
+	}
+}
+
+func TestByteCounter(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	c := publisher.NewByteCounter(fp)
+	c.SetClasses(publisher.TopicClasses{Roots: []string{"ups/office"}})
+
+	c.Publish(publisher.Message{Topic: "ups/office/state", Payload: "{}"})           //nolint:errcheck
+	c.Publish(publisher.Message{Topic: "ups/office/battery/charge", Payload: "100"}) //nolint:errcheck
+	fp.PublishError = errors.New("broker down")
+	if err := c.Publish(publisher.Message{Topic: "ups/office/events", Payload: "x"}); err == nil {
+		t.Fatal("Publish should pass on the wrapped publisher's error")
+	}
+
+	got := c.Take()
+	if got.Bytes != 16+2+25+3 || got.Messages != 2 {
+		t.Errorf("counted %d bytes in %d messages", got.Bytes, got.Messages)
+	}
+	if got.ByClass["state"] != 18 || got.ByClass["variables"] != 28 || got.ByClass["other"] != 0 {
+		t.Errorf("by class = %v", got.ByClass)
+	}
+	if len(got.ByClass) != len(publisher.TopicClassNames) {
+		t.Errorf("by class = %v, want every class", got.ByClass)
+	}
+	if again := c.Take(); again.Bytes != 0 || again.ByClass["state"] != 0 {
+		t.Errorf("second Take = %+v, want nothing counted", again)
+	}
+}
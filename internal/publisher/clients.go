@@ -0,0 +1,57 @@
+package publisher
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ClientsMessage is the JSON payload of the {prefix}/{label}/clients topic.
+type ClientsMessage struct {
+	Timestamp string   `json:"timestamp"`
+	Count     int      `json:"count"`
+	NumLogins *int     `json:"num_logins,omitempty"` // nil when upsd doesn't report it
+	Hosts     []string `json:"hosts"`
+	Missing   []string `json:"missing,omitempty"` // expected hosts not attached
+}
+
+// ClientsTopic returns the topic listing the upsd clients attached to the UPS.
+func ClientsTopic(prefix, upsName string) string {
+	return fmt.Sprintf("%s/%s/clients", prefix, upsName)
+}
+
+// PublishClients publishes the attached hosts.  numLogins < 0 means upsd
+// did not report a login count.  Every host in expected that is not in
+// hosts is listed under "missing".
+func PublishClients(hosts []string, numLogins int, expected []string, at time.Time, cfg PublishConfig, pub Publisher) error {
+	msg := ClientsMessage{
+		Timestamp: at.UTC().Format(time.RFC3339),
+		Count:     len(hosts),
+		Hosts:     hosts,
+	}
+	if msg.Hosts == nil {
+		msg.Hosts = []string{}
+	}
+	if numLogins >= 0 {
+		msg.NumLogins = &numLogins
+	}
+	attached := make(map[string]bool, len(hosts))
+	for _, h := range hosts {
+		attached[h] = true
+	}
+	for _, h := range expected {
+		if !attached[h] {
+			msg.Missing = append(msg.Missing, h)
+		}
+	}
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("marshalling clients: %w", err)
+	}
+	return pub.Publish(Message{
+		Topic:    ClientsTopic(cfg.Prefix, cfg.UPSName),
+		Payload:  string(payload),
+		Retained: cfg.Retained,
+	})
+}
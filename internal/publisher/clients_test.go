@@ -0,0 +1,53 @@
+package publisher_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+)
+
+var clientsAt = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func TestPublishClients(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower", Retained: true}
+	hosts := []string{"192.168.1.10", "192.168.1.11"}
+	expected := []string{"192.168.1.10", "192.168.1.12"}
+
+	if err := publisher.PublishClients(hosts, 2, expected, clientsAt, cfg, fp); err != nil {
+		t.Fatalf("PublishClients: %v", err)
+	}
+	msg, ok := fp.Find("ups/cyberpower/clients")
+	if !ok {
+		t.Fatal("clients topic not published")
+	}
+	want := `{"timestamp":"2026-03-01T12:00:00Z","count":2,"num_logins":2,"hosts":["192.168.1.10","192.168.1.11"],"missing":["192.168.1.12"]}`
+	if msg.Payload != want {
+		t.Errorf("payload = %s\nwant      %s", msg.Payload, want)
+	}
+	if !msg.Retained {
+		t.Error("clients should follow cfg.Retained")
+	}
+}
+
+func TestPublishClients_NoneAttachedNoNumLogins(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower"}
+	if err := publisher.PublishClients(nil, -1, nil, clientsAt, cfg, fp); err != nil {
+		t.Fatalf("PublishClients: %v", err)
+	}
+	want := `{"timestamp":"2026-03-01T12:00:00Z","count":0,"hosts":[]}`
+	if msg, _ := fp.Find("ups/cyberpower/clients"); msg.Payload != want {
+		t.Errorf("payload = %s, want %s", msg.Payload, want)
+	}
+}
+
+func TestPublishClients_PublishError(t *testing.T) {
+	fp := &publisher.FakePublisher{PublishError: errors.New("broker down")}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower"}
+	if err := publisher.PublishClients(nil, 0, nil, clientsAt, cfg, fp); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,48 @@
+package publisher
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// CommandResultMessage is the JSON payload of the cmd/result topic.
+type CommandResultMessage struct {
+	Command   string `json:"command"`
+	OK        bool   `json:"ok"`
+	Error     string `json:"error,omitempty"`
+	Timestamp string `json:"timestamp"`
+}
+
+// CommandTopic returns the topic that accepts NUT instant command names
+// when MQTT commands are enabled.
+func CommandTopic(prefix, upsName string) string {
+	return fmt.Sprintf("%s/%s/cmd", prefix, upsName)
+}
+
+// CommandResultTopic returns the topic each command's outcome is published on.
+func CommandResultTopic(prefix, upsName string) string {
+	return fmt.Sprintf("%s/%s/cmd/result", prefix, upsName)
+}
+
+// PublishCommandResult publishes the outcome of one instant command.
+// Results are never retained: they answer a request, not describe a state.
+func PublishCommandResult(cmd string, cmdErr error, t time.Time, cfg PublishConfig, pub Publisher) error {
+	res := CommandResultMessage{
+		Command:   cmd,
+		OK:        cmdErr == nil,
+		Timestamp: t.UTC().Format(time.RFC3339),
+	}
+	if cmdErr != nil {
+		res.Error = cmdErr.Error()
+	}
+	payload, err := json.Marshal(res)
+	if err != nil {
+		return fmt.Errorf("marshalling command result: %w", err)
+	}
+	return pub.Publish(Message{
+		Topic:    CommandResultTopic(cfg.Prefix, cfg.UPSName),
+		Payload:  string(payload),
+		Retained: false,
+	})
+}
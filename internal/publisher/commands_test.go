@@ -0,0 +1,31 @@
+package publisher_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+)
+
+func TestPublishCommandResult(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower", Retained: true}
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	if err := publisher.PublishCommandResult("beeper.disable", nil, at, cfg, fp); err != nil {
+		t.Fatalf("PublishCommandResult: %v", err)
+	}
+	if err := publisher.PublishCommandResult("load.off", errors.New("ACCESS-DENIED"), at, cfg, fp); err != nil {
+		t.Fatalf("PublishCommandResult: %v", err)
+	}
+	want := []string{
+		`{"command":"beeper.disable","ok":true,"timestamp":"2026-03-01T12:00:00Z"}`,
+		`{"command":"load.off","ok":false,"error":"ACCESS-DENIED","timestamp":"2026-03-01T12:00:00Z"}`,
+	}
+	for i, msg := range fp.Messages {
+		if msg.Topic != "ups/cyberpower/cmd/result" || msg.Payload != want[i] || msg.Retained {
+			t.Errorf("message %d = %+v, want non-retained %s", i, msg, want[i])
+		}
+	}
+}
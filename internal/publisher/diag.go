@@ -0,0 +1,32 @@
+package publisher
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RawCommandTopic returns the diagnostics topic that accepts raw NUT
+// protocol lines when raw passthrough is enabled.
+func RawCommandTopic(prefix, upsName string) string {
+	return fmt.Sprintf("%s/%s/diag/nut/command", prefix, upsName)
+}
+
+// RawResponseTopic returns the topic raw NUT responses are published on.
+func RawResponseTopic(prefix, upsName string) string {
+	return fmt.Sprintf("%s/%s/diag/nut/response", prefix, upsName)
+}
+
+// PublishRawResponse publishes upsd's reply lines, newline-separated, or
+// "ERR <reason>" when the command failed or was refused.  Responses are
+// never retained.
+func PublishRawResponse(resp []string, cmdErr error, cfg PublishConfig, pub Publisher) error {
+	payload := strings.Join(resp, "\n")
+	if cmdErr != nil {
+		payload = "ERR " + cmdErr.Error()
+	}
+	return pub.Publish(Message{
+		Topic:    RawResponseTopic(cfg.Prefix, cfg.UPSName),
+		Payload:  payload,
+		Retained: false,
+	})
+}
@@ -0,0 +1,39 @@
+package publisher_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+)
+
+func TestPublishRawResponse(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower", Retained: true}
+	resp := []string{"BEGIN LIST UPS", `UPS cyberpower "CP1500"`, "END LIST UPS"}
+
+	if err := publisher.PublishRawResponse(resp, nil, cfg, fp); err != nil {
+		t.Fatalf("PublishRawResponse: %v", err)
+	}
+	msg, ok := fp.Find("ups/cyberpower/diag/nut/response")
+	if !ok {
+		t.Fatal("response not published")
+	}
+	if msg.Retained {
+		t.Error("raw responses should never be retained")
+	}
+	if want := "BEGIN LIST UPS\nUPS cyberpower \"CP1500\"\nEND LIST UPS"; msg.Payload != want {
+		t.Errorf("payload = %q, want %q", msg.Payload, want)
+	}
+}
+
+func TestPublishRawResponse_Error(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower"}
+	if err := publisher.PublishRawResponse(nil, errors.New("command not allowed"), cfg, fp); err != nil {
+		t.Fatalf("PublishRawResponse: %v", err)
+	}
+	if msg, _ := fp.Find("ups/cyberpower/diag/nut/response"); msg.Payload != "ERR command not allowed" {
+		t.Errorf("payload = %q", msg.Payload)
+	}
+}
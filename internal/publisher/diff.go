@@ -0,0 +1,82 @@
+package publisher
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// VarChange is the before/after value of one NUT variable in a diff message.
+// Old is nil for a variable that just appeared, New is nil for one that
+// disappeared.
+type VarChange struct {
+	Old *string `json:"old"`
+	New *string `json:"new"`
+}
+
+// DiffMessage is the payload of the {prefix}/{label}/diff topic.
+type DiffMessage struct {
+	Timestamp string               `json:"timestamp"`
+	Changes   map[string]VarChange `json:"changes"`
+}
+
+// DiffTopic returns the topic carrying per-poll variable changes.
+func DiffTopic(prefix, upsName string) string {
+	return fmt.Sprintf("%s/%s/diff", prefix, upsName)
+}
+
+// DiffVars compares two polls' variables and returns an entry for every name
+// that was added, removed or changed value.  The result is empty when prev
+// and cur are identical.
+func DiffVars(prev, cur map[string]string) map[string]VarChange {
+	changes := make(map[string]VarChange)
+	for name, v := range cur {
+		old, ok := prev[name]
+		if ok && old == v {
+			continue
+		}
+		v := v
+		c := VarChange{New: &v}
+		if ok {
+			c.Old = &old
+		}
+		changes[name] = c
+	}
+	for name, v := range prev {
+		if _, ok := cur[name]; !ok {
+			v := v
+			changes[name] = VarChange{Old: &v}
+		}
+	}
+	return changes
+}
+
+// PublishDiff publishes changes to the diff topic, leaving out variables
+// cfg filters out.  Nothing is sent when no change is left.  The message is never retained: it describes a single
+// transition, not current state.
+func PublishDiff(changes map[string]VarChange, at time.Time, cfg PublishConfig, pub Publisher) error {
+	if len(cfg.IncludeVars) > 0 || len(cfg.ExcludeVars) > 0 {
+		kept := make(map[string]VarChange, len(changes))
+		for name, c := range changes {
+			if cfg.publishes(name) {
+				kept[name] = c
+			}
+		}
+		changes = kept
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(DiffMessage{
+		Timestamp: at.UTC().Format(time.RFC3339),
+		Changes:   changes,
+	})
+	if err != nil {
+		return fmt.Errorf("marshalling diff: %w", err)
+	}
+	return pub.Publish(Message{
+		Topic:    DiffTopic(cfg.Prefix, cfg.UPSName),
+		Payload:  string(payload),
+		Retained: false,
+	})
+}
@@ -0,0 +1,82 @@
+package publisher_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+)
+
+func TestDiffVars_ChangedAddedRemoved(t *testing.T) {
+	prev := map[string]string{"ups.status": "OL", "battery.charge": "100", "ups.test.result": "Done"}
+	cur := map[string]string{"ups.status": "OB DISCHRG", "battery.charge": "100", "ups.beeper.status": "enabled"}
+
+	got := publisher.DiffVars(prev, cur)
+	if len(got) != 3 {
+		t.Fatalf("DiffVars returned %d entries, want 3: %v", len(got), got)
+	}
+	if c := got["ups.status"]; c.Old == nil || *c.Old != "OL" || c.New == nil || *c.New != "OB DISCHRG" {
+		t.Errorf("ups.status = %+v, want OL → OB DISCHRG", c)
+	}
+	if c := got["ups.beeper.status"]; c.Old != nil || c.New == nil || *c.New != "enabled" {
+		t.Errorf("ups.beeper.status = %+v, want added", c)
+	}
+	if c := got["ups.test.result"]; c.Old == nil || *c.Old != "Done" || c.New != nil {
+		t.Errorf("ups.test.result = %+v, want removed", c)
+	}
+}
+
+func TestDiffVars_Identical(t *testing.T) {
+	vars := map[string]string{"ups.status": "OL"}
+	if got := publisher.DiffVars(vars, vars); len(got) != 0 {
+		t.Errorf("DiffVars = %v, want empty", got)
+	}
+}
+
+func TestPublishDiff_NonRetainedJSON(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower", Retained: true}
+	changes := publisher.DiffVars(map[string]string{"ups.load": "8"}, map[string]string{"ups.load": "9"})
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	if err := publisher.PublishDiff(changes, at, cfg, fp); err != nil {
+		t.Fatalf("PublishDiff: %v", err)
+	}
+	msg, ok := fp.Find("ups/cyberpower/diff")
+	if !ok {
+		t.Fatal("diff topic not published")
+	}
+	if msg.Retained {
+		t.Error("diff should never be retained")
+	}
+	want := `{"timestamp":"2026-03-01T12:00:00Z","changes":{"ups.load":{"old":"8","new":"9"}}}`
+	if msg.Payload != want {
+		t.Errorf("payload = %s, want %s", msg.Payload, want)
+	}
+	var d publisher.DiffMessage
+	if err := json.Unmarshal([]byte(msg.Payload), &d); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+}
+
+func TestPublishDiff_EmptySkipped(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower"}
+	if err := publisher.PublishDiff(nil, time.Now(), cfg, fp); err != nil {
+		t.Fatalf("PublishDiff: %v", err)
+	}
+	if len(fp.Messages) != 0 {
+		t.Errorf("got %d messages, want none for an empty diff", len(fp.Messages))
+	}
+}
+
+func TestPublishDiff_PublishError(t *testing.T) {
+	fp := &publisher.FakePublisher{PublishError: errors.New("broker down")}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower"}
+	changes := publisher.DiffVars(nil, map[string]string{"ups.load": "9"})
+	if err := publisher.PublishDiff(changes, time.Now(), cfg, fp); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,208 @@
+package publisher
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// DiscoveryConfig controls Home Assistant MQTT discovery.
+type DiscoveryConfig struct {
+	Prefix string // HA discovery prefix, normally "homeassistant"
+}
+
+// entity describes how one published value maps onto a Home Assistant
+// entity.  Topic-relative names are resolved against PublishConfig when
+// discovery payloads are built.
+type entity struct {
+	key         string // metric key under computed/, or NUT variable name when raw
+	raw         bool   // true for raw NUT variables, false for computed metrics
+	component   string // "sensor" or "binary_sensor"
+	name        string
+	deviceClass string
+	unit        string
+	stateClass  string
+	options     []string // possible states of an "enum" sensor
+
+	// alwaysAvailable leaves out the availability topic: the entity reports
+	// the very condition that marks the state topic offline.
+	alwaysAvailable bool
+}
+
+// discoveryEntities lists the values announced to Home Assistant.  Binary
+// sensors use the "true"/"false" payloads produced by strconv.FormatBool.
+var discoveryEntities = []entity{
+	{key: "battery.charge", raw: true, component: "sensor", name: "Battery charge", deviceClass: "battery", unit: "%", stateClass: "measurement"},
+	{key: "load_watts", component: "sensor", name: "Load", deviceClass: "power", unit: "W", stateClass: "measurement"},
+	{key: "battery_runtime_mins", component: "sensor", name: "Battery runtime", deviceClass: "duration", unit: "min", stateClass: "measurement"},
+	{key: "battery_runtime_hours", component: "sensor", name: "Battery runtime (hours)", deviceClass: "duration", unit: "h", stateClass: "measurement"},
+	{key: "on_battery", component: "binary_sensor", name: "On battery"},
+	{key: "low_battery", component: "binary_sensor", name: "Low battery", deviceClass: "battery"},
+	{key: "status_display", component: "sensor", name: "Status"},
+	{key: "power_source", component: "sensor", name: "Power source", deviceClass: "enum", options: []string{"mains", "battery", "bypass", "off", "unknown"}},
+	{key: "input_voltage_deviation_pct", component: "sensor", name: "Input voltage deviation", unit: "%", stateClass: "measurement"},
+	{key: "communication_lost", component: "binary_sensor", name: "Communication lost", deviceClass: "problem", alwaysAvailable: true},
+}
+
+// discoveryDevice is the "device" block shared by every entity of one UPS.
+type discoveryDevice struct {
+	Identifiers   []string `json:"identifiers"`
+	Name          string   `json:"name"`
+	Manufacturer  string   `json:"manufacturer,omitempty"`
+	Model         string   `json:"model,omitempty"`
+	SuggestedArea string   `json:"suggested_area,omitempty"`
+}
+
+// discoveryPayload is the JSON body of a Home Assistant discovery message.
+type discoveryPayload struct {
+	Name              string          `json:"name"`
+	UniqueID          string          `json:"unique_id"`
+	ObjectID          string          `json:"object_id"`
+	StateTopic        string          `json:"state_topic"`
+	DeviceClass       string          `json:"device_class,omitempty"`
+	UnitOfMeasurement string          `json:"unit_of_measurement,omitempty"`
+	StateClass        string          `json:"state_class,omitempty"`
+	Options           []string        `json:"options,omitempty"`
+	PayloadOn         string          `json:"payload_on,omitempty"`
+	PayloadOff        string          `json:"payload_off,omitempty"`
+	AvailabilityTopic string          `json:"availability_topic,omitempty"`
+	AvailabilityTmpl  string          `json:"availability_template,omitempty"`
+	AttributesTopic   string          `json:"json_attributes_topic,omitempty"`
+	AttributesTmpl    string          `json:"json_attributes_template,omitempty"`
+	Device            discoveryDevice `json:"device"`
+}
+
+// availabilityTemplate maps the state topic onto HA availability: the
+// offline announcement / LWT carries "online": false, every regular state
+// message has no "online" key at all.
+const availabilityTemplate = "{{ 'offline' if value_json.online is defined and not value_json.online else 'online' }}"
+
+// expiringAvailabilityTemplate is availabilityTemplate for state messages
+// stamped with expires_at: a retained message replayed after its expiry,
+// e.g. when HA restarts long after the bridge died, is treated as offline.
+const expiringAvailabilityTemplate = "{{ 'offline' if (value_json.online is defined and not value_json.online)" +
+	" or (value_json.expires_at is defined and as_timestamp(value_json.expires_at) < as_timestamp(now())) else 'online' }}"
+
+// labelsTemplate extracts the labels object of the state message as entity
+// attributes.  The offline announcement has none; the entities are
+// unavailable then anyway.
+const labelsTemplate = "{{ (value_json.labels if value_json.labels is defined else {}) | tojson }}"
+
+// CommunicationLostTopic returns the computed topic that reports whether the
+// bridge currently has working communication with the UPS.
+func CommunicationLostTopic(prefix, upsName string) string {
+	return ComputedTopic(prefix, upsName, "communication_lost")
+}
+
+// PublishCommunicationLost publishes computed/communication_lost: true when
+// polling failed (including upsd refusing stale driver data), false after a
+// successful poll.
+func PublishCommunicationLost(lost bool, cfg PublishConfig, pub Publisher) error {
+	return pub.Publish(Message{
+		Topic:    CommunicationLostTopic(cfg.Prefix, cfg.UPSName),
+		Payload:  fmt.Sprint(lost),
+		Retained: cfg.Retained,
+	})
+}
+
+// HAStatusTopic returns the topic Home Assistant publishes its birth
+// ("online") and will ("offline") messages on.
+func HAStatusTopic(discoveryPrefix string) string {
+	return discoveryPrefix + "/status"
+}
+
+// DiscoveryTopic returns the Home Assistant config topic for one entity.
+func DiscoveryTopic(discoveryPrefix, component, upsName, objectID string) string {
+	return fmt.Sprintf("%s/%s/%s/%s/config", discoveryPrefix, component, discoveryNodeID(upsName), objectID)
+}
+
+// PublishDiscovery announces every entity in discoveryEntities to Home
+// Assistant.  vars supplies the device manufacturer and model when the UPS
+// reports them.  HA devices have no free-form tags, so cfg.Labels become
+// attributes of every entity, read from the state message, and the "room"
+// label the device's suggested area.  Discovery messages are always
+// retained so HA picks them up whenever it (re)starts.
+func PublishDiscovery(vars map[string]string, dcfg DiscoveryConfig, cfg PublishConfig, pub Publisher) error {
+	nodeID := discoveryNodeID(cfg.UPSName)
+	device := discoveryDevice{
+		Identifiers:   []string{nodeID},
+		Name:          cfg.UPSName,
+		Manufacturer:  firstNonEmpty(vars["device.mfr"], vars["ups.mfr"]),
+		Model:         firstNonEmpty(vars["device.model"], vars["ups.model"]),
+		SuggestedArea: cfg.Labels["room"],
+	}
+
+	availability := availabilityTemplate
+	if cfg.RetainTTL > 0 {
+		availability = expiringAvailabilityTemplate
+	}
+
+	for _, e := range discoveryEntities {
+		if e.raw && !cfg.publishes(e.key) {
+			continue
+		}
+		objectID := strings.ReplaceAll(e.key, ".", "_")
+		stateTopic := ComputedTopic(cfg.Prefix, cfg.UPSName, e.key)
+		if e.raw {
+			stateTopic = cfg.variableTopic(e.key)
+		}
+		p := discoveryPayload{
+			Name:              e.name,
+			UniqueID:          nodeID + "_" + objectID,
+			ObjectID:          nodeID + "_" + objectID,
+			StateTopic:        stateTopic,
+			DeviceClass:       e.deviceClass,
+			UnitOfMeasurement: e.unit,
+			StateClass:        e.stateClass,
+			Options:           e.options,
+			Device:            device,
+		}
+		if !e.alwaysAvailable {
+			p.AvailabilityTopic, p.AvailabilityTmpl = StateTopic(cfg.Prefix, cfg.UPSName), availability
+		}
+		if e.component == "binary_sensor" {
+			p.PayloadOn, p.PayloadOff = "true", "false"
+		}
+		if len(cfg.Labels) > 0 {
+			p.AttributesTopic = StateTopic(cfg.Prefix, cfg.UPSName)
+			p.AttributesTmpl = labelsTemplate
+		}
+		payload, err := json.Marshal(p)
+		if err != nil {
+			return fmt.Errorf("marshalling discovery for %s: %w", e.key, err)
+		}
+		if err := pub.Publish(Message{
+			Topic:    DiscoveryTopic(dcfg.Prefix, e.component, cfg.UPSName, objectID),
+			Payload:  string(payload),
+			Retained: true,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// discoveryNodeID derives a Home Assistant node ID from the UPS label; HA
+// only allows [a-zA-Z0-9_-] there.
+func discoveryNodeID(upsName string) string {
+	var b strings.Builder
+	b.WriteString("ups_mqtt_")
+	for _, r := range upsName {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
+
+func firstNonEmpty(vals ...string) string {
+	for _, v := range vals {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
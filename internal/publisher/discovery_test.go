@@ -0,0 +1,178 @@
+package publisher_test
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+)
+
+var discoveryCfg = publisher.DiscoveryConfig{Prefix: "homeassistant"}
+
+// ---- PublishCommunicationLost ---------------------------------------------
+
+func TestPublishCommunicationLost(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower", Retained: true}
+
+	if err := publisher.PublishCommunicationLost(true, cfg, fp); err != nil {
+		t.Fatalf("PublishCommunicationLost: %v", err)
+	}
+	msg, ok := fp.Find("ups/cyberpower/computed/communication_lost")
+	if !ok {
+		t.Fatal("computed/communication_lost not published")
+	}
+	if msg.Payload != "true" || !msg.Retained {
+		t.Errorf("msg = %+v, want retained \"true\"", msg)
+	}
+}
+
+// ---- PublishDiscovery ------------------------------------------------------
+
+func decodeDiscovery(t *testing.T, fp *publisher.FakePublisher, topic string) map[string]interface{} {
+	t.Helper()
+	msg, ok := fp.Find(topic)
+	if !ok {
+		t.Fatalf("%s not published", topic)
+	}
+	if !msg.Retained {
+		t.Errorf("%s should be retained", topic)
+	}
+	var p map[string]interface{}
+	if err := json.Unmarshal([]byte(msg.Payload), &p); err != nil {
+		t.Fatalf("invalid discovery JSON on %s: %v", topic, err)
+	}
+	return p
+}
+
+func TestPublishDiscovery_CommunicationLostIsProblemSensor(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower", Retained: false}
+
+	if err := publisher.PublishDiscovery(nil, discoveryCfg, cfg, fp); err != nil {
+		t.Fatalf("PublishDiscovery: %v", err)
+	}
+	p := decodeDiscovery(t, fp, "homeassistant/binary_sensor/ups_mqtt_cyberpower/communication_lost/config")
+	checks := map[string]string{
+		"device_class": "problem",
+		"state_topic":  "ups/cyberpower/computed/communication_lost",
+		"payload_on":   "true",
+		"payload_off":  "false",
+		"unique_id":    "ups_mqtt_cyberpower_communication_lost",
+	}
+	for k, want := range checks {
+		if p[k] != want {
+			t.Errorf("%s = %v, want %q", k, p[k], want)
+		}
+	}
+	// The stale-data offline state must not hide the sensor reporting it.
+	if _, ok := p["availability_topic"]; ok {
+		t.Errorf("availability_topic = %v, want none", p["availability_topic"])
+	}
+	p = decodeDiscovery(t, fp, "homeassistant/sensor/ups_mqtt_cyberpower/load_watts/config")
+	if p["availability_topic"] != "ups/cyberpower/state" {
+		t.Errorf("load_watts availability_topic = %v", p["availability_topic"])
+	}
+}
+
+func TestPublishDiscovery_RawVariableFollowsNamespacePrefix(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{
+		Prefix:            "ups",
+		UPSName:           "cyberpower",
+		NamespacePrefixes: map[string]string{"battery": "power/batt"},
+	}
+	if err := publisher.PublishDiscovery(nil, discoveryCfg, cfg, fp); err != nil {
+		t.Fatalf("PublishDiscovery: %v", err)
+	}
+	p := decodeDiscovery(t, fp, "homeassistant/sensor/ups_mqtt_cyberpower/battery_charge/config")
+	if p["state_topic"] != "power/batt/charge" {
+		t.Errorf("state_topic = %v, want power/batt/charge", p["state_topic"])
+	}
+	if p["unit_of_measurement"] != "%" || p["device_class"] != "battery" {
+		t.Errorf("battery.charge payload = %v", p)
+	}
+}
+
+func TestPublishDiscovery_PowerSourceIsEnum(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower"}
+	if err := publisher.PublishDiscovery(nil, discoveryCfg, cfg, fp); err != nil {
+		t.Fatalf("PublishDiscovery: %v", err)
+	}
+	p := decodeDiscovery(t, fp, "homeassistant/sensor/ups_mqtt_cyberpower/power_source/config")
+	if p["device_class"] != "enum" || p["state_topic"] != "ups/cyberpower/computed/power_source" {
+		t.Errorf("power_source payload = %v", p)
+	}
+	opts, _ := p["options"].([]interface{})
+	if len(opts) != 5 || opts[0] != "mains" {
+		t.Errorf("options = %v", p["options"])
+	}
+}
+
+func TestPublishDiscovery_DeviceInfo(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "office ups"}
+	vars := map[string]string{"ups.mfr": "CPS", "ups.model": "CP1500EPFCLCD"}
+
+	if err := publisher.PublishDiscovery(vars, discoveryCfg, cfg, fp); err != nil {
+		t.Fatalf("PublishDiscovery: %v", err)
+	}
+	p := decodeDiscovery(t, fp, "homeassistant/sensor/ups_mqtt_office_ups/load_watts/config")
+	dev, _ := p["device"].(map[string]interface{})
+	if dev["manufacturer"] != "CPS" || dev["model"] != "CP1500EPFCLCD" || dev["name"] != "office ups" {
+		t.Errorf("device = %v", dev)
+	}
+}
+
+func TestPublishDiscovery_Labels(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower", Labels: map[string]string{"room": "Server room", "rack": "4"}}
+	if err := publisher.PublishDiscovery(nil, discoveryCfg, cfg, fp); err != nil {
+		t.Fatalf("PublishDiscovery: %v", err)
+	}
+	p := decodeDiscovery(t, fp, "homeassistant/sensor/ups_mqtt_cyberpower/load_watts/config")
+	dev, _ := p["device"].(map[string]interface{})
+	if dev["suggested_area"] != "Server room" {
+		t.Errorf("suggested_area = %v", dev["suggested_area"])
+	}
+	if p["json_attributes_topic"] != "ups/cyberpower/state" || p["json_attributes_template"] == nil {
+		t.Errorf("attributes not wired to the state labels: %v", p)
+	}
+
+	fp = &publisher.FakePublisher{}
+	cfg.Labels = nil
+	if err := publisher.PublishDiscovery(nil, discoveryCfg, cfg, fp); err != nil {
+		t.Fatalf("PublishDiscovery: %v", err)
+	}
+	p = decodeDiscovery(t, fp, "homeassistant/sensor/ups_mqtt_cyberpower/load_watts/config")
+	if _, ok := p["json_attributes_topic"]; ok {
+		t.Error("json_attributes_topic set without labels")
+	}
+}
+
+func TestPublishDiscovery_RetainTTLChecksExpiry(t *testing.T) {
+	for _, ttl := range []time.Duration{0, time.Hour} {
+		fp := &publisher.FakePublisher{}
+		cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower", RetainTTL: ttl}
+		if err := publisher.PublishDiscovery(nil, discoveryCfg, cfg, fp); err != nil {
+			t.Fatalf("PublishDiscovery: %v", err)
+		}
+		p := decodeDiscovery(t, fp, "homeassistant/sensor/ups_mqtt_cyberpower/load_watts/config")
+		tmpl, _ := p["availability_template"].(string)
+		if got := strings.Contains(tmpl, "expires_at"); got != (ttl > 0) {
+			t.Errorf("retain_ttl %s: availability_template = %q", ttl, tmpl)
+		}
+	}
+}
+
+func TestPublishDiscovery_PublishError(t *testing.T) {
+	fp := &publisher.FakePublisher{PublishError: errors.New("broker down")}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower"}
+	if err := publisher.PublishDiscovery(nil, discoveryCfg, cfg, fp); err == nil {
+		t.Fatal("expected error")
+	}
+}
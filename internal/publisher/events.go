@@ -0,0 +1,90 @@
+package publisher
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// EventMessage is the JSON payload of the events topic.
+type EventMessage struct {
+	Event          string `json:"event"`
+	PreviousStatus string `json:"previous_status"`
+	Status         string `json:"status"`
+	Timestamp      string `json:"timestamp"`
+	Drill          bool   `json:"drill,omitempty"`
+}
+
+// EventsTopic returns the topic carrying status transition events for
+// automations.
+func EventsTopic(prefix, upsName string) string {
+	return fmt.Sprintf("%s/%s/events", prefix, upsName)
+}
+
+// PollCompleteTopic returns the topic marking the end of each poll cycle.
+func PollCompleteTopic(prefix, upsName string) string {
+	return fmt.Sprintf("%s/%s/poll_complete", prefix, upsName)
+}
+
+// PollComplete is the JSON payload of the poll_complete topic.  Topics is
+// how many messages the cycle published before it, CorrelationID names the
+// cycle.
+type PollComplete struct {
+	CorrelationID string `json:"correlation_id"`
+	Topics        int64  `json:"topics"`
+	Timestamp     string `json:"timestamp"`
+}
+
+// PublishPollComplete publishes the end-of-cycle marker.  It is never
+// retained: a consumer that connects mid-cycle waits for the next one.
+func PublishPollComplete(id string, topics int64, t time.Time, cfg PublishConfig, pub Publisher) error {
+	payload, err := json.Marshal(PollComplete{CorrelationID: id, Topics: topics, Timestamp: t.UTC().Format(time.RFC3339)})
+	if err != nil {
+		return fmt.Errorf("marshalling poll_complete: %w", err)
+	}
+	return pub.Publish(Message{
+		Topic:   PollCompleteTopic(cfg.Prefix, cfg.UPSName),
+		Payload: string(payload),
+	})
+}
+
+// DrillTopic returns the command topic that starts and stops outage
+// drills.
+func DrillTopic(prefix, upsName string) string {
+	return BridgeTopic(prefix, upsName) + "/drill"
+}
+
+// PublishEvent publishes one status transition.  Like notifications,
+// events are never retained: they describe a moment, not a state.
+func PublishEvent(event, prevStatus, status string, t time.Time, cfg PublishConfig, pub Publisher) error {
+	return publishEvent(EventMessage{
+		Event:          event,
+		PreviousStatus: prevStatus,
+		Status:         status,
+		Timestamp:      t.UTC().Format(time.RFC3339),
+	}, cfg, pub)
+}
+
+// PublishDrillEvent publishes a transition of a drill's scripted outage,
+// marked "drill": true so automations can tell it from the real thing.
+func PublishDrillEvent(event, prevStatus, status string, t time.Time, cfg PublishConfig, pub Publisher) error {
+	return publishEvent(EventMessage{
+		Event:          event,
+		PreviousStatus: prevStatus,
+		Status:         status,
+		Timestamp:      t.UTC().Format(time.RFC3339),
+		Drill:          true,
+	}, cfg, pub)
+}
+
+func publishEvent(msg EventMessage, cfg PublishConfig, pub Publisher) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("marshalling event: %w", err)
+	}
+	return pub.Publish(Message{
+		Topic:    EventsTopic(cfg.Prefix, cfg.UPSName),
+		Payload:  string(payload),
+		Retained: false,
+	})
+}
@@ -0,0 +1,48 @@
+package publisher_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+)
+
+func TestPublishEvent(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower", Retained: true}
+	at := time.Date(2026, 3, 1, 3, 12, 0, 0, time.UTC)
+	if err := publisher.PublishEvent("power_lost", "OL", "OB DISCHRG", at, cfg, fp); err != nil {
+		t.Fatalf("PublishEvent: %v", err)
+	}
+	msg, ok := fp.Find("ups/cyberpower/events")
+	if !ok {
+		t.Fatal("events topic not published")
+	}
+	want := `{"event":"power_lost","previous_status":"OL","status":"OB DISCHRG","timestamp":"2026-03-01T03:12:00Z"}`
+	if msg.Payload != want {
+		t.Errorf("payload = %s\nwant      %s", msg.Payload, want)
+	}
+	if msg.Retained {
+		t.Error("events must not be retained")
+	}
+}
+
+func TestPublishPollComplete(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower", Retained: true}
+	at := time.Date(2026, 3, 1, 3, 12, 0, 0, time.UTC)
+	if err := publisher.PublishPollComplete("1772334720-7", 42, at, cfg, fp); err != nil {
+		t.Fatalf("PublishPollComplete: %v", err)
+	}
+	msg, ok := fp.Find("ups/cyberpower/poll_complete")
+	if !ok {
+		t.Fatal("poll_complete topic not published")
+	}
+	want := `{"correlation_id":"1772334720-7","topics":42,"timestamp":"2026-03-01T03:12:00Z"}`
+	if msg.Payload != want {
+		t.Errorf("payload = %s\nwant      %s", msg.Payload, want)
+	}
+	if msg.Retained {
+		t.Error("poll_complete must not be retained")
+	}
+}
@@ -0,0 +1,110 @@
+package publisher
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ExportRetained subscribes to filters and collects the retained messages the
+// broker replays, stopping once none has arrived for quiet.  Live updates
+// that arrive meanwhile are ignored, so the result is the retained tree as
+// the broker holds it, one message per topic, sorted by topic.
+func ExportRetained(s Subscriber, filters []string, quiet time.Duration) ([]Message, error) {
+	var mu sync.Mutex
+	got := make(map[string]Message)
+	arrived := make(chan struct{}, 1)
+	handler := func(msg Message) {
+		if !msg.Retained {
+			return
+		}
+		mu.Lock()
+		got[msg.Topic] = msg
+		mu.Unlock()
+		select {
+		case arrived <- struct{}{}:
+		default:
+		}
+	}
+
+	for i, f := range filters {
+		if err := s.Subscribe(f, handler); err != nil {
+			for _, done := range filters[:i] {
+				s.Unsubscribe(done) //nolint:errcheck
+			}
+			return nil, fmt.Errorf("subscribing to %s: %w", f, err)
+		}
+	}
+	defer func() {
+		for _, f := range filters {
+			s.Unsubscribe(f) //nolint:errcheck
+		}
+	}()
+
+	timer := time.NewTimer(quiet)
+	defer timer.Stop()
+wait:
+	for {
+		select {
+		case <-arrived:
+			timer.Reset(quiet)
+		case <-timer.C:
+			break wait
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	msgs := make([]Message, 0, len(got))
+	for _, msg := range got {
+		msgs = append(msgs, msg)
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].Topic < msgs[j].Topic })
+	return msgs, nil
+}
+
+// WriteExport writes msgs to w one JSON object per line, in the format
+// FileSink uses, so a file sink's output can be imported too.
+func WriteExport(w io.Writer, msgs []Message) error {
+	for _, msg := range msgs {
+		line, err := newSinkRecord(msg)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadExport parses what WriteExport (or a FileSink) wrote.  Blank lines
+// are skipped.
+func ReadExport(r io.Reader) ([]Message, error) {
+	var msgs []Message
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		var rec sinkRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		if rec.Topic == "" {
+			return nil, fmt.Errorf("line %d: no topic", n)
+		}
+		msgs = append(msgs, Message{Topic: rec.Topic, Payload: rec.Payload, Retained: rec.Retained})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return msgs, nil
+}
@@ -0,0 +1,91 @@
+package publisher_test
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+)
+
+// retainedBroker replays its retained messages to each new subscription, as
+// an MQTT broker does, and also sends a live one to check it is ignored.
+type retainedBroker struct {
+	publisher.FakePublisher
+	retained []publisher.Message
+}
+
+func (b *retainedBroker) Subscribe(topic string, handler func(publisher.Message)) error {
+	if err := b.FakePublisher.Subscribe(topic, handler); err != nil {
+		return err
+	}
+	for _, msg := range b.retained {
+		if publisher.TopicMatches(topic, msg.Topic) {
+			handler(msg)
+		}
+	}
+	handler(publisher.Message{Topic: topic + "/live", Payload: "x"})
+	return nil
+}
+
+func TestExportRetained(t *testing.T) {
+	b := &retainedBroker{retained: []publisher.Message{
+		{Topic: "ups/cyberpower/state", Payload: `{"online":true}`, Retained: true},
+		{Topic: "ups/cyberpower/battery/charge", Payload: "100", Retained: true},
+		{Topic: "other/thing", Payload: "1", Retained: true},
+	}}
+	msgs, err := publisher.ExportRetained(b, []string{"ups/cyberpower/#"}, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("ExportRetained: %v", err)
+	}
+	want := []publisher.Message{
+		{Topic: "ups/cyberpower/battery/charge", Payload: "100", Retained: true},
+		{Topic: "ups/cyberpower/state", Payload: `{"online":true}`, Retained: true},
+	}
+	if !reflect.DeepEqual(msgs, want) {
+		t.Errorf("got %+v, want %+v", msgs, want)
+	}
+	if len(b.Subscriptions) != 0 {
+		t.Errorf("subscriptions left behind: %v", b.Subscriptions)
+	}
+}
+
+func TestExportRetained_SubscribeError(t *testing.T) {
+	b := &retainedBroker{}
+	b.SubscribeError = errors.New("not authorised")
+	if _, err := publisher.ExportRetained(b, []string{"ups/#"}, time.Millisecond); err == nil || !strings.Contains(err.Error(), "ups/#") {
+		t.Errorf("err = %v, want the filter named", err)
+	}
+}
+
+func TestExport_RoundTrip(t *testing.T) {
+	msgs := []publisher.Message{
+		{Topic: "ups/cyberpower/battery/charge", Payload: "100", Retained: true},
+		{Topic: "ups/cyberpower/state", Payload: "{\"a\":\"b\"}\n", Retained: true},
+	}
+	var buf bytes.Buffer
+	if err := publisher.WriteExport(&buf, msgs); err != nil {
+		t.Fatalf("WriteExport: %v", err)
+	}
+	got, err := publisher.ReadExport(strings.NewReader(buf.String() + "\n"))
+	if err != nil {
+		t.Fatalf("ReadExport: %v", err)
+	}
+	if !reflect.DeepEqual(got, msgs) {
+		t.Errorf("got %+v, want %+v", got, msgs)
+	}
+}
+
+func TestReadExport_Invalid(t *testing.T) {
+	for name, body := range map[string]string{
+		"not json": "{\"topic\":\"a\"}\nnope\n",
+		"no topic": "{\"payload\":\"1\"}\n",
+	} {
+		if _, err := publisher.ReadExport(strings.NewReader(body)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -1,22 +1,69 @@
 package publisher
 
+import "sync"
+
 // FakePublisher records every published Message so tests can inspect them.
+// It also acts as a loopback broker: published messages are delivered to any
+// matching subscription, and Deliver injects messages from "elsewhere".
 type FakePublisher struct {
-	Messages     []Message
-	PublishError error
-	Closed       bool
+	Messages       []Message
+	PublishError   error
+	SubscribeError error
+	Closed         bool
+	Subscriptions  map[string]func(Message)
+
+	mu sync.Mutex // guards Subscriptions for handlers running on other goroutines
 }
 
-// Publish appends the message to the recorded list, or returns PublishError
-// if set.
+// Publish appends the message to the recorded list and delivers it to
+// matching subscriptions, or returns PublishError if set.
 func (f *FakePublisher) Publish(msg Message) error {
 	if f.PublishError != nil {
 		return f.PublishError
 	}
 	f.Messages = append(f.Messages, msg)
+	f.Deliver(msg)
+	return nil
+}
+
+// Subscribe records handler for topic, or returns SubscribeError if set.
+func (f *FakePublisher) Subscribe(topic string, handler func(Message)) error {
+	if f.SubscribeError != nil {
+		return f.SubscribeError
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Subscriptions == nil {
+		f.Subscriptions = make(map[string]func(Message))
+	}
+	f.Subscriptions[topic] = handler
 	return nil
 }
 
+// Unsubscribe removes the subscription for topic.
+func (f *FakePublisher) Unsubscribe(topic string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.Subscriptions, topic)
+	return nil
+}
+
+// Deliver calls every subscription whose filter matches msg.Topic, as if the
+// broker had routed msg to this client.  It is not recorded in Messages.
+func (f *FakePublisher) Deliver(msg Message) {
+	f.mu.Lock()
+	var handlers []func(Message)
+	for filter, handler := range f.Subscriptions {
+		if TopicMatches(filter, msg.Topic) {
+			handlers = append(handlers, handler)
+		}
+	}
+	f.mu.Unlock()
+	for _, h := range handlers {
+		h(msg)
+	}
+}
+
 // Close marks the publisher as closed.
 func (f *FakePublisher) Close() error {
 	f.Closed = true
@@ -37,5 +84,7 @@ func (f *FakePublisher) Find(topic string) (Message, bool) {
 func (f *FakePublisher) Reset() {
 	f.Messages = nil
 	f.PublishError = nil
+	f.SubscribeError = nil
 	f.Closed = false
+	f.Subscriptions = nil
 }
@@ -0,0 +1,63 @@
+package publisher
+
+import (
+	"errors"
+	"sync"
+)
+
+// FanoutPublisher publishes every message to each of several publishers,
+// one per broker, for mqtt.broker_mode "fanout".  Brokers may join later,
+// as their connections come up.  A publish succeeds as long as one broker
+// took it, so a broker that is down doesn't fail the poll.
+type FanoutPublisher struct {
+	mu      sync.Mutex
+	targets []Publisher
+	closed  bool
+}
+
+// NewFanoutPublisher returns a FanoutPublisher publishing to targets.
+func NewFanoutPublisher(targets ...Publisher) *FanoutPublisher {
+	return &FanoutPublisher{targets: targets}
+}
+
+// Add starts publishing to p as well.  After Close, p is closed instead.
+func (f *FanoutPublisher) Add(p Publisher) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		p.Close() //nolint:errcheck
+		return
+	}
+	f.targets = append(f.targets, p)
+}
+
+// Publish sends msg to every target.  It fails only when all of them did,
+// with their errors joined.
+func (f *FanoutPublisher) Publish(msg Message) error {
+	f.mu.Lock()
+	targets := f.targets
+	f.mu.Unlock()
+	var errs []error
+	for _, p := range targets {
+		if err := p.Publish(msg); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) < len(targets) {
+		return nil
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes every target.
+func (f *FanoutPublisher) Close() error {
+	f.mu.Lock()
+	targets := f.targets
+	f.targets, f.closed = nil, true
+	f.mu.Unlock()
+	var errs []error
+	for _, p := range targets {
+		errs = append(errs, p.Close())
+	}
+	return errors.Join(errs...)
+}
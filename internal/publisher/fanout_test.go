@@ -0,0 +1,42 @@
+package publisher_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+)
+
+func TestFanoutPublisher(t *testing.T) {
+	a, b := &publisher.FakePublisher{}, &publisher.FakePublisher{}
+	fan := publisher.NewFanoutPublisher(a)
+	fan.Add(b)
+
+	msg := publisher.Message{Topic: "ups/cyberpower/state", Payload: "{}", Retained: true}
+	if err := fan.Publish(msg); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	for i, fp := range []*publisher.FakePublisher{a, b} {
+		if got, ok := fp.Find("ups/cyberpower/state"); !ok || got != msg {
+			t.Errorf("broker %d got %+v, want %+v", i, got, msg)
+		}
+	}
+
+	a.PublishError = errors.New("not connected")
+	if err := fan.Publish(msg); err != nil {
+		t.Errorf("Publish with one broker down = %v, want nil", err)
+	}
+	b.PublishError = errors.New("not connected")
+	if err := fan.Publish(msg); err == nil {
+		t.Error("Publish with every broker down should fail")
+	}
+
+	if err := fan.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	late := &publisher.FakePublisher{}
+	fan.Add(late)
+	if !a.Closed || !b.Closed || !late.Closed {
+		t.Errorf("closed = %v, %v, %v; want every broker closed, including one added after Close", a.Closed, b.Closed, late.Closed)
+	}
+}
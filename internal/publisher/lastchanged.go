@@ -0,0 +1,90 @@
+package publisher
+
+import (
+	"path"
+	"sort"
+	"time"
+)
+
+// lastChangedSuffix is appended to a variable topic to form its companion
+// topic carrying the time the value last changed.
+const lastChangedSuffix = "$last_changed"
+
+// ChangeTracker remembers the previous value of every NUT variable so callers
+// can tell which ones actually changed between polls.  It is not safe for
+// concurrent use.
+type ChangeTracker struct {
+	values map[string]string
+}
+
+// NewChangeTracker returns an empty tracker; every variable seen on the first
+// call to Changed is reported as changed.
+func NewChangeTracker() *ChangeTracker {
+	return &ChangeTracker{values: make(map[string]string)}
+}
+
+// Changed records vars as the latest values and returns, sorted, the names
+// whose value differs from the previous call (including names not seen
+// before).  Variables that disappear from vars are forgotten, so they are
+// reported as changed again if they come back.
+func (t *ChangeTracker) Changed(vars map[string]string) []string {
+	var changed []string
+	for name, value := range vars {
+		if prev, ok := t.values[name]; !ok || prev != value {
+			changed = append(changed, name)
+		}
+	}
+	for name := range t.values {
+		if _, ok := vars[name]; !ok {
+			delete(t.values, name)
+		}
+	}
+	for _, name := range changed {
+		t.values[name] = vars[name]
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// LastChangedTopic returns the companion topic for a variable's last-change
+// timestamp in the default layout, e.g. ups/office-ups/battery/charge/$last_changed.
+// Variables routed elsewhere by PublishConfig.NamespacePrefixes get their
+// companion next to the routed topic instead.
+func LastChangedTopic(prefix, upsName, name string) string {
+	return VariableTopic(prefix, upsName, name) + "/" + lastChangedSuffix
+}
+
+// PublishLastChanged publishes changedAt (RFC 3339) to the $last_changed
+// companion topic of every name in changed that matches cfg.LastChanged.
+// The messages are always retained: they are only sent when a value moves,
+// so a subscriber arriving later would otherwise never learn the timestamp.
+func PublishLastChanged(changed []string, changedAt time.Time, cfg PublishConfig, pub Publisher) error {
+	if len(cfg.LastChanged) == 0 {
+		return nil
+	}
+	stamp := changedAt.UTC().Format(time.RFC3339)
+	for _, name := range changed {
+		if !matchesAny(cfg.LastChanged, name) || !cfg.publishes(name) {
+			continue
+		}
+		if err := pub.Publish(Message{
+			Topic:    cfg.variableTopic(name) + "/" + lastChangedSuffix,
+			Payload:  stamp,
+			Retained: true,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchesAny reports whether name matches at least one path.Match pattern.
+// Malformed patterns never match.
+func matchesAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, err := path.Match(p, name); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package publisher_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+)
+
+// ---- ChangeTracker --------------------------------------------------------
+
+func TestChangeTracker_FirstCallReportsAll(t *testing.T) {
+	ct := publisher.NewChangeTracker()
+	got := ct.Changed(map[string]string{"ups.status": "OL", "battery.charge": "100"})
+	want := []string{"battery.charge", "ups.status"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Changed = %v, want %v", got, want)
+	}
+}
+
+func TestChangeTracker_OnlyChangedValues(t *testing.T) {
+	ct := publisher.NewChangeTracker()
+	ct.Changed(map[string]string{"ups.status": "OL", "battery.charge": "100"})
+
+	got := ct.Changed(map[string]string{"ups.status": "OB DISCHRG", "battery.charge": "100"})
+	if !reflect.DeepEqual(got, []string{"ups.status"}) {
+		t.Errorf("Changed = %v, want [ups.status]", got)
+	}
+	if got := ct.Changed(map[string]string{"ups.status": "OB DISCHRG", "battery.charge": "100"}); len(got) != 0 {
+		t.Errorf("Changed = %v on identical poll, want none", got)
+	}
+}
+
+func TestChangeTracker_VanishedVariableReappears(t *testing.T) {
+	ct := publisher.NewChangeTracker()
+	ct.Changed(map[string]string{"input.voltage": "242"})
+	ct.Changed(map[string]string{})
+
+	got := ct.Changed(map[string]string{"input.voltage": "242"})
+	if !reflect.DeepEqual(got, []string{"input.voltage"}) {
+		t.Errorf("Changed = %v, want [input.voltage] after variable reappeared", got)
+	}
+}
+
+// ---- PublishLastChanged ---------------------------------------------------
+
+func TestLastChangedTopic(t *testing.T) {
+	got := publisher.LastChangedTopic("home", "myups", "battery.charge")
+	if got != "home/myups/battery/charge/$last_changed" {
+		t.Errorf("LastChangedTopic = %q", got)
+	}
+}
+
+func TestPublishLastChanged_FiltersAndRetains(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{
+		Prefix:      "ups",
+		UPSName:     "cyberpower",
+		LastChanged: []string{"battery.*", "ups.status"},
+	}
+	at := time.Date(2026, 2, 23, 16, 40, 28, 0, time.UTC)
+	changed := []string{"battery.charge", "input.voltage", "ups.status"}
+
+	if err := publisher.PublishLastChanged(changed, at, cfg, fp); err != nil {
+		t.Fatalf("PublishLastChanged: %v", err)
+	}
+	if len(fp.Messages) != 2 {
+		t.Fatalf("published %d messages, want 2: %+v", len(fp.Messages), fp.Messages)
+	}
+	msg, ok := fp.Find("ups/cyberpower/battery/charge/$last_changed")
+	if !ok {
+		t.Fatal("battery/charge/$last_changed not published")
+	}
+	if msg.Payload != "2026-02-23T16:40:28Z" {
+		t.Errorf("payload = %q, want RFC3339 timestamp", msg.Payload)
+	}
+	if !msg.Retained {
+		t.Error("$last_changed should always be retained")
+	}
+	if _, ok := fp.Find("ups/cyberpower/input/voltage/$last_changed"); ok {
+		t.Error("input.voltage does not match any pattern and should be skipped")
+	}
+}
+
+func TestPublishLastChanged_DisabledWhenNoPatterns(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower"}
+	if err := publisher.PublishLastChanged([]string{"ups.status"}, time.Now(), cfg, fp); err != nil {
+		t.Fatalf("PublishLastChanged: %v", err)
+	}
+	if len(fp.Messages) != 0 {
+		t.Errorf("published %d messages with no patterns configured, want 0", len(fp.Messages))
+	}
+}
+
+func TestPublishLastChanged_BadPatternNeverMatches(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower", LastChanged: []string{"["}}
+	if err := publisher.PublishLastChanged([]string{"ups.status"}, time.Now(), cfg, fp); err != nil {
+		t.Fatalf("PublishLastChanged: %v", err)
+	}
+	if len(fp.Messages) != 0 {
+		t.Error("malformed pattern should not match anything")
+	}
+}
+
+func TestPublishLastChanged_PublishError(t *testing.T) {
+	fp := &publisher.FakePublisher{PublishError: errors.New("broker down")}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower", LastChanged: []string{"*"}}
+	if err := publisher.PublishLastChanged([]string{"ups.status"}, time.Now(), cfg, fp); err == nil {
+		t.Fatal("expected error when publish fails")
+	}
+}
+
+func TestPublishLastChanged_FollowsNamespacePrefixes(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{
+		Prefix:            "ups",
+		UPSName:           "ups1",
+		LastChanged:       []string{"*"},
+		NamespacePrefixes: map[string]string{"battery": "power/ups1/battery"},
+	}
+	if err := publisher.PublishLastChanged([]string{"battery.charge"}, time.Now(), cfg, fp); err != nil {
+		t.Fatalf("PublishLastChanged: %v", err)
+	}
+	if _, ok := fp.Find("power/ups1/battery/charge/$last_changed"); !ok {
+		t.Error("$last_changed should sit next to the routed variable topic")
+	}
+}
@@ -0,0 +1,39 @@
+package publisher
+
+import "strings"
+
+// MirrorPublisher wraps a Publisher and republishes every message whose
+// topic lies under From onto the same path under To.  It lets a deployment
+// publish its current and a new topic layout side by side while automations
+// are migrated from one to the other.
+type MirrorPublisher struct {
+	Publisher
+	From string // topic root being mirrored, e.g. "ups/cyberpower"
+	To   string // replacement root, e.g. "home/power/office-ups"
+}
+
+// NewMirrorPublisher returns pub wrapped so that topics under from are also
+// published under to.
+func NewMirrorPublisher(pub Publisher, from, to string) *MirrorPublisher {
+	return &MirrorPublisher{
+		Publisher: pub,
+		From:      strings.TrimSuffix(from, "/"),
+		To:        strings.TrimSuffix(to, "/"),
+	}
+}
+
+// Publish sends msg unchanged, then a copy on the mirrored topic if msg's
+// topic is under From.  The first error is returned; if the original publish
+// fails the mirror is not attempted.
+func (m *MirrorPublisher) Publish(msg Message) error {
+	if err := m.Publisher.Publish(msg); err != nil {
+		return err
+	}
+	rest, ok := strings.CutPrefix(msg.Topic, m.From)
+	if !ok || (rest != "" && rest[0] != '/') {
+		return nil
+	}
+	mirrored := msg
+	mirrored.Topic = m.To + rest
+	return m.Publisher.Publish(mirrored)
+}
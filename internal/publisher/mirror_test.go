@@ -0,0 +1,60 @@
+package publisher_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+)
+
+func TestMirrorPublisher_CopiesTopicsUnderRoot(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	mp := publisher.NewMirrorPublisher(fp, "ups/cyberpower/", "home/office-ups")
+
+	msg := publisher.Message{Topic: "ups/cyberpower/battery/charge", Payload: "100", Retained: true}
+	if err := mp.Publish(msg); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if _, ok := fp.Find("ups/cyberpower/battery/charge"); !ok {
+		t.Error("original topic not published")
+	}
+	got, ok := fp.Find("home/office-ups/battery/charge")
+	if !ok {
+		t.Fatal("mirrored topic not published")
+	}
+	if got.Payload != "100" || !got.Retained {
+		t.Errorf("mirrored msg = %+v, want payload and retain flag preserved", got)
+	}
+}
+
+func TestMirrorPublisher_OtherTopicsNotMirrored(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	mp := publisher.NewMirrorPublisher(fp, "ups/cyberpower", "home/office-ups")
+
+	for _, topic := range []string{"ups/cyberpower-2/state", "homeassistant/sensor/x/config"} {
+		if err := mp.Publish(publisher.Message{Topic: topic}); err != nil {
+			t.Fatalf("Publish(%s): %v", topic, err)
+		}
+	}
+	if len(fp.Messages) != 2 {
+		t.Errorf("got %d messages, want 2 (no mirrors)", len(fp.Messages))
+	}
+}
+
+func TestMirrorPublisher_ErrorStopsMirror(t *testing.T) {
+	fp := &publisher.FakePublisher{PublishError: errors.New("broker down")}
+	mp := publisher.NewMirrorPublisher(fp, "ups/cyberpower", "home/office-ups")
+	if err := mp.Publish(publisher.Message{Topic: "ups/cyberpower/state"}); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestMirrorPublisher_ClosePassesThrough(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	if err := publisher.NewMirrorPublisher(fp, "a", "b").Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if !fp.Closed {
+		t.Error("underlying publisher not closed")
+	}
+}
@@ -0,0 +1,54 @@
+package publisher
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/alerts"
+)
+
+// NotificationMessage is the JSON payload of the notify topic.
+type NotificationMessage struct {
+	Event     string `json:"event"`
+	Severity  string `json:"severity"`
+	Message   string `json:"message"`
+	Timestamp string `json:"timestamp"`
+	Drill     bool   `json:"drill,omitempty"`
+}
+
+// NotifyTopic returns the topic carrying one-off notifications meant for
+// people: status events and alert transitions.
+func NotifyTopic(prefix, upsName string) string {
+	return fmt.Sprintf("%s/%s/notify", prefix, upsName)
+}
+
+// AlertEvent turns an alert transition into a notification event; clearing
+// an alert is reported as "{name}_cleared".
+func AlertEvent(a alerts.Alert) alerts.Event {
+	if a.Active {
+		return alerts.Event{Name: a.Name, Severity: a.Severity, Message: a.Message}
+	}
+	return alerts.Event{Name: a.Name + "_cleared", Severity: a.Severity, Message: a.Message}
+}
+
+// PublishNotification publishes ev to the notify topic.  Notifications are
+// never retained: a subscriber connecting later should not be paged for
+// something that already happened.
+func PublishNotification(ev alerts.Event, t time.Time, cfg PublishConfig, pub Publisher) error {
+	payload, err := json.Marshal(NotificationMessage{
+		Event:     ev.Name,
+		Severity:  string(ev.Severity),
+		Message:   ev.Message,
+		Timestamp: t.UTC().Format(time.RFC3339),
+		Drill:     ev.Drill,
+	})
+	if err != nil {
+		return fmt.Errorf("marshalling notification: %w", err)
+	}
+	return pub.Publish(Message{
+		Topic:    NotifyTopic(cfg.Prefix, cfg.UPSName),
+		Payload:  string(payload),
+		Retained: false,
+	})
+}
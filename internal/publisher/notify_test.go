@@ -0,0 +1,41 @@
+package publisher_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/alerts"
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+)
+
+func TestPublishNotification(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower", Retained: true}
+	ev := alerts.Event{Name: "low_battery", Severity: alerts.SeverityCritical, Message: "UPS battery is low"}
+
+	if err := publisher.PublishNotification(ev, time.Date(2026, 3, 1, 3, 0, 0, 0, time.UTC), cfg, fp); err != nil {
+		t.Fatalf("PublishNotification: %v", err)
+	}
+	msg, ok := fp.Find("ups/cyberpower/notify")
+	if !ok {
+		t.Fatal("notify topic not published")
+	}
+	if msg.Retained {
+		t.Error("notifications must never be retained")
+	}
+	want := `{"event":"low_battery","severity":"critical","message":"UPS battery is low","timestamp":"2026-03-01T03:00:00Z"}`
+	if msg.Payload != want {
+		t.Errorf("payload = %s\nwant      %s", msg.Payload, want)
+	}
+}
+
+func TestAlertEvent(t *testing.T) {
+	a := alerts.Alert{Name: "redundancy", Severity: alerts.SeverityWarning, Active: true, Message: "1 client"}
+	if ev := publisher.AlertEvent(a); ev.Name != "redundancy" || ev.Severity != alerts.SeverityWarning || ev.Message != "1 client" {
+		t.Errorf("fired event = %+v", ev)
+	}
+	a.Active = false
+	if ev := publisher.AlertEvent(a); ev.Name != "redundancy_cleared" {
+		t.Errorf("cleared event = %+v", ev)
+	}
+}
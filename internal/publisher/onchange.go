@@ -0,0 +1,56 @@
+package publisher
+
+import "sync"
+
+// OnChangePublisher wraps a Publisher and drops retained messages whose
+// payload is the same as the last one published on their topic: the broker
+// already holds that value, so on a quiet UPS most of each poll's writes
+// can be skipped.  Non-retained messages always go through.
+type OnChangePublisher struct {
+	Publisher
+
+	mu   sync.Mutex
+	last map[string]string
+}
+
+// NewOnChangePublisher returns pub wrapped to publish retained topics only
+// when their payload changes.
+func NewOnChangePublisher(pub Publisher) *OnChangePublisher {
+	return &OnChangePublisher{Publisher: pub, last: make(map[string]string)}
+}
+
+// Publish sends msg unless it is retained and unchanged.  A payload only
+// counts as published once the wrapped Publisher accepted it, so a failed
+// publish is retried on the next poll.
+func (p *OnChangePublisher) Publish(msg Message) error {
+	if msg.Retained {
+		p.mu.Lock()
+		last, seen := p.last[msg.Topic]
+		p.mu.Unlock()
+		if seen && last == msg.Payload {
+			return nil
+		}
+	}
+	if err := p.Publisher.Publish(msg); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if msg.Retained {
+		p.last[msg.Topic] = msg.Payload
+	} else {
+		// A non-retained message replaces nothing the broker holds, but
+		// the next retained one must not be compared with stale history.
+		delete(p.last, msg.Topic)
+	}
+	return nil
+}
+
+// Reset forgets every published payload, so the next poll publishes
+// everything again, e.g. after reconnecting to a broker that may have lost
+// its retained messages.
+func (p *OnChangePublisher) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.last = make(map[string]string)
+}
@@ -0,0 +1,58 @@
+package publisher_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+)
+
+func TestOnChangePublisher(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	p := publisher.NewOnChangePublisher(fp)
+	for _, msg := range []publisher.Message{
+		{Topic: "ups/cyberpower/battery/charge", Payload: "100", Retained: true},
+		{Topic: "ups/cyberpower/battery/charge", Payload: "100", Retained: true}, // unchanged: dropped
+		{Topic: "ups/cyberpower/battery/charge", Payload: "99", Retained: true},
+		{Topic: "ups/cyberpower/notify", Payload: "{}"},
+		{Topic: "ups/cyberpower/notify", Payload: "{}"}, // not retained: always sent
+	} {
+		if err := p.Publish(msg); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	if len(fp.Messages) != 4 {
+		t.Errorf("published %d messages, want 4: %+v", len(fp.Messages), fp.Messages)
+	}
+
+	p.Reset()
+	p.Publish(publisher.Message{Topic: "ups/cyberpower/battery/charge", Payload: "99", Retained: true}) //nolint:errcheck
+	if len(fp.Messages) != 5 {
+		t.Error("Reset should make the next message go out again")
+	}
+}
+
+func TestOnChangePublisher_FailedPublishRetried(t *testing.T) {
+	fp := &publisher.FakePublisher{PublishError: errors.New("offline")}
+	p := publisher.NewOnChangePublisher(fp)
+	msg := publisher.Message{Topic: "ups/cyberpower/ups/load", Payload: "12", Retained: true}
+	if err := p.Publish(msg); err == nil {
+		t.Fatal("expected the wrapped error")
+	}
+	fp.PublishError = nil
+	p.Publish(msg) //nolint:errcheck
+	if len(fp.Messages) != 1 {
+		t.Error("a payload that failed to publish should be sent again")
+	}
+}
+
+func TestOnChangePublisher_RetainFlagChange(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	p := publisher.NewOnChangePublisher(fp)
+	for _, retained := range []bool{true, false, true} {
+		p.Publish(publisher.Message{Topic: "ups/cyberpower/ups/status", Payload: "OL", Retained: retained}) //nolint:errcheck
+	}
+	if len(fp.Messages) != 3 {
+		t.Errorf("published %d messages, want 3", len(fp.Messages))
+	}
+}
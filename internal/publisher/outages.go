@@ -0,0 +1,27 @@
+package publisher
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/sweeney/ups-mqtt/internal/outages"
+)
+
+// OutageStatsTopic returns the topic carrying the outage statistics.
+func OutageStatsTopic(prefix, upsName string) string {
+	return fmt.Sprintf("%s/%s/outages/stats", prefix, upsName)
+}
+
+// PublishOutageStats publishes s as JSON to the outage statistics topic,
+// retained when cfg.Retained is.
+func PublishOutageStats(s outages.Stats, cfg PublishConfig, pub Publisher) error {
+	payload, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("marshalling outage stats: %w", err)
+	}
+	return pub.Publish(Message{
+		Topic:    OutageStatsTopic(cfg.Prefix, cfg.UPSName),
+		Payload:  string(payload),
+		Retained: cfg.Retained,
+	})
+}
@@ -0,0 +1,29 @@
+package publisher_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/outages"
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+)
+
+func TestPublishOutageStats(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower", Retained: true}
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	h := outages.History{Outages: []outages.Outage{{Start: start, End: start.Add(90 * time.Second)}}}
+
+	if err := publisher.PublishOutageStats(h.Stats(time.UTC), cfg, fp); err != nil {
+		t.Fatalf("PublishOutageStats: %v", err)
+	}
+	msg, ok := fp.Find("ups/cyberpower/outages/stats")
+	if !ok || !msg.Retained {
+		t.Fatalf("stats = %+v, want them published retained", msg)
+	}
+	want := `{"since":"2026-03-01T12:00:00Z","outages":1,"per_month":{"2026-03":1},"longest_secs":90,`
+	if !strings.HasPrefix(msg.Payload, want) {
+		t.Errorf("payload = %s\nwant prefix %s", msg.Payload, want)
+	}
+}
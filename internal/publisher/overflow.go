@@ -0,0 +1,171 @@
+package publisher
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// State overflow strategies for PublishConfig.StateOverflow.
+const (
+	// OverflowDropDriver leaves out driver.* variables first, then other
+	// variables as for OverflowTruncate.
+	OverflowDropDriver = "drop_driver"
+	// OverflowSplit moves the variables to numbered state/part/N topics.
+	OverflowSplit = "split"
+	// OverflowTruncate leaves out variables, keeping essentialVars first
+	// and the rest in name order, until the message fits.
+	OverflowTruncate = "truncate"
+)
+
+// essentialVars are kept in a truncated state message before any others.
+var essentialVars = []string{
+	"ups.status", "battery.charge", "battery.runtime", "ups.load",
+	"ups.realpower", "ups.realpower.nominal", "input.voltage", "output.voltage",
+}
+
+// StatePart is one chunk of the variables of a split state message,
+// published to StatePartTopic.
+type StatePart struct {
+	Timestamp string            `json:"timestamp"`
+	UPSName   string            `json:"ups_name"`
+	Part      int               `json:"part"`
+	Parts     int               `json:"parts"`
+	Variables map[string]string `json:"variables"`
+}
+
+// StatePartTopic returns the topic of part n (from 1) of a split state
+// message.
+func StatePartTopic(prefix, upsName string, n int) string {
+	return fmt.Sprintf("%s/%s/state/part/%d", prefix, upsName, n)
+}
+
+// shrinkState returns state marshalled with as many variables as fit in
+// cfg.MaxStateBytes, in the priority order of cfg.StateOverflow.  If even
+// none fit, the message is returned without variables.
+func shrinkState(state StateMessage, cfg PublishConfig) ([]byte, error) {
+	order := keepOrder(state.Variables, cfg.StateOverflow == OverflowDropDriver)
+	all := state.Variables
+	marshalFirst := func(n int) ([]byte, error) {
+		state.Variables = make(map[string]string, n)
+		for _, name := range order[:n] {
+			state.Variables[name] = all[name]
+		}
+		state.Truncated, state.OmittedVariables = true, len(order)-n
+		return json.Marshal(state)
+	}
+	// Binary search for the largest prefix of order that fits.
+	lo, hi := 0, len(order)-1
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		payload, err := marshalFirst(mid)
+		if err != nil {
+			return nil, fmt.Errorf("marshalling state: %w", err)
+		}
+		if len(payload) <= cfg.MaxStateBytes {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	payload, err := marshalFirst(lo)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling state: %w", err)
+	}
+	return payload, nil
+}
+
+// keepOrder returns the names in vars in the order they are kept when
+// truncating: essentialVars, then the rest by name, with driver.* last
+// when dropDriver is set.
+func keepOrder(vars map[string]string, dropDriver bool) []string {
+	rank := func(name string) int {
+		for i, e := range essentialVars {
+			if name == e {
+				return i
+			}
+		}
+		if dropDriver && strings.HasPrefix(name, "driver.") {
+			return len(essentialVars) + 1
+		}
+		return len(essentialVars)
+	}
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		ri, rj := rank(names[i]), rank(names[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// publishSplitState publishes state without its variables, which go to as
+// many StatePart messages of at most cfg.MaxStateBytes as needed (a single
+// variable too large on its own gets a part of its own regardless).
+// Higher-numbered parts left over from an earlier, larger split are not
+// cleared; consumers should read parts 1 to Parts only.
+func publishSplitState(state StateMessage, cfg PublishConfig, pub Publisher) error {
+	names := make([]string, 0, len(state.Variables))
+	for name := range state.Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Pack variables greedily; part numbers are filled in afterwards, so
+	// leave room for them in the size check.
+	var chunks []map[string]string
+	current := map[string]string{}
+	for _, name := range names {
+		current[name] = state.Variables[name]
+		if len(current) > 1 {
+			payload, err := json.Marshal(StatePart{Timestamp: state.Timestamp, UPSName: state.UPSName, Part: 9999, Parts: 9999, Variables: current})
+			if err != nil {
+				return fmt.Errorf("marshalling state part: %w", err)
+			}
+			if len(payload) > cfg.MaxStateBytes {
+				delete(current, name)
+				chunks = append(chunks, current)
+				current = map[string]string{name: state.Variables[name]}
+			}
+		}
+	}
+	chunks = append(chunks, current)
+
+	for i, chunk := range chunks {
+		payload, err := json.Marshal(StatePart{
+			Timestamp: state.Timestamp,
+			UPSName:   state.UPSName,
+			Part:      i + 1,
+			Parts:     len(chunks),
+			Variables: chunk,
+		})
+		if err != nil {
+			return fmt.Errorf("marshalling state part: %w", err)
+		}
+		if err := pub.Publish(Message{
+			Topic:    StatePartTopic(cfg.Prefix, cfg.UPSName, i+1),
+			Payload:  string(payload),
+			Retained: cfg.Retained,
+		}); err != nil {
+			return err
+		}
+	}
+
+	state.Variables = map[string]string{}
+	state.Parts = len(chunks)
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("marshalling state: %w", err)
+	}
+	return pub.Publish(Message{
+		Topic:    StateTopic(cfg.Prefix, cfg.UPSName),
+		Payload:  string(payload),
+		Retained: cfg.Retained,
+	})
+}
@@ -0,0 +1,178 @@
+package publisher_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/metrics"
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+)
+
+// bigVars is a poll from a UPS with many driver and device variables.
+func bigVars() map[string]string {
+	vars := map[string]string{
+		"ups.status":     "OL",
+		"battery.charge": "100",
+		"ups.load":       "8",
+	}
+	for i := 0; i < 40; i++ {
+		vars[fmt.Sprintf("driver.parameter.p%02d", i)] = "some driver setting"
+		vars[fmt.Sprintf("outlet.%02d.desc", i)] = "Outlet description"
+	}
+	return vars
+}
+
+func decodeState(t *testing.T, fp *publisher.FakePublisher) publisher.StateMessage {
+	t.Helper()
+	msg, ok := fp.Find("ups/cyberpower/state")
+	if !ok {
+		t.Fatal("state not published")
+	}
+	var st publisher.StateMessage
+	if err := json.Unmarshal([]byte(msg.Payload), &st); err != nil {
+		t.Fatalf("state JSON: %v", err)
+	}
+	return st
+}
+
+func TestPublishState_UnderLimitUnchanged(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower", MaxStateBytes: 100000, StateOverflow: publisher.OverflowTruncate}
+	if err := publisher.PublishState(sampleVars, metrics.Compute(sampleVars), cfg, fp); err != nil {
+		t.Fatalf("PublishState: %v", err)
+	}
+	if st := decodeState(t, fp); st.Truncated || st.Parts != 0 || len(st.Variables) != len(sampleVars) {
+		t.Errorf("state = %+v, want untouched", st)
+	}
+}
+
+func TestPublishState_DropDriverFirst(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower", MaxStateBytes: 2000, StateOverflow: publisher.OverflowDropDriver}
+	vars := bigVars()
+	if err := publisher.PublishState(vars, metrics.Compute(vars), cfg, fp); err != nil {
+		t.Fatalf("PublishState: %v", err)
+	}
+	if n := len(fp.Messages[0].Payload); n > 2000 {
+		t.Errorf("payload is %d bytes, want ≤ 2000", n)
+	}
+	st := decodeState(t, fp)
+	if !st.Truncated || st.OmittedVariables != len(vars)-len(st.Variables) {
+		t.Errorf("truncated = %v, omitted = %d with %d of %d kept", st.Truncated, st.OmittedVariables, len(st.Variables), len(vars))
+	}
+	for name := range vars {
+		if _, kept := st.Variables[name]; !kept && !strings.HasPrefix(name, "driver.") {
+			t.Errorf("%s omitted while driver.* variables remain droppable", name)
+		}
+	}
+}
+
+func TestPublishState_TruncateKeepsEssentials(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower", MaxStateBytes: 500, StateOverflow: publisher.OverflowTruncate}
+	vars := bigVars()
+	if err := publisher.PublishState(vars, metrics.Compute(vars), cfg, fp); err != nil {
+		t.Fatalf("PublishState: %v", err)
+	}
+	if n := len(fp.Messages[0].Payload); n > 500 {
+		t.Errorf("payload is %d bytes, want ≤ 500", n)
+	}
+	st := decodeState(t, fp)
+	if !st.Truncated || st.Variables["ups.status"] != "OL" || st.Variables["ups.load"] != "8" {
+		t.Errorf("state = %+v, want truncated with essentials kept", st)
+	}
+}
+
+func TestPublishState_TruncateNothingFits(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower", MaxStateBytes: 10, StateOverflow: publisher.OverflowTruncate}
+	if err := publisher.PublishState(sampleVars, metrics.Compute(sampleVars), cfg, fp); err != nil {
+		t.Fatalf("PublishState: %v", err)
+	}
+	if st := decodeState(t, fp); len(st.Variables) != 0 || st.OmittedVariables != len(sampleVars) {
+		t.Errorf("state = %+v, want every variable omitted", st)
+	}
+}
+
+func TestPublishState_Split(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower", Retained: true, MaxStateBytes: 1000, StateOverflow: publisher.OverflowSplit}
+	vars := bigVars()
+	if err := publisher.PublishState(vars, metrics.Compute(vars), cfg, fp); err != nil {
+		t.Fatalf("PublishState: %v", err)
+	}
+	st := decodeState(t, fp)
+	if st.Parts < 2 || len(st.Variables) != 0 || st.Truncated {
+		t.Fatalf("state = %+v, want variables moved to parts", st)
+	}
+	got := map[string]string{}
+	for n := 1; n <= st.Parts; n++ {
+		msg, ok := fp.Find(publisher.StatePartTopic("ups", "cyberpower", n))
+		if !ok || !msg.Retained || len(msg.Payload) > 1000 {
+			t.Fatalf("part %d = %+v, want retained and ≤ 1000 bytes", n, msg)
+		}
+		var part publisher.StatePart
+		if err := json.Unmarshal([]byte(msg.Payload), &part); err != nil {
+			t.Fatalf("part %d JSON: %v", n, err)
+		}
+		if part.Part != n || part.Parts != st.Parts || part.Timestamp != st.Timestamp {
+			t.Errorf("part %d header = %+v", n, part)
+		}
+		for k, v := range part.Variables {
+			got[k] = v
+		}
+	}
+	if len(got) != len(vars) {
+		t.Errorf("parts carry %d variables, want %d", len(got), len(vars))
+	}
+	if msg := fp.Messages[len(fp.Messages)-1]; msg.Topic != "ups/cyberpower/state" {
+		t.Errorf("last message on %s, want the state topic after its parts", msg.Topic)
+	}
+}
+
+func TestPublishState_SplitPublishError(t *testing.T) {
+	fp := &publisher.FakePublisher{PublishError: errors.New("broker down")}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower", MaxStateBytes: 1000, StateOverflow: publisher.OverflowSplit}
+	vars := bigVars()
+	if err := publisher.PublishState(vars, metrics.Compute(vars), cfg, fp); err == nil {
+		t.Fatal("expected publish error")
+	}
+}
+
+func TestPublishState_Labels(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower", Labels: map[string]string{"site": "lon1"}}
+	if err := publisher.PublishState(sampleVars, metrics.Compute(sampleVars), cfg, fp); err != nil {
+		t.Fatalf("PublishState: %v", err)
+	}
+	if st := decodeState(t, fp); st.Labels["site"] != "lon1" {
+		t.Errorf("labels = %v", st.Labels)
+	}
+}
+
+func TestPublishState_RetainTTL(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower"}
+	if err := publisher.PublishState(sampleVars, metrics.Compute(sampleVars), cfg, fp); err != nil {
+		t.Fatalf("PublishState: %v", err)
+	}
+	if st := decodeState(t, fp); st.ExpiresAt != "" {
+		t.Errorf("expires_at = %q without retain_ttl", st.ExpiresAt)
+	}
+
+	fp = &publisher.FakePublisher{}
+	cfg.RetainTTL = 10 * time.Minute
+	if err := publisher.PublishState(sampleVars, metrics.Compute(sampleVars), cfg, fp); err != nil {
+		t.Fatalf("PublishState: %v", err)
+	}
+	st := decodeState(t, fp)
+	ts, err1 := time.Parse(time.RFC3339, st.Timestamp)
+	exp, err2 := time.Parse(time.RFC3339, st.ExpiresAt)
+	if err1 != nil || err2 != nil || exp.Sub(ts) != 10*time.Minute {
+		t.Errorf("timestamp %q, expires_at %q; want 10m apart", st.Timestamp, st.ExpiresAt)
+	}
+}
@@ -0,0 +1,51 @@
+package publisher
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// PrintPublisher writes each message to an io.Writer instead of a broker,
+// for dry runs: as "topic payload" lines, with " (retained)" after the
+// topic of retained messages, or with JSON set as one JSON object per line
+// in the file sink format, which import reads back.
+type PrintPublisher struct {
+	JSON bool
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewPrintPublisher returns a PrintPublisher writing to w.
+func NewPrintPublisher(w io.Writer, json bool) *PrintPublisher {
+	return &PrintPublisher{JSON: json, w: w}
+}
+
+// Publish writes msg.  It is safe for concurrent use, so several UPS
+// pipelines can share one.
+func (p *PrintPublisher) Publish(msg Message) error {
+	var line []byte
+	if p.JSON {
+		rec, err := newSinkRecord(msg)
+		if err != nil {
+			return err
+		}
+		line = append(rec, '\n')
+	} else {
+		retained := ""
+		if msg.Retained {
+			retained = " (retained)"
+		}
+		line = fmt.Appendf(nil, "%s%s %s\n", msg.Topic, retained, msg.Payload)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := p.w.Write(line)
+	return err
+}
+
+// Close is a no-op: the writer belongs to the caller.
+func (p *PrintPublisher) Close() error {
+	return nil
+}
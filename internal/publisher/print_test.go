@@ -0,0 +1,30 @@
+package publisher_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+)
+
+func TestPrintPublisher(t *testing.T) {
+	var out strings.Builder
+	p := publisher.NewPrintPublisher(&out, false)
+	p.Publish(publisher.Message{Topic: "ups/cyberpower/battery/charge", Payload: "100", Retained: true}) //nolint:errcheck
+	p.Publish(publisher.Message{Topic: "ups/cyberpower/events", Payload: `{"event":"power_lost"}`})      //nolint:errcheck
+	want := "ups/cyberpower/battery/charge (retained) 100\nups/cyberpower/events {\"event\":\"power_lost\"}\n"
+	if out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+
+	out.Reset()
+	p = publisher.NewPrintPublisher(&out, true)
+	msg := publisher.Message{Topic: "ups/cyberpower/state", Payload: `{"online":true}`, Retained: true}
+	if err := p.Publish(msg); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	msgs, err := publisher.ReadExport(strings.NewReader(out.String()))
+	if err != nil || len(msgs) != 1 || msgs[0] != msg {
+		t.Errorf("JSON output %q read back as %+v, %v", out.String(), msgs, err)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/sweeney/ups-mqtt/internal/metrics"
 )
@@ -25,12 +26,123 @@ type Publisher interface {
 	Close() error
 }
 
+// Subscriber is implemented by publishers that can also receive messages.
+// Topic filters may use the MQTT + and # wildcards.
+type Subscriber interface {
+	Subscribe(topic string, handler func(Message)) error
+	Unsubscribe(topic string) error
+}
+
+// PubSub is a connection that can both publish and subscribe.
+type PubSub interface {
+	Publisher
+	Subscriber
+}
+
+// TopicMatches reports whether topic matches the MQTT subscription filter,
+// honouring the single-level (+) and multi-level (#) wildcards.
+func TopicMatches(filter, topic string) bool {
+	fs := strings.Split(filter, "/")
+	ts := strings.Split(topic, "/")
+	for i, f := range fs {
+		if f == "#" {
+			return true
+		}
+		if i >= len(ts) || (f != "+" && f != ts[i]) {
+			return false
+		}
+	}
+	return len(fs) == len(ts)
+}
+
 // PublishConfig groups the MQTT routing parameters so callers don't need to
 // thread three separate arguments through every function.
 type PublishConfig struct {
 	Prefix   string
 	UPSName  string
 	Retained bool
+
+	// LastChanged holds variable name patterns that get a $last_changed
+	// companion topic; see PublishLastChanged.
+	LastChanged []string
+
+	// NonRetained holds variable name patterns whose topics are never
+	// retained, overriding Retained for those variables only.
+	NonRetained []string
+
+	// IncludeVars and ExcludeVars hold variable name patterns narrowing
+	// the variables published, as topics and in the state message: when
+	// IncludeVars is set only those matching it are, and those matching
+	// ExcludeVars never are.
+	IncludeVars []string
+	ExcludeVars []string
+
+	// NamespacePrefixes maps a variable namespace (e.g. "battery" or
+	// "driver.version") to the topic root that replaces
+	// {prefix}/{ups_name}/{namespace} for variables inside it.
+	NamespacePrefixes map[string]string
+
+	// MaxStateBytes caps the size of the state message; StateOverflow
+	// (one of the Overflow* constants) says how an oversized one is cut
+	// down.  Zero means no limit.
+	MaxStateBytes int
+	StateOverflow string
+
+	// Labels are site-specific tags such as site, rack or room, added to
+	// the state message and Home Assistant device info.
+	Labels map[string]string
+
+	// RetainTTL, when positive, stamps the state message with an expires_at
+	// time and makes Home Assistant treat a state message past it as
+	// offline.  No other topic is stamped.
+	RetainTTL time.Duration
+}
+
+// variableTopic returns the topic for NUT variable name, honouring
+// NamespacePrefixes.  The longest namespace that equals name or is a
+// dot-delimited prefix of it wins; the rest of the name is appended with
+// dots turned into slashes.
+func (c PublishConfig) variableTopic(name string) string {
+	best := ""
+	for ns := range c.NamespacePrefixes {
+		if len(ns) > len(best) && (name == ns || strings.HasPrefix(name, ns+".")) {
+			best = ns
+		}
+	}
+	if best == "" {
+		return VariableTopic(c.Prefix, c.UPSName, name)
+	}
+	root := strings.TrimSuffix(c.NamespacePrefixes[best], "/")
+	if rest := strings.TrimPrefix(name[len(best):], "."); rest != "" {
+		return root + "/" + strings.ReplaceAll(TopicLevel(rest), ".", "/")
+	}
+	return root
+}
+
+// retainedFor reports whether the topic for NUT variable name is retained.
+func (c PublishConfig) retainedFor(name string) bool {
+	return c.Retained && !matchesAny(c.NonRetained, name)
+}
+
+// publishes reports whether NUT variable name passes IncludeVars and
+// ExcludeVars.
+func (c PublishConfig) publishes(name string) bool {
+	return (len(c.IncludeVars) == 0 || matchesAny(c.IncludeVars, name)) && !matchesAny(c.ExcludeVars, name)
+}
+
+// filterVars returns the variables of vars that c publishes: vars itself
+// when no filter is set.
+func (c PublishConfig) filterVars(vars map[string]string) map[string]string {
+	if len(c.IncludeVars) == 0 && len(c.ExcludeVars) == 0 {
+		return vars
+	}
+	kept := make(map[string]string, len(vars))
+	for name, value := range vars {
+		if c.publishes(name) {
+			kept[name] = value
+		}
+	}
+	return kept
 }
 
 // StateMessage is the JSON payload for the combined state topic.
@@ -40,11 +152,29 @@ type StateMessage struct {
 	UPSName   string            `json:"ups_name"`
 	Variables map[string]string `json:"variables"`
 	Computed  metrics.Metrics   `json:"computed"`
+	Labels    map[string]string `json:"labels,omitempty"`
+
+	// ExpiresAt is when the message should be considered stale if nothing
+	// newer has replaced it; set when PublishConfig.RetainTTL is.
+	ExpiresAt string `json:"expires_at,omitempty"`
+
+	// Stale marks a reading from before the bridge started, published
+	// from its checkpoint until the first poll replaces it.
+	Stale bool `json:"stale,omitempty"`
+
+	// Set when the message was cut down to fit PublishConfig.MaxStateBytes:
+	// Truncated/OmittedVariables when variables were left out, Parts when
+	// they were moved to Parts state/part/N topics (see StatePart).
+	Truncated        bool `json:"truncated,omitempty"`
+	OmittedVariables int  `json:"omitted_variables,omitempty"`
+	Parts            int  `json:"parts,omitempty"`
 }
 
-// OnlineState is the LWT / online-announcement payload.
+// OnlineState is the LWT / online-announcement payload.  DataStale is set
+// when the state topic was downgraded because the driver's data is stale.
 type OnlineState struct {
 	Online    bool   `json:"online"`
+	DataStale bool   `json:"data_stale,omitempty"`
 	Timestamp string `json:"timestamp"`
 }
 
@@ -81,7 +211,13 @@ func PublishOutage(
 	cfg PublishConfig,
 	pub Publisher,
 ) error {
-	now := time.Now().UTC()
+	return PublishOutageAt(vars, m, outageStart, time.Now(), cfg, pub)
+}
+
+// PublishOutageAt is PublishOutage for a reading taken at now rather than
+// just now.
+func PublishOutageAt(vars map[string]string, m metrics.Metrics, outageStart, now time.Time, cfg PublishConfig, pub Publisher) error {
+	now = now.UTC()
 
 	var runtimeSecs, chargePct float64
 	if v, err := strconv.ParseFloat(vars["battery.runtime"], 64); err == nil {
@@ -128,8 +264,8 @@ func ClearOutage(cfg PublishConfig, pub Publisher) error {
 	})
 }
 
-// PublishAll publishes every NUT variable as an individual topic, every
-// computed metric under the "computed/" sub-tree, and the combined JSON
+// PublishAll publishes every NUT variable cfg doesn't filter out as an
+// individual topic, every computed metric under the "computed/" sub-tree, and the combined JSON
 // state topic.  It returns the first publish error encountered.
 func PublishAll(
 	vars map[string]string,
@@ -137,24 +273,97 @@ func PublishAll(
 	cfg PublishConfig,
 	pub Publisher,
 ) error {
-	// --- individual NUT variable topics ---
-	for name, value := range vars {
-		topic := fmt.Sprintf("%s/%s/%s", cfg.Prefix, cfg.UPSName, strings.ReplaceAll(name, ".", "/"))
-		if err := pub.Publish(Message{Topic: topic, Payload: value, Retained: cfg.Retained}); err != nil {
+	if err := PublishVariables(vars, cfg, pub); err != nil {
+		return err
+	}
+	if err := PublishMetrics(m, cfg, pub); err != nil {
+		return err
+	}
+	return PublishState(vars, m, cfg, pub)
+}
+
+// criticalMetrics are the computed topics PublishCritical sends ahead of
+// the rest.
+var criticalMetrics = []string{"on_battery", "low_battery", "power_source"}
+
+// PublishCritical publishes the topics automations react to on a power
+// event, in order: the state message (which also carries availability),
+// ups.status, and the on_battery, low_battery and power_source computed
+// topics, for a reading taken at now.  Callers use it ahead of the bulk of
+// a poll's publishes when the UPS goes on or off battery, so that on a slow
+// link those messages aren't queued behind a few hundred variable topics.
+func PublishCritical(vars map[string]string, m metrics.Metrics, now time.Time, cfg PublishConfig, pub Publisher) error {
+	if err := PublishStateAt(vars, m, now, cfg, pub); err != nil {
+		return err
+	}
+	if status, ok := vars["ups.status"]; ok {
+		if err := PublishVariables(map[string]string{"ups.status": status}, cfg, pub); err != nil {
+			return err
+		}
+	}
+	topics := m.AsTopicMap()
+	for _, name := range criticalMetrics {
+		if err := PublishComputed(name, topics[name], cfg, pub); err != nil {
 			return err
 		}
 	}
+	return nil
+}
+
+// PublishVariables publishes every NUT variable that cfg doesn't filter
+// out as an individual topic.
+func PublishVariables(vars map[string]string, cfg PublishConfig, pub Publisher) error {
+	for name, value := range cfg.filterVars(vars) {
+		topic := cfg.variableTopic(name)
+		if err := pub.Publish(Message{Topic: topic, Payload: value, Retained: cfg.retainedFor(name)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	// --- computed metric topics ---
+// PublishMetrics publishes every computed metric under the "computed/"
+// sub-tree.
+func PublishMetrics(m metrics.Metrics, cfg PublishConfig, pub Publisher) error {
 	for name, payload := range m.AsTopicMap() {
-		topic := fmt.Sprintf("%s/%s/computed/%s", cfg.Prefix, cfg.UPSName, name)
+		topic := ComputedTopic(cfg.Prefix, cfg.UPSName, name)
 		if err := pub.Publish(Message{Topic: topic, Payload: payload, Retained: cfg.Retained}); err != nil {
 			return err
 		}
 	}
+	return nil
+}
+
+// ComputedTopic returns the topic of a computed metric.
+func ComputedTopic(prefix, upsName, name string) string {
+	return fmt.Sprintf("%s/%s/computed/%s", prefix, upsName, name)
+}
+
+// PublishComputed publishes a single computed value that is derived across
+// polls rather than by metrics.Compute, so it is not part of the state
+// topic's "computed" object.
+func PublishComputed(name, payload string, cfg PublishConfig, pub Publisher) error {
+	return pub.Publish(Message{
+		Topic:    ComputedTopic(cfg.Prefix, cfg.UPSName, name),
+		Payload:  payload,
+		Retained: cfg.Retained,
+	})
+}
 
-	// --- combined JSON state topic ---
-	return publishState(vars, m, cfg, pub)
+// GlitchCountTopic returns the MQTT topic carrying the running count of
+// readings rejected or clamped by the plausibility filter.
+func GlitchCountTopic(prefix, upsName string) string {
+	return fmt.Sprintf("%s/%s/glitch_count", prefix, upsName)
+}
+
+// PublishGlitchCount publishes the cumulative plausibility-filter glitch
+// count since startup.
+func PublishGlitchCount(count int64, cfg PublishConfig, pub Publisher) error {
+	return pub.Publish(Message{
+		Topic:    GlitchCountTopic(cfg.Prefix, cfg.UPSName),
+		Payload:  strconv.FormatInt(count, 10),
+		Retained: cfg.Retained,
+	})
 }
 
 // FormatOffline returns the JSON payload for the offline announcement.
@@ -166,28 +375,79 @@ func FormatOffline() string {
 	return string(payload)
 }
 
+// VariableTopic returns the MQTT topic for a raw NUT variable: dots in the
+// variable name become topic levels, each made safe by TopicLevel.
+func VariableTopic(prefix, upsName, name string) string {
+	return fmt.Sprintf("%s/%s/%s", prefix, upsName, strings.ReplaceAll(TopicLevel(name), ".", "/"))
+}
+
+// TopicLevel makes s safe to use as MQTT topic levels: the wildcards + and
+// #, which a broker refuses in a published topic, the level separator /,
+// and whitespace and control characters all become _.  UPS labels and the
+// variable and alert names in topics go through it, so a name that a
+// driver or the config allows can't break out of the bridge's tree or
+// have the connection dropped.
+func TopicLevel(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '+' || r == '#' || r == '/' || unicode.IsSpace(r) || unicode.IsControl(r) {
+			return '_'
+		}
+		return r
+	}, s)
+}
+
 // StateTopic returns the MQTT topic used for the combined state message.
 func StateTopic(prefix, upsName string) string {
 	return fmt.Sprintf("%s/%s/state", prefix, upsName)
 }
 
-// publishState marshals and publishes the combined JSON state message.
-func publishState(
+// PublishState marshals and publishes the combined JSON state message.
+func PublishState(
 	vars map[string]string,
 	m metrics.Metrics,
 	cfg PublishConfig,
 	pub Publisher,
 ) error {
+	return PublishStateAt(vars, m, time.Now(), cfg, pub)
+}
+
+// PublishStateAt is PublishState for a reading taken at now rather than
+// just now, such as the last poll's published again.
+func PublishStateAt(vars map[string]string, m metrics.Metrics, now time.Time, cfg PublishConfig, pub Publisher) error {
+	return publishState(vars, m, now, false, cfg, pub)
+}
+
+// PublishStaleState is PublishStateAt for a reading left over from before
+// the bridge started, marked "stale":true.
+func PublishStaleState(vars map[string]string, m metrics.Metrics, at time.Time, cfg PublishConfig, pub Publisher) error {
+	return publishState(vars, m, at, true, cfg, pub)
+}
+
+func publishState(vars map[string]string, m metrics.Metrics, now time.Time, stale bool, cfg PublishConfig, pub Publisher) error {
+	now = now.UTC()
 	state := StateMessage{
-		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Timestamp: now.Format(time.RFC3339),
 		UPSName:   cfg.UPSName,
-		Variables: vars,
+		Variables: cfg.filterVars(vars),
 		Computed:  m,
+		Labels:    cfg.Labels,
+		Stale:     stale,
+	}
+	if cfg.RetainTTL > 0 {
+		state.ExpiresAt = now.Add(cfg.RetainTTL).Format(time.RFC3339)
 	}
 	payload, err := json.Marshal(state)
 	if err != nil {
 		return fmt.Errorf("marshalling state: %w", err)
 	}
+	if cfg.MaxStateBytes > 0 && len(payload) > cfg.MaxStateBytes {
+		if cfg.StateOverflow == OverflowSplit {
+			return publishSplitState(state, cfg, pub)
+		}
+		if payload, err = shrinkState(state, cfg); err != nil {
+			return err
+		}
+	}
 	return pub.Publish(Message{
 		Topic:    StateTopic(cfg.Prefix, cfg.UPSName),
 		Payload:  string(payload),
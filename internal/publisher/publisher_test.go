@@ -193,6 +193,23 @@ func TestStateTopic(t *testing.T) {
 	}
 }
 
+func TestTopicLevel(t *testing.T) {
+	if got := publisher.TopicLevel("office ups/#1+\t"); got != "office_ups__1__" {
+		t.Errorf("TopicLevel = %q", got)
+	}
+	if got := publisher.VariableTopic("ups", "office", "battery.charge low+"); got != "ups/office/battery/charge_low_" {
+		t.Errorf("VariableTopic = %q", got)
+	}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "office", NamespacePrefixes: map[string]string{"driver": "infra/nut"}}
+	fp := &publisher.FakePublisher{}
+	if err := publisher.PublishVariables(map[string]string{"driver.parameter.port/0": "auto"}, cfg, fp); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fp.Find("infra/nut/parameter/port_0"); !ok {
+		t.Errorf("routed variable published as %v", fp.Messages)
+	}
+}
+
 // ---- FormatOffline --------------------------------------------------------
 
 func TestFormatOffline(t *testing.T) {
@@ -400,3 +417,266 @@ func TestPublishAll_VarsPublishError(t *testing.T) {
 		t.Fatal("expected error when vars publish fails")
 	}
 }
+
+// ---- NonRetained override ---------------------------------------------------
+
+func TestPublishAll_NonRetained_OverridesPerVariable(t *testing.T) {
+	vars := map[string]string{
+		"ups.status":      "OL",
+		"ups.test.result": "Done and passed",
+	}
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{
+		Prefix:      "ups",
+		UPSName:     "cyberpower",
+		Retained:    true,
+		NonRetained: []string{"ups.test.*"},
+	}
+	if err := publisher.PublishAll(vars, metrics.Compute(vars), cfg, fp); err != nil {
+		t.Fatalf("PublishAll: %v", err)
+	}
+
+	if msg, _ := fp.Find("ups/cyberpower/ups/test/result"); msg.Retained {
+		t.Error("ups.test.result matches non_retained and should not be retained")
+	}
+	if msg, _ := fp.Find("ups/cyberpower/ups/status"); !msg.Retained {
+		t.Error("ups.status should keep the global retain setting")
+	}
+	if msg, _ := fp.Find("ups/cyberpower/state"); !msg.Retained {
+		t.Error("state topic should keep the global retain setting")
+	}
+}
+
+func TestPublishAll_NonRetained_NeverForcesRetain(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower", NonRetained: []string{"ups.status"}}
+	if err := publisher.PublishAll(sampleVars, metrics.Compute(sampleVars), cfg, fp); err != nil {
+		t.Fatalf("PublishAll: %v", err)
+	}
+	for _, msg := range fp.Messages {
+		if msg.Retained {
+			t.Errorf("topic %q retained with global retain disabled", msg.Topic)
+		}
+	}
+}
+
+// ---- NamespacePrefixes routing ------------------------------------------------
+
+func TestPublishAll_IncludeExcludeVars(t *testing.T) {
+	vars := map[string]string{
+		"ups.status":     "OL",
+		"battery.charge": "100",
+		"driver.name":    "usbhid-ups",
+		"driver.version": "2.8.1",
+		"device.serial":  "CTHGV2000123",
+	}
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{
+		Prefix:      "ups",
+		UPSName:     "cyberpower",
+		IncludeVars: []string{"ups.*", "battery.*", "driver.*"},
+		ExcludeVars: []string{"driver.version"},
+	}
+	if err := publisher.PublishAll(vars, metrics.Compute(vars), cfg, fp); err != nil {
+		t.Fatalf("PublishAll: %v", err)
+	}
+
+	for _, topic := range []string{"ups/cyberpower/ups/status", "ups/cyberpower/battery/charge", "ups/cyberpower/driver/name"} {
+		if _, ok := fp.Find(topic); !ok {
+			t.Errorf("%s should be published", topic)
+		}
+	}
+	for _, topic := range []string{"ups/cyberpower/driver/version", "ups/cyberpower/device/serial"} {
+		if _, ok := fp.Find(topic); ok {
+			t.Errorf("%s should be filtered out", topic)
+		}
+	}
+	msg, _ := fp.Find("ups/cyberpower/state")
+	var state publisher.StateMessage
+	if err := json.Unmarshal([]byte(msg.Payload), &state); err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Variables) != 3 || state.Variables["driver.version"] != "" || state.Variables["device.serial"] != "" {
+		t.Errorf("state variables = %v, want only the filtered ones", state.Variables)
+	}
+	if state.Computed.StatusDisplay == "" {
+		t.Error("computed metrics should still be derived")
+	}
+}
+
+func TestPublishAll_NamespacePrefixes(t *testing.T) {
+	vars := map[string]string{
+		"battery.charge":     "100",
+		"battery.charge.low": "10",
+		"driver.name":        "usbhid-ups",
+		"driver.version":     "2.8.1",
+		"ups.status":         "OL",
+	}
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{
+		Prefix:  "ups",
+		UPSName: "ups1",
+		NamespacePrefixes: map[string]string{
+			"battery":        "power/ups1/battery",
+			"driver":         "infra/nut/ups1/",
+			"driver.version": "infra/versions/ups1-driver",
+		},
+	}
+	if err := publisher.PublishAll(vars, metrics.Compute(vars), cfg, fp); err != nil {
+		t.Fatalf("PublishAll: %v", err)
+	}
+
+	for topic, want := range map[string]string{
+		"power/ups1/battery/charge":     "100",
+		"power/ups1/battery/charge/low": "10",
+		"infra/nut/ups1/name":           "usbhid-ups",
+		"infra/versions/ups1-driver":    "2.8.1", // longest namespace wins
+		"ups/ups1/ups/status":           "OL",    // unrouted namespace keeps the default layout
+	} {
+		msg, ok := fp.Find(topic)
+		if !ok {
+			t.Errorf("topic %q not published", topic)
+			continue
+		}
+		if msg.Payload != want {
+			t.Errorf("topic %q payload = %q, want %q", topic, msg.Payload, want)
+		}
+	}
+	if _, ok := fp.Find("ups/ups1/battery/charge"); ok {
+		t.Error("routed variable must not also be published under the default prefix")
+	}
+	if _, ok := fp.Find("ups/ups1/state"); !ok {
+		t.Error("state topic should stay under the default prefix")
+	}
+}
+
+func TestPublishAll_NamespacePrefixes_MatchesWholeSegments(t *testing.T) {
+	vars := map[string]string{"batteryx.level": "1"}
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{
+		Prefix:            "ups",
+		UPSName:           "ups1",
+		NamespacePrefixes: map[string]string{"battery": "power/battery"},
+	}
+	if err := publisher.PublishAll(vars, metrics.Compute(vars), cfg, fp); err != nil {
+		t.Fatalf("PublishAll: %v", err)
+	}
+	if _, ok := fp.Find("ups/ups1/batteryx/level"); !ok {
+		t.Error(`"battery" namespace must not capture "batteryx.level"`)
+	}
+}
+
+// ---- Glitch count -------------------------------------------------------------
+
+func TestPublishGlitchCount(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower", Retained: true}
+	if err := publisher.PublishGlitchCount(3, cfg, fp); err != nil {
+		t.Fatalf("PublishGlitchCount: %v", err)
+	}
+	msg, ok := fp.Find("ups/cyberpower/glitch_count")
+	if !ok {
+		t.Fatal("glitch_count not published")
+	}
+	if msg.Payload != "3" || !msg.Retained {
+		t.Errorf("glitch_count = %+v, want payload 3, retained", msg)
+	}
+}
+
+// ---- Cross-poll computed values ----------------------------------------------
+
+func TestPublishComputed(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower", Retained: true}
+	if err := publisher.PublishComputed("battery_charge_rate", "-0.5", cfg, fp); err != nil {
+		t.Fatalf("PublishComputed: %v", err)
+	}
+	msg, ok := fp.Find("ups/cyberpower/computed/battery_charge_rate")
+	if !ok {
+		t.Fatal("computed/battery_charge_rate not published")
+	}
+	if msg.Payload != "-0.5" || !msg.Retained {
+		t.Errorf("msg = %+v, want retained -0.5", msg)
+	}
+}
+
+// ---- Per-class publishing ----------------------------------------------------
+
+func TestPublishVariables_OnlyVariableTopics(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower"}
+	if err := publisher.PublishVariables(sampleVars, cfg, fp); err != nil {
+		t.Fatalf("PublishVariables: %v", err)
+	}
+	if len(fp.Messages) != len(sampleVars) {
+		t.Errorf("published %d messages, want %d", len(fp.Messages), len(sampleVars))
+	}
+	if _, ok := fp.Find("ups/cyberpower/battery/charge"); !ok {
+		t.Error("battery/charge not published")
+	}
+	if _, ok := fp.Find("ups/cyberpower/state"); ok {
+		t.Error("state must not be published by PublishVariables")
+	}
+}
+
+func TestPublishMetrics_OnlyComputedTopics(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower"}
+	if err := publisher.PublishMetrics(metrics.Compute(sampleVars), cfg, fp); err != nil {
+		t.Fatalf("PublishMetrics: %v", err)
+	}
+	for _, msg := range fp.Messages {
+		if !strings.HasPrefix(msg.Topic, "ups/cyberpower/computed/") {
+			t.Errorf("unexpected topic %q", msg.Topic)
+		}
+	}
+	if _, ok := fp.Find("ups/cyberpower/computed/load_watts"); !ok {
+		t.Error("computed/load_watts not published")
+	}
+}
+
+func TestPublishMetrics_Error(t *testing.T) {
+	fp := &publisher.FakePublisher{PublishError: errors.New("broker down")}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower"}
+	if err := publisher.PublishMetrics(metrics.Compute(sampleVars), cfg, fp); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestPublishState_OnlyStateTopic(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower", Retained: true}
+	if err := publisher.PublishState(sampleVars, metrics.Compute(sampleVars), cfg, fp); err != nil {
+		t.Fatalf("PublishState: %v", err)
+	}
+	if len(fp.Messages) != 1 || fp.Messages[0].Topic != "ups/cyberpower/state" {
+		t.Errorf("messages = %+v, want only the state topic", fp.Messages)
+	}
+}
+
+func TestPublishCritical_Order(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower", Retained: true}
+	vars := map[string]string{"ups.status": "OB LB", "battery.charge": "9"}
+	if err := publisher.PublishCritical(vars, metrics.Compute(vars), time.Now(), cfg, fp); err != nil {
+		t.Fatalf("PublishCritical: %v", err)
+	}
+	want := []string{
+		"ups/cyberpower/state",
+		"ups/cyberpower/ups/status",
+		"ups/cyberpower/computed/on_battery",
+		"ups/cyberpower/computed/low_battery",
+		"ups/cyberpower/computed/power_source",
+	}
+	if len(fp.Messages) != len(want) {
+		t.Fatalf("published %d messages, want %d: %+v", len(fp.Messages), len(want), fp.Messages)
+	}
+	for i, msg := range fp.Messages {
+		if msg.Topic != want[i] || !msg.Retained {
+			t.Errorf("message %d = %s (retained %v), want retained %s", i, msg.Topic, msg.Retained, want[i])
+		}
+	}
+	if fp.Messages[3].Payload != "true" {
+		t.Errorf("low_battery = %q, want true", fp.Messages[3].Payload)
+	}
+}
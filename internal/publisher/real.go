@@ -3,11 +3,16 @@ package publisher
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/eclipse/paho.mqtt.golang/packets"
 
 	"github.com/sweeney/ups-mqtt/internal/config"
 )
@@ -16,14 +21,53 @@ import (
 type MQTTPublisher struct {
 	client mqtt.Client
 	qos    byte
+
+	mu       sync.Mutex
+	onConn   func(event string, err error)
+	connects int
+
+	// trying is the broker of the latest connection attempt, and broker
+	// the one the last successful attempt connected to.
+	trying, broker string
+
+	// attempting is set while a connection attempt is outstanding, and
+	// failing once a failed attempt has been reported; reconnecting once
+	// the reconnect after a loss has been.
+	attempting   bool
+	failing      bool
+	reconnecting bool
 }
 
-// NewMQTTPublisher creates a connected MQTT client.
+// Connection events reported to the OnConnChange handler.
+const (
+	ConnConnected    = "connected"
+	ConnLost         = "disconnected"
+	ConnReconnecting = "reconnecting"
+	ConnFailed       = "connect_failed"
+	ConnAuthFailed   = "auth_failed"
+)
+
+// ConnectFailure returns the event for a failed connection to the broker:
+// ConnAuthFailed when the broker refused the credentials, ConnFailed for
+// anything else.
+func ConnectFailure(err error) string {
+	if errors.Is(err, packets.ErrorRefusedBadUsernameOrPassword) || errors.Is(err, packets.ErrorRefusedNotAuthorised) {
+		return ConnAuthFailed
+	}
+	return ConnFailed
+}
+
+// NewMQTTPublisher creates a connected MQTT client.  With several brokers
+// (cfg.Brokers) paho tries them in order on every connect and reconnect;
+// Broker says which one it is using.
 // lwtTopic and lwtPayload are used for the Last Will and Testament message,
-// published by the broker if the client disconnects unexpectedly.
+// published by the broker if the client disconnects unexpectedly; an empty
+// lwtTopic registers none.
 func NewMQTTPublisher(cfg config.MQTTConfig, lwtTopic, lwtPayload string) (*MQTTPublisher, error) {
 	opts := mqtt.NewClientOptions()
-	opts.AddBroker(cfg.Broker)
+	for _, b := range cfg.BrokerList() {
+		opts.AddBroker(b)
+	}
 	opts.SetClientID(cfg.ClientID)
 	if cfg.Username != "" {
 		opts.SetUsername(cfg.Username)
@@ -32,21 +76,115 @@ func NewMQTTPublisher(cfg config.MQTTConfig, lwtTopic, lwtPayload string) (*MQTT
 	opts.SetKeepAlive(60 * time.Second)
 	opts.SetCleanSession(true)
 	opts.SetAutoReconnect(true)
-	opts.SetWill(lwtTopic, lwtPayload, cfg.QOS, true)
 
-	if cfg.TLSCACert != "" {
-		tlsCfg, err := newTLSConfig(cfg.TLSCACert)
+	p := &MQTTPublisher{qos: cfg.QOS}
+	opts.SetConnectionLostHandler(func(_ mqtt.Client, err error) { p.connEvent(ConnLost, err) })
+	opts.SetOnConnectHandler(func(mqtt.Client) { p.connEvent(ConnConnected, nil) })
+	opts.SetReconnectingHandler(func(mqtt.Client, *mqtt.ClientOptions) { p.reconnectEvent() })
+	opts.SetConnectionAttemptHandler(func(broker *url.URL, tlsCfg *tls.Config) *tls.Config {
+		p.attemptEvent(broker.String())
+		return tlsCfg
+	})
+	if lwtTopic != "" {
+		opts.SetWill(lwtTopic, lwtPayload, cfg.QOS, true)
+	}
+
+	tlsOpts := tlsOptions{
+		CACert:     cfg.TLSCACert,
+		Insecure:   cfg.TLSInsecure,
+		ServerName: cfg.TLSServerName,
+		ClientCert: cfg.TLSClientCert,
+		ClientKey:  cfg.TLSClientKey,
+	}
+	if tlsOpts != (tlsOptions{}) {
+		tlsCfg, err := newTLSConfig(tlsOpts)
 		if err != nil {
-			return nil, fmt.Errorf("loading TLS CA cert %q: %w", cfg.TLSCACert, err)
+			return nil, fmt.Errorf("configuring TLS: %w", err)
 		}
 		opts.SetTLSConfig(tlsCfg)
 	}
 
-	client := mqtt.NewClient(opts)
-	if token := client.Connect(); token.Wait() && token.Error() != nil {
-		return nil, fmt.Errorf("connecting to MQTT broker %q: %w", cfg.Broker, token.Error())
+	p.client = mqtt.NewClient(opts)
+	if token := p.client.Connect(); token.Wait() && token.Error() != nil {
+		return nil, &ConnectError{Broker: strings.Join(cfg.BrokerList(), ", "), Err: token.Error()}
+	}
+	return p, nil
+}
+
+// ConnectError is returned by NewMQTTPublisher when the broker could not be
+// reached or refused the connection, as opposed to a configuration error.
+type ConnectError struct {
+	Broker string
+	Err    error
+}
+
+func (e *ConnectError) Error() string {
+	return fmt.Sprintf("connecting to MQTT broker %q: %v", e.Broker, e.Err)
+}
+
+func (e *ConnectError) Unwrap() error { return e.Err }
+
+// OnConnChange registers f to be told when the connection to the broker is
+// lost, when reconnecting starts, when a reconnect attempt first fails (a
+// run of failures is reported once; paho doesn't say why) and when the
+// connection is re-established; the initial connection isn't reported.
+// paho calls it on its own goroutines.
+func (p *MQTTPublisher) OnConnChange(f func(event string, err error)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onConn = f
+}
+
+func (p *MQTTPublisher) connEvent(event string, err error) {
+	p.mu.Lock()
+	switch event {
+	case ConnConnected:
+		p.connects++
+		p.broker = p.trying
+		p.attempting, p.failing, p.reconnecting = false, false, false
+	case ConnLost:
+		p.attempting, p.failing, p.reconnecting = false, false, false
+	}
+	f, initial := p.onConn, event == ConnConnected && p.connects == 1
+	p.mu.Unlock()
+	if f != nil && !initial {
+		f(event, err)
+	}
+}
+
+// reconnectEvent reports the first reconnect attempt after a loss.
+func (p *MQTTPublisher) reconnectEvent() {
+	p.mu.Lock()
+	first := !p.reconnecting
+	p.reconnecting = true
+	p.mu.Unlock()
+	if first {
+		p.connEvent(ConnReconnecting, nil)
+	}
+}
+
+// attemptEvent is told about every connection attempt, to each broker in
+// turn.  paho starts the next attempt only once the previous one has
+// failed, so an attempt still outstanding means a failure, reported once
+// until the next connection.
+func (p *MQTTPublisher) attemptEvent(broker string) {
+	p.mu.Lock()
+	failed := p.attempting && !p.failing
+	p.attempting, p.trying = true, broker
+	if failed {
+		p.failing = true
 	}
-	return &MQTTPublisher{client: client, qos: cfg.QOS}, nil
+	p.mu.Unlock()
+	if failed {
+		p.connEvent(ConnFailed, errors.New("connection attempt failed"))
+	}
+}
+
+// Broker returns the URL of the broker last connected to.
+func (p *MQTTPublisher) Broker() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.broker
 }
 
 // Publish sends a single MQTT message and waits for the broker to acknowledge.
@@ -56,21 +194,75 @@ func (p *MQTTPublisher) Publish(msg Message) error {
 	return token.Error()
 }
 
+// Subscribe registers handler for messages on topic (MQTT wildcards allowed).
+// paho calls handler on its own goroutine; handlers must not block for long.
+func (p *MQTTPublisher) Subscribe(topic string, handler func(Message)) error {
+	token := p.client.Subscribe(topic, p.qos, func(_ mqtt.Client, m mqtt.Message) {
+		handler(Message{Topic: m.Topic(), Payload: string(m.Payload()), Retained: m.Retained()})
+	})
+	token.Wait()
+	if err := token.Error(); err != nil {
+		return err
+	}
+	// Brokers report an ACL denial as a SUBACK return code of 0x80 rather
+	// than as an error.
+	if st, ok := token.(*mqtt.SubscribeToken); ok {
+		if code, ok := st.Result()[topic]; ok && code == 0x80 {
+			return fmt.Errorf("broker refused subscription to %s", topic)
+		}
+	}
+	return nil
+}
+
+// Unsubscribe removes the subscription for topic.
+func (p *MQTTPublisher) Unsubscribe(topic string) error {
+	token := p.client.Unsubscribe(topic)
+	token.Wait()
+	return token.Error()
+}
+
 // Close disconnects from the broker gracefully.
 func (p *MQTTPublisher) Close() error {
 	p.client.Disconnect(250)
 	return nil
 }
 
-// newTLSConfig builds a *tls.Config that trusts caFile as an additional CA.
-func newTLSConfig(caFile string) (*tls.Config, error) {
-	caCert, err := os.ReadFile(caFile)
+// tlsOptions are the [mqtt] TLS settings: an extra CA to trust, whether
+// to skip certificate verification, the name to verify against, and a
+// client certificate and key to present.
+type tlsOptions struct {
+	CACert     string
+	Insecure   bool
+	ServerName string
+	ClientCert string
+	ClientKey  string
+}
+
+// newTLSConfig builds the *tls.Config for o.  Without a CA file the
+// system CAs are trusted.
+func newTLSConfig(o tlsOptions) (*tls.Config, error) {
+	cfg := &tls.Config{
+		InsecureSkipVerify: o.Insecure, //nolint:gosec // opted into by mqtt.tls_insecure
+		ServerName:         o.ServerName,
+	}
+	if o.ClientCert != "" {
+		pair, err := tls.LoadX509KeyPair(o.ClientCert, o.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{pair}
+	}
+	if o.CACert == "" {
+		return cfg, nil
+	}
+	caCert, err := os.ReadFile(o.CACert)
 	if err != nil {
 		return nil, fmt.Errorf("reading CA cert: %w", err)
 	}
 	pool := x509.NewCertPool()
 	if !pool.AppendCertsFromPEM(caCert) {
-		return nil, fmt.Errorf("failed to parse CA cert from %q", caFile)
+		return nil, fmt.Errorf("failed to parse CA cert from %q", o.CACert)
 	}
-	return &tls.Config{RootCAs: pool}, nil
+	cfg.RootCAs = pool
+	return cfg, nil
 }
@@ -9,11 +9,17 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
+	"errors"
 	"math/big"
+	"net"
 	"os"
+	"path/filepath"
+	"slices"
 	"testing"
 	"time"
 
+	"github.com/eclipse/paho.mqtt.golang/packets"
+
 	"github.com/sweeney/ups-mqtt/internal/config"
 )
 
@@ -52,7 +58,7 @@ func makeTempCACert(t *testing.T) string {
 // ── newTLSConfig ─────────────────────────────────────────────────────────────
 
 func TestNewTLSConfig_NonexistentFile(t *testing.T) {
-	_, err := newTLSConfig("/nonexistent/ca.pem")
+	_, err := newTLSConfig(tlsOptions{CACert: "/nonexistent/ca.pem"})
 	if err == nil {
 		t.Fatal("expected error for non-existent CA cert file")
 	}
@@ -67,7 +73,7 @@ func TestNewTLSConfig_InvalidPEM(t *testing.T) {
 	f.WriteString("this is not a valid PEM certificate") //nolint:errcheck
 	f.Close()                                            //nolint:errcheck
 
-	_, err = newTLSConfig(f.Name())
+	_, err = newTLSConfig(tlsOptions{CACert: f.Name()})
 	if err == nil {
 		t.Fatal("expected error for file with no valid PEM blocks")
 	}
@@ -77,7 +83,7 @@ func TestNewTLSConfig_ValidCert(t *testing.T) {
 	path := makeTempCACert(t)
 	defer os.Remove(path)
 
-	cfg, err := newTLSConfig(path)
+	cfg, err := newTLSConfig(tlsOptions{CACert: path})
 	if err != nil {
 		t.Fatalf("newTLSConfig: %v", err)
 	}
@@ -86,6 +92,52 @@ func TestNewTLSConfig_ValidCert(t *testing.T) {
 	}
 }
 
+func TestNewTLSConfig_InsecureAndServerName(t *testing.T) {
+	cfg, err := newTLSConfig(tlsOptions{Insecure: true, ServerName: "broker.lan"})
+	if err != nil {
+		t.Fatalf("newTLSConfig: %v", err)
+	}
+	if !cfg.InsecureSkipVerify || cfg.ServerName != "broker.lan" || cfg.RootCAs != nil {
+		t.Errorf("tls.Config = %+v, want verification skipped, ServerName set and the system CAs", cfg)
+	}
+}
+
+func TestNewTLSConfig_ClientCert(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "ups-mqtt"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating cert: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshalling key: %v", err)
+	}
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+	os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0o600)  //nolint:errcheck
+	os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600) //nolint:errcheck
+
+	cfg, err := newTLSConfig(tlsOptions{ClientCert: certPath, ClientKey: keyPath})
+	if err != nil {
+		t.Fatalf("newTLSConfig: %v", err)
+	}
+	if len(cfg.Certificates) != 1 {
+		t.Errorf("Certificates = %d, want the client certificate", len(cfg.Certificates))
+	}
+	if _, err := newTLSConfig(tlsOptions{ClientCert: certPath, ClientKey: certPath}); err == nil {
+		t.Error("expected error for a key file holding no key")
+	}
+}
+
 // ── NewMQTTPublisher ─────────────────────────────────────────────────────────
 
 // TestNewMQTTPublisher_TLSCertError verifies the error path when the TLS CA
@@ -117,3 +169,107 @@ func TestNewMQTTPublisher_WithCredentials_TLSError(t *testing.T) {
 		t.Fatal("expected TLS error even with credentials set")
 	}
 }
+
+func TestMQTTPublisher_ConnEvent(t *testing.T) {
+	p := &MQTTPublisher{}
+	p.connEvent("connected", nil) // before a handler is registered
+	var events []string
+	p.OnConnChange(func(event string, err error) { events = append(events, event) })
+	p.connEvent("disconnected", nil)
+	p.connEvent("connected", nil)
+	if len(events) != 2 || events[0] != "disconnected" || events[1] != "connected" {
+		t.Errorf("events = %q, want the reconnect but not the initial connection", events)
+	}
+}
+
+func TestMQTTPublisher_ReconnectEvents(t *testing.T) {
+	p := &MQTTPublisher{}
+	p.attemptEvent("tcp://broker:1883")
+	p.connEvent(ConnConnected, nil)
+	var events []string
+	p.OnConnChange(func(event string, err error) { events = append(events, event) })
+
+	p.connEvent(ConnLost, errors.New("EOF"))
+	for range 3 { // three failed attempts, then one that succeeds
+		p.reconnectEvent()
+		p.attemptEvent("tcp://broker:1883")
+	}
+	p.reconnectEvent()
+	p.attemptEvent("tcp://broker:1883")
+	p.connEvent(ConnConnected, nil)
+
+	want := []string{ConnLost, ConnReconnecting, ConnFailed, ConnConnected}
+	if !slices.Equal(events, want) {
+		t.Errorf("events = %q, want %q", events, want)
+	}
+}
+
+func TestMQTTPublisher_Broker(t *testing.T) {
+	p := &MQTTPublisher{}
+	var events []string
+	p.OnConnChange(func(event string, err error) { events = append(events, event) })
+	p.attemptEvent("tcp://primary:1883")
+	p.attemptEvent("tcp://standby:1883") // the primary didn't answer
+	p.connEvent(ConnConnected, nil)
+	if got := p.Broker(); got != "tcp://standby:1883" {
+		t.Errorf("Broker() = %q, want the standby", got)
+	}
+	if !slices.Equal(events, []string{ConnFailed}) {
+		t.Errorf("events = %q, want the primary's failure reported", events)
+	}
+
+	p.connEvent(ConnLost, errors.New("EOF"))
+	p.reconnectEvent()
+	p.attemptEvent("tcp://primary:1883")
+	p.connEvent(ConnConnected, nil)
+	if got := p.Broker(); got != "tcp://primary:1883" {
+		t.Errorf("Broker() = %q after reconnecting, want the primary", got)
+	}
+}
+
+func TestNewMQTTPublisher_UnreachableBrokers(t *testing.T) {
+	var addrs []string
+	for range 2 {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listening: %v", err)
+		}
+		addrs = append(addrs, "tcp://"+ln.Addr().String())
+		ln.Close() //nolint:errcheck
+	}
+	_, err := NewMQTTPublisher(config.MQTTConfig{Brokers: addrs, ClientID: "test"}, "", "")
+	var connErr *ConnectError
+	if !errors.As(err, &connErr) || connErr.Broker != addrs[0]+", "+addrs[1] {
+		t.Errorf("err = %v, want a ConnectError naming both brokers", err)
+	}
+}
+
+func TestConnectFailure(t *testing.T) {
+	cases := map[error]string{
+		packets.ErrorRefusedBadUsernameOrPassword: ConnAuthFailed,
+		packets.ErrorRefusedNotAuthorised:         ConnAuthFailed,
+		packets.ErrorRefusedServerUnavailable:     ConnFailed,
+		errors.New("connection refused"):          ConnFailed,
+	}
+	for err, want := range cases {
+		wrapped := &ConnectError{Broker: "tcp://broker:1883", Err: err}
+		if got := ConnectFailure(wrapped); got != want {
+			t.Errorf("ConnectFailure(%v) = %q, want %q", err, got, want)
+		}
+	}
+}
+
+func TestNewMQTTPublisher_Unreachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close() //nolint:errcheck
+
+	_, err = NewMQTTPublisher(config.MQTTConfig{Broker: "tcp://" + addr, ClientID: "test"}, "", "")
+	var connErr *ConnectError
+	if !errors.As(err, &connErr) || ConnectFailure(err) != ConnFailed {
+		t.Errorf("err = %v, want a ConnectError", err)
+	}
+}
@@ -0,0 +1,86 @@
+package publisher
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// SelfTestResult is the outcome of a publish/subscribe loopback check,
+// published as JSON to the selftest topic.
+type SelfTestResult struct {
+	OK        bool    `json:"ok"`
+	LatencyMS float64 `json:"latency_ms,omitempty"`
+	Error     string  `json:"error,omitempty"`
+	Timestamp string  `json:"timestamp"`
+}
+
+// SelfTestTopic returns the topic carrying the latest self-test result.
+func SelfTestTopic(prefix, upsName string) string {
+	return fmt.Sprintf("%s/%s/selftest", prefix, upsName)
+}
+
+// SelfTestProbeTopic returns the topic the loopback probe is sent on.
+func SelfTestProbeTopic(prefix, upsName string) string {
+	return SelfTestTopic(prefix, upsName) + "/probe"
+}
+
+// SelfTestRunTopic returns the command topic that triggers an on-demand
+// self-test; any payload will do.
+func SelfTestRunTopic(prefix, upsName string) string {
+	return SelfTestTopic(prefix, upsName) + "/run"
+}
+
+// SelfTest subscribes to the probe topic, publishes a unique non-retained
+// probe to it and waits up to timeout for the broker to deliver it back.
+// A failure with the connection up usually means a broker ACL silently
+// drops this client's publishes (or its subscriptions).
+func SelfTest(ps PubSub, cfg PublishConfig, timeout time.Duration) SelfTestResult {
+	start := time.Now()
+	res := SelfTestResult{Timestamp: start.UTC().Format(time.RFC3339)}
+
+	topic := SelfTestProbeTopic(cfg.Prefix, cfg.UPSName)
+	nonce := strconv.FormatInt(start.UnixNano(), 10)
+	got := make(chan struct{}, 1)
+
+	if err := ps.Subscribe(topic, func(msg Message) {
+		if msg.Payload == nonce {
+			select {
+			case got <- struct{}{}:
+			default:
+			}
+		}
+	}); err != nil {
+		res.Error = fmt.Sprintf("subscribing to %s: %v", topic, err)
+		return res
+	}
+	defer ps.Unsubscribe(topic) //nolint:errcheck
+
+	if err := ps.Publish(Message{Topic: topic, Payload: nonce}); err != nil {
+		res.Error = fmt.Sprintf("publishing to %s: %v", topic, err)
+		return res
+	}
+
+	select {
+	case <-got:
+		res.OK = true
+		res.LatencyMS = float64(time.Since(start).Microseconds()) / 1000
+	case <-time.After(timeout):
+		res.Error = fmt.Sprintf("probe on %s not received within %s — check broker ACLs for publish and subscribe", topic, timeout)
+	}
+	return res
+}
+
+// PublishSelfTestResult publishes res as JSON to the selftest topic.
+func PublishSelfTestResult(res SelfTestResult, cfg PublishConfig, pub Publisher) error {
+	payload, err := json.Marshal(res)
+	if err != nil {
+		return fmt.Errorf("marshalling self-test result: %w", err)
+	}
+	return pub.Publish(Message{
+		Topic:    SelfTestTopic(cfg.Prefix, cfg.UPSName),
+		Payload:  string(payload),
+		Retained: cfg.Retained,
+	})
+}
@@ -0,0 +1,123 @@
+package publisher_test
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+)
+
+var selfTestCfg = publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower", Retained: true}
+
+// droppingBroker accepts subscriptions and publishes but never delivers
+// anything, like a broker whose ACL silently discards this client's writes.
+type droppingBroker struct {
+	*publisher.FakePublisher
+}
+
+func (d droppingBroker) Subscribe(string, func(publisher.Message)) error { return nil }
+
+func TestSelfTest_RoundTrip(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	res := publisher.SelfTest(fp, selfTestCfg, time.Second)
+	if !res.OK {
+		t.Fatalf("SelfTest failed: %s", res.Error)
+	}
+	msg, ok := fp.Find("ups/cyberpower/selftest/probe")
+	if !ok {
+		t.Fatal("probe not published")
+	}
+	if msg.Retained {
+		t.Error("probe should not be retained")
+	}
+	if len(fp.Subscriptions) != 0 {
+		t.Error("probe subscription should be removed afterwards")
+	}
+}
+
+func TestSelfTest_Timeout(t *testing.T) {
+	res := publisher.SelfTest(droppingBroker{&publisher.FakePublisher{}}, selfTestCfg, 10*time.Millisecond)
+	if res.OK {
+		t.Fatal("SelfTest should fail when the probe never arrives")
+	}
+	if !strings.Contains(res.Error, "ACL") {
+		t.Errorf("error = %q, want a hint about ACLs", res.Error)
+	}
+}
+
+func TestSelfTest_SubscribeError(t *testing.T) {
+	fp := &publisher.FakePublisher{SubscribeError: errors.New("not authorised")}
+	if res := publisher.SelfTest(fp, selfTestCfg, time.Second); res.OK || !strings.Contains(res.Error, "subscribing") {
+		t.Errorf("res = %+v, want subscribe failure", res)
+	}
+}
+
+func TestSelfTest_PublishError(t *testing.T) {
+	fp := &publisher.FakePublisher{PublishError: errors.New("broker down")}
+	if res := publisher.SelfTest(fp, selfTestCfg, time.Second); res.OK || !strings.Contains(res.Error, "publishing") {
+		t.Errorf("res = %+v, want publish failure", res)
+	}
+}
+
+func TestSelfTest_IgnoresForeignPayloads(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	// Another message on the probe topic must not count as the round trip.
+	fp.Deliver(publisher.Message{Topic: "ups/cyberpower/selftest/probe", Payload: "stale"})
+	res := publisher.SelfTest(droppingBroker{fp}, selfTestCfg, 10*time.Millisecond)
+	if res.OK {
+		t.Error("SelfTest should not accept a probe it did not send")
+	}
+}
+
+func TestPublishSelfTestResult(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	res := publisher.SelfTestResult{OK: true, LatencyMS: 1.5, Timestamp: "2026-03-01T12:00:00Z"}
+	if err := publisher.PublishSelfTestResult(res, selfTestCfg, fp); err != nil {
+		t.Fatalf("PublishSelfTestResult: %v", err)
+	}
+	msg, ok := fp.Find("ups/cyberpower/selftest")
+	if !ok {
+		t.Fatal("selftest topic not published")
+	}
+	var got publisher.SelfTestResult
+	if err := json.Unmarshal([]byte(msg.Payload), &got); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if got != res || !msg.Retained {
+		t.Errorf("got %+v (retained=%v), want %+v retained", got, msg.Retained, res)
+	}
+}
+
+func TestTopicMatches(t *testing.T) {
+	tests := []struct {
+		filter, topic string
+		want          bool
+	}{
+		{"ups/a/state", "ups/a/state", true},
+		{"ups/+/state", "ups/a/state", true},
+		{"ups/+/state", "ups/a/b/state", false},
+		{"ups/#", "ups/a/b/state", true},
+		{"ups/a", "ups/a/state", false},
+		{"ups/a/state", "ups/a", false},
+	}
+	for _, tc := range tests {
+		if got := publisher.TopicMatches(tc.filter, tc.topic); got != tc.want {
+			t.Errorf("TopicMatches(%q, %q) = %v, want %v", tc.filter, tc.topic, got, tc.want)
+		}
+	}
+}
+
+func TestFakePublisher_Unsubscribe(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	calls := 0
+	fp.Subscribe("ups/#", func(publisher.Message) { calls++ }) //nolint:errcheck
+	fp.Publish(publisher.Message{Topic: "ups/a"})              //nolint:errcheck
+	fp.Unsubscribe("ups/#")                                    //nolint:errcheck
+	fp.Publish(publisher.Message{Topic: "ups/a"})              //nolint:errcheck
+	if calls != 1 {
+		t.Errorf("handler called %d times, want 1", calls)
+	}
+}
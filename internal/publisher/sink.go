@@ -0,0 +1,342 @@
+package publisher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+
+	"github.com/sweeney/ups-mqtt/internal/plugin"
+	"github.com/sweeney/ups-mqtt/internal/schedule"
+)
+
+// Sink is an output that published messages can be routed to: the MQTT
+// connection, a file, an HTTP endpoint and so on.  It is the Publisher
+// contract under the name of its role in a Router.
+type Sink = Publisher
+
+// Route sends the messages whose topic matches any of Topics (every message
+// when Topics is empty) and none of Exclude to Sink.  Topics and Exclude are
+// MQTT topic filters.
+type Route struct {
+	Name    string
+	Sink    Sink
+	Topics  []string
+	Exclude []string
+}
+
+// Matches reports whether r takes messages published on topic.
+func (r Route) Matches(topic string) bool {
+	for _, f := range r.Exclude {
+		if TopicMatches(f, topic) {
+			return false
+		}
+	}
+	if len(r.Topics) == 0 {
+		return true
+	}
+	for _, f := range r.Topics {
+		if TopicMatches(f, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// Router is a Publisher that fans each message out to the routes that take
+// it, so new outputs can be added without the publish functions knowing.
+type Router struct {
+	Routes []Route
+}
+
+// NewRouter returns a Router over routes.
+func NewRouter(routes ...Route) *Router {
+	return &Router{Routes: routes}
+}
+
+// Publish sends msg to every matching route.  A failing sink doesn't stop
+// the others; their errors are joined.
+func (r *Router) Publish(msg Message) error {
+	var errs []error
+	for _, rt := range r.Routes {
+		if !rt.Matches(msg.Topic) {
+			continue
+		}
+		if err := rt.Sink.Publish(msg); err != nil {
+			errs = append(errs, fmt.Errorf("sink %q: %w", rt.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes every sink.
+func (r *Router) Close() error {
+	var errs []error
+	for _, rt := range r.Routes {
+		if err := rt.Sink.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("sink %q: %w", rt.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// PausableSink passes messages on to Sink except while Paused is set, when
+// they are dropped.  Sinks sharing one Paused are paused together.
+type PausableSink struct {
+	Sink   Sink
+	Paused *atomic.Bool
+}
+
+// Publish sends msg to the sink unless it is paused.
+func (s *PausableSink) Publish(msg Message) error {
+	if s.Paused.Load() {
+		return nil
+	}
+	return s.Sink.Publish(msg)
+}
+
+// Close closes the sink.
+func (s *PausableSink) Close() error {
+	return s.Sink.Close()
+}
+
+// sinkRecord is how the file and HTTP sinks encode a message.
+type sinkRecord struct {
+	Time     string `json:"time"`
+	Topic    string `json:"topic"`
+	Payload  string `json:"payload"`
+	Retained bool   `json:"retained,omitempty"`
+}
+
+func recordOf(msg Message) sinkRecord {
+	return sinkRecord{
+		Time:     time.Now().UTC().Format(time.RFC3339),
+		Topic:    msg.Topic,
+		Payload:  msg.Payload,
+		Retained: msg.Retained,
+	}
+}
+
+func newSinkRecord(msg Message) ([]byte, error) {
+	return json.Marshal(recordOf(msg))
+}
+
+// FileSink appends each message to a file as one JSON object per line.
+type FileSink struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// NewFileSink opens path for appending, creating it if necessary.
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening sink file: %w", err)
+	}
+	return &FileSink{f: f}, nil
+}
+
+// Publish appends msg to the file.
+func (s *FileSink) Publish(msg Message) error {
+	line, err := newSinkRecord(msg)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.f.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the file.
+func (s *FileSink) Close() error {
+	return s.f.Close()
+}
+
+// HTTPSink POSTs each message to URL as a JSON object.
+type HTTPSink struct {
+	URL     string
+	Client  *http.Client
+	Timeout time.Duration
+}
+
+// Publish posts msg and fails on anything but a 2xx response.
+func (s *HTTPSink) Publish(msg Message) error {
+	body, err := newSinkRecord(msg)
+	if err != nil {
+		return err
+	}
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = schedule.CallTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("building sink request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("posting to %s: %w", s.URL, err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s returned %s: %s", s.URL, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// Close is a no-op; HTTPSink holds no connection of its own.
+func (s *HTTPSink) Close() error {
+	return nil
+}
+
+// PluginSink hands each message to a plugin program (see internal/plugin)
+// as a "publish" call whose params are the object the file sink writes, so
+// third-party outputs can be added without rebuilding the bridge.
+type PluginSink struct {
+	Process *plugin.Process
+}
+
+// Publish sends msg to the plugin and waits for it to be accepted.
+func (s *PluginSink) Publish(msg Message) error {
+	return s.Process.Call("publish", recordOf(msg), nil)
+}
+
+// Close stops the plugin.
+func (s *PluginSink) Close() error {
+	return s.Process.Close()
+}
+
+// KafkaWriter is the part of kafka-go's *kafka.Writer a KafkaSink uses.
+type KafkaWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// KafkaSink writes the state message of each poll to a Kafka topic, keyed
+// by the UPS label so each UPS's readings stay in order on one partition,
+// and timestamped with the reading.  Other messages routed to it are
+// skipped: a Kafka consumer wants one document per reading, not the MQTT
+// topic tree, and has no use for the empty payloads that clear a topic.
+type KafkaSink struct {
+	Writer  KafkaWriter
+	Timeout time.Duration
+}
+
+// KafkaOptions configure NewKafkaSink.  With TLS the brokers are reached
+// over TLS, trusting TLSCACert (the system CAs when empty) and checked as
+// TLSInsecure and TLSServerName say, like the [mqtt] options of the same
+// names.  SASLMechanism, when set, is "plain", "scram-sha-256" or
+// "scram-sha-512" and logs in as Username.
+type KafkaOptions struct {
+	Brokers  []string
+	Topic    string
+	ClientID string
+	Timeout  time.Duration
+
+	TLS           bool
+	TLSCACert     string
+	TLSInsecure   bool
+	TLSServerName string
+
+	SASLMechanism string
+	Username      string
+	Password      string
+}
+
+// NewKafkaSink returns a KafkaSink writing through a kafka-go Writer built
+// from o.  Each record is sent on its own as soon as it is published,
+// partitioned by key as Kafka's Java client does, and acknowledged by every
+// in-sync replica.  A failed write is not retried: the next poll's reading
+// replaces it.
+func NewKafkaSink(o KafkaOptions) (*KafkaSink, error) {
+	transport := &kafka.Transport{ClientID: o.ClientID, DialTimeout: o.Timeout}
+	if o.TLS {
+		tlsCfg, err := newTLSConfig(tlsOptions{CACert: o.TLSCACert, Insecure: o.TLSInsecure, ServerName: o.TLSServerName})
+		if err != nil {
+			return nil, err
+		}
+		transport.TLS = tlsCfg
+	}
+	mechanism, err := saslMechanism(o.SASLMechanism, o.Username, o.Password)
+	if err != nil {
+		return nil, err
+	}
+	transport.SASL = mechanism
+	return &KafkaSink{Timeout: o.Timeout, Writer: &kafka.Writer{
+		Addr:         kafka.TCP(o.Brokers...),
+		Topic:        o.Topic,
+		Balancer:     &kafka.Murmur2Balancer{},
+		RequiredAcks: kafka.RequireAll,
+		MaxAttempts:  1,
+		BatchSize:    1,
+		Transport:    transport,
+	}}, nil
+}
+
+// saslMechanism returns the SASL mechanism called name for user, or nil
+// when name is empty.
+func saslMechanism(name, user, password string) (sasl.Mechanism, error) {
+	switch name {
+	case "":
+		return nil, nil
+	case "plain":
+		return plain.Mechanism{Username: user, Password: password}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, user, password)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, user, password)
+	}
+	return nil, fmt.Errorf("unknown SASL mechanism %q", name)
+}
+
+// Publish writes msg to Kafka if it is a state message.
+func (s *KafkaSink) Publish(msg Message) error {
+	if !strings.HasSuffix(msg.Topic, "/state") || msg.Payload == "" {
+		return nil
+	}
+	var state StateMessage
+	if err := json.Unmarshal([]byte(msg.Payload), &state); err != nil {
+		return fmt.Errorf("decoding state message: %w", err)
+	}
+	at, err := time.Parse(time.RFC3339, state.Timestamp)
+	if err != nil {
+		at = time.Now()
+	}
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = schedule.CallTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return s.Writer.WriteMessages(ctx, kafka.Message{Key: []byte(state.UPSName), Value: []byte(msg.Payload), Time: at})
+}
+
+// Close flushes the writer and closes its connections to the brokers.
+func (s *KafkaSink) Close() error {
+	return s.Writer.Close()
+}
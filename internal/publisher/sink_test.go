@@ -0,0 +1,308 @@
+package publisher_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/sweeney/ups-mqtt/internal/plugin"
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+)
+
+func TestRouter_RoutesByTopic(t *testing.T) {
+	mqtt, file := &publisher.FakePublisher{}, &publisher.FakePublisher{}
+	r := publisher.NewRouter(
+		publisher.Route{Name: "mqtt", Sink: mqtt, Exclude: []string{"ups/+/computed/#"}},
+		publisher.Route{Name: "file", Sink: file, Topics: []string{"ups/+/computed/#"}},
+	)
+
+	for _, topic := range []string{"ups/cyberpower/battery/charge", "ups/cyberpower/computed/load_watts"} {
+		if err := r.Publish(publisher.Message{Topic: topic}); err != nil {
+			t.Fatalf("Publish(%s): %v", topic, err)
+		}
+	}
+	if len(mqtt.Messages) != 1 || mqtt.Messages[0].Topic != "ups/cyberpower/battery/charge" {
+		t.Errorf("mqtt got %+v, want only the raw variable", mqtt.Messages)
+	}
+	if len(file.Messages) != 1 || file.Messages[0].Topic != "ups/cyberpower/computed/load_watts" {
+		t.Errorf("file got %+v, want only the computed metric", file.Messages)
+	}
+}
+
+func TestRouter_ErrorDoesNotStopOtherSinks(t *testing.T) {
+	bad := &publisher.FakePublisher{PublishError: errors.New("disk full")}
+	good := &publisher.FakePublisher{}
+	r := publisher.NewRouter(
+		publisher.Route{Name: "bad", Sink: bad},
+		publisher.Route{Name: "good", Sink: good},
+	)
+	err := r.Publish(publisher.Message{Topic: "ups/cyberpower/state"})
+	if err == nil || !strings.Contains(err.Error(), `sink "bad"`) {
+		t.Errorf("err = %v, want the failing sink named", err)
+	}
+	if len(good.Messages) != 1 {
+		t.Error("healthy sink should still receive the message")
+	}
+}
+
+func TestRouter_CloseClosesEverySink(t *testing.T) {
+	a, b := &publisher.FakePublisher{}, &publisher.FakePublisher{}
+	if err := publisher.NewRouter(publisher.Route{Sink: a}, publisher.Route{Sink: b}).Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if !a.Closed || !b.Closed {
+		t.Error("every sink should be closed")
+	}
+}
+
+func TestPausableSink(t *testing.T) {
+	var paused atomic.Bool
+	file := &publisher.FakePublisher{}
+	s := &publisher.PausableSink{Sink: file, Paused: &paused}
+	paused.Store(true)
+	s.Publish(publisher.Message{Topic: "ups/cyberpower/state"}) //nolint:errcheck
+	paused.Store(false)
+	s.Publish(publisher.Message{Topic: "ups/cyberpower/battery/charge"}) //nolint:errcheck
+	if len(file.Messages) != 1 || file.Messages[0].Topic != "ups/cyberpower/battery/charge" {
+		t.Errorf("sink got %+v, want only the message sent while resumed", file.Messages)
+	}
+	if err := s.Close(); err != nil || !file.Closed {
+		t.Errorf("Close = %v, closed %v", err, file.Closed)
+	}
+}
+
+func TestFileSink_AppendsJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sink.jsonl")
+	s, err := publisher.NewFileSink(path)
+	if err != nil {
+		t.Fatalf("NewFileSink: %v", err)
+	}
+	s.Publish(publisher.Message{Topic: "ups/cyberpower/battery/charge", Payload: "100", Retained: true}) //nolint:errcheck
+	s.Publish(publisher.Message{Topic: "ups/cyberpower/ups/load", Payload: "12"})                        //nolint:errcheck
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading sink file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2:\n%s", len(lines), data)
+	}
+	var rec struct {
+		Time, Topic, Payload string
+		Retained             bool
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatalf("line 1 is not JSON: %v", err)
+	}
+	if rec.Topic != "ups/cyberpower/battery/charge" || rec.Payload != "100" || !rec.Retained || rec.Time == "" {
+		t.Errorf("line 1 = %+v", rec)
+	}
+}
+
+func TestNewFileSink_Error(t *testing.T) {
+	if _, err := publisher.NewFileSink(filepath.Join(t.TempDir(), "missing", "sink.jsonl")); err == nil {
+		t.Error("expected error for a missing directory")
+	}
+}
+
+func TestHTTPSink_PostsJSON(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Content-Type = %q", r.Header.Get("Content-Type"))
+		}
+		b, _ := io.ReadAll(r.Body)
+		got = string(b)
+	}))
+	defer srv.Close()
+
+	s := &publisher.HTTPSink{URL: srv.URL, Client: srv.Client()}
+	if err := s.Publish(publisher.Message{Topic: "ups/cyberpower/state", Payload: `{"x":1}`}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if !strings.Contains(got, `"topic":"ups/cyberpower/state"`) || !strings.Contains(got, `"payload":"{\"x\":1}"`) {
+		t.Errorf("body = %s", got)
+	}
+}
+
+func TestHTTPSink_ErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	s := &publisher.HTTPSink{URL: srv.URL}
+	err := s.Publish(publisher.Message{Topic: "t"})
+	if err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("err = %v, want the 502 reported", err)
+	}
+}
+
+// TestPluginSinkHelper is the plugin of TestPluginSink: it accepts every
+// publish except those on topic "reject", and reports the records it got to
+// the file named by SINK_PLUGIN_OUT.
+func TestPluginSinkHelper(t *testing.T) {
+	out := os.Getenv("SINK_PLUGIN_OUT")
+	if out == "" {
+		return
+	}
+	f, _ := os.OpenFile(out, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	sc := bufio.NewScanner(os.Stdin)
+	for sc.Scan() {
+		var req struct {
+			Method string `json:"method"`
+			Params struct {
+				Topic    string `json:"topic"`
+				Payload  string `json:"payload"`
+				Retained bool   `json:"retained"`
+			} `json:"params"`
+		}
+		json.Unmarshal(sc.Bytes(), &req) //nolint:errcheck
+		if req.Params.Topic == "reject" {
+			fmt.Println(`{"error":"no route to NMS"}`)
+			continue
+		}
+		fmt.Fprintf(f, "%s %s %s %v\n", req.Method, req.Params.Topic, req.Params.Payload, req.Params.Retained)
+		fmt.Println(`{}`)
+	}
+	os.Exit(0)
+}
+
+func TestPluginSink(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "got")
+	t.Setenv("SINK_PLUGIN_OUT", out)
+	s := &publisher.PluginSink{Process: &plugin.Process{
+		Command: os.Args[0],
+		Args:    []string{"-test.run=TestPluginSinkHelper"},
+		Timeout: 10 * time.Second,
+	}}
+	if err := s.Publish(publisher.Message{Topic: "ups/cyberpower/battery/charge", Payload: "100", Retained: true}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if err := s.Publish(publisher.Message{Topic: "reject"}); err == nil || !strings.Contains(err.Error(), "no route to NMS") {
+		t.Errorf("err = %v, want the plugin's error", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	got, _ := os.ReadFile(out)
+	if string(got) != "publish ups/cyberpower/battery/charge 100 true\n" {
+		t.Errorf("plugin got %q", got)
+	}
+}
+
+// kafkaWriter records the messages a KafkaSink writes.
+type kafkaWriter struct {
+	msgs   []kafka.Message
+	closed bool
+}
+
+func (w *kafkaWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if _, ok := ctx.Deadline(); !ok {
+		return errors.New("no deadline")
+	}
+	w.msgs = append(w.msgs, msgs...)
+	return nil
+}
+
+func (w *kafkaWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func TestKafkaSink_OnlyState(t *testing.T) {
+	w := &kafkaWriter{}
+	s := &publisher.KafkaSink{Writer: w}
+	for _, msg := range []publisher.Message{
+		{Topic: "ups/cyberpower/battery/charge", Payload: "100"},
+		{Topic: "ups/cyberpower/state/part/1", Payload: "{}"},
+		{Topic: "ups/cyberpower/state", Payload: ""},
+	} {
+		if err := s.Publish(msg); err != nil {
+			t.Errorf("Publish(%s %q) = %v, want it skipped", msg.Topic, msg.Payload, err)
+		}
+	}
+	if err := s.Publish(publisher.Message{Topic: "ups/cyberpower/state", Payload: "not json"}); err == nil || !strings.Contains(err.Error(), "decoding state") {
+		t.Errorf("bad state: err = %v", err)
+	}
+	state := `{"timestamp":"2026-03-01T12:00:00Z","ups_name":"cyberpower","variables":{}}`
+	if err := s.Publish(publisher.Message{Topic: "ups/cyberpower/state", Payload: state}); err != nil {
+		t.Fatalf("state: %v", err)
+	}
+	if len(w.msgs) != 1 {
+		t.Fatalf("wrote %d records, want 1", len(w.msgs))
+	}
+	if m := w.msgs[0]; string(m.Key) != "cyberpower" || string(m.Value) != state || !m.Time.Equal(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("record = key %q value %q time %v", m.Key, m.Value, m.Time)
+	}
+	if err := s.Close(); err != nil || !w.closed {
+		t.Errorf("Close = %v, writer closed %v", err, w.closed)
+	}
+}
+
+func TestNewKafkaSink(t *testing.T) {
+	s, err := publisher.NewKafkaSink(publisher.KafkaOptions{
+		Brokers:       []string{"kafka1:9093", "kafka2:9093"},
+		Topic:         "facility.ups",
+		ClientID:      "ups-mqtt",
+		TLS:           true,
+		TLSServerName: "kafka.example.com",
+		SASLMechanism: "scram-sha-512",
+		Username:      "bridge",
+		Password:      "secret",
+	})
+	if err != nil {
+		t.Fatalf("NewKafkaSink: %v", err)
+	}
+	w, ok := s.Writer.(*kafka.Writer)
+	if !ok {
+		t.Fatalf("Writer = %T, want *kafka.Writer", s.Writer)
+	}
+	if w.Addr.String() != "kafka1:9093,kafka2:9093" || w.Topic != "facility.ups" || w.RequiredAcks != kafka.RequireAll {
+		t.Errorf("writer = addr %s topic %q acks %v", w.Addr, w.Topic, w.RequiredAcks)
+	}
+	if _, ok := w.Balancer.(*kafka.Murmur2Balancer); !ok {
+		t.Errorf("Balancer = %T, want Kafka's murmur2 partitioning", w.Balancer)
+	}
+	tr := w.Transport.(*kafka.Transport)
+	if tr.ClientID != "ups-mqtt" || tr.TLS == nil || tr.TLS.ServerName != "kafka.example.com" {
+		t.Errorf("transport = client %q TLS %+v", tr.ClientID, tr.TLS)
+	}
+	if tr.SASL == nil || tr.SASL.Name() != "SCRAM-SHA-512" {
+		t.Errorf("SASL = %v, want SCRAM-SHA-512", tr.SASL)
+	}
+
+	plain, err := publisher.NewKafkaSink(publisher.KafkaOptions{Brokers: []string{"kafka1:9092"}, Topic: "t"})
+	if err != nil {
+		t.Fatalf("NewKafkaSink without TLS: %v", err)
+	}
+	if tr := plain.Writer.(*kafka.Writer).Transport.(*kafka.Transport); tr.TLS != nil || tr.SASL != nil {
+		t.Errorf("transport = %+v, want plaintext without SASL", tr)
+	}
+
+	for name, o := range map[string]publisher.KafkaOptions{
+		"unknown mechanism": {SASLMechanism: "gssapi"},
+		"missing CA":        {TLS: true, TLSCACert: filepath.Join(t.TempDir(), "missing.pem")},
+	} {
+		if _, err := publisher.NewKafkaSink(o); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package publisher
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// DataStaleTopic returns the computed topic that reports whether the NUT
+// driver's data is stale.
+func DataStaleTopic(prefix, upsName string) string {
+	return ComputedTopic(prefix, upsName, "data_stale")
+}
+
+// PublishDataStale publishes computed/data_stale.  When stale, it also
+// replaces the state message with an offline one flagged data_stale, so
+// consumers that take availability from the state topic (including Home
+// Assistant discovery) stop treating the last values as live.  The next
+// regular state message restores availability.
+func PublishDataStale(stale bool, cfg PublishConfig, pub Publisher) error {
+	if err := pub.Publish(Message{
+		Topic:    DataStaleTopic(cfg.Prefix, cfg.UPSName),
+		Payload:  fmt.Sprint(stale),
+		Retained: cfg.Retained,
+	}); err != nil || !stale {
+		return err
+	}
+	payload, err := json.Marshal(OnlineState{
+		Online:    false,
+		DataStale: true,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return fmt.Errorf("marshalling stale state: %w", err)
+	}
+	return pub.Publish(Message{
+		Topic:    StateTopic(cfg.Prefix, cfg.UPSName),
+		Payload:  string(payload),
+		Retained: cfg.Retained,
+	})
+}
@@ -0,0 +1,62 @@
+package publisher_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+)
+
+func TestPublishDataStale_DowngradesAvailability(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower", Retained: true}
+
+	if err := publisher.PublishDataStale(true, cfg, fp); err != nil {
+		t.Fatalf("PublishDataStale: %v", err)
+	}
+	if msg, ok := fp.Find("ups/cyberpower/computed/data_stale"); !ok || msg.Payload != "true" || !msg.Retained {
+		t.Errorf("computed/data_stale = %+v, want retained \"true\"", msg)
+	}
+	msg, ok := fp.Find("ups/cyberpower/state")
+	if !ok || !msg.Retained {
+		t.Fatalf("state = %+v, want retained offline message", msg)
+	}
+	var st publisher.OnlineState
+	if err := json.Unmarshal([]byte(msg.Payload), &st); err != nil {
+		t.Fatalf("state JSON: %v", err)
+	}
+	if st.Online || !st.DataStale || st.Timestamp == "" {
+		t.Errorf("state = %+v, want offline with data_stale", st)
+	}
+}
+
+func TestPublishDataStale_False(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower"}
+
+	if err := publisher.PublishDataStale(false, cfg, fp); err != nil {
+		t.Fatalf("PublishDataStale: %v", err)
+	}
+	if len(fp.Messages) != 1 || fp.Messages[0].Payload != "false" {
+		t.Errorf("messages = %+v, want only computed/data_stale=false", fp.Messages)
+	}
+}
+
+func TestPublishDataStale_PublishError(t *testing.T) {
+	fp := &publisher.FakePublisher{PublishError: errors.New("broker down")}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower"}
+	if err := publisher.PublishDataStale(true, cfg, fp); err == nil {
+		t.Fatal("expected publish error")
+	}
+}
+
+func TestFormatOffline_NotStale(t *testing.T) {
+	var st map[string]interface{}
+	if err := json.Unmarshal([]byte(publisher.FormatOffline()), &st); err != nil {
+		t.Fatalf("FormatOffline JSON: %v", err)
+	}
+	if _, ok := st["data_stale"]; ok {
+		t.Errorf("offline announcement = %v, should not carry data_stale", st)
+	}
+}
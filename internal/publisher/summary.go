@@ -0,0 +1,27 @@
+package publisher
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/sweeney/ups-mqtt/internal/summary"
+)
+
+// SummaryTopic returns the topic carrying the daily summary report.
+func SummaryTopic(prefix, upsName string) string {
+	return fmt.Sprintf("%s/%s/summary", prefix, upsName)
+}
+
+// PublishSummary publishes r as JSON to the summary topic.  It is retained
+// when cfg.Retained is, so the latest digest is there for anyone who looks.
+func PublishSummary(r summary.Report, cfg PublishConfig, pub Publisher) error {
+	payload, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("marshalling summary: %w", err)
+	}
+	return pub.Publish(Message{
+		Topic:    SummaryTopic(cfg.Prefix, cfg.UPSName),
+		Payload:  string(payload),
+		Retained: cfg.Retained,
+	})
+}
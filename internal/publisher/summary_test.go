@@ -0,0 +1,28 @@
+package publisher_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/publisher"
+	"github.com/sweeney/ups-mqtt/internal/summary"
+)
+
+func TestPublishSummary(t *testing.T) {
+	fp := &publisher.FakePublisher{}
+	cfg := publisher.PublishConfig{Prefix: "ups", UPSName: "cyberpower", Retained: true}
+	var p summary.Period
+	p.Add(summary.Reading{Time: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), LoadPct: 25, LoadPctOK: true})
+
+	if err := publisher.PublishSummary(p.Report(time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)), cfg, fp); err != nil {
+		t.Fatalf("PublishSummary: %v", err)
+	}
+	msg, ok := fp.Find("ups/cyberpower/summary")
+	if !ok || !msg.Retained {
+		t.Fatalf("summary = %+v, want it published retained", msg)
+	}
+	want := `{"from":"2026-03-01T00:00:00Z","to":"2026-03-02T00:00:00Z","polls":1,"load_avg_pct":25,"outages":0,"on_battery_secs":0}`
+	if msg.Payload != want {
+		t.Errorf("payload = %s\nwant      %s", msg.Payload, want)
+	}
+}
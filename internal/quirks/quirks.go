@@ -0,0 +1,176 @@
+// Package quirks adjusts NUT readings for the known firmware and driver
+// peculiarities of particular UPS models.  A Profile is matched against the
+// identity variables a UPS reports and can drop variables the model reports
+// bogus values for, rescale ones reported in the wrong unit, add
+// plausibility rules, and change what metrics are computed from.  Like
+// internal/plausibility it is pure: no I/O and no state.
+package quirks
+
+import (
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/sweeney/ups-mqtt/internal/plausibility"
+)
+
+// Profile describes the quirks of one family of UPSes.
+type Profile struct {
+	Name string
+
+	// Manufacturer and Model are globs (path.Match syntax) matched against
+	// device.mfr/ups.mfr and device.model/ups.model; VendorID is compared
+	// case-insensitively with ups.vendorid.  Every non-empty field must
+	// match, and a profile with none set matches nothing.
+	Manufacturer string
+	Model        string
+	VendorID     string
+
+	// Drop lists variables the model reports nonsense for; they are removed
+	// from every poll.
+	Drop []string
+
+	// Scale multiplies numeric variables reported in the wrong unit.
+	Scale map[string]float64
+
+	// Rules are plausibility rules for the model's known glitches.
+	Rules map[string]plausibility.Rule
+
+	// Defaults are metric fallbacks for variables the model never reports.
+	Defaults map[string]string
+
+	// LoadFromRealPower computes metrics from ups.realpower /
+	// ups.realpower.nominal rather than ups.load when the UPS reports both,
+	// for models whose ups.load is only whole percent, which limits
+	// load_watts to steps of 1% of the nominal power.
+	LoadFromRealPower bool
+}
+
+// Builtin returns the profiles shipped with the bridge.
+func Builtin() []Profile {
+	zero := 0.0
+	return []Profile{
+		{
+			// CyberPower USB HID units (vendor ID 0764) report ups.load in
+			// whole percent, input.voltage=0 for a poll while reconnecting
+			// to mains, and the string "CPS" as battery.mfr.date.
+			Name:              "cyberpower",
+			VendorID:          "0764",
+			Drop:              []string{"battery.mfr.date"},
+			Rules:             map[string]plausibility.Rule{"input.voltage": {Min: &zero, ZeroOnlyOnBattery: true}},
+			LoadFromRealPower: true,
+		},
+	}
+}
+
+// Matches reports whether p applies to the UPS that reported vars.
+func (p Profile) Matches(vars map[string]string) bool {
+	if p.Manufacturer == "" && p.Model == "" && p.VendorID == "" {
+		return false
+	}
+	return globMatches(p.Manufacturer, vars["device.mfr"], vars["ups.mfr"]) &&
+		globMatches(p.Model, vars["device.model"], vars["ups.model"]) &&
+		(p.VendorID == "" || strings.EqualFold(p.VendorID, vars["ups.vendorid"]))
+}
+
+// globMatches reports whether pattern is empty or matches any non-empty value.
+func globMatches(pattern string, values ...string) bool {
+	if pattern == "" {
+		return true
+	}
+	for _, v := range values {
+		if ok, err := path.Match(pattern, v); v != "" && err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Set is the profiles that apply to one UPS, in precedence order: where two
+// profiles adjust the same variable, the later one wins.
+type Set []Profile
+
+// Match returns the profiles that apply to the UPS that reported vars.
+func Match(profiles []Profile, vars map[string]string) Set {
+	var s Set
+	for _, p := range profiles {
+		if p.Matches(vars) {
+			s = append(s, p)
+		}
+	}
+	return s
+}
+
+// Names returns the names of the profiles in s.
+func (s Set) Names() []string {
+	names := make([]string, len(s))
+	for i, p := range s {
+		names[i] = p.Name
+	}
+	return names
+}
+
+// Apply returns a copy of vars with dropped variables removed and scaled
+// ones rescaled.  Unparseable values are left as reported.
+func (s Set) Apply(vars map[string]string) map[string]string {
+	if len(s) == 0 {
+		return vars
+	}
+	out := make(map[string]string, len(vars))
+	for name, v := range vars {
+		out[name] = v
+	}
+	for _, p := range s {
+		for _, name := range p.Drop {
+			delete(out, name)
+		}
+		for name, factor := range p.Scale {
+			if f, err := strconv.ParseFloat(out[name], 64); err == nil {
+				out[name] = strconv.FormatFloat(f*factor, 'f', -1, 64)
+			}
+		}
+	}
+	return out
+}
+
+// Rules returns the plausibility rules of every profile in s.
+func (s Set) Rules() map[string]plausibility.Rule {
+	rules := make(map[string]plausibility.Rule)
+	for _, p := range s {
+		for name, r := range p.Rules {
+			rules[name] = r
+		}
+	}
+	return rules
+}
+
+// MetricsVars returns vars as metrics should see them: profile defaults are
+// added for variables not reported, and ups.load is recomputed from
+// ups.realpower where a profile asks for it.  Like nut.defaults, the result
+// only feeds metrics; the raw variable topics are unaffected.
+func (s Set) MetricsVars(vars map[string]string) map[string]string {
+	if len(s) == 0 {
+		return vars
+	}
+	out := make(map[string]string, len(vars))
+	for name, v := range vars {
+		out[name] = v
+	}
+	fineLoad := false
+	for _, p := range s {
+		for name, v := range p.Defaults {
+			if _, ok := vars[name]; !ok {
+				out[name] = v
+			}
+		}
+		fineLoad = fineLoad || p.LoadFromRealPower
+	}
+	if fineLoad {
+		watts, err1 := strconv.ParseFloat(out["ups.realpower"], 64)
+		nominal, err2 := strconv.ParseFloat(out["ups.realpower.nominal"], 64)
+		if err1 == nil && err2 == nil && nominal > 0 {
+			out["ups.load"] = strconv.FormatFloat(watts/nominal*100, 'f', -1, 64)
+		}
+	}
+	return out
+}
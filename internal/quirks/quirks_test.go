@@ -0,0 +1,147 @@
+package quirks
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sweeney/ups-mqtt/internal/plausibility"
+)
+
+// cyberpowerVars is an abridged CP1500EPFCLCD poll (usbhid-ups).
+var cyberpowerVars = map[string]string{
+	"device.mfr":            "CPS",
+	"device.model":          "CP1500EPFCLCD",
+	"ups.vendorid":          "0764",
+	"ups.status":            "OL",
+	"ups.load":              "8",
+	"ups.realpower":         "77",
+	"ups.realpower.nominal": "900",
+	"battery.mfr.date":      "CPS",
+	"input.voltage":         "242.0",
+}
+
+func TestProfile_Matches(t *testing.T) {
+	cases := []struct {
+		name string
+		p    Profile
+		want bool
+	}{
+		{"vendor id", Profile{VendorID: "0764"}, true},
+		{"model glob", Profile{Model: "CP1500*"}, true},
+		{"manufacturer and model", Profile{Manufacturer: "CPS", Model: "CP*"}, true},
+		{"one field mismatches", Profile{Manufacturer: "APC", Model: "CP*"}, false},
+		{"other vendor", Profile{VendorID: "051d"}, false},
+		{"no match fields", Profile{Drop: []string{"ups.load"}}, false},
+		{"bad glob", Profile{Model: "["}, false},
+	}
+	for _, c := range cases {
+		if got := c.p.Matches(cyberpowerVars); got != c.want {
+			t.Errorf("%s: Matches = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestProfile_Matches_UPSFallbackVariables(t *testing.T) {
+	vars := map[string]string{"ups.mfr": "American Power Conversion", "ups.model": "Back-UPS ES 700"}
+	if !(Profile{Manufacturer: "American*", Model: "Back-UPS*"}).Matches(vars) {
+		t.Error("ups.mfr/ups.model should be matched when device.* are absent")
+	}
+}
+
+func TestBuiltin_CyberPower(t *testing.T) {
+	s := Match(Builtin(), cyberpowerVars)
+	if got := s.Names(); !reflect.DeepEqual(got, []string{"cyberpower"}) {
+		t.Fatalf("matched %v, want [cyberpower]", got)
+	}
+
+	out := s.Apply(cyberpowerVars)
+	if _, ok := out["battery.mfr.date"]; ok {
+		t.Error("battery.mfr.date should be dropped")
+	}
+	if _, ok := cyberpowerVars["battery.mfr.date"]; !ok {
+		t.Error("Apply must not modify its input")
+	}
+
+	filtered, glitches := plausibility.Filter{Mode: plausibility.ModeDrop, Rules: s.Rules()}.Apply(
+		map[string]string{"ups.status": "OL", "input.voltage": "0"})
+	if _, ok := filtered["input.voltage"]; ok || len(glitches) != 1 {
+		t.Errorf("input.voltage=0 on mains should be rejected, got %v %v", filtered, glitches)
+	}
+
+	m := s.MetricsVars(out)
+	if m["ups.load"] != "8.555555555555555" {
+		t.Errorf("metrics ups.load = %q, want realpower/nominal", m["ups.load"])
+	}
+	if out["ups.load"] != "8" {
+		t.Errorf("published ups.load = %q, should stay as reported", out["ups.load"])
+	}
+}
+
+func TestMatch_None(t *testing.T) {
+	vars := map[string]string{"ups.vendorid": "051d", "ups.load": "8"}
+	s := Match(Builtin(), vars)
+	if len(s) != 0 {
+		t.Fatalf("matched %v, want none", s.Names())
+	}
+	if out := s.Apply(vars); !reflect.DeepEqual(out, vars) {
+		t.Errorf("Apply = %v, want unchanged", out)
+	}
+	if out := s.MetricsVars(vars); !reflect.DeepEqual(out, vars) {
+		t.Errorf("MetricsVars = %v, want unchanged", out)
+	}
+	if len(s.Rules()) != 0 {
+		t.Errorf("Rules = %v, want none", s.Rules())
+	}
+}
+
+func TestSet_Apply_Scale(t *testing.T) {
+	s := Set{{Name: "minutes", Scale: map[string]float64{"battery.runtime": 60, "battery.charge": 2}}}
+	out := s.Apply(map[string]string{"battery.runtime": "82", "battery.charge": "n/a"})
+	if out["battery.runtime"] != "4920" {
+		t.Errorf("battery.runtime = %q, want 4920", out["battery.runtime"])
+	}
+	if out["battery.charge"] != "n/a" {
+		t.Errorf("battery.charge = %q, unparseable values should be left alone", out["battery.charge"])
+	}
+	if _, ok := out["ups.load"]; ok {
+		t.Error("scaling must not invent missing variables")
+	}
+}
+
+func TestSet_MetricsVars_Defaults(t *testing.T) {
+	s := Set{
+		{Name: "a", Defaults: map[string]string{"ups.realpower.nominal": "600", "input.voltage.nominal": "230"}},
+		{Name: "b", Defaults: map[string]string{"ups.realpower.nominal": "900"}},
+	}
+	out := s.MetricsVars(map[string]string{"input.voltage.nominal": "120"})
+	if out["ups.realpower.nominal"] != "900" {
+		t.Errorf("ups.realpower.nominal = %q, later profile should win", out["ups.realpower.nominal"])
+	}
+	if out["input.voltage.nominal"] != "120" {
+		t.Errorf("input.voltage.nominal = %q, reported value should win", out["input.voltage.nominal"])
+	}
+}
+
+func TestSet_MetricsVars_LoadFromRealPower_Incomplete(t *testing.T) {
+	s := Set{{Name: "fine", LoadFromRealPower: true}}
+	for _, vars := range []map[string]string{
+		{"ups.load": "8", "ups.realpower.nominal": "900"},
+		{"ups.load": "8", "ups.realpower": "77"},
+		{"ups.load": "8", "ups.realpower": "77", "ups.realpower.nominal": "0"},
+	} {
+		if out := s.MetricsVars(vars); out["ups.load"] != "8" {
+			t.Errorf("MetricsVars(%v) ups.load = %q, want 8", vars, out["ups.load"])
+		}
+	}
+}
+
+func TestSet_Rules_LaterWins(t *testing.T) {
+	lo, hi := 100.0, 250.0
+	s := Set{
+		{Name: "a", Rules: map[string]plausibility.Rule{"input.voltage": {Min: &lo}}},
+		{Name: "b", Rules: map[string]plausibility.Rule{"input.voltage": {Max: &hi}}},
+	}
+	if r := s.Rules()["input.voltage"]; r.Min != nil || r.Max == nil || *r.Max != 250 {
+		t.Errorf("input.voltage rule = %+v, want b's", r)
+	}
+}
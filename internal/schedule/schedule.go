@@ -0,0 +1,84 @@
+// Package schedule parses daily time windows such as quiet hours and tests
+// whether an instant falls inside one, and daily times such as when the
+// summary report is due.  Windows are in local wall-clock
+// time and may wrap past midnight.  It also provides a ticker aligned to
+// wall-clock boundaries, for polls that line up across collectors, and the
+// time budget for calls made from inside a poll.
+package schedule
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CallTimeout bounds a call made from inside a poll — a plugin or hook run,
+// a webhook, an HTTP sink or a Grafana push — whose own timeout isn't set,
+// so that a hung peer can't stall the poll loop.
+const CallTimeout = 5 * time.Second
+
+// Window is a daily interval of minutes since midnight, [Start, End).  When
+// End < Start the window wraps past midnight; Start == End is empty.
+type Window struct {
+	Start int
+	End   int
+}
+
+// Parse parses "HH:MM-HH:MM", e.g. "22:00-07:00".
+func Parse(s string) (Window, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return Window{}, fmt.Errorf("invalid window %q: want HH:MM-HH:MM", s)
+	}
+	start, err := parseClock(from)
+	if err != nil {
+		return Window{}, fmt.Errorf("invalid window %q: %w", s, err)
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return Window{}, fmt.Errorf("invalid window %q: %w", s, err)
+	}
+	return Window{Start: start, End: end}, nil
+}
+
+// parseClock parses "HH:MM" into minutes since midnight.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("bad time %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Contains reports whether t, in its own location, falls inside w.
+func (w Window) Contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	if w.Start <= w.End {
+		return m >= w.Start && m < w.End
+	}
+	return m >= w.Start || m < w.End
+}
+
+// Daily is a time of day, in minutes since midnight, for something done
+// once a day.
+type Daily int
+
+// ParseDaily parses "HH:MM", e.g. "07:00".
+func ParseDaily(s string) (Daily, error) {
+	m, err := parseClock(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day: %w", err)
+	}
+	return Daily(m), nil
+}
+
+// Next returns the first instant strictly after t, in t's location, whose
+// wall-clock time is d.
+func (d Daily) Next(t time.Time) time.Time {
+	y, m, day := t.Date()
+	next := time.Date(y, m, day, int(d)/60, int(d)%60, 0, 0, t.Location())
+	if !next.After(t) {
+		next = time.Date(y, m, day+1, int(d)/60, int(d)%60, 0, 0, t.Location())
+	}
+	return next
+}
@@ -0,0 +1,87 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func at(hour, min int) time.Time {
+	return time.Date(2026, 3, 1, hour, min, 0, 0, time.UTC)
+}
+
+func TestParse(t *testing.T) {
+	w, err := Parse("22:00-07:30")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if w.Start != 22*60 || w.End != 7*60+30 {
+		t.Errorf("window = %+v", w)
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for _, s := range []string{"", "22:00", "22:00-", "25:00-07:00", "22:00-7pm"} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("Parse(%q): expected error", s)
+		}
+	}
+}
+
+func TestContains_SameDay(t *testing.T) {
+	w := Window{Start: 9 * 60, End: 17 * 60}
+	cases := map[time.Time]bool{
+		at(8, 59): false,
+		at(9, 0):  true,
+		at(12, 0): true,
+		at(17, 0): false,
+	}
+	for tm, want := range cases {
+		if got := w.Contains(tm); got != want {
+			t.Errorf("Contains(%s) = %v, want %v", tm.Format("15:04"), got, want)
+		}
+	}
+}
+
+func TestContains_WrapsMidnight(t *testing.T) {
+	w := Window{Start: 22 * 60, End: 7 * 60}
+	cases := map[time.Time]bool{
+		at(21, 59): false,
+		at(22, 0):  true,
+		at(0, 0):   true,
+		at(6, 59):  true,
+		at(7, 0):   false,
+		at(12, 0):  false,
+	}
+	for tm, want := range cases {
+		if got := w.Contains(tm); got != want {
+			t.Errorf("Contains(%s) = %v, want %v", tm.Format("15:04"), got, want)
+		}
+	}
+}
+
+func TestContains_Empty(t *testing.T) {
+	w := Window{Start: 60, End: 60}
+	if w.Contains(at(1, 0)) {
+		t.Error("Start == End should be empty")
+	}
+}
+
+func TestDaily_Next(t *testing.T) {
+	d, err := ParseDaily("07:30")
+	if err != nil {
+		t.Fatalf("ParseDaily: %v", err)
+	}
+	cases := map[time.Time]time.Time{
+		at(6, 0):  at(7, 30),
+		at(7, 30): at(7, 30).AddDate(0, 0, 1),
+		at(23, 0): at(7, 30).AddDate(0, 0, 1),
+	}
+	for from, want := range cases {
+		if got := d.Next(from); !got.Equal(want) {
+			t.Errorf("Next(%s) = %s, want %s", from.Format("15:04"), got, want)
+		}
+	}
+	if _, err := ParseDaily("7pm"); err == nil {
+		t.Error("ParseDaily(7pm): expected error")
+	}
+}
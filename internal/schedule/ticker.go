@@ -0,0 +1,53 @@
+package schedule
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/clock"
+)
+
+// NextBoundary returns the first wall-clock multiple of interval after t,
+// counted from midnight UTC — with a 30s interval, the next :00 or :30.
+func NextBoundary(t time.Time, interval time.Duration) time.Time {
+	return t.Truncate(interval).Add(interval)
+}
+
+// AlignedTicker is a time.Ticker whose ticks fall on wall-clock multiples
+// of its interval (see NextBoundary) rather than on multiples since it was
+// started, so collectors sharing an interval sample at the same instants.
+// Each tick is re-aligned, so clock adjustments don't accumulate.  Like
+// time.Ticker it drops ticks a slow receiver misses.
+type AlignedTicker struct {
+	C <-chan time.Time
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// NewAlignedTicker starts an AlignedTicker on clk; its first tick is at the
+// next boundary.
+func NewAlignedTicker(clk clock.Clock, interval time.Duration) *AlignedTicker {
+	c := make(chan time.Time, 1)
+	t := &AlignedTicker{C: c, stop: make(chan struct{})}
+	go func() {
+		for {
+			now := clk.Now()
+			select {
+			case now := <-clk.After(NextBoundary(now, interval).Sub(now)):
+				select {
+				case c <- now:
+				default:
+				}
+			case <-t.stop:
+				return
+			}
+		}
+	}()
+	return t
+}
+
+// Stop turns the ticker off.  It may be called more than once.
+func (t *AlignedTicker) Stop() {
+	t.once.Do(func() { close(t.stop) })
+}
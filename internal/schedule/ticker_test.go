@@ -0,0 +1,64 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sweeney/ups-mqtt/internal/clock"
+)
+
+func TestNextBoundary(t *testing.T) {
+	for _, tc := range []struct {
+		at       time.Time
+		interval time.Duration
+		want     time.Time
+	}{
+		{time.Date(2026, 3, 1, 12, 0, 7, 0, time.UTC), 30 * time.Second, time.Date(2026, 3, 1, 12, 0, 30, 0, time.UTC)},
+		{time.Date(2026, 3, 1, 12, 0, 30, 0, time.UTC), 30 * time.Second, time.Date(2026, 3, 1, 12, 1, 0, 0, time.UTC)},
+		{time.Date(2026, 3, 1, 12, 7, 0, 1, time.UTC), 5 * time.Minute, time.Date(2026, 3, 1, 12, 10, 0, 0, time.UTC)},
+		{time.Date(2026, 3, 1, 23, 59, 0, 0, time.UTC), time.Hour, time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)},
+	} {
+		if got := NextBoundary(tc.at, tc.interval); !got.Equal(tc.want) {
+			t.Errorf("NextBoundary(%s, %s) = %s, want %s", tc.at, tc.interval, got, tc.want)
+		}
+	}
+}
+
+func TestAlignedTicker(t *testing.T) {
+	const interval = 100 * time.Millisecond
+	tk := NewAlignedTicker(clock.Real, interval)
+	defer tk.Stop()
+	for i := 0; i < 2; i++ {
+		select {
+		case at := <-tk.C:
+			if off := at.Sub(at.Truncate(interval)); off > interval/2 {
+				t.Errorf("tick %d at %s is %s past the boundary", i, at, off)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no tick")
+		}
+	}
+	tk.Stop()
+	tk.Stop() // idempotent
+}
+
+func TestAlignedTicker_FakeClock(t *testing.T) {
+	const interval = 30 * time.Second
+	f := clock.NewFake(time.Date(2026, 3, 1, 12, 0, 7, 0, time.UTC))
+	tk := NewAlignedTicker(f, interval)
+	defer tk.Stop()
+	// The ticker waits on the clock from its own goroutine, so the clock
+	// is moved a second at a time until it has.
+	for i := 0; i < 2*int(interval/time.Second); i++ {
+		f.Advance(time.Second)
+		select {
+		case at := <-tk.C:
+			if !at.Equal(at.Truncate(interval)) {
+				t.Errorf("tick at %s, want it on a boundary", at)
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	t.Fatal("no tick")
+}
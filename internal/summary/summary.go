@@ -0,0 +1,156 @@
+// Package summary accumulates a period's polls, normally a day, into a
+// digest for users who don't watch dashboards: the input voltage range,
+// energy used, outages, time on battery and average load.  Like the
+// metrics and trend packages it is pure arithmetic over the readings fed
+// in; publishing and scheduling are left to the caller.
+package summary
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Reading is what one poll contributes.  The OK flags mark values the UPS
+// reported; LoadWatts is zero when it can't be worked out.
+type Reading struct {
+	Time           time.Time
+	OnBattery      bool
+	InputVoltage   float64
+	InputVoltageOK bool
+	LoadPct        float64
+	LoadPctOK      bool
+	LoadWatts      float64
+}
+
+// Period accumulates Readings until Report is called.  Energy and time on
+// battery are counted over the gap to the next reading, except for gaps
+// longer than MaxGap (when set), where the bridge was down or polls failed
+// and what happened is unknown.  The zero value is ready to use.
+type Period struct {
+	MaxGap time.Duration
+
+	start, last time.Time
+	lastOnBat   bool
+	lastWatts   float64
+	polls       int
+
+	vMin, vMax float64
+	haveV      bool
+	loadSum    float64
+	loadN      int
+	energyWh   float64
+	haveEnergy bool
+	outages    int
+	onBattery  time.Duration
+}
+
+// Add records r.  Readings must come in time order; one that doesn't move
+// time forward only updates the ranges and counts.
+func (p *Period) Add(r Reading) {
+	if p.polls == 0 {
+		p.start = r.Time
+	} else if dt := r.Time.Sub(p.last); dt > 0 && (p.MaxGap <= 0 || dt <= p.MaxGap) {
+		p.energyWh += p.lastWatts * dt.Hours()
+		if p.lastOnBat {
+			p.onBattery += dt
+		}
+	}
+	if r.OnBattery && (p.polls == 0 || !p.lastOnBat) {
+		p.outages++
+	}
+	if r.InputVoltageOK {
+		if !p.haveV || r.InputVoltage < p.vMin {
+			p.vMin = r.InputVoltage
+		}
+		if !p.haveV || r.InputVoltage > p.vMax {
+			p.vMax = r.InputVoltage
+		}
+		p.haveV = true
+	}
+	if r.LoadPctOK {
+		p.loadSum += r.LoadPct
+		p.loadN++
+	}
+	if r.LoadWatts > 0 {
+		p.haveEnergy = true
+	}
+	p.polls++
+	if r.Time.After(p.last) {
+		p.last = r.Time
+	}
+	p.lastOnBat, p.lastWatts = r.OnBattery, r.LoadWatts
+}
+
+// Report is the JSON payload of the summary topic.  Values the UPS never
+// reported during the period are omitted.
+type Report struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Polls int    `json:"polls"`
+
+	InputVoltageMin *float64 `json:"input_voltage_min,omitempty"`
+	InputVoltageMax *float64 `json:"input_voltage_max,omitempty"`
+	EnergyWh        *float64 `json:"energy_wh,omitempty"`
+	LoadAvgPct      *float64 `json:"load_avg_pct,omitempty"`
+
+	// Outages counts the times the UPS went on battery, including an
+	// outage already in progress when the period began.
+	Outages       int     `json:"outages"`
+	OnBatterySecs float64 `json:"on_battery_secs"`
+}
+
+// Report summarises the period from its first reading to end.
+func (p *Period) Report(end time.Time) Report {
+	from := p.start
+	if p.polls == 0 {
+		from = end
+	}
+	r := Report{
+		From:          from.UTC().Format(time.RFC3339),
+		To:            end.UTC().Format(time.RFC3339),
+		Polls:         p.polls,
+		Outages:       p.outages,
+		OnBatterySecs: math.Round(p.onBattery.Seconds()),
+	}
+	if p.haveV {
+		r.InputVoltageMin, r.InputVoltageMax = ptr(p.vMin), ptr(p.vMax)
+	}
+	if p.haveEnergy {
+		r.EnergyWh = ptr(p.energyWh)
+	}
+	if p.loadN > 0 {
+		r.LoadAvgPct = ptr(p.loadSum / float64(p.loadN))
+	}
+	return r
+}
+
+// Message is r in a sentence, for notifiers.
+func (r Report) Message() string {
+	var parts []string
+	if r.EnergyWh != nil {
+		parts = append(parts, fmt.Sprintf("%.2f kWh used", *r.EnergyWh/1000))
+	}
+	switch r.Outages {
+	case 0:
+		parts = append(parts, "no outages")
+	case 1:
+		parts = append(parts, fmt.Sprintf("1 outage (%s on battery)", time.Duration(r.OnBatterySecs)*time.Second))
+	default:
+		parts = append(parts, fmt.Sprintf("%d outages (%s on battery)", r.Outages, time.Duration(r.OnBatterySecs)*time.Second))
+	}
+	if r.LoadAvgPct != nil {
+		parts = append(parts, fmt.Sprintf("average load %g%%", *r.LoadAvgPct))
+	}
+	if r.InputVoltageMin != nil {
+		parts = append(parts, fmt.Sprintf("input %g–%g V", *r.InputVoltageMin, *r.InputVoltageMax))
+	}
+	return "Daily summary: " + strings.Join(parts, ", ")
+}
+
+// ptr rounds v to two decimals and returns a pointer to it.
+func ptr(v float64) *float64 {
+	v = math.Round(v*100) / 100
+	return &v
+}
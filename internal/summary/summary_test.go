@@ -0,0 +1,68 @@
+package summary
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+func reading(min int, onBattery bool, volts, load, watts float64) Reading {
+	return Reading{
+		Time:           t0.Add(time.Duration(min) * time.Minute),
+		OnBattery:      onBattery,
+		InputVoltage:   volts,
+		InputVoltageOK: !onBattery,
+		LoadPct:        load,
+		LoadPctOK:      true,
+		LoadWatts:      watts,
+	}
+}
+
+func TestPeriod_Report(t *testing.T) {
+	p := Period{MaxGap: 15 * time.Minute}
+	for _, r := range []Reading{
+		reading(0, false, 230, 20, 180),
+		reading(10, false, 241, 30, 270), // 10 min × 180 W = 30 Wh
+		reading(60, true, 0, 40, 360),    // 50 min gap: too long, not counted
+		reading(65, true, 0, 40, 360),    // 5 min × 360 W = 30 Wh, on battery
+		reading(70, false, 225, 30, 270), // 5 min × 360 W = 30 Wh, on battery
+		reading(75, true, 0, 40, 360),    // 5 min × 270 W = 22.5 Wh; second outage
+	} {
+		p.Add(r)
+	}
+	got := p.Report(t0.Add(24 * time.Hour))
+
+	if got.From != "2026-03-01T00:00:00Z" || got.To != "2026-03-02T00:00:00Z" || got.Polls != 6 {
+		t.Errorf("from %s to %s, %d polls", got.From, got.To, got.Polls)
+	}
+	if got.EnergyWh == nil || *got.EnergyWh != 112.5 {
+		t.Errorf("EnergyWh = %v, want 112.5", got.EnergyWh)
+	}
+	if got.Outages != 2 || got.OnBatterySecs != 600 {
+		t.Errorf("outages %d, on battery %vs; want 2 and 600s", got.Outages, got.OnBatterySecs)
+	}
+	if *got.InputVoltageMin != 225 || *got.InputVoltageMax != 241 || *got.LoadAvgPct != 33.33 {
+		t.Errorf("voltage %v–%v, load %v", *got.InputVoltageMin, *got.InputVoltageMax, *got.LoadAvgPct)
+	}
+	want := "Daily summary: 0.11 kWh used, 2 outages (10m0s on battery), average load 33.33%, input 225–241 V"
+	if msg := got.Message(); msg != want {
+		t.Errorf("Message() = %q, want %q", msg, want)
+	}
+}
+
+func TestPeriod_Empty(t *testing.T) {
+	var p Period
+	b, err := json.Marshal(p.Report(t0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"from":"2026-03-01T00:00:00Z","to":"2026-03-01T00:00:00Z","polls":0,"outages":0,"on_battery_secs":0}`
+	if string(b) != want {
+		t.Errorf("report = %s, want %s", b, want)
+	}
+	if msg := p.Report(t0).Message(); msg != "Daily summary: no outages" {
+		t.Errorf("Message() = %q", msg)
+	}
+}
@@ -0,0 +1,87 @@
+package trend
+
+import (
+	"strings"
+	"time"
+)
+
+// Charger states reported by ChargerState.
+const (
+	ChargerCharging    = "charging"
+	ChargerFloating    = "floating"
+	ChargerDischarging = "discharging"
+	ChargerResting     = "resting"
+)
+
+const (
+	// chargerRateDeadband is the smoothed charge rate, in %/min, below which
+	// the charge counts as steady.
+	chargerRateDeadband = 0.05
+
+	// chargerFull is the charge, in percent, from which a battery that is
+	// neither charging nor discharging on mains counts as floating.
+	chargerFull = 95.0
+)
+
+// ChargerState derives a single charger state from ups.status and the
+// charge trend, debounced so the flapping CHRG/DISCHRG tokens of some
+// drivers don't make it flap too.  It also fills in what many UPSes never
+// report: a steadily rising charge counts as charging without CHRG, and a
+// full battery held steady on mains as floating.
+//
+// A new state is only reported once it has been seen for Hold; going on
+// battery is the exception and is reported at once.
+type ChargerState struct {
+	Hold time.Duration
+
+	state     string
+	candidate string
+	since     time.Time
+}
+
+// Update classifies one poll and returns the debounced state.  charge and
+// rate (the smoothed charge rate, %/min) are used when their ok flags are
+// set.
+func (c *ChargerState) Update(status string, charge float64, chargeOK bool, rate float64, rateOK bool, now time.Time) string {
+	next := classifyCharger(strings.Fields(status), charge, chargeOK, rate, rateOK)
+	switch {
+	case c.state == "" || next == c.state:
+		c.state, c.candidate = next, ""
+	case next == ChargerDischarging && hasToken(strings.Fields(status), "OB"):
+		c.state, c.candidate = next, ""
+	case next != c.candidate:
+		c.candidate, c.since = next, now
+		if c.Hold <= 0 {
+			c.state, c.candidate = next, ""
+		}
+	case now.Sub(c.since) >= c.Hold:
+		c.state, c.candidate = next, ""
+	}
+	return c.state
+}
+
+// classifyCharger is the undebounced state of a single poll.
+func classifyCharger(tokens []string, charge float64, chargeOK bool, rate float64, rateOK bool) string {
+	switch {
+	case hasToken(tokens, "OB"), hasToken(tokens, "DISCHRG"):
+		return ChargerDischarging
+	case hasToken(tokens, "CHRG"):
+		return ChargerCharging
+	case rateOK && rate > chargerRateDeadband:
+		return ChargerCharging
+	case rateOK && rate < -chargerRateDeadband:
+		return ChargerDischarging
+	case chargeOK && charge >= chargerFull:
+		return ChargerFloating
+	}
+	return ChargerResting
+}
+
+func hasToken(tokens []string, want string) bool {
+	for _, t := range tokens {
+		if t == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package trend
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClassifyCharger(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		status   string
+		charge   float64
+		chargeOK bool
+		rate     float64
+		rateOK   bool
+		want     string
+	}{
+		{"on battery", "OB DISCHRG", 80, true, -1, true, ChargerDischarging},
+		{"DISCHRG on mains", "OL DISCHRG", 80, true, 0, true, ChargerDischarging},
+		{"CHRG", "OL CHRG", 60, true, 0, false, ChargerCharging},
+		{"rising without CHRG", "OL", 60, true, 0.3, true, ChargerCharging},
+		{"falling on mains", "OL", 60, true, -0.3, true, ChargerDischarging},
+		{"full and steady", "OL", 100, true, 0.01, true, ChargerFloating},
+		{"full, no rate yet", "OL", 100, true, 0, false, ChargerFloating},
+		{"partial and steady", "OL", 60, true, 0, true, ChargerResting},
+		{"nothing known", "OL", 0, false, 0, false, ChargerResting},
+	} {
+		if got := classifyCharger(strings.Fields(tc.status), tc.charge, tc.chargeOK, tc.rate, tc.rateOK); got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestChargerState_DebouncesFlapping(t *testing.T) {
+	c := &ChargerState{Hold: time.Minute}
+	step := 20 * time.Second
+	if got := c.Update("OL", 100, true, 0, true, t0); got != ChargerFloating {
+		t.Fatalf("first poll = %s, want floating", got)
+	}
+	// CHRG blinks on and off: never held for a minute, so still floating.
+	for i, status := range []string{"OL CHRG", "OL", "OL CHRG", "OL"} {
+		if got := c.Update(status, 100, true, 0, true, t0.Add(time.Duration(i+1)*step)); got != ChargerFloating {
+			t.Errorf("poll %d (%s) = %s, want floating", i+2, status, got)
+		}
+	}
+	// CHRG that stays for the hold time is reported.
+	start := t0.Add(5 * step)
+	for i := 0; i < 3; i++ {
+		c.Update("OL CHRG", 98, true, 0.2, true, start.Add(time.Duration(i)*step))
+	}
+	if got := c.Update("OL CHRG", 98, true, 0.2, true, start.Add(3*step)); got != ChargerCharging {
+		t.Errorf("after the hold = %s, want charging", got)
+	}
+}
+
+func TestChargerState_OnBatteryImmediate(t *testing.T) {
+	c := &ChargerState{Hold: 5 * time.Minute}
+	c.Update("OL", 100, true, 0, true, t0)
+	if got := c.Update("OB DISCHRG", 100, true, 0, true, t0.Add(time.Second)); got != ChargerDischarging {
+		t.Errorf("on battery = %s, want discharging at once", got)
+	}
+}
+
+func TestChargerState_NoHold(t *testing.T) {
+	c := &ChargerState{}
+	c.Update("OL", 100, true, 0, true, t0)
+	if got := c.Update("OL CHRG", 99, true, 0, true, t0.Add(time.Second)); got != ChargerCharging {
+		t.Errorf("got %s, want charging without a hold", got)
+	}
+}
@@ -0,0 +1,78 @@
+package trend
+
+import (
+	"time"
+)
+
+// Runtime estimates the time left on battery from how fast the charge is
+// actually falling, as an alternative to the firmware's battery.runtime,
+// which on many UPSes jumps with every load change.  The discharge rate is
+// the least-squares slope of the charge readings within the last Window,
+// and the estimate is how long the current charge lasts at that rate.  Feed
+// it only while on battery, and Reset it when mains return.  The zero value
+// is not usable; set Window first.
+type Runtime struct {
+	Window time.Duration
+
+	samples []runtimeSample
+}
+
+type runtimeSample struct {
+	t      time.Time
+	charge float64
+}
+
+// Add records charge (percent) observed at t and returns the estimated
+// minutes until it reaches 0.  ok is false until the readings in the window
+// show the charge falling, which for a UPS reporting whole percent takes
+// until the first step down.
+func (r *Runtime) Add(charge float64, t time.Time) (mins float64, ok bool) {
+	if n := len(r.samples); n > 0 && !t.After(r.samples[n-1].t) {
+		return r.estimate(r.samples[n-1].charge)
+	}
+	r.samples = append(r.samples, runtimeSample{t, charge})
+	cutoff := t.Add(-r.Window)
+	drop := 0
+	for drop < len(r.samples)-2 && r.samples[drop].t.Before(cutoff) {
+		drop++
+	}
+	r.samples = r.samples[drop:]
+	return r.estimate(charge)
+}
+
+// estimate divides charge by the current discharge rate.
+func (r *Runtime) estimate(charge float64) (float64, bool) {
+	slope, ok := r.slope()
+	if !ok || slope >= 0 {
+		return 0, false
+	}
+	return max(charge, 0) / -slope, true
+}
+
+// slope is the least-squares fit of charge against time over the samples,
+// in percent per minute.
+func (r *Runtime) slope() (float64, bool) {
+	if len(r.samples) < 2 {
+		return 0, false
+	}
+	t0 := r.samples[0].t
+	var n, sx, sy, sxx, sxy float64
+	for _, s := range r.samples {
+		x := s.t.Sub(t0).Minutes()
+		n++
+		sx += x
+		sy += s.charge
+		sxx += x * x
+		sxy += x * s.charge
+	}
+	den := n*sxx - sx*sx
+	if den == 0 {
+		return 0, false
+	}
+	return (n*sxy - sx*sy) / den, true
+}
+
+// Reset forgets all samples, e.g. when mains return.
+func (r *Runtime) Reset() {
+	*r = Runtime{Window: r.Window}
+}
@@ -0,0 +1,87 @@
+package trend
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRuntime_SteadyDischarge(t *testing.T) {
+	r := &Runtime{Window: 10 * time.Minute}
+	if _, ok := r.Add(100, t0); ok {
+		t.Error("one sample should not yield an estimate")
+	}
+	var got float64
+	for i := 1; i <= 20; i++ {
+		got, _ = r.Add(100-0.5*float64(i), t0.Add(time.Duration(i)*time.Minute))
+	}
+	// 90 % left at 0.5 %/min.
+	if math.Abs(got-180) > 1e-6 {
+		t.Errorf("estimate = %v min, want 180", got)
+	}
+}
+
+func TestRuntime_NeedsFallingCharge(t *testing.T) {
+	r := &Runtime{Window: 10 * time.Minute}
+	r.Add(100, t0)
+	if _, ok := r.Add(100, t0.Add(time.Minute)); ok {
+		t.Error("a flat charge should not yield an estimate")
+	}
+	if _, ok := r.Add(101, t0.Add(2*time.Minute)); ok {
+		t.Error("a rising charge should not yield an estimate")
+	}
+}
+
+func TestRuntime_QuantisedSteps(t *testing.T) {
+	// Whole-percent charge falling 1 % every 2 minutes, polled every 30 s.
+	r := &Runtime{Window: 10 * time.Minute}
+	var got float64
+	var ok bool
+	for i := 0; i <= 80; i++ {
+		charge := 100 - float64(i/4)
+		got, ok = r.Add(charge, t0.Add(time.Duration(i)*30*time.Second))
+	}
+	// 80 % left at 0.5 %/min ≈ 160 min; a poll-to-poll rate would read 0 or 2.
+	if !ok || got < 140 || got > 180 {
+		t.Errorf("estimate = %v, %v; want ≈160 min", got, ok)
+	}
+}
+
+func TestRuntime_WindowFollowsLoadChange(t *testing.T) {
+	r := &Runtime{Window: 5 * time.Minute}
+	charge := 100.0
+	for i := 0; i <= 30; i++ { // 0.2 %/min
+		r.Add(charge, t0.Add(time.Duration(i)*time.Minute))
+		charge -= 0.2
+	}
+	var got float64
+	for i := 31; i <= 40; i++ { // load went up: 1 %/min
+		charge--
+		got, _ = r.Add(charge, t0.Add(time.Duration(i)*time.Minute))
+	}
+	if want := charge / 1; math.Abs(got-want) > 1e-6 {
+		t.Errorf("estimate = %v min, want %v once the window holds only the new rate", got, want)
+	}
+}
+
+func TestRuntime_IgnoresNonIncreasingTime(t *testing.T) {
+	r := &Runtime{Window: 10 * time.Minute}
+	r.Add(100, t0)
+	want, _ := r.Add(99, t0.Add(time.Minute))
+	if got, ok := r.Add(10, t0.Add(time.Minute)); !ok || got != want {
+		t.Errorf("estimate = %v, %v; want unchanged %v", got, ok, want)
+	}
+}
+
+func TestRuntime_Reset(t *testing.T) {
+	r := &Runtime{Window: 10 * time.Minute}
+	r.Add(100, t0)
+	r.Add(99, t0.Add(time.Minute))
+	r.Reset()
+	if r.Window != 10*time.Minute {
+		t.Error("Reset should keep Window")
+	}
+	if _, ok := r.Add(98, t0.Add(2*time.Minute)); ok {
+		t.Error("Reset should forget earlier samples")
+	}
+}
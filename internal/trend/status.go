@@ -0,0 +1,36 @@
+package trend
+
+import "strings"
+
+// StatusDebounce holds back a change of ups.status until the new status has
+// been seen on Polls polls in a row, so a UPS flipping between OL and OB
+// through a brownout doesn't make everything derived from the status flip
+// too.  A status carrying LB or FSD is taken at once, since the UPS may be
+// off before another poll; so is the first status seen.  Polls of 1 or
+// less takes every status at once.
+type StatusDebounce struct {
+	Polls int
+
+	status    string
+	candidate string
+	seen      int
+}
+
+// Update records the status of one poll and returns the debounced status.
+func (d *StatusDebounce) Update(status string) string {
+	tokens := strings.Fields(status)
+	switch {
+	case status == d.status:
+		d.candidate, d.seen = "", 0
+	case d.status == "", d.Polls <= 1, hasToken(tokens, "LB"), hasToken(tokens, "FSD"):
+		d.status, d.candidate, d.seen = status, "", 0
+	case status != d.candidate:
+		d.candidate, d.seen = status, 1
+	default:
+		d.seen++
+	}
+	if d.candidate != "" && d.seen >= d.Polls {
+		d.status, d.candidate, d.seen = d.candidate, "", 0
+	}
+	return d.status
+}
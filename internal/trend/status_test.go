@@ -0,0 +1,37 @@
+package trend
+
+import "testing"
+
+func TestStatusDebounce(t *testing.T) {
+	d := StatusDebounce{Polls: 3}
+	for i, step := range []struct{ status, want string }{
+		{"OL", "OL"}, // the first status is taken at once
+		{"OB", "OL"},
+		{"OL", "OL"}, // a flap resets the count
+		{"OB", "OL"},
+		{"OB", "OL"},
+		{"OB", "OB"}, // third poll in a row
+		{"OL", "OB"},
+		{"OL CHRG", "OB"}, // a different status starts over
+		{"OL CHRG", "OB"},
+		{"OL CHRG", "OL CHRG"},
+		{"OB LB", "OB LB"}, // low battery can't wait
+		{"OL", "OB LB"},
+		{"FSD OB LB", "FSD OB LB"},
+	} {
+		if got := d.Update(step.status); got != step.want {
+			t.Fatalf("poll %d (%q): Update = %q, want %q", i, step.status, got, step.want)
+		}
+	}
+}
+
+func TestStatusDebounce_Off(t *testing.T) {
+	for _, polls := range []int{0, 1} {
+		d := StatusDebounce{Polls: polls}
+		for _, status := range []string{"OL", "OB", "OL"} {
+			if got := d.Update(status); got != status {
+				t.Errorf("Polls %d: Update(%q) = %q", polls, status, got)
+			}
+		}
+	}
+}
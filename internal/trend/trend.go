@@ -0,0 +1,83 @@
+// Package trend derives smoothed rates of change and moving averages from
+// successive readings, a debounced battery charger state and a runtime
+// estimate from them, and debounces ups.status.  Values are pure arithmetic
+// over the samples fed in; the only state is the previous sample, the
+// running averages, the readings within the runtime window and the pending
+// state changes.
+package trend
+
+import (
+	"math"
+	"time"
+)
+
+// Rate estimates the derivative of a reading, in units per minute, smoothed
+// with an exponentially weighted moving average whose time constant is
+// Window.  Irregular sampling is handled by weighting each step by its
+// elapsed time.  The zero value is not usable; set Window first.
+type Rate struct {
+	Window time.Duration
+
+	lastValue float64
+	lastTime  time.Time
+	haveLast  bool
+
+	rate     float64
+	haveRate bool
+}
+
+// Add records value observed at t and returns the smoothed rate.  ok is
+// false until two samples with increasing timestamps have been seen.
+func (r *Rate) Add(value float64, t time.Time) (rate float64, ok bool) {
+	if !r.haveLast {
+		r.lastValue, r.lastTime, r.haveLast = value, t, true
+		return 0, false
+	}
+	dt := t.Sub(r.lastTime)
+	if dt <= 0 {
+		return r.rate, r.haveRate
+	}
+	step := (value - r.lastValue) / dt.Minutes()
+	r.lastValue, r.lastTime = value, t
+
+	if !r.haveRate {
+		r.rate, r.haveRate = step, true
+		return r.rate, true
+	}
+	alpha := 1 - math.Exp(-float64(dt)/float64(r.Window))
+	r.rate += alpha * (step - r.rate)
+	return r.rate, true
+}
+
+// Reset forgets all samples, e.g. after a long gap in readings.
+func (r *Rate) Reset() {
+	*r = Rate{Window: r.Window}
+}
+
+// Average is an exponentially weighted moving average of a reading whose
+// time constant is Window, each step weighted by its elapsed time as in
+// Rate.  The zero value is not usable; set Window first.
+type Average struct {
+	Window time.Duration
+
+	value    float64
+	lastTime time.Time
+	have     bool
+}
+
+// Add records value observed at t and returns the average, which starts
+// at the first value.
+func (a *Average) Add(value float64, t time.Time) float64 {
+	if !a.have {
+		a.value, a.lastTime, a.have = value, t, true
+		return a.value
+	}
+	dt := t.Sub(a.lastTime)
+	if dt <= 0 {
+		return a.value
+	}
+	a.lastTime = t
+	alpha := 1 - math.Exp(-float64(dt)/float64(a.Window))
+	a.value += alpha * (value - a.value)
+	return a.value
+}
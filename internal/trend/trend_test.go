@@ -0,0 +1,103 @@
+package trend
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func TestRate_NeedsTwoSamples(t *testing.T) {
+	r := &Rate{Window: 5 * time.Minute}
+	if _, ok := r.Add(100, t0); ok {
+		t.Error("one sample should not yield a rate")
+	}
+	got, ok := r.Add(99, t0.Add(30*time.Second))
+	if !ok {
+		t.Fatal("two samples should yield a rate")
+	}
+	if got != -2 {
+		t.Errorf("rate = %v, want -2 %%/min", got)
+	}
+}
+
+func TestRate_SteadyDischargeConverges(t *testing.T) {
+	r := &Rate{Window: 5 * time.Minute}
+	var got float64
+	for i := 0; i <= 60; i++ {
+		got, _ = r.Add(100-0.5*float64(i), t0.Add(time.Duration(i)*time.Minute))
+	}
+	if math.Abs(got-(-0.5)) > 1e-9 {
+		t.Errorf("rate = %v, want -0.5", got)
+	}
+}
+
+func TestRate_SmoothsQuantisedSteps(t *testing.T) {
+	// Charge reported in whole percent: it steps by 1 every 4th poll.
+	r := &Rate{Window: 5 * time.Minute}
+	charge := 50.0
+	var got float64
+	for i := 0; i < 200; i++ {
+		if i%4 == 0 && i > 0 {
+			charge++
+		}
+		got, _ = r.Add(charge, t0.Add(time.Duration(i)*15*time.Second))
+	}
+	// True rate is 1 % per minute; a single step would read 4 or 0.
+	if got < 0.7 || got > 1.3 {
+		t.Errorf("smoothed rate = %v, want ≈1", got)
+	}
+}
+
+func TestRate_IgnoresNonIncreasingTime(t *testing.T) {
+	r := &Rate{Window: time.Minute}
+	r.Add(10, t0)
+	r.Add(11, t0.Add(time.Minute))
+	got, ok := r.Add(50, t0.Add(time.Minute))
+	if !ok || got != 1 {
+		t.Errorf("rate = %v, %v; want unchanged 1", got, ok)
+	}
+}
+
+func TestRate_Reset(t *testing.T) {
+	r := &Rate{Window: time.Minute}
+	r.Add(10, t0)
+	r.Add(11, t0.Add(time.Minute))
+	r.Reset()
+	if r.Window != time.Minute {
+		t.Error("Reset should keep Window")
+	}
+	if _, ok := r.Add(12, t0.Add(2*time.Minute)); ok {
+		t.Error("rate should be unknown after Reset")
+	}
+}
+
+func TestAverage(t *testing.T) {
+	a := &Average{Window: 5 * time.Minute}
+	if got := a.Add(60, t0); got != 60 {
+		t.Errorf("first Add = %v, want the value itself", got)
+	}
+	// One time constant later the average has moved 1 − 1/e of the way.
+	want := 60 + (1-math.Exp(-1))*(20-60)
+	if got := a.Add(20, t0.Add(5*time.Minute)); math.Abs(got-want) > 1e-9 {
+		t.Errorf("Add = %v, want %v", got, want)
+	}
+	if got := a.Add(0, t0.Add(5*time.Minute)); math.Abs(got-want) > 1e-9 {
+		t.Errorf("Add at the same time = %v, want it unchanged at %v", got, want)
+	}
+
+	// Oscillating readings settle near their mean.
+	a = &Average{Window: 5 * time.Minute}
+	var got float64
+	for i := 0; i < 240; i++ {
+		v := 40.0
+		if i%2 == 1 {
+			v = 80
+		}
+		got = a.Add(v, t0.Add(time.Duration(i)*30*time.Second))
+	}
+	if math.Abs(got-60) > 3 {
+		t.Errorf("average of 40/80 oscillation = %v, want about 60", got)
+	}
+}
@@ -0,0 +1,99 @@
+// Package update finds out whether a newer release of the bridge is out.
+// It only reads a release feed; nothing is downloaded or installed.
+package update
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultURL is the GitHub API endpoint for the latest release.
+const DefaultURL = "https://api.github.com/repos/sweeney/ups-mqtt/releases/latest"
+
+// fetchTimeout bounds a single request to the feed.
+const fetchTimeout = 10 * time.Second
+
+// Latest returns the version of the latest release from the feed at url,
+// which answers like GitHub's "latest release" API: a JSON object whose
+// tag_name is the version.  userAgent identifies the bridge to the feed.
+func Latest(ctx context.Context, client *http.Client, url, userAgent string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("building release feed request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("fetching %s: %w", url, err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("release feed returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var release struct {
+		TagName string `json:"tag_name"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&release); err != nil {
+		return "", fmt.Errorf("parsing release feed: %w", err)
+	}
+	if release.TagName == "" {
+		return "", errors.New("release feed has no tag_name")
+	}
+	return release.TagName, nil
+}
+
+// Newer reports whether latest is a later version than current.  Both are
+// compared as MAJOR.MINOR.PATCH with an optional leading "v"; anything
+// after "-" or "+" is ignored.  A current version that isn't one, such as
+// "devel" for a build from source, is never outdated.
+func Newer(latest, current string) bool {
+	l, ok := parse(latest)
+	if !ok {
+		return false
+	}
+	c, ok := parse(current)
+	if !ok {
+		return false
+	}
+	for i := range l {
+		if l[i] != c[i] {
+			return l[i] > c[i]
+		}
+	}
+	return false
+}
+
+// parse splits v into its major, minor and patch numbers.
+func parse(v string) ([3]int, bool) {
+	var out [3]int
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	parts := strings.Split(v, ".")
+	if len(parts) != 3 {
+		return out, false
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return out, false
+		}
+		out[i] = n
+	}
+	return out, true
+}
@@ -0,0 +1,60 @@
+package update
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLatest(t *testing.T) {
+	var agent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agent = r.Header.Get("User-Agent")
+		w.Write([]byte(`{"tag_name":"v1.4.0","name":"1.4.0","draft":false}`)) //nolint:errcheck
+	}))
+	defer srv.Close()
+
+	got, err := Latest(context.Background(), srv.Client(), srv.URL, "ups-mqtt/v1.3.2")
+	if err != nil || got != "v1.4.0" {
+		t.Errorf("Latest = %q, %v; want v1.4.0", got, err)
+	}
+	if agent != "ups-mqtt/v1.3.2" {
+		t.Errorf("User-Agent = %q", agent)
+	}
+}
+
+func TestLatest_Errors(t *testing.T) {
+	for name, h := range map[string]http.HandlerFunc{
+		"status":   func(w http.ResponseWriter, r *http.Request) { http.Error(w, "rate limited", http.StatusForbidden) },
+		"not json": func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("<html>")) },       //nolint:errcheck
+		"no tag":   func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{"name":"x"}`)) }, //nolint:errcheck
+	} {
+		srv := httptest.NewServer(h)
+		if got, err := Latest(context.Background(), srv.Client(), srv.URL, "ups-mqtt"); err == nil {
+			t.Errorf("%s: Latest = %q, want an error", name, got)
+		}
+		srv.Close()
+	}
+}
+
+func TestNewer(t *testing.T) {
+	cases := []struct {
+		latest, current string
+		want            bool
+	}{
+		{"v1.4.0", "v1.3.2", true},
+		{"v1.4.0", "1.4.0", false},
+		{"v1.10.0", "v1.9.9", true},
+		{"v2.0.0", "v1.99.0", true},
+		{"v1.3.0", "v1.4.0", false},
+		{"v1.4.1", "v1.4.0-3-gabc123", true},
+		{"v1.4.0", "devel", false},
+		{"nightly", "v1.4.0", false},
+	}
+	for _, c := range cases {
+		if got := Newer(c.latest, c.current); got != c.want {
+			t.Errorf("Newer(%q, %q) = %v, want %v", c.latest, c.current, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+// Package wol builds and sends Wake-on-LAN magic packets, used to bring
+// hosts back up once mains power has returned after an outage.
+package wol
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+)
+
+// DefaultAddr is the broadcast address magic packets are sent to when none
+// is configured.  Port 9 (discard) is the conventional Wake-on-LAN port.
+const DefaultAddr = "255.255.255.255:9"
+
+// MagicPacket returns the magic packet for the 48-bit MAC address mac, in
+// any form net.ParseMAC accepts: six 0xFF bytes followed by the address
+// repeated sixteen times.
+func MagicPacket(mac string) ([]byte, error) {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return nil, err
+	}
+	if len(hw) != 6 {
+		return nil, fmt.Errorf("invalid MAC address %q: Wake-on-LAN needs a 48-bit address", mac)
+	}
+	return append(bytes.Repeat([]byte{0xff}, 6), bytes.Repeat(hw, 16)...), nil
+}
+
+// Send sends the magic packet for mac as a UDP datagram to addr
+// (host:port, usually a broadcast address).
+func Send(addr, mac string) error {
+	packet, err := MagicPacket(mac)
+	if err != nil {
+		return err
+	}
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return fmt.Errorf("dialling %s: %w", addr, err)
+	}
+	defer conn.Close() //nolint:errcheck
+	if _, err := conn.Write(packet); err != nil {
+		return fmt.Errorf("sending to %s: %w", addr, err)
+	}
+	return nil
+}
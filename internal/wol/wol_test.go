@@ -0,0 +1,66 @@
+package wol
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMagicPacket(t *testing.T) {
+	p, err := MagicPacket("00:11:22:aa:bb:cc")
+	if err != nil {
+		t.Fatalf("MagicPacket: %v", err)
+	}
+	if len(p) != 102 {
+		t.Fatalf("len = %d, want 102", len(p))
+	}
+	if !bytes.Equal(p[:6], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("header = % x", p[:6])
+	}
+	mac := []byte{0x00, 0x11, 0x22, 0xaa, 0xbb, 0xcc}
+	for i := 0; i < 16; i++ {
+		if got := p[6+6*i : 12+6*i]; !bytes.Equal(got, mac) {
+			t.Fatalf("repetition %d = % x", i, got)
+		}
+	}
+}
+
+func TestMagicPacket_Invalid(t *testing.T) {
+	for _, mac := range []string{"", "not-a-mac", "00:00:5e:00:53:00:00:01"} {
+		if _, err := MagicPacket(mac); err == nil {
+			t.Errorf("MagicPacket(%q): expected error", mac)
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer conn.Close() //nolint:errcheck
+
+	if err := Send(conn.LocalAddr().String(), "00-11-22-AA-BB-CC"); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second)) //nolint:errcheck
+	buf := make([]byte, 200)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("reading packet: %v", err)
+	}
+	want, _ := MagicPacket("00:11:22:aa:bb:cc")
+	if !bytes.Equal(buf[:n], want) {
+		t.Errorf("received % x", buf[:n])
+	}
+}
+
+func TestSend_Errors(t *testing.T) {
+	if err := Send("127.0.0.1:9", "bogus"); err == nil {
+		t.Error("expected error for an invalid MAC")
+	}
+	if err := Send("no-port", "00:11:22:aa:bb:cc"); err == nil {
+		t.Error("expected error for an invalid address")
+	}
+}